@@ -0,0 +1,139 @@
+// Package apierror is the body every API handler answers a failed call with, client or server error alike: a JSON
+// object with a stable code apps show a localized message for, a message for developers, the field of the call at
+// fault if there is one, and the ID of the call, to quote to support and find its logs by.
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/metrics"
+)
+
+// ContentType is the content type of error bodies
+const ContentType = "application/json"
+
+// Body is the error a failed call is answered with, eg
+// {"code": "not_found", "message": "request not found", "request_id": "c6af9ac6-7b61-11e6-9a41-93e8deadbeef"}
+type Body struct {
+	Code      string   `json:"code"`
+	Message   string   `json:"message"`
+	Field     string   `json:"field,omitempty"`
+	RequestID string   `json:"request_id"`
+	Details   []Detail `json:"details,omitempty"` // Every field at fault, when there are several
+}
+
+// Detail is what is wrong with one field of a call
+type Detail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Field   string `json:"field"`
+}
+
+// Coded is implemented by errors with a code more precise than that of their status
+type Coded interface {
+	ErrorCode() string
+}
+
+// Fielded is implemented by errors about a field of the call, named as it is sent
+type Fielded interface {
+	ErrorField() string
+}
+
+// Detailed is implemented by errors about several fields of the call
+type Detailed interface {
+	ErrorDetails() []Detail
+}
+
+// codes are the codes of errors without one of their own, by status
+var codes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusGone:                  "gone",
+	http.StatusPreconditionFailed:    "precondition_failed",
+	http.StatusRequestEntityTooLarge: "too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusUnprocessableEntity:   "unprocessable",
+	http.StatusTooManyRequests:       "too_many_requests",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusNotImplemented:        "not_implemented",
+	http.StatusBadGateway:            "bad_gateway",
+	http.StatusServiceUnavailable:    "unavailable",
+	http.StatusGatewayTimeout:        "gateway_timeout",
+}
+
+// Code returns the code of errors answered with a status, for errors without one of their own
+func Code(statusCode int) string {
+	if code, ok := codes[statusCode]; ok {
+		return code
+	}
+	if statusCode >= 500 {
+		return "internal_error"
+	}
+	return "bad_request"
+}
+
+var (
+	requestMu sync.Mutex
+	requestID string
+)
+
+// Handler wraps the router of an API handler so errors name the call they answer
+func Handler(h metrics.APIHandler) metrics.APIHandler {
+	return func(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		SetRequestID(req.RequestContext.RequestID)
+		defer SetRequestID("")
+		return h(req)
+	}
+}
+
+// SetRequestID names the call being answered, for handlers of calls other than API Gateway proxy requests, eg
+// WebSocket routes.  It lasts until it is set again.
+func SetRequestID(id string) {
+	requestMu.Lock()
+	defer requestMu.Unlock()
+	requestID = id
+}
+
+func currentRequestID() string {
+	requestMu.Lock()
+	defer requestMu.Unlock()
+	return requestID
+}
+
+// New returns the body of an error answered with a status
+func New(statusCode int, err error) Body {
+	body := Body{Code: Code(statusCode), Message: err.Error(), RequestID: currentRequestID()}
+	if coded, ok := err.(Coded); ok {
+		body.Code = coded.ErrorCode()
+	}
+	if fielded, ok := err.(Fielded); ok {
+		body.Field = fielded.ErrorField()
+	}
+	if detailed, ok := err.(Detailed); ok {
+		body.Details = detailed.ErrorDetails()
+		if body.Field == "" && len(body.Details) > 0 {
+			body.Field = body.Details[0].Field
+		}
+	}
+	return body
+}
+
+// Response returns the response answering a call with an error
+func Response(statusCode int, err error) events.APIGatewayProxyResponse {
+	body, marshalErr := json.Marshal(New(statusCode, err))
+	if marshalErr != nil {
+		body = []byte(`{"code":"internal_error","message":"error marshalling error"}`)
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers:    map[string]string{"content-type": ContentType, "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}
+}
@@ -0,0 +1,61 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// fieldErr is an error about a field, with a code of its own
+type fieldErr struct{}
+
+func (fieldErr) Error() string      { return "zoom must be a map zoom level" }
+func (fieldErr) ErrorCode() string  { return "invalid_zoom" }
+func (fieldErr) ErrorField() string { return "zoom" }
+
+func TestResponse(t *testing.T) {
+	resp := Response(http.StatusNotFound, errors.New("request not found"))
+	if resp.StatusCode != http.StatusNotFound || resp.Headers["content-type"] != ContentType {
+		t.Errorf("Response() = %+v, want a 404 of JSON", resp)
+	}
+	body := Body{}
+	if err := json.Unmarshal([]byte(resp.Body), &body); err != nil {
+		t.Fatalf("Response() body = %s, %v", resp.Body, err)
+	}
+	if want := (Body{Code: "not_found", Message: "request not found"}); body.Code != want.Code || body.Message != want.Message || body.Field != "" {
+		t.Errorf("Response() body = %+v, want %+v", body, want)
+	}
+
+	// Errors may name their own code and field
+	if body := New(http.StatusBadRequest, fieldErr{}); body.Code != "invalid_zoom" || body.Field != "zoom" {
+		t.Errorf("New() of an error with a code and field = %+v", body)
+	}
+
+	if code := Code(http.StatusTeapot); code != "bad_request" {
+		t.Errorf("Code(418) = %s, want bad_request", code)
+	}
+	if code := Code(599); code != "internal_error" {
+		t.Errorf("Code(599) = %s, want internal_error", code)
+	}
+}
+
+// Errors answering a call carry its ID, and only while it is answered
+func TestHandler(t *testing.T) {
+	h := Handler(func(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		return Response(http.StatusInternalServerError, errors.New("table unavailable")), nil
+	})
+	req := events.APIGatewayProxyRequest{}
+	req.RequestContext.RequestID = "c6af9ac6-7b61-11e6-9a41-93e8deadbeef"
+	resp, _ := h(req)
+
+	body := Body{}
+	if err := json.Unmarshal([]byte(resp.Body), &body); err != nil || body.RequestID != req.RequestContext.RequestID || body.Code != "internal_error" {
+		t.Errorf("Handler() body = %s, %v, want the request ID", resp.Body, err)
+	}
+	if body := New(http.StatusBadRequest, errors.New("bad")); body.RequestID != "" {
+		t.Errorf("New() after the call = %+v, want no request ID", body)
+	}
+}
@@ -0,0 +1,55 @@
+// Package auth tells handlers who is making a call, from the claims of the Cognito token API Gateway verified:
+// the groups the caller is in and, for staff, the city they work for.
+package auth
+
+import (
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Cognito groups of staff
+const (
+	CityAdminGroup     = "city_admin"     // Staff managing their city's requests, services and integrations
+	PlatformAdminGroup = "platform_admin" // The platform team, onboarding and managing cities
+)
+
+// Claim returns a claim of the caller's Cognito token, or "" if absent
+func Claim(req events.APIGatewayProxyRequest, name string) string {
+	claims, ok := req.RequestContext.Authorizer["claims"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	value, _ := claims[name].(string)
+	return value
+}
+
+// Groups returns the Cognito groups the caller's token places them in
+func Groups(req events.APIGatewayProxyRequest) []string {
+	// API Gateway flattens the groups claim into a string such as "[residents city_admin]"
+	separator := func(r rune) bool { return r == '[' || r == ']' || r == ',' || r == ' ' }
+	return strings.FieldsFunc(Claim(req, "cognito:groups"), separator)
+}
+
+// InGroup reports whether the caller's token places them in a Cognito group
+func InGroup(req events.APIGatewayProxyRequest, group string) bool {
+	for _, g := range Groups(req) {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// StaffCity returns the city the caller works for, from the custom:city claim of their token, or "" if it names none
+func StaffCity(req events.APIGatewayProxyRequest) string {
+	return Claim(req, "custom:city")
+}
+
+// IsAdminOf reports whether the caller is a city admin, and, when their token names a city, that it is this one
+func IsAdminOf(city string, req events.APIGatewayProxyRequest) bool {
+	if staffCity := StaffCity(req); staffCity != "" && staffCity != city {
+		return false
+	}
+	return InGroup(req, CityAdminGroup)
+}
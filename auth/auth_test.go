@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func caller(claims map[string]interface{}) events.APIGatewayProxyRequest {
+	req := events.APIGatewayProxyRequest{}
+	req.RequestContext.Authorizer = map[string]interface{}{"claims": claims}
+	return req
+}
+
+func TestInGroup(t *testing.T) {
+	tests := []struct {
+		groups interface{}
+		admin  bool
+	}{
+		{"city_admin", true},
+		{"[residents city_admin]", true},
+		{"[residents,city_admin]", true},
+		{"residents", false},
+		{"[city_administrators]", false},
+		{nil, false},
+	}
+
+	for _, tt := range tests {
+		req := caller(map[string]interface{}{"cognito:groups": tt.groups})
+		if got := InGroup(req, CityAdminGroup); got != tt.admin {
+			t.Errorf("InGroup(%v, city_admin) = %v, want %v", tt.groups, got, tt.admin)
+		}
+	}
+	if InGroup(events.APIGatewayProxyRequest{}, CityAdminGroup) {
+		t.Error("InGroup() of a call without claims = true, want false")
+	}
+}
+
+func TestIsAdminOf(t *testing.T) {
+	tests := []struct {
+		claims map[string]interface{}
+		city   string
+		admin  bool
+	}{
+		{map[string]interface{}{"cognito:groups": "[city_admin]", "custom:city": "Troy"}, "Troy", true},
+		{map[string]interface{}{"cognito:groups": "[city_admin]", "custom:city": "Troy"}, "Albany", false},
+		{map[string]interface{}{"cognito:groups": "[city_admin]"}, "Albany", true},
+		{map[string]interface{}{"cognito:groups": "[residents]", "custom:city": "Troy"}, "Troy", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsAdminOf(tt.city, caller(tt.claims)); got != tt.admin {
+			t.Errorf("IsAdminOf(%s, %v) = %v, want %v", tt.city, tt.claims, got, tt.admin)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// Package awsclient shares AWS sessions across the invocations of a warm Lambda.  Creating a session loads
+// configuration and credentials, which added tens of milliseconds to every call, and to every cold start many times
+// over, when handlers made a session for each client.  Sessions are created on first use rather than at init, so
+// handlers and tests that never call AWS don't need credentials.  Service clients are cheap to make from a shared
+// session; see the benchmarks.
+package awsclient
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+var (
+	mu       sync.Mutex
+	sessions = map[string]*session.Session{}
+)
+
+// Session returns the shared session of the deployment's region
+func Session() *session.Session {
+	return SessionIn("")
+}
+
+// SessionIn returns the shared session of a region, eg the region of a city pinned to one.  An empty region is the
+// deployment's region.
+func SessionIn(region string) *session.Session {
+	mu.Lock()
+	defer mu.Unlock()
+
+	sess, ok := sessions[region]
+	if !ok {
+		config := aws.NewConfig()
+		if region != "" {
+			config = config.WithRegion(region)
+		}
+		// Like session.New, which handlers used before, a session that can't be configured fails its calls
+		// rather than the handler
+		sess = session.New(config)
+		sessions[region] = sess
+	}
+	return sess
+}
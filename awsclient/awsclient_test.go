@@ -0,0 +1,40 @@
+package awsclient
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestSessionIn(t *testing.T) {
+	if SessionIn("eu-west-1") != SessionIn("eu-west-1") {
+		t.Error("SessionIn() should return the same session for a region")
+	}
+	if SessionIn("eu-west-1") == SessionIn("us-east-1") {
+		t.Error("SessionIn() should return a session per region")
+	}
+	if got := aws.StringValue(SessionIn("eu-west-1").Config.Region); got != "eu-west-1" {
+		t.Errorf("SessionIn() region = %s, want eu-west-1", got)
+	}
+	if Session() != SessionIn("") {
+		t.Error("Session() should be the session of the deployment's region")
+	}
+}
+
+// BenchmarkNewSession is what every call paid for its clients before sessions were shared
+func BenchmarkNewSession(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		s3.New(session.New(aws.NewConfig().WithRegion("us-east-1")))
+	}
+}
+
+// BenchmarkSharedSession is what a call of a warm Lambda pays for a client
+func BenchmarkSharedSession(b *testing.B) {
+	SessionIn("us-east-1")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s3.New(SessionIn("us-east-1"))
+	}
+}
@@ -0,0 +1,109 @@
+// Package catalog holds curated service catalogs new cities start from, so onboarding a city doesn't mean entering
+// the same services by hand.  Template services are grouped by the department that usually handles them, eg
+// "streets"; a city assigns the groups to its own agencies with routing rules.
+package catalog
+
+import (
+	"sort"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+// Template is a curated service catalog.  Its services' codes are prefixed with the city's name when applied.
+type Template struct {
+	Name        string               `json:"name"`
+	Description string               `json:"description"`
+	Services    []repository.Service `json:"services"`
+}
+
+// Get returns the template of a name
+func Get(name string) (Template, bool) {
+	template, ok := templates[name]
+	return template, ok
+}
+
+// Templates returns every template, by name
+func Templates() []Template {
+	list := []Template{}
+	for _, template := range templates {
+		list = append(list, template)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// service returns a realtime template service
+func service(code string, name string, group string, slaHours int, description string, keywords ...string) repository.Service {
+	return repository.Service{
+		ServiceCode: code,
+		ServiceName: name,
+		Description: description,
+		Type:        "realtime",
+		Keywords:    keywords,
+		Group:       group,
+		SLAHours:    slaHours,
+	}
+}
+
+// emergency marks a template service as an emergency, whose updates are sent even during quiet hours
+func emergency(s repository.Service) repository.Service {
+	s.Emergency = true
+	return s
+}
+
+var templates = map[string]Template{
+	"standard_municipal": {
+		Name:        "standard_municipal",
+		Description: "Services most cities and towns offer: streets, sanitation, parks, code enforcement and animal control",
+		Services: []repository.Service{
+			service("pothole", "Pothole", "streets", 72, "Hole or sunken pavement in a road", "road", "street", "pavement"),
+			service("street-light", "Street Light Out", "streets", 168, "Street light out, flickering or on during the day", "lamp", "light"),
+			emergency(service("traffic-signal", "Traffic Signal Problem", "streets", 4, "Traffic light dark, stuck or out of sync", "traffic light", "signal")),
+			service("street-sign", "Damaged or Missing Street Sign", "streets", 168, "Street name or traffic sign damaged, missing or obstructed", "sign", "stop sign"),
+			service("sidewalk", "Sidewalk Repair", "streets", 720, "Cracked, raised or missing sidewalk", "sidewalk", "trip hazard"),
+			service("curb-ramp", "Curb Ramp Problem", "streets", 720, "Damaged or missing curb ramp", "ada", "wheelchair", "accessibility"),
+			service("street-sweeping", "Street Sweeping", "streets", 336, "Debris or dirt along a street that needs sweeping", "debris", "sweeper"),
+			service("snow-removal", "Snow or Ice on Road", "streets", 24, "Road not plowed or icy", "snow", "ice", "plow"),
+			service("missed-trash", "Missed Trash Pickup", "sanitation", 48, "Trash not collected on its scheduled day", "garbage", "trash", "collection"),
+			service("missed-recycling", "Missed Recycling Pickup", "sanitation", 48, "Recycling not collected on its scheduled day", "recycling", "collection"),
+			service("bulk-item", "Bulk Item Pickup", "sanitation", 168, "Furniture, appliances or other large items to collect", "furniture", "appliance", "mattress"),
+			service("illegal-dumping", "Illegal Dumping", "sanitation", 120, "Trash or debris dumped on public property", "dumping", "litter"),
+			service("overflowing-bin", "Overflowing Public Trash Can", "sanitation", 48, "Public trash or recycling can that is full", "trash can", "bin"),
+			service("dead-animal", "Dead Animal Removal", "sanitation", 24, "Dead animal on a street or public property", "carcass", "roadkill"),
+			service("graffiti", "Graffiti", "code-enforcement", 168, "Graffiti on public or private property", "vandalism", "tagging"),
+			service("abandoned-vehicle", "Abandoned Vehicle", "code-enforcement", 336, "Vehicle left unmoved on a street for days", "car", "vehicle", "parking"),
+			service("overgrown-lot", "Overgrown Lot or Weeds", "code-enforcement", 336, "Tall grass or weeds on a vacant or neglected lot", "grass", "weeds", "vacant lot"),
+			service("property-maintenance", "Property Maintenance", "code-enforcement", 720, "Building in disrepair, broken windows or unsafe structure", "blight", "building"),
+			service("noise", "Noise Complaint", "code-enforcement", 72, "Ongoing noise from construction, equipment or a business", "noise", "construction"),
+			service("parking-violation", "Parking Violation", "code-enforcement", 24, "Vehicle blocking a driveway, hydrant or crosswalk", "parking", "blocked driveway"),
+			service("park-maintenance", "Park Maintenance", "parks", 168, "Broken equipment, litter or damage in a park", "park", "playground"),
+			service("tree-down", "Fallen Tree or Limb", "parks", 24, "Tree or large branch down on a street, sidewalk or park", "tree", "branch", "limb"),
+			service("tree-trimming", "Tree Trimming", "parks", 720, "Street tree blocking a sign, light or sidewalk", "tree", "pruning"),
+			service("stray-animal", "Stray or Loose Animal", "animal-control", 24, "Stray, loose or injured animal", "dog", "cat", "stray"),
+			service("animal-nuisance", "Animal Nuisance", "animal-control", 168, "Barking dog, wildlife or other animal problem", "barking", "wildlife"),
+			emergency(service("water-main", "Water Main Break", "utilities", 4, "Water flowing from a street, hydrant or main", "water", "leak", "flooding")),
+			service("storm-drain", "Clogged Storm Drain", "utilities", 72, "Storm drain blocked or water pooling in the street", "drain", "flooding", "catch basin"),
+			service("sewer-backup", "Sewer Backup or Odor", "utilities", 24, "Sewage backing up or a sewer smell", "sewer", "odor"),
+			service("fire-hydrant", "Fire Hydrant Problem", "utilities", 72, "Hydrant damaged, leaking or blocked", "hydrant"),
+			service("general-inquiry", "General Question or Concern", "city-hall", 120, "Anything not covered by another service", "question", "other"),
+		},
+	},
+	"county_roads": {
+		Name:        "county_roads",
+		Description: "Services of a county highway department maintaining rural roads, bridges and culverts",
+		Services: []repository.Service{
+			service("pothole", "Pothole", "highway", 120, "Hole or sunken pavement in a county road", "road", "pavement"),
+			service("shoulder-repair", "Shoulder Repair", "highway", 336, "Washed out or dropped road shoulder", "shoulder", "edge"),
+			service("gravel-road", "Gravel Road Grading", "highway", 336, "Washboard, ruts or loose gravel on an unpaved road", "gravel", "grading", "dirt road"),
+			service("road-sign", "Damaged or Missing Road Sign", "highway", 168, "County road sign damaged, missing or obstructed", "sign"),
+			emergency(service("road-hazard", "Road Hazard", "highway", 4, "Debris, washout or other hazard in the roadway", "debris", "washout", "hazard")),
+			emergency(service("snow-ice", "Snow or Ice on County Road", "highway", 12, "County road not plowed or icy", "snow", "ice", "plow")),
+			service("culvert", "Culvert or Ditch Problem", "drainage", 336, "Blocked or collapsed culvert, or ditch not draining", "culvert", "ditch", "drainage"),
+			service("flooding", "Road Flooding", "drainage", 24, "Water over or undermining a county road", "flooding", "water"),
+			service("bridge", "Bridge or Guardrail Damage", "bridges", 72, "Damaged bridge, railing or guardrail", "bridge", "guardrail"),
+			service("roadside-mowing", "Roadside Mowing or Brush", "highway", 720, "Brush or grass blocking sight lines along a road", "mowing", "brush", "sight line"),
+			service("tree-down", "Tree Down on Road", "highway", 12, "Tree or large branch blocking a county road", "tree", "branch"),
+			service("dead-animal", "Dead Animal on Road", "highway", 48, "Dead animal on a county road", "carcass", "roadkill", "deer"),
+		},
+	},
+}
@@ -0,0 +1,54 @@
+package catalog
+
+import (
+	"regexp"
+	"testing"
+)
+
+// codePattern is the pattern the service handler requires of codes, less room for a city prefix
+var codePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+func TestTemplates(t *testing.T) {
+	list := Templates()
+	if len(list) != len(templates) {
+		t.Fatalf("Templates() returned %d templates, want %d", len(list), len(templates))
+	}
+	for i := 1; i < len(list); i++ {
+		if list[i-1].Name >= list[i].Name {
+			t.Errorf("Templates() not sorted: %s before %s", list[i-1].Name, list[i].Name)
+		}
+	}
+
+	for name, template := range templates {
+		if template.Name != name {
+			t.Errorf("template %s is named %s", name, template.Name)
+		}
+		if len(template.Services) == 0 {
+			t.Errorf("template %s has no services", name)
+		}
+
+		codes := map[string]bool{}
+		for _, s := range template.Services {
+			if !codePattern.MatchString(s.ServiceCode) {
+				t.Errorf("template %s: service code %q is not a lower case slug", name, s.ServiceCode)
+			}
+			if codes[s.ServiceCode] {
+				t.Errorf("template %s: service code %s repeated", name, s.ServiceCode)
+			}
+			codes[s.ServiceCode] = true
+
+			if s.ServiceName == "" || s.Group == "" || s.Type != "realtime" || s.Metadata || s.CityID != "" {
+				t.Errorf("template %s: service %s is incomplete or city specific: %+v", name, s.ServiceCode, s)
+			}
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	if _, ok := Get("standard_municipal"); !ok {
+		t.Error("Get(standard_municipal) not found")
+	}
+	if _, ok := Get("nope"); ok {
+		t.Error("Get(nope) found a template")
+	}
+}
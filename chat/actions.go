@@ -0,0 +1,80 @@
+package chat
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ActionLinkTTL is how long the buttons of a Teams card keep working
+const ActionLinkTTL = 7 * 24 * time.Hour
+
+// slackTolerance is how old a Slack request may be, refusing replays of captured requests
+const slackTolerance = 5 * time.Minute
+
+// ErrInvalidSignature is returned for callbacks whose signature doesn't verify, or has expired
+var ErrInvalidSignature = errors.New("chat: invalid or expired signature")
+
+// ActionLink returns the link a Teams card's button opens to take an action on a request of a city, eg
+// "https://api.example.com/Prod/integrations/teams/action?action=close&city=albany&expires=1583020800&request=SR-1&signature=..."
+func ActionLink(base string, secret string, cityID string, requestID string, action string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	query := url.Values{}
+	query.Set("city", cityID)
+	query.Set("request", requestID)
+	query.Set("action", action)
+	query.Set("expires", exp)
+	query.Set("signature", actionSignature(secret, cityID, requestID, action, exp))
+	return base + "?" + query.Encode()
+}
+
+// VerifyActionLink checks the signature and expiry of an action link's query parameters.  Without a secret every
+// link is refused, since anyone could sign one with the empty key.
+func VerifyActionLink(secret string, params map[string]string, now time.Time) error {
+	if secret == "" {
+		return ErrInvalidSignature
+	}
+	expires, err := strconv.ParseInt(params["expires"], 10, 64)
+	if err != nil || now.Unix() > expires {
+		return ErrInvalidSignature
+	}
+	want := actionSignature(secret, params["city"], params["request"], params["action"], params["expires"])
+	if !hmac.Equal([]byte(want), []byte(params["signature"])) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func actionSignature(secret string, cityID string, requestID string, action string, expires string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(cityID + "\n" + requestID + "\n" + action + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySlack checks that a callback came from Slack, from its X-Slack-Request-Timestamp and X-Slack-Signature
+// headers and the app's signing secret.  Without a signing secret every callback is refused.
+func VerifySlack(signingSecret string, timestamp string, body string, signature string, now time.Time) error {
+	if signingSecret == "" {
+		return ErrInvalidSignature
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	age := now.Sub(time.Unix(ts, 0))
+	if age > slackTolerance || age < -slackTolerance {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+	want := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(want), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
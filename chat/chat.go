@@ -0,0 +1,192 @@
+// Package chat posts requests to the Slack and Microsoft Teams channels of agencies as cards, with buttons that
+// accept or close the request without leaving the channel.  Small departments run on chat rather than dashboards.
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// Actions a card's buttons take on its request
+const (
+	ActionAccept = "accept"
+	ActionClose  = "close"
+)
+
+// actionStatus is the status each action moves a request to
+var actionStatus = map[string]string{
+	ActionAccept: repository.RequestAccepted,
+	ActionClose:  repository.RequestClosed,
+}
+
+// ActionStatus returns the status an action moves a request to, and false for unknown actions
+func ActionStatus(action string) (string, bool) {
+	status, ok := actionStatus[action]
+	return status, ok
+}
+
+// Actions returns the actions that can be taken on a request: accepting it while it is open, and closing it until
+// it is closed
+func Actions(request repository.Request) []string {
+	actions := []string{}
+	if request.Status == repository.RequestOpen || request.Status == "" {
+		actions = append(actions, ActionAccept)
+	}
+	if request.Status != repository.RequestClosed {
+		actions = append(actions, ActionClose)
+	}
+	return actions
+}
+
+// Card is a request as posted to a channel
+type Card struct {
+	Title   string // eg "New Pothole request" or "Pothole request closed"
+	Request repository.Request
+	Link    string // Where the request is seen in full, on the dashboard or in the API
+}
+
+// actionTitles label the buttons of actions
+var actionTitles = map[string]string{
+	ActionAccept: "Accept",
+	ActionClose:  "Close",
+}
+
+// slackCityBlock prefixes the block_id of a Slack card's buttons, which names the city of the card's request
+const slackCityBlock = "city:"
+
+// SlackActionCity returns the city of the request a Slack button was pressed for, from the block_id of its block
+func SlackActionCity(blockID string) string {
+	if !strings.HasPrefix(blockID, slackCityBlock) {
+		return ""
+	}
+	return strings.TrimPrefix(blockID, slackCityBlock)
+}
+
+// SlackMessage returns a card as a Slack message of blocks.  The buttons carry the request's ID as their value and
+// the action as their action_id, and their block the request's city as its block_id.
+func SlackMessage(card Card) map[string]interface{} {
+	request := card.Request
+	text := "*" + card.Title + "*"
+	if request.Address != "" {
+		text += " at " + request.Address
+	}
+	if request.Description != "" {
+		text += "\n>" + request.Description
+	}
+	text += fmt.Sprintf("\n<%s|%s>", card.Link, request.ServiceRequestID)
+
+	blocks := []interface{}{
+		map[string]interface{}{
+			"type": "section",
+			"text": map[string]string{"type": "mrkdwn", "text": text},
+		},
+		map[string]interface{}{
+			"type":     "context",
+			"elements": []interface{}{map[string]string{"type": "mrkdwn", "text": "Status: " + request.Status}},
+		},
+	}
+
+	buttons := []interface{}{}
+	for _, action := range Actions(request) {
+		button := map[string]interface{}{
+			"type":      "button",
+			"action_id": action,
+			"value":     request.ServiceRequestID,
+			"text":      map[string]string{"type": "plain_text", "text": actionTitles[action]},
+		}
+		if action == ActionClose {
+			button["style"] = "primary"
+		}
+		buttons = append(buttons, button)
+	}
+	if len(buttons) > 0 {
+		blocks = append(blocks, map[string]interface{}{"type": "actions", "block_id": slackCityBlock + request.CityID, "elements": buttons})
+	}
+
+	return map[string]interface{}{"text": card.Title + " " + request.ServiceRequestID, "blocks": blocks}
+}
+
+// TeamsMessage returns a card as a Teams message holding an Adaptive Card.  Incoming webhooks can't take a
+// card's submissions, so its buttons open the links actionURL returns for each action.
+func TeamsMessage(card Card, actionURL func(action string) string) map[string]interface{} {
+	request := card.Request
+	facts := []interface{}{map[string]string{"title": "Status", "value": request.Status}}
+	if request.Address != "" {
+		facts = append(facts, map[string]string{"title": "Address", "value": request.Address})
+	}
+	facts = append(facts, map[string]string{"title": "Request", "value": request.ServiceRequestID})
+
+	body := []interface{}{
+		map[string]interface{}{"type": "TextBlock", "text": card.Title, "weight": "Bolder", "size": "Medium", "wrap": true},
+	}
+	if request.Description != "" {
+		body = append(body, map[string]interface{}{"type": "TextBlock", "text": request.Description, "wrap": true})
+	}
+	body = append(body, map[string]interface{}{"type": "FactSet", "facts": facts})
+
+	actions := []interface{}{}
+	for _, action := range Actions(request) {
+		actions = append(actions, map[string]string{"type": "Action.OpenUrl", "title": actionTitles[action], "url": actionURL(action)})
+	}
+	actions = append(actions, map[string]string{"type": "Action.OpenUrl", "title": "View", "url": card.Link})
+
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []interface{}{map[string]interface{}{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]interface{}{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body":    body,
+				"actions": actions,
+			},
+		}},
+	}
+}
+
+// PostSlack posts a card to a Slack channel through an incoming webhook of the platform's Slack app.  The app's
+// interactivity request URL must be the SlackActions endpoint for the buttons to work.
+func PostSlack(webhookURL string, card Card) error {
+	return post(webhookURL, SlackMessage(card))
+}
+
+// PostTeams posts a card to a Teams channel through an incoming webhook, its buttons opening signed action links
+// under actionBase
+func PostTeams(webhookURL string, card Card, actionBase string, secret string) error {
+	expires := time.Now().Add(ActionLinkTTL)
+	return post(webhookURL, TeamsMessage(card, func(action string) string {
+		return ActionLink(actionBase, secret, card.Request.CityID, card.Request.ServiceRequestID, action, expires)
+	}))
+}
+
+// Post sends a message to a webhook, eg a Slack response_url
+func Post(url string, message interface{}) error {
+	return post(url, message)
+}
+
+func post(url string, message interface{}) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("chat: unable to marshal message: %s", err)
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("chat: unable to post message: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("chat: webhook responded %s", resp.Status)
+	}
+	return nil
+}
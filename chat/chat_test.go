@@ -0,0 +1,136 @@
+package chat
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestActions(t *testing.T) {
+	tests := map[string]string{
+		repository.RequestOpen:       "accept,close",
+		repository.RequestAccepted:   "close",
+		repository.RequestInProgress: "close",
+		repository.RequestClosed:     "",
+	}
+	for status, want := range tests {
+		if got := strings.Join(Actions(repository.Request{Status: status}), ","); got != want {
+			t.Errorf("Actions(%s) = %s, want %s", status, got, want)
+		}
+	}
+}
+
+func TestSlackMessage(t *testing.T) {
+	card := Card{
+		Title:   "New Pothole request",
+		Request: repository.Request{ServiceRequestID: "SR-1", CityID: "albany", Status: repository.RequestOpen, Address: "12 Elm St"},
+		Link:    "https://dashboard.example.com/requests/SR-1",
+	}
+	body, _ := json.Marshal(SlackMessage(card))
+	for _, want := range []string{`"action_id":"accept"`, `"action_id":"close"`, `"value":"SR-1"`, `"block_id":"city:albany"`, `at 12 Elm St`, `https://dashboard.example.com/requests/SR-1|SR-1`} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("SlackMessage() = %s, want %s", body, want)
+		}
+	}
+
+	card.Request.Status = repository.RequestClosed
+	body, _ = json.Marshal(SlackMessage(card))
+	if strings.Contains(string(body), `"actions"`) {
+		t.Errorf("SlackMessage() of a closed request = %s, want no buttons", body)
+	}
+}
+
+func TestSlackActionCity(t *testing.T) {
+	tests := map[string]string{"city:albany": "albany", "city:": "", "Xa1b": ""}
+	for blockID, want := range tests {
+		if got := SlackActionCity(blockID); got != want {
+			t.Errorf("SlackActionCity(%q) = %q, want %q", blockID, got, want)
+		}
+	}
+}
+
+func TestTeamsMessage(t *testing.T) {
+	card := Card{Title: "New Pothole request", Request: repository.Request{ServiceRequestID: "SR-1", Status: repository.RequestAccepted}, Link: "https://dashboard.example.com/requests/SR-1"}
+	body, _ := json.Marshal(TeamsMessage(card, func(action string) string { return "https://api.example.com/" + action }))
+	for _, want := range []string{`"contentType":"application/vnd.microsoft.card.adaptive"`, `"url":"https://api.example.com/close"`, `"title":"View"`} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("TeamsMessage() = %s, want %s", body, want)
+		}
+	}
+	if strings.Contains(string(body), "https://api.example.com/accept") {
+		t.Errorf("TeamsMessage() of an accepted request = %s, want no accept button", body)
+	}
+}
+
+func TestActionLink(t *testing.T) {
+	now := time.Unix(1583020800, 0)
+	link := ActionLink("https://api.example.com/Prod/integrations/teams/action", "secret", "albany", "SR-1", ActionClose, now.Add(time.Hour))
+
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("ActionLink() = %s, not a URL", link)
+	}
+	params := map[string]string{}
+	for name := range u.Query() {
+		params[name] = u.Query().Get(name)
+	}
+	if err := VerifyActionLink("secret", params, now); err != nil {
+		t.Errorf("VerifyActionLink() = %s, want nil", err)
+	}
+	if err := VerifyActionLink("other", params, now); err != ErrInvalidSignature {
+		t.Errorf("VerifyActionLink() with another secret = %v, want ErrInvalidSignature", err)
+	}
+	if err := VerifyActionLink("secret", params, now.Add(2*time.Hour)); err != ErrInvalidSignature {
+		t.Errorf("VerifyActionLink() after expiry = %v, want ErrInvalidSignature", err)
+	}
+	params["action"] = ActionAccept
+	if err := VerifyActionLink("secret", params, now); err != ErrInvalidSignature {
+		t.Errorf("VerifyActionLink() of another action = %v, want ErrInvalidSignature", err)
+	}
+	params["action"] = ActionClose
+	params["city"] = "troy"
+	if err := VerifyActionLink("secret", params, now); err != ErrInvalidSignature {
+		t.Errorf("VerifyActionLink() of another city = %v, want ErrInvalidSignature", err)
+	}
+
+	// Links signed with the empty key are refused, lest anyone forge them when no secret is set
+	link = ActionLink("https://api.example.com/Prod/integrations/teams/action", "", "albany", "SR-1", ActionClose, now.Add(time.Hour))
+	u, _ = url.Parse(link)
+	for name := range u.Query() {
+		params[name] = u.Query().Get(name)
+	}
+	if err := VerifyActionLink("", params, now); err != ErrInvalidSignature {
+		t.Errorf("VerifyActionLink() without a secret = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestVerifySlack(t *testing.T) {
+	now := time.Unix(1531420618, 0)
+	body := "payload=%7B%7D"
+	mac := hmac.New(sha256.New, []byte("signing"))
+	mac.Write([]byte("v0:1531420618:" + body))
+	signature := "v0=" + hex.EncodeToString(mac.Sum(nil))
+
+	if err := VerifySlack("signing", "1531420618", body, signature, now); err != nil {
+		t.Errorf("VerifySlack() = %s, want nil", err)
+	}
+	if err := VerifySlack("signing", "1531420618", body+"x", signature, now); err == nil {
+		t.Error("VerifySlack() of an altered body should set an error")
+	}
+	if err := VerifySlack("signing", "1531420618", body, signature, now.Add(10*time.Minute)); err == nil {
+		t.Error("VerifySlack() of a stale request should set an error")
+	}
+
+	mac = hmac.New(sha256.New, []byte(""))
+	mac.Write([]byte("v0:1531420618:" + body))
+	if err := VerifySlack("", "1531420618", body, "v0="+hex.EncodeToString(mac.Sum(nil)), now); err == nil {
+		t.Error("VerifySlack() without a signing secret should set an error")
+	}
+}
@@ -0,0 +1,41 @@
+// Command citybackfill sets the city_id of requests, services and users stored before data was partitioned by
+// city, assigning them to the city the deployment served
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func main() {
+	city := flag.String("city", "", "city_name of the city the existing data belongs to")
+	dryRun := flag.Bool("dry-run", false, "count the items needing a city_id without updating them")
+	flag.Parse()
+
+	if *city == "" {
+		log.Fatal("-city must name the city the existing data belongs to")
+	}
+	if _, err := repository.GetCity(*city); err != nil {
+		log.Fatalf("Unable to get city %s: %s", *city, err)
+	}
+
+	tables := []struct{ name, key string }{
+		{repository.RequestsTable, "service_request_id"},
+		{repository.ServicesTable, "service_code"},
+		{repository.UsersTable, "account_id"},
+	}
+	for _, table := range tables {
+		updated, err := repository.BackfillCityID(table.name, table.key, *city, *dryRun)
+		if err != nil {
+			log.Fatalf("city_id backfill of %s stopped after %d items: %s", table.name, updated, err)
+		}
+
+		if *dryRun {
+			log.Printf("%d %s items need a city_id", updated, table.name)
+			continue
+		}
+		log.Printf("Set the city_id of %d %s items to %s", updated, table.name, *city)
+	}
+}
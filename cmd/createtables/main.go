@@ -0,0 +1,23 @@
+// Command createtables creates the platform's DynamoDB tables missing from an account, billed per request, with
+// the keys and indexes the repository expects, so a dev stack or a new region doesn't start from hand-made tables
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func main() {
+	created, err := repository.CreateTables()
+	if len(created) > 0 {
+		log.Printf("Created %s", strings.Join(created, ", "))
+	}
+	if err != nil {
+		log.Fatalf("table creation stopped: %s", err)
+	}
+	if len(created) == 0 {
+		log.Printf("Every table exists")
+	}
+}
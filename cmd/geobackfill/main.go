@@ -0,0 +1,26 @@
+// Command geobackfill sets the geohash and geo_cell of requests stored before they were derived at write time,
+// so the requests show up in location queries
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "count the requests needing a geohash without updating them")
+	flag.Parse()
+
+	updated, err := repository.BackfillGeohashes(*dryRun)
+	if err != nil {
+		log.Fatalf("geohash backfill stopped after %d requests: %s", updated, err)
+	}
+
+	if *dryRun {
+		log.Printf("%d requests need a geohash", updated)
+		return
+	}
+	log.Printf("Set the geohash of %d requests", updated)
+}
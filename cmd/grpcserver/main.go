@@ -0,0 +1,31 @@
+// Command grpcserver serves the Open311 gRPC service on GRPC_ADDR (default :50051), for running in a container
+// behind a load balancer.  Calls must carry the GRPC_API_TOKEN as a bearer token when it is set.
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+
+	"github.com/social-torch/open311-services/rpc"
+)
+
+func main() {
+	addr := os.Getenv("GRPC_ADDR")
+	if addr == "" {
+		addr = ":50051"
+	}
+	token := os.Getenv("GRPC_API_TOKEN")
+	if token == "" {
+		log.Println("GRPC_API_TOKEN is not set; calls are not authenticated")
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Unable to listen on %s: %s", addr, err)
+	}
+	log.Printf("Serving gRPC on %s", addr)
+	if err := rpc.NewServer(token).Serve(listener); err != nil {
+		log.Fatal(err)
+	}
+}
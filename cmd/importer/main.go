@@ -0,0 +1,56 @@
+// Command importer imports the export of a city's previous 311 system, mapping its categories to the city's
+// services with a JSON mapping file, eg
+//
+//	importer -city troy -format seeclickfix -mapping mapping.json issues.csv
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/social-torch/open311-services/importer"
+)
+
+func main() {
+	city := flag.String("city", "", "city_name of the city importing its history")
+	format := flag.String("format", "", "format of the export: seeclickfix or connectedbits")
+	mappingFile := flag.String("mapping", "", "JSON file mapping the export's categories to service codes")
+	dryRun := flag.Bool("dry-run", false, "check the export and mapping without storing anything")
+	flag.Parse()
+	if *city == "" || *format == "" || *mappingFile == "" || flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(*mappingFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mapping, err := importer.ReadMapping(f)
+	f.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	export, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer export.Close()
+
+	result, err := importer.Import(*city, *format, export, mapping, *dryRun)
+	if err != nil {
+		log.Fatalf("Import stopped after %d requests: %s", result.Imported, err)
+	}
+	if len(result.Unmapped) > 0 {
+		log.Fatalf("Nothing imported. Map these categories to services first: %s", strings.Join(result.Unmapped, ", "))
+	}
+
+	if *dryRun {
+		log.Printf("%d requests would be imported", result.Imported)
+		return
+	}
+	log.Printf("Imported %d requests, skipped %d already imported", result.Imported, result.Skipped)
+}
@@ -0,0 +1,26 @@
+// Command pinbackfill puts the map pins of requests stored before the Stream function kept pins, so the requests
+// show up on maps reading pins
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "count the requests with a pin without putting them")
+	flag.Parse()
+
+	put, err := repository.BackfillPins(*dryRun)
+	if err != nil {
+		log.Fatalf("pin backfill stopped after %d requests: %s", put, err)
+	}
+
+	if *dryRun {
+		log.Printf("%d requests have a pin", put)
+		return
+	}
+	log.Printf("Put the pins of %d requests", put)
+}
@@ -0,0 +1,26 @@
+// Command queuebackfill sets the queue_agency of requests stored before it was derived at write time, so open
+// requests show up in their agency's workload
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "count the requests needing a queue without updating them")
+	flag.Parse()
+
+	updated, err := repository.BackfillQueues(*dryRun)
+	if err != nil {
+		log.Fatalf("queue backfill stopped after %d requests: %s", updated, err)
+	}
+
+	if *dryRun {
+		log.Printf("%d requests need a queue", updated)
+		return
+	}
+	log.Printf("Set the queue of %d requests", updated)
+}
@@ -0,0 +1,38 @@
+// Command zipbackfill sets the ZIP code of requests stored before it was derived at write time, by reverse
+// geocoding their location with the place index named by PLACE_INDEX, and rewrites ZIP codes stored as numbers
+// as strings
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/social-torch/open311-services/geocode"
+	"github.com/social-torch/open311-services/repository"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "count the requests needing a ZIP code, or one stored as a number, without looking them up or writing them")
+	flag.Parse()
+
+	geocoder := geocode.New("")
+	derive := func(request repository.Request) (repository.ZipCode, error) {
+		place, err := geocoder.Reverse(request.Coordinates())
+		if err != nil {
+			log.Printf("No ZIP code for request %s: %s", request.ServiceRequestID, err)
+			return "", err
+		}
+		return place.ZipCode, nil
+	}
+
+	updated, err := repository.BackfillZipCodes(derive, *dryRun)
+	if err != nil {
+		log.Fatalf("ZIP code backfill stopped after %d requests: %s", updated, err)
+	}
+
+	if *dryRun {
+		log.Printf("%d requests need a ZIP code", updated)
+		return
+	}
+	log.Printf("Set the ZIP code of %d requests", updated)
+}
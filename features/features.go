@@ -0,0 +1,85 @@
+// Package features evaluates the feature flags cities set in their config.  Handlers check flags on most calls, so
+// each city's config is cached for a short while in the warm Lambda container rather than read every time.
+package features
+
+import (
+	"sync"
+	"time"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+// ttl is how long a city's config is cached, and so how long a changed flag takes to reach every handler
+const ttl = time.Minute
+
+// Cache keeps the configs of cities for a while after they are read
+type Cache struct {
+	ttl     time.Duration
+	load    func(cityName string) (repository.CityConfig, error)
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+type entry struct {
+	config  repository.CityConfig
+	expires time.Time
+}
+
+// NewCache returns a cache reading configs from the Cities table, keeping each for ttl
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{
+		ttl:     ttl,
+		load:    repository.GetCityConfig,
+		now:     time.Now,
+		entries: map[string]entry{},
+	}
+}
+
+// Config returns the config of a city.  Calls to no city in particular, and to cities not in the database, get
+// the zero config, which leaves every flag at the platform default.
+func (c *Cache) Config(cityName string) (repository.CityConfig, error) {
+	if cityName == "" {
+		return repository.CityConfig{}, nil
+	}
+
+	c.mu.Lock()
+	e, ok := c.entries[cityName]
+	c.mu.Unlock()
+	if ok && c.now().Before(e.expires) {
+		return e.config, nil
+	}
+
+	config, err := c.load(cityName)
+	if err != nil {
+		if _, ok := err.(*repository.CityNotFoundErr); !ok {
+			return repository.CityConfig{}, err
+		}
+	}
+
+	c.mu.Lock()
+	c.entries[cityName] = entry{config, c.now().Add(c.ttl)}
+	c.mu.Unlock()
+	return config, nil
+}
+
+// Enabled reports whether a city has a feature on
+func (c *Cache) Enabled(cityName string, feature string) (bool, error) {
+	config, err := c.Config(cityName)
+	if err != nil {
+		return false, err
+	}
+	return config.Enabled(feature), nil
+}
+
+var shared = NewCache(ttl)
+
+// Enabled reports whether a city has a feature on, from the cache shared by the handler's calls
+func Enabled(cityName string, feature string) (bool, error) {
+	return shared.Enabled(cityName, feature)
+}
+
+// Config returns the config of a city, from the cache shared by the handler's calls
+func Config(cityName string) (repository.CityConfig, error) {
+	return shared.Config(cityName)
+}
@@ -0,0 +1,60 @@
+package features
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Date(2019, 6, 2, 9, 0, 0, 0, time.UTC)
+	loads := 0
+	configs := map[string]repository.CityConfig{
+		"Troy": {Features: map[string]bool{repository.FeatureUpvoting: true}},
+	}
+
+	c := NewCache(time.Minute)
+	c.now = func() time.Time { return now }
+	c.load = func(cityName string) (repository.CityConfig, error) {
+		loads++
+		if cityName == "Albany" {
+			return repository.CityConfig{}, errors.New("throttled")
+		}
+		config, ok := configs[cityName]
+		if !ok {
+			return repository.CityConfig{}, &repository.CityNotFoundErr{}
+		}
+		return config, nil
+	}
+
+	if on, err := c.Enabled("Troy", repository.FeatureUpvoting); !on || err != nil {
+		t.Errorf("Enabled() = %t, %v, want true", on, err)
+	}
+
+	// Changes aren't seen until the cached config expires
+	configs["Troy"] = repository.CityConfig{}
+	now = now.Add(30 * time.Second)
+	if on, _ := c.Enabled("Troy", repository.FeatureUpvoting); !on || loads != 1 {
+		t.Errorf("Enabled() = %t after %d loads, want true from the cache", on, loads)
+	}
+	now = now.Add(time.Minute)
+	if on, _ := c.Enabled("Troy", repository.FeatureUpvoting); on || loads != 2 {
+		t.Errorf("Enabled() = %t after %d loads, want false once expired", on, loads)
+	}
+
+	if on, err := c.Enabled("Cohoes", repository.FeatureAnonymousReporting); !on || err != nil {
+		t.Errorf("Enabled() of unknown city = %t, %v, want the default", on, err)
+	}
+	if on, err := c.Enabled("", repository.FeatureVideoUpload); !on || err != nil {
+		t.Errorf("Enabled() of no city = %t, %v, want the default", on, err)
+	}
+
+	if _, err := c.Enabled("Albany", repository.FeatureUpvoting); err == nil {
+		t.Error("Enabled() should set the error of a failed load")
+	}
+	if _, err := c.Enabled("Albany", repository.FeatureUpvoting); err == nil {
+		t.Error("Enabled() should not cache a failed load")
+	}
+}
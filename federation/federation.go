@@ -0,0 +1,355 @@
+// Package federation talks to the Open311 GeoReport v2 servers of cities that run their own backend, so the app
+// works in those cities too.  Responses are normalized into the repository types the rest of the platform uses.
+package federation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// maxResponseSize bounds how much of a server's response is read
+const maxResponseSize = 10 << 20
+
+// RequestFilters are the GeoReport v2 query parameters passed through to a server listing requests
+var RequestFilters = []string{"service_request_id", "service_code", "start_date", "end_date", "status"}
+
+// Client calls the GeoReport v2 server of one city
+type Client struct {
+	city     string
+	endpoint string
+	values   url.Values // jurisdiction_id, sent with every call when the server expects one
+}
+
+// New returns a client of a federated city's server.  The city's endpoint is the base of its GeoReport v2 paths,
+// eg "https://311.example.gov/open311/v2".
+func New(city repository.City) *Client {
+	values := url.Values{}
+	if city.FederationJurisdictionID != "" {
+		values.Set("jurisdiction_id", city.FederationJurisdictionID)
+	}
+	return &Client{
+		city:     city.CityName,
+		endpoint: strings.TrimSuffix(city.Endpoint, "/"),
+		values:   values,
+	}
+}
+
+// RemoteErr is returned when a server refuses a call, eg for an unknown service code.  StatusCode is the
+// server's 4xx status and the message is its description of the problem.
+type RemoteErr struct {
+	StatusCode int
+	message    string
+}
+
+func (e *RemoteErr) Error() string {
+	return e.message
+}
+
+// Services returns the services a city offers
+func (c *Client) Services() ([]repository.Service, error) {
+	body, err := c.get("services.json", url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	return normalizeServices(c.city, body)
+}
+
+// Requests returns the requests of a city, filtered by the RequestFilters in filters
+func (c *Client) Requests(filters map[string]string) ([]repository.Request, error) {
+	query := url.Values{}
+	for _, name := range RequestFilters {
+		if v := filters[name]; v != "" {
+			query.Set(name, v)
+		}
+	}
+
+	body, err := c.get("requests.json", query)
+	if err != nil {
+		return nil, err
+	}
+	return normalizeRequests(c.city, body)
+}
+
+// Request returns a single request of a city.  If the server has no such request, a RemoteErr error with status
+// 404 is set
+func (c *Client) Request(id string) (repository.Request, error) {
+	body, err := c.get("requests/"+url.PathEscape(id)+".json", url.Values{})
+	if err != nil {
+		return repository.Request{}, err
+	}
+
+	requests, err := normalizeRequests(c.city, body)
+	if err != nil {
+		return repository.Request{}, err
+	}
+	if len(requests) == 0 {
+		return repository.Request{}, &RemoteErr{http.StatusNotFound, fmt.Sprintf("request %s not found by %s", id, c.endpoint)}
+	}
+	return requests[0], nil
+}
+
+// Submit creates a request on a city's server with the city's API key
+func (c *Client) Submit(request repository.Request, apiKey string) (repository.RequestResponse, error) {
+	form := submitForm(request)
+	for name, values := range c.values {
+		form[name] = values
+	}
+	form.Set("api_key", apiKey)
+
+	resp, err := client.PostForm(c.endpoint+"/requests.json", form)
+	if err != nil {
+		return repository.RequestResponse{}, fmt.Errorf("federation: unable to submit request to %s: %s", c.endpoint, err)
+	}
+	body, err := read(resp)
+	if err != nil {
+		return repository.RequestResponse{}, err
+	}
+	return normalizeSubmission(c.endpoint, body)
+}
+
+// get calls a GeoReport v2 path, returning the body of a successful response
+func (c *Client) get(path string, query url.Values) ([]byte, error) {
+	for name, values := range c.values {
+		query[name] = values
+	}
+
+	u := c.endpoint + "/" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, fmt.Errorf("federation: unable to reach %s: %s", c.endpoint, err)
+	}
+	return read(resp)
+}
+
+// read returns the body of a successful response.  Errors described by the server in GeoReport v2 form are
+// returned as RemoteErr errors when the server blames the caller.
+func read(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("federation: unable to read response of %s: %s", resp.Request.URL.Host, err)
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return body, nil
+	}
+
+	message := resp.Status
+	var errs []struct {
+		Code        json.RawMessage `json:"code"`
+		Description string          `json:"description"`
+	}
+	if json.Unmarshal(body, &errs) == nil && len(errs) > 0 && errs[0].Description != "" {
+		message = errs[0].Description
+	}
+
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		return nil, &RemoteErr{resp.StatusCode, fmt.Sprintf("%s responded: %s", resp.Request.URL.Host, message)}
+	}
+	return nil, fmt.Errorf("federation: %s responded %s: %s", resp.Request.URL.Host, resp.Status, message)
+}
+
+// service is a GeoReport v2 service as servers send it.  Servers disagree on whether keywords are a list or a
+// comma separated string, on whether metadata is a boolean or a string, and some send numeric service codes.
+type service struct {
+	ServiceCode json.RawMessage `json:"service_code"`
+	ServiceName string          `json:"service_name"`
+	Description string          `json:"description"`
+	Metadata    json.RawMessage `json:"metadata"`
+	Type        string          `json:"type"`
+	Keywords    json.RawMessage `json:"keywords"`
+	Group       string          `json:"group"`
+}
+
+// request is a GeoReport v2 request as servers send it.  The spec names longitude long and the update time
+// updated_datetime, and servers send zipcode as a string or a number.
+type request struct {
+	ServiceRequestID  json.RawMessage       `json:"service_request_id"`
+	Status            string                `json:"status"`
+	StatusNotes       string                `json:"status_notes"`
+	ServiceName       string                `json:"service_name"`
+	ServiceCode       string                `json:"service_code"`
+	Description       string                `json:"description"`
+	AgencyResponsible string                `json:"agency_responsible"`
+	ServiceNotice     string                `json:"service_notice"`
+	RequestedDateTime string                `json:"requested_datetime"`
+	UpdatedDateTime   string                `json:"updated_datetime"`
+	ExpectedDateTime  string                `json:"expected_datetime"`
+	Address           string                `json:"address"`
+	AddressID         json.RawMessage       `json:"address_id"`
+	ZipCode           json.RawMessage       `json:"zipcode"`
+	Latitude          repository.Coordinate `json:"lat"`
+	Longitude         repository.Coordinate `json:"long"`
+	MediaURL          string                `json:"media_url"`
+}
+
+// normalizeServices converts a GeoReport v2 services.json response into services of a city
+func normalizeServices(city string, body []byte) ([]repository.Service, error) {
+	var remote []service
+	err := json.Unmarshal(body, &remote)
+	if err != nil {
+		return nil, fmt.Errorf("federation: services of %s are not GeoReport v2 JSON: %s", city, err)
+	}
+
+	services := []repository.Service{}
+	for _, s := range remote {
+		metadata, _ := strconv.ParseBool(text(s.Metadata))
+		services = append(services, repository.Service{
+			ServiceCode: text(s.ServiceCode),
+			ServiceName: s.ServiceName,
+			Description: s.Description,
+			Metadata:    metadata,
+			Type:        s.Type,
+			Keywords:    keywords(s.Keywords),
+			Group:       s.Group,
+			CityID:      city,
+		})
+	}
+	return services, nil
+}
+
+// normalizeRequests converts a GeoReport v2 requests.json response into requests of a city
+func normalizeRequests(city string, body []byte) ([]repository.Request, error) {
+	var remote []request
+	err := json.Unmarshal(body, &remote)
+	if err != nil {
+		return nil, fmt.Errorf("federation: requests of %s are not GeoReport v2 JSON: %s", city, err)
+	}
+
+	requests := []repository.Request{}
+	for _, r := range remote {
+		requests = append(requests, repository.Request{
+			ServiceRequestID:  text(r.ServiceRequestID),
+			CityID:            city,
+			Status:            r.Status,
+			StatusNotes:       r.StatusNotes,
+			ServiceName:       r.ServiceName,
+			ServiceCode:       r.ServiceCode,
+			Description:       r.Description,
+			AgencyResponsible: r.AgencyResponsible,
+			ServiceNotice:     r.ServiceNotice,
+			RequestedDateTime: r.RequestedDateTime,
+			UpdatedDateTime:   r.UpdatedDateTime,
+			ExpectedDateTime:  r.ExpectedDateTime,
+			Address:           r.Address,
+			AddressID:         text(r.AddressID),
+			ZipCode:           remoteZipCode(r.ZipCode),
+			Location:          repository.Location{Latitude: r.Latitude, Longitude: r.Longitude},
+			MediaURL:          r.MediaURL,
+		})
+	}
+	return requests, nil
+}
+
+// normalizeSubmission converts the response to a GeoReport v2 POST requests.json.  Servers that queue requests
+// return a token instead of an ID, which is passed on as a warning.
+func normalizeSubmission(endpoint string, body []byte) (repository.RequestResponse, error) {
+	var remote []struct {
+		ServiceRequestID json.RawMessage `json:"service_request_id"`
+		ServiceNotice    string          `json:"service_notice"`
+		AccountID        json.RawMessage `json:"account_id"`
+		Token            json.RawMessage `json:"token"`
+	}
+	err := json.Unmarshal(body, &remote)
+	if err != nil || len(remote) == 0 {
+		return repository.RequestResponse{}, fmt.Errorf("federation: %s did not return a GeoReport v2 submission", endpoint)
+	}
+
+	response := repository.RequestResponse{
+		ServiceRequestID: text(remote[0].ServiceRequestID),
+		ServiceNotice:    remote[0].ServiceNotice,
+		AccountID:        text(remote[0].AccountID),
+	}
+	if response.ServiceRequestID == "" {
+		token := text(remote[0].Token)
+		if token == "" {
+			return response, fmt.Errorf("federation: %s returned neither a service_request_id nor a token", endpoint)
+		}
+		response.Warnings = []string{fmt.Sprintf("the city queued the request with token %s; it gets an ID once processed", token)}
+	}
+	return response, nil
+}
+
+// submitForm returns the GeoReport v2 form fields of a request being submitted
+func submitForm(request repository.Request) url.Values {
+	form := url.Values{}
+	form.Set("service_code", request.ServiceCode)
+	if request.HasLocation() {
+		lat, lon := request.Coordinates()
+		form.Set("lat", strconv.FormatFloat(lat, 'f', -1, 64))
+		form.Set("long", strconv.FormatFloat(lon, 'f', -1, 64))
+	}
+	if request.Address != "" {
+		form.Set("address_string", request.Address)
+	}
+	if request.AddressID != "" {
+		form.Set("address_id", request.AddressID)
+	}
+	if request.Description != "" {
+		form.Set("description", request.Description)
+	}
+	if request.MediaURL != "" {
+		form.Set("media_url", request.MediaURL)
+	}
+	if request.AccountID != "" && request.AccountID != "guest" {
+		form.Set("account_id", request.AccountID)
+	}
+	return form
+}
+
+// text returns a JSON string or number as text, or "" for null or absent values
+func text(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var n json.Number
+	if json.Unmarshal(raw, &n) == nil {
+		return n.String()
+	}
+	var b bool
+	if json.Unmarshal(raw, &b) == nil {
+		return strconv.FormatBool(b)
+	}
+	return ""
+}
+
+// remoteZipCode returns a zipcode sent as a string or a number, or none if it isn't a ZIP code
+func remoteZipCode(raw json.RawMessage) repository.ZipCode {
+	var zipCode repository.ZipCode
+	if json.Unmarshal(raw, &zipCode) != nil {
+		return ""
+	}
+	return repository.ParseZipCode(string(zipCode))
+}
+
+// keywords returns service keywords sent as a list or as a comma separated string
+func keywords(raw json.RawMessage) []string {
+	var list []string
+	if json.Unmarshal(raw, &list) != nil {
+		list = strings.Split(text(raw), ",")
+	}
+
+	words := []string{}
+	for _, k := range list {
+		if k = strings.TrimSpace(k); k != "" {
+			words = append(words, k)
+		}
+	}
+	return words
+}
@@ -0,0 +1,140 @@
+package federation
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestNormalizeServices(t *testing.T) {
+	body := `[
+		{"service_code": "001", "service_name": "Pothole", "metadata": false, "type": "realtime", "keywords": ["road", "street"], "group": "Streets"},
+		{"service_code": 2, "service_name": "Graffiti", "metadata": "true", "type": "batch", "keywords": "paint, wall,", "group": "Parks"}
+	]`
+
+	services, err := normalizeServices("Troy", []byte(body))
+	if err != nil || len(services) != 2 {
+		t.Fatalf("normalizeServices() = %+v, %v, want 2 services", services, err)
+	}
+	if services[0].ServiceCode != "001" || services[0].Metadata || len(services[0].Keywords) != 2 || services[0].CityID != "Troy" {
+		t.Errorf("normalizeServices()[0] = %+v", services[0])
+	}
+	if services[1].ServiceCode != "2" || !services[1].Metadata || len(services[1].Keywords) != 2 || services[1].Keywords[1] != "wall" {
+		t.Errorf("normalizeServices()[1] = %+v, want metadata and keywords paint and wall", services[1])
+	}
+
+	if _, err := normalizeServices("Troy", []byte(`{"error": "down"}`)); err == nil {
+		t.Error("normalizeServices() of a non-list should set an error")
+	}
+}
+
+func TestNormalizeRequests(t *testing.T) {
+	body := `[{"service_request_id": 638344, "status": "open", "service_code": "001", "updated_datetime": "2019-06-02T09:00:00-04:00",
+		"address": "8TH AVE and JUDAH ST", "address_id": 545483, "zipcode": "94122", "lat": 37.762221815, "long": "-122.4651145"}]`
+
+	requests, err := normalizeRequests("Troy", []byte(body))
+	if err != nil || len(requests) != 1 {
+		t.Fatalf("normalizeRequests() = %+v, %v, want 1 request", requests, err)
+	}
+
+	r := requests[0]
+	if r.ServiceRequestID != "638344" || r.AddressID != "545483" || r.ZipCode != "94122" || r.CityID != "Troy" {
+		t.Errorf("normalizeRequests() = %+v", r)
+	}
+	if r.UpdatedDateTime != "2019-06-02T09:00:00-04:00" {
+		t.Errorf("UpdatedDateTime = %s, want updated_datetime", r.UpdatedDateTime)
+	}
+	if lat, lon := r.Coordinates(); lat != 37.762221815 || lon != -122.4651145 {
+		t.Errorf("Coordinates() = %f, %f, want 37.762221815, -122.4651145", lat, lon)
+	}
+}
+
+// Servers that send ZIP codes as numbers lose their leading zeros
+func TestRemoteZipCode(t *testing.T) {
+	tests := map[string]repository.ZipCode{`"01040"`: "01040", `1040`: "01040", `"12180-4321"`: "12180-4321", `"N/A"`: "", `null`: ""}
+	for raw, want := range tests {
+		if got := remoteZipCode(json.RawMessage(raw)); got != want {
+			t.Errorf("remoteZipCode(%s) = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestNormalizeSubmission(t *testing.T) {
+	response, err := normalizeSubmission("311.example.gov", []byte(`[{"service_request_id": 293944, "service_notice": "Within 2 days", "account_id": null}]`))
+	if err != nil || response.ServiceRequestID != "293944" || response.ServiceNotice != "Within 2 days" || len(response.Warnings) != 0 {
+		t.Errorf("normalizeSubmission() = %+v, %v", response, err)
+	}
+
+	response, err = normalizeSubmission("311.example.gov", []byte(`[{"token": "12345"}]`))
+	if err != nil || response.ServiceRequestID != "" || len(response.Warnings) != 1 {
+		t.Errorf("normalizeSubmission() of token = %+v, %v, want a warning", response, err)
+	}
+
+	if _, err = normalizeSubmission("311.example.gov", []byte(`[{}]`)); err == nil {
+		t.Error("normalizeSubmission() without ID or token should set an error")
+	}
+}
+
+func TestSubmitForm(t *testing.T) {
+	location, _ := repository.NewLocation(42.7284, -73.6918)
+	form := submitForm(repository.Request{ServiceCode: "001", Location: location, Description: "Deep hole", AccountID: "guest"})
+
+	if form.Get("service_code") != "001" || form.Get("lat") != "42.7284" || form.Get("long") != "-73.6918" || form.Get("description") != "Deep hole" {
+		t.Errorf("submitForm() = %v", form)
+	}
+	if _, ok := form["account_id"]; ok {
+		t.Error("submitForm() should not send the guest account")
+	}
+	if _, ok := form["address_string"]; ok {
+		t.Error("submitForm() should leave out empty fields")
+	}
+}
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("jurisdiction_id") != "troyny.gov" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`[{"code": 400, "description": "jurisdiction_id is required"}]`))
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v2/requests.json":
+			if r.URL.Query().Get("status") != "open" || r.URL.Query().Get("bbox") != "" {
+				t.Errorf("requests.json query = %s, want status only", r.URL.RawQuery)
+			}
+			w.Write([]byte(`[{"service_request_id": "1", "status": "open"}]`))
+		case "/v2/requests/2.json":
+			w.Write([]byte(`[]`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	c := New(repository.City{CityName: "Troy", Endpoint: server.URL + "/v2/", FederationJurisdictionID: "troyny.gov"})
+
+	requests, err := c.Requests(map[string]string{"status": "open", "bbox": "1,2,3,4"})
+	if err != nil || len(requests) != 1 || requests[0].ServiceRequestID != "1" {
+		t.Errorf("Requests() = %+v, %v", requests, err)
+	}
+
+	_, err = c.Request("2")
+	if remote, ok := err.(*RemoteErr); !ok || remote.StatusCode != http.StatusNotFound {
+		t.Errorf("Request() of unknown ID = %v, want a 404 RemoteErr", err)
+	}
+
+	_, err = c.Services()
+	if _, ok := err.(*RemoteErr); err == nil || ok {
+		t.Errorf("Services() of failing server = %v, want an error other than RemoteErr", err)
+	}
+
+	c = New(repository.City{CityName: "Troy", Endpoint: server.URL + "/v2"})
+	_, err = c.Services()
+	if remote, ok := err.(*RemoteErr); !ok || remote.StatusCode != http.StatusBadRequest {
+		t.Errorf("Services() without jurisdiction_id = %v, want a 400 RemoteErr", err)
+	}
+}
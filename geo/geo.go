@@ -0,0 +1,281 @@
+// Package geo provides the geohash encoding, distance and polygon math, clustering and GeoJSON types behind the
+// location queries on requests
+package geo
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// earthRadius is the mean radius of the earth in meters
+const earthRadius = 6371000.0
+
+// metersPerDegree is the length of a degree of latitude, and of longitude at the equator
+const metersPerDegree = 111320.0
+
+const base32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// Box is an area bounded by lines of latitude and longitude, in WGS84 degrees
+type Box struct {
+	MinLat float64
+	MinLon float64
+	MaxLat float64
+	MaxLon float64
+}
+
+// Contains reports whether a point lies within the box
+func (b Box) Contains(lat, lon float64) bool {
+	return lat >= b.MinLat && lat <= b.MaxLat && lon >= b.MinLon && lon <= b.MaxLon
+}
+
+// ParseBox parses a bounding box given as minLon,minLat,maxLon,maxLat, the order used by GeoJSON and most map
+// libraries
+func ParseBox(bbox string) (Box, error) {
+	parts := strings.Split(bbox, ",")
+	if len(parts) != 4 {
+		return Box{}, errors.New("bbox must be minLon,minLat,maxLon,maxLat")
+	}
+
+	v := make([]float64, 4)
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return Box{}, errors.New("bbox must be minLon,minLat,maxLon,maxLat in decimal degrees")
+		}
+		v[i] = f
+	}
+
+	box := Box{MinLon: v[0], MinLat: v[1], MaxLon: v[2], MaxLat: v[3]}
+	if box.MinLon > box.MaxLon || box.MinLat > box.MaxLat || box.MinLat < -90 || box.MaxLat > 90 ||
+		box.MinLon < -180 || box.MaxLon > 180 {
+		return Box{}, errors.New("bbox must have min before max, within -180..180 longitude and -90..90 latitude")
+	}
+	return box, nil
+}
+
+// Encode returns the geohash of a point with the given number of characters
+func Encode(lat, lon float64, precision int) string {
+	latRange := [2]float64{-90, 90}
+	lonRange := [2]float64{-180, 180}
+
+	hash := make([]byte, 0, precision)
+	bit, ch := 0, 0
+	even := true
+	for len(hash) < precision {
+		if even {
+			mid := (lonRange[0] + lonRange[1]) / 2
+			if lon >= mid {
+				ch |= 1 << uint(4-bit)
+				lonRange[0] = mid
+			} else {
+				lonRange[1] = mid
+			}
+		} else {
+			mid := (latRange[0] + latRange[1]) / 2
+			if lat >= mid {
+				ch |= 1 << uint(4-bit)
+				latRange[0] = mid
+			} else {
+				latRange[1] = mid
+			}
+		}
+		even = !even
+
+		if bit < 4 {
+			bit++
+		} else {
+			hash = append(hash, base32[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return string(hash)
+}
+
+// cellSize returns the height and width in degrees of the geohash cells of a precision
+func cellSize(precision int) (float64, float64) {
+	bits := 5 * precision
+	lonBits := (bits + 1) / 2
+	latBits := bits / 2
+	return 180 / math.Pow(2, float64(latBits)), 360 / math.Pow(2, float64(lonBits))
+}
+
+// Cover returns the geohashes of the given precision of every cell overlapping the box
+func Cover(box Box, precision int) []string {
+	height, width := cellSize(precision)
+
+	index := func(v, min, size float64, last int) int {
+		i := int(math.Floor((v - min) / size))
+		if i > last {
+			return last
+		}
+		return i
+	}
+	latCells := int(math.Round(180 / height))
+	lonCells := int(math.Round(360 / width))
+	minRow := index(math.Max(box.MinLat, -90), -90, height, latCells-1)
+	maxRow := index(math.Min(box.MaxLat, 90), -90, height, latCells-1)
+	minCol := index(math.Max(box.MinLon, -180), -180, width, lonCells-1)
+	maxCol := index(math.Min(box.MaxLon, 180), -180, width, lonCells-1)
+
+	hashes := []string{}
+	for row := minRow; row <= maxRow; row++ {
+		for col := minCol; col <= maxCol; col++ {
+			lat := -90 + (float64(row)+0.5)*height
+			lon := -180 + (float64(col)+0.5)*width
+			hashes = append(hashes, Encode(lat, lon, precision))
+		}
+	}
+	return hashes
+}
+
+// CircleBox returns the smallest box containing a circle of radius meters around a point
+func CircleBox(lat, lon, radius float64) Box {
+	dLat := radius / metersPerDegree
+	dLon := 180.0
+	if cos := math.Cos(lat * math.Pi / 180); cos > 0 {
+		dLon = math.Min(radius/(metersPerDegree*cos), 180)
+	}
+	return Box{MinLat: lat - dLat, MinLon: lon - dLon, MaxLat: lat + dLat, MaxLon: lon + dLon}
+}
+
+// Distance returns the great-circle distance in meters between two WGS84 points
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// Polygon holds the coordinates of a GeoJSON Polygon: an outer ring of [lon, lat] positions followed by the
+// rings of any holes
+type Polygon [][][]float64
+
+// Contains reports whether a point lies inside the outer ring and outside every hole
+func (p Polygon) Contains(lat, lon float64) bool {
+	if len(p) == 0 || !inRing(p[0], lat, lon) {
+		return false
+	}
+	for _, hole := range p[1:] {
+		if inRing(hole, lat, lon) {
+			return false
+		}
+	}
+	return true
+}
+
+// MultiPolygon holds the coordinates of a GeoJSON MultiPolygon, for areas in several parts
+type MultiPolygon []Polygon
+
+// Contains reports whether a point lies inside any part
+func (m MultiPolygon) Contains(lat, lon float64) bool {
+	for _, p := range m {
+		if p.Contains(lat, lon) {
+			return true
+		}
+	}
+	return false
+}
+
+// inRing casts a ray east from the point and counts the edges of the ring it crosses; an odd count is inside
+func inRing(ring [][]float64, lat, lon float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		if len(ring[i]) < 2 || len(ring[j]) < 2 {
+			continue
+		}
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+		if (yi > lat) != (yj > lat) && lon < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// FeatureCollection is a GeoJSON FeatureCollection, readable by web maps and GIS tools
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// Feature is a GeoJSON Feature.  Geometry is a Point or another value marshalling to a GeoJSON geometry object;
+// features without a location have a nil, null, geometry.
+type Feature struct {
+	Type       string                 `json:"type"`
+	Geometry   interface{}            `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// Point is a GeoJSON Point geometry
+type Point struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"` // [lon, lat]
+}
+
+// NewFeatureCollection returns a FeatureCollection of features, which is never null
+func NewFeatureCollection(features []Feature) FeatureCollection {
+	if features == nil {
+		features = []Feature{}
+	}
+	return FeatureCollection{Type: "FeatureCollection", Features: features}
+}
+
+// NewFeature returns a Feature at a point with properties
+func NewFeature(lat, lon float64, properties map[string]interface{}) Feature {
+	return Feature{
+		Type:       "Feature",
+		Geometry:   &Point{Type: "Point", Coordinates: [2]float64{lon, lat}},
+		Properties: properties,
+	}
+}
+
+// Cluster is a group of points sharing a geohash cell, located at their centroid
+type Cluster struct {
+	Cell      string  `json:"geohash"`
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lon"`
+	Count     int     `json:"count"`
+	ID        string  `json:"id,omitempty"` // ID of the point when the cluster holds only one
+}
+
+// Clusterer groups points into the geohash cells of a precision
+type Clusterer struct {
+	precision int
+	clusters  map[string]*Cluster
+}
+
+// NewClusterer returns a Clusterer grouping by cells of precision characters
+func NewClusterer(precision int) *Clusterer {
+	return &Clusterer{precision: precision, clusters: map[string]*Cluster{}}
+}
+
+// Add puts a point in the cluster of its cell, moving the cluster's centroid
+func (c *Clusterer) Add(id string, lat, lon float64) {
+	cell := Encode(lat, lon, c.precision)
+	cluster, ok := c.clusters[cell]
+	if !ok {
+		c.clusters[cell] = &Cluster{Cell: cell, Latitude: lat, Longitude: lon, Count: 1, ID: id}
+		return
+	}
+
+	cluster.Count++
+	cluster.Latitude += (lat - cluster.Latitude) / float64(cluster.Count)
+	cluster.Longitude += (lon - cluster.Longitude) / float64(cluster.Count)
+	cluster.ID = ""
+}
+
+// Clusters returns the clusters, ordered by cell so responses are stable
+func (c *Clusterer) Clusters() []Cluster {
+	clusters := []Cluster{}
+	for _, cluster := range c.clusters {
+		clusters = append(clusters, *cluster)
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Cell < clusters[j].Cell })
+	return clusters
+}
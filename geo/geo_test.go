@@ -0,0 +1,158 @@
+package geo
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"testing"
+)
+
+func TestParseBox(t *testing.T) {
+	box, err := ParseBox("-73.70,42.72,-73.68,42.74")
+	want := Box{MinLon: -73.70, MinLat: 42.72, MaxLon: -73.68, MaxLat: 42.74}
+	if err != nil || box != want {
+		t.Errorf("ParseBox() = %+v, %v, want %+v", box, err, want)
+	}
+
+	for _, bbox := range []string{"", "1,2,3", "a,b,c,d", "-73.68,42.72,-73.70,42.74", "0,-91,1,0"} {
+		if _, err := ParseBox(bbox); err == nil {
+			t.Errorf("ParseBox(%q) should fail", bbox)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		lat, lon  float64
+		precision int
+		hash      string
+	}{
+		{57.64911, 10.40744, 11, "u4pruydqqvj"},
+		{42.7284, -73.6918, 5, "dree5"},
+		{-33.8688, 151.2093, 6, "r3gx2f"},
+	}
+
+	for _, tt := range tests {
+		if got := Encode(tt.lat, tt.lon, tt.precision); got != tt.hash {
+			t.Errorf("Encode(%f, %f, %d) = %s, want %s", tt.lat, tt.lon, tt.precision, got, tt.hash)
+		}
+	}
+}
+
+func TestCover(t *testing.T) {
+	// A box well inside one cell is covered by that cell alone
+	box := CircleBox(42.7284, -73.6918, 50)
+	if got := Cover(box, 5); len(got) != 1 || got[0] != Encode(42.7284, -73.6918, 5) {
+		t.Errorf("Cover(small box) = %v", got)
+	}
+
+	// Every point of a larger box falls in one of its cover's cells
+	box = CircleBox(42.7284, -73.6918, 8000)
+	cover := Cover(box, 5)
+	sort.Strings(cover)
+	for _, p := range [][2]float64{
+		{box.MinLat, box.MinLon}, {box.MinLat, box.MaxLon}, {box.MaxLat, box.MinLon}, {box.MaxLat, box.MaxLon}, {42.7284, -73.6918},
+	} {
+		hash := Encode(p[0], p[1], 5)
+		i := sort.SearchStrings(cover, hash)
+		if i == len(cover) || cover[i] != hash {
+			t.Errorf("cover %v is missing %s containing %v", cover, hash, p)
+		}
+	}
+}
+
+func TestCircleBox(t *testing.T) {
+	box := CircleBox(42.7284, -73.6918, 1000)
+	if !box.Contains(42.7284, -73.6918) {
+		t.Error("box should contain its centre")
+	}
+	if d := Distance(42.7284, -73.6918, box.MaxLat, -73.6918); d < 990 || d > 1010 {
+		t.Errorf("box extends %f meters north, want about 1000", d)
+	}
+	if d := Distance(42.7284, -73.6918, 42.7284, box.MaxLon); d < 990 || d > 1010 {
+		t.Errorf("box extends %f meters east, want about 1000", d)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	// Troy to Albany, NY is roughly 10km
+	d := Distance(42.7284, -73.6918, 42.6526, -73.7562)
+	if d < 9500 || d > 10500 {
+		t.Errorf("Distance() = %f, want about 10000", d)
+	}
+}
+
+func TestPolygonContains(t *testing.T) {
+	// A square around downtown Troy with a hole cut out of its north east corner
+	square := [][]float64{{-73.70, 42.72}, {-73.68, 42.72}, {-73.68, 42.74}, {-73.70, 42.74}, {-73.70, 42.72}}
+	hole := [][]float64{{-73.685, 42.735}, {-73.681, 42.735}, {-73.681, 42.739}, {-73.685, 42.739}, {-73.685, 42.735}}
+	p := Polygon{square, hole}
+
+	tests := []struct {
+		lat, lon float64
+		want     bool
+	}{
+		{42.73, -73.69, true},
+		{42.737, -73.683, false},
+		{42.75, -73.69, false},
+		{42.73, -73.67, false},
+	}
+
+	for _, tt := range tests {
+		if got := p.Contains(tt.lat, tt.lon); got != tt.want {
+			t.Errorf("Polygon.Contains(%f, %f) = %v, want %v", tt.lat, tt.lon, got, tt.want)
+		}
+	}
+
+	if (Polygon{}).Contains(42.73, -73.69) {
+		t.Error("empty Polygon should contain nothing")
+	}
+
+	// A second part to the west
+	west := Polygon{{{-73.80, 42.72}, {-73.78, 42.72}, {-73.78, 42.74}, {-73.80, 42.72}}}
+	m := MultiPolygon{Polygon{square}, west}
+	if !m.Contains(42.725, -73.785) || !m.Contains(42.73, -73.69) || m.Contains(42.73, -73.75) {
+		t.Error("MultiPolygon.Contains should match points in any part only")
+	}
+}
+
+func TestFeatureCollection(t *testing.T) {
+	located := NewFeature(42.73, -73.69, map[string]interface{}{"status": "open"})
+	unlocated := Feature{Type: "Feature", Properties: map[string]interface{}{"status": "closed"}}
+
+	body, err := json.Marshal(NewFeatureCollection([]Feature{located, unlocated}))
+	want := `{"type":"FeatureCollection","features":[` +
+		`{"type":"Feature","geometry":{"type":"Point","coordinates":[-73.69,42.73]},"properties":{"status":"open"}},` +
+		`{"type":"Feature","geometry":null,"properties":{"status":"closed"}}]}`
+	if err != nil || string(body) != want {
+		t.Errorf("FeatureCollection JSON = %s, %v, want %s", body, err, want)
+	}
+
+	body, _ = json.Marshal(NewFeatureCollection(nil))
+	if string(body) != `{"type":"FeatureCollection","features":[]}` {
+		t.Errorf("empty FeatureCollection JSON = %s", body)
+	}
+}
+
+func TestClusterer(t *testing.T) {
+	c := NewClusterer(5)
+	c.Add("a", 42.7280, -73.6920)
+	c.Add("b", 42.7290, -73.6910)
+	c.Add("c", -33.8688, 151.2093)
+
+	clusters := c.Clusters()
+	if len(clusters) != 2 {
+		t.Fatalf("Clusters() = %+v, want 2 clusters", clusters)
+	}
+
+	troy, sydney := clusters[0], clusters[1]
+	if troy.Cell != "dree5" || troy.Count != 2 || troy.ID != "" {
+		t.Errorf("Troy cluster = %+v, want 2 unnamed points in dree5", troy)
+	}
+	if math.Abs(troy.Latitude-42.7285) > 1e-9 || math.Abs(troy.Longitude+73.6915) > 1e-9 {
+		t.Errorf("Troy cluster centroid = %f, %f, want 42.7285, -73.6915", troy.Latitude, troy.Longitude)
+	}
+	if sydney.Count != 1 || sydney.ID != "c" {
+		t.Errorf("Sydney cluster = %+v, want the single point c", sydney)
+	}
+}
@@ -0,0 +1,61 @@
+package geocode
+
+import (
+	"sync"
+	"time"
+)
+
+// Lookups are cached in memory for the life of the Lambda container, so repeat lookups of the same spot, eg
+// several reports of one pothole, cost one place index request
+const (
+	cacheTTL  = time.Hour
+	cacheSize = 1000
+)
+
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// cache holds lookup results for up to cacheTTL.  When full, expired entries are dropped, and if none have
+// expired it starts over; lookups are cheap enough that a smarter eviction isn't worth its code.
+type cache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	size    int
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+func newCache(size int, ttl time.Duration) *cache {
+	return &cache{entries: map[string]cacheEntry{}, size: size, ttl: ttl, now: time.Now}
+}
+
+func (c *cache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || c.now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *cache) put(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if len(c.entries) >= c.size {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.size {
+			c.entries = map[string]cacheEntry{}
+		}
+	}
+	c.entries[key] = cacheEntry{value: value, expires: now.Add(c.ttl)}
+}
@@ -0,0 +1,41 @@
+package geocode
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	c := newCache(2, time.Hour)
+	c.now = func() time.Time { return now }
+
+	c.put("a", 1)
+	if v, ok := c.get("a"); !ok || v != 1 {
+		t.Errorf("get(a) = %v, %v, want 1", v, ok)
+	}
+	if _, ok := c.get("b"); ok {
+		t.Error("get(b) should miss")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, ok := c.get("a"); ok {
+		t.Error("get(a) should miss once expired")
+	}
+
+	// a has expired, so adding c after b drops only a
+	c.put("b", 2)
+	c.put("c", 3)
+	if _, ok := c.get("b"); !ok {
+		t.Error("get(b) should hit after expired entries are dropped")
+	}
+
+	// Nothing has expired, so a full cache starts over
+	c.put("d", 4)
+	if _, ok := c.get("b"); ok {
+		t.Error("get(b) should miss after a full cache starts over")
+	}
+	if v, ok := c.get("d"); !ok || v != 4 {
+		t.Errorf("get(d) = %v, %v, want 4", v, ok)
+	}
+}
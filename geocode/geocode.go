@@ -0,0 +1,157 @@
+// Package geocode resolves between coordinates and street addresses, and searches for places, with Amazon Location
+// Service place indexes.  Each city may use its own place index; the rest use the one named by PLACE_INDEX.
+package geocode
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/locationservice"
+	"github.com/social-torch/open311-services/awsclient"
+	"github.com/social-torch/open311-services/geo"
+	"github.com/social-torch/open311-services/repository"
+)
+
+// Place is an address and its location
+type Place struct {
+	Address   string             // Human readable address, eg "123 Main St, Troy, NY 12180, USA"
+	AddressID string             // Place index ID of the address
+	ZipCode   repository.ZipCode // ZIP code, five digits or ZIP+4. Empty when unknown
+	Latitude  float64            // WGS84
+	Longitude float64            // WGS84
+}
+
+type NotFoundErr struct {
+	message string
+}
+
+func (e *NotFoundErr) Error() string {
+	return e.message
+}
+
+// Geocoder looks places up in one place index, caching the results
+type Geocoder struct {
+	index string
+}
+
+// lookups caches the results of every Geocoder, keyed by index and lookup
+var lookups = newCache(cacheSize, cacheTTL)
+
+// New returns a Geocoder using the named place index, or the deployment's PLACE_INDEX when index is ""
+func New(index string) *Geocoder {
+	if index == "" {
+		index = os.Getenv("PLACE_INDEX")
+	}
+	return &Geocoder{index: index}
+}
+
+// ForCity returns a Geocoder using a city's own place index, eg one with a local data provider, or the
+// deployment's PLACE_INDEX when the city has none
+func ForCity(city repository.City) *Geocoder {
+	return New(city.PlaceIndex)
+}
+
+// Match is a place found by a search, and the place index's relevance (0 to 1) of the match
+type Match struct {
+	Place
+	Relevance float64
+}
+
+// Reverse returns the address nearest a point.  If there is none, a NotFoundErr error is set
+func (g *Geocoder) Reverse(lat float64, lon float64) (Place, error) {
+	// Points within about a meter share an address
+	key := fmt.Sprintf("%s|reverse|%.5f,%.5f", g.index, lat, lon)
+	if cached, ok := lookups.get(key); ok {
+		return cached.(Place), nil
+	}
+
+	svc := locationservice.New(awsclient.Session())
+	result, err := svc.SearchPlaceIndexForPosition(&locationservice.SearchPlaceIndexForPositionInput{
+		IndexName:  aws.String(g.index),
+		Position:   []*float64{aws.Float64(lon), aws.Float64(lat)},
+		MaxResults: aws.Int64(1),
+	})
+	if err != nil {
+		return Place{}, fmt.Errorf("geocode: unable to reverse geocode %f,%f: %s", lat, lon, err)
+	}
+
+	if len(result.Results) == 0 || result.Results[0].Place == nil {
+		return Place{}, &NotFoundErr{fmt.Sprintf("no address found at %f,%f", lat, lon)}
+	}
+
+	r := result.Results[0]
+	place := toPlace(r.Place, aws.StringValue(r.PlaceId))
+	lookups.put(key, place)
+	return place, nil
+}
+
+// Forward returns the best match for a free text address.  When an area is given, only places inside it are
+// considered.  If nothing matches, a NotFoundErr error is set
+func (g *Geocoder) Forward(address string, area *geo.Box) (Match, error) {
+	matches, err := g.Search(address, area, 1)
+	if err != nil {
+		return Match{}, err
+	}
+	if len(matches) == 0 {
+		return Match{}, &NotFoundErr{fmt.Sprintf("no place found matching '%s'", address)}
+	}
+	return matches[0], nil
+}
+
+// Search returns up to max places matching free text, best first.  When an area is given, only places inside
+// it are considered.
+func (g *Geocoder) Search(text string, area *geo.Box, max int) ([]Match, error) {
+	key := fmt.Sprintf("%s|search|%d|%s", g.index, max, strings.ToLower(strings.TrimSpace(text)))
+	if area != nil {
+		key += fmt.Sprintf("|%f,%f,%f,%f", area.MinLon, area.MinLat, area.MaxLon, area.MaxLat)
+	}
+	if cached, ok := lookups.get(key); ok {
+		return cached.([]Match), nil
+	}
+
+	input := &locationservice.SearchPlaceIndexForTextInput{
+		IndexName:  aws.String(g.index),
+		Text:       aws.String(text),
+		MaxResults: aws.Int64(int64(max)),
+	}
+	if area != nil {
+		input.FilterBBox = []*float64{
+			aws.Float64(area.MinLon), aws.Float64(area.MinLat), aws.Float64(area.MaxLon), aws.Float64(area.MaxLat),
+		}
+	}
+
+	svc := locationservice.New(awsclient.Session())
+	result, err := svc.SearchPlaceIndexForText(input)
+	if err != nil {
+		return nil, fmt.Errorf("geocode: unable to search for '%s': %s", text, err)
+	}
+
+	matches := []Match{}
+	for _, r := range result.Results {
+		if r.Place == nil {
+			continue
+		}
+		matches = append(matches, Match{
+			Place:     toPlace(r.Place, aws.StringValue(r.PlaceId)),
+			Relevance: aws.Float64Value(r.Relevance),
+		})
+	}
+
+	lookups.put(key, matches)
+	return matches, nil
+}
+
+func toPlace(p *locationservice.Place, id string) Place {
+	place := Place{
+		Address:   aws.StringValue(p.Label),
+		AddressID: id,
+		ZipCode:   repository.ParseZipCode(aws.StringValue(p.PostalCode)),
+	}
+	if p.Geometry != nil && len(p.Geometry.Point) == 2 {
+		place.Longitude = aws.Float64Value(p.Geometry.Point[0])
+		place.Latitude = aws.Float64Value(p.Geometry.Point[1])
+	}
+	return place
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/social-torch/open311-services/chat"
+	"github.com/social-torch/open311-services/notification"
+	"github.com/social-torch/open311-services/repository"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+
+// handler tells the agency responsible for a new request about it through each channel the agency configured, and
+// updates the agency's chat channels as the request's status changes
+func handler(event events.CloudWatchEvent) error {
+	var requestEvent repository.RequestEvent
+	err := json.Unmarshal(event.Detail, &requestEvent)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling domain event detail: %s", err)
+	}
+
+	request := requestEvent.Request
+	created := requestEvent.Type == repository.RequestCreatedEvent
+	if (!created && requestEvent.Type != repository.StatusChangedEvent) || request.AgencyResponsible == "" {
+		return nil
+	}
+
+	chain, err := repository.GetAgencyChain(request.AgencyResponsible)
+	if err != nil {
+		switch err.(type) {
+		case *repository.AgencyNotFoundErr:
+			warningLogger.Printf("%s. agency_id '%s' not in database", err, request.AgencyResponsible)
+			return nil
+		default:
+			return err
+		}
+	}
+
+	link := requestLink(request.ServiceRequestID)
+	if !created {
+		card := chat.Card{Title: fmt.Sprintf("%s request %s", request.ServiceName, request.Status), Request: request, Link: link}
+		for _, agency := range recipients(chain) {
+			postCards(agency, card)
+		}
+		return nil
+	}
+
+	sender := notification.Sender(cityOf(request))
+	for _, agency := range recipients(chain) {
+		notifyAgency(agency, request, event.Detail, link, sender)
+	}
+
+	return nil
+}
+
+// recipients returns the agencies told about a new request, given the agency responsible for it followed by the
+// departments above it.  A division without channels of its own is covered by the nearest department above it
+// that has some, and departments that ask to hear about their sub-agencies' requests are told too.
+func recipients(chain []repository.Agency) []repository.Agency {
+	agencies := []repository.Agency{}
+	covered := false
+	for i, agency := range chain {
+		if !covered && hasChannels(agency) {
+			agencies = append(agencies, agency)
+			covered = true
+			continue
+		}
+		if i > 0 && agency.NotifySubagencies && hasChannels(agency) {
+			agencies = append(agencies, agency)
+		}
+	}
+	return agencies
+}
+
+// hasChannels reports whether an agency configured any channel to be told about new requests through
+func hasChannels(agency repository.Agency) bool {
+	return len(agency.Emails) > 0 || agency.WebhookURL != "" || agency.SlackWebhookURL != "" || agency.TeamsWebhookURL != ""
+}
+
+// notifyAgency tells an agency about a new request through each channel it configured.  Each channel is
+// independent; one failing should not keep the agency from hearing through the others, nor cause those that
+// succeeded to be repeated on retry.
+func notifyAgency(agency repository.Agency, request repository.Request, detail []byte, link string, sender string) {
+	for _, address := range agency.Emails {
+		id, err := notification.SendEmail(sender, address, notification.AgencyNewRequestTemplate,
+			map[string]string{
+				"agency":             agency.Name,
+				"service_request_id": request.ServiceRequestID,
+				"service_name":       request.ServiceName,
+				"description":        request.Description,
+				"address":            request.Address,
+				"link":               link,
+			})
+		record(request, repository.ChannelEmail, address, id, err)
+		if err != nil {
+			warningLogger.Println(err)
+			continue
+		}
+		infoLogger.Printf("Emailed %s about request %s (message %s)", address, request.ServiceRequestID, id)
+	}
+
+	if agency.WebhookURL != "" {
+		err := notification.PostJSON(agency.WebhookURL, agency.WebhookSecret, detail)
+		if err != nil {
+			warningLogger.Println(err)
+		}
+	}
+
+	postCards(agency, chat.Card{Title: fmt.Sprintf("New %s request", request.ServiceName), Request: request, Link: link})
+}
+
+// postCards posts a card of a request to each chat channel of an agency, with buttons to accept or close it
+func postCards(agency repository.Agency, card chat.Card) {
+	if agency.SlackWebhookURL != "" {
+		err := chat.PostSlack(agency.SlackWebhookURL, card)
+		record(card.Request, repository.ChannelSlack, agency.ID, "", err)
+		if err != nil {
+			warningLogger.Println(err)
+		}
+	}
+
+	if agency.TeamsWebhookURL != "" {
+		err := chat.PostTeams(agency.TeamsWebhookURL, card, os.Getenv("API_URL")+"/integrations/teams/action", os.Getenv("CHAT_ACTION_SECRET"))
+		record(card.Request, repository.ChannelTeams, agency.ID, "", err)
+		if err != nil {
+			warningLogger.Println(err)
+		}
+	}
+}
+
+// record logs an attempt to notify the agency in the request's delivery log
+func record(request repository.Request, channel string, recipient string, messageID string, err error) {
+	delivery := repository.NotificationDelivery{
+		ServiceRequestID: request.ServiceRequestID,
+		Channel:          channel,
+		Recipient:        recipient,
+		MessageID:        messageID,
+		Status:           repository.DeliverySent,
+		Attempt:          1,
+	}
+	if err != nil {
+		delivery.Status = repository.DeliveryFailed
+		delivery.Error = err.Error()
+	}
+
+	err = repository.RecordNotificationDelivery(delivery)
+	if err != nil {
+		warningLogger.Println(err)
+	}
+}
+
+// requestLink deep links into the city dashboard when DASHBOARD_URL is configured, and to the API otherwise
+func requestLink(id string) string {
+	if dashboard := os.Getenv("DASHBOARD_URL"); dashboard != "" {
+		return strings.TrimRight(dashboard, "/") + "/requests/" + id
+	}
+	return os.Getenv("API_URL") + "/request/" + id
+}
+
+// cityOf returns the city whose sender applies to email about a request.  Requests made to no city in
+// particular, or whose city can't be looked up, use the platform sender.
+func cityOf(request repository.Request) repository.City {
+	if request.CityID == "" {
+		return repository.City{}
+	}
+
+	city, err := repository.GetCity(request.CityID)
+	if err != nil {
+		warningLogger.Printf("Unable to get city %s of request %s, using platform defaults: %s", request.CityID, request.ServiceRequestID, err)
+		return repository.City{}
+	}
+	return city
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestRecipients(t *testing.T) {
+	potholes := repository.Agency{ID: "potholes"}
+	streets := repository.Agency{ID: "streets", Emails: []string{"streets@troyny.gov"}}
+	publicWorks := repository.Agency{ID: "public-works", SlackWebhookURL: "https://hooks.slack.com/services/T/B/X", NotifySubagencies: true}
+	mayor := repository.Agency{ID: "mayor", NotifySubagencies: true}
+
+	tests := []struct {
+		name  string
+		chain []repository.Agency
+		want  []string
+	}{
+		{"agency with channels", []repository.Agency{streets}, []string{"streets"}},
+		{"division without channels", []repository.Agency{potholes, streets}, []string{"streets"}},
+		{"department hearing of its divisions", []repository.Agency{streets, publicWorks}, []string{"streets", "public-works"}},
+		{"covered by a department hearing of its divisions", []repository.Agency{potholes, publicWorks}, []string{"public-works"}},
+		{"department without channels", []repository.Agency{streets, mayor}, []string{"streets"}},
+		{"no channels", []repository.Agency{potholes}, []string{}},
+	}
+	for _, tt := range tests {
+		got := recipients(tt.chain)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: recipients() = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i].ID != tt.want[i] {
+				t.Errorf("%s: recipients()[%d] = %s, want %s", tt.name, i, got[i].ID, tt.want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/social-torch/open311-services/metrics"
+	"github.com/social-torch/open311-services/notification"
+	"github.com/social-torch/open311-services/repository"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+
+// anomalyWindow is the trailing window volume is judged over.  A whole day evens out the quiet of the night, which
+// would look like a drought hour by hour.
+const anomalyWindow = 24 * time.Hour
+
+// handler runs hourly, comparing the requests each city received over the last day with the days before, and
+// alerting the platform team to services with abnormal spikes or droughts
+func handler(event events.CloudWatchEvent) error {
+	cities, err := repository.GetCities()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	start := now.Add(-anomalyWindow)
+	failed := 0
+
+	for _, city := range cities {
+		// Federated cities take requests on their own servers, paused cities are expected to be quiet, and cities
+		// pinned to another region are analyzed by the stack there
+		if city.Federated || city.Deactivated || city.DataRegion() != repository.DataRegion() {
+			continue
+		}
+
+		err := analyze(city, start, now)
+		if err != nil {
+			// One city's failure should not hold back everyone else's alerts
+			warningLogger.Printf("Unable to analyze the volume of %s: %s", city.CityName, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("volume of %d cities not analyzed", failed)
+	}
+	return nil
+}
+
+// analyze looks for anomalies in the requests a city received from start to now, counting each as
+// VolumeAnomalies and alerting the platform team the first time it is seen in a day
+func analyze(city repository.City, start time.Time, now time.Time) error {
+	recent, err := repository.GetRecentRequests(city.CityName, start)
+	if err != nil {
+		return err
+	}
+	baseline, err := repository.GetDailyStats(city.CityName, repository.BaselineDays(start, city.Config.Location()))
+	if err != nil {
+		return err
+	}
+
+	for _, anomaly := range repository.Anomalies(recent, baseline, anomalyWindow) {
+		metrics.Count("VolumeAnomalies", metrics.Dimension{Name: "City", Value: city.CityName}, metrics.Dimension{Name: "Kind", Value: anomaly.Kind})
+
+		// The window slides every hour, so an anomaly lasting all day is only alerted once
+		day := now.In(city.Config.Location()).Format("2006-01-02")
+		seen, err := repository.IncrementCounter(fmt.Sprintf("anomaly#%s#%s#%s#%s", city.CityName, anomaly.Kind, anomaly.ServiceCode, day), 1)
+		if err != nil {
+			return err
+		}
+		if seen > 1 {
+			continue
+		}
+
+		infoLogger.Printf("%s of %s in %s: %d requests, %.1f expected", anomaly.Kind, subject(anomaly), city.CityName, anomaly.Count, anomaly.Expected)
+		alert(city, anomaly)
+	}
+	return nil
+}
+
+// subject names what an anomaly is about, a service or all requests
+func subject(anomaly repository.Anomaly) string {
+	if anomaly.ServiceCode == "" {
+		return "all requests"
+	}
+	return anomaly.ServiceCode
+}
+
+// alert tells the platform team about an anomaly by email to PLATFORM_ADMIN_EMAILS (comma separated) and on the
+// Slack channel of PLATFORM_SLACK_WEBHOOK_URL.  Failures are logged, as the anomaly is still counted.
+func alert(city repository.City, anomaly repository.Anomaly) {
+	for _, address := range strings.Split(os.Getenv("PLATFORM_ADMIN_EMAILS"), ",") {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+		_, err := notification.SendEmail(notification.Sender(repository.City{}), address, notification.VolumeAnomalyTemplate,
+			map[string]string{
+				"city":     city.CityName,
+				"kind":     anomaly.Kind,
+				"service":  subject(anomaly),
+				"count":    fmt.Sprint(anomaly.Count),
+				"expected": fmt.Sprintf("%.1f", anomaly.Expected),
+				"window":   "24 hours",
+			})
+		if err != nil {
+			warningLogger.Println(err)
+		}
+	}
+
+	if webhookURL := os.Getenv("PLATFORM_SLACK_WEBHOOK_URL"); webhookURL != "" {
+		text := fmt.Sprintf("Volume %s in %s: %d requests for %s in the last 24 hours, %.1f expected",
+			anomaly.Kind, city.CityName, anomaly.Count, subject(anomaly), anomaly.Expected)
+		err := notification.PostSlack(webhookURL, text)
+		if err != nil {
+			warningLogger.Println(err)
+		}
+	}
+}
+
+func main() {
+	lambda.Start(handler)
+}
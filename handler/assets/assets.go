@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/apierror"
+	"github.com/social-torch/open311-services/auth"
+	"github.com/social-torch/open311-services/metrics"
+	"github.com/social-torch/open311-services/repository"
+	"github.com/social-torch/open311-services/warmup"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+var errorLogger = log.New(os.Stderr, "ERROR\t", log.Lshortfile)
+
+// Radius, in meters, of a nearby search when none is given, and the largest allowed.  Assets are picked from a
+// short list, so the search stays within sight of the reporter.
+const (
+	defaultNearbyRadius = 50
+	maxNearbyRadius     = 500
+)
+
+// Route requests
+func router(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	switch req.HTTPMethod {
+	case "GET":
+		if req.Resource == "/assets/nearby" {
+			return getNearbyAssets(req)
+		}
+
+	case "PUT":
+		if req.Resource == "/city/{id}/asset/{asset_id}" {
+			id := req.PathParameters["id"]
+			return putAsset(id, req)
+		}
+	}
+	return clientError(http.StatusMethodNotAllowed, errors.New("method must be 'GET' or 'PUT'"))
+}
+
+func getNearbyAssets(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	lat, errLat := strconv.ParseFloat(req.QueryStringParameters["lat"], 64)
+	lon, errLon := strconv.ParseFloat(req.QueryStringParameters["lon"], 64)
+	if errLat != nil || errLon != nil {
+		return clientError(http.StatusBadRequest, errors.New("lat and lon must be specified in decimal degrees"))
+	}
+	if _, err := repository.NewLocation(lat, lon); err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+
+	radius := float64(defaultNearbyRadius)
+	if v, ok := req.QueryStringParameters["radius"]; ok {
+		r, err := strconv.ParseFloat(v, 64)
+		if err != nil || r <= 0 || r > maxNearbyRadius {
+			return clientError(http.StatusBadRequest, fmt.Errorf("radius must be between 0 and %d meters", maxNearbyRadius))
+		}
+		radius = r
+	}
+
+	assets, err := repository.GetNearbyAssets(lat, lon, radius, req.QueryStringParameters["type"])
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	body, err := json.Marshal(assets)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling GetNearbyAssets() struct"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+func putAsset(city string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !auth.IsAdminOf(city, req) {
+		return clientError(http.StatusForbidden, fmt.Errorf("assets of %s may only be managed by its city admins", city))
+	}
+
+	var asset repository.Asset
+	err := json.Unmarshal([]byte(req.Body), &asset)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, errors.New("error unmarshalling asset JSON. Check syntax"))
+	}
+
+	asset.City = city
+	asset.ID = req.PathParameters["asset_id"]
+	if asset.Type == "" || asset.Label == "" {
+		return clientError(http.StatusBadRequest, errors.New("type and label must be specified"))
+	}
+	if !asset.HasLocation() {
+		return clientError(http.StatusBadRequest, errors.New("lat and lon must be specified"))
+	}
+	err = asset.ValidateLocation()
+	if err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+
+	err = repository.PutAsset(asset)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	body, err := json.Marshal(asset)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("unable to marshal JSON for response"))
+	}
+
+	infoLogger.Printf("%s %s of %s registered", asset.Type, asset.ID, city)
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+func serverError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
+	errorLogger.Println(err.Error())
+	return apierror.Response(statusCode, err), nil
+}
+
+func clientError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
+	warningLogger.Println(err.Error())
+	return apierror.Response(statusCode, err), nil
+}
+
+func main() {
+	warmup.Start("assets", metrics.Handler("assets", apierror.Handler(repository.Audit(router))), repository.Warm)
+}
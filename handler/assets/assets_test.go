@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestGetNearbyAssetsInvalid(t *testing.T) {
+	tests := []struct {
+		params map[string]string
+		want   int
+	}{
+		{map[string]string{}, http.StatusBadRequest},
+		{map[string]string{"lat": "42.65"}, http.StatusBadRequest},
+		{map[string]string{"lat": "north", "lon": "-73.75"}, http.StatusBadRequest},
+		{map[string]string{"lat": "0", "lon": "0"}, http.StatusBadRequest},
+		{map[string]string{"lat": "91", "lon": "-73.75"}, http.StatusBadRequest},
+		{map[string]string{"lat": "42.65", "lon": "-73.75", "radius": "0"}, http.StatusBadRequest},
+		{map[string]string{"lat": "42.65", "lon": "-73.75", "radius": "501"}, http.StatusBadRequest},
+		{map[string]string{"lat": "42.65", "lon": "-73.75", "radius": "far"}, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		req := events.APIGatewayProxyRequest{HTTPMethod: "GET", Resource: "/assets/nearby", QueryStringParameters: test.params}
+		resp, err := router(req)
+		if err != nil || resp.StatusCode != test.want {
+			t.Errorf("router(%v) = %d, %v, want %d", test.params, resp.StatusCode, err, test.want)
+		}
+	}
+}
+
+func TestPutAssetInvalid(t *testing.T) {
+	admin := map[string]interface{}{"cognito:groups": "[city_admin]", "custom:city": "Troy"}
+	otherCity := map[string]interface{}{"cognito:groups": "[city_admin]", "custom:city": "Albany"}
+	resident := map[string]interface{}{"cognito:groups": "[residents]", "custom:city": "Troy"}
+
+	tests := []struct {
+		claims map[string]interface{}
+		body   string
+		want   int
+	}{
+		{resident, `{"type":"streetlight","label":"SL-1","lat":42.73,"lon":-73.69}`, http.StatusForbidden},
+		{otherCity, `{"type":"streetlight","label":"SL-1","lat":42.73,"lon":-73.69}`, http.StatusForbidden},
+		{admin, `{"type":`, http.StatusUnprocessableEntity},
+		{admin, `{"label":"SL-1","lat":42.73,"lon":-73.69}`, http.StatusBadRequest},
+		{admin, `{"type":"streetlight","lat":42.73,"lon":-73.69}`, http.StatusBadRequest},
+		{admin, `{"type":"streetlight","label":"SL-1"}`, http.StatusBadRequest},
+		{admin, `{"type":"streetlight","label":"SL-1","lat":42.73,"lon":-190}`, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		req := events.APIGatewayProxyRequest{
+			HTTPMethod:     "PUT",
+			Resource:       "/city/{id}/asset/{asset_id}",
+			PathParameters: map[string]string{"id": "Troy", "asset_id": "SL-1"},
+			Body:           test.body,
+		}
+		req.RequestContext.Authorizer = map[string]interface{}{"claims": test.claims}
+		resp, err := router(req)
+		if err != nil || resp.StatusCode != test.want {
+			t.Errorf("router(%s) = %d, %v, want %d", test.body, resp.StatusCode, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/apierror"
+	"github.com/social-torch/open311-services/chat"
+	"github.com/social-torch/open311-services/metrics"
+	"github.com/social-torch/open311-services/repository"
+	"github.com/social-torch/open311-services/warmup"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+var errorLogger = log.New(os.Stderr, "ERROR\t", log.Lshortfile)
+
+// Route requests.  Callers are authenticated by the signatures of Slack and of action links, not Cognito.
+func router(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	switch req.HTTPMethod {
+	case "GET":
+		if req.Resource == "/integrations/teams/action" {
+			return confirmTeamsAction(req)
+		}
+
+	case "POST":
+		if req.Resource == "/integrations/slack/actions" {
+			return slackAction(req)
+		}
+
+		if req.Resource == "/integrations/teams/action" {
+			return teamsAction(req)
+		}
+	}
+	return clientError(http.StatusMethodNotAllowed, errors.New("method must be 'GET' or 'POST'"))
+}
+
+// slackPayload is the part of a Slack block_actions payload acted on
+type slackPayload struct {
+	Type string `json:"type"`
+	User struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+	Actions []struct {
+		ActionID string `json:"action_id"`
+		BlockID  string `json:"block_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+	ResponseURL string `json:"response_url"`
+}
+
+// slackAction takes the action of a button pressed on a Slack card, replacing the card with the updated request
+func slackAction(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	body := req.Body
+	if req.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return clientError(http.StatusBadRequest, errors.New("body is not base64"))
+		}
+		body = string(decoded)
+	}
+
+	err := chat.VerifySlack(os.Getenv("SLACK_SIGNING_SECRET"), header(req, "X-Slack-Request-Timestamp"), body, header(req, "X-Slack-Signature"), time.Now())
+	if err != nil {
+		return clientError(http.StatusUnauthorized, err)
+	}
+
+	form, err := url.ParseQuery(body)
+	if err != nil {
+		return clientError(http.StatusBadRequest, errors.New("body is not form encoded"))
+	}
+	var payload slackPayload
+	err = json.Unmarshal([]byte(form.Get("payload")), &payload)
+	if err != nil {
+		return clientError(http.StatusBadRequest, errors.New("error unmarshalling Slack payload"))
+	}
+	if payload.Type != "block_actions" || len(payload.Actions) == 0 {
+		return ok("")
+	}
+
+	action := payload.Actions[0]
+	request, err := act(chat.SlackActionCity(action.BlockID), action.Value, action.ActionID, "slack:"+payload.User.ID)
+	if err != nil {
+		switch err.(type) {
+		case *repository.RequestIdNotFoundErr, *repository.RequestChangedErr, *actionErr:
+			// Slack shows nothing of the response to a button, so the user is told in the channel
+			warningLogger.Println(err)
+			if err := chat.Post(payload.ResponseURL, map[string]interface{}{"response_type": "ephemeral", "replace_original": false, "text": err.Error()}); err != nil {
+				warningLogger.Println(err)
+			}
+			return ok("")
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	card := chat.Card{
+		Title:   fmt.Sprintf("%s request %s by %s", request.ServiceName, request.Status, slackName(payload.User.Username, payload.User.ID)),
+		Request: request,
+		Link:    requestLink(request.ServiceRequestID),
+	}
+	message := chat.SlackMessage(card)
+	message["replace_original"] = true
+	if err := chat.Post(payload.ResponseURL, message); err != nil {
+		warningLogger.Println(err)
+	}
+	return ok("")
+}
+
+// confirmTeamsAction asks for confirmation before taking the action of a button pressed on a Teams card.  Links
+// are opened by link previews and crawlers too, so the action is only taken when the form is submitted.
+func confirmTeamsAction(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	params := req.QueryStringParameters
+	err := chat.VerifyActionLink(os.Getenv("CHAT_ACTION_SECRET"), params, time.Now())
+	if err != nil {
+		return page(http.StatusForbidden, "This link has expired. Open the request on the dashboard instead.")
+	}
+
+	request, err := repository.GetRequest(params["city"], params["request"])
+	if err != nil {
+		switch err.(type) {
+		case *repository.RequestIdNotFoundErr:
+			return page(http.StatusNotFound, "This request no longer exists.")
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	query := url.Values{}
+	for name, value := range params {
+		query.Set(name, value)
+	}
+	return page(http.StatusOK, fmt.Sprintf(`%s request %s is %s.</p>
+<form method="post" action="?%s"><button type="submit">%s request</button></form><p>`,
+		html.EscapeString(request.ServiceName), html.EscapeString(request.ServiceRequestID), html.EscapeString(request.Status),
+		html.EscapeString(query.Encode()), html.EscapeString(actionTitle(params["action"]))))
+}
+
+// teamsAction takes the action of a confirmed button pressed on a Teams card
+func teamsAction(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	params := req.QueryStringParameters
+	err := chat.VerifyActionLink(os.Getenv("CHAT_ACTION_SECRET"), params, time.Now())
+	if err != nil {
+		return page(http.StatusForbidden, "This link has expired. Open the request on the dashboard instead.")
+	}
+
+	// Teams doesn't say who opened a link, so the change is credited to the channel's integration
+	request, err := act(params["city"], params["request"], params["action"], "teams")
+	if err != nil {
+		switch err.(type) {
+		case *repository.RequestIdNotFoundErr:
+			return page(http.StatusNotFound, "This request no longer exists.")
+		case *repository.RequestChangedErr, *actionErr:
+			return page(http.StatusConflict, html.EscapeString(err.Error()))
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+	return page(http.StatusOK, fmt.Sprintf("%s request %s is now %s.",
+		html.EscapeString(request.ServiceName), html.EscapeString(request.ServiceRequestID), html.EscapeString(request.Status)))
+}
+
+// actionErr is returned by act for actions that can't be taken on a request
+type actionErr struct {
+	message string
+}
+
+func (e *actionErr) Error() string {
+	return e.message
+}
+
+// act takes an action on a request of a city through the same update as the status API, crediting actor in the
+// audit log
+func act(cityID string, requestID string, action string, actor string) (repository.Request, error) {
+	status, known := chat.ActionStatus(action)
+	if !known {
+		return repository.Request{}, &actionErr{fmt.Sprintf("unknown action '%s'", action)}
+	}
+
+	request, err := repository.GetRequest(cityID, requestID)
+	if err != nil {
+		return request, err
+	}
+	allowed := false
+	for _, a := range chat.Actions(request) {
+		allowed = allowed || a == action
+	}
+	if !allowed {
+		return request, &actionErr{fmt.Sprintf("request %s is already %s", request.ServiceRequestID, request.Status)}
+	}
+
+	stored := request
+	request.Status = status
+	repository.AuditActor(actor)
+	_, err = repository.UpdateRequest(request, stored, actor)
+	if err != nil {
+		return request, err
+	}
+	infoLogger.Printf("Request %s %s by %s", request.ServiceRequestID, request.Status, actor)
+
+	return repository.GetRequest(request.CityID, request.ServiceRequestID)
+}
+
+// actionTitle labels the button confirming an action
+func actionTitle(action string) string {
+	switch action {
+	case chat.ActionAccept:
+		return "Accept"
+	case chat.ActionClose:
+		return "Close"
+	}
+	return action
+}
+
+// slackName returns how a Slack user is mentioned on a card
+func slackName(username string, id string) string {
+	if id != "" {
+		return "<@" + id + ">"
+	}
+	return username
+}
+
+// requestLink deep links into the city dashboard when DASHBOARD_URL is configured, and to the API otherwise
+func requestLink(id string) string {
+	if dashboard := os.Getenv("DASHBOARD_URL"); dashboard != "" {
+		return strings.TrimRight(dashboard, "/") + "/requests/" + id
+	}
+	return os.Getenv("API_URL") + "/request/" + id
+}
+
+// header returns a header of a call regardless of the case it was sent in
+func header(req events.APIGatewayProxyRequest, name string) string {
+	for key, value := range req.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+func ok(body string) (events.APIGatewayProxyResponse, error) {
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusOK, Body: body}, nil
+}
+
+// page answers a browser opening an action link with a minimal HTML page around message
+func page(statusCode int, message string) (events.APIGatewayProxyResponse, error) {
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers:    map[string]string{"content-type": "text/html; charset=utf-8"},
+		Body:       "<!DOCTYPE html><html><head><meta name=\"viewport\" content=\"width=device-width\"><title>Open311</title></head><body><p>" + message + "</p></body></html>",
+	}, nil
+}
+
+func serverError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
+	errorLogger.Println(err.Error())
+	return apierror.Response(statusCode, err), nil
+}
+
+func clientError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
+	warningLogger.Println(err.Error())
+	return apierror.Response(statusCode, err), nil
+}
+
+func main() {
+	warmup.Start("chat", metrics.Handler("chat", apierror.Handler(repository.Audit(router))), repository.Warm)
+}
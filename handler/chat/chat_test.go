@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestHeader(t *testing.T) {
+	req := events.APIGatewayProxyRequest{Headers: map[string]string{"x-slack-signature": "v0=abc"}}
+	if got := header(req, "X-Slack-Signature"); got != "v0=abc" {
+		t.Errorf("header() = %q, want v0=abc", got)
+	}
+	if got := header(req, "X-Slack-Request-Timestamp"); got != "" {
+		t.Errorf("header() of a missing header = %q, want empty", got)
+	}
+}
+
+func TestUnsignedCallbacks(t *testing.T) {
+	resp, _ := router(events.APIGatewayProxyRequest{HTTPMethod: "POST", Resource: "/integrations/slack/actions", Body: "payload=%7B%7D"})
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unsigned Slack callback = %d, want 401", resp.StatusCode)
+	}
+
+	resp, _ = router(events.APIGatewayProxyRequest{HTTPMethod: "POST", Resource: "/integrations/teams/action",
+		QueryStringParameters: map[string]string{"request": "SR-1", "action": "close", "expires": "9999999999", "signature": "forged"}})
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("forged Teams action = %d, want 403", resp.StatusCode)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/auth"
+	"github.com/social-torch/open311-services/repository"
+)
+
+// Audit log listings: the days covered when no start_date is given, and how many entries are returned by default
+// and at most
+const (
+	defaultAuditDays  = 30
+	defaultAuditLimit = 500
+	maxAuditLimit     = 1000
+)
+
+// getAuditLog lists the writes made to a city's records, newest first, for public-records requests.  Writes to
+// records of no city in particular are listed under the city "platform", for platform admins.
+func getAuditLog(city string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !auth.IsAdminOf(city, req) && !auth.InGroup(req, auth.PlatformAdminGroup) {
+		return clientError(http.StatusForbidden, fmt.Errorf("the audit log of %s may only be seen by its city admins and platform admins", city))
+	}
+
+	end := time.Now()
+	if v := req.QueryStringParameters["end_date"]; v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return clientError(http.StatusBadRequest, errors.New("end_date must be a w3 datetime, eg 2019-06-02T12:00:00Z"))
+		}
+		end = t
+	}
+	start := end.AddDate(0, 0, -defaultAuditDays)
+	if v := req.QueryStringParameters["start_date"]; v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil || t.After(end) {
+			return clientError(http.StatusBadRequest, errors.New("start_date must be a w3 datetime before end_date, eg 2019-06-01T00:00:00Z"))
+		}
+		start = t
+	}
+
+	limit := defaultAuditLimit
+	if v, ok := req.QueryStringParameters["limit"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAuditLimit {
+			return clientError(http.StatusBadRequest, fmt.Errorf("limit must be between 1 and %d", maxAuditLimit))
+		}
+		limit = n
+	}
+
+	entries, truncated, err := repository.GetAuditLog(city, start, end, req.QueryStringParameters["table"], req.QueryStringParameters["actor"], limit)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	body, err := json.Marshal(entries)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling GetAuditLog() struct"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*", "X-Truncated": strconv.FormatBool(truncated)},
+		Body:       string(body),
+	}, nil
+}
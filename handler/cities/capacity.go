@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/auth"
+	"github.com/social-torch/open311-services/metrics"
+)
+
+// Capacity reports: the days covered by default, and at most.  CloudWatch keeps minute data for 15 days, and
+// coarser data long after, so longer reports are only a little less precise.
+const (
+	defaultCapacityDays = 7
+	maxCapacityDays     = 90
+)
+
+// routeCapacity is the capacity consumed, and the calls throttled, answering one route or running one function
+type routeCapacity struct {
+	Route         string  `json:"route"`
+	ReadCapacity  float64 `json:"read_capacity"`
+	WriteCapacity float64 `json:"write_capacity"`
+	Throttles     float64 `json:"throttles"`
+}
+
+// tableCapacity is the capacity consumed, and the calls throttled, on one table, with the routes responsible
+type tableCapacity struct {
+	Table         string          `json:"table"`
+	ReadCapacity  float64         `json:"read_capacity"`
+	WriteCapacity float64         `json:"write_capacity"`
+	Throttles     float64         `json:"throttles"`
+	Routes        []routeCapacity `json:"routes"`
+}
+
+// getCapacity reports the DynamoDB capacity units the platform's tables consumed over the last days, and the calls
+// throttled, broken down by the API route or function responsible, so operators see which scans are burning the
+// budget before the bill arrives.  Only platform admins may see it.
+func getCapacity(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !auth.InGroup(req, auth.PlatformAdminGroup) {
+		return clientError(http.StatusForbidden, errors.New("table capacity may only be seen by platform admins"))
+	}
+
+	n := defaultCapacityDays
+	if v, ok := req.QueryStringParameters["days"]; ok {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 1 || d > maxCapacityDays {
+			return clientError(http.StatusBadRequest, fmt.Errorf("days must be between 1 and %d", maxCapacityDays))
+		}
+		n = d
+	}
+	end := time.Now()
+	start := end.AddDate(0, 0, -n)
+
+	totals := map[string][]metrics.Total{}
+	for _, name := range []string{"ConsumedReadCapacity", "ConsumedWriteCapacity", "DynamoDBThrottles"} {
+		t, err := metrics.Totals(name, []string{"Table", "Route"}, start, end)
+		if err != nil {
+			return serverError(http.StatusInternalServerError, err)
+		}
+		totals[name] = t
+	}
+
+	body, err := json.Marshal(capacityReport(totals["ConsumedReadCapacity"], totals["ConsumedWriteCapacity"], totals["DynamoDBThrottles"]))
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling capacityReport() struct"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// capacityReport totals the capacity consumed and calls throttled by table and route, costliest tables and routes
+// first
+func capacityReport(reads []metrics.Total, writes []metrics.Total, throttles []metrics.Total) []tableCapacity {
+	tables := map[string]*tableCapacity{}
+	routes := map[[2]string]*routeCapacity{}
+	add := func(totals []metrics.Total, field func(t *tableCapacity, r *routeCapacity, sum float64)) {
+		for _, total := range totals {
+			table, route := total.Dimensions["Table"], total.Dimensions["Route"]
+			if tables[table] == nil {
+				tables[table] = &tableCapacity{Table: table, Routes: []routeCapacity{}}
+			}
+			if routes[[2]string{table, route}] == nil {
+				routes[[2]string{table, route}] = &routeCapacity{Route: route}
+			}
+			field(tables[table], routes[[2]string{table, route}], total.Sum)
+		}
+	}
+	add(reads, func(t *tableCapacity, r *routeCapacity, sum float64) { t.ReadCapacity += sum; r.ReadCapacity += sum })
+	add(writes, func(t *tableCapacity, r *routeCapacity, sum float64) { t.WriteCapacity += sum; r.WriteCapacity += sum })
+	add(throttles, func(t *tableCapacity, r *routeCapacity, sum float64) { t.Throttles += sum; r.Throttles += sum })
+
+	for key, route := range routes {
+		tables[key[0]].Routes = append(tables[key[0]].Routes, *route)
+	}
+
+	report := []tableCapacity{}
+	for _, table := range tables {
+		sort.Slice(table.Routes, func(i, j int) bool {
+			ci, cj := table.Routes[i].ReadCapacity+table.Routes[i].WriteCapacity, table.Routes[j].ReadCapacity+table.Routes[j].WriteCapacity
+			if ci != cj {
+				return ci > cj
+			}
+			return table.Routes[i].Route < table.Routes[j].Route
+		})
+		report = append(report, *table)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		ci, cj := report[i].ReadCapacity+report[i].WriteCapacity, report[j].ReadCapacity+report[j].WriteCapacity
+		if ci != cj {
+			return ci > cj
+		}
+		return report[i].Table < report[j].Table
+	})
+	return report
+}
@@ -7,10 +7,20 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/social-torch/open311-services/apierror"
+	"github.com/social-torch/open311-services/auth"
+	"github.com/social-torch/open311-services/catalog"
+	"github.com/social-torch/open311-services/geo"
+	"github.com/social-torch/open311-services/metrics"
+	"github.com/social-torch/open311-services/notification"
 	"github.com/social-torch/open311-services/repository"
+	"github.com/social-torch/open311-services/warmup"
 )
 
 var infoLogger = log.New(os.Stdout, "INFO\t", 0)
@@ -30,12 +40,154 @@ func router(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse,
 			return getCities()
 		}
 
+		if req.Resource == "/cities/locate" {
+			return locateCity(req)
+		}
+
+		if req.Resource == "/cities/endpoints.json" {
+			return getEndpoints(req)
+		}
+
+		if req.Resource == "/cities/capacity" {
+			return getCapacity(req)
+		}
+
+		if req.Resource == "/city/{id}/stats" {
+			id := req.PathParameters["id"]
+			return getStats(id, req)
+		}
+
+		if req.Resource == "/city/{id}/agencies" {
+			id := req.PathParameters["id"]
+			return getAgencies(id, req)
+		}
+
+		if req.Resource == "/city/{id}/audit" {
+			id := req.PathParameters["id"]
+			return getAuditLog(id, req)
+		}
+
+		if req.Resource == "/city/{id}/engagement" {
+			id := req.PathParameters["id"]
+			if !auth.IsAdminOf(id, req) && !auth.InGroup(req, auth.PlatformAdminGroup) {
+				return clientError(http.StatusForbidden, fmt.Errorf("the engagement of %s may only be seen by its city admins and platform admins", id))
+			}
+			return getEngagement(id, req)
+		}
+
+		if req.Resource == "/city/{id}/workload" {
+			id := req.PathParameters["id"]
+			return getWorkloads(id, req)
+		}
+
+		if req.Resource == "/city/{id}/agency/{agency_id}/workload" {
+			id := req.PathParameters["id"]
+			return getWorkload(id, req.PathParameters["agency_id"], req)
+		}
+
+		if req.Resource == "/city/{id}/agency/{agency_id}/schedule.ics" {
+			id := req.PathParameters["id"]
+			return getAgencySchedule(id, req.PathParameters["agency_id"])
+		}
+
+		if req.Resource == "/city/{id}/templates" {
+			id := req.PathParameters["id"]
+			return getTemplates(id, req)
+		}
+
+		if req.Resource == "/city/onboard" {
+			if !auth.InGroup(req, auth.PlatformAdminGroup) {
+				return clientError(http.StatusForbidden, errors.New("onboarding requests may only be reviewed by platform admins"))
+			}
+			return getOnboardingRequests(req)
+		}
+
+		if req.Resource == "/city/catalogs" {
+			return getCatalogTemplates()
+		}
+
+		if req.Resource == "/city/onboard/{id}" {
+			if !auth.InGroup(req, auth.PlatformAdminGroup) {
+				return clientError(http.StatusForbidden, errors.New("onboarding requests may only be reviewed by platform admins"))
+			}
+			id := req.PathParameters["id"]
+			return getOnboardingRequest(id)
+		}
+
 	case "POST":
 		if req.Resource == "/city/onboard" {
 			return submitRequest(req)
 		}
+
+		if req.Resource == "/city/onboard/{id}/approve" {
+			if !auth.InGroup(req, auth.PlatformAdminGroup) {
+				return clientError(http.StatusForbidden, errors.New("onboarding requests may only be approved by platform admins"))
+			}
+			id := req.PathParameters["id"]
+			return approveOnboardingRequest(id, req)
+		}
+
+		if req.Resource == "/city/{id}/catalog/{template}" {
+			id := req.PathParameters["id"]
+			return applyCatalogTemplate(id, req.PathParameters["template"], req)
+		}
+
+		if req.Resource == "/city/{id}/deactivate" || req.Resource == "/city/{id}/activate" {
+			if !auth.InGroup(req, auth.PlatformAdminGroup) {
+				return clientError(http.StatusForbidden, errors.New("cities may only be deactivated and activated by platform admins"))
+			}
+			id := req.PathParameters["id"]
+			return setDeactivated(id, req.Resource == "/city/{id}/deactivate", req)
+		}
+
+	case "PUT":
+		if req.Resource == "/city/{id}" {
+			if !auth.InGroup(req, auth.PlatformAdminGroup) {
+				return clientError(http.StatusForbidden, errors.New("city records may only be updated by platform admins"))
+			}
+			id := req.PathParameters["id"]
+			return updateCity(id, req)
+		}
+
+		if req.Resource == "/city/{id}/template/{name}/{language}" {
+			id := req.PathParameters["id"]
+			return putTemplate(id, req)
+		}
+
+		if req.Resource == "/city/{id}/neighborhoods" {
+			id := req.PathParameters["id"]
+			return putNeighborhoods(id, req)
+		}
+
+		if req.Resource == "/city/{id}/boundary" {
+			id := req.PathParameters["id"]
+			return putBoundary(id, req)
+		}
+
+		if req.Resource == "/city/{id}/config" {
+			id := req.PathParameters["id"]
+			return putConfig(id, req)
+		}
+
+		if req.Resource == "/city/{id}/agency/{agency_id}" {
+			id := req.PathParameters["id"]
+			return putAgency(id, req.PathParameters["agency_id"], req)
+		}
+
+		if req.Resource == "/city/{id}/routing" {
+			id := req.PathParameters["id"]
+			return putRouting(id, req)
+		}
+	case "PATCH":
+		if req.Resource == "/city/onboard/{id}" {
+			if !auth.InGroup(req, auth.PlatformAdminGroup) {
+				return clientError(http.StatusForbidden, errors.New("onboarding requests may only be updated by platform admins"))
+			}
+			id := req.PathParameters["id"]
+			return updateOnboardingRequest(id, req)
+		}
 	}
-	return clientError(http.StatusMethodNotAllowed, errors.New("method must be 'GET' or 'POST'"))
+	return clientError(http.StatusMethodNotAllowed, errors.New("method must be 'GET', 'POST', 'PUT' or 'PATCH'"))
 
 }
 
@@ -81,6 +233,163 @@ func getCities() (events.APIGatewayProxyResponse, error) {
 	}, nil
 }
 
+// Days summarized by GET /city/{id}/stats when none are given, and the most allowed
+const (
+	defaultStatsDays = 30
+	maxStatsDays     = 366
+)
+
+// topServices is how many of the most requested services the stats list
+const topServices = 5
+
+// getStats summarizes a city's requests over the last days, read from the counters the stream processor keeps
+func getStats(id string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	n := defaultStatsDays
+	if v, ok := req.QueryStringParameters["days"]; ok {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 1 || d > maxStatsDays {
+			return clientError(http.StatusBadRequest, fmt.Errorf("days must be between 1 and %d", maxStatsDays))
+		}
+		n = d
+	}
+
+	config, err := repository.GetCityConfig(id)
+	if err != nil {
+		switch err.(type) {
+		case *repository.CityNotFoundErr:
+			errorMessage := fmt.Errorf("%s.  city_name '%s' not in database", err, id)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	daily, err := repository.GetDailyStats(id, statsDays(time.Now().In(config.Location()), n))
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+	open, err := repository.GetOpenRequests(id)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	stats := repository.SummarizeStats(daily, topServices)
+	stats.CityID = id
+	stats.Open = open
+
+	body, err := json.Marshal(stats)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling SummarizeStats() struct"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// getEngagement measures how residents of a city took part over the last days days: signups, reporters and
+// subscriptions
+func getEngagement(id string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	n := defaultStatsDays
+	if v, ok := req.QueryStringParameters["days"]; ok {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 1 || d > maxStatsDays {
+			return clientError(http.StatusBadRequest, fmt.Errorf("days must be between 1 and %d", maxStatsDays))
+		}
+		n = d
+	}
+
+	config, err := repository.GetCityConfig(id)
+	if err != nil {
+		switch err.(type) {
+		case *repository.CityNotFoundErr:
+			errorMessage := fmt.Errorf("%s.  city_name '%s' not in database", err, id)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	today := time.Now().In(config.Location())
+	daily, err := repository.GetDailyStats(id, statsDays(today, n))
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	// Reporters are distinct accounts, which daily counters can't add up, so the period's requests are read
+	start := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location()).AddDate(0, 0, 1-n)
+	requests, err := repository.GetRecentRequests(id, start)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	engagement := repository.NewEngagement(daily, requests)
+	engagement.CityID = id
+
+	body, err := json.Marshal(engagement)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling NewEngagement() struct"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// statsDays returns the n days ending today, oldest first, as YYYY-MM-DD
+func statsDays(today time.Time, n int) []string {
+	days := make([]string, n)
+	for i := range days {
+		days[i] = today.AddDate(0, 0, i-n+1).Format("2006-01-02")
+	}
+	return days
+}
+
+// cityLocation is the city serving a location, with what the app needs to start using it
+type cityLocation struct {
+	CityName  string                `json:"city_name"`
+	Endpoint  string                `json:"endpoint"`
+	Federated bool                  `json:"federated"`
+	Config    repository.CityConfig `json:"config"`
+}
+
+// locateCity finds the city serving the lat and lon query parameters, so the app can pick the user's city for them
+func locateCity(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	lat, errLat := strconv.ParseFloat(req.QueryStringParameters["lat"], 64)
+	lon, errLon := strconv.ParseFloat(req.QueryStringParameters["lon"], 64)
+	if errLat != nil || errLon != nil {
+		return clientError(http.StatusBadRequest, errors.New("lat and lon must be specified in decimal degrees"))
+	}
+	if _, err := repository.NewLocation(lat, lon); err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+
+	city, err := repository.FindCity(lat, lon)
+	if err != nil {
+		switch err.(type) {
+		case *repository.CityNotFoundErr:
+			return clientError(http.StatusNotFound, fmt.Errorf("%s. Pick a city from GET /cities", err))
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	body, err := json.Marshal(cityLocation{city.CityName, city.Endpoint, city.Federated, city.Config})
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling FindCity() struct"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
 func submitRequest(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	userID := req.Headers["from"] // accountID must be added to header in client app
 	if userID == "" {             // but just in case the client app doesn't, track request as a guest
@@ -111,6 +420,21 @@ func submitRequest(req events.APIGatewayProxyRequest) (events.APIGatewayProxyRes
 
 	infoLogger.Println("New onboarding request submitted")
 
+	// The request is already stored, so failed notifications are logged rather than reported to the client
+	notifyPlatformTeam(onboardingRequest)
+
+	if onboardingRequest.Email != "" {
+		_, err = notification.SendEmail(notification.Sender(repository.City{}), onboardingRequest.Email, notification.OnboardingConfirmationTemplate,
+			map[string]string{
+				"first_name": onboardingRequest.FirstName,
+				"city":       onboardingRequest.City,
+				"state":      onboardingRequest.State,
+			})
+		if err != nil {
+			warningLogger.Println(err)
+		}
+	}
+
 	return events.APIGatewayProxyResponse{
 		StatusCode: http.StatusCreated,
 		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
@@ -118,24 +442,901 @@ func submitRequest(req events.APIGatewayProxyRequest) (events.APIGatewayProxyRes
 	}, nil
 }
 
-func serverError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
-	errorLogger.Println(err.Error())
+// getOnboardingRequests lists onboarding requests, narrowed to a status or assignee by the query parameters of
+// the same names
+func getOnboardingRequests(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	requests, err := repository.GetOnboardingRequests()
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	status := req.QueryStringParameters["status"]
+	assignee := req.QueryStringParameters["assignee"]
+	matching := []repository.OnboardingRequest{}
+	for _, r := range requests {
+		if (status == "" || r.Status == status) && (assignee == "" || r.Assignee == assignee) {
+			matching = append(matching, r)
+		}
+	}
+
+	body, err := json.Marshal(matching)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling GetOnboardingRequests() struct"))
+	}
+
 	return events.APIGatewayProxyResponse{
-		StatusCode: statusCode,
-		Headers:    map[string]string{"content-type": "text/plain"},
-		Body:       http.StatusText(statusCode) + ": " + err.Error(),
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
 	}, nil
 }
 
-func clientError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
-	warningLogger.Println(err.Error())
+func getOnboardingRequest(id string) (events.APIGatewayProxyResponse, error) {
+	onboardingRequest, err := repository.GetOnboardingRequest(id)
+	if err != nil {
+		switch err.(type) {
+		case *repository.OnboardingRequestNotFoundErr:
+			errorMessage := fmt.Errorf("%s. id '%s' not in database", err, id)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	body, err := json.Marshal(onboardingRequest)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling GetOnboardingRequest() struct"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// updateOnboardingRequest changes the status, assignee or notes of an onboarding request.  Requests are approved,
+// and go live, by provisioning their city through POST /city/onboard/{id}/approve rather than by status.
+func updateOnboardingRequest(id string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var update repository.OnboardingUpdate
+	err := json.Unmarshal([]byte(req.Body), &update)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, errors.New("error unmarshalling onboarding update JSON. Check syntax"))
+	}
+	if update.Status == nil && update.Assignee == nil && update.Notes == nil {
+		return clientError(http.StatusBadRequest, errors.New("an update needs a status, assignee or notes"))
+	}
+	if update.Notes != nil && len(*update.Notes) > maxOnboardingNotes {
+		return clientError(http.StatusBadRequest, fmt.Errorf("notes can't be longer than %d characters", maxOnboardingNotes))
+	}
+
+	if update.Status != nil {
+		onboardingRequest, err := repository.GetOnboardingRequest(id)
+		if err != nil {
+			switch err.(type) {
+			case *repository.OnboardingRequestNotFoundErr:
+				errorMessage := fmt.Errorf("%s. id '%s' not in database", err, id)
+				return clientError(http.StatusNotFound, errorMessage)
+			default:
+				return serverError(http.StatusInternalServerError, err)
+			}
+		}
+
+		code, err := checkTransition(onboardingRequest.Status, *update.Status)
+		if err != nil {
+			return clientError(code, err)
+		}
+	}
+
+	err = repository.UpdateOnboardingRequest(id, update)
+	if err != nil {
+		switch err.(type) {
+		case *repository.OnboardingRequestNotFoundErr:
+			errorMessage := fmt.Errorf("%s. id '%s' not in database", err, id)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	infoLogger.Printf("Onboarding request %s updated", id)
+	return getOnboardingRequest(id)
+}
+
+// maxOnboardingNotes bounds the platform team's notes on an onboarding request
+const maxOnboardingNotes = 10000
+
+// checkTransition checks a status change made through PATCH /city/onboard/{id}, returning the status code of the
+// response refusing it.  Requests move between received and contacted while the platform team talks with the city,
+// are rejected from either, and reopened by moving a rejection back to received.
+func checkTransition(from string, to string) (int, error) {
+	switch to {
+	case repository.OnboardingReceived, repository.OnboardingContacted, repository.OnboardingRejected:
+	case repository.OnboardingApproved, repository.OnboardingLive:
+		return http.StatusBadRequest, fmt.Errorf("requests become %s by approving them with POST /city/onboard/{id}/approve", to)
+	default:
+		return http.StatusBadRequest, fmt.Errorf("status '%s' must be received, contacted or rejected", to)
+	}
+
+	switch {
+	case from == repository.OnboardingApproved || from == repository.OnboardingLive:
+		return http.StatusConflict, fmt.Errorf("request is already %s", from)
+	case from == repository.OnboardingRejected && to == repository.OnboardingContacted:
+		return http.StatusConflict, errors.New("rejected requests are reopened by moving them back to received")
+	}
+	return http.StatusOK, nil
+}
+
+func getCatalogTemplates() (events.APIGatewayProxyResponse, error) {
+	body, err := json.Marshal(catalog.Templates())
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling catalog templates"))
+	}
+
 	return events.APIGatewayProxyResponse{
-		StatusCode: statusCode,
-		Headers:    map[string]string{"content-type": "text/plain"},
-		Body:       http.StatusText(statusCode) + ": " + err.Error(),
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
 	}, nil
 }
 
+// catalogResult is the response to applying a catalog template
+type catalogResult struct {
+	Template string `json:"template"`
+	Added    int    `json:"added"`   // Services added to the city
+	Skipped  int    `json:"skipped"` // Services the city already had
+}
+
+// applyCatalogTemplate adds the services of a catalog template to a city.  Services the city already has are
+// kept as they are, so a template can be applied again after the city has edited its services.
+func applyCatalogTemplate(city string, name string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !auth.IsAdminOf(city, req) && !auth.InGroup(req, auth.PlatformAdminGroup) {
+		return clientError(http.StatusForbidden, fmt.Errorf("catalog templates may only be applied to %s by its city admins", city))
+	}
+
+	template, ok := catalog.Get(name)
+	if !ok {
+		return clientError(http.StatusNotFound, fmt.Errorf("no catalog template named %s", name))
+	}
+
+	_, err := repository.GetCity(city)
+	if err != nil {
+		switch err.(type) {
+		case *repository.CityNotFoundErr:
+			errorMessage := fmt.Errorf("%s.  city_name '%s' not in database", err, city)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	added, err := addServices(city, template.Services)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	body, err := json.Marshal(catalogResult{Template: name, Added: added, Skipped: len(template.Services) - added})
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling catalog result"))
+	}
+
+	infoLogger.Printf("Catalog template %s applied to %s: %d services added", name, city, added)
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// approval is the body of an onboarding approval.  Every field is optional.
+type approval struct {
+	CityName    string `json:"city_name"`        // Name of the city to provision. Defaults to the city of the request
+	CatalogCity string `json:"catalog_city"`     // City whose services are cloned. Defaults to DEFAULT_CATALOG_CITY
+	Template    string `json:"catalog_template"` // Catalog template applied in place of cloning a city's services
+	Region      string `json:"region"`           // AWS region the city's data is pinned to. Defaults to the platform's
+	MediaBucket string `json:"media_bucket"`     // Bucket in the city's region holding its media. Required with a region
+}
+
+// approveOnboardingRequest provisions the city of an onboarding request.  A provisioning that failed part way is
+// finished by approving the request again.
+func approveOnboardingRequest(id string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	onboardingRequest, err := repository.GetOnboardingRequest(id)
+	if err != nil {
+		switch err.(type) {
+		case *repository.OnboardingRequestNotFoundErr:
+			errorMessage := fmt.Errorf("%s. id '%s' not in database", err, id)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	var a approval
+	if req.Body != "" {
+		err = json.Unmarshal([]byte(req.Body), &a)
+		if err != nil {
+			return clientError(http.StatusUnprocessableEntity, errors.New("error unmarshalling approval JSON. Check syntax"))
+		}
+	}
+	if a.CityName == "" {
+		a.CityName = strings.TrimSpace(onboardingRequest.City)
+	}
+	if a.CatalogCity == "" && a.Template == "" {
+		a.CatalogCity = os.Getenv("DEFAULT_CATALOG_CITY")
+	}
+	if _, ok := catalog.Get(a.Template); a.Template != "" && !ok {
+		return clientError(http.StatusBadRequest, fmt.Errorf("catalog_template '%s' is not a catalog template", a.Template))
+	}
+
+	switch {
+	case onboardingRequest.Status == repository.OnboardingLive:
+		return clientError(http.StatusConflict, fmt.Errorf("onboarding request %s already provisioned %s", id, onboardingRequest.CityName))
+	case onboardingRequest.Status == repository.OnboardingRejected:
+		return clientError(http.StatusConflict, fmt.Errorf("onboarding request %s was rejected. Move it back to received before approving it", id))
+	case onboardingRequest.CityName != "" && onboardingRequest.CityName != a.CityName:
+		return clientError(http.StatusConflict, fmt.Errorf("onboarding request %s is already provisioning %s", id, onboardingRequest.CityName))
+	case a.CityName == "":
+		return clientError(http.StatusBadRequest, errors.New("city_name must be specified when the onboarding request names no city"))
+	case onboardingRequest.Email == "":
+		return clientError(http.StatusBadRequest, errors.New("onboarding request has no email to invite a city admin at"))
+	}
+	err = validateRegion(a.Region, a.MediaBucket)
+	if err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+	err = checkMediaBucket(repository.City{CityName: a.CityName, Region: a.Region, MediaBucket: a.MediaBucket})
+	if err != nil {
+		switch err.(type) {
+		case *mediaBucketErr:
+			return clientError(http.StatusBadRequest, err)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	err = repository.SetOnboardingStatus(id, repository.OnboardingApproved, a.CityName)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	p := provisioning{
+		request:  onboardingRequest,
+		city:     repository.City{CityName: a.CityName, Region: a.Region, MediaBucket: a.MediaBucket},
+		catalog:  a.CatalogCity,
+		template: a.Template,
+	}
+	err = p.run()
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	err = repository.SetOnboardingStatus(id, repository.OnboardingLive, a.CityName)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	city, err := repository.GetCity(a.CityName)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	body, err := json.Marshal(city)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("unable to marshal JSON for response"))
+	}
+
+	infoLogger.Printf("Onboarding request %s approved and %s provisioned", id, a.CityName)
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// notifyPlatformTeam tells the platform team about a new onboarding lead by email to PLATFORM_ADMIN_EMAILS
+// (comma separated) and on the Slack channel of PLATFORM_SLACK_WEBHOOK_URL
+func notifyPlatformTeam(onboardingRequest repository.OnboardingRequest) {
+	contact := strings.TrimSpace(onboardingRequest.FirstName + " " + onboardingRequest.LastName)
+
+	for _, address := range strings.Split(os.Getenv("PLATFORM_ADMIN_EMAILS"), ",") {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+		_, err := notification.SendEmail(notification.Sender(repository.City{}), address, notification.OnboardingLeadTemplate,
+			map[string]string{
+				"city":     onboardingRequest.City,
+				"state":    onboardingRequest.State,
+				"contact":  contact,
+				"email":    onboardingRequest.Email,
+				"feedback": onboardingRequest.Feedback,
+			})
+		if err != nil {
+			warningLogger.Println(err)
+		}
+	}
+
+	if webhookURL := os.Getenv("PLATFORM_SLACK_WEBHOOK_URL"); webhookURL != "" {
+		text := fmt.Sprintf("New onboarding request from %s, %s: %s <%s>",
+			onboardingRequest.City, onboardingRequest.State, contact, onboardingRequest.Email)
+		if onboardingRequest.Feedback != "" {
+			text += "\n> " + onboardingRequest.Feedback
+		}
+		err := notification.PostSlack(webhookURL, text)
+		if err != nil {
+			warningLogger.Println(err)
+		}
+	}
+}
+
+// Notifications whose copy a city may replace
+var templateNames = map[string]bool{
+	notification.StatusChangedTemplate: true,
+	notification.DigestTemplate:        true,
+}
+
+func getTemplates(city string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !auth.IsAdminOf(city, req) {
+		return clientError(http.StatusForbidden, fmt.Errorf("templates of %s may only be managed by its city admins", city))
+	}
+
+	templates, err := repository.GetNotificationTemplates(city)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	body, err := json.Marshal(templates)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling notification templates"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+func putTemplate(city string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !auth.IsAdminOf(city, req) {
+		return clientError(http.StatusForbidden, fmt.Errorf("templates of %s may only be managed by its city admins", city))
+	}
+
+	var template repository.NotificationTemplate
+	err := json.Unmarshal([]byte(req.Body), &template)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, errors.New("error unmarshalling notification template JSON. Check syntax"))
+	}
+
+	template.City = city
+	template.Name = req.PathParameters["name"]
+	template.Language = req.PathParameters["language"]
+	if !templateNames[template.Name] {
+		return clientError(http.StatusNotFound, fmt.Errorf("no notification named '%s'", template.Name))
+	}
+	if template.Subject == "" || template.Text == "" {
+		return clientError(http.StatusBadRequest, errors.New("subject and text must be specified"))
+	}
+
+	_, err = repository.GetCity(city)
+	if err != nil {
+		switch err.(type) {
+		case *repository.CityNotFoundErr:
+			errorMessage := fmt.Errorf("%s.  city_name '%s' not in database", err, city)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	err = repository.PutNotificationTemplate(template)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	body, err := json.Marshal(template)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("unable to marshal JSON for response"))
+	}
+
+	infoLogger.Printf("%s template of %s updated for language %s", template.Name, city, template.Language)
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// geometry is a GeoJSON Polygon or MultiPolygon geometry object
+type geometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// multiPolygon reads a Polygon or MultiPolygon geometry as a MultiPolygon
+func (g geometry) multiPolygon() (geo.MultiPolygon, error) {
+	var err error
+	var parts geo.MultiPolygon
+	switch g.Type {
+	case "Polygon":
+		var polygon geo.Polygon
+		err = json.Unmarshal(g.Coordinates, &polygon)
+		parts = geo.MultiPolygon{polygon}
+	case "MultiPolygon":
+		err = json.Unmarshal(g.Coordinates, &parts)
+	default:
+		return nil, errors.New("must be a GeoJSON Polygon or MultiPolygon")
+	}
+	if err != nil {
+		return nil, errors.New("coordinates must be rings of [lon, lat] positions")
+	}
+
+	for _, polygon := range parts {
+		if len(polygon) == 0 || len(polygon[0]) < 4 {
+			return nil, errors.New("needs an outer ring of at least 4 positions for each polygon")
+		}
+	}
+	return parts, nil
+}
+
+// putBoundary replaces the city limits that submitted requests must lie within
+func putBoundary(city string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !auth.IsAdminOf(city, req) {
+		return clientError(http.StatusForbidden, fmt.Errorf("the boundary of %s may only be set by its city admins", city))
+	}
+
+	var g geometry
+	err := json.Unmarshal([]byte(req.Body), &g)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, errors.New("error unmarshalling GeoJSON geometry. Check syntax"))
+	}
+
+	boundary, err := g.multiPolygon()
+	if err != nil {
+		return clientError(http.StatusBadRequest, fmt.Errorf("boundary %s", err))
+	}
+
+	err = repository.SetCityBoundary(city, boundary)
+	if err != nil {
+		switch err.(type) {
+		case *repository.CityNotFoundErr:
+			errorMessage := fmt.Errorf("%s.  city_name '%s' not in database", err, city)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	infoLogger.Printf("Boundary of %s set with %d parts", city, len(boundary))
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusNoContent,
+		Headers:    map[string]string{"Access-Control-Allow-Origin": "*"},
+	}, nil
+}
+
+// neighborhoodBody is a neighborhood as PUT /city/{id}/neighborhoods takes it, with a GeoJSON geometry
+type neighborhoodBody struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Geometry geometry `json:"geometry"`
+}
+
+// putNeighborhoods replaces the neighborhoods or wards of a city that requests are tagged with and ranked by.
+// Requests already submitted keep the neighborhood they were tagged with.
+func putNeighborhoods(city string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !auth.IsAdminOf(city, req) {
+		return clientError(http.StatusForbidden, fmt.Errorf("the neighborhoods of %s may only be set by its city admins", city))
+	}
+
+	var bodies []neighborhoodBody
+	err := json.Unmarshal([]byte(req.Body), &bodies)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, errors.New("error unmarshalling neighborhoods JSON. Check syntax"))
+	}
+
+	neighborhoods := []repository.Neighborhood{}
+	seen := map[string]bool{}
+	for _, body := range bodies {
+		if body.ID == "" || body.Name == "" {
+			return clientError(http.StatusBadRequest, errors.New("each neighborhood needs an id and a name"))
+		}
+		if seen[body.ID] {
+			return clientError(http.StatusBadRequest, fmt.Errorf("neighborhood id '%s' is used more than once", body.ID))
+		}
+		seen[body.ID] = true
+
+		boundary, err := body.Geometry.multiPolygon()
+		if err != nil {
+			return clientError(http.StatusBadRequest, fmt.Errorf("geometry of neighborhood '%s' %s", body.ID, err))
+		}
+		neighborhoods = append(neighborhoods, repository.Neighborhood{ID: body.ID, Name: body.Name, Boundary: boundary})
+	}
+
+	err = repository.SetCityNeighborhoods(city, neighborhoods)
+	if err != nil {
+		switch err.(type) {
+		case *repository.CityNotFoundErr:
+			errorMessage := fmt.Errorf("%s.  city_name '%s' not in database", err, city)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	infoLogger.Printf("%d neighborhoods of %s set", len(neighborhoods), city)
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusNoContent,
+		Headers:    map[string]string{"Access-Control-Allow-Origin": "*"},
+	}, nil
+}
+
+// putConfig replaces the settings a city tunes for itself
+func putConfig(city string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !auth.IsAdminOf(city, req) {
+		return clientError(http.StatusForbidden, fmt.Errorf("the config of %s may only be set by its city admins", city))
+	}
+
+	var config repository.CityConfig
+	err := json.Unmarshal([]byte(req.Body), &config)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, errors.New("error unmarshalling city config JSON. Check syntax"))
+	}
+
+	err = config.Validate()
+	if err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+
+	err = repository.SetCityConfig(city, config)
+	if err != nil {
+		switch err.(type) {
+		case *repository.CityNotFoundErr:
+			errorMessage := fmt.Errorf("%s.  city_name '%s' not in database", err, city)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	body, err := json.Marshal(config)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("unable to marshal JSON for response"))
+	}
+
+	infoLogger.Printf("Config of %s set", city)
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// hexColorPattern matches colors such as "#1d4f91"
+var hexColorPattern = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// updateCity replaces the settings of a city's record.  The boundary, config and deactivation are kept, since
+// they have endpoints of their own.
+func updateCity(id string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var city repository.City
+	err := json.Unmarshal([]byte(req.Body), &city)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, errors.New("error unmarshalling city JSON. Check syntax"))
+	}
+
+	city.CityName = id
+	err = validateCity(city)
+	if err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+
+	// A city's own media bucket is in the region it is pinned to, which the update keeps
+	stored, err := repository.GetCity(id)
+	if err != nil {
+		switch err.(type) {
+		case *repository.CityNotFoundErr:
+			errorMessage := fmt.Errorf("%s.  city_name '%s' not in database", err, id)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+	city.Region = stored.Region
+	err = checkMediaBucket(city)
+	if err != nil {
+		switch err.(type) {
+		case *mediaBucketErr:
+			return clientError(http.StatusBadRequest, err)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	err = repository.UpdateCity(city)
+	if err != nil {
+		switch err.(type) {
+		case *repository.CityNotFoundErr:
+			errorMessage := fmt.Errorf("%s.  city_name '%s' not in database", err, id)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	infoLogger.Printf("City %s updated", id)
+	return getCity(id)
+}
+
+// validateCity checks the settings of a city record being updated
+func validateCity(city repository.City) error {
+	if city.Endpoint != "" && !strings.HasPrefix(city.Endpoint, "https://") {
+		return errors.New("endpoint must be an https URL")
+	}
+	if city.Federated && city.Endpoint == "" {
+		return errors.New("federated cities need the endpoint of their GeoReport v2 server")
+	}
+	if city.SenderEmail != "" && !strings.Contains(city.SenderEmail, "@") {
+		return fmt.Errorf("sender_email '%s' is not an email address", city.SenderEmail)
+	}
+	if city.LogoURL != "" && !strings.HasPrefix(city.LogoURL, "https://") {
+		return errors.New("logo_url must be an https URL")
+	}
+	if city.BrandColor != "" && !hexColorPattern.MatchString(city.BrandColor) {
+		return fmt.Errorf("brand_color '%s' must be a hex color, eg #1d4f91", city.BrandColor)
+	}
+	if city.SMSDailyQuota < 0 || city.MediaArchiveDays < 0 || city.MediaRetentionDays < 0 {
+		return errors.New("sms_daily_quota, media_archive_days and media_retention_days can't be negative. Use 0 for the default")
+	}
+	if _, ok := city.Box(); len(city.BoundingBox) > 0 && !ok {
+		return errors.New("bbox must be minLon,minLat,maxLon,maxLat with min before max")
+	}
+	return nil
+}
+
+// validateRegion checks the region a city being provisioned is pinned to.  The shared images bucket is in the
+// platform's region, so a city pinned elsewhere brings a bucket of its own.
+func validateRegion(region string, mediaBucket string) error {
+	if region == "" || region == repository.AwsRegion {
+		return nil
+	}
+	if !repository.ValidRegion(region) {
+		return fmt.Errorf("region '%s' is not an AWS region, eg eu-west-1", region)
+	}
+	if mediaBucket == "" {
+		return fmt.Errorf("cities pinned to %s need a media_bucket in that region", region)
+	}
+	return nil
+}
+
+// deactivation is the body of a POST /city/{id}/deactivate
+type deactivation struct {
+	Notice string `json:"notice"` // Shown to residents whose submissions are refused. Defaults to a generic notice
+}
+
+// setDeactivated pauses or resumes a city.  A paused city's requests and services can still be read, but
+// submissions are refused with its notice.
+func setDeactivated(id string, deactivated bool, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var d deactivation
+	if deactivated && req.Body != "" {
+		err := json.Unmarshal([]byte(req.Body), &d)
+		if err != nil {
+			return clientError(http.StatusUnprocessableEntity, errors.New("error unmarshalling deactivation JSON. Check syntax"))
+		}
+	}
+
+	err := repository.SetCityDeactivated(id, deactivated, strings.TrimSpace(d.Notice))
+	if err != nil {
+		switch err.(type) {
+		case *repository.CityNotFoundErr:
+			errorMessage := fmt.Errorf("%s.  city_name '%s' not in database", err, id)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	if deactivated {
+		infoLogger.Printf("City %s deactivated", id)
+	} else {
+		infoLogger.Printf("City %s activated", id)
+	}
+	return getCity(id)
+}
+
+// agencyIDPattern limits agency IDs to characters that are safe in URL paths
+var agencyIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// maxAgencyDepth is how deep agencies may be nested, matching how far the repository follows a hierarchy
+const maxAgencyDepth = 8
+
+// getAgencies lists the departments and divisions of a city.  Their webhook secrets are included, so only the
+// city's admins may list them.
+func getAgencies(city string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !auth.IsAdminOf(city, req) {
+		return clientError(http.StatusForbidden, fmt.Errorf("the agencies of %s may only be listed by its city admins", city))
+	}
+
+	agencies, err := repository.GetCityAgencies(city)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	body, err := json.Marshal(agencies)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling GetCityAgencies() struct"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// putAgency adds or replaces a department or division of a city
+func putAgency(city string, id string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !auth.IsAdminOf(city, req) {
+		return clientError(http.StatusForbidden, fmt.Errorf("the agencies of %s may only be managed by its city admins", city))
+	}
+
+	var agency repository.Agency
+	err := json.Unmarshal([]byte(req.Body), &agency)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, errors.New("error unmarshalling agency JSON. Check syntax"))
+	}
+	agency.ID = id
+	agency.CityID = city
+	agency.Name = strings.TrimSpace(agency.Name)
+
+	if !agencyIDPattern.MatchString(agency.ID) {
+		return clientError(http.StatusBadRequest, errors.New("agency_id must be 1 to 64 letters, digits, '_', '.' or '-', starting with a letter or digit"))
+	}
+	if agency.Name == "" {
+		return clientError(http.StatusBadRequest, errors.New("name must be specified"))
+	}
+
+	agencies, err := repository.GetCityAgencies(city)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+	err = checkParent(agencies, agency)
+	if err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+
+	err = repository.PutAgency(agency)
+	if err != nil {
+		switch err.(type) {
+		case *repository.AgencyOfAnotherCityErr:
+			errorMessage := fmt.Errorf("%s. agency_id '%s' is taken", err, id)
+			return clientError(http.StatusConflict, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	body, err := json.Marshal(agency)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("unable to marshal JSON for response"))
+	}
+
+	infoLogger.Printf("Agency %s of %s put", id, city)
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// checkParent checks that the parent of an agency is another agency of its city, and that the agency would not
+// end up above itself
+func checkParent(agencies []repository.Agency, agency repository.Agency) error {
+	if agency.ParentID == "" {
+		return nil
+	}
+
+	parents := map[string]string{agency.ID: agency.ParentID}
+	for _, a := range agencies {
+		if a.ID != agency.ID {
+			parents[a.ID] = a.ParentID
+		}
+	}
+	if _, ok := parents[agency.ParentID]; !ok {
+		return fmt.Errorf("parent_id '%s' is not an agency of %s", agency.ParentID, agency.CityID)
+	}
+
+	for id, depth := agency.ParentID, 1; id != ""; id, depth = parents[id], depth+1 {
+		if id == agency.ID {
+			return fmt.Errorf("agency '%s' can't be under itself", agency.ID)
+		}
+		if depth >= maxAgencyDepth {
+			return fmt.Errorf("agencies can be nested at most %d deep", maxAgencyDepth)
+		}
+	}
+	return nil
+}
+
+// putRouting replaces the rules assigning a city's requests to its agencies
+func putRouting(city string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !auth.IsAdminOf(city, req) {
+		return clientError(http.StatusForbidden, fmt.Errorf("the routing of %s may only be set by its city admins", city))
+	}
+
+	var rules []repository.RoutingRule
+	err := json.Unmarshal([]byte(req.Body), &rules)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, errors.New("error unmarshalling routing rules JSON. Check syntax"))
+	}
+
+	agencies, err := repository.GetCityAgencies(city)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+	err = validateRouting(rules, agencies)
+	if err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+
+	err = repository.SetCityRouting(city, rules)
+	if err != nil {
+		switch err.(type) {
+		case *repository.CityNotFoundErr:
+			errorMessage := fmt.Errorf("%s.  city_name '%s' not in database", err, city)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	body, err := json.Marshal(rules)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("unable to marshal JSON for response"))
+	}
+
+	infoLogger.Printf("Routing of %s set", city)
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// validateRouting checks that each rule applies to either a service_code or a group, and assigns requests to one
+// of the city's agencies
+func validateRouting(rules []repository.RoutingRule, agencies []repository.Agency) error {
+	ids := map[string]bool{}
+	for _, a := range agencies {
+		ids[a.ID] = true
+	}
+
+	for i, rule := range rules {
+		if (rule.ServiceCode == "") == (rule.Group == "") {
+			return fmt.Errorf("rule %d must have either a service_code or a group", i)
+		}
+		if !ids[rule.AgencyID] {
+			return fmt.Errorf("agency_id '%s' of rule %d is not an agency of the city", rule.AgencyID, i)
+		}
+	}
+	return nil
+}
+
+func serverError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
+	errorLogger.Println(err.Error())
+	return apierror.Response(statusCode, err), nil
+}
+
+func clientError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
+	warningLogger.Println(err.Error())
+	return apierror.Response(statusCode, err), nil
+}
+
 func main() {
-	lambda.Start(router)
+	warmup.Start("cities", metrics.Handler("cities", apierror.Handler(repository.Audit(router))), repository.Warm)
 }
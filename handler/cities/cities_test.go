@@ -1,8 +1,326 @@
 package main
 
 import (
+	"fmt"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/social-torch/open311-services/metrics"
+	"github.com/social-torch/open311-services/repository"
 )
 
-func TestStub(t *testing.T) {
+func TestCityServiceCode(t *testing.T) {
+	tests := []struct {
+		city string
+		want string
+	}{
+		{"Troy", "troy-pothole"},
+		{"Saratoga Springs", "saratoga-springs-pothole"},
+		{"Coeur d'Alene", "coeur-d-alene-pothole"},
+		{" St. Paul ", "st-paul-pothole"},
+		{"Española", "espa-ola-pothole"},
+	}
+
+	for _, tt := range tests {
+		if got := cityServiceCode(tt.city, "pothole"); got != tt.want {
+			t.Errorf("cityServiceCode(%q) = %s, want %s", tt.city, got, tt.want)
+		}
+	}
+}
+
+func TestValidateCity(t *testing.T) {
+	valid := repository.City{
+		CityName:    "Troy",
+		Endpoint:    "https://311.troyny.gov/open311/v2",
+		SenderEmail: "311@troyny.gov",
+		LogoURL:     "https://troyny.gov/logo.png",
+		BrandColor:  "#1d4f91",
+		BoundingBox: []float64{-73.72, 42.67, -73.63, 42.79},
+		Federated:   true,
+	}
+	if err := validateCity(valid); err != nil {
+		t.Errorf("validateCity() = %s, want nil", err)
+	}
+	if err := validateCity(repository.City{CityName: "Troy"}); err != nil {
+		t.Errorf("validateCity() of bare city = %s, want nil", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*repository.City)
+	}{
+		{"http endpoint", func(c *repository.City) { c.Endpoint = "http://311.troyny.gov" }},
+		{"federated without endpoint", func(c *repository.City) { c.Endpoint = "" }},
+		{"malformed sender", func(c *repository.City) { c.SenderEmail = "troyny.gov" }},
+		{"named color", func(c *repository.City) { c.BrandColor = "blue" }},
+		{"negative quota", func(c *repository.City) { c.SMSDailyQuota = -1 }},
+		{"short bbox", func(c *repository.City) { c.BoundingBox = []float64{-73.72, 42.67} }},
+		{"backwards bbox", func(c *repository.City) { c.BoundingBox = []float64{-73.63, 42.79, -73.72, 42.67} }},
+	}
+	for _, tt := range tests {
+		city := valid
+		tt.modify(&city)
+		if err := validateCity(city); err == nil {
+			t.Errorf("validateCity() with %s should set an error", tt.name)
+		}
+	}
+}
+
+func TestValidateRegion(t *testing.T) {
+	tests := []struct {
+		region string
+		bucket string
+		valid  bool
+	}{
+		{"", "", true},
+		{repository.AwsRegion, "", true},
+		{"eu-west-1", "troy-media-eu", true},
+		{"eu-west-1", "", false},
+		{"europe", "troy-media-eu", false},
+	}
+	for _, tt := range tests {
+		err := validateRegion(tt.region, tt.bucket)
+		if (err == nil) != tt.valid {
+			t.Errorf("validateRegion(%q, %q) = %v, want valid %t", tt.region, tt.bucket, err, tt.valid)
+		}
+	}
+}
+
+func TestNotifiesUploads(t *testing.T) {
+	function := func(events []string, rules ...string) *s3.LambdaFunctionConfiguration {
+		c := &s3.LambdaFunctionConfiguration{Events: aws.StringSlice(events)}
+		if len(rules) > 0 {
+			c.Filter = &s3.NotificationConfigurationFilter{Key: &s3.KeyFilter{}}
+			for i := 0; i < len(rules); i += 2 {
+				c.Filter.Key.FilterRules = append(c.Filter.Key.FilterRules, &s3.FilterRule{Name: aws.String(rules[i]), Value: aws.String(rules[i+1])})
+			}
+		}
+		return c
+	}
+	tests := []struct {
+		name      string
+		functions []*s3.LambdaFunctionConfiguration
+		want      bool
+	}{
+		{"no notification", nil, false},
+		{"every upload", []*s3.LambdaFunctionConfiguration{function([]string{"s3:ObjectCreated:*"})}, true},
+		{"the city's prefix", []*s3.LambdaFunctionConfiguration{function([]string{"s3:ObjectCreated:*"}, "Prefix", "troy/")}, true},
+		{"another prefix", []*s3.LambdaFunctionConfiguration{function([]string{"s3:ObjectCreated:*"}, "prefix", "albany/")}, false},
+		{"some suffixes", []*s3.LambdaFunctionConfiguration{function([]string{"s3:ObjectCreated:*"}, "suffix", ".jpg")}, false},
+		{"puts only", []*s3.LambdaFunctionConfiguration{function([]string{"s3:ObjectCreated:Put"})}, false},
+		{"one of several", []*s3.LambdaFunctionConfiguration{function([]string{"s3:ObjectRemoved:*"}), function([]string{"s3:ObjectCreated:*"})}, true},
+	}
+	for _, tt := range tests {
+		config := &s3.NotificationConfiguration{LambdaFunctionConfigurations: tt.functions}
+		if got := notifiesUploads(config, "troy/"); got != tt.want {
+			t.Errorf("notifiesUploads() of %s = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     int
+	}{
+		{repository.OnboardingReceived, repository.OnboardingContacted, http.StatusOK},
+		{repository.OnboardingContacted, repository.OnboardingReceived, http.StatusOK},
+		{repository.OnboardingContacted, repository.OnboardingRejected, http.StatusOK},
+		{repository.OnboardingRejected, repository.OnboardingReceived, http.StatusOK},
+		{repository.OnboardingRejected, repository.OnboardingContacted, http.StatusConflict},
+		{repository.OnboardingApproved, repository.OnboardingRejected, http.StatusConflict},
+		{repository.OnboardingLive, repository.OnboardingReceived, http.StatusConflict},
+		{repository.OnboardingContacted, repository.OnboardingApproved, http.StatusBadRequest},
+		{repository.OnboardingReceived, "pending", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		code, err := checkTransition(tt.from, tt.to)
+		if code != tt.want || (err == nil) != (tt.want == http.StatusOK) {
+			t.Errorf("checkTransition(%s, %s) = %d, %v, want %d", tt.from, tt.to, code, err, tt.want)
+		}
+	}
+}
+
+func TestStatsDays(t *testing.T) {
+	today := time.Date(2020, 3, 1, 8, 0, 0, 0, time.UTC)
+	days := statsDays(today, 3)
+	if len(days) != 3 || days[0] != "2020-02-28" || days[1] != "2020-02-29" || days[2] != "2020-03-01" {
+		t.Errorf("statsDays() = %v, want 2020-02-28 to 2020-03-01", days)
+	}
+}
+
+func TestCheckParent(t *testing.T) {
+	agencies := []repository.Agency{
+		{ID: "public-works"},
+		{ID: "streets", ParentID: "public-works"},
+		{ID: "potholes", ParentID: "streets"},
+	}
+
+	tests := []struct {
+		agency repository.Agency
+		ok     bool
+	}{
+		{repository.Agency{ID: "parks"}, true},
+		{repository.Agency{ID: "sidewalks", ParentID: "streets"}, true},
+		{repository.Agency{ID: "streets", ParentID: "public-works"}, true},
+		{repository.Agency{ID: "sidewalks", ParentID: "fire"}, false},
+		{repository.Agency{ID: "public-works", ParentID: "potholes"}, false},
+		{repository.Agency{ID: "streets", ParentID: "streets"}, false},
+	}
+	for _, tt := range tests {
+		if err := checkParent(agencies, tt.agency); (err == nil) != tt.ok {
+			t.Errorf("checkParent(%s under %s) = %v, want ok %t", tt.agency.ID, tt.agency.ParentID, err, tt.ok)
+		}
+	}
+
+	deep := []repository.Agency{{ID: "a0"}}
+	for i := 1; i < maxAgencyDepth; i++ {
+		deep = append(deep, repository.Agency{ID: fmt.Sprintf("a%d", i), ParentID: fmt.Sprintf("a%d", i-1)})
+	}
+	if err := checkParent(deep, repository.Agency{ID: "too-deep", ParentID: deep[len(deep)-1].ID}); err == nil {
+		t.Errorf("checkParent() %d deep should set an error", maxAgencyDepth+1)
+	}
+}
+
+func TestValidateRouting(t *testing.T) {
+	agencies := []repository.Agency{{ID: "streets"}, {ID: "fire"}}
+	valid := []repository.RoutingRule{{Group: "public-works", AgencyID: "streets"}, {ServiceCode: "troy-hydrant", AgencyID: "fire"}}
+	if err := validateRouting(valid, agencies); err != nil {
+		t.Errorf("validateRouting() = %s, want nil", err)
+	}
+
+	invalid := [][]repository.RoutingRule{
+		{{AgencyID: "streets"}},
+		{{Group: "public-works", ServiceCode: "troy-pothole", AgencyID: "streets"}},
+		{{Group: "public-works", AgencyID: "parks"}},
+	}
+	for _, rules := range invalid {
+		if err := validateRouting(rules, agencies); err == nil {
+			t.Errorf("validateRouting(%+v) should set an error", rules)
+		}
+	}
+}
+
+func TestCapacityReport(t *testing.T) {
+	total := func(table, route string, sum float64) metrics.Total {
+		return metrics.Total{Dimensions: map[string]string{"Table": table, "Route": route}, Sum: sum}
+	}
+	reads := []metrics.Total{
+		total("Requests", "GET /requests", 900),
+		total("Requests", "system:open311-Digest", 120),
+		total("Cities", "GET /cities", 40),
+	}
+	writes := []metrics.Total{
+		total("Requests", "POST /requests", 30),
+		total("Counters", "system:open311-Stream", 80),
+	}
+	throttles := []metrics.Total{total("Requests", "GET /requests", 3)}
+
+	report := capacityReport(reads, writes, throttles)
+	if len(report) != 3 || report[0].Table != "Requests" || report[1].Table != "Counters" || report[2].Table != "Cities" {
+		t.Fatalf("capacityReport() = %+v, want Requests, Counters, then Cities", report)
+	}
+	requests := report[0]
+	if requests.ReadCapacity != 1020 || requests.WriteCapacity != 30 || requests.Throttles != 3 {
+		t.Errorf("Requests capacity = %+v, want 1020 read, 30 written and 3 throttles", requests)
+	}
+	want := []routeCapacity{
+		{Route: "GET /requests", ReadCapacity: 900, Throttles: 3},
+		{Route: "system:open311-Digest", ReadCapacity: 120},
+		{Route: "POST /requests", WriteCapacity: 30},
+	}
+	if len(requests.Routes) != len(want) {
+		t.Fatalf("Requests routes = %+v, want %+v", requests.Routes, want)
+	}
+	for i := range want {
+		if requests.Routes[i] != want[i] {
+			t.Errorf("Requests routes[%d] = %+v, want %+v", i, requests.Routes[i], want[i])
+		}
+	}
+
+	if report := capacityReport(nil, nil, nil); report == nil || len(report) != 0 {
+		t.Errorf("capacityReport() of no metrics = %v, want empty", report)
+	}
+}
+
+func TestAgencyCalendar(t *testing.T) {
+	city := repository.City{CityName: "troy", Config: repository.CityConfig{TimeZone: "America/New_York"}}
+	agency := repository.Agency{ID: "streets", Name: "Streets"}
+	queue := []repository.Request{
+		{ServiceRequestID: "r1", Status: "open", ServiceName: "Pothole", Address: "12 Elm St, Troy; NY", ScheduledDateTime: "2020-03-05T09:00:00-05:00"},
+		{ServiceRequestID: "r2", Status: "open", ServiceName: "Pothole", Address: "1 River St"},
+		{ServiceRequestID: "r3", Status: "open", ServiceCode: "graffiti", ScheduledDateTime: "2020-03-04T13:00:00Z"},
+	}
+	now := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	calendar := agencyCalendar(city, agency, queue, now)
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"X-WR-CALNAME:Streets scheduled work\r\n",
+		"UID:r3@open311\r\nDTSTAMP:20200301T000000Z\r\nDTSTART:20200304T130000Z\r\nDTEND:20200304T140000Z\r\nSUMMARY:graffiti\r\n",
+		"DTSTART:20200305T140000Z\r\n",
+		"SUMMARY:Pothole at 12 Elm St\\, Troy\\; NY\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(calendar, want) {
+			t.Errorf("agencyCalendar() = %s, want %q", calendar, want)
+		}
+	}
+	if strings.Contains(calendar, "r2") {
+		t.Error("agencyCalendar() has an event for a request without scheduled work")
+	}
+	if strings.Index(calendar, "UID:r3") > strings.Index(calendar, "UID:r1") {
+		t.Error("agencyCalendar() events should be soonest first")
+	}
+
+	city.Config.OpenData.RedactFields = []string{"address"}
+	if calendar := agencyCalendar(city, agency, queue, now); strings.Contains(calendar, "Elm") {
+		t.Errorf("agencyCalendar() = %s, want the address redacted", calendar)
+	}
+}
+
+func TestICalFold(t *testing.T) {
+	line := "SUMMARY:" + strings.Repeat("é", 50)
+	folded := icalFold(line)
+	for _, l := range strings.Split(folded, "\r\n") {
+		if len(l) > 75 {
+			t.Errorf("icalFold() line of %d octets, want at most 75", len(l))
+		}
+	}
+	if strings.Replace(folded, "\r\n ", "", -1) != line {
+		t.Errorf("icalFold() = %q, unfolds to something other than %q", folded, line)
+	}
+}
+
+func TestDirectory(t *testing.T) {
+	cities := []repository.City{
+		{CityName: "troy"},
+		{CityName: "albany", Endpoint: "https://311.albanyny.gov/api/"},
+		{CityName: "schenectady", Federated: true, Endpoint: "https://open311.schenectady.gov/v2", FederationJurisdictionID: "schenectady.gov"},
+		{CityName: "cohoes", Federated: true},
+	}
+
+	got := directory(cities, "https://api.example.com/Prod").Endpoints
+	if len(got) != 3 {
+		t.Fatalf("directory() listed %d endpoints, want 3 leaving out the federated city without a server", len(got))
+	}
+
+	want := []directoryEndpoint{
+		{Name: "albany", JurisdictionID: "albany", URL: "https://311.albanyny.gov/api"},
+		{Name: "schenectady", JurisdictionID: "schenectady.gov", URL: "https://open311.schenectady.gov/v2", Federated: true},
+		{Name: "troy", JurisdictionID: "troy", URL: "https://api.example.com/Prod"},
+	}
+	for i, w := range want {
+		e := got[i]
+		if e.Name != w.Name || e.JurisdictionID != w.JurisdictionID || e.URL != w.URL || e.Federated != w.Federated {
+			t.Errorf("endpoint %d = %+v, want %+v", i, e, w)
+		}
+		if e.Specification != geoReportV2 || e.Type != "production" || len(e.Formats) == 0 {
+			t.Errorf("endpoint %s = %+v, want a GeoReport v2 production endpoint with formats", e.Name, e)
+		}
+	}
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/repository"
+)
+
+// geoReportV2 is the specification every endpoint in the directory serves
+const geoReportV2 = "http://wiki.open311.org/GeoReport_v2"
+
+// endpointsDirectory lists the GeoReport v2 endpoint of every city, in the shape of the community Open311 endpoints
+// directory: each endpoint is described as by GeoReport v2 service discovery, with the city's name and the
+// jurisdiction_id calls to it are made with.
+type endpointsDirectory struct {
+	Endpoints []directoryEndpoint `json:"endpoints"`
+}
+
+type directoryEndpoint struct {
+	Name           string   `json:"name"`
+	JurisdictionID string   `json:"jurisdiction_id,omitempty"` // Sent with every call, scoping it to the city. Empty when the server serves one city
+	Specification  string   `json:"specification"`
+	URL            string   `json:"url"`     // Base of the endpoint's GeoReport v2 paths, eg {url}/services
+	Type           string   `json:"type"`    // production, test or dev, as in service discovery
+	Formats        []string `json:"formats"` // Content types the endpoint responds in
+	Federated      bool     `json:"federated"`
+}
+
+// getEndpoints serves the directory of every city's endpoint as endpoints.json, so Open311 apps and the community
+// directory can discover the cities hosted here
+func getEndpoints(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	cities, err := repository.GetCities()
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	body, err := json.MarshalIndent(directory(cities, "https://"+req.Headers["Host"]+"/"+req.RequestContext.Stage), "", "  ")
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling endpoints directory"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers: map[string]string{
+			"content-type":                "application/json",
+			"Access-Control-Allow-Origin": "*",
+			"Cache-Control":               "max-age=3600",
+		},
+		Body: string(body),
+	}, nil
+}
+
+// directory returns the endpoints of cities, sorted by name.  Hosted cities are served at their endpoint, or else the
+// API at apiBase, scoped by their city_name as jurisdiction_id.  Federated cities are listed at their own server.
+func directory(cities []repository.City, apiBase string) endpointsDirectory {
+	endpoints := []directoryEndpoint{}
+	for _, city := range cities {
+		endpoint := directoryEndpoint{
+			Name:           city.CityName,
+			JurisdictionID: city.CityName,
+			Specification:  geoReportV2,
+			URL:            strings.TrimSuffix(city.Endpoint, "/"),
+			Type:           "production",
+			Formats:        []string{"application/json", "application/geo+json"},
+			Federated:      city.Federated,
+		}
+		if city.Federated {
+			endpoint.JurisdictionID = city.FederationJurisdictionID
+			endpoint.Formats = []string{"application/json"}
+		}
+		if endpoint.URL == "" {
+			if city.Federated {
+				continue
+			}
+			endpoint.URL = apiBase
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].Name < endpoints[j].Name
+	})
+	return endpointsDirectory{Endpoints: endpoints}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	cognito "github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/social-torch/open311-services/auth"
+	"github.com/social-torch/open311-services/awsclient"
+	"github.com/social-torch/open311-services/catalog"
+	"github.com/social-torch/open311-services/repository"
+)
+
+// provisioning sets up the city of an approved onboarding request.  Every step can be run again, so a
+// provisioning that failed part way is finished by approving the request again.
+type provisioning struct {
+	request  repository.OnboardingRequest
+	city     repository.City
+	catalog  string // city_name of the city whose service catalog is cloned. Empty to start with no services
+	template string // Name of the catalog template applied instead of cloning a city's services
+}
+
+// provisionErr names the step a provisioning failed at
+type provisionErr struct {
+	step string
+	err  error
+}
+
+func (e *provisionErr) Error() string {
+	return fmt.Sprintf("provisioning stopped at %s: %s", e.step, e.err)
+}
+
+// run performs each step of the provisioning in turn, stopping at the first that fails
+func (p *provisioning) run() error {
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"city record", p.createCity},
+		{"service catalog", p.cloneCatalog},
+		{"media prefix", p.createMediaPrefix},
+		{"city admin invite", p.inviteAdmin},
+	}
+
+	for _, step := range steps {
+		err := step.run()
+		if err != nil {
+			return &provisionErr{step.name, err}
+		}
+		infoLogger.Printf("Provisioned %s of %s", step.name, p.city.CityName)
+	}
+	return nil
+}
+
+// createCity adds the Cities record, keeping the record of an earlier attempt
+func (p *provisioning) createCity() error {
+	err := repository.AddCity(p.city)
+	if _, ok := err.(*repository.CityAlreadyExistsErr); ok {
+		return nil
+	}
+	return err
+}
+
+// cloneCatalog copies the services of the catalog template, or else of the catalog city.  Groups of a catalog city
+// name its agencies, so its clones have none until the new city's admins assign their own.
+func (p *provisioning) cloneCatalog() error {
+	if p.template != "" {
+		template, ok := catalog.Get(p.template)
+		if !ok {
+			return fmt.Errorf("no catalog template named %s", p.template)
+		}
+		_, err := addServices(p.city.CityName, template.Services)
+		return err
+	}
+
+	if p.catalog == "" {
+		return nil
+	}
+
+	services, err := repository.GetServices(p.catalog)
+	if err != nil {
+		return err
+	}
+	for i := range services {
+		services[i].Group = ""
+	}
+
+	_, err = addServices(p.city.CityName, services)
+	return err
+}
+
+// addServices adds services to a city's catalog.  Service codes are unique across cities, so each code is prefixed
+// with the city.  Services the city already has are kept, so a catalog can be applied again; the number of
+// services added is returned.
+func addServices(cityName string, services []repository.Service) (int, error) {
+	prefixed := make([]repository.Service, len(services))
+	for i, service := range services {
+		service.ServiceCode = cityServiceCode(cityName, service.ServiceCode)
+		prefixed[i] = service
+	}
+	return repository.AddServices(cityName, prefixed)
+}
+
+// createMediaPrefix marks the city's prefix of the bucket its media is kept in: the shared images bucket, or the
+// city's own in the region it is pinned to
+func (p *provisioning) createMediaPrefix() error {
+	bucket := os.Getenv("IMAGE_BUCKET")
+	svc := s3.New(awsclient.Session())
+	if p.city.MediaBucket != "" {
+		bucket = p.city.MediaBucket
+		svc = s3.New(awsclient.SessionIn(p.city.DataRegion()))
+	}
+
+	_, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(p.city.CityName + "/"),
+		Body:   bytes.NewReader(nil),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create media prefix of %s: %s", p.city.CityName, err)
+	}
+	return nil
+}
+
+// inviteAdmin creates a Cognito account for the requester, which emails them a temporary password, and makes it
+// an admin of the new city.  An existing account is only reused when it already belongs to the city, so approving
+// a request can't move another city's staff.
+func (p *provisioning) inviteAdmin() error {
+	svc := cognito.New(awsclient.Session())
+	poolID := aws.String(os.Getenv("COGNITO_USER_POOL_ID"))
+	username := aws.String(p.request.Email)
+
+	_, err := svc.AdminCreateUser(&cognito.AdminCreateUserInput{
+		UserPoolId: poolID,
+		Username:   username,
+		UserAttributes: []*cognito.AttributeType{
+			{Name: aws.String("email"), Value: username},
+			{Name: aws.String("email_verified"), Value: aws.String("true")},
+			{Name: aws.String("custom:city"), Value: aws.String(p.city.CityName)},
+		},
+		DesiredDeliveryMediums: []*string{aws.String(cognito.DeliveryMediumTypeEmail)},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == cognito.ErrCodeUsernameExistsException {
+		user, err := svc.AdminGetUser(&cognito.AdminGetUserInput{UserPoolId: poolID, Username: username})
+		if err != nil {
+			return fmt.Errorf("unable to get existing account %s: %s", p.request.Email, err)
+		}
+		if attribute(user.UserAttributes, "custom:city") != p.city.CityName {
+			return fmt.Errorf("account %s already exists and does not belong to %s. Make it a city admin by hand", p.request.Email, p.city.CityName)
+		}
+	} else if err != nil {
+		return fmt.Errorf("unable to create account %s: %s", p.request.Email, err)
+	}
+
+	_, err = svc.AdminAddUserToGroup(&cognito.AdminAddUserToGroupInput{
+		UserPoolId: poolID,
+		Username:   username,
+		GroupName:  aws.String(auth.CityAdminGroup),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to add %s to %s: %s", p.request.Email, auth.CityAdminGroup, err)
+	}
+	return nil
+}
+
+// attribute returns the value of a Cognito user attribute, or "" if absent
+func attribute(attributes []*cognito.AttributeType, name string) string {
+	for _, a := range attributes {
+		if aws.StringValue(a.Name) == name {
+			return aws.StringValue(a.Value)
+		}
+	}
+	return ""
+}
+
+// cityServiceCode returns the code of a service cloned into a city: the service's code prefixed with the city's
+// name in lower case, eg "troy-pothole" for service "pothole" cloned into "Troy"
+func cityServiceCode(cityName string, code string) string {
+	slug := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToLower(r)
+		}
+		return '-'
+	}, cityName)
+	slug = strings.Trim(slug, "-")
+	for strings.Contains(slug, "--") {
+		slug = strings.Replace(slug, "--", "-", -1)
+	}
+	return slug + "-" + code
+}
+
+// mediaBucketErr is returned for a city's own media bucket when uploads to it wouldn't reach the media pipeline
+type mediaBucketErr struct {
+	message string
+}
+
+func (e *mediaBucketErr) Error() string {
+	return e.message
+}
+
+// checkMediaBucket makes sure uploads to a city's own media bucket notify the media pipeline, which moderates them
+// and records what they are.  Media that is never moderated stays pending while it is served.  A city without a
+// bucket of its own uses the shared images bucket, which is set up with the stack.
+func checkMediaBucket(city repository.City) error {
+	if city.MediaBucket == "" {
+		return nil
+	}
+
+	svc := s3.New(awsclient.SessionIn(city.DataRegion()))
+	config, err := svc.GetBucketNotificationConfiguration(&s3.GetBucketNotificationConfigurationRequest{
+		Bucket: aws.String(city.MediaBucket),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchBucket {
+		return &mediaBucketErr{fmt.Sprintf("media_bucket '%s' is not a bucket in %s", city.MediaBucket, city.DataRegion())}
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read the event notifications of %s: %s", city.MediaBucket, err)
+	}
+
+	if !notifiesUploads(config, city.CityName+"/") {
+		return &mediaBucketErr{fmt.Sprintf("media_bucket '%s' must notify the Moderation function in %s of s3:ObjectCreated events under %s/", city.MediaBucket, city.DataRegion(), city.CityName)}
+	}
+	return nil
+}
+
+// notifiesUploads reports whether a bucket's notification configuration invokes a function for each object created
+// under prefix
+func notifiesUploads(config *s3.NotificationConfiguration, prefix string) bool {
+	for _, c := range config.LambdaFunctionConfigurations {
+		created := false
+		for _, event := range c.Events {
+			created = created || aws.StringValue(event) == "s3:ObjectCreated:*"
+		}
+		if created && coversPrefix(c.Filter, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// coversPrefix reports whether a notification's filter lets through every key under prefix
+func coversPrefix(filter *s3.NotificationConfigurationFilter, prefix string) bool {
+	if filter == nil || filter.Key == nil {
+		return true
+	}
+	for _, rule := range filter.Key.FilterRules {
+		switch strings.ToLower(aws.StringValue(rule.Name)) {
+		case "prefix":
+			if !strings.HasPrefix(prefix, aws.StringValue(rule.Value)) {
+				return false
+			}
+		case "suffix":
+			if aws.StringValue(rule.Value) != "" {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/repository"
+)
+
+// scheduledWork is how long work on a request is shown for in calendars, which need an end to each event
+const scheduledWork = time.Hour
+
+// icalTime is the layout of UTC times in iCalendar, RFC 5545
+const icalTime = "20060102T150405Z"
+
+// getAgencySchedule serves the open requests of an agency with work scheduled on them as an iCalendar feed, so
+// crews and residents can subscribe to scheduled fixes in their calendar apps.  Calendar apps can't sign in, so
+// events carry only the fields the city publishes as open data.
+func getAgencySchedule(cityName string, id string) (events.APIGatewayProxyResponse, error) {
+	city, err := repository.GetCity(cityName)
+	if err != nil {
+		switch err.(type) {
+		case *repository.CityNotFoundErr:
+			errorMessage := fmt.Errorf("%s.  city_id '%s' not in database", err, cityName)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	agency, err := repository.GetAgency(id)
+	if err != nil {
+		switch err.(type) {
+		case *repository.AgencyNotFoundErr:
+			errorMessage := fmt.Errorf("%s. agency_id '%s' not in database", err, id)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+	if agency.CityID != cityName {
+		return clientError(http.StatusNotFound, fmt.Errorf("agency not found. agency_id '%s' is not an agency of %s", id, cityName))
+	}
+
+	queue, err := repository.GetAgencyQueue(cityName, agency.ID)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers: map[string]string{
+			"content-type":                "text/calendar; charset=utf-8",
+			"Access-Control-Allow-Origin": "*",
+			"Cache-Control":               "max-age=900",
+		},
+		Body: agencyCalendar(city, agency, queue, time.Now()),
+	}, nil
+}
+
+// agencyCalendar returns the requests of an agency's queue with work scheduled on them as an iCalendar, soonest
+// first.  Each request is an event of scheduledWork at its scheduled_datetime, titled by its service and, unless the
+// city redacts it from open data, its address.
+func agencyCalendar(city repository.City, agency repository.Agency, queue []repository.Request, now time.Time) string {
+	redacted := map[string]bool{}
+	for _, field := range city.Config.OpenData.RedactFields {
+		redacted[field] = true
+	}
+
+	scheduled := []repository.Request{}
+	for _, request := range queue {
+		if _, err := time.Parse(time.RFC3339, request.ScheduledDateTime); err == nil {
+			scheduled = append(scheduled, request)
+		}
+	}
+	sort.SliceStable(scheduled, func(i, j int) bool {
+		a, _ := time.Parse(time.RFC3339, scheduled[i].ScheduledDateTime)
+		b, _ := time.Parse(time.RFC3339, scheduled[j].ScheduledDateTime)
+		return a.Before(b)
+	})
+
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Social Torch//Open311 Services//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:" + icalText(agency.Name+" scheduled work"),
+	}
+	if city.Config.TimeZone != "" {
+		lines = append(lines, "X-WR-TIMEZONE:"+city.Config.TimeZone)
+	}
+
+	stamp := now.UTC().Format(icalTime)
+	for _, request := range scheduled {
+		start, _ := time.Parse(time.RFC3339, request.ScheduledDateTime)
+
+		summary := request.ServiceName
+		if summary == "" {
+			summary = request.ServiceCode
+		}
+		if request.Address != "" && !redacted["address"] {
+			summary += " at " + request.Address
+		}
+
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			"UID:"+request.ServiceRequestID+"@open311",
+			"DTSTAMP:"+stamp,
+			"DTSTART:"+start.UTC().Format(icalTime),
+			"DTEND:"+start.Add(scheduledWork).UTC().Format(icalTime),
+			"SUMMARY:"+icalText(summary),
+			"DESCRIPTION:"+icalText(fmt.Sprintf("Request %s, %s", request.ServiceRequestID, request.Status)),
+		)
+		if request.Address != "" && !redacted["address"] {
+			lines = append(lines, "LOCATION:"+icalText(request.Address))
+		}
+		lines = append(lines, "END:VEVENT")
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(icalFold(line))
+		b.WriteString("\r\n")
+	}
+	return b.String()
+}
+
+// icalText escapes a value of an iCalendar text property
+func icalText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// icalFold folds a content line longer than the 75 octets iCalendar allows onto continuation lines, without
+// splitting a UTF-8 character
+func icalFold(line string) string {
+	var b strings.Builder
+	width := 0
+	for _, r := range line {
+		size := len(string(r))
+		if width+size > 75 {
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += size
+	}
+	return b.String()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/auth"
+	"github.com/social-torch/open311-services/repository"
+)
+
+// getWorkloads summarizes the queue of every agency of a city, so supervisors can see where crews are stretched.
+// Each agency's queue is read from the queue_agency-index, never by scanning requests.
+func getWorkloads(city string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !auth.IsAdminOf(city, req) {
+		return clientError(http.StatusForbidden, fmt.Errorf("the workload of %s may only be seen by its city admins", city))
+	}
+
+	agencies, err := repository.GetCityAgencies(city)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	now := time.Now()
+	workloads := []repository.Workload{}
+	for _, agency := range agencies {
+		queue, err := repository.GetAgencyQueue(city, agency.ID)
+		if err != nil {
+			return serverError(http.StatusInternalServerError, err)
+		}
+
+		// Overdue items are listed per agency, keeping the city's summary small
+		workload := repository.NewWorkload(agency, queue, now)
+		workload.OverdueItems = nil
+		workloads = append(workloads, workload)
+	}
+
+	body, err := json.Marshal(workloads)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling NewWorkload() structs"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// getWorkload summarizes the queue of one agency of a city, listing its overdue requests
+func getWorkload(city string, id string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !auth.IsAdminOf(city, req) {
+		return clientError(http.StatusForbidden, fmt.Errorf("the workload of %s may only be seen by its city admins", city))
+	}
+
+	agency, err := repository.GetAgency(id)
+	if err != nil {
+		switch err.(type) {
+		case *repository.AgencyNotFoundErr:
+			errorMessage := fmt.Errorf("%s. agency_id '%s' not in database", err, id)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+	if agency.CityID != city {
+		return clientError(http.StatusNotFound, fmt.Errorf("agency not found. agency_id '%s' is not an agency of %s", id, city))
+	}
+
+	queue, err := repository.GetAgencyQueue(city, agency.ID)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	body, err := json.Marshal(repository.NewWorkload(agency, queue, time.Now()))
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling NewWorkload() struct"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/geo"
+	"github.com/social-torch/open311-services/geocode"
+	"github.com/social-torch/open311-services/repository"
+	"github.com/social-torch/open311-services/validate"
+	"github.com/social-torch/open311-services/warmup"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+
+// Actions a contact flow invokes the handler for, as its action parameter
+const (
+	actionMenu    = "menu"    // Read the services of the city as a keypad menu
+	actionService = "service" // Find the service a caller pressed or said
+	actionLocate  = "locate"  // Find the address a caller said, to read back for confirmation
+	actionCreate  = "create"  // Make the request
+	actionStatus  = "status"  // Read the status of a request, or of the caller's latest requests
+)
+
+// menuSize is how many services the keypad menu offers, one per key from 1 to 9
+const menuSize = 9
+
+// lookupWindow is how far back a caller's requests are looked up when they give no request ID
+const lookupWindow = 30 * 24 * time.Hour
+
+// handler answers Amazon Connect contact flows, so agents and the IVR can take requests by phone.  Flows invoke it
+// with the city_id they answer for and an action, and branch on the flat attributes it returns.  Answers callers
+// can't be given, such as an address that isn't found, are returned as found=false with a message to read them,
+// rather than an error, which flows can't tell from the platform being down.
+func handler(event events.ConnectEvent) (events.ConnectResponse, error) {
+	params := event.Details.Parameters
+	contact := event.Details.ContactData
+
+	city, err := repository.GetCity(params["city_id"])
+	if err != nil {
+		return nil, err
+	}
+	if city.Federated {
+		return nil, fmt.Errorf("%s takes requests on its own server", city.CityName)
+	}
+
+	switch params["action"] {
+	case actionMenu:
+		return menu(city)
+	case actionService:
+		return service(city, params["input"])
+	case actionLocate:
+		return locate(city, params["address"])
+	case actionCreate:
+		return create(city, params, callerAccount(contact.CustomerEndpoint.Address))
+	case actionStatus:
+		return status(city, params["service_request_id"], callerAccount(contact.CustomerEndpoint.Address))
+	}
+	return nil, fmt.Errorf("action '%s' must be %s, %s, %s, %s or %s", params["action"], actionMenu, actionService, actionLocate, actionCreate, actionStatus)
+}
+
+// menu returns the prompt of the city's keypad menu, eg "For Pothole, press 1. For Streetlight out, press 2."
+func menu(city repository.City) (events.ConnectResponse, error) {
+	services, err := menuServices(city.CityName)
+	if err != nil {
+		return nil, err
+	}
+
+	var prompt []string
+	for i, s := range services {
+		prompt = append(prompt, fmt.Sprintf("For %s, press %d.", s.ServiceName, i+1))
+	}
+	return events.ConnectResponse{"prompt": strings.Join(prompt, " "), "count": strconv.Itoa(len(services))}, nil
+}
+
+// menuServices returns the services of the keypad menu: the city's services by name, up to menuSize.  Callers
+// wanting others can say them.
+func menuServices(cityName string) ([]repository.Service, error) {
+	services, err := repository.GetServices(cityName)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].ServiceName < services[j].ServiceName
+	})
+	if len(services) > menuSize {
+		services = services[:menuSize]
+	}
+	return services, nil
+}
+
+// service finds the service a caller pressed the key of on the menu, or said
+func service(city repository.City, input string) (events.ConnectResponse, error) {
+	var services []repository.Service
+	var err error
+	if _, digit := strconv.Atoi(strings.TrimSpace(input)); digit == nil {
+		services, err = menuServices(city.CityName)
+	} else {
+		services, err = repository.GetServices(city.CityName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s, ok := matchService(services, input)
+	if !ok {
+		return notFound("Sorry, we didn't catch which issue you're reporting.")
+	}
+	return events.ConnectResponse{"found": "true", "service_code": s.ServiceCode, "service_name": s.ServiceName}, nil
+}
+
+// matchService returns the service caller input names: its position on the menu, its service_code, or speech
+// naming the service or one of its keywords, eg "there's a pothole on my street".  Longer names and keywords win,
+// so "streetlight out" beats "street".
+func matchService(services []repository.Service, input string) (repository.Service, bool) {
+	input = strings.ToLower(strings.TrimSpace(input))
+	if input == "" {
+		return repository.Service{}, false
+	}
+	if n, err := strconv.Atoi(input); err == nil {
+		if n < 1 || n > len(services) {
+			return repository.Service{}, false
+		}
+		return services[n-1], true
+	}
+
+	var best repository.Service
+	longest := 0
+	for _, s := range services {
+		if strings.ToLower(s.ServiceCode) == input {
+			return s, true
+		}
+		for _, term := range append([]string{s.ServiceName}, s.Keywords...) {
+			term = strings.ToLower(strings.TrimSpace(term))
+			if term != "" && len(term) > longest && strings.Contains(input, term) {
+				best, longest = s, len(term)
+			}
+		}
+	}
+	return best, longest > 0
+}
+
+// locate finds the address a caller said in the city, returning it as the place index has it to be read back
+func locate(city repository.City, address string) (events.ConnectResponse, error) {
+	match, err := geocodeAddress(city, address)
+	if err != nil {
+		switch err.(type) {
+		case *callerErr:
+			return notFound(err.Error())
+		default:
+			return nil, err
+		}
+	}
+	return events.ConnectResponse{"found": "true", "address": match.Place.Address}, nil
+}
+
+// create makes the request of a call.  The address is located as by locate, which is cached, so the request is made
+// at the address read back to the caller.
+func create(city repository.City, params map[string]string, accountID string) (events.ConnectResponse, error) {
+	if city.Deactivated {
+		notice := city.DeactivationNotice
+		if notice == "" {
+			notice = fmt.Sprintf("%s is not taking new requests right now. Please try again later.", city.CityName)
+		}
+		return notFound(notice)
+	}
+
+	s, err := repository.LookupService(city.CityName, params["service_code"])
+	if err != nil {
+		if _, ok := err.(*repository.ServiceCodeNotFoundErr); !ok {
+			return nil, err
+		}
+		return nil, fmt.Errorf("service_code '%s' not offered by %s", params["service_code"], city.CityName)
+	}
+
+	match, err := geocodeAddress(city, params["address"])
+	if err != nil {
+		switch err.(type) {
+		case *callerErr:
+			return notFound(err.Error())
+		default:
+			return nil, err
+		}
+	}
+	lat, lon := match.Place.Latitude, match.Place.Longitude
+	location, err := repository.NewLocation(lat, lon)
+	if err != nil {
+		return nil, fmt.Errorf("place index located '%s' at an invalid location: %s", params["address"], err)
+	}
+
+	request := repository.Request{
+		CityID:       city.CityName,
+		ServiceCode:  s.ServiceCode,
+		Description:  params["description"],
+		Address:      match.Place.Address,
+		AddressID:    match.Place.AddressID,
+		ZipCode:      match.Place.ZipCode,
+		Location:     location,
+		Neighborhood: city.NeighborhoodOf(lat, lon),
+	}
+	if err := validate.Request(request); err != nil {
+		if _, ok := err.(*validate.InvalidRequestErr); ok {
+			warningLogger.Println(err)
+			return notFound("Sorry, we couldn't take that request. Please try again with a shorter description.")
+		}
+		return nil, err
+	}
+	response, err := repository.SubmitRequest(request, accountID)
+	if err != nil {
+		return nil, err
+	}
+	infoLogger.Println("New request taken by phone: " + response.ServiceRequestID)
+
+	return events.ConnectResponse{
+		"found":              "true",
+		"service_request_id": response.ServiceRequestID,
+		"prompt":             fmt.Sprintf("Your %s request at %s has been made. Call back any time to hear its status.", s.ServiceName, match.Place.Address),
+	}, nil
+}
+
+// status reads the status of a request an agent looked up, or else of the caller's requests of the lookupWindow,
+// latest first
+func status(city repository.City, requestID string, accountID string) (events.ConnectResponse, error) {
+	var requests []repository.Request
+	if requestID != "" {
+		request, err := repository.GetRequest(city.CityName, requestID)
+		if err != nil {
+			if _, ok := err.(*repository.RequestIdNotFoundErr); ok {
+				return notFound("Sorry, we couldn't find that request.")
+			}
+			return nil, err
+		}
+		if request.CityID != city.CityName {
+			return notFound("Sorry, we couldn't find that request.")
+		}
+		requests = append(requests, request)
+	} else {
+		// Every withheld number is a guest, so their requests can't be told apart
+		if accountID == "guest" {
+			return notFound("We can't look up requests by phone when your number is withheld.")
+		}
+		now := time.Now()
+		recent, err := repository.GetRequestsBetween(city.CityName, now.Add(-lookupWindow), now)
+		if err != nil {
+			return nil, err
+		}
+		requests = callerRequests(recent, accountID)
+	}
+
+	if len(requests) == 0 {
+		return notFound("We couldn't find any requests made from this phone in the last 30 days.")
+	}
+	return events.ConnectResponse{"found": "true", "prompt": statusPrompt(requests, city.Config.Location())}, nil
+}
+
+// maxStatuses is how many of a caller's requests are read out
+const maxStatuses = 3
+
+// callerRequests returns the requests made by a caller, latest first, up to maxStatuses
+func callerRequests(requests []repository.Request, accountID string) []repository.Request {
+	var mine []repository.Request
+	for _, request := range requests {
+		if request.AccountID == accountID {
+			mine = append(mine, request)
+		}
+	}
+	sort.Slice(mine, func(i, j int) bool {
+		return mine[i].RequestedDateTime > mine[j].RequestedDateTime
+	})
+	if len(mine) > maxStatuses {
+		mine = mine[:maxStatuses]
+	}
+	return mine
+}
+
+// spokenStatuses are request statuses as they are read to callers
+var spokenStatuses = map[string]string{
+	repository.RequestOpen:       "open",
+	repository.RequestAccepted:   "accepted",
+	repository.RequestInProgress: "in progress",
+	repository.RequestClosed:     "closed",
+}
+
+// statusPrompt reads out requests, eg "Your Pothole request at 433 River St, made June 3, is in progress."
+func statusPrompt(requests []repository.Request, loc *time.Location) string {
+	var prompt []string
+	for _, request := range requests {
+		sentence := fmt.Sprintf("Your %s request", request.ServiceName)
+		if request.Address != "" {
+			sentence += " at " + request.Address
+		}
+		if made, err := time.Parse(time.RFC3339, request.RequestedDateTime); err == nil {
+			sentence += ", made " + made.In(loc).Format("January 2") + ","
+		}
+		status, ok := spokenStatuses[request.Status]
+		if !ok {
+			status = request.Status
+		}
+		sentence += " is " + status + "."
+		if request.StatusNotes != "" && request.Status == repository.RequestClosed {
+			sentence += " " + request.StatusNotes
+		}
+		prompt = append(prompt, sentence)
+	}
+	return strings.Join(prompt, " ")
+}
+
+// callerErr is returned for caller input that can't be acted on.  Its message is read to the caller.
+type callerErr struct {
+	message string
+}
+
+func (e *callerErr) Error() string {
+	return e.message
+}
+
+// geocodeAddress locates an address a caller said within the city and its limits
+func geocodeAddress(city repository.City, address string) (geocode.Match, error) {
+	if strings.TrimSpace(address) == "" {
+		return geocode.Match{}, &callerErr{"Sorry, we didn't catch the address."}
+	}
+
+	var area *geo.Box
+	if box, ok := city.Box(); ok {
+		area = &box
+	}
+	match, err := geocode.ForCity(city).Forward(address, area)
+	if err != nil {
+		switch err.(type) {
+		case *geocode.NotFoundErr:
+			return match, &callerErr{fmt.Sprintf("Sorry, we couldn't find %s in %s.", address, city.CityName)}
+		default:
+			return match, err
+		}
+	}
+	if len(city.Boundary) > 0 && !city.Boundary.Contains(match.Place.Latitude, match.Place.Longitude) {
+		return match, &callerErr{fmt.Sprintf("%s is outside the city limits of %s.", match.Place.Address, city.CityName)}
+	}
+	return match, nil
+}
+
+// callerAccount returns the account requests made from a phone number are credited to.  The number is kept out of
+// account IDs, which are listed with requests, as an HMAC keyed by CALLER_ID_SECRET; withheld numbers are guests.
+func callerAccount(phoneNumber string) string {
+	if phoneNumber == "" || os.Getenv("CALLER_ID_SECRET") == "" {
+		return "guest"
+	}
+	mac := hmac.New(sha256.New, []byte(os.Getenv("CALLER_ID_SECRET")))
+	mac.Write([]byte(phoneNumber))
+	return "phone:" + hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// notFound answers a flow with a message to read the caller, when what they asked for can't be done
+func notFound(message string) (events.ConnectResponse, error) {
+	warningLogger.Println(message)
+	return events.ConnectResponse{"found": "false", "prompt": message}, nil
+}
+
+func main() {
+	warmup.Start("connect", handler, repository.Warm)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestMatchService(t *testing.T) {
+	services := []repository.Service{
+		{ServiceCode: "troy-graffiti", ServiceName: "Graffiti"},
+		{ServiceCode: "troy-pothole", ServiceName: "Pothole", Keywords: []string{"hole in the road"}},
+		{ServiceCode: "troy-street", ServiceName: "Street"},
+		{ServiceCode: "troy-streetlight", ServiceName: "Streetlight out", Keywords: []string{"light"}},
+	}
+
+	tests := map[string]string{
+		"2":                                 "troy-pothole",
+		" 4 ":                               "troy-streetlight",
+		"troy-graffiti":                     "troy-graffiti",
+		"There's a huge POTHOLE on my road": "troy-pothole",
+		"a hole in the road":                "troy-pothole",
+		"the streetlight out front":         "troy-streetlight",
+		"something wrong with the street":   "troy-street",
+	}
+	for input, want := range tests {
+		if got, ok := matchService(services, input); !ok || got.ServiceCode != want {
+			t.Errorf("matchService(%q) = %s, %v, want %s", input, got.ServiceCode, ok, want)
+		}
+	}
+
+	for _, input := range []string{"", "0", "5", "a noisy neighbour"} {
+		if got, ok := matchService(services, input); ok {
+			t.Errorf("matchService(%q) = %s, want no match", input, got.ServiceCode)
+		}
+	}
+}
+
+func TestCallerRequests(t *testing.T) {
+	requests := []repository.Request{
+		{ServiceRequestID: "SR-1", AccountID: "phone:a", RequestedDateTime: "2019-06-01T12:00:00Z"},
+		{ServiceRequestID: "SR-2", AccountID: "phone:b", RequestedDateTime: "2019-06-02T12:00:00Z"},
+		{ServiceRequestID: "SR-3", AccountID: "phone:a", RequestedDateTime: "2019-06-03T12:00:00Z"},
+		{ServiceRequestID: "SR-4", AccountID: "phone:a", RequestedDateTime: "2019-06-04T12:00:00Z"},
+		{ServiceRequestID: "SR-5", AccountID: "phone:a", RequestedDateTime: "2019-06-05T12:00:00Z"},
+	}
+	mine := callerRequests(requests, "phone:a")
+	if len(mine) != maxStatuses || mine[0].ServiceRequestID != "SR-5" || mine[2].ServiceRequestID != "SR-3" {
+		t.Errorf("callerRequests() = %+v, want the caller's latest %d", mine, maxStatuses)
+	}
+}
+
+func TestStatusPrompt(t *testing.T) {
+	loc, _ := time.LoadLocation("America/New_York")
+	requests := []repository.Request{
+		{ServiceName: "Pothole", Address: "433 River St", RequestedDateTime: "2019-06-03T02:00:00Z", Status: repository.RequestInProgress},
+		{ServiceName: "Graffiti", RequestedDateTime: "2019-05-20T15:00:00Z", Status: repository.RequestClosed, StatusNotes: "Painted over."},
+	}
+	want := "Your Pothole request at 433 River St, made June 2, is in progress. Your Graffiti request, made May 20, is closed. Painted over."
+	if got := statusPrompt(requests, loc); got != want {
+		t.Errorf("statusPrompt() = %q, want %q", got, want)
+	}
+}
+
+func TestCallerAccount(t *testing.T) {
+	os.Setenv("CALLER_ID_SECRET", "secret")
+	defer os.Unsetenv("CALLER_ID_SECRET")
+
+	account := callerAccount("+15182704400")
+	if !strings.HasPrefix(account, "phone:") || strings.Contains(account, "5182704400") {
+		t.Errorf("callerAccount() = %s, want an HMAC of the number", account)
+	}
+	if callerAccount("+15182704400") != account || callerAccount("+15182704401") == account {
+		t.Error("callerAccount() should be the same for a number and differ between numbers")
+	}
+	if got := callerAccount(""); got != "guest" {
+		t.Errorf("callerAccount() of a withheld number = %s, want guest", got)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/social-torch/open311-services/apierror"
+	"github.com/social-torch/open311-services/repository"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+var errorLogger = log.New(os.Stderr, "ERROR\t", log.Lshortfile)
+
+// subscribeMessage is sent by clients to scope the updates they receive
+type subscribeMessage struct {
+	Action  string                    `json:"action"`
+	Filters []repository.Subscription `json:"filters"`
+}
+
+// Route WebSocket requests
+func router(req events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+	apierror.SetRequestID(req.RequestContext.RequestID)
+	id := req.RequestContext.ConnectionID
+
+	switch req.RequestContext.RouteKey {
+	case "$connect":
+		return connect(id, req)
+	case "$disconnect":
+		return disconnect(id)
+	case "subscribe":
+		return subscribe(id, req)
+	}
+	return clientError(http.StatusBadRequest, fmt.Errorf("unknown route '%s'", req.RequestContext.RouteKey))
+}
+
+func connect(id string, req events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+	accountID := req.QueryStringParameters["account_id"]
+	if accountID == "" {
+		accountID = "guest"
+	}
+
+	err := repository.AddConnection(repository.Connection{ID: id, AccountID: accountID})
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	infoLogger.Printf("Connection %s opened by %s", id, accountID)
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+}
+
+func disconnect(id string) (events.APIGatewayProxyResponse, error) {
+	err := repository.DeleteConnection(id)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+}
+
+func subscribe(id string, req events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var message subscribeMessage
+	err := json.Unmarshal([]byte(req.Body), &message)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, errors.New("error unmarshalling subscribe message JSON. Check syntax"))
+	}
+
+	err = validateFilters(message.Filters)
+	if err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+
+	err = repository.SetConnectionFilters(id, message.Filters)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	return events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil
+}
+
+// validateFilters checks that each filter is of a known type, and that area filters are centred on a valid location
+func validateFilters(filters []repository.Subscription) error {
+	for _, filter := range filters {
+		switch filter.Type {
+		case repository.RequestSubscription, repository.ServiceSubscription:
+		case repository.AreaSubscription:
+			if !filter.HasLocation() || filter.ValidateLocation() != nil {
+				return errors.New("area filters need a valid lat and lon")
+			}
+		default:
+			return errors.New("filter type must be 'request', 'service' or 'area'")
+		}
+	}
+	return nil
+}
+
+func serverError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
+	errorLogger.Println(err.Error())
+	return apierror.Response(statusCode, err), nil
+}
+
+func clientError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
+	warningLogger.Println(err.Error())
+	return apierror.Response(statusCode, err), nil
+}
+
+func main() {
+	lambda.Start(router)
+}
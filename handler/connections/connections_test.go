@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestValidateFilters(t *testing.T) {
+	tests := []struct {
+		filters string
+		valid   bool
+	}{
+		{`[]`, true},
+		{`[{"type":"request","service_request_id":"42"},{"type":"service","service_code":"001"}]`, true},
+		{`[{"type":"area","lat":42.65,"lon":-73.75,"radius":500}]`, true},
+		{`[{"type":"area","radius":500}]`, false},
+		{`[{"type":"area","lat":91,"lon":-73.75}]`, false},
+		{`[{"type":"area","lat":42.65,"lon":-181}]`, false},
+		{`[{"type":"request"},{"type":"ward"}]`, false},
+		{`[{}]`, false},
+	}
+
+	for _, test := range tests {
+		var filters []repository.Subscription
+		if err := json.Unmarshal([]byte(test.filters), &filters); err != nil {
+			t.Fatalf("Unmarshal(%s) = %v", test.filters, err)
+		}
+		if err := validateFilters(filters); (err == nil) != test.valid {
+			t.Errorf("validateFilters(%s) = %v, want valid %v", test.filters, err, test.valid)
+		}
+	}
+}
+
+func TestRouterRejects(t *testing.T) {
+	tests := []struct {
+		route string
+		body  string
+		want  int
+	}{
+		{"subscribe", `{"action":"subscribe","filters":`, http.StatusUnprocessableEntity},
+		{"subscribe", `{"action":"subscribe","filters":[{"type":"ward"}]}`, http.StatusBadRequest},
+		{"$default", `{}`, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		req := events.APIGatewayWebsocketProxyRequest{Body: test.body}
+		req.RequestContext.RouteKey = test.route
+		resp, err := router(req)
+		if err != nil || resp.StatusCode != test.want {
+			t.Errorf("router(%s, %s) = %d, %v, want %d", test.route, test.body, resp.StatusCode, err, test.want)
+		}
+	}
+}
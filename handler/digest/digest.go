@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/social-torch/open311-services/notification"
+	"github.com/social-torch/open311-services/repository"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+
+// handler runs daily, emailing daily digest users a summary of the last day and, on Mondays, weekly digest
+// users a summary of the last week
+func handler(event events.CloudWatchEvent) error {
+	now := time.Now()
+	periods := digestPeriods(now)
+
+	requests := map[string][]repository.Request{}
+	cities := map[string]repository.City{}
+
+	for frequency, period := range periods {
+		users, err := repository.GetDigestUsers(frequency)
+		if err != nil {
+			return err
+		}
+
+		for _, user := range users {
+			if user.Preferences.EmailAddress == "" {
+				continue
+			}
+
+			subscriptions, err := repository.GetUserSubscriptions(user.AccountID)
+			if err != nil {
+				return err
+			}
+
+			inCity, err := cityRequests(requests, user.CityID, now)
+			if err != nil {
+				return err
+			}
+
+			digest := notification.NewDigest(subscriptions, inCity, now.Add(-period))
+			if digest.Empty() {
+				continue
+			}
+
+			err = sendDigest(cityOf(cities, user.CityID), user, frequency, digest)
+			if err != nil {
+				// One undeliverable address should not hold back everyone else's digest
+				warningLogger.Println(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// digestPeriods returns the period summarized by each digest frequency due on a day: a day, and on Mondays a week
+func digestPeriods(now time.Time) map[string]time.Duration {
+	periods := map[string]time.Duration{repository.DigestDaily: 24 * time.Hour}
+	if now.Weekday() == time.Monday {
+		periods[repository.DigestWeekly] = 7 * 24 * time.Hour
+	}
+	return periods
+}
+
+// digestLookback is how long before a digest requests are looked at.  Requests made earlier are left out even when
+// they close during the digest's period.
+const digestLookback = 90 * 24 * time.Hour
+
+// cityRequests returns the requests of a city made within the digestLookback, reading each city's once per run.
+// Users without a city, from before requests were partitioned by city, hear about every request.
+func cityRequests(requests map[string][]repository.Request, cityID string, now time.Time) ([]repository.Request, error) {
+	if inCity, ok := requests[cityID]; ok {
+		return inCity, nil
+	}
+
+	inCity, err := repository.GetRequestsBetween(cityID, now.Add(-digestLookback), now)
+	if err != nil {
+		return nil, err
+	}
+	requests[cityID] = inCity
+	return inCity, nil
+}
+
+// cityOf returns the city whose copy and sender apply to a user's digest, looking each city up once per run.
+// Cities that can't be looked up fall back to the platform's copy and sender.
+func cityOf(cities map[string]repository.City, cityID string) repository.City {
+	if cityID == "" {
+		return repository.City{}
+	}
+	if city, ok := cities[cityID]; ok {
+		return city
+	}
+
+	city, err := repository.GetCity(cityID)
+	if err != nil {
+		warningLogger.Printf("Unable to get city %s, using platform defaults: %s", cityID, err)
+		city = repository.City{}
+	}
+	cities[cityID] = city
+	return city
+}
+
+func sendDigest(city repository.City, user repository.User, frequency string, digest notification.Digest) error {
+	unsubscribeURL := fmt.Sprintf("%s/user/%s/unsubscribe?token=%s",
+		os.Getenv("API_URL"), url.PathEscape(user.AccountID), notification.UnsubscribeToken(user.AccountID))
+
+	id, err := notification.SendCityEmail(city, user.Preferences.Language, user.Preferences.EmailAddress, notification.DigestTemplate,
+		map[string]string{
+			"frequency":       frequency,
+			"created_count":   strconv.Itoa(len(digest.Created)),
+			"resolved_count":  strconv.Itoa(len(digest.Resolved)),
+			"summary":         digest.Summary(),
+			"unsubscribe_url": unsubscribeURL,
+		})
+	if err != nil {
+		return err
+	}
+
+	infoLogger.Printf("Sent %s digest to %s (message %s)", frequency, user.AccountID, id)
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
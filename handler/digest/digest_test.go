@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestDigestPeriods(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		date string
+		want map[string]time.Duration
+	}{
+		{"2019-06-03", map[string]time.Duration{repository.DigestDaily: day, repository.DigestWeekly: 7 * day}}, // Monday
+		{"2019-06-04", map[string]time.Duration{repository.DigestDaily: day}},
+		{"2019-06-09", map[string]time.Duration{repository.DigestDaily: day}},
+	}
+
+	for _, test := range tests {
+		now, _ := time.Parse("2006-01-02", test.date)
+		if got := digestPeriods(now); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("digestPeriods(%s) = %v, want %v", test.date, got, test.want)
+		}
+	}
+}
+
+func TestCityCaches(t *testing.T) {
+	albany := []repository.Request{{ServiceRequestID: "42", CityID: "albany"}}
+	requests := map[string][]repository.Request{"albany": albany}
+	got, err := cityRequests(requests, "albany", time.Now())
+	if err != nil || !reflect.DeepEqual(got, albany) {
+		t.Errorf("cityRequests(albany) = %v, %v, want cached %v", got, err, albany)
+	}
+
+	cities := map[string]repository.City{"albany": {CityName: "albany", SenderEmail: "311@albanyny.gov"}}
+	if got := cityOf(cities, "albany"); got.SenderEmail != "311@albanyny.gov" {
+		t.Errorf("cityOf(albany) = %v, want cached city", got)
+	}
+	if got := cityOf(cities, ""); !reflect.DeepEqual(got, repository.City{}) {
+		t.Errorf("cityOf(\"\") = %v, want platform defaults", got)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/social-torch/open311-services/notification"
+	"github.com/social-torch/open311-services/repository"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+
+// Delivery is attempted this many times, backing off between attempts
+const maxAttempts = 3
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// handler posts a domain event to every webhook of its city subscribed to its type
+func handler(event events.CloudWatchEvent) error {
+	var requestEvent repository.RequestEvent
+	err := json.Unmarshal(event.Detail, &requestEvent)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling domain event detail: %s", err)
+	}
+
+	webhooks, err := repository.GetWebhooks()
+	if err != nil {
+		return err
+	}
+
+	for _, webhook := range webhooks {
+		if !subscribed(webhook, requestEvent) {
+			continue
+		}
+
+		body := []byte(event.Detail)
+		if webhook.Format == repository.WebhookFormatRESTHook {
+			body, err = json.Marshal(notification.HookPayload(requestEvent))
+			if err != nil {
+				warningLogger.Printf("Failed to marshal REST hook payload for webhook %s: %s", webhook.ID, err)
+				continue
+			}
+		}
+
+		delivery := deliver(webhook, body)
+		delivery.EventType = requestEvent.Type
+		delivery.ServiceRequestID = requestEvent.ServiceRequestID
+
+		err = repository.RecordWebhookDelivery(delivery)
+		if err != nil {
+			// The event was delivered (or given up on); a missing log entry should not cause a redelivery
+			warningLogger.Println(err)
+		}
+
+		// REST hook subscribers answer 410 Gone once the subscription was removed on their side, eg a Zap turned off
+		if webhook.Format == repository.WebhookFormatRESTHook && delivery.StatusCode == http.StatusGone {
+			infoLogger.Printf("Unsubscribing REST hook %s, which is gone", webhook.ID)
+			err = repository.DeleteWebhook(webhook.ID)
+			if err != nil {
+				warningLogger.Println(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// subscribed reports whether a webhook takes an event: one of its types, of its city and, when the webhook is
+// filtered to a service, of that service.  Events of requests without a city go only to webhooks without one.
+func subscribed(webhook repository.Webhook, e repository.RequestEvent) bool {
+	if webhook.City != e.Request.CityID {
+		return false
+	}
+	if webhook.ServiceCode != "" && webhook.ServiceCode != e.Request.ServiceCode {
+		return false
+	}
+	for _, t := range webhook.EventTypes {
+		if t == e.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// deliver posts the signed body to a webhook, retrying failed attempts
+func deliver(webhook repository.Webhook, body []byte) repository.WebhookDelivery {
+	delivery := repository.WebhookDelivery{WebhookID: webhook.ID}
+	signature := notification.Sign(webhook.Secret, body)
+
+	backoff := time.Second
+	for delivery.Attempts < maxAttempts {
+		if delivery.Attempts > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		delivery.Attempts++
+
+		req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(body))
+		if err != nil {
+			delivery.Error = err.Error()
+			break
+		}
+		req.Header.Set("content-type", "application/json")
+		req.Header.Set(notification.SignatureHeader, signature)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			delivery.StatusCode = 0
+			delivery.Error = err.Error()
+			continue
+		}
+		resp.Body.Close()
+
+		delivery.StatusCode = resp.StatusCode
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			delivery.Error = ""
+			infoLogger.Printf("Delivered event to webhook %s", webhook.ID)
+			return delivery
+		}
+		delivery.Error = http.StatusText(resp.StatusCode)
+
+		// Other client errors will not succeed on retry
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			break
+		}
+	}
+
+	warningLogger.Printf("Failed to deliver event to webhook %s after %d attempts: %s", webhook.ID, delivery.Attempts, delivery.Error)
+	return delivery
+}
+
+func main() {
+	lambda.Start(handler)
+}
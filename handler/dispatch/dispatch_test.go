@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestSubscribed(t *testing.T) {
+	event := repository.RequestEvent{
+		Type:    repository.StatusChangedEvent,
+		Request: repository.Request{CityID: "troy", ServiceCode: "pothole"},
+	}
+
+	tests := []struct {
+		name    string
+		webhook repository.Webhook
+		want    bool
+	}{
+		{"type", repository.Webhook{City: "troy", EventTypes: []string{repository.StatusChangedEvent}}, true},
+		{"other type", repository.Webhook{City: "troy", EventTypes: []string{repository.RequestCreatedEvent}}, false},
+		{"other city", repository.Webhook{City: "albany", EventTypes: []string{repository.StatusChangedEvent}}, false},
+		{"no city", repository.Webhook{EventTypes: []string{repository.StatusChangedEvent}}, false},
+		{"service", repository.Webhook{City: "troy", ServiceCode: "pothole", EventTypes: []string{repository.StatusChangedEvent}}, true},
+		{"other service", repository.Webhook{City: "troy", ServiceCode: "graffiti", EventTypes: []string{repository.StatusChangedEvent}}, false},
+	}
+	for _, tt := range tests {
+		if got := subscribed(tt.webhook, event); got != tt.want {
+			t.Errorf("%s: subscribed() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Requests without a city reach only webhooks that have none either
+	event.Request.CityID = ""
+	if subscribed(repository.Webhook{City: "troy", EventTypes: []string{repository.StatusChangedEvent}}, event) {
+		t.Error("subscribed() of a city's webhook to an event without a city = true, want false")
+	}
+	if !subscribed(repository.Webhook{EventTypes: []string{repository.StatusChangedEvent}}, event) {
+		t.Error("subscribed() of a webhook without a city to an event without one = false, want true")
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/social-torch/open311-services/notification"
+	"github.com/social-torch/open311-services/repository"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+
+// defaultBackoff is the wait before a breached request is escalated again, when ESCALATION_BACKOFF_HOURS is unset.
+// The wait doubles with every escalation.
+const defaultBackoff = 24 * time.Hour
+
+// handler runs hourly, escalating open requests that are past their expected or SLA resolution time to the
+// supervisors of the agency responsible
+func handler(event events.CloudWatchEvent) error {
+	backoff := defaultBackoff
+	if v, err := strconv.Atoi(os.Getenv("ESCALATION_BACKOFF_HOURS")); err == nil && v > 0 {
+		backoff = time.Duration(v) * time.Hour
+	}
+
+	requests, err := repository.GetRequests("")
+	if err != nil {
+		return err
+	}
+
+	services := map[string]repository.Service{}
+	agencies := map[string]*repository.Agency{}
+	cities := map[string]repository.City{}
+	now := time.Now()
+
+	for _, request := range requests {
+		if request.Status == repository.RequestClosed {
+			continue
+		}
+
+		service, ok := services[request.ServiceCode]
+		if !ok {
+			service, _ = repository.GetService(request.ServiceCode)
+			services[request.ServiceCode] = service
+		}
+
+		city := cityOf(cities, request.CityID)
+		due, ok := deadline(request, service, city.Config)
+		if !ok || !dueForEscalation(request, due, now, backoff) {
+			continue
+		}
+
+		agency, ok := agencies[request.AgencyResponsible]
+		if !ok {
+			agency, err = getAgency(request.AgencyResponsible)
+			if err != nil {
+				return err
+			}
+			agencies[request.AgencyResponsible] = agency
+		}
+		if agency == nil {
+			warningLogger.Printf("Request %s breached its SLA but has no agency to escalate to", request.ServiceRequestID)
+			continue
+		}
+
+		level := request.EscalationLevel + 1
+		escalate(city, *agency, request, due, level)
+
+		err = repository.RecordEscalation(request.CityID, request.ServiceRequestID, level)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deadline returns when a request should be resolved by: its expected time when one was given, and otherwise its
+// service's SLA or the city's default SLA, counted in the city's business hours.  ok is false when none applies.
+func deadline(request repository.Request, service repository.Service, config repository.CityConfig) (time.Time, bool) {
+	if expected, err := time.Parse(time.RFC3339, request.ExpectedDateTime); err == nil {
+		return expected, true
+	}
+
+	requested, err := time.Parse(time.RFC3339, request.RequestedDateTime)
+	slaHours := config.SLAHours(service)
+	if err != nil || slaHours <= 0 {
+		return time.Time{}, false
+	}
+	return config.Due(requested, slaHours), true
+}
+
+// dueForEscalation reports whether a request past its deadline should be escalated now.  The first escalation
+// happens as soon as the deadline passes; each later one waits twice as long as the one before.
+func dueForEscalation(request repository.Request, due time.Time, now time.Time, backoff time.Duration) bool {
+	if now.Before(due) {
+		return false
+	}
+	if request.EscalationLevel == 0 {
+		return true
+	}
+
+	last, err := time.Parse(time.RFC3339, request.EscalatedDateTime)
+	if err != nil {
+		return true
+	}
+	wait := backoff << uint(request.EscalationLevel-1)
+	return !now.Before(last.Add(wait))
+}
+
+// getAgency returns nil when the agency is not configured
+func getAgency(id string) (*repository.Agency, error) {
+	if id == "" {
+		return nil, nil
+	}
+
+	agency, err := repository.GetAgency(id)
+	if err != nil {
+		switch err.(type) {
+		case *repository.AgencyNotFoundErr:
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+	return &agency, nil
+}
+
+// cityOf returns the city whose sender applies to escalations of its requests, looking each city up once per
+// run.  Requests made to no city in particular, or whose city can't be looked up, use the platform sender.
+func cityOf(cities map[string]repository.City, cityID string) repository.City {
+	if cityID == "" {
+		return repository.City{}
+	}
+	if city, ok := cities[cityID]; ok {
+		return city
+	}
+
+	city, err := repository.GetCity(cityID)
+	if err != nil {
+		warningLogger.Printf("Unable to get city %s, using platform defaults: %s", cityID, err)
+		city = repository.City{}
+	}
+	cities[cityID] = city
+	return city
+}
+
+// escalate tells an agency's supervisors about a breached request through each channel the agency configured
+func escalate(city repository.City, agency repository.Agency, request repository.Request, due time.Time, level int) {
+	overdue := time.Since(due).Round(time.Hour).String()
+
+	for _, address := range agency.SupervisorEmails {
+		id, err := notification.SendEmail(notification.Sender(city), address, notification.SLABreachTemplate,
+			map[string]string{
+				"agency":             agency.Name,
+				"service_request_id": request.ServiceRequestID,
+				"service_name":       request.ServiceName,
+				"status":             request.Status,
+				"address":            request.Address,
+				"due":                due.Format(time.RFC1123),
+				"overdue":            overdue,
+				"escalation_level":   strconv.Itoa(level),
+			})
+		record(request, repository.ChannelEmail, address, id, err)
+		if err != nil {
+			warningLogger.Println(err)
+		}
+	}
+
+	if agency.SlackWebhookURL != "" {
+		text := fmt.Sprintf("Escalation %d: %s request %s is %s overdue and still %s",
+			level, request.ServiceName, request.ServiceRequestID, overdue, request.Status)
+		err := notification.PostSlack(agency.SlackWebhookURL, text)
+		record(request, repository.ChannelSlack, agency.ID, "", err)
+		if err != nil {
+			warningLogger.Println(err)
+		}
+	}
+
+	infoLogger.Printf("Escalated request %s to %s (level %d)", request.ServiceRequestID, agency.ID, level)
+}
+
+// record logs an escalation attempt in the request's delivery log
+func record(request repository.Request, channel string, recipient string, messageID string, err error) {
+	delivery := repository.NotificationDelivery{
+		ServiceRequestID: request.ServiceRequestID,
+		Channel:          channel,
+		Recipient:        recipient,
+		MessageID:        messageID,
+		Status:           repository.DeliverySent,
+		Attempt:          1,
+	}
+	if err != nil {
+		delivery.Status = repository.DeliveryFailed
+		delivery.Error = err.Error()
+	}
+
+	err = repository.RecordNotificationDelivery(delivery)
+	if err != nil {
+		warningLogger.Println(err)
+	}
+}
+
+func main() {
+	lambda.Start(handler)
+}
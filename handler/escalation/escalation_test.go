@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestDeadline(t *testing.T) {
+	requested := "2019-06-01T09:00:00Z"
+	expected := "2019-06-03T09:00:00Z"
+
+	due, ok := deadline(repository.Request{RequestedDateTime: requested, ExpectedDateTime: expected}, repository.Service{SLAHours: 4}, repository.CityConfig{})
+	if !ok || due.Format(time.RFC3339) != expected {
+		t.Errorf("deadline with expected time = %v, %v, want %s", due, ok, expected)
+	}
+
+	due, ok = deadline(repository.Request{RequestedDateTime: requested}, repository.Service{SLAHours: 4}, repository.CityConfig{})
+	if !ok || due.Format(time.RFC3339) != "2019-06-01T13:00:00Z" {
+		t.Errorf("deadline from SLA = %v, %v, want 2019-06-01T13:00:00Z", due, ok)
+	}
+
+	due, ok = deadline(repository.Request{RequestedDateTime: requested}, repository.Service{}, repository.CityConfig{DefaultSLAHours: 48})
+	if !ok || due.Format(time.RFC3339) != "2019-06-03T09:00:00Z" {
+		t.Errorf("deadline from city default SLA = %v, %v, want 2019-06-03T09:00:00Z", due, ok)
+	}
+
+	// 2019-06-01 is a Saturday, so an 8 business hour SLA runs through Monday's working day
+	weekdays := repository.BusinessHours{Open: "09:00", Close: "17:00"}
+	config := repository.CityConfig{Calendar: repository.BusinessCalendar{Hours: map[string]repository.BusinessHours{"monday": weekdays, "tuesday": weekdays}}}
+	due, ok = deadline(repository.Request{RequestedDateTime: requested}, repository.Service{SLAHours: 8}, config)
+	if !ok || due.Format(time.RFC3339) != "2019-06-03T17:00:00Z" {
+		t.Errorf("deadline in business hours = %v, %v, want 2019-06-03T17:00:00Z", due, ok)
+	}
+
+	if _, ok = deadline(repository.Request{RequestedDateTime: requested}, repository.Service{}, repository.CityConfig{}); ok {
+		t.Error("deadline without expected time or SLA should not apply")
+	}
+}
+
+func TestDueForEscalation(t *testing.T) {
+	due := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	backoff := 24 * time.Hour
+
+	tests := []struct {
+		level     int
+		escalated string
+		now       time.Time
+		want      bool
+	}{
+		{0, "", due.Add(-time.Hour), false},
+		{0, "", due.Add(time.Hour), true},
+		{1, "2019-06-01T01:00:00Z", due.Add(12 * time.Hour), false},
+		{1, "2019-06-01T01:00:00Z", due.Add(25 * time.Hour), true},
+		{2, "2019-06-02T01:00:00Z", due.Add(49 * time.Hour), false},
+		{2, "2019-06-02T01:00:00Z", due.Add(73 * time.Hour), true},
+	}
+
+	for _, tt := range tests {
+		request := repository.Request{EscalationLevel: tt.level, EscalatedDateTime: tt.escalated}
+		if got := dueForEscalation(request, due, tt.now, backoff); got != tt.want {
+			t.Errorf("dueForEscalation(level %d, at %s) = %v, want %v", tt.level, tt.now, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/social-torch/open311-services/apierror"
+	"github.com/social-torch/open311-services/auth"
+	"github.com/social-torch/open311-services/metrics"
+	"github.com/social-torch/open311-services/repository"
+	"github.com/social-torch/open311-services/warmup"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+var errorLogger = log.New(os.Stderr, "ERROR\t", log.Lshortfile)
+
+// maxDepth is how deeply queries may nest, so a query can't fan out into the whole database
+const maxDepth = 6
+
+// parsedSchema is parsed once per Lambda container.  Parsing checks every field of the schema has a resolver.
+var parsedSchema = graphql.MustParseSchema(schema, &resolver{}, graphql.MaxDepth(maxDepth))
+
+// graphqlRequest is the body of a GraphQL call
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// callerKey is the context key of the caller of a GraphQL call
+type callerKey struct{}
+
+// callerInfo is who is making a GraphQL call, from their Cognito token, and the cache of what their call reads
+type callerInfo struct {
+	username      string   // cognito:username, "" for calls without a token
+	staffCity     string   // custom:city of the caller's token, naming the city staff work for
+	groups        []string // Cognito groups of the caller
+	platformAdmin bool
+	loader        *loader
+}
+
+// caller returns the caller of the GraphQL call being resolved
+func caller(ctx context.Context) *callerInfo {
+	c, ok := ctx.Value(callerKey{}).(*callerInfo)
+	if !ok {
+		return &callerInfo{loader: &loader{services: map[string]*serviceResult{}}}
+	}
+	return c
+}
+
+// cityID returns the city the caller's calls are scoped to when they name none: the city in their token, else the
+// JURISDICTION this deployment serves
+func (c *callerInfo) cityID() string {
+	if c.staffCity != "" {
+		return c.staffCity
+	}
+	return os.Getenv("JURISDICTION")
+}
+
+// requestCity returns the city of a request named by its ID: the cityId given, else the city the caller's calls are
+// scoped to.  Requests are read from the tables of their city.
+func (c *callerInfo) requestCity(cityID *string) string {
+	if cityID != nil {
+		return *cityID
+	}
+	return c.cityID()
+}
+
+// isAdminOf reports whether the caller is an admin of a city.  Staff tokens name the city they work for.
+func (c *callerInfo) isAdminOf(city string) bool {
+	if c.staffCity != "" && c.staffCity != city {
+		return false
+	}
+	for _, g := range c.groups {
+		if g == auth.CityAdminGroup {
+			return true
+		}
+	}
+	return false
+}
+
+// Route requests
+func router(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if req.Resource != "/graphql" {
+		return clientError(http.StatusNotFound, errors.New("not found"))
+	}
+
+	var call graphqlRequest
+	switch req.HTTPMethod {
+	case "GET":
+		// Queries may be sent in the query string, so they can be cached; mutations must be posted
+		call.Query = req.QueryStringParameters["query"]
+		call.OperationName = req.QueryStringParameters["operationName"]
+		if v := req.QueryStringParameters["variables"]; v != "" {
+			err := json.Unmarshal([]byte(v), &call.Variables)
+			if err != nil {
+				return clientError(http.StatusBadRequest, errors.New("variables must be a JSON object"))
+			}
+		}
+		if hasMutation(call.Query) {
+			return clientError(http.StatusMethodNotAllowed, errors.New("mutations must be sent with POST"))
+		}
+
+	case "POST":
+		err := json.Unmarshal([]byte(req.Body), &call)
+		if err != nil {
+			return clientError(http.StatusBadRequest, errors.New("error unmarshalling GraphQL call JSON. Check syntax"))
+		}
+
+	default:
+		return clientError(http.StatusMethodNotAllowed, errors.New("method must be 'GET' or 'POST'"))
+	}
+
+	if call.Query == "" {
+		return clientError(http.StatusBadRequest, errors.New("query is required"))
+	}
+	return execute(context.WithValue(context.Background(), callerKey{}, callerOf(req)), call)
+}
+
+// hasMutation reports whether a GraphQL document defines a mutation operation, wherever it is in the document and
+// whichever operation the call names.  Comments, strings and default values of variables are skipped rather than
+// parsed, so only the keyword starting each top level definition is looked at.
+func hasMutation(document string) bool {
+	braces, parens := 0, 0
+	definition := true // whether the next name starts a definition
+	for i := 0; i < len(document); i++ {
+		c := document[i]
+		switch {
+		case c == '#':
+			for i < len(document) && document[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(document[i:], `"""`):
+			end := strings.Index(strings.Replace(document[i+3:], `\"""`, "xxxx", -1), `"""`)
+			if end < 0 {
+				return false
+			}
+			i += 3 + end + 2
+		case c == '"':
+			for i++; i < len(document) && document[i] != '"' && document[i] != '\n'; i++ {
+				if document[i] == '\\' {
+					i++
+				}
+			}
+		case c == '(':
+			parens++
+		case c == ')':
+			parens--
+		case c == '{':
+			if braces == 0 && parens == 0 {
+				definition = false
+			}
+			braces++
+		case c == '}':
+			braces--
+			if braces == 0 && parens == 0 {
+				definition = true
+			}
+		case c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+			start := i
+			for i+1 < len(document) && isNameChar(document[i+1]) {
+				i++
+			}
+			if definition && braces == 0 && parens == 0 {
+				if document[start:i+1] == "mutation" {
+					return true
+				}
+				definition = false
+			}
+		}
+	}
+	return false
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// execute runs a GraphQL call.  As GraphQL expects, errors resolving fields are answered with a 200 listing them
+// beside the data that could be resolved.
+func execute(ctx context.Context, call graphqlRequest) (events.APIGatewayProxyResponse, error) {
+	response := parsedSchema.Exec(ctx, call.Query, call.OperationName, call.Variables)
+	for _, err := range response.Errors {
+		warningLogger.Println(err.Error())
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling GraphQL response"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// callerOf returns who is making a call, from their Cognito token
+func callerOf(req events.APIGatewayProxyRequest) *callerInfo {
+	c := &callerInfo{
+		username:  auth.Claim(req, "cognito:username"),
+		staffCity: auth.StaffCity(req),
+		loader:    &loader{services: map[string]*serviceResult{}},
+	}
+
+	c.groups = auth.Groups(req)
+	c.platformAdmin = auth.InGroup(req, auth.PlatformAdminGroup)
+	return c
+}
+
+// deactivationNotice returns the notice residents see when their submission to a paused city is refused
+func deactivationNotice(city repository.City) string {
+	if city.DeactivationNotice != "" {
+		return city.DeactivationNotice
+	}
+
+	notice := fmt.Sprintf("%s is not taking new requests through the app right now. Please try again later", city.CityName)
+	if phone := city.Config.Contact.Phone; phone != "" {
+		notice += ", or call " + phone
+	}
+	return notice
+}
+
+func serverError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
+	errorLogger.Println(err.Error())
+	return apierror.Response(statusCode, err), nil
+}
+
+func clientError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
+	warningLogger.Println(err.Error())
+	return apierror.Response(statusCode, err), nil
+}
+
+func main() {
+	warmup.Start("graphql", metrics.Handler("graphql", apierror.Handler(repository.Audit(router))), repository.Warm)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// signedIn returns a call to /graphql made with a Cognito token of the given claims
+func signedIn(method string, claims map[string]interface{}) events.APIGatewayProxyRequest {
+	req := events.APIGatewayProxyRequest{HTTPMethod: method, Resource: "/graphql", QueryStringParameters: map[string]string{}}
+	req.RequestContext.Authorizer = map[string]interface{}{"claims": claims}
+	return req
+}
+
+func TestCallerOf(t *testing.T) {
+	c := callerOf(signedIn("POST", map[string]interface{}{
+		"cognito:username": "clerk",
+		"custom:city":      "troy",
+		"cognito:groups":   "[residents city_admin]",
+	}))
+	if c.username != "clerk" || c.cityID() != "troy" || c.platformAdmin {
+		t.Errorf("callerOf() = %+v", c)
+	}
+	if !c.isAdminOf("troy") || c.isAdminOf("albany") {
+		t.Errorf("clerk of troy isAdminOf() troy %v, albany %v, want true, false", c.isAdminOf("troy"), c.isAdminOf("albany"))
+	}
+
+	admin := callerOf(signedIn("POST", map[string]interface{}{"cognito:username": "ops", "cognito:groups": "[platform_admin]"}))
+	if !admin.platformAdmin || admin.isAdminOf("troy") {
+		t.Errorf("callerOf() a platform admin = %+v", admin)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	resident := map[string]interface{}{"cognito:username": "resident"}
+
+	// Reading another user is refused before anything is read
+	req := signedIn("POST", resident)
+	req.Body = `{"query": "query($id: ID!) { user(id: $id) { id } }", "variables": {"id": "someone-else"}}`
+	resp, _ := router(req)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(resp.Body, "users may only be read by themselves") {
+		t.Errorf("user query of another user = %d %s", resp.StatusCode, resp.Body)
+	}
+
+	// Queries are checked against the schema
+	req = signedIn("GET", resident)
+	req.QueryStringParameters["query"] = "{ requests { id password } }"
+	resp, _ = router(req)
+	var body struct {
+		Errors []struct{ Message string }
+	}
+	if err := json.Unmarshal([]byte(resp.Body), &body); err != nil || len(body.Errors) == 0 {
+		t.Errorf("query of an unknown field = %s, want errors", resp.Body)
+	}
+
+	// Mutations change data, so they can't be sent in a cacheable GET
+	req = signedIn("GET", resident)
+	req.QueryStringParameters["query"] = `mutation { addComment(id: "SR-1", text: "hi") { text } }`
+	if resp, _ := router(req); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET of a mutation = %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+	req.QueryStringParameters["query"] = "query Q { requests { id } }\n# runs the mutation\nmutation M { addComment(id: \"SR-1\", text: \"hi\") { text } }"
+	req.QueryStringParameters["operationName"] = "M"
+	if resp, _ := router(req); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET of a mutation after a query = %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+
+	req = signedIn("POST", resident)
+	req.Body = `{"query": "mutation { updateRequestStatus(id: \"SR-1\", status: \"done\") { id } }"}`
+	if resp, _ := router(req); !strings.Contains(resp.Body, "status must be one of") {
+		t.Errorf("update to an unknown status = %s", resp.Body)
+	}
+}
+
+func TestHasMutation(t *testing.T) {
+	tests := map[string]bool{
+		`{ requests { id } }`:                                                                    false,
+		`query Requests { requests { id } }`:                                                     false,
+		`mutation { addComment(id: "SR-1", text: "hi") { text } }`:                               true,
+		"# a comment\nmutation { addComment(id: \"SR-1\", text: \"hi\") { text } }":              true,
+		`query Q { requests { id } } mutation M { addComment(id: "SR-1", text: "hi") { text } }`: true,
+		`query Q($text: String = "mutation { }") { requests(search: $text) { id } }`:             false,
+		`query Q { requests { mutation: id } } fragment F on Request { id }`:                     false,
+		"{ requests { id } } # mutation { x }":                                                   false,
+		`query Q($f: Filter = {status: "open"}) { requests { id } } mutation { x }`:              true,
+		`query Q { a(text: """mutation \""" } mutation""") }`:                                    false,
+	}
+	for query, want := range tests {
+		if got := hasMutation(query); got != want {
+			t.Errorf("hasMutation(%s) = %v, want %v", query, got, want)
+		}
+	}
+}
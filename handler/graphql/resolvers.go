@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/social-torch/open311-services/features"
+	"github.com/social-torch/open311-services/repository"
+	"github.com/social-torch/open311-services/validate"
+)
+
+// requestsWindow is the period requests are listed over when no dates are given, and the longest that may be
+// asked for, as in GET /requests
+const requestsWindow = 90 * 24 * time.Hour
+
+// maxRequests is how many requests a listing returns at most
+const maxRequests = 1000
+
+// statuses are the statuses a request may be set to
+var statuses = map[string]bool{
+	repository.RequestOpen: true, repository.RequestAccepted: true, repository.RequestInProgress: true, repository.RequestClosed: true,
+}
+
+// loader caches what the resolvers of one call look up, so a listing of requests of the same service reads the
+// service once.  Fields are resolved concurrently.
+type loader struct {
+	mu       sync.Mutex
+	services map[string]*serviceResult
+}
+
+type serviceResult struct {
+	once    sync.Once
+	service repository.Service
+	err     error
+}
+
+// service returns a service, reading it once per call
+func (l *loader) service(code string) (repository.Service, error) {
+	l.mu.Lock()
+	result, ok := l.services[code]
+	if !ok {
+		result = &serviceResult{}
+		l.services[code] = result
+	}
+	l.mu.Unlock()
+
+	result.once.Do(func() { result.service, result.err = repository.GetService(code) })
+	return result.service, result.err
+}
+
+// resolver is the root of the schema
+type resolver struct{}
+
+func (r *resolver) Requests(ctx context.Context, args struct {
+	CityID      *string
+	Status      *string
+	ServiceCode *string
+	StartDate   *string
+	EndDate     *string
+	First       *int32
+}) ([]*requestResolver, error) {
+	cityID := caller(ctx).cityID()
+	if args.CityID != nil {
+		cityID = *args.CityID
+	}
+
+	end := time.Now()
+	if args.EndDate != nil {
+		t, err := time.Parse(time.RFC3339, *args.EndDate)
+		if err != nil {
+			return nil, errors.New("endDate must be a w3 datetime, eg 2019-06-02T12:00:00Z")
+		}
+		end = t
+	}
+	start := end.Add(-requestsWindow)
+	if args.StartDate != nil {
+		t, err := time.Parse(time.RFC3339, *args.StartDate)
+		if err != nil || t.After(end) || end.Sub(t) > requestsWindow {
+			return nil, errors.New("startDate must be a w3 datetime before endDate, and at most 90 days before it")
+		}
+		start = t
+	}
+
+	first := 100
+	if args.First != nil {
+		if *args.First < 1 || *args.First > maxRequests {
+			return nil, fmt.Errorf("first must be between 1 and %d", maxRequests)
+		}
+		first = int(*args.First)
+	}
+
+	requests, err := repository.GetRequestsBetween(cityID, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	// Newest first
+	sort.SliceStable(requests, func(i, j int) bool { return requests[i].RequestedDateTime > requests[j].RequestedDateTime })
+	resolvers := []*requestResolver{}
+	for _, request := range requests {
+		if args.Status != nil && request.Status != *args.Status {
+			continue
+		}
+		if args.ServiceCode != nil && request.ServiceCode != *args.ServiceCode {
+			continue
+		}
+		resolvers = append(resolvers, &requestResolver{request})
+		if len(resolvers) == first {
+			break
+		}
+	}
+	return resolvers, nil
+}
+
+func (r *resolver) Request(ctx context.Context, args struct {
+	ID     graphql.ID
+	CityID *string
+}) (*requestResolver, error) {
+	request, err := repository.GetRequest(caller(ctx).requestCity(args.CityID), string(args.ID))
+	if _, ok := err.(*repository.RequestIdNotFoundErr); ok {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &requestResolver{request}, nil
+}
+
+func (r *resolver) Services(ctx context.Context, args struct{ CityID *string }) ([]*serviceResolver, error) {
+	cityID := caller(ctx).cityID()
+	if args.CityID != nil {
+		cityID = *args.CityID
+	}
+
+	services, err := repository.GetServices(cityID)
+	if err != nil {
+		return nil, err
+	}
+	resolvers := []*serviceResolver{}
+	for _, service := range services {
+		resolvers = append(resolvers, &serviceResolver{service})
+	}
+	return resolvers, nil
+}
+
+func (r *resolver) User(ctx context.Context, args struct{ ID graphql.ID }) (*userResolver, error) {
+	c := caller(ctx)
+	if c.username != string(args.ID) && !c.platformAdmin {
+		return nil, errors.New("users may only be read by themselves and platform admins")
+	}
+
+	user, err := repository.GetUser(string(args.ID))
+	if _, ok := err.(*repository.AccountIDNotFoundErr); ok {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &userResolver{user}, nil
+}
+
+// submitRequestInput is a request submitted through the schema
+type submitRequestInput struct {
+	CityID      *string
+	ServiceCode string
+	Lat         float64
+	Lon         float64
+	Address     *string
+	Description *string
+	MediaURL    *string
+}
+
+// SubmitRequest makes a request to a city, with the checks of POST /requests for requests located by coordinates
+func (r *resolver) SubmitRequest(ctx context.Context, args struct{ Input submitRequestInput }) (*requestResolver, error) {
+	c := caller(ctx)
+	in := args.Input
+	request := repository.Request{CityID: c.cityID(), ServiceCode: in.ServiceCode}
+	if in.CityID != nil {
+		request.CityID = *in.CityID
+	}
+	if in.Address != nil {
+		request.Address = *in.Address
+	}
+	if in.Description != nil {
+		request.Description = *in.Description
+	}
+	if in.MediaURL != nil {
+		request.MediaURL = *in.MediaURL
+	}
+	location, err := repository.NewLocation(in.Lat, in.Lon)
+	if err != nil {
+		return nil, err
+	}
+	request.Location = location
+	if err := validate.Request(request); err != nil {
+		return nil, err
+	}
+
+	city := repository.City{}
+	if request.CityID != "" {
+		city, err = repository.GetCity(request.CityID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if city.Deactivated {
+		return nil, errors.New(deactivationNotice(city))
+	}
+	if city.Federated {
+		return nil, fmt.Errorf("%s takes requests on its own server; submit them through POST /requests", city.CityName)
+	}
+
+	accountID := c.username
+	if accountID == "" {
+		anonymous, err := features.Enabled(city.CityName, repository.FeatureAnonymousReporting)
+		if err != nil {
+			return nil, err
+		}
+		if !anonymous {
+			return nil, fmt.Errorf("%s requires an account to submit requests", city.CityName)
+		}
+		accountID = "guest"
+	}
+
+	if !repository.IsValidServiceCode(request.CityID, request.ServiceCode) {
+		return nil, errors.New("invalid service code: " + request.ServiceCode)
+	}
+	lat, lon := request.Coordinates()
+	if len(city.Boundary) > 0 && !city.Boundary.Contains(lat, lon) {
+		return nil, fmt.Errorf("location is outside the city limits of %s", city.CityName)
+	}
+	request.Neighborhood = city.NeighborhoodOf(lat, lon)
+
+	response, err := repository.SubmitRequest(request, accountID)
+	if err != nil {
+		return nil, err
+	}
+	infoLogger.Println("New request submitted: " + response.ServiceRequestID)
+
+	stored, err := repository.GetRequest(request.CityID, response.ServiceRequestID)
+	if err != nil {
+		return nil, err
+	}
+	return &requestResolver{stored}, nil
+}
+
+// UpdateRequestStatus moves a request along its lifecycle, for the admins of its city
+func (r *resolver) UpdateRequestStatus(ctx context.Context, args struct {
+	ID          graphql.ID
+	CityID      *string
+	Status      string
+	StatusNotes *string
+}) (*requestResolver, error) {
+	if !statuses[args.Status] {
+		return nil, fmt.Errorf("status must be one of open, accepted, inProgress or closed")
+	}
+
+	c := caller(ctx)
+	request, err := repository.GetRequest(c.requestCity(args.CityID), string(args.ID))
+	if err != nil {
+		return nil, err
+	}
+	if !c.isAdminOf(request.CityID) && !c.platformAdmin {
+		return nil, fmt.Errorf("requests of %s may only be updated by its city admins", request.CityID)
+	}
+
+	stored := request
+	request.Status = args.Status
+	if args.StatusNotes != nil {
+		request.StatusNotes = *args.StatusNotes
+	}
+	_, err = repository.UpdateRequest(request, stored, c.username)
+	if err != nil {
+		return nil, err
+	}
+	infoLogger.Println("Request updated: " + request.ServiceRequestID)
+
+	updated, err := repository.GetRequest(request.CityID, request.ServiceRequestID)
+	if err != nil {
+		return nil, err
+	}
+	return &requestResolver{updated}, nil
+}
+
+// maxCommentLength is the longest comment accepted, in characters
+const maxCommentLength = 2000
+
+// AddComment leaves a note on a request, for signed in users
+func (r *resolver) AddComment(ctx context.Context, args struct {
+	ID     graphql.ID
+	CityID *string
+	Text   string
+}) (*commentResolver, error) {
+	c := caller(ctx)
+	if c.username == "" {
+		return nil, errors.New("sign in to comment on requests")
+	}
+	text := strings.TrimSpace(args.Text)
+	if text == "" || len([]rune(text)) > maxCommentLength {
+		return nil, fmt.Errorf("text must be between 1 and %d characters", maxCommentLength)
+	}
+
+	comment, err := repository.AddComment(c.requestCity(args.CityID), string(args.ID), c.username, text)
+	if err != nil {
+		return nil, err
+	}
+	return &commentResolver{comment}, nil
+}
+
+type requestResolver struct{ r repository.Request }
+
+func (r *requestResolver) ID() graphql.ID             { return graphql.ID(r.r.ServiceRequestID) }
+func (r *requestResolver) CityID() *string            { return optional(r.r.CityID) }
+func (r *requestResolver) Status() string             { return r.r.Status }
+func (r *requestResolver) StatusNotes() *string       { return optional(r.r.StatusNotes) }
+func (r *requestResolver) ServiceCode() string        { return r.r.ServiceCode }
+func (r *requestResolver) ServiceName() *string       { return optional(r.r.ServiceName) }
+func (r *requestResolver) Description() *string       { return optional(r.r.Description) }
+func (r *requestResolver) AgencyResponsible() *string { return optional(r.r.AgencyResponsible) }
+func (r *requestResolver) AssignedTo() *string        { return optional(r.r.AssignedTo) }
+func (r *requestResolver) Address() *string           { return optional(r.r.Address) }
+func (r *requestResolver) Neighborhood() *string      { return optional(r.r.Neighborhood) }
+func (r *requestResolver) MediaURL() *string          { return optional(r.r.MediaURL) }
+func (r *requestResolver) RequestedDatetime() *string { return optional(r.r.RequestedDateTime) }
+func (r *requestResolver) UpdatedDatetime() *string   { return optional(r.r.UpdatedDateTime) }
+func (r *requestResolver) ExpectedDatetime() *string  { return optional(r.r.ExpectedDateTime) }
+func (r *requestResolver) ClosedDatetime() *string    { return optional(r.r.ClosedDateTime) }
+
+func (r *requestResolver) Lat() *float64 {
+	if !r.r.HasLocation() {
+		return nil
+	}
+	lat, _ := r.r.Coordinates()
+	return &lat
+}
+
+func (r *requestResolver) Lon() *float64 {
+	if !r.r.HasLocation() {
+		return nil
+	}
+	_, lon := r.r.Coordinates()
+	return &lon
+}
+
+func (r *requestResolver) Service(ctx context.Context) (*serviceResolver, error) {
+	service, err := caller(ctx).loader.service(r.r.ServiceCode)
+	if _, ok := err.(*repository.ServiceCodeNotFoundErr); ok {
+		// Services can be deleted after requests are made for them
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &serviceResolver{service}, nil
+}
+
+func (r *requestResolver) Comments() []*commentResolver {
+	resolvers := []*commentResolver{}
+	for _, comment := range r.r.Comments {
+		resolvers = append(resolvers, &commentResolver{comment})
+	}
+	return resolvers
+}
+
+type serviceResolver struct{ s repository.Service }
+
+func (s *serviceResolver) Code() graphql.ID     { return graphql.ID(s.s.ServiceCode) }
+func (s *serviceResolver) Name() string         { return s.s.ServiceName }
+func (s *serviceResolver) Description() *string { return optional(s.s.Description) }
+func (s *serviceResolver) Group() *string       { return optional(s.s.Group) }
+func (s *serviceResolver) Emergency() bool      { return s.s.Emergency }
+func (s *serviceResolver) CityID() *string      { return optional(s.s.CityID) }
+
+func (s *serviceResolver) SLAHours() *int32 {
+	if s.s.SLAHours == 0 {
+		return nil
+	}
+	hours := int32(s.s.SLAHours)
+	return &hours
+}
+
+type commentResolver struct{ c repository.Comment }
+
+func (c *commentResolver) AccountID() string { return c.c.AccountID }
+func (c *commentResolver) Text() string      { return c.c.Text }
+func (c *commentResolver) Timestamp() string { return c.c.Timestamp }
+
+type userResolver struct{ u repository.User }
+
+func (u *userResolver) ID() graphql.ID  { return graphql.ID(u.u.AccountID) }
+func (u *userResolver) CityID() *string { return optional(u.u.CityID) }
+
+func (u *userResolver) SubmittedRequests() ([]*requestResolver, error) {
+	return requestsByID(u.u.SubmittedRequests)
+}
+
+func (u *userResolver) WatchedRequests() ([]*requestResolver, error) {
+	return requestsByID(u.u.WatchedRequests)
+}
+
+// requestsByID looks up requests by their IDs, leaving out those since deleted
+func requestsByID(ids []string) ([]*requestResolver, error) {
+	requests, err := repository.GetRequestsByID(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := []*requestResolver{}
+	for _, request := range requests {
+		resolvers = append(resolvers, &requestResolver{request})
+	}
+	return resolvers, nil
+}
+
+// optional returns nil for an empty string, which the schema leaves null
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,83 @@
+package main
+
+// schema is the GraphQL schema served at /graphql.  It covers what the web dashboard reads and changes; anything
+// else is served by the REST API.
+const schema = `
+schema {
+	query: Query
+	mutation: Mutation
+}
+
+type Query {
+	# Requests made to a city between startDate and endDate (RFC3339, the last 90 days by default), newest first
+	requests(cityId: String, status: String, serviceCode: String, startDate: String, endDate: String, first: Int = 100): [Request!]!
+	request(id: ID!, cityId: String): Request
+	services(cityId: String): [Service!]!
+	# The signed in user; platform admins may read anyone
+	user(id: ID!): User
+}
+
+type Mutation {
+	# Requests located only by address, or by an asset, are submitted through POST /requests
+	submitRequest(input: SubmitRequestInput!): Request!
+	# City admins only
+	updateRequestStatus(id: ID!, cityId: String, status: String!, statusNotes: String): Request!
+	addComment(id: ID!, cityId: String, text: String!): Comment!
+}
+
+input SubmitRequestInput {
+	cityId: String
+	serviceCode: String!
+	lat: Float!
+	lon: Float!
+	address: String
+	description: String
+	mediaUrl: String
+}
+
+type Request {
+	id: ID!
+	cityId: String
+	status: String!
+	statusNotes: String
+	serviceCode: String!
+	serviceName: String
+	service: Service
+	description: String
+	agencyResponsible: String
+	assignedTo: String
+	address: String
+	lat: Float
+	lon: Float
+	neighborhood: String
+	mediaUrl: String
+	requestedDatetime: String
+	updatedDatetime: String
+	expectedDatetime: String
+	closedDatetime: String
+	comments: [Comment!]!
+}
+
+type Service {
+	code: ID!
+	name: String!
+	description: String
+	group: String
+	emergency: Boolean!
+	slaHours: Int
+	cityId: String
+}
+
+type Comment {
+	accountId: String!
+	text: String!
+	timestamp: String!
+}
+
+type User {
+	id: ID!
+	cityId: String
+	submittedRequests: [Request!]!
+	watchedRequests: [Request!]!
+}
+`
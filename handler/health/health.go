@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/social-torch/open311-services/apierror"
+	"github.com/social-torch/open311-services/awsclient"
+	"github.com/social-torch/open311-services/metrics"
+	"github.com/social-torch/open311-services/repository"
+	"github.com/social-torch/open311-services/warmup"
+)
+
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+var errorLogger = log.New(os.Stderr, "ERROR\t", log.Lshortfile)
+
+// checkTimeout is how long the dependencies have to answer.  A dependency that doesn't answer in time is as good
+// as down to the callers of the API.
+const checkTimeout = 5 * time.Second
+
+// Statuses of a dependency, and of the deployment as a whole
+const (
+	statusOK       = "ok"
+	statusError    = "error"
+	statusTimeout  = "timeout"
+	statusDegraded = "degraded"
+)
+
+// dependency is the outcome of checking one thing the API needs
+type dependency struct {
+	Name      string `json:"name"` // eg "dynamodb:Requests" or "s3:open311-images"
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"` // AWS error code, eg "AccessDeniedException"; messages stay in the logs
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+// health is the state of the deployment and of each of its dependencies
+type health struct {
+	Status       string       `json:"status"`
+	Dependencies []dependency `json:"dependencies"`
+}
+
+// check is a dependency to verify
+type check struct {
+	name string
+	run  func() error
+}
+
+// Route requests
+func router(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if req.HTTPMethod == "GET" && req.Resource == "/health" {
+		return getHealth()
+	}
+	return clientError(http.StatusMethodNotAllowed, errors.New("method not allowed"))
+}
+
+// getHealth verifies the API can reach each of its tables and the images bucket with its credentials, for
+// synthetic monitors.  It answers 200 when every dependency is ok, and 503 listing the ones that aren't otherwise.
+func getHealth() (events.APIGatewayProxyResponse, error) {
+	checks := []check{}
+	for _, table := range repository.HealthTables {
+		table := table
+		checks = append(checks, check{"dynamodb:" + table, func() error { return repository.CheckTable(table) }})
+	}
+	if bucket := os.Getenv("IMAGE_BUCKET"); bucket != "" {
+		checks = append(checks, check{"s3:" + bucket, func() error { return checkBucket(bucket) }})
+	}
+
+	result := runChecks(checks, checkTimeout)
+	for _, d := range result.Dependencies {
+		if d.Status != statusOK {
+			warningLogger.Printf("%s is %s %s", d.Name, d.Status, d.Error)
+		}
+	}
+
+	body, err := json.Marshal(result)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling health struct"))
+	}
+
+	statusCode := http.StatusOK
+	if result.Status != statusOK {
+		statusCode = http.StatusServiceUnavailable
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*", "Cache-Control": "no-store"},
+		Body:       string(body),
+	}, nil
+}
+
+// runChecks runs the checks at once, giving them until timeout to answer, and reports them in order
+func runChecks(checks []check, timeout time.Duration) health {
+	type outcome struct {
+		i int
+		d dependency
+	}
+	done := make(chan outcome, len(checks))
+	for i, c := range checks {
+		go func(i int, c check) {
+			start := time.Now()
+			err := c.run()
+			d := dependency{Name: c.name, Status: statusOK, LatencyMS: int64(time.Since(start) / time.Millisecond)}
+			if err != nil {
+				errorLogger.Printf("%s: %s", c.name, err)
+				d.Status, d.Error = statusError, errorCode(err)
+			}
+			done <- outcome{i, d}
+		}(i, c)
+	}
+
+	result := health{Status: statusOK, Dependencies: make([]dependency, len(checks))}
+	for i, c := range checks {
+		result.Dependencies[i] = dependency{Name: c.name, Status: statusTimeout, LatencyMS: int64(timeout / time.Millisecond)}
+	}
+	deadline := time.After(timeout)
+wait:
+	for range checks {
+		select {
+		case o := <-done:
+			result.Dependencies[o.i] = o.d
+		case <-deadline:
+			break wait
+		}
+	}
+
+	for _, d := range result.Dependencies {
+		if d.Status != statusOK {
+			result.Status = statusDegraded
+		}
+	}
+	return result
+}
+
+// errorCode returns the AWS error code of an error, which names the problem without revealing the account's
+// resources to anonymous callers
+func errorCode(err error) string {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		return aerr.Code()
+	}
+	return "unknown"
+}
+
+// checkBucket verifies the bucket exists and can be reached with the deployment's credentials
+func checkBucket(bucket string) error {
+	svc := s3.New(awsclient.Session())
+	_, err := svc.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String(bucket)})
+	return err
+}
+
+func serverError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
+	errorLogger.Println(err.Error())
+	return apierror.Response(statusCode, err), nil
+}
+
+func clientError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
+	warningLogger.Println(err.Error())
+	return apierror.Response(statusCode, err), nil
+}
+
+func main() {
+	warmup.Start("health", metrics.Handler("health", apierror.Handler(router)), repository.Warm)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestRunChecks(t *testing.T) {
+	checks := []check{
+		{"dynamodb:Requests", func() error { return nil }},
+		{"dynamodb:Users", func() error { return awserr.New("AccessDeniedException", "not authorized", nil) }},
+		{"s3:images", func() error { time.Sleep(time.Second); return nil }},
+		{"dynamodb:Cities", func() error { return errors.New("no credentials") }},
+	}
+
+	result := runChecks(checks, 50*time.Millisecond)
+	if result.Status != statusDegraded {
+		t.Errorf("status = %s, want %s", result.Status, statusDegraded)
+	}
+	want := []struct{ status, code string }{{statusOK, ""}, {statusError, "AccessDeniedException"}, {statusTimeout, ""}, {statusError, "unknown"}}
+	for i, w := range want {
+		d := result.Dependencies[i]
+		if d.Name != checks[i].name || d.Status != w.status || d.Error != w.code {
+			t.Errorf("dependency %d = %+v, want %s %s %s", i, d, checks[i].name, w.status, w.code)
+		}
+	}
+
+	if result := runChecks(checks[:1], time.Second); result.Status != statusOK {
+		t.Errorf("status of healthy dependencies = %s, want %s", result.Status, statusOK)
+	}
+}
@@ -1,18 +1,32 @@
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudfront/sign"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/oklog/ulid"
+	"github.com/social-torch/open311-services/apierror"
+	"github.com/social-torch/open311-services/auth"
+	"github.com/social-torch/open311-services/awsclient"
+	"github.com/social-torch/open311-services/features"
+	"github.com/social-torch/open311-services/metrics"
+	"github.com/social-torch/open311-services/repository"
+	"github.com/social-torch/open311-services/warmup"
 )
 
 var infoLogger = log.New(os.Stdout, "INFO\t", 0)
@@ -21,42 +35,204 @@ var errorLogger = log.New(os.Stderr, "ERROR\t", log.Lshortfile)
 
 // Route requests
 func router(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	loc, err := mediaLocation(req)
+	if err != nil {
+		switch err.(type) {
+		case *repository.CityNotFoundErr:
+			errorMessage := fmt.Errorf("%s. city_name '%s' not in database", err, req.QueryStringParameters["city"])
+			return clientError(http.StatusNotFound, errorMessage)
+		case *crossCityErr:
+			return clientError(http.StatusForbidden, err)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+	key := loc.Prefix + req.PathParameters["key"]
+
 	switch req.HTTPMethod {
 	case "GET":
 		if req.Resource == "/images/fetch/{key}" {
-			key := req.PathParameters["key"]
-			return getPresignedURLForFetch(key)
+			return getPresignedURLForFetch(loc, key, auth.StaffCity(req) != "")
 		}
 
 		if req.Resource == "/images/store/{key}" {
-			key := req.PathParameters["key"]
-			return getPresignedURLForStore(key)
+			if response, ok := checkVideo(loc, key); !ok {
+				return response, nil
+			}
+			return getPresignedURLForStore(loc, key, req)
+		}
+
+		if req.Resource == "/images/multipart/{key}/part" {
+			return getPresignedURLForPart(loc, key, req.QueryStringParameters["upload_id"], req.QueryStringParameters["part_number"])
+		}
+
+	case "POST":
+		if req.Resource == "/images" {
+			return storeImage(loc, req)
+		}
+
+		if req.Resource == "/images/multipart/{key}" {
+			if response, ok := checkVideo(loc, key); !ok {
+				return response, nil
+			}
+			return initiateMultipartUpload(loc, key, req)
+		}
+
+		if req.Resource == "/images/multipart/{key}/complete" {
+			return completeMultipartUpload(loc, key, req)
 		}
 	}
-	return clientError(http.StatusMethodNotAllowed, errors.New("Method must be 'GET'"))
+	return clientError(http.StatusMethodNotAllowed, errors.New("Method must be 'GET' or 'POST'"))
 
 }
 
-// Get presigned S3 URL to retrieve an image
-func getPresignedURLForFetch(key string) (events.APIGatewayProxyResponse, error) {
-	bucket := os.Getenv("IMAGE_BUCKET")
-	svc := s3.New(session.New())
-	req, _ := svc.GetObjectRequest( &s3.GetObjectInput {
-		Bucket: aws.String(bucket),
-		Key: aws.String(key) } )
+// location is where a city's media is stored: its bucket and the key prefix namespacing its objects
+type location struct {
+	City   string
+	Bucket string
+	Prefix string
+	Region string // Region of the bucket of a city pinned to one. Empty for the deployment's region
+}
 
-	urlStr, err := req.Presign(10 * time.Minute)
+// client returns an S3 client of the location's bucket
+func (l location) client() *s3.S3 {
+	return s3.New(awsclient.SessionIn(l.Region))
+}
+
+// crossCityErr is returned when city staff ask for another city's media
+type crossCityErr struct {
+	message string
+}
+
+func (e *crossCityErr) Error() string {
+	return e.message
+}
+
+// mediaLocation resolves the media location for the city named by the 'city' query parameter.  Cities may configure
+// their own bucket in the Cities table; otherwise the shared images bucket is used.  Staff accounts carry a
+// custom:city claim and may only reach their own city's media, so they must name it.  Without a city, the legacy
+// un-namespaced location is used.
+func mediaLocation(req events.APIGatewayProxyRequest) (location, error) {
+	loc := location{Bucket: os.Getenv("IMAGE_BUCKET")}
+
+	cityName := req.QueryStringParameters["city"]
+	switch staffCity := auth.StaffCity(req); {
+	case staffCity != "" && cityName == "":
+		return loc, &crossCityErr{fmt.Sprintf("staff of %s must name their city in the city query parameter", staffCity)}
+	case staffCity != "" && staffCity != cityName:
+		return loc, &crossCityErr{fmt.Sprintf("staff of %s may not access media of %s", staffCity, cityName)}
+	}
+	if cityName == "" {
+		return loc, nil
+	}
+
+	city, err := repository.GetCity(cityName)
+	if err != nil {
+		return loc, err
+	}
+
+	loc.City = city.CityName
+	loc.Prefix = city.CityName + "/"
+	loc.Region = city.Region
+	if city.MediaBucket != "" {
+		loc.Bucket = city.MediaBucket
+	}
+	return loc, nil
+}
+
+// Video file extensions, which cities may refuse
+var videoExtensions = []string{".mp4", ".mov", ".m4v", ".3gp", ".webm"}
+
+// checkVideo refuses uploads of video to cities that have switched video upload off.  When it refuses, or the
+// city's flags can't be read, the error response is returned with ok false.
+func checkVideo(loc location, key string) (response events.APIGatewayProxyResponse, ok bool) {
+	if !isVideo(key) {
+		return response, true
+	}
+
+	video, err := features.Enabled(loc.City, repository.FeatureVideoUpload)
+	if err != nil {
+		response, _ = serverError(http.StatusInternalServerError, err)
+		return response, false
+	}
+	if !video {
+		response, _ = clientError(http.StatusForbidden, fmt.Errorf("%s does not accept video", loc.City))
+		return response, false
+	}
+	return response, true
+}
+
+func isVideo(key string) bool {
+	key = strings.ToLower(key)
+	for _, ext := range videoExtensions {
+		if strings.HasSuffix(key, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get signed URL to retrieve an image.  Images are served through CloudFront when a distribution is configured
+// so the same photo viewed by many residents and staff is cached at the edge; otherwise fall back to S3 presigning.
+// Staff, who may only reach their own city's media, also see media that moderation hasn't cleared.
+func getPresignedURLForFetch(loc location, key string, staff bool) (events.APIGatewayProxyResponse, error) {
+	media, err := mediaRecord(key)
 	if err != nil {
 		errorLogger.Println(err)
-		return serverError(http.StatusInternalServerError, errors.New("Error retreiving presigned S3 URL for retrieving"))
+		return serverError(http.StatusInternalServerError, errors.New("Error retreiving media record"))
 	}
 
-	infoLogger.Println("Presigned URL  ", urlStr)
-	body, _ := json.Marshal( &struct {
-																			 URL      string  `json:"url"`
-																		 }{
-																			 URL: urlStr,
-																		 })
+	if media.ModerationStatus == repository.ModerationRejected {
+		return clientError(http.StatusForbidden, fmt.Errorf("image '%s' was rejected by content moderation", key))
+	}
+	if !media.Viewable(staff, time.Now()) {
+		return clientError(http.StatusForbidden, fmt.Errorf("image '%s' is %s until city staff review it", key, media.ModerationStatus))
+	}
+
+	if media.StorageStatus == repository.MediaDeleted {
+		return clientError(http.StatusGone, fmt.Errorf("image '%s' was deleted per the city's retention policy", key))
+	}
+
+	// Archived objects are in Glacier and cannot be fetched; tell the client rather than hand out a failing URL
+	if media.StorageStatus == repository.MediaArchived {
+		body, _ := json.Marshal(&struct {
+			URL              string `json:"url"`
+			ModerationStatus string `json:"moderation_status"`
+			StorageStatus    string `json:"storage_status"`
+		}{
+			ModerationStatus: media.ModerationStatus,
+			StorageStatus:    media.StorageStatus,
+		})
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusOK,
+			Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+			Body:       string(body),
+		}, nil
+	}
+
+	// The distribution fronts the shared images bucket only; cities with their own bucket are presigned directly
+	var urlStr string
+	if os.Getenv("CLOUDFRONT_DOMAIN") != "" && loc.Bucket == os.Getenv("IMAGE_BUCKET") {
+		urlStr, err = signedCloudFrontURL(key)
+	} else {
+		urlStr, err = presignedS3URL(loc, key)
+	}
+	if err != nil {
+		errorLogger.Println(err)
+		return serverError(http.StatusInternalServerError, errors.New("Error retreiving signed URL for retrieving"))
+	}
+
+	infoLogger.Println("Signed URL  ", urlStr)
+	body, _ := json.Marshal(&struct {
+		URL              string `json:"url"`
+		ModerationStatus string `json:"moderation_status"`
+		StorageStatus    string `json:"storage_status"`
+	}{
+		URL:              urlStr,
+		ModerationStatus: media.ModerationStatus,
+		StorageStatus:    media.StorageStatus,
+	})
 
 	return events.APIGatewayProxyResponse{
 		StatusCode: http.StatusOK,
@@ -65,13 +241,93 @@ func getPresignedURLForFetch(key string) (events.APIGatewayProxyResponse, error)
 	}, nil
 }
 
+// mediaRecord returns the media record of an object.  Objects uploaded before media records existed
+// are treated as active and pending moderation
+func mediaRecord(key string) (repository.Media, error) {
+	media, err := repository.GetMedia(key)
+	if err != nil {
+		switch err.(type) {
+		case *repository.MediaNotFoundErr:
+			return repository.Media{Key: key, ModerationStatus: repository.ModerationPending, StorageStatus: repository.MediaActive}, nil
+		default:
+			return media, err
+		}
+	}
+
+	if media.StorageStatus == "" {
+		media.StorageStatus = repository.MediaActive
+	}
+	return media, nil
+}
+
+// registerMedia records who is uploading key and for which request before an upload URL is issued.
+// The uploader's account comes from the 'from' header and the request from the service_request_id query parameter.
+func registerMedia(loc location, key string, req events.APIGatewayProxyRequest) error {
+	accountID := req.Headers["from"]
+	if accountID == "" {
+		accountID = "guest"
+	}
+
+	return repository.RegisterMedia(repository.Media{
+		Key:              key,
+		City:             loc.City,
+		ServiceRequestID: req.QueryStringParameters["service_request_id"],
+		AccountID:        accountID,
+	})
+}
+
+// registerError answers a call whose media couldn't be registered: a key already registered is a conflict, and
+// media can't be attached to a request that doesn't exist
+func registerError(err error) (events.APIGatewayProxyResponse, error) {
+	switch err.(type) {
+	case *repository.MediaConflictErr:
+		return clientError(http.StatusConflict, err)
+	case *repository.RequestIdNotFoundErr:
+		return clientError(http.StatusBadRequest, fmt.Errorf("%s. service_request_id not in database", err))
+	default:
+		return serverError(http.StatusInternalServerError, err)
+	}
+}
+
+// cloudFrontSigner is created on first use and reused while the Lambda container is warm
+var cloudFrontSigner *sign.URLSigner
+
+// signedCloudFrontURL returns a CloudFront URL for key signed with the distribution's trusted key pair
+func signedCloudFrontURL(key string) (string, error) {
+	if cloudFrontSigner == nil {
+		privKey, err := sign.LoadPEMPrivKey(strings.NewReader(os.Getenv("CLOUDFRONT_PRIVATE_KEY")))
+		if err != nil {
+			return "", fmt.Errorf("unable to load CloudFront private key: %s", err)
+		}
+		cloudFrontSigner = sign.NewURLSigner(os.Getenv("CLOUDFRONT_KEY_PAIR_ID"), privKey)
+	}
+
+	rawURL := url.URL{Scheme: "https", Host: os.Getenv("CLOUDFRONT_DOMAIN"), Path: "/" + key}
+	return cloudFrontSigner.Sign(rawURL.String(), time.Now().Add(10*time.Minute))
+}
+
+// presignedS3URL returns a presigned S3 GET URL for key
+func presignedS3URL(loc location, key string) (string, error) {
+	svc := loc.client()
+	req, _ := svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(loc.Bucket),
+		Key:    aws.String(key),
+	})
+
+	return req.Presign(10 * time.Minute)
+}
+
 // Get presigned S3 URL to store an image
-func getPresignedURLForStore(key string) (events.APIGatewayProxyResponse, error) {
-	bucket := os.Getenv("IMAGE_BUCKET")
-	svc := s3.New(session.New())
+func getPresignedURLForStore(loc location, key string, r events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	err := registerMedia(loc, key, r)
+	if err != nil {
+		return registerError(err)
+	}
+
+	svc := loc.client()
 	req, _ := svc.PutObjectRequest(&s3.PutObjectInput{
-		Bucket: aws.String(bucket),
-		Key: aws.String(key) } )
+		Bucket: aws.String(loc.Bucket),
+		Key:    aws.String(key)})
 
 	urlStr, err := req.Presign(10 * time.Minute)
 	if err != nil {
@@ -80,11 +336,11 @@ func getPresignedURLForStore(key string) (events.APIGatewayProxyResponse, error)
 	}
 
 	infoLogger.Println("Presigned URL  ", urlStr)
-	body, _ := json.Marshal( &struct {
-																			 URL      string  `json:"url"`
-																		 }{
-																			 URL: urlStr,
-																		 })
+	body, _ := json.Marshal(&struct {
+		URL string `json:"url"`
+	}{
+		URL: urlStr,
+	})
 
 	return events.APIGatewayProxyResponse{
 		StatusCode: http.StatusOK,
@@ -93,24 +349,218 @@ func getPresignedURLForStore(key string) (events.APIGatewayProxyResponse, error)
 	}, nil
 }
 
-func serverError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
-	errorLogger.Println(err.Error())
+// maxDirectUploadBytes caps images stored through POST /images.  Anything larger must use a presigned URL.
+const maxDirectUploadBytes = 1 << 20
+
+// Extensions used for keys of directly uploaded images, by content type
+var directUploadTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// Store a small base64 encoded image for clients unable to PUT to a presigned S3 URL.  The object lands in
+// the same bucket and is registered the same way, so the media pipeline processes it like any other upload.
+func storeImage(loc location, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	contentType := req.Headers["content-type"]
+	if contentType == "" {
+		contentType = req.Headers["Content-Type"]
+	}
+
+	ext, ok := directUploadTypes[contentType]
+	if !ok {
+		return clientError(http.StatusUnsupportedMediaType, errors.New("content-type must be 'image/jpeg' or 'image/png'"))
+	}
+
+	// Binary bodies arrive base64 encoded from API Gateway; text bodies are expected to be base64 already
+	data, err := base64.StdEncoding.DecodeString(req.Body)
+	if err != nil {
+		return clientError(http.StatusBadRequest, errors.New("image body must be base64 encoded"))
+	}
+
+	if len(data) > maxDirectUploadBytes {
+		return clientError(http.StatusRequestEntityTooLarge, fmt.Errorf("image exceeds %d bytes. Use /images/store/{key} instead", maxDirectUploadBytes))
+	}
+
+	t := time.Now().UTC()
+	id, err := ulid.New(ulid.Timestamp(t), rand.New(rand.NewSource(t.UnixNano())))
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("unable to generate image key"))
+	}
+	key := loc.Prefix + id.String() + ext
+
+	err = registerMedia(loc, key, req)
+	if err != nil {
+		return registerError(err)
+	}
+
+	svc := loc.client()
+	_, err = svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(loc.Bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+		Body:        bytes.NewReader(data),
+	})
+	if err != nil {
+		errorLogger.Println(err)
+		return serverError(http.StatusInternalServerError, errors.New("Error storing image"))
+	}
+
+	infoLogger.Println("Image stored ", key)
+	body, _ := json.Marshal(&struct {
+		Key string `json:"key"`
+	}{
+		Key: key,
+	})
+
 	return events.APIGatewayProxyResponse{
-		StatusCode: statusCode,
-		Headers:    map[string]string{"content-type": "text/plain"},
-		Body:       http.StatusText(statusCode) + ": " + err.Error(),
+		StatusCode: http.StatusCreated,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
 	}, nil
 }
 
-func clientError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
-	warningLogger.Println(err.Error())
+// multipartPart identifies one uploaded part of a multipart upload by the ETag S3 returned for it
+type multipartPart struct {
+	PartNumber int64  `json:"part_number"`
+	ETag       string `json:"etag"`
+}
+
+// multipartCompletion is the body a client posts once every part of a multipart upload has been stored
+type multipartCompletion struct {
+	UploadID string          `json:"upload_id"`
+	Parts    []multipartPart `json:"parts"`
+}
+
+// Start an S3 multipart upload so large media can be sent in resumable parts
+func initiateMultipartUpload(loc location, key string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	err := registerMedia(loc, key, req)
+	if err != nil {
+		return registerError(err)
+	}
+
+	svc := loc.client()
+	result, err := svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String(loc.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		errorLogger.Println(err)
+		return serverError(http.StatusInternalServerError, errors.New("Error initiating multipart upload"))
+	}
+
+	infoLogger.Println("Multipart upload initiated ", aws.StringValue(result.UploadId))
+	body, _ := json.Marshal(&struct {
+		Key      string `json:"key"`
+		UploadID string `json:"upload_id"`
+	}{
+		Key:      key,
+		UploadID: aws.StringValue(result.UploadId),
+	})
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusCreated,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// Get presigned S3 URL to store a single part of a multipart upload
+func getPresignedURLForPart(loc location, key string, uploadID string, partNumber string) (events.APIGatewayProxyResponse, error) {
+	if uploadID == "" {
+		return clientError(http.StatusBadRequest, errors.New("upload_id must be specified"))
+	}
+
+	// S3 part numbers range from 1 to 10,000
+	part, err := strconv.ParseInt(partNumber, 10, 64)
+	if err != nil || part < 1 || part > 10000 {
+		return clientError(http.StatusBadRequest, errors.New("part_number must be an integer between 1 and 10000"))
+	}
+
+	svc := loc.client()
+	req, _ := svc.UploadPartRequest(&s3.UploadPartInput{
+		Bucket:     aws.String(loc.Bucket),
+		Key:        aws.String(key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int64(part),
+	})
+
+	urlStr, err := req.Presign(10 * time.Minute)
+	if err != nil {
+		errorLogger.Println(err)
+		return serverError(http.StatusInternalServerError, errors.New("Error retreiving presigned S3 URL for storing part"))
+	}
+
+	body, _ := json.Marshal(&struct {
+		URL        string `json:"url"`
+		PartNumber int64  `json:"part_number"`
+	}{
+		URL:        urlStr,
+		PartNumber: part,
+	})
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// Assemble the uploaded parts into the final S3 object
+func completeMultipartUpload(loc location, key string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var completion multipartCompletion
+	err := json.Unmarshal([]byte(req.Body), &completion)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, errors.New("error unmarshalling multipart completion JSON. Check syntax"))
+	}
+
+	if completion.UploadID == "" || len(completion.Parts) == 0 {
+		return clientError(http.StatusBadRequest, errors.New("upload_id and at least one part must be specified"))
+	}
+
+	parts := []*s3.CompletedPart{}
+	for _, p := range completion.Parts {
+		parts = append(parts, &s3.CompletedPart{
+			ETag:       aws.String(p.ETag),
+			PartNumber: aws.Int64(p.PartNumber),
+		})
+	}
+
+	svc := loc.client()
+	_, err = svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(loc.Bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(completion.UploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		errorLogger.Println(err)
+		return serverError(http.StatusInternalServerError, errors.New("Error completing multipart upload"))
+	}
+
+	infoLogger.Println("Multipart upload completed ", key)
+	body, _ := json.Marshal(&struct {
+		Key string `json:"key"`
+	}{
+		Key: key,
+	})
+
 	return events.APIGatewayProxyResponse{
-		StatusCode: statusCode,
-		Headers:    map[string]string{"content-type": "text/plain"},
-		Body:       http.StatusText(statusCode) + ": " + err.Error(),
+		StatusCode: http.StatusCreated,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
 	}, nil
 }
 
+func serverError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
+	errorLogger.Println(err.Error())
+	return apierror.Response(statusCode, err), nil
+}
+
+func clientError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
+	warningLogger.Println(err.Error())
+	return apierror.Response(statusCode, err), nil
+}
+
 func main() {
-	lambda.Start(router)
+	warmup.Start("images", metrics.Handler("images", apierror.Handler(repository.Audit(router))), repository.Warm)
 }
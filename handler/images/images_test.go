@@ -1,8 +1,55 @@
 package main
 
 import (
+	"errors"
+	"net/http"
 	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/repository"
 )
 
 func TestStub(t *testing.T) {
 }
+
+func TestRegisterError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{&repository.MediaConflictErr{}, http.StatusConflict},
+		{&repository.RequestIdNotFoundErr{}, http.StatusBadRequest},
+		{errors.New("throttled"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if resp, _ := registerError(tt.err); resp.StatusCode != tt.want {
+			t.Errorf("registerError(%T) = %d, want %d", tt.err, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+// Staff reach only their own city's media, which they must name; the lookups of a named city aren't reached here
+func TestMediaLocationStaff(t *testing.T) {
+	staff := map[string]interface{}{"claims": map[string]interface{}{"custom:city": "albany"}}
+	tests := []struct {
+		name       string
+		city       string
+		authorizer map[string]interface{}
+		crossCity  bool
+	}{
+		{"resident without a city", "", nil, false},
+		{"staff without a city", "", staff, true},
+		{"staff of another city", "troy", staff, true},
+	}
+	for _, tt := range tests {
+		req := events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"city": tt.city}}
+		req.RequestContext.Authorizer = tt.authorizer
+		loc, err := mediaLocation(req)
+		if _, ok := err.(*crossCityErr); ok != tt.crossCity {
+			t.Errorf("mediaLocation() of %s = %v, want cross-city %t", tt.name, err, tt.crossCity)
+		}
+		if err == nil && loc.Prefix != "" {
+			t.Errorf("mediaLocation() of %s = %+v, want the un-namespaced location", tt.name, loc)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/social-torch/open311-services/awsclient"
+	"github.com/social-torch/open311-services/importer"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+
+// importEvent names an export uploaded to IMPORT_BUCKET and the mapping of its categories, eg
+// {"city_id": "troy", "format": "seeclickfix", "key": "troy/issues.csv", "mapping_key": "troy/mapping.json"}
+type importEvent struct {
+	CityID     string `json:"city_id"`
+	Format     string `json:"format"`
+	Key        string `json:"key"`
+	MappingKey string `json:"mapping_key"`
+	DryRun     bool   `json:"dry_run"`
+}
+
+// handler imports an export of a city's previous 311 system.  It is invoked by the platform team once the export
+// and its mapping are uploaded; exports too large to import within the function's timeout are imported with
+// cmd/importer, or invoked again, as requests already imported are skipped.
+func handler(event importEvent) (importer.Result, error) {
+	if event.CityID == "" || event.Format == "" || event.Key == "" || event.MappingKey == "" {
+		return importer.Result{}, errors.New("city_id, format, key and mapping_key are required")
+	}
+
+	svc := s3.New(awsclient.Session())
+	bucket := aws.String(os.Getenv("IMPORT_BUCKET"))
+	mappingObject, err := svc.GetObject(&s3.GetObjectInput{Bucket: bucket, Key: aws.String(event.MappingKey)})
+	if err != nil {
+		return importer.Result{}, fmt.Errorf("unable to read mapping %s: %s", event.MappingKey, err)
+	}
+	mapping, err := importer.ReadMapping(mappingObject.Body)
+	mappingObject.Body.Close()
+	if err != nil {
+		return importer.Result{}, err
+	}
+
+	export, err := svc.GetObject(&s3.GetObjectInput{Bucket: bucket, Key: aws.String(event.Key)})
+	if err != nil {
+		return importer.Result{}, fmt.Errorf("unable to read export %s: %s", event.Key, err)
+	}
+	defer export.Body.Close()
+
+	result, err := importer.Import(event.CityID, event.Format, export.Body, mapping, event.DryRun)
+	if err != nil {
+		return result, err
+	}
+	infoLogger.Printf("Imported %d requests of %s from %s, skipped %d, %d categories unmapped", result.Imported, event.CityID, event.Key, result.Skipped, len(result.Unmapped))
+	return result, nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
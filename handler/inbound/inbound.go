@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/oklog/ulid"
+	"github.com/social-torch/open311-services/awsclient"
+	"github.com/social-torch/open311-services/geo"
+	"github.com/social-torch/open311-services/geocode"
+	"github.com/social-torch/open311-services/notification"
+	"github.com/social-torch/open311-services/repository"
+	"github.com/social-torch/open311-services/validate"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+var errorLogger = log.New(os.Stderr, "ERROR\t", log.Lshortfile)
+
+// emailAccount is who requests emailed in are made by.  Residents who email have no account to credit.
+const emailAccount = "guest"
+
+// handler makes requests of the emails SES receives at the addresses cities take requests at.  The SES receipt
+// rule stores each email in INBOUND_BUCKET under INBOUND_PREFIX before invoking the handler, which is only told of
+// its headers.  Once a request is made, failures are logged rather than returned, since SES retrying the email
+// would make the request again.
+func handler(event events.SimpleEmailEvent) error {
+	cities, err := repository.GetCities()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, record := range event.Records {
+		if err := receive(record.SES, cities); err != nil {
+			errorLogger.Printf("Unable to make a request of email %s: %s", record.SES.Mail.MessageID, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d emails not made into requests", failed)
+	}
+	return nil
+}
+
+// receive makes a request of an email and replies to the sender with its tracking ID, or with why it couldn't be
+// made.  Emails that can't be trusted, automatic replies and emails to no city's address are dropped.
+func receive(email events.SimpleEmailService, cities []repository.City) error {
+	id := email.Mail.MessageID
+	if !trusted(email.Receipt) {
+		warningLogger.Printf("Dropped email %s, which failed spam, virus or sender checks", id)
+		return nil
+	}
+	if automatic(email.Mail) {
+		infoLogger.Printf("Dropped automatic email %s", id)
+		return nil
+	}
+	if len(email.Mail.CommonHeaders.From) == 0 {
+		return nil
+	}
+	sender, err := mail.ParseAddress(email.Mail.CommonHeaders.From[0])
+	if err != nil {
+		warningLogger.Printf("Dropped email %s from unreadable sender '%s'", id, email.Mail.CommonHeaders.From[0])
+		return nil
+	}
+
+	city, serviceCode, ok := route(cities, email.Receipt.Recipients)
+	if !ok {
+		infoLogger.Printf("Dropped email %s to %s, which no city takes requests at", id, strings.Join(email.Receipt.Recipients, ", "))
+		return nil
+	}
+
+	raw, err := getEmail(id)
+	if err != nil {
+		return err
+	}
+	m, err := parseMessage(raw)
+	if err != nil {
+		warningLogger.Printf("Dropped unreadable email %s: %s", id, err)
+		return nil
+	}
+
+	request, err := makeRequest(city, serviceCode, m)
+	if err != nil {
+		switch err.(type) {
+		case *rejectionErr:
+			infoLogger.Printf("Email %s to %s not made into a request: %s", id, city.CityName, err)
+			reply(city, sender.Address, notification.EmailRequestRejectedTemplate, map[string]string{"reason": err.Error(), "subject": m.Subject})
+			return nil
+		default:
+			return err
+		}
+	}
+
+	keys, err := mediaKeys(city, m.Attachments, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		request.MediaURL = mediaURL(keys[0])
+	}
+	if err := validate.Request(request); err != nil {
+		if _, ok := err.(*validate.InvalidRequestErr); !ok {
+			return err
+		}
+		infoLogger.Printf("Email %s to %s not made into a request: %s", id, city.CityName, err)
+		reply(city, sender.Address, notification.EmailRequestRejectedTemplate, map[string]string{"reason": err.Error(), "subject": m.Subject})
+		return nil
+	}
+
+	response, err := repository.SubmitRequest(request, emailAccount)
+	if err != nil {
+		return err
+	}
+	infoLogger.Printf("New request %s made of email %s to %s", response.ServiceRequestID, id, city.CityName)
+
+	storeAttachments(city, response.ServiceRequestID, keys, m.Attachments)
+	reply(city, sender.Address, notification.EmailRequestReceivedTemplate, map[string]string{
+		"service_request_id": response.ServiceRequestID,
+		"service_name":       request.ServiceName,
+		"address":            request.Address,
+	})
+	return nil
+}
+
+// trusted reports whether SES found an email free of spam and viruses, and from the sender it claims to be from.
+// Replying to a forged sender would send the city's mail to someone who never wrote.
+func trusted(receipt events.SimpleEmailReceipt) bool {
+	if receipt.SpamVerdict.Status == "FAIL" || receipt.VirusVerdict.Status == "FAIL" || receipt.DMARCVerdict.Status == "FAIL" {
+		return false
+	}
+	return receipt.SPFVerdict.Status == "PASS" || receipt.DKIMVerdict.Status == "PASS"
+}
+
+// automatic reports whether an email was sent by a machine, such as a bounce, an out-of-office reply or a mailing
+// list, which would otherwise make requests of each other's replies
+func automatic(m events.SimpleEmailMessage) bool {
+	if m.Source == "" {
+		return true
+	}
+	for _, h := range m.Headers {
+		value := strings.ToLower(strings.TrimSpace(h.Value))
+		switch strings.ToLower(h.Name) {
+		case "auto-submitted":
+			if value != "no" {
+				return true
+			}
+		case "precedence":
+			if value == "bulk" || value == "list" || value == "junk" || value == "auto_reply" {
+				return true
+			}
+		case "x-autoreply", "x-autorespond", "list-id":
+			return true
+		}
+	}
+	return false
+}
+
+// route returns the city taking requests at the first recipient of an email that a city does, and the service of
+// requests emailed there.  An address claimed by more than one city is routed to neither.  Federated cities take
+// requests on their own servers.
+func route(cities []repository.City, recipients []string) (repository.City, string, bool) {
+	for _, recipient := range recipients {
+		var matched []repository.City
+		var serviceCode string
+		for _, city := range cities {
+			if city.Federated {
+				continue
+			}
+			if code, ok := city.Config.InboundService(recipient); ok {
+				matched = append(matched, city)
+				serviceCode = code
+			}
+		}
+
+		switch len(matched) {
+		case 0:
+			continue
+		case 1:
+			return matched[0], serviceCode, true
+		default:
+			warningLogger.Printf("Inbound email %s is claimed by %d cities, and routed to none", recipient, len(matched))
+		}
+	}
+	return repository.City{}, "", false
+}
+
+// rejectionErr is returned by makeRequest for emails that can't be made into a request.  Its message is told to
+// the sender.
+type rejectionErr struct {
+	message string
+}
+
+func (e *rejectionErr) Error() string {
+	return e.message
+}
+
+// makeRequest makes the request an email to a city reports, located by geocoding its address within the city
+func makeRequest(city repository.City, serviceCode string, m message) (repository.Request, error) {
+	if city.Deactivated {
+		notice := city.DeactivationNotice
+		if notice == "" {
+			notice = fmt.Sprintf("%s is not taking new requests by email right now. Please try again later", city.CityName)
+		}
+		return repository.Request{}, &rejectionErr{notice}
+	}
+
+	service, err := repository.LookupService(city.CityName, serviceCode)
+	if err != nil {
+		if _, ok := err.(*repository.ServiceCodeNotFoundErr); !ok {
+			return repository.Request{}, err
+		}
+		warningLogger.Printf("Inbound email of %s names service_code '%s', which the city doesn't offer", city.CityName, serviceCode)
+		return repository.Request{}, &rejectionErr{fmt.Sprintf("%s is not taking requests at this address", city.CityName)}
+	}
+
+	description, address := describe(m)
+	if address == "" {
+		return repository.Request{}, &rejectionErr{`we couldn't tell where the issue is. Send it again with a line such as "Address: 433 River St"`}
+	}
+	if description == "" {
+		description = m.Subject
+	}
+	description = truncate(description, validate.MaxDescriptionLength)
+
+	var area *geo.Box
+	if box, ok := city.Box(); ok {
+		area = &box
+	}
+	match, err := geocode.ForCity(city).Forward(address, area)
+	if err != nil {
+		switch err.(type) {
+		case *geocode.NotFoundErr:
+			return repository.Request{}, &rejectionErr{fmt.Sprintf(`we couldn't find "%s" in %s. Send it again with a line such as "Address: 433 River St"`, address, city.CityName)}
+		default:
+			return repository.Request{}, err
+		}
+	}
+	lat, lon := match.Place.Latitude, match.Place.Longitude
+	location, err := repository.NewLocation(lat, lon)
+	if err != nil {
+		return repository.Request{}, fmt.Errorf("place index located '%s' at an invalid location: %s", address, err)
+	}
+	if len(city.Boundary) > 0 && !city.Boundary.Contains(lat, lon) {
+		return repository.Request{}, &rejectionErr{fmt.Sprintf(`"%s" is outside the city limits of %s`, address, city.CityName)}
+	}
+
+	return repository.Request{
+		CityID:       city.CityName,
+		ServiceCode:  service.ServiceCode,
+		ServiceName:  service.ServiceName,
+		Description:  description,
+		Address:      address,
+		AddressID:    match.Place.AddressID,
+		ZipCode:      match.Place.ZipCode,
+		Location:     location,
+		Neighborhood: city.NeighborhoodOf(lat, lon),
+	}, nil
+}
+
+// truncate cuts a description to at most max characters, as long emails such as forwarded threads would otherwise
+// be refused
+func truncate(description string, max int) string {
+	runes := []rune(description)
+	if len(runes) <= max {
+		return description
+	}
+	return string(runes[:max])
+}
+
+// mediaURL is where clients fetch a stored attachment, through the images API
+func mediaURL(key string) string {
+	return os.Getenv("API_URL") + "/images/fetch/" + url.PathEscape(key)
+}
+
+// getEmail reads the raw email SES stored
+func getEmail(messageID string) ([]byte, error) {
+	key := os.Getenv("INBOUND_PREFIX") + messageID
+	svc := s3.New(awsclient.Session())
+	result, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(os.Getenv("INBOUND_BUCKET")),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read email '%s': %s", key, err)
+	}
+	defer result.Body.Close()
+
+	raw, err := ioutil.ReadAll(result.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read email '%s': %s", key, err)
+	}
+	return raw, nil
+}
+
+// mediaKeys returns the keys attachments are stored at, under the city's prefix like the uploads of the app
+func mediaKeys(city repository.City, attachments []attachment, t time.Time) ([]string, error) {
+	entropy := rand.New(rand.NewSource(t.UnixNano()))
+	keys := make([]string, len(attachments))
+	for i, a := range attachments {
+		id, err := ulid.New(ulid.Timestamp(t), entropy)
+		if err != nil {
+			return nil, errors.New("unable to generate media key")
+		}
+		keys[i] = city.CityName + "/" + id.String() + attachmentExtensions[a.ContentType]
+	}
+	return keys, nil
+}
+
+// storeAttachments registers the attachments of an email as media of its request and stores them in the city's
+// media bucket, where the media pipeline moderates and processes them like any other upload
+func storeAttachments(city repository.City, requestID string, keys []string, attachments []attachment) {
+	bucket := os.Getenv("IMAGE_BUCKET")
+	if city.MediaBucket != "" {
+		bucket = city.MediaBucket
+	}
+	svc := s3.New(awsclient.SessionIn(city.Region))
+
+	for i, a := range attachments {
+		err := repository.RegisterMedia(repository.Media{
+			Key:              keys[i],
+			City:             city.CityName,
+			ServiceRequestID: requestID,
+			AccountID:        emailAccount,
+		})
+		if err != nil {
+			errorLogger.Println(err)
+			continue
+		}
+
+		_, err = svc.PutObject(&s3.PutObjectInput{
+			Bucket:      aws.String(bucket),
+			Key:         aws.String(keys[i]),
+			ContentType: aws.String(a.ContentType),
+			Body:        bytes.NewReader(a.Data),
+		})
+		if err != nil {
+			errorLogger.Printf("Unable to store attachment %s of request %s: %s", keys[i], requestID, err)
+		}
+	}
+}
+
+// reply emails the sender of a request in the city's copy.  Failures are logged, since the request stands.
+func reply(city repository.City, to string, template string, data map[string]string) {
+	if _, err := notification.SendCityEmail(city, "", to, template, data); err != nil {
+		warningLogger.Println(err)
+	}
+}
+
+func main() {
+	lambda.Start(handler)
+}
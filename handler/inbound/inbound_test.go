@@ -0,0 +1,160 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/repository"
+)
+
+const photoEmail = "From: Resident <resident@example.com>\r\n" +
+	"To: potholes@troyny.gov\r\n" +
+	"Subject: =?UTF-8?Q?Pothole_on_River_St?=\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=\"outer\"\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"inner\"\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=UTF-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Deep pothole in the right lane =E2=80=94 it took out my tire.\r\n" +
+	"Address: 433 River St\r\n" +
+	"\r\n" +
+	"-- \r\n" +
+	"Sent from my phone\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=UTF-8\r\n" +
+	"\r\n" +
+	"<p>Deep pothole in the right lane</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: image/jpeg; name=\"pothole.jpg\"\r\n" +
+	"Content-Disposition: attachment; filename=\"pothole.jpg\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"/9j/4AAQ\r\n" +
+	"SkZJRg==\r\n" +
+	"--outer\r\n" +
+	"Content-Type: application/pdf\r\n" +
+	"Content-Disposition: attachment; filename=\"letter.pdf\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0=\r\n" +
+	"--outer--\r\n"
+
+func TestParseMessage(t *testing.T) {
+	m, err := parseMessage([]byte(photoEmail))
+	if err != nil {
+		t.Fatalf("parseMessage() = %s", err)
+	}
+	if m.Subject != "Pothole on River St" {
+		t.Errorf("Subject = %q, want the decoded subject", m.Subject)
+	}
+	if !strings.HasPrefix(m.Text, "Deep pothole in the right lane — it took out my tire.") {
+		t.Errorf("Text = %q, want the decoded plain text body", m.Text)
+	}
+	if len(m.Attachments) != 1 || m.Attachments[0].ContentType != "image/jpeg" || string(m.Attachments[0].Data) != "\xff\xd8\xff\xe0\x00\x10JFIF" {
+		t.Errorf("Attachments = %+v, want the photo only", m.Attachments)
+	}
+
+	description, address := describe(m)
+	if description != "Deep pothole in the right lane — it took out my tire." || address != "433 River St" {
+		t.Errorf("describe() = %q, %q", description, address)
+	}
+}
+
+func TestParseHTMLMessage(t *testing.T) {
+	raw := "From: resident@example.com\r\nSubject: 12 Congress St\r\nContent-Type: text/html\r\n\r\n<div>Streetlight out&nbsp;since Monday<br>Please fix</div>"
+	m, err := parseMessage([]byte(raw))
+	if err != nil {
+		t.Fatalf("parseMessage() = %s", err)
+	}
+	description, address := describe(m)
+	if description != "Streetlight out since Monday\nPlease fix" || address != "12 Congress St" {
+		t.Errorf("describe() = %q, %q, want the text of the HTML body and the subject", description, address)
+	}
+}
+
+func TestDescribeQuotedReply(t *testing.T) {
+	m := message{Text: "Still not fixed\nLocation: 1 Monument Sq\n\nOn Mon, Jun 3, 2019 at 9:00 AM Troy 311 wrote:\n> Your request was received\nAddress: 2 Elsewhere Rd"}
+	description, address := describe(m)
+	if description != "Still not fixed" || address != "1 Monument Sq" {
+		t.Errorf("describe() = %q, %q, want the body above the quote", description, address)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate("Pothole", 10); got != "Pothole" {
+		t.Errorf("truncate() = %q, want a short description kept whole", got)
+	}
+	if got := truncate("Schlaglöcher", 10); got != "Schlaglöch" {
+		t.Errorf("truncate() = %q, want the first 10 characters", got)
+	}
+}
+
+func TestTrusted(t *testing.T) {
+	pass := events.SimpleEmailVerdict{Status: "PASS"}
+	fail := events.SimpleEmailVerdict{Status: "FAIL"}
+	tests := []struct {
+		name    string
+		receipt events.SimpleEmailReceipt
+		want    bool
+	}{
+		{"authenticated", events.SimpleEmailReceipt{SPFVerdict: pass, DKIMVerdict: pass, SpamVerdict: pass, VirusVerdict: pass}, true},
+		{"dkim only", events.SimpleEmailReceipt{DKIMVerdict: pass}, true},
+		{"unauthenticated", events.SimpleEmailReceipt{SPFVerdict: fail, DKIMVerdict: fail}, false},
+		{"spam", events.SimpleEmailReceipt{SPFVerdict: pass, SpamVerdict: fail}, false},
+		{"virus", events.SimpleEmailReceipt{DKIMVerdict: pass, VirusVerdict: fail}, false},
+		{"dmarc failure", events.SimpleEmailReceipt{SPFVerdict: pass, DMARCVerdict: fail}, false},
+	}
+	for _, tt := range tests {
+		if got := trusted(tt.receipt); got != tt.want {
+			t.Errorf("trusted() of %s email = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAutomatic(t *testing.T) {
+	header := func(name, value string) events.SimpleEmailMessage {
+		return events.SimpleEmailMessage{Source: "resident@example.com", Headers: []events.SimpleEmailHeader{{Name: name, Value: value}}}
+	}
+	if automatic(header("Auto-Submitted", "no")) || automatic(header("Precedence", "first-class")) {
+		t.Error("automatic() of a resident's email = true, want false")
+	}
+	for _, m := range []events.SimpleEmailMessage{
+		header("Auto-Submitted", "auto-replied"),
+		header("Precedence", "bulk"),
+		header("X-Autoreply", "yes"),
+		header("List-Id", "<neighbors.example.com>"),
+		{Source: ""},
+	} {
+		if !automatic(m) {
+			t.Errorf("automatic(%+v) = false, want true", m)
+		}
+	}
+}
+
+func TestRoute(t *testing.T) {
+	troy := repository.City{CityName: "troy"}
+	troy.Config.InboundEmail = map[string]string{"potholes@troyny.gov": "troy-pothole", "311@example.org": "troy-other"}
+	albany := repository.City{CityName: "albany"}
+	albany.Config.InboundEmail = map[string]string{"311@example.org": "albany-other"}
+	federated := repository.City{CityName: "cohoes", Federated: true}
+	federated.Config.InboundEmail = map[string]string{"potholes@cohoes.example": "cohoes-pothole"}
+	cities := []repository.City{troy, albany, federated}
+
+	city, code, ok := route(cities, []string{"someone@example.com", "Potholes@troyny.gov"})
+	if !ok || city.CityName != "troy" || code != "troy-pothole" {
+		t.Errorf("route() = %s, %s, %v, want troy-pothole", city.CityName, code, ok)
+	}
+	if _, _, ok := route(cities, []string{"311@example.org"}); ok {
+		t.Error("route() of an address claimed by two cities should not be ok")
+	}
+	if _, _, ok := route(cities, []string{"potholes@cohoes.example"}); ok {
+		t.Error("route() to a federated city should not be ok")
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// Limits on the attachments of an email taken into the media pipeline.  Further attachments are dropped.
+const (
+	maxAttachments     = 5
+	maxAttachmentBytes = 10 << 20
+)
+
+// attachmentExtensions are the extensions of the attachments taken into the media pipeline, by content type
+var attachmentExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// message is the part of an email a request is made from
+type message struct {
+	Subject     string
+	Text        string
+	Attachments []attachment
+}
+
+// attachment is a photo attached to an email
+type attachment struct {
+	ContentType string
+	Data        []byte
+}
+
+// header is the header of an email or of one of its parts
+type header interface {
+	Get(key string) string
+}
+
+// parseMessage reads the subject, plain text body and photos of a raw email.  Emails with only an HTML body have
+// its text taken, without the markup.
+func parseMessage(raw []byte) (message, error) {
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return message{}, fmt.Errorf("error reading email: %s", err)
+	}
+
+	var parsed message
+	subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	if err != nil {
+		subject = m.Header.Get("Subject")
+	}
+	parsed.Subject = strings.TrimSpace(subject)
+
+	var html string
+	err = parsePart(m.Header, m.Body, &parsed, &html)
+	if err != nil {
+		return message{}, err
+	}
+	if parsed.Text == "" && html != "" {
+		parsed.Text = htmlText(html)
+	}
+	return parsed, nil
+}
+
+// parsePart reads a part of an email into parsed, descending into multipart parts.  The first plain text and HTML
+// bodies are kept.
+func parsePart(h header, body io.Reader, parsed *message, html *string) error {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("error reading email part: %s", err)
+			}
+			err = parsePart(part.Header, part, parsed, html)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(decode(h, body), maxAttachmentBytes+1))
+	if err != nil {
+		return fmt.Errorf("error reading email part: %s", err)
+	}
+
+	attached := strings.HasPrefix(strings.ToLower(h.Get("Content-Disposition")), "attachment")
+	switch {
+	case mediaType == "text/plain" && !attached && parsed.Text == "":
+		parsed.Text = strings.TrimSpace(string(data))
+	case mediaType == "text/html" && !attached && *html == "":
+		*html = string(data)
+	case attachmentExtensions[mediaType] != "":
+		if len(parsed.Attachments) < maxAttachments && len(data) <= maxAttachmentBytes {
+			parsed.Attachments = append(parsed.Attachments, attachment{ContentType: mediaType, Data: data})
+		}
+	}
+	return nil
+}
+
+// decode undoes the transfer encoding of a part.  Quoted-printable parts of multipart bodies are already decoded by
+// mime/multipart, which removes their Content-Transfer-Encoding.
+func decode(h header, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(h.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &whitespaceStripper{body})
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
+
+// whitespaceStripper drops the line breaks of base64 bodies, which encoding/base64 doesn't expect mid-stream
+type whitespaceStripper struct {
+	r io.Reader
+}
+
+func (w *whitespaceStripper) Read(p []byte) (int, error) {
+	n, err := w.r.Read(p)
+	kept := 0
+	for _, b := range p[:n] {
+		if b != '\r' && b != '\n' && b != ' ' && b != '\t' {
+			p[kept] = b
+			kept++
+		}
+	}
+	return kept, err
+}
+
+var (
+	tags       = regexp.MustCompile(`(?s)<(script|style)[^>]*>.*?</(script|style)>|<[^>]*>`)
+	blankLines = regexp.MustCompile(`\n\s*\n\s*`)
+)
+
+// htmlText returns the text of an HTML body
+func htmlText(html string) string {
+	text := tags.ReplaceAllString(strings.NewReplacer("<br>", "\n", "<br/>", "\n", "<br />", "\n", "</p>", "\n\n", "</div>", "\n").Replace(html), "")
+	text = strings.NewReplacer("&nbsp;", " ", "&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", `"`, "&#39;", "'").Replace(text)
+	return strings.TrimSpace(blankLines.ReplaceAllString(text, "\n\n"))
+}
+
+// addressLine matches a line of the body giving the location of the issue, eg "Address: 433 River St"
+var addressLine = regexp.MustCompile(`(?i)^\s*(address|location|where)\s*:\s*(.+?)\s*$`)
+
+// quoteStart matches the line mail clients begin a quoted earlier message with
+var quoteStart = regexp.MustCompile(`^On .+ wrote:$`)
+
+// describe returns the description and address of the issue an email reports.  The description is the body up to
+// the sender's signature or a quoted earlier message.  The address is taken from an "Address:" line of the body,
+// which is left out of the description, or else is the subject.
+func describe(m message) (description string, address string) {
+	var lines []string
+	for _, line := range strings.Split(strings.Replace(m.Text, "\r\n", "\n", -1), "\n") {
+		trimmed := strings.TrimRight(line, " \t")
+		if trimmed == "--" || strings.HasPrefix(trimmed, ">") || quoteStart.MatchString(strings.TrimSpace(trimmed)) {
+			break
+		}
+		if match := addressLine.FindStringSubmatch(trimmed); match != nil && address == "" {
+			address = match[2]
+			continue
+		}
+		lines = append(lines, trimmed)
+	}
+
+	if address == "" {
+		address = m.Subject
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")), address
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+	"github.com/social-torch/open311-services/awsclient"
+	"github.com/social-torch/open311-services/notification"
+	"github.com/social-torch/open311-services/repository"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+
+// handler pushes domain events to the WebSocket connections whose filters match the request
+func handler(event events.CloudWatchEvent) error {
+	var requestEvent repository.RequestEvent
+	err := json.Unmarshal(event.Detail, &requestEvent)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling domain event detail: %s", err)
+	}
+
+	connections, err := repository.GetConnections()
+	if err != nil {
+		return err
+	}
+
+	// WEBSOCKET_ENDPOINT is the https:// callback URL of the WebSocket API stage
+	svc := apigatewaymanagementapi.New(awsclient.Session(), aws.NewConfig().WithEndpoint(os.Getenv("WEBSOCKET_ENDPOINT")))
+
+	sent := 0
+	for _, connection := range connections {
+		if !wants(connection, requestEvent.Request) {
+			continue
+		}
+
+		_, err = svc.PostToConnection(&apigatewaymanagementapi.PostToConnectionInput{
+			ConnectionId: aws.String(connection.ID),
+			Data:         event.Detail,
+		})
+		if err != nil {
+			// Clients that vanished without disconnecting are cleaned up here
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == apigatewaymanagementapi.ErrCodeGoneException {
+				err = repository.DeleteConnection(connection.ID)
+				if err != nil {
+					warningLogger.Println(err)
+				}
+				continue
+			}
+			warningLogger.Printf("unable to post to connection %s: %s", connection.ID, err)
+			continue
+		}
+		sent++
+	}
+
+	infoLogger.Printf("Pushed %s of %s to %d connections", requestEvent.Type, requestEvent.ServiceRequestID, sent)
+	return nil
+}
+
+// wants reports whether a connection's filters cover a request.  Connections without filters receive everything
+func wants(connection repository.Connection, request repository.Request) bool {
+	if len(connection.Filters) == 0 {
+		return true
+	}
+	for _, filter := range connection.Filters {
+		if notification.Matches(filter, request) {
+			return true
+		}
+	}
+	return false
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestWants(t *testing.T) {
+	location, err := repository.NewLocation(42.6526, -73.7562)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := repository.Request{ServiceRequestID: "42", ServiceCode: "001", Location: location}
+
+	near, _ := repository.NewLocation(42.6530, -73.7560)
+	far, _ := repository.NewLocation(42.7284, -73.6918)
+
+	tests := []struct {
+		name    string
+		filters []repository.Subscription
+		want    bool
+	}{
+		{"no filters", nil, true},
+		{"request", []repository.Subscription{{Type: repository.RequestSubscription, ServiceRequestID: "42"}}, true},
+		{"other request", []repository.Subscription{{Type: repository.RequestSubscription, ServiceRequestID: "43"}}, false},
+		{"service", []repository.Subscription{{Type: repository.ServiceSubscription, ServiceCode: "001"}}, true},
+		{"nearby area", []repository.Subscription{{Type: repository.AreaSubscription, Location: near, Radius: 500}}, true},
+		{"distant area", []repository.Subscription{{Type: repository.AreaSubscription, Location: far, Radius: 500}}, false},
+		{"any filter", []repository.Subscription{
+			{Type: repository.ServiceSubscription, ServiceCode: "002"},
+			{Type: repository.RequestSubscription, ServiceRequestID: "42"},
+		}, true},
+	}
+
+	for _, test := range tests {
+		connection := repository.Connection{ID: "c", Filters: test.filters}
+		if got := wants(connection, request); got != test.want {
+			t.Errorf("wants(%s) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
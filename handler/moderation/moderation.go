@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rekognition"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/social-torch/open311-services/awsclient"
+	"github.com/social-torch/open311-services/repository"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+
+// minConfidence is the Rekognition confidence (percent) above which a label or face is acted upon
+const minConfidence = 80
+
+// Top level Rekognition moderation categories that cause an image to be rejected outright
+var rejectedCategories = []string{"Explicit Nudity", "Violence", "Visually Disturbing"}
+
+// Image types Rekognition is able to analyze
+var imageExtensions = []string{".jpg", ".jpeg", ".png"}
+
+// handler completes the Media record of each stored upload with its content type, size, dimensions and,
+// for images, the moderation status determined by Rekognition.  Images are normalized along the way.
+func handler(event events.S3Event) error {
+	for _, record := range event.Records {
+		// Copies are storage class changes, such as retention archiving an upload onto its own key, or preserved
+		// originals; neither is a new upload
+		if record.EventName == "ObjectCreated:Copy" {
+			continue
+		}
+
+		// The bucket of a city pinned to a region is there, and Rekognition only reads buckets of its own region
+		sess := awsclient.SessionIn(record.AWSRegion)
+		rek := rekognition.New(sess)
+		svc := s3.New(sess)
+
+		bucket := record.S3.Bucket.Name
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			return fmt.Errorf("unable to decode object key '%s': %s", record.S3.Object.Key, err)
+		}
+
+		head, err := svc.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return fmt.Errorf("unable to read metadata of '%s': %s", key, err)
+		}
+
+		// The normalized replacement of an image was already recorded when it was written
+		if _, ok := head.Metadata[normalizedMetadata]; ok {
+			continue
+		}
+
+		// Derived objects such as transcoder output and preserved originals are not registered
+		_, err = repository.GetMedia(key)
+		if err != nil {
+			switch err.(type) {
+			case *repository.MediaNotFoundErr:
+				infoLogger.Printf("Skipping unregistered object %s", key)
+				continue
+			default:
+				return err
+			}
+		}
+
+		// Only images can be analyzed; other media (eg video) is left pending for review
+		status := repository.ModerationPending
+		size := aws.Int64Value(head.ContentLength)
+		width, height := 0, 0
+		if isImage(key) {
+			status, err = moderate(rek, bucket, key)
+			if err != nil {
+				return err
+			}
+
+			width, height, size, err = normalizeImage(svc, bucket, key, aws.StringValue(head.ContentType))
+			if err != nil {
+				warningLogger.Printf("Unable to normalize %s: %s", key, err)
+				size = aws.Int64Value(head.ContentLength)
+			}
+		}
+
+		err = repository.RecordMediaUpload(key, aws.StringValue(head.ContentType), size, width, height, status)
+		if err != nil {
+			return err
+		}
+
+		if status == repository.ModerationApproved {
+			infoLogger.Printf("Media %s %s", key, status)
+		} else {
+			warningLogger.Printf("Media %s %s", key, status)
+		}
+	}
+
+	return nil
+}
+
+// moderate determines the moderation status of an image.  Nudity and graphic violence are rejected;
+// images showing faces may expose personal information and are flagged for review.
+func moderate(rek *rekognition.Rekognition, bucket string, key string) (string, error) {
+	img := &rekognition.Image{
+		S3Object: &rekognition.S3Object{
+			Bucket: aws.String(bucket),
+			Name:   aws.String(key),
+		},
+	}
+
+	labels, err := rek.DetectModerationLabels(&rekognition.DetectModerationLabelsInput{
+		Image:         img,
+		MinConfidence: aws.Float64(minConfidence),
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to detect moderation labels for '%s': %s", key, err)
+	}
+
+	if isRejected(labels.ModerationLabels) {
+		return repository.ModerationRejected, nil
+	}
+
+	faces, err := rek.DetectFaces(&rekognition.DetectFacesInput{Image: img})
+	if err != nil {
+		return "", fmt.Errorf("unable to detect faces for '%s': %s", key, err)
+	}
+
+	if showsFace(faces.FaceDetails) {
+		return repository.ModerationFlagged, nil
+	}
+
+	return repository.ModerationApproved, nil
+}
+
+// isRejected reports whether any moderation label falls in a rejected top level category.  Top level labels have no
+// parent and are their own category.
+func isRejected(labels []*rekognition.ModerationLabel) bool {
+	for _, label := range labels {
+		category := aws.StringValue(label.ParentName)
+		if category == "" {
+			category = aws.StringValue(label.Name)
+		}
+		for _, rejected := range rejectedCategories {
+			if category == rejected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// showsFace reports whether any face was detected with at least minConfidence
+func showsFace(faces []*rekognition.FaceDetail) bool {
+	for _, face := range faces {
+		if aws.Float64Value(face.Confidence) >= minConfidence {
+			return true
+		}
+	}
+	return false
+}
+
+func isImage(key string) bool {
+	key = strings.ToLower(key)
+	for _, ext := range imageExtensions {
+		if strings.HasSuffix(key, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rekognition"
+)
+
+func TestIsRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		parent string
+		want   bool
+	}{
+		{"Explicit Nudity", "", true},
+		{"Graphic Male Nudity", "Explicit Nudity", true},
+		{"Weapon Violence", "Violence", true},
+		{"Emaciated Bodies", "Visually Disturbing", true},
+		{"Suggestive", "", false},
+		{"Female Swimwear Or Underwear", "Suggestive", false},
+		{"Drinking", "Alcohol", false},
+	}
+
+	for _, test := range tests {
+		labels := []*rekognition.ModerationLabel{{Name: aws.String(test.name), ParentName: aws.String(test.parent)}}
+		if got := isRejected(labels); got != test.want {
+			t.Errorf("isRejected(%q, %q) = %v, want %v", test.name, test.parent, got, test.want)
+		}
+	}
+
+	if isRejected(nil) {
+		t.Errorf("isRejected(nil) = true, want false")
+	}
+}
+
+func TestShowsFace(t *testing.T) {
+	tests := []struct {
+		confidences []float64
+		want        bool
+	}{
+		{nil, false},
+		{[]float64{40}, false},
+		{[]float64{minConfidence}, true},
+		{[]float64{20, 99.5}, true},
+	}
+
+	for _, test := range tests {
+		faces := []*rekognition.FaceDetail{}
+		for _, c := range test.confidences {
+			faces = append(faces, &rekognition.FaceDetail{Confidence: aws.Float64(c)})
+		}
+		if got := showsFace(faces); got != test.want {
+			t.Errorf("showsFace(%v) = %v, want %v", test.confidences, got, test.want)
+		}
+	}
+}
+
+func TestIsImage(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"albany/photo.jpg", true},
+		{"albany/PHOTO.JPEG", true},
+		{"photo.png", true},
+		{"albany/clip.mp4", false},
+		{"photo.gif", false},
+	}
+
+	for _, test := range tests {
+		if got := isImage(test.key); got != test.want {
+			t.Errorf("isImage(%q) = %v, want %v", test.key, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"golang.org/x/image/draw"
+)
+
+// originalsPrefix is where untouched uploads are preserved, in cold storage, when an image is normalized
+const originalsPrefix = "originals/"
+
+// normalizedMetadata marks objects written by normalizeImage so the event they trigger is not processed again.
+// The SDK canonicalizes metadata keys, hence the capitalization.
+const normalizedMetadata = "Normalized"
+
+// defaultMaxDimension caps the longest side of stored images when MAX_IMAGE_DIMENSION is not set
+const defaultMaxDimension = 2048
+
+// normalizeImage rotates an image upright according to its EXIF orientation and downscales it so its longest side
+// does not exceed the configured maximum.  When either is needed the original is copied to the originals prefix and
+// replaced by the normalized image.  The stored width, height and size in bytes are returned.
+func normalizeImage(svc *s3.S3, bucket string, key string, contentType string) (int, int, int64, error) {
+	object, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer object.Body.Close()
+
+	data, err := ioutil.ReadAll(object.Body)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	orientation := 1
+	if format == "jpeg" {
+		orientation = exifOrientation(data)
+	}
+
+	maxDimension := defaultMaxDimension
+	if v, err := strconv.Atoi(os.Getenv("MAX_IMAGE_DIMENSION")); err == nil && v > 0 {
+		maxDimension = v
+	}
+
+	b := img.Bounds()
+	if orientation == 1 && b.Dx() <= maxDimension && b.Dy() <= maxDimension {
+		return b.Dx(), b.Dy(), int64(len(data)), nil
+	}
+
+	img = orient(downscale(img, maxDimension), orientation)
+
+	var buf bytes.Buffer
+	if format == "png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	}
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("unable to encode normalized image: %s", err)
+	}
+
+	_, err = svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(originalsPrefix + key),
+		CopySource:   aws.String(bucket + "/" + url.PathEscape(key)),
+		StorageClass: aws.String(s3.StorageClassGlacierIr),
+	})
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("unable to preserve original: %s", err)
+	}
+
+	_, err = svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+		Body:        bytes.NewReader(buf.Bytes()),
+		Metadata:    map[string]*string{normalizedMetadata: aws.String("true")},
+	})
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("unable to store normalized image: %s", err)
+	}
+
+	infoLogger.Printf("Normalized %s (orientation %d, %dx%d)", key, orientation, img.Bounds().Dx(), img.Bounds().Dy())
+	return img.Bounds().Dx(), img.Bounds().Dy(), int64(buf.Len()), nil
+}
+
+// downscale shrinks img so neither side exceeds max, preserving aspect ratio
+func downscale(img image.Image, max int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= max && h <= max {
+		return img
+	}
+
+	if w >= h {
+		h = h * max / w
+		w = max
+	} else {
+		w = w * max / h
+		h = max
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// orient applies the transform that displays an image stored with the given EXIF orientation upright
+func orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				sx, sy = w-1-x, y
+			case 3: // rotated 180
+				sx, sy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				sx, sy = x, h-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // needs 90 clockwise rotation
+				sx, sy = y, h-1-x
+			case 7: // transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // needs 90 counter-clockwise rotation
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// exifOrientation returns the EXIF orientation (1-8) of a JPEG, or 1 if it has none
+func exifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	// Walk the JPEG segments looking for the APP1 Exif segment, which precedes the image data
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xDA || i+2+length > len(data) { // start of scan
+			return 1
+		}
+
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// tiffOrientation reads the orientation tag from the first IFD of a TIFF header
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+
+	entries := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < entries; e++ {
+		entry := ifd + 2 + e*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
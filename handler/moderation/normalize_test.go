@@ -0,0 +1,92 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// jpegWithOrientation builds the start of a JPEG holding only an APP1 Exif segment with the given orientation
+func jpegWithOrientation(orientation byte, bigEndian bool) []byte {
+	tiff := []byte{'I', 'I', 0x2A, 0x00, 0x08, 0x00, 0x00, 0x00, // header, IFD0 at offset 8
+		0x01, 0x00, // one entry
+		0x12, 0x01, 0x03, 0x00, 0x01, 0x00, 0x00, 0x00, orientation, 0x00, 0x00, 0x00, // orientation, SHORT
+		0x00, 0x00, 0x00, 0x00} // no next IFD
+	if bigEndian {
+		tiff = []byte{'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08,
+			0x00, 0x01,
+			0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, orientation, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00}
+	}
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	length := len(segment) + 2
+	data := []byte{0xFF, 0xD8, 0xFF, 0xE1, byte(length >> 8), byte(length)}
+	data = append(data, segment...)
+	return append(data, 0xFF, 0xDA, 0x00, 0x02)
+}
+
+func TestExifOrientation(t *testing.T) {
+	for _, bigEndian := range []bool{false, true} {
+		for o := byte(1); o <= 8; o++ {
+			if got := exifOrientation(jpegWithOrientation(o, bigEndian)); got != int(o) {
+				t.Errorf("exifOrientation(bigEndian=%v) = %d, want %d", bigEndian, got, o)
+			}
+		}
+	}
+
+	if got := exifOrientation([]byte{0x89, 'P', 'N', 'G'}); got != 1 {
+		t.Errorf("exifOrientation(png) = %d, want 1", got)
+	}
+
+	if got := exifOrientation(jpegWithOrientation(9, false)); got != 1 {
+		t.Errorf("exifOrientation(invalid) = %d, want 1", got)
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// 2x1 image: red on the left, blue on the right
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, red)
+	src.Set(1, 0, blue)
+
+	tests := []struct {
+		orientation int
+		width       int
+		height      int
+		first       color.RGBA // pixel at (0, 0) after orienting
+	}{
+		{1, 2, 1, red},
+		{2, 2, 1, blue},
+		{3, 2, 1, blue},
+		{6, 1, 2, red},
+		{8, 1, 2, blue},
+	}
+
+	for _, tt := range tests {
+		dst := orient(src, tt.orientation)
+		b := dst.Bounds()
+		if b.Dx() != tt.width || b.Dy() != tt.height {
+			t.Errorf("orient(%d) size = %dx%d, want %dx%d", tt.orientation, b.Dx(), b.Dy(), tt.width, tt.height)
+			continue
+		}
+		if got := color.RGBAModel.Convert(dst.At(0, 0)); got != tt.first {
+			t.Errorf("orient(%d) first pixel = %v, want %v", tt.orientation, got, tt.first)
+		}
+	}
+}
+
+func TestDownscale(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4000, 3000))
+
+	b := downscale(src, 2048).Bounds()
+	if b.Dx() != 2048 || b.Dy() != 1536 {
+		t.Errorf("downscale size = %dx%d, want 2048x1536", b.Dx(), b.Dy())
+	}
+
+	if downscale(src, 4000) != image.Image(src) {
+		t.Errorf("downscale should not touch images within the limit")
+	}
+}
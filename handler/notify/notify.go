@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/social-torch/open311-services/awsclient"
+	"github.com/social-torch/open311-services/notification"
+	"github.com/social-torch/open311-services/repository"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+
+// Statuses important enough to warrant a text message, eg a crew being dispatched
+var criticalStatuses = map[string]bool{
+	repository.RequestInProgress: true,
+}
+
+// retryJob is queued on RETRY_QUEUE_URL when a notification fails, to be retried by this function
+type retryJob struct {
+	AccountID string             `json:"account_id"`
+	Channel   string             `json:"channel"`
+	Request   repository.Request `json:"request"`
+	NotBefore time.Time          `json:"not_before"` // When a deferred notification may be sent, zero for failed ones
+}
+
+// skipped is returned by a channel that deliberately did not send, eg over the daily SMS quota
+type skipped struct {
+	reason string
+}
+
+func (e *skipped) Error() string {
+	return e.reason
+}
+
+// deferred is returned by a channel that holds a notification back until a later time, eg the end of quiet hours
+type deferred struct {
+	until time.Time
+}
+
+func (e *deferred) Error() string {
+	return "deferred until " + e.until.UTC().Format(time.RFC3339)
+}
+
+// handler receives domain events from EventBridge and failed notifications from the retry queue
+func handler(payload json.RawMessage) error {
+	var sqsEvent events.SQSEvent
+	err := json.Unmarshal(payload, &sqsEvent)
+	if err == nil && len(sqsEvent.Records) > 0 {
+		return retry(sqsEvent)
+	}
+
+	var event events.CloudWatchEvent
+	err = json.Unmarshal(payload, &event)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling event: %s", err)
+	}
+	return handleEvent(event)
+}
+
+// handleEvent notifies the submitter of a request when its status changes, and users whose subscriptions cover
+// the request when it is created or its status changes
+func handleEvent(event events.CloudWatchEvent) error {
+	var requestEvent repository.RequestEvent
+	err := json.Unmarshal(event.Detail, &requestEvent)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling domain event detail: %s", err)
+	}
+
+	if requestEvent.Type != repository.StatusChangedEvent && requestEvent.Type != repository.RequestCreatedEvent {
+		return nil
+	}
+
+	request := requestEvent.Request
+
+	// Each user is notified once per event, however many of their subscriptions match.  Submitters already know
+	// about the request they just created.
+	notified := map[string]bool{"": true, "guest": true}
+	if requestEvent.Type == repository.StatusChangedEvent {
+		err = notifyUser(request.AccountID, request, false)
+		if err != nil {
+			return err
+		}
+	}
+	notified[request.AccountID] = true
+
+	subscriptions, err := repository.GetSubscriptions()
+	if err != nil {
+		return err
+	}
+
+	for _, subscription := range subscriptions {
+		if notified[subscription.AccountID] || !notification.Matches(subscription, request) {
+			continue
+		}
+		notified[subscription.AccountID] = true
+
+		err = notifyUser(subscription.AccountID, request, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// retry sends notifications that failed.  Returning an error leaves the message on the queue to be tried again,
+// until the queue's redrive policy moves it to the dead letter queue.
+func retry(sqsEvent events.SQSEvent) error {
+	for _, message := range sqsEvent.Records {
+		var job retryJob
+		err := json.Unmarshal([]byte(message.Body), &job)
+		if err != nil {
+			warningLogger.Printf("Discarding malformed retry message %s: %s", message.MessageId, err)
+			continue
+		}
+
+		// SQS delays messages by 15 minutes at most, so deferrals longer than that are queued again until they are due
+		if wait := time.Until(job.NotBefore); wait > 0 {
+			err = queueRetry(job, wait)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		user, err := repository.GetUser(job.AccountID)
+		if err != nil {
+			return err
+		}
+
+		// The first attempt was made before the job was queued
+		attempt := 1
+		if received, err := strconv.Atoi(message.Attributes["ApproximateReceiveCount"]); err == nil {
+			attempt += received
+		}
+
+		err = deliver(user, job.Channel, job.Request, attempt)
+		if d, ok := err.(*deferred); ok {
+			job.NotBefore = d.until
+			err = queueRetry(job, time.Until(d.until))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyUser sends the state of a request through each channel the user and the city enabled.  Users who chose a digest
+// hear about their subscriptions by email only in the digest.  Failed and deferred channels are queued for retry.
+func notifyUser(accountID string, request repository.Request, subscribed bool) error {
+	if accountID == "" || accountID == "guest" {
+		return nil
+	}
+
+	user, err := repository.GetUser(accountID)
+	if err != nil {
+		switch err.(type) {
+		case *repository.AccountIDNotFoundErr:
+			warningLogger.Printf("%s. account_id '%s' not in database", err, accountID)
+			return nil
+		default:
+			return err
+		}
+	}
+
+	config := cityOf(request).Config
+	for _, channel := range userChannels(user, request, subscribed) {
+		if !config.ChannelEnabled(channel) {
+			continue
+		}
+		err = deliver(user, channel, request, 1)
+		if err != nil {
+			job := retryJob{AccountID: accountID, Channel: channel, Request: request}
+			if d, ok := err.(*deferred); ok {
+				job.NotBefore = d.until
+			}
+			err = queueRetry(job, time.Until(job.NotBefore))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// userChannels lists the channels a user chose to hear about a request through.  Subscribers who chose a digest get
+// no email, and text messages are only sent for critical statuses.
+func userChannels(user repository.User, request repository.Request, subscribed bool) []string {
+	channels := []string{}
+	if user.Preferences.Push {
+		channels = append(channels, repository.ChannelPush)
+	}
+
+	digested := subscribed && user.Preferences.Digest != ""
+	if user.Preferences.Email && user.Preferences.EmailAddress != "" && !digested {
+		channels = append(channels, repository.ChannelEmail)
+	}
+
+	if user.Preferences.SMS && user.Preferences.PhoneNumber != "" && criticalStatuses[request.Status] {
+		channels = append(channels, repository.ChannelSMS)
+	}
+	return channels
+}
+
+// deliver sends a notification through one channel and records the attempt in the request's delivery log
+func deliver(user repository.User, channel string, request repository.Request, attempt int) error {
+	delivery := repository.NotificationDelivery{
+		ServiceRequestID: request.ServiceRequestID,
+		AccountID:        user.AccountID,
+		Channel:          channel,
+		Attempt:          attempt,
+	}
+
+	var id string
+	var err error
+	switch channel {
+	case repository.ChannelPush:
+		delivery.Recipient = strings.Join(user.PushEndpoints, ",")
+		id, err = sendPush(user, request)
+	case repository.ChannelEmail:
+		delivery.Recipient = user.Preferences.EmailAddress
+		id, err = sendEmail(user, request)
+	case repository.ChannelSMS:
+		delivery.Recipient = user.Preferences.PhoneNumber
+		id, err = sendSMS(user, request)
+	default:
+		err = fmt.Errorf("unknown notification channel '%s'", channel)
+	}
+
+	delivery.MessageID = id
+	delivery.Status = repository.DeliverySent
+	if err != nil {
+		delivery.Error = err.Error()
+		delivery.Status = repository.DeliveryFailed
+		switch err.(type) {
+		case *skipped:
+			delivery.Status = repository.DeliverySkipped
+			err = nil
+		case *deferred:
+			delivery.Status = repository.DeliveryDeferred
+		}
+	}
+
+	// The log is for troubleshooting; failing to write it must not cause the notification to be sent again
+	logErr := repository.RecordNotificationDelivery(delivery)
+	if logErr != nil {
+		warningLogger.Println(logErr)
+	}
+
+	return err
+}
+
+// Longest delay SQS holds a message back for
+const maxQueueDelay = 15 * time.Minute
+
+// queueRetry puts a failed or deferred notification on the retry queue, to be tried again after delay.  Delays
+// longer than SQS allows are cut short, and the notification is deferred again when it is tried too early.
+func queueRetry(job retryJob, delay time.Duration) error {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("unable to marshal retry of %s notification: %s", job.Channel, err)
+	}
+
+	svc := sqs.New(awsclient.Session())
+	_, err = svc.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:     aws.String(os.Getenv("RETRY_QUEUE_URL")),
+		MessageBody:  aws.String(string(body)),
+		DelaySeconds: aws.Int64(queueDelay(delay)),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to queue retry of %s notification: %s", job.Channel, err)
+	}
+
+	warningLogger.Printf("Queued retry of %s notification to %s about %s", job.Channel, job.AccountID, job.Request.ServiceRequestID)
+	return nil
+}
+
+// queueDelay returns the DelaySeconds of a message to be received after delay
+func queueDelay(delay time.Duration) int64 {
+	if delay > maxQueueDelay {
+		delay = maxQueueDelay
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	return int64(delay / time.Second)
+}
+
+// sendSMS texts a critical update, deferring it until the user's quiet hours end unless the service is an
+// emergency service, and within the city's daily quota
+func sendSMS(user repository.User, request repository.Request) (string, error) {
+	now := time.Now()
+	if notification.InQuietHours(user.Preferences, now) {
+		service, err := repository.GetService(request.ServiceCode)
+		if err != nil || !service.Emergency {
+			until := notification.QuietHoursEnd(user.Preferences, now)
+			infoLogger.Printf("Holding back SMS to %s until quiet hours end at %s", user.AccountID, until)
+			return "", &deferred{until}
+		}
+	}
+
+	city := cityOf(request)
+	allowed, err := notification.ReserveSMS(city, now)
+	if err != nil {
+		return "", err
+	}
+	if !allowed {
+		warningLogger.Printf("Daily SMS quota spent; not texting %s about %s", user.AccountID, request.ServiceRequestID)
+		return "", &skipped{"daily SMS quota spent"}
+	}
+
+	fallback := fmt.Sprintf("%s request %s: %s", request.ServiceName, request.ServiceRequestID, request.Status)
+	if request.StatusNotes != "" {
+		fallback += ". " + request.StatusNotes
+	}
+	message, err := notification.ShortMessage(city, user.Preferences.Language, notification.StatusChangedTemplate, statusData(request), fallback)
+	if err != nil {
+		return "", err
+	}
+
+	id, err := notification.SendSMS(user.Preferences.PhoneNumber, message)
+	if err != nil {
+		return "", err
+	}
+
+	infoLogger.Printf("Texted %s about request %s (message %s)", user.AccountID, request.ServiceRequestID, id)
+	return id, nil
+}
+
+// sendEmail emails the new status of a request, with a link to unsubscribe from email notifications
+func sendEmail(user repository.User, request repository.Request) (string, error) {
+	unsubscribeURL := fmt.Sprintf("%s/user/%s/unsubscribe?token=%s",
+		os.Getenv("API_URL"), url.PathEscape(user.AccountID), notification.UnsubscribeToken(user.AccountID))
+
+	data := statusData(request)
+	data["unsubscribe_url"] = unsubscribeURL
+
+	id, err := notification.SendCityEmail(cityOf(request), user.Preferences.Language, user.Preferences.EmailAddress, notification.StatusChangedTemplate, data)
+	if err != nil {
+		return "", err
+	}
+
+	infoLogger.Printf("Emailed %s about request %s (message %s)", user.AccountID, request.ServiceRequestID, id)
+	return id, nil
+}
+
+// sendPush publishes the new status of a request to every device the user registered, returning the SNS
+// message IDs
+func sendPush(user repository.User, request repository.Request) (string, error) {
+	fallback := fmt.Sprintf("%s request %s is now %s", request.ServiceName, request.ServiceRequestID, request.Status)
+	if request.StatusNotes != "" {
+		fallback += ": " + request.StatusNotes
+	}
+	message, err := notification.ShortMessage(cityOf(request), user.Preferences.Language, notification.StatusChangedTemplate, statusData(request), fallback)
+	if err != nil {
+		return "", err
+	}
+
+	ids := []string{}
+	svc := sns.New(awsclient.Session())
+	for _, endpoint := range user.PushEndpoints {
+		result, err := svc.Publish(&sns.PublishInput{
+			TargetArn: aws.String(endpoint),
+			Message:   aws.String(message),
+		})
+		if err != nil {
+			// Devices that uninstalled the app are disabled by SNS; they should not fail delivery to the others
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == sns.ErrCodeEndpointDisabledException {
+				warningLogger.Printf("Push endpoint %s of %s is disabled", endpoint, user.AccountID)
+				continue
+			}
+			return strings.Join(ids, ","), fmt.Errorf("unable to publish push notification to %s: %s", endpoint, err)
+		}
+		ids = append(ids, aws.StringValue(result.MessageId))
+	}
+
+	infoLogger.Printf("Notified %s of request %s status %s", user.AccountID, request.ServiceRequestID, request.Status)
+	return strings.Join(ids, ","), nil
+}
+
+// statusData is the data status change notification copy is rendered with
+func statusData(request repository.Request) map[string]string {
+	return map[string]string{
+		"service_request_id": request.ServiceRequestID,
+		"service_name":       request.ServiceName,
+		"status":             request.Status,
+		"status_notes":       request.StatusNotes,
+		"address":            request.Address,
+	}
+}
+
+// cityOf returns the city whose copy, sender and quotas apply to notifications about a request.  Requests made
+// to no city in particular, or whose city can't be looked up, use the platform defaults.
+func cityOf(request repository.Request) repository.City {
+	if request.CityID == "" {
+		return repository.City{}
+	}
+
+	city, err := repository.GetCity(request.CityID)
+	if err != nil {
+		warningLogger.Printf("Unable to get city %s of request %s, using platform defaults: %s", request.CityID, request.ServiceRequestID, err)
+		return repository.City{}
+	}
+	return city
+}
+
+func main() {
+	lambda.Start(handler)
+}
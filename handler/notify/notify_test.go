@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestUserChannels(t *testing.T) {
+	all := repository.NotificationPreferences{Push: true, Email: true, EmailAddress: "a@example.com", SMS: true, PhoneNumber: "+15185550100"}
+	digest := all
+	digest.Digest = repository.DigestDaily
+	noAddress := repository.NotificationPreferences{Email: true, SMS: true}
+
+	open := repository.Request{Status: repository.RequestOpen}
+	inProgress := repository.Request{Status: repository.RequestInProgress}
+
+	tests := []struct {
+		name        string
+		preferences repository.NotificationPreferences
+		request     repository.Request
+		subscribed  bool
+		want        []string
+	}{
+		{"none", repository.NotificationPreferences{}, inProgress, false, []string{}},
+		{"critical", all, inProgress, false, []string{repository.ChannelPush, repository.ChannelEmail, repository.ChannelSMS}},
+		{"not critical", all, open, false, []string{repository.ChannelPush, repository.ChannelEmail}},
+		{"digest submitter", digest, open, false, []string{repository.ChannelPush, repository.ChannelEmail}},
+		{"digest subscriber", digest, inProgress, true, []string{repository.ChannelPush, repository.ChannelSMS}},
+		{"no address", noAddress, inProgress, false, []string{}},
+	}
+
+	for _, test := range tests {
+		user := repository.User{AccountID: "a", Preferences: test.preferences}
+		if got := userChannels(user, test.request, test.subscribed); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("userChannels(%s) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestHandlerIgnores(t *testing.T) {
+	detail, _ := json.Marshal(repository.RequestEvent{Type: repository.MediaAddedEvent, Request: repository.Request{AccountID: "a"}})
+	event, _ := json.Marshal(events.CloudWatchEvent{Detail: detail})
+	if err := handler(event); err != nil {
+		t.Errorf("handler(%s) = %v, want nil", repository.MediaAddedEvent, err)
+	}
+
+	retries, _ := json.Marshal(events.SQSEvent{Records: []events.SQSMessage{{MessageId: "1", Body: "{"}}})
+	if err := handler(retries); err != nil {
+		t.Errorf("handler(malformed retry) = %v, want nil", err)
+	}
+
+	if err := handler(json.RawMessage(`[]`)); err == nil {
+		t.Errorf("handler([]) = nil, want error")
+	}
+}
+
+func TestStatusData(t *testing.T) {
+	request := repository.Request{ServiceRequestID: "42", ServiceName: "Pothole", Status: repository.RequestClosed, StatusNotes: "Filled", Address: "1 State St"}
+	want := map[string]string{
+		"service_request_id": "42",
+		"service_name":       "Pothole",
+		"status":             repository.RequestClosed,
+		"status_notes":       "Filled",
+		"address":            "1 State St",
+	}
+	if got := statusData(request); !reflect.DeepEqual(got, want) {
+		t.Errorf("statusData() = %v, want %v", got, want)
+	}
+
+	if got := cityOf(repository.Request{}); !reflect.DeepEqual(got, repository.City{}) {
+		t.Errorf("cityOf(no city) = %v, want platform defaults", got)
+	}
+}
+
+func TestQueueDelay(t *testing.T) {
+	tests := map[time.Duration]int64{
+		-time.Hour:       0, // failed notifications, retried at once
+		90 * time.Second: 90,
+		8 * time.Hour:    900, // the end of quiet hours, beyond what SQS delays
+	}
+	for delay, want := range tests {
+		if got := queueDelay(delay); got != want {
+			t.Errorf("queueDelay(%s) = %d, want %d", delay, got, want)
+		}
+	}
+}
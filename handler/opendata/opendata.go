@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/social-torch/open311-services/awsclient"
+	"github.com/social-torch/open311-services/geo"
+	"github.com/social-torch/open311-services/portal"
+	"github.com/social-torch/open311-services/repository"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+
+// openDataPrefix is the publicly readable prefix of the open-data bucket.  Each city's snapshot is under
+// open-data/<city_name>/.
+const openDataPrefix = "open-data/"
+
+// handler runs nightly, publishing a snapshot of the requests of every city that has opted in to open data as CSV
+// and GeoJSON, so open-data portals and researchers can download them without going through the API, and
+// upserting them to the datasets of cities' portals.  Domain events of requests are upserted to the portals of
+// cities that publish live.
+func handler(event events.CloudWatchEvent) error {
+	if event.Source == repository.EventSource {
+		return publishLive(event.Detail)
+	}
+
+	bucket := os.Getenv("OPEN_DATA_BUCKET")
+
+	cities, err := repository.GetCities()
+	if err != nil {
+		return err
+	}
+
+	svc := s3.New(awsclient.Session())
+	failed := 0
+
+	for _, city := range cities {
+		// Federated cities' requests are held by their own servers, and cities pinned to another region are
+		// published by the stack there
+		settings := city.Config.OpenData
+		if (!settings.Enabled && settings.Portal.Platform == "") || city.Federated || city.DataRegion() != repository.DataRegion() {
+			continue
+		}
+
+		requests, err := repository.GetRequests(city.CityName)
+		if err == nil && settings.Enabled {
+			err = publish(svc, bucket, city, requests)
+		}
+		if err == nil && settings.Portal.Platform != "" {
+			err = portal.New(city).Upsert(portalRows(requests, publishedFields(settings.RedactFields), settings.Portal))
+			if err == nil {
+				infoLogger.Printf("Upserted %d requests of %s to %s", len(requests), city.CityName, settings.Portal.URL)
+			}
+		}
+		if err != nil {
+			// One city's failure should not hold back everyone else's snapshot
+			warningLogger.Printf("Unable to publish open data of %s: %s", city.CityName, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("open data of %d cities not published", failed)
+	}
+	return nil
+}
+
+// publishLive upserts the request of a domain event to its city's portal, when the city publishes live.  A
+// request missed here is published by the next nightly run.
+func publishLive(detail json.RawMessage) error {
+	var requestEvent repository.RequestEvent
+	err := json.Unmarshal(detail, &requestEvent)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling domain event detail: %s", err)
+	}
+
+	city, err := repository.GetCity(requestEvent.Request.CityID)
+	if err != nil {
+		switch err.(type) {
+		case *repository.CityNotFoundErr:
+			return nil
+		default:
+			return err
+		}
+	}
+
+	settings := city.Config.OpenData
+	if settings.Portal.Platform == "" || !settings.Portal.Live || city.Federated {
+		return nil
+	}
+	rows := portalRows([]repository.Request{requestEvent.Request}, publishedFields(settings.RedactFields), settings.Portal)
+	return portal.New(city).Upsert(rows)
+}
+
+// publish writes the snapshot of a city's requests to the open-data bucket
+func publish(svc *s3.S3, bucket string, city repository.City, requests []repository.Request) error {
+	fields := publishedFields(city.Config.OpenData.RedactFields)
+
+	csvBody, err := snapshotCSV(requests, fields)
+	if err != nil {
+		return err
+	}
+	geoJSONBody, err := json.Marshal(snapshotGeoJSON(requests, fields))
+	if err != nil {
+		return fmt.Errorf("error marshalling snapshot as GeoJSON: %s", err)
+	}
+
+	prefix := openDataPrefix + city.CityName + "/"
+	if err := putObject(svc, bucket, prefix+"requests.csv", "text/csv", csvBody); err != nil {
+		return err
+	}
+	if err := putObject(svc, bucket, prefix+"requests.geojson", "application/geo+json", geoJSONBody); err != nil {
+		return err
+	}
+
+	infoLogger.Printf("Published %d requests of %s", len(requests), city.CityName)
+	return nil
+}
+
+// putObject replaces an object of the open-data bucket
+func putObject(svc *s3.S3, bucket string, key string, contentType string, body []byte) error {
+	_, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		Body:         bytes.NewReader(body),
+		ContentType:  aws.String(contentType),
+		CacheControl: aws.String("max-age=3600"),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to write '%s': %s", key, err)
+	}
+	return nil
+}
+
+// publishedFields returns the OpenDataFields a city hasn't redacted
+func publishedFields(redact []string) []string {
+	redacted := map[string]bool{}
+	for _, field := range redact {
+		redacted[field] = true
+	}
+
+	fields := []string{}
+	for _, field := range repository.OpenDataFields {
+		if !redacted[field] {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// snapshotCSV returns requests as CSV with a header row.  The location field is written as lat and lon columns,
+// empty for requests without a location.
+func snapshotCSV(requests []repository.Request, fields []string) ([]byte, error) {
+	header := []string{}
+	for _, field := range fields {
+		if field == "location" {
+			header = append(header, "lat", "lon")
+		} else {
+			header = append(header, field)
+		}
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(header)
+
+	for _, request := range requests {
+		row := []string{}
+		for _, field := range fields {
+			if field != "location" {
+				row = append(row, cell(fieldValue(request, field)))
+				continue
+			}
+			if !request.HasLocation() {
+				row = append(row, "", "")
+				continue
+			}
+			lat, lon := request.Coordinates()
+			row = append(row, strconv.FormatFloat(lat, 'f', -1, 64), strconv.FormatFloat(lon, 'f', -1, 64))
+		}
+		w.Write(row)
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("error writing snapshot as CSV: %s", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// snapshotGeoJSON returns requests as a FeatureCollection.  Requests are located by their geometry or point unless
+// the location field is redacted, when every feature's geometry is null.
+func snapshotGeoJSON(requests []repository.Request, fields []string) geo.FeatureCollection {
+	features := []geo.Feature{}
+	for _, request := range requests {
+		properties := map[string]interface{}{}
+		located := false
+		for _, field := range fields {
+			if field == "location" {
+				located = true
+				continue
+			}
+			properties[field] = fieldValue(request, field)
+		}
+
+		switch {
+		case located && request.Geometry != nil:
+			features = append(features, geo.Feature{Type: "Feature", Geometry: request.Geometry, Properties: properties})
+		case located && request.HasLocation():
+			lat, lon := request.Coordinates()
+			features = append(features, geo.NewFeature(lat, lon, properties))
+		default:
+			features = append(features, geo.Feature{Type: "Feature", Properties: properties})
+		}
+	}
+	return geo.NewFeatureCollection(features)
+}
+
+// portalRows returns requests as rows of a portal's dataset, in the columns of the snapshot CSV renamed by the
+// portal's columns.  Requests without a location have null lat and lon.
+func portalRows(requests []repository.Request, fields []string, settings repository.OpenDataPortal) []portal.Row {
+	rows := []portal.Row{}
+	for _, request := range requests {
+		row := portal.Row{}
+		for _, field := range fields {
+			if field != "location" {
+				row[settings.Column(field)] = fieldValue(request, field)
+				continue
+			}
+			row[settings.Column("lat")], row[settings.Column("lon")] = nil, nil
+			if request.HasLocation() {
+				row[settings.Column("lat")], row[settings.Column("lon")] = request.Coordinates()
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// fieldValue returns the value of one of the OpenDataFields of a request, other than location.  Unknown zip codes
+// and resolution times of open requests are nil.
+func fieldValue(request repository.Request, field string) interface{} {
+	switch field {
+	case "service_request_id":
+		return request.ServiceRequestID
+	case "status":
+		return request.Status
+	case "service_code":
+		return request.ServiceCode
+	case "service_name":
+		return request.ServiceName
+	case "agency_responsible":
+		return request.AgencyResponsible
+	case "requested_datetime":
+		return request.RequestedDateTime
+	case "update_datetime":
+		return request.UpdatedDateTime
+	case "expected_datetime":
+		return request.ExpectedDateTime
+	case "closed_datetime":
+		return request.ClosedDateTime
+	case "resolution_hours":
+		if request.ResolutionHours == 0 {
+			return nil
+		}
+		return request.ResolutionHours
+	case "address":
+		return request.Address
+	case "zipcode":
+		if request.ZipCode == "" {
+			return nil
+		}
+		return string(request.ZipCode)
+	}
+	return nil
+}
+
+// cell formats a field value as a CSV cell, nil as empty
+func cell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestPublishedFields(t *testing.T) {
+	fields := publishedFields(nil)
+	if !reflect.DeepEqual(fields, repository.OpenDataFields) {
+		t.Errorf("publishedFields(nil) = %v, want every field", fields)
+	}
+
+	fields = publishedFields([]string{"address", "location"})
+	for _, field := range fields {
+		if field == "address" || field == "location" {
+			t.Errorf("publishedFields() kept redacted field %s", field)
+		}
+	}
+	if len(fields) != len(repository.OpenDataFields)-2 {
+		t.Errorf("publishedFields() = %v, want all but address and location", fields)
+	}
+}
+
+func snapshotRequests() []repository.Request {
+	return []repository.Request{
+		{
+			ServiceRequestID:  "r1",
+			Status:            repository.RequestClosed,
+			ServiceCode:       "troy-pothole",
+			Description:       "Outside my house at 12 Elm St, call Jane on 555-0100",
+			Address:           "12 Elm St",
+			ZipCode:           "12180",
+			Location:          repository.Location{Latitude: 42.7284, Longitude: -73.6918},
+			AccountID:         "acct-1",
+			MediaURL:          "https://media.example.com/r1.jpg",
+			StatusNotes:       "Spoke to Jane",
+			ResolutionHours:   26.5,
+			RequestedDateTime: "2020-03-01T09:00:00Z",
+		},
+		{
+			ServiceRequestID: "r2",
+			Status:           repository.RequestOpen,
+			ServiceCode:      "troy-graffiti",
+			AuditLog:         []repository.AuditEntry{{AccountID: "acct-2"}},
+		},
+	}
+}
+
+func TestSnapshotCSV(t *testing.T) {
+	body, err := snapshotCSV(snapshotRequests(), publishedFields([]string{"address"}))
+	if err != nil {
+		t.Fatalf("snapshotCSV() error = %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("snapshotCSV() wrote %d lines, want a header and 2 rows:\n%s", len(lines), body)
+	}
+
+	wantHeader := "service_request_id,status,service_code,service_name,agency_responsible,requested_datetime," +
+		"update_datetime,expected_datetime,closed_datetime,resolution_hours,zipcode,lat,lon"
+	if lines[0] != wantHeader {
+		t.Errorf("header = %s, want %s", lines[0], wantHeader)
+	}
+	if want := "r1,closed,troy-pothole,,,2020-03-01T09:00:00Z,,,,26.5,12180,42.7284,-73.6918"; lines[1] != want {
+		t.Errorf("row = %s, want %s", lines[1], want)
+	}
+	if want := "r2,open,troy-graffiti,,,,,,,,,,"; lines[2] != want {
+		t.Errorf("row = %s, want %s", lines[2], want)
+	}
+
+	for _, pii := range []string{"Elm", "Jane", "acct-", "media.example.com"} {
+		if strings.Contains(string(body), pii) {
+			t.Errorf("snapshotCSV() published %q", pii)
+		}
+	}
+}
+
+func TestSnapshotGeoJSON(t *testing.T) {
+	collection := snapshotGeoJSON(snapshotRequests(), publishedFields(nil))
+	if len(collection.Features) != 2 {
+		t.Fatalf("snapshotGeoJSON() has %d features, want 2", len(collection.Features))
+	}
+	if collection.Features[0].Geometry == nil || collection.Features[1].Geometry != nil {
+		t.Errorf("geometries = %v, %v, want a point and null", collection.Features[0].Geometry, collection.Features[1].Geometry)
+	}
+	if _, ok := collection.Features[0].Properties["location"]; ok {
+		t.Error("location published as a property")
+	}
+
+	body, _ := json.Marshal(collection)
+	for _, pii := range []string{"Jane", "acct-", "media.example.com", "audit_log", "description"} {
+		if strings.Contains(string(body), pii) {
+			t.Errorf("snapshotGeoJSON() published %q", pii)
+		}
+	}
+
+	collection = snapshotGeoJSON(snapshotRequests(), publishedFields([]string{"location"}))
+	if collection.Features[0].Geometry != nil {
+		t.Errorf("geometry = %v with location redacted, want null", collection.Features[0].Geometry)
+	}
+}
+
+func TestPortalRows(t *testing.T) {
+	settings := repository.OpenDataPortal{Columns: map[string]string{"service_request_id": "id", "lat": "latitude"}}
+	rows := portalRows(snapshotRequests(), publishedFields([]string{"address"}), settings)
+	if len(rows) != 2 {
+		t.Fatalf("portalRows() = %d rows, want 2", len(rows))
+	}
+
+	if rows[0]["id"] != "r1" || rows[0]["latitude"] != 42.7284 || rows[0]["lon"] != -73.6918 || rows[0]["zipcode"] != "12180" {
+		t.Errorf("row = %v, want r1 in its renamed columns", rows[0])
+	}
+	if _, ok := rows[1]["latitude"]; !ok || rows[1]["latitude"] != nil {
+		t.Errorf("row = %v, want a null latitude for a request without a location", rows[1])
+	}
+	for _, row := range rows {
+		for _, column := range []string{"service_request_id", "lat", "address", "location", "description", "account_id"} {
+			if _, ok := row[column]; ok {
+				t.Errorf("row = %v, shouldn't have column %s", row, column)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/federation"
+	"github.com/social-torch/open311-services/repository"
+)
+
+// federatedCity returns the city a call is scoped to when that city serves its requests from its own GeoReport v2
+// server.  Calls to any other city, or to no city in particular, return an empty City.
+func federatedCity(req events.APIGatewayProxyRequest) (repository.City, error) {
+	city, err := servingCity(cityID(req))
+	if err != nil {
+		if _, ok := err.(*repository.CityNotFoundErr); ok {
+			return repository.City{}, nil
+		}
+		return repository.City{}, err
+	}
+	if !city.Federated {
+		return repository.City{}, nil
+	}
+	return city, nil
+}
+
+// proxyGet answers a GET for a federated city from the city's server.  Only the calls GeoReport v2 defines can be
+// forwarded; searches by area, clusters, pins, media and notification deliveries need the platform's own database.
+func proxyGet(req events.APIGatewayProxyRequest, city repository.City) (events.APIGatewayProxyResponse, error) {
+	client := federation.New(city)
+
+	if req.Resource == "/request/{id}" {
+		request, err := client.Request(req.PathParameters["id"])
+		if err != nil {
+			return proxyError(err)
+		}
+
+		body, err := json.Marshal(&request)
+		if err != nil {
+			return serverError(http.StatusInternalServerError, errors.New("error marshalling federated Request() struct"))
+		}
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusOK,
+			Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+			Body:       string(body),
+		}, nil
+	}
+
+	_, bbox := req.QueryStringParameters["bbox"]
+	_, zipCode := req.QueryStringParameters["zipcode"]
+	if req.Resource == "/requests" && !bbox && !zipCode {
+		requests, err := client.Requests(req.QueryStringParameters)
+		if err != nil {
+			return proxyError(err)
+		}
+		return listing(req, requests, map[string]string{})
+	}
+
+	return clientError(http.StatusNotImplemented, fmt.Errorf("%s serves its requests from its own Open311 server, which only supports listing requests by %v", city.CityName, federation.RequestFilters))
+}
+
+// submitFederated forwards a new request to the GeoReport v2 server of a federated city.  The city's server
+// validates the request and keeps it, so it isn't tracked in the platform's database.  Servers that queue requests
+// answer with a token rather than an ID, and the request is only accepted until they make it.
+func submitFederated(req events.APIGatewayProxyRequest, city repository.City, request repository.Request) (events.APIGatewayProxyResponse, error) {
+	if request.ServiceRequestID != "" {
+		return clientError(http.StatusNotImplemented, fmt.Errorf("requests to %s are updated by the city's own Open311 server", city.CityName))
+	}
+
+	response, err := federation.New(city).Submit(request, city.FederationAPIKey)
+	if err != nil {
+		return proxyError(err)
+	}
+	infoLogger.Printf("New request forwarded to %s: %s", city.CityName, response.ServiceRequestID)
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("unable to marshal JSON for request response"))
+	}
+
+	statusCode := http.StatusAccepted
+	headers := map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"}
+	if response.ServiceRequestID != "" {
+		statusCode = http.StatusCreated
+		headers["Location"] = requestURL(req, response.ServiceRequestID)
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Headers:    headers,
+		Body:       string(body),
+	}, nil
+}
+
+// proxyError passes on the errors a city's server blames on the caller, and reports any other failure of the
+// server as a bad gateway
+func proxyError(err error) (events.APIGatewayProxyResponse, error) {
+	if remote, ok := err.(*federation.RemoteErr); ok {
+		return clientError(remote.StatusCode, remote)
+	}
+	return serverError(http.StatusBadGateway, err)
+}
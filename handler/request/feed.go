@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/federation"
+	"github.com/social-torch/open311-services/repository"
+)
+
+// atomContentType is the content type of GET /requests/feed.atom
+const atomContentType = "application/atom+xml"
+
+// feedWindow is how far back a feed reaches, and feedSize the most entries it holds
+const (
+	feedWindow = 7 * 24 * time.Hour
+	feedSize   = 100
+)
+
+// atomFeed is an Atom feed, RFC 4287, of requests.  Entries are located with GeoRSS Simple.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	GeoRSS  string      `xml:"xmlns:georss,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID        string    `xml:"id"`
+	Title     string    `xml:"title"`
+	Published string    `xml:"published"`
+	Updated   string    `xml:"updated"`
+	Link      atomLink  `xml:"link"`
+	Category  *atomTerm `xml:"category"`
+	Summary   string    `xml:"summary"`
+	Point     string    `xml:"georss:point,omitempty"`
+}
+
+type atomTerm struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr,omitempty"`
+}
+
+// getRequestsFeed serves the requests made to a city over the last week, newest first, as an Atom feed, so
+// newsrooms and civic hackers can follow them in a feed reader.  Feeds are filtered by service_code and
+// neighborhood.  Feed readers can't sign in, so entries carry only the fields the city publishes as open data.
+func getRequestsFeed(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	cityName := cityID(req)
+	if cityName == "" {
+		return clientError(http.StatusBadRequest, errors.New("city_id must be specified, since feeds are of a city's requests"))
+	}
+
+	city, err := repository.GetCity(cityName)
+	if err != nil {
+		switch err.(type) {
+		case *repository.CityNotFoundErr:
+			errorMessage := fmt.Errorf("%s.  city_id '%s' not in database", err, cityName)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	serviceCode := req.QueryStringParameters["service_code"]
+	neighborhood := req.QueryStringParameters["neighborhood"]
+	if neighborhood != "" && neighborhoodName(city, neighborhood) == "" {
+		return clientError(http.StatusNotFound, fmt.Errorf("neighborhood '%s' not in %s", neighborhood, city.CityName))
+	}
+
+	end := time.Now()
+	start := end.Add(-feedWindow)
+	var requests []repository.Request
+	if city.Federated {
+		if neighborhood != "" {
+			return clientError(http.StatusNotImplemented, fmt.Errorf("%s serves its requests from its own Open311 server, which doesn't tag them with neighborhoods", city.CityName))
+		}
+		requests, err = federation.New(city).Requests(map[string]string{
+			"service_code": serviceCode,
+			"start_date":   start.UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			return proxyError(err)
+		}
+	} else {
+		requests, err = repository.GetRequestsBetween(city.CityName, start, end)
+		if err != nil {
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	feed := requestsFeed(city, feedRequests(requests, serviceCode, neighborhood), apiBase(req), end)
+	feed.Title = feedTitle(city, requests, serviceCode, neighborhood)
+	feed.Links = []atomLink{{Rel: "self", Href: apiBase(req) + req.Path + feedQuery(req)}}
+
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling requests as an Atom feed"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers: map[string]string{
+			"content-type":                atomContentType,
+			"Access-Control-Allow-Origin": "*",
+			"Cache-Control":               "max-age=300",
+		},
+		Body: xml.Header + string(body),
+	}, nil
+}
+
+// feedRequests returns the requests of a service and neighborhood, either of which may be "" for all, newest
+// first and at most feedSize of them
+func feedRequests(requests []repository.Request, serviceCode string, neighborhood string) []repository.Request {
+	matching := []repository.Request{}
+	for _, request := range requests {
+		if (serviceCode == "" || request.ServiceCode == serviceCode) && (neighborhood == "" || request.Neighborhood == neighborhood) {
+			matching = append(matching, request)
+		}
+	}
+
+	sort.SliceStable(matching, func(i, j int) bool {
+		return matching[i].RequestedDateTime > matching[j].RequestedDateTime
+	})
+	if len(matching) > feedSize {
+		matching = matching[:feedSize]
+	}
+	return matching
+}
+
+// requestsFeed returns requests as an Atom feed, leaving out the address and location of cities that redact them
+// from open data.  Entries link to the request in the API at base.
+func requestsFeed(city repository.City, requests []repository.Request, base string, now time.Time) atomFeed {
+	redacted := map[string]bool{}
+	for _, field := range city.Config.OpenData.RedactFields {
+		redacted[field] = true
+	}
+
+	feed := atomFeed{
+		GeoRSS:  "http://www.georss.org/georss",
+		ID:      "urn:open311:feed:" + city.CityName,
+		Updated: now.UTC().Format(time.RFC3339),
+		Author:  atomAuthor{Name: city.CityName},
+		Entries: []atomEntry{},
+	}
+
+	latest := ""
+	for _, request := range requests {
+		updated := request.UpdatedDateTime
+		if updated == "" {
+			updated = request.RequestedDateTime
+		}
+		if updated > latest {
+			latest = updated
+		}
+
+		title := request.ServiceName
+		if title == "" {
+			title = request.ServiceCode
+		}
+		if request.Address != "" && !redacted["address"] {
+			title += " at " + request.Address
+		}
+
+		summary := fmt.Sprintf("Status: %s", request.Status)
+		if request.AgencyResponsible != "" && !redacted["agency_responsible"] {
+			summary += fmt.Sprintf(". Assigned to %s", request.AgencyResponsible)
+		}
+		if request.ClosedDateTime != "" && !redacted["closed_datetime"] {
+			summary += fmt.Sprintf(". Closed %s", request.ClosedDateTime)
+		}
+
+		entry := atomEntry{
+			ID:        "urn:open311:request:" + request.ServiceRequestID,
+			Title:     title,
+			Published: request.RequestedDateTime,
+			Updated:   updated,
+			Link:      atomLink{Rel: "alternate", Href: base + "/request/" + url.PathEscape(request.ServiceRequestID)},
+			Summary:   summary,
+		}
+		if request.ServiceCode != "" {
+			entry.Category = &atomTerm{Term: request.ServiceCode, Label: request.ServiceName}
+		}
+		if request.HasLocation() && !redacted["location"] {
+			lat, lon := request.Coordinates()
+			entry.Point = strconv.FormatFloat(lat, 'f', -1, 64) + " " + strconv.FormatFloat(lon, 'f', -1, 64)
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	if latest != "" {
+		feed.Updated = latest
+	}
+	return feed
+}
+
+// feedTitle names a feed after its city and the service and neighborhood it is filtered by
+func feedTitle(city repository.City, requests []repository.Request, serviceCode string, neighborhood string) string {
+	title := city.CityName + " 311 requests"
+	if serviceCode != "" {
+		name := serviceCode
+		for _, request := range requests {
+			if request.ServiceCode == serviceCode && request.ServiceName != "" {
+				name = request.ServiceName
+				break
+			}
+		}
+		title += ": " + name
+	}
+	if neighborhood != "" {
+		title += " in " + neighborhoodName(city, neighborhood)
+	}
+	return title
+}
+
+// neighborhoodName returns the name of a neighborhood of a city, or "" when the city has no such neighborhood
+func neighborhoodName(city repository.City, id string) string {
+	for _, n := range city.Neighborhoods {
+		if n.ID == id {
+			if n.Name == "" {
+				return n.ID
+			}
+			return n.Name
+		}
+	}
+	return ""
+}
+
+// apiBase returns the URL of the API a call was made to, eg "https://abc123.execute-api.us-east-1.amazonaws.com/Prod"
+func apiBase(req events.APIGatewayProxyRequest) string {
+	return "https://" + req.Headers["Host"] + "/" + req.RequestContext.Stage
+}
+
+// feedQuery returns the query string of a feed's own URL
+func feedQuery(req events.APIGatewayProxyRequest) string {
+	query := url.Values{}
+	for name, value := range req.QueryStringParameters {
+		query.Set(name, value)
+	}
+	if len(query) == 0 {
+		return ""
+	}
+	return "?" + query.Encode()
+}
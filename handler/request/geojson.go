@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/geo"
+	"github.com/social-torch/open311-services/repository"
+	"github.com/social-torch/open311-services/wire"
+)
+
+// Content types of request listings, chosen with the format query parameter
+const (
+	jsonContentType    = "application/json"
+	geoJSONContentType = "application/geo+json"
+)
+
+// listingBody marshals a listing of requests in the format the caller asked for: a JSON array of their summaries by
+// default, a GeoJSON FeatureCollection of them for format=geojson, or a base64 encoded protobuf RequestList when no
+// format is given and the Accept header prefers application/x-protobuf.  The fields query parameter selects the fields
+// of each entry, or the properties of each feature.  It returns the body and its content type.
+func listingBody(req events.APIGatewayProxyRequest, requests []repository.Request) (string, string, error) {
+	w, err := newListingWriter(req, 0)
+	if err != nil {
+		return "", "", err
+	}
+	for _, request := range requests {
+		if _, err := w.add(request); err != nil {
+			return "", "", err
+		}
+	}
+	body, contentType := w.close()
+	return body, contentType, nil
+}
+
+// listingWriter marshals a listing of requests a request at a time, as listingBody does, so that a listing read a
+// page at a time is never held whole
+type listingWriter struct {
+	contentType string
+	fields      []string // Fields of each entry, all of them when empty
+	body        bytes.Buffer
+	count       int // Requests written
+	budget      int // Most bytes of body, once encoded. Zero for no limit
+}
+
+// newListingWriter returns a writer of a listing in the format the caller asked for, of at most budget bytes
+func newListingWriter(req events.APIGatewayProxyRequest, budget int) (*listingWriter, error) {
+	fields, err := listingFields(req)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &listingWriter{fields: fields, budget: budget}
+	switch format := req.QueryStringParameters["format"]; format {
+	case "", "json":
+		w.contentType = jsonContentType
+		if format == "" && wire.Protobuf(wire.Accept(req.Headers)) {
+			w.contentType = wire.ContentTypeProtobuf
+		}
+	case "geojson":
+		w.contentType = geoJSONContentType
+	default:
+		return nil, &formatErr{fmt.Sprintf("unknown format '%s'. Use json or geojson", format)}
+	}
+	return w, nil
+}
+
+// listingFields returns the fields of a listing the fields query parameter selects, from those of a request summary.
+// It is empty when the parameter isn't given, for every field.
+func listingFields(req events.APIGatewayProxyRequest) ([]string, error) {
+	fields, err := repository.ParseFields(req.QueryStringParameters["fields"], repository.SummaryAttributes)
+	if err != nil {
+		return nil, &formatErr{err.Error()}
+	}
+	return fields, nil
+}
+
+// attributes returns the attributes to read of the requests listed, so that unselected fields aren't read
+func (w *listingWriter) attributes() []string {
+	return repository.ListingAttributes(w.fields)
+}
+
+// add writes a request to the listing.  It returns false, writing nothing, when the request would take the listing
+// over its budget; the first request is always written.
+func (w *listingWriter) add(request repository.Request) (bool, error) {
+	var entry []byte
+	var err error
+	switch w.contentType {
+	case wire.ContentTypeProtobuf:
+		entry, err = wire.RequestEntry(request)
+		if err != nil {
+			return false, errors.New("error marshalling requests as protobuf")
+		}
+	case geoJSONContentType:
+		feature := requestFeature(request.Summary())
+		feature.Properties = selectProperties(feature.Properties, w.fields)
+		entry, err = json.Marshal(feature)
+		if err != nil {
+			return false, errors.New("error marshalling requests as GeoJSON")
+		}
+	default:
+		entry, err = json.Marshal(request.Summary())
+		if err == nil && len(w.fields) > 0 {
+			entry, err = selectFields(entry, w.fields)
+		}
+		if err != nil {
+			return false, errors.New("error marshalling requests")
+		}
+	}
+
+	if w.budget > 0 && w.count > 0 && w.size(len(entry)) > w.budget {
+		return false, nil
+	}
+	if w.count > 0 && w.contentType != wire.ContentTypeProtobuf {
+		w.body.WriteByte(',')
+	}
+	w.body.Write(entry)
+	w.count++
+	return true, nil
+}
+
+// size returns how many bytes the listing would take once closed, with another entry of n bytes
+func (w *listingWriter) size(n int) int {
+	if w.contentType == wire.ContentTypeProtobuf {
+		return base64.StdEncoding.EncodedLen(w.body.Len() + n)
+	}
+	if w.contentType == geoJSONContentType {
+		return len(geoJSONPrefix) + w.body.Len() + 1 + n + len(geoJSONSuffix)
+	}
+	return len("[") + w.body.Len() + 1 + n + len("]")
+}
+
+// JSON around the features of a GeoJSON listing, as geo.FeatureCollection marshals
+const (
+	geoJSONPrefix = `{"type":"FeatureCollection","features":[`
+	geoJSONSuffix = `]}`
+)
+
+// close returns the body of the listing and its content type
+func (w *listingWriter) close() (string, string) {
+	switch w.contentType {
+	case wire.ContentTypeProtobuf:
+		return base64.StdEncoding.EncodeToString(w.body.Bytes()), w.contentType
+	case geoJSONContentType:
+		return geoJSONPrefix + w.body.String() + geoJSONSuffix, w.contentType
+	default:
+		return "[" + w.body.String() + "]", w.contentType
+	}
+}
+
+// formatErr is returned by listingBody for formats or fields it doesn't produce
+type formatErr struct {
+	message string
+}
+
+func (e *formatErr) Error() string {
+	return e.message
+}
+
+// requestFeature returns the summary of a request as a GeoJSON Feature.  Requests with a geometry are drawn as their
+// line or polygon, with their point in the lat and lon properties.  Properties are kept flat so GIS tools show them as
+// attribute columns.
+func requestFeature(request repository.RequestSummary) geo.Feature {
+	properties := map[string]interface{}{
+		"service_request_id": request.ServiceRequestID,
+		"status":             request.Status,
+		"service_name":       request.ServiceName,
+		"service_code":       request.ServiceCode,
+		"requested_datetime": request.RequestedDateTime,
+		"update_datetime":    request.UpdatedDateTime,
+		"address":            request.Address,
+		"media_url":          request.MediaURL,
+	}
+
+	if request.Geometry != nil {
+		properties["lat"], properties["lon"] = request.Coordinates()
+		return geo.Feature{Type: "Feature", Geometry: request.Geometry, Properties: properties}
+	}
+	if !request.HasLocation() {
+		return geo.Feature{Type: "Feature", Properties: properties}
+	}
+	lat, lon := request.Coordinates()
+	return geo.NewFeature(lat, lon, properties)
+}
+
+// selectFields returns a JSON object with only the fields selected of those it has
+func selectFields(object []byte, fields []string) ([]byte, error) {
+	all := map[string]json.RawMessage{}
+	err := json.Unmarshal(object, &all)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := map[string]json.RawMessage{}
+	for _, field := range fields {
+		if value, ok := all[field]; ok {
+			selected[field] = value
+		}
+	}
+	return json.Marshal(selected)
+}
+
+// selectProperties returns the properties of a feature selected, or all of them when none are.  The geometry of the
+// feature is kept either way.
+func selectProperties(properties map[string]interface{}, fields []string) map[string]interface{} {
+	if len(fields) == 0 {
+		return properties
+	}
+
+	selected := map[string]interface{}{}
+	for _, field := range fields {
+		if value, ok := properties[field]; ok {
+			selected[field] = value
+		}
+	}
+	return selected
+}
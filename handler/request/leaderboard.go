@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/repository"
+)
+
+// leaderboardResponse is the body of GET /analytics/neighborhoods
+type leaderboardResponse struct {
+	CityID        string                        `json:"city_id"`
+	RankBy        string                        `json:"rank_by"`
+	Start         string                        `json:"start"` // First day of the period, YYYY-MM-DD in the city's time zone
+	End           string                        `json:"end"`
+	Neighborhoods []repository.NeighborhoodRank `json:"neighborhoods"`
+}
+
+// getLeaderboard ranks the neighborhoods of a city by the requests submitted or resolved in them over a period, for
+// the community engagement page.  Like GET /requests/stats, it reads the counters the stream processor keeps.
+func getLeaderboard(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	cityName := cityID(req)
+	if cityName == "" {
+		return clientError(http.StatusBadRequest, errors.New("city_id must be specified, since neighborhoods belong to a city"))
+	}
+
+	rankBy := req.QueryStringParameters["rank_by"]
+	switch rankBy {
+	case repository.LeaderboardBySubmitted, repository.LeaderboardByResolved:
+	case "":
+		rankBy = repository.LeaderboardBySubmitted
+	default:
+		return clientError(http.StatusBadRequest, fmt.Errorf("rank_by '%s' must be submitted or resolved", rankBy))
+	}
+
+	n, err := statsPeriod(req.QueryStringParameters["period"])
+	if err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+
+	city, err := repository.GetCity(cityName)
+	if err != nil {
+		switch err.(type) {
+		case *repository.CityNotFoundErr:
+			errorMessage := fmt.Errorf("%s.  city_id '%s' not in database", err, cityName)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	days := periodDays(time.Now().In(city.Config.Location()), n)
+	daily, err := repository.GetDailyStats(cityName, days)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	body, err := json.Marshal(leaderboardResponse{
+		CityID:        cityName,
+		RankBy:        rankBy,
+		Start:         days[0],
+		End:           days[len(days)-1],
+		Neighborhoods: repository.Leaderboard(city.Neighborhoods, daily, rankBy),
+	})
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling Leaderboard() struct"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
@@ -6,38 +6,118 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/social-torch/open311-services/apierror"
+	"github.com/social-torch/open311-services/auth"
+	"github.com/social-torch/open311-services/awsclient"
+	"github.com/social-torch/open311-services/features"
+	"github.com/social-torch/open311-services/geo"
+	"github.com/social-torch/open311-services/geocode"
+	"github.com/social-torch/open311-services/metrics"
 	"github.com/social-torch/open311-services/repository"
+	"github.com/social-torch/open311-services/snapshot"
+	"github.com/social-torch/open311-services/validate"
+	"github.com/social-torch/open311-services/warmup"
+	"github.com/social-torch/open311-services/wire"
 )
 
 var infoLogger = log.New(os.Stdout, "INFO\t", 0)
 var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
 var errorLogger = log.New(os.Stderr, "ERROR\t", log.Lshortfile)
 
-/// Route requests
+// / Route requests
 func router(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	switch req.HTTPMethod {
 	case "GET":
+		// Feeds are built here for every city, from a federated city's server when it has one
+		if req.Resource == "/requests/feed.atom" {
+			return getRequestsFeed(req)
+		}
+
+		// Cities with their own Open311 server are answered by it
+		city, err := federatedCity(req)
+		if err != nil {
+			return serverError(http.StatusInternalServerError, err)
+		}
+		if city.Federated {
+			return proxyGet(req, city)
+		}
+
 		if req.Resource == "/request/{id}" {
-			id := req.PathParameters["id"]
-			return getRequest(id)
+			return getRequest(req)
 		}
 
 		if req.Resource == "/requests" {
-			return getRequests()
+			if _, ok := req.QueryStringParameters["bbox"]; ok {
+				return getRequestsInBox(req)
+			}
+			if _, ok := req.QueryStringParameters["zipcode"]; ok {
+				return getRequestsByZipCode(req)
+			}
+			return getRequests(req)
+		}
+
+		if req.Resource == "/requests/stats" {
+			return getRequestStats(req)
+		}
+
+		if req.Resource == "/analytics/trending" {
+			return getTrending(req)
+		}
+
+		if req.Resource == "/analytics/neighborhoods" {
+			return getLeaderboard(req)
+		}
+
+		if req.Resource == "/requests/nearby" {
+			return getNearbyRequests(req)
+		}
+
+		if req.Resource == "/requests/clusters" {
+			return getRequestClusters(req)
+		}
+
+		if req.Resource == "/requests/pins" {
+			return getRequestPins(req)
+		}
+
+		if req.Resource == "/request/{id}/media" {
+			id := req.PathParameters["id"]
+			return getRequestMedia(id, req)
+		}
+
+		if req.Resource == "/request/{id}/notifications" {
+			id := req.PathParameters["id"]
+			return getNotificationDeliveries(id, req)
 		}
 
 	case "POST":
 		return submitRequest(req)
+
+	case "PUT":
+		if req.Resource == "/request/{id}/media/moderation" {
+			id := req.PathParameters["id"]
+			return reviewRequestMedia(id, req)
+		}
 	}
-	return clientError(http.StatusMethodNotAllowed, errors.New("method must be 'GET' or 'POST'"))
+	return clientError(http.StatusMethodNotAllowed, errors.New("method must be 'GET', 'POST' or 'PUT'"))
 }
 
-func getRequest(id string) (events.APIGatewayProxyResponse, error) {
-	request, err := repository.GetRequest(id)
+func getRequest(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	id := req.PathParameters["id"]
+	fields, err := repository.ParseFields(req.QueryStringParameters["fields"], repository.RequestAttributes)
+	if err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+
+	request, err := repository.GetRequestFields(cityID(req), id, fields)
 	if err != nil {
 		switch err.(type) {
 		case *repository.RequestIdNotFoundErr:
@@ -49,6 +129,9 @@ func getRequest(id string) (events.APIGatewayProxyResponse, error) {
 	}
 
 	body, err := json.Marshal(&request)
+	if err == nil && len(fields) > 0 {
+		body, err = selectFields(body, fields)
+	}
 	if err != nil {
 		return serverError(http.StatusInternalServerError, errors.New("error marshalling GetRequest() struct"))
 	}
@@ -60,16 +143,590 @@ func getRequest(id string) (events.APIGatewayProxyResponse, error) {
 	}, nil
 }
 
-func getRequests() (events.APIGatewayProxyResponse, error) {
-	requests, err := repository.GetRequests()
+// requestsWindow is the span of requests listed when no start_date or end_date is given, and the longest that may
+// be asked for, as in GeoReport v2
+const requestsWindow = 90 * 24 * time.Hour
+
+func getRequests(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	// Residents opening the map are sent to the static snapshot, sparing the table during spikes
+	if !auth.InGroup(req, auth.CityAdminGroup) {
+		if location := snapshot.Location(req, cityID(req), snapshot.RequestsFile); location != "" {
+			return snapshot.Redirect(location)
+		}
+	}
+
+	start, end, err := requestsRange(req, time.Now())
+	if err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+
+	limit, err := requestsLimit(req)
+	if err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+
+	w, err := newListingWriter(req, listingBudget)
+	if err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+
+	// The listing is written a page at a time, stopping short of the response limit with a cursor to continue from
+	cursor := req.QueryStringParameters["cursor"]
+	for {
+		pageLimit := repository.RequestsPageSize
+		if limit > 0 && limit-w.count < pageLimit {
+			pageLimit = limit - w.count
+		}
+		requests, next, err := repository.GetRequestsPage(cityID(req), start, end, cursor, pageLimit, w.attributes())
+		if err != nil {
+			switch err.(type) {
+			case *repository.CursorErr:
+				return clientError(http.StatusBadRequest, err)
+			default:
+				return serverError(http.StatusInternalServerError, err)
+			}
+		}
+
+		full := false
+		for _, request := range requests {
+			written, err := w.add(request)
+			if err != nil {
+				return serverError(http.StatusInternalServerError, err)
+			}
+			if !written {
+				full = true
+				break
+			}
+			cursor = repository.RequestCursor(request)
+		}
+		if !full {
+			cursor = next
+		}
+		if full || cursor == "" || (limit > 0 && w.count >= limit) {
+			break
+		}
+	}
+
+	headers := map[string]string{}
+	if cursor != "" {
+		headers["X-Next-Cursor"] = cursor
+		headers["Link"] = fmt.Sprintf("<%s>; rel=\"next\"", nextPage(req, cursor))
+	}
+	body, contentType := w.close()
+	return listed(body, contentType, headers), nil
+}
+
+// Requests in a listing when no limit is given, and the most that may be asked for.  The city's admins may ask for
+// limit=all, which lists every request in the range, up to the listingBudget.
+const (
+	defaultRequestsLimit = 100
+	maxRequestsLimit     = 1000
+)
+
+// requestsLimit returns the most requests a listing may hold, or 0 for all of them
+func requestsLimit(req events.APIGatewayProxyRequest) (int, error) {
+	v, ok := req.QueryStringParameters["limit"]
+	if !ok {
+		return defaultRequestsLimit, nil
+	}
+	// Only for the admins of the city listed, whose tokens name it
+	if v == "all" {
+		if auth.StaffCity(req) == "" || !auth.IsAdminOf(cityID(req), req) {
+			return 0, errors.New("limit=all may only be asked for by the city's admins. Follow the cursor of each listing instead")
+		}
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit <= 0 || limit > maxRequestsLimit {
+		return 0, fmt.Errorf("limit must be a number of requests from 1 to %d", maxRequestsLimit)
+	}
+	return limit, nil
+}
+
+// listingBudget is the most bytes of requests put in a listing of requests, leaving room under the 6 MB an API Gateway
+// proxy response may carry
+const listingBudget = 5 << 20
+
+// nextPage returns the URL of the listing continuing a listing from cursor
+func nextPage(req events.APIGatewayProxyRequest, cursor string) string {
+	query := url.Values{}
+	for name, value := range req.QueryStringParameters {
+		query.Set(name, value)
+	}
+	query.Set("cursor", cursor)
+	return apiBase(req) + req.Path + "?" + query.Encode()
+}
+
+// requestURL returns the URL a request is read from
+func requestURL(req events.APIGatewayProxyRequest, id string) string {
+	return apiBase(req) + "/request/" + url.PathEscape(id)
+}
+
+// requestsRange returns the range of requested_datetime a listing covers, from its start_date and end_date.  Either
+// may be left out, and the range defaults to the requestsWindow ending at the end_date, or now.
+func requestsRange(req events.APIGatewayProxyRequest, now time.Time) (time.Time, time.Time, error) {
+	end := now
+	if v := req.QueryStringParameters["end_date"]; v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return end, end, errors.New("end_date must be a w3 datetime, eg 2019-06-02T12:00:00Z")
+		}
+		end = t
+	}
+
+	start := end.Add(-requestsWindow)
+	if v := req.QueryStringParameters["start_date"]; v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return start, end, errors.New("start_date must be a w3 datetime, eg 2019-06-01T00:00:00Z")
+		}
+		start = t
+		if _, ok := req.QueryStringParameters["end_date"]; !ok && now.Sub(start) > requestsWindow {
+			end = start.Add(requestsWindow)
+		}
+	}
+
+	if end.Before(start) {
+		return start, end, errors.New("end_date must not be before start_date")
+	}
+	if end.Sub(start) > requestsWindow {
+		return start, end, fmt.Errorf("start_date and end_date may not span more than %d days", int(requestsWindow.Hours()/24))
+	}
+	return start, end, nil
+}
+
+// mediaEntry is a request attachment with URLs presigned for immediate display
+type mediaEntry struct {
+	Key              string `json:"media_key"`
+	ContentType      string `json:"content_type"`
+	Width            int    `json:"width"`
+	Height           int    `json:"height"`
+	ModerationStatus string `json:"moderation_status"`
+	StorageStatus    string `json:"storage_status"`
+	Timestamp        string `json:"timestamp"`
+	URL              string `json:"url"`           // Full size media. Empty when rejected, archived or deleted
+	ThumbnailURL     string `json:"thumbnail_url"` // Thumbnail or poster frame, falling back to the full size media
+}
+
+// Radius, in meters, of a nearby search when none is given, and the largest allowed
+const (
+	defaultNearbyRadius = 500
+	maxNearbyRadius     = 5000
+)
+
+func getNearbyRequests(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	lat, errLat := strconv.ParseFloat(req.QueryStringParameters["lat"], 64)
+	lon, errLon := strconv.ParseFloat(req.QueryStringParameters["lon"], 64)
+	if errLat != nil || errLon != nil {
+		return clientError(http.StatusBadRequest, errors.New("lat and lon must be specified in decimal degrees"))
+	}
+	if _, err := repository.NewLocation(lat, lon); err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+
+	radius := float64(defaultNearbyRadius)
+	if v, ok := req.QueryStringParameters["radius"]; ok {
+		r, err := strconv.ParseFloat(v, 64)
+		if err != nil || r <= 0 || r > maxNearbyRadius {
+			return clientError(http.StatusBadRequest, fmt.Errorf("radius must be between 0 and %d meters", maxNearbyRadius))
+		}
+		radius = r
+	}
+
+	fields, err := listingFields(req)
+	if err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+
+	requests, err := repository.GetNearbyRequests(cityID(req), lat, lon, radius, repository.ListingAttributes(fields))
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	return listing(req, requests, map[string]string{})
+}
+
+func getRequestsInBox(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	box, err := geo.ParseBox(req.QueryStringParameters["bbox"])
+	if err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+
+	fields, err := listingFields(req)
+	if err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+
+	limit := zoomLimit(req.QueryStringParameters["zoom"])
+	requests, truncated, err := repository.GetRequestsInBox(cityID(req), box, limit, repository.ListingAttributes(fields))
+	if err != nil {
+		switch err.(type) {
+		case *repository.BoxTooLargeErr:
+			return clientError(http.StatusBadRequest, fmt.Errorf("%s. zoom in to query a smaller area", err))
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	return listing(req, requests, map[string]string{"X-Truncated": strconv.FormatBool(truncated)})
+}
+
+// listing responds with a listing of requests in the format asked for, adding headers to the usual ones
+func listing(req events.APIGatewayProxyRequest, requests []repository.Request, headers map[string]string) (events.APIGatewayProxyResponse, error) {
+	body, contentType, err := listingBody(req, requests)
+	if err != nil {
+		switch err.(type) {
+		case *formatErr:
+			return clientError(http.StatusBadRequest, err)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	return listed(body, contentType, headers), nil
+}
+
+// listed returns the response carrying a listing, adding headers to the usual ones
+func listed(body string, contentType string, headers map[string]string) events.APIGatewayProxyResponse {
+	headers["content-type"] = contentType
+	headers["Access-Control-Allow-Origin"] = "*"
+	headers["Vary"] = "Accept"
+	return events.APIGatewayProxyResponse{
+		StatusCode:      http.StatusOK,
+		Headers:         headers,
+		Body:            body,
+		IsBase64Encoded: contentType == wire.ContentTypeProtobuf,
+	}
+}
+
+func getRequestClusters(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	box, err := geo.ParseBox(req.QueryStringParameters["bbox"])
+	if err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+
+	zoom, err := strconv.Atoi(req.QueryStringParameters["zoom"])
+	if err != nil || zoom < 0 {
+		return clientError(http.StatusBadRequest, errors.New("zoom must be specified as a map zoom level"))
+	}
+
+	clusters, err := repository.GetRequestClusters(cityID(req), box, clusterPrecision(zoom))
+	if err != nil {
+		switch err.(type) {
+		case *repository.BoxTooLargeErr:
+			return clientError(http.StatusBadRequest, fmt.Errorf("%s. zoom in to query a smaller area", err))
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	body, err := json.Marshal(clusters)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling GetRequestClusters() struct"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// pinsLimit is the most pins a viewport shows.  Pins are a few dozen bytes each, so a viewport can carry many
+// more of them than of requests.
+const pinsLimit = 5000
+
+func getRequestPins(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	box, err := geo.ParseBox(req.QueryStringParameters["bbox"])
+	if err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+
+	pins, truncated, err := repository.GetPinsInBox(cityID(req), box, pinsLimit)
+	if err != nil {
+		switch err.(type) {
+		case *repository.BoxTooLargeErr:
+			return clientError(http.StatusBadRequest, fmt.Errorf("%s. zoom in to query a smaller area", err))
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	body, err := json.Marshal(pins)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling GetPinsInBox() struct"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers: map[string]string{
+			"content-type":                "application/json",
+			"Access-Control-Allow-Origin": "*",
+			"X-Truncated":                 strconv.FormatBool(truncated),
+		},
+		Body: string(body),
+	}, nil
+}
+
+func getRequestsByZipCode(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	zipCode := repository.ZipCode(req.QueryStringParameters["zipcode"])
+	if !zipCode.Valid() {
+		return clientError(http.StatusBadRequest, errors.New("zipcode must be a five digit ZIP code or ZIP+4"))
+	}
+
+	fields, err := listingFields(req)
+	if err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+
+	requests, err := repository.GetRequestsByZipCode(cityID(req), zipCode, repository.ListingAttributes(fields))
 	if err != nil {
 		return serverError(http.StatusInternalServerError, err)
 	}
 
-	body, err := json.Marshal(requests)
+	return listing(req, requests, map[string]string{})
+}
+
+// clusterPrecision returns the geohash precision requests are clustered by at a zoom level, so each cluster
+// covers roughly a quarter of a map tile.  Zoomed in maps get cells of a few centimeters, making each request
+// its own point.
+func clusterPrecision(zoom int) int {
+	switch {
+	case zoom < 9:
+		return 4
+	case zoom < 12:
+		return 5
+	case zoom < 14:
+		return 6
+	case zoom < 16:
+		return 7
+	}
+	return 12
+}
+
+// zoomLimit returns how many requests a map at a zoom level shows.  Zoomed out maps show fewer markers so they
+// stay legible; without a zoom level the most detailed limit applies.
+func zoomLimit(zoom string) int {
+	z, err := strconv.Atoi(zoom)
+	switch {
+	case err != nil:
+		return 1000
+	case z < 12:
+		return 100
+	case z < 15:
+		return 500
+	}
+	return 1000
+}
+
+// getRequestMedia lists the media of a request, with URLs of the media that may be served to the caller.  Staff of
+// the request's city see media that moderation hasn't cleared.
+func getRequestMedia(id string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	request, err := repository.GetRequest(cityID(req), id)
 	if err != nil {
-		return serverError(http.StatusInternalServerError, errors.New("error marshalling GetRequests() struct"))
+		switch err.(type) {
+		case *repository.RequestIdNotFoundErr:
+			errorMessage := fmt.Errorf("%s. service_request_id '%s' not in database", err, id)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	media, err := repository.GetRequestMedia(request.CityID, id)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
 	}
+	staff := auth.StaffCity(req) != "" && auth.StaffCity(req) == request.CityID
+	now := time.Now()
+
+	stores := map[string]mediaStore{}
+	entries := []mediaEntry{}
+	for _, m := range media {
+		entry := mediaEntry{
+			Key:              m.Key,
+			ContentType:      m.ContentType,
+			Width:            m.Width,
+			Height:           m.Height,
+			ModerationStatus: m.ModerationStatus,
+			StorageStatus:    m.StorageStatus,
+			Timestamp:        m.Timestamp,
+		}
+
+		if m.Viewable(staff, now) && (m.StorageStatus == "" || m.StorageStatus == repository.MediaActive) {
+			store, err := mediaStoreOf(stores, m.City)
+			if err != nil {
+				return serverError(http.StatusInternalServerError, err)
+			}
+
+			entry.URL, err = store.presign(m.Key)
+			if err != nil {
+				return serverError(http.StatusInternalServerError, errors.New("error presigning media URL"))
+			}
+
+			entry.ThumbnailURL = entry.URL
+			for _, v := range m.Variants {
+				if v.Name == "thumbnail" || v.Name == "poster" {
+					entry.ThumbnailURL, err = store.presign(v.Key)
+					if err != nil {
+						return serverError(http.StatusInternalServerError, errors.New("error presigning thumbnail URL"))
+					}
+					break
+				}
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	body, err := json.Marshal(entries)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling request media"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// mediaStore is the bucket holding a city's media and the region it is in
+type mediaStore struct {
+	Bucket string
+	Region string // Region of the bucket of a city pinned to one. Empty for the deployment's region
+}
+
+// mediaStoreOf returns where a city's media is stored, caching lookups in stores
+func mediaStoreOf(stores map[string]mediaStore, cityName string) (mediaStore, error) {
+	if store, ok := stores[cityName]; ok {
+		return store, nil
+	}
+
+	store := mediaStore{Bucket: os.Getenv("IMAGE_BUCKET")}
+	if cityName != "" {
+		city, err := repository.GetCity(cityName)
+		if err != nil {
+			return mediaStore{}, err
+		}
+		store.Region = city.Region
+		if city.MediaBucket != "" {
+			store.Bucket = city.MediaBucket
+		}
+	}
+
+	stores[cityName] = store
+	return store, nil
+}
+
+// presign returns a presigned S3 GET URL for key, signed in the bucket's region
+func (m mediaStore) presign(key string) (string, error) {
+	svc := s3.New(awsclient.SessionIn(m.Region))
+	req, _ := svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(m.Bucket),
+		Key:    aws.String(key),
+	})
+
+	return req.Presign(10 * time.Minute)
+}
+
+// mediaReview is a city admin's moderation decision on media of a request
+type mediaReview struct {
+	Key              string `json:"media_key"`
+	ModerationStatus string `json:"moderation_status"` // "approved" or "rejected"
+}
+
+// reviewRequestMedia approves or rejects media of a request, for the admins of the media's city.  Media flagged by
+// moderation, or pending past the grace period, is withheld from the public until it is approved here.
+func reviewRequestMedia(id string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !auth.InGroup(req, auth.CityAdminGroup) {
+		return clientError(http.StatusForbidden, errors.New("media may only be reviewed by city admins"))
+	}
+
+	var review mediaReview
+	err := json.Unmarshal([]byte(req.Body), &review)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, errors.New("error unmarshalling media review JSON. Check syntax"))
+	}
+	if review.ModerationStatus != repository.ModerationApproved && review.ModerationStatus != repository.ModerationRejected {
+		return clientError(http.StatusBadRequest, fmt.Errorf("moderation_status must be '%s' or '%s'", repository.ModerationApproved, repository.ModerationRejected))
+	}
+
+	request, err := repository.GetRequest(cityID(req), id)
+	if err != nil {
+		switch err.(type) {
+		case *repository.RequestIdNotFoundErr:
+			errorMessage := fmt.Errorf("%s. service_request_id '%s' not in database", err, id)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	media, err := repository.GetMedia(review.Key)
+	if _, ok := err.(*repository.MediaNotFoundErr); ok || err == nil && media.ServiceRequestID != request.ServiceRequestID {
+		return clientError(http.StatusNotFound, fmt.Errorf("media '%s' is not attached to request %s", review.Key, id))
+	}
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	// Media stored before keys were namespaced by city is the request's city's
+	city := media.City
+	if city == "" {
+		city = request.CityID
+	}
+	if !auth.IsAdminOf(city, req) {
+		return clientError(http.StatusForbidden, fmt.Errorf("media of %s may only be reviewed by its city admins", city))
+	}
+
+	err = repository.ReviewMedia(media.Key, review.ModerationStatus, auth.Claim(req, "cognito:username"))
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+	infoLogger.Printf("Media %s %s by %s", media.Key, review.ModerationStatus, auth.Claim(req, "cognito:username"))
+
+	body, err := json.Marshal(review)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("unable to marshal JSON for media review response"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// getNotificationDeliveries lists the notifications sent about a request, for the admins of the request's city
+func getNotificationDeliveries(id string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !auth.InGroup(req, auth.CityAdminGroup) {
+		return clientError(http.StatusForbidden, errors.New("notification deliveries may only be inspected by city admins"))
+	}
+	request, err := repository.GetRequest(cityID(req), id)
+	if err != nil {
+		switch err.(type) {
+		case *repository.RequestIdNotFoundErr:
+			errorMessage := fmt.Errorf("%s. service_request_id '%s' not in database", err, id)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+	if !auth.IsAdminOf(request.CityID, req) {
+		return clientError(http.StatusForbidden, fmt.Errorf("notification deliveries of %s may only be inspected by its city admins", request.CityID))
+	}
+
+	deliveries, err := repository.GetNotificationDeliveries(id)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	body, err := json.Marshal(deliveries)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling notification deliveries"))
+	}
+
 	return events.APIGatewayProxyResponse{
 		StatusCode: http.StatusOK,
 		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
@@ -84,69 +741,379 @@ func submitRequest(req events.APIGatewayProxyRequest) (events.APIGatewayProxyRes
 		userID = "guest"
 	}
 
-	var Open311request repository.Request
-	err := json.Unmarshal([]byte(req.Body), &Open311request)
+	Open311request, update, err := requestInput(req.Body)
 	if err != nil {
 		return clientError(http.StatusUnprocessableEntity, errors.New("error unmarshalling Request JSON. Check syntax"))
 	}
 
+	// Every field that is wrong is reported at once, before anything is looked up or stored
+	err = validate.Request(Open311request)
+	if err != nil {
+		switch err.(type) {
+		case *validate.InvalidRequestErr:
+			return clientError(http.StatusBadRequest, err)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	// Updates change the fields a client sent of the stored request, which keeps the rest
+	var stored repository.Request
+	if update != nil {
+		stored, err = repository.GetRequest(cityID(req), update.ServiceRequestID)
+		if err != nil {
+			switch err.(type) {
+			case *repository.RequestIdNotFoundErr:
+				errorMessage := fmt.Errorf("%s. service_request_id '%s' not in database", err, update.ServiceRequestID)
+				return clientError(http.StatusNotFound, errorMessage)
+			default:
+				return serverError(http.StatusInternalServerError, err)
+			}
+		}
+		// Residents correct what they reported, while how it is handled is up to the city
+		if update.StaffUpdateInput.Sent() && !auth.IsAdminOf(stored.CityID, req) {
+			return clientError(http.StatusForbidden, fmt.Errorf("status, notes, notices, schedules and assignments of requests to %s may only be set by its city admins", stored.CityID))
+		}
+		Open311request = update.Apply(stored)
+	}
+
+	// Requests are made to the caller's city unless they name one
+	if Open311request.CityID == "" {
+		Open311request.CityID = cityID(req)
+	}
+	city, err := servingCity(Open311request.CityID)
+	if err != nil {
+		switch err.(type) {
+		case *repository.CityNotFoundErr:
+			errorMessage := fmt.Errorf("%s. city_id '%s' not in database", err, Open311request.CityID)
+			return clientError(http.StatusBadRequest, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+	if city.Deactivated {
+		return clientError(http.StatusServiceUnavailable, errors.New(deactivationNotice(city)))
+	}
+	if city.Federated {
+		return submitFederated(req, city, Open311request)
+	}
+
+	// Cities may require residents to sign in before reporting
+	anonymous, err := features.Enabled(city.CityName, repository.FeatureAnonymousReporting)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+	if userID == "guest" && !anonymous {
+		return clientError(http.StatusUnauthorized, fmt.Errorf("%s requires an account to submit requests. Sign in and send the account in the 'from' header", city.CityName))
+	}
+
 	// Make sure Request has minimum amount of information in order to create new 311 request
-	// Check that service code exists in Services table
-	if !repository.IsValidServiceCode(Open311request.ServiceCode) {
-		return clientError(http.StatusBadRequest, errors.New("invalid Service Code: "+Open311request.ServiceCode))
+	// Check that service code exists in Services table and is offered by the city.  The service is cached for
+	// SubmitRequest, which reads it again to name the request and route it.
+	_, err = repository.LookupService(Open311request.CityID, Open311request.ServiceCode)
+	if err != nil {
+		switch err.(type) {
+		case *repository.ServiceCodeNotFoundErr:
+			return clientError(http.StatusBadRequest, errors.New("invalid Service Code: "+Open311request.ServiceCode))
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	// Requests about a registered asset are located at the asset
+	if Open311request.AssetID != "" {
+		if city.CityName == "" {
+			return clientError(http.StatusBadRequest, errors.New("asset_id can't be used without a city_id naming the city whose assets are registered"))
+		}
+		err = attachAsset(city.CityName, &Open311request)
+		if err != nil {
+			switch err.(type) {
+			case *repository.AssetNotFoundErr:
+				errorMessage := fmt.Errorf("%s. asset_id '%s' not in the asset registry", err, Open311request.AssetID)
+				return clientError(http.StatusBadRequest, errorMessage)
+			default:
+				return serverError(http.StatusInternalServerError, err)
+			}
+		}
 	}
 
 	// Check that request has a location
-	if Open311request.Address == "" && (Open311request.Latitude == 0 && Open311request.Longitude == 0) {
+	if Open311request.Address == "" && !Open311request.HasLocation() {
 		return clientError(http.StatusBadRequest, errors.New("no location included in request"))
 	}
 
+	err = Open311request.ValidateLocation()
+	if err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+
+	if Open311request.Geometry != nil {
+		err = Open311request.Geometry.Validate()
+		if err != nil {
+			return clientError(http.StatusBadRequest, err)
+		}
+	}
+
+	geocoder := geocode.ForCity(city)
+
+	// Requests located only by address need coordinates to be found on the map
+	var warnings []string
+	if !Open311request.HasLocation() {
+		warning, err := forwardGeocode(geocoder, &Open311request)
+		if err != nil {
+			switch err.(type) {
+			case *geocode.NotFoundErr:
+				errorMessage := fmt.Errorf("%s. Check the address or include lat and lon", err)
+				return clientError(http.StatusBadRequest, errorMessage)
+			default:
+				return serverError(http.StatusInternalServerError, err)
+			}
+		}
+		if warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+
+	err = checkJurisdiction(city, Open311request)
+	if err != nil {
+		switch err.(type) {
+		case *outsideJurisdictionErr:
+			return clientError(http.StatusBadRequest, err)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	// Neighborhood leaderboards count requests by the neighborhood they are located in
+	if Open311request.HasLocation() {
+		Open311request.Neighborhood = city.NeighborhoodOf(Open311request.Coordinates())
+	}
+
+	// Staff work orders need a street address, and breakdowns by ZIP code need the ZIP code, so fill in whichever
+	// is missing from the coordinates
+	if Open311request.Address == "" || Open311request.ZipCode == "" {
+		reverseGeocode(geocoder, &Open311request)
+	}
+
 	var response repository.RequestResponse
 	// If this is a new request, initialize a new request.  If this is an existing request, update it
+	statusCode := http.StatusCreated
 	if Open311request.ServiceRequestID == "" {
 		// Create new Open311 Request and load into DynamoDB Requests table
 		response, err = repository.SubmitRequest(Open311request, userID)
 		infoLogger.Println("New request submitted: " + response.ServiceRequestID)
 	} else {
 		// Update existing Open311 Request in DynamoDB Requests table
-		response, err = repository.UpdateRequest(Open311request, userID)
+		response, err = repository.UpdateRequest(Open311request, stored, userID)
 		infoLogger.Println("Request updated: " + response.ServiceRequestID)
+		statusCode = http.StatusOK
 	}
 
 	if err != nil {
-		return serverError(http.StatusInternalServerError, err)
+		return submitError(err, Open311request.ServiceRequestID)
 	}
+	response.Warnings = warnings
 
 	body, err := json.Marshal(response)
 	if err != nil {
 		return serverError(http.StatusInternalServerError, errors.New("unable to marshal JSON for request response"))
 	}
 
+	// New requests are found at their own URL
+	headers := map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"}
+	if statusCode == http.StatusCreated {
+		headers["Location"] = requestURL(req, response.ServiceRequestID)
+	}
 	return events.APIGatewayProxyResponse{
-		StatusCode: http.StatusCreated,
-		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		StatusCode: statusCode,
+		Headers:    headers,
 		Body:       string(body),
 	}, nil
 }
 
+// submitError answers a submission the repository refused: an update of an unknown ID is not found, and never
+// creates the request, while a new request with the ID of one stored, or an update of a request changed since it
+// was read, is a conflict
+func submitError(err error, id string) (events.APIGatewayProxyResponse, error) {
+	switch err.(type) {
+	case *repository.RequestIdNotFoundErr:
+		errorMessage := fmt.Errorf("%s. service_request_id '%s' not in database", err, id)
+		return clientError(http.StatusNotFound, errorMessage)
+	case *repository.RequestIdConflictErr, *repository.RequestChangedErr:
+		return clientError(http.StatusConflict, err)
+	default:
+		return serverError(http.StatusInternalServerError, err)
+	}
+}
+
+// requestInput returns the request a submission makes, with only the fields a client may set.  A body with a
+// service_request_id updates that request, and the update is returned too, to be applied to the stored request.
+func requestInput(body string) (repository.Request, *repository.UpdateRequestInput, error) {
+	var id struct {
+		ServiceRequestID string `json:"service_request_id"`
+	}
+	err := json.Unmarshal([]byte(body), &id)
+	if err != nil {
+		return repository.Request{}, nil, err
+	}
+
+	if id.ServiceRequestID == "" {
+		var input repository.SubmitRequestInput
+		err = json.Unmarshal([]byte(body), &input)
+		return input.Request(), nil, err
+	}
+	update := &repository.UpdateRequestInput{}
+	err = json.Unmarshal([]byte(body), update)
+	return update.Apply(repository.Request{}), update, err
+}
+
+// reverseGeocode sets the address and ZIP code of a request from its coordinates, where they are missing.
+// Failures are logged rather than failing the submission, since the coordinates still locate the request.
+func reverseGeocode(geocoder *geocode.Geocoder, request *repository.Request) {
+	place, err := geocoder.Reverse(request.Coordinates())
+	if err != nil {
+		warningLogger.Println(err)
+		return
+	}
+
+	if request.Address == "" {
+		request.Address = place.Address
+		request.AddressID = place.AddressID
+	}
+	if request.ZipCode == "" {
+		request.ZipCode = place.ZipCode
+	}
+}
+
+// attachAsset locates a request at the asset it is about, and labels it the way crews refer to the asset.  If
+// the asset isn't in the city's registry, an AssetNotFoundErr error is set
+func attachAsset(cityName string, request *repository.Request) error {
+	asset, err := repository.GetAsset(cityName, request.AssetID)
+	if err != nil {
+		return err
+	}
+
+	request.Location = asset.Location
+	request.AssetLabel = asset.Label
+	if request.Address == "" {
+		request.Address = asset.Address
+	}
+	return nil
+}
+
+// minRelevance is the place index relevance below which a geocoded address is accepted with a warning
+const minRelevance = 0.8
+
+// forwardGeocode sets the coordinates of a request from its address, considering only places within the
+// SERVICE_AREA bounding box when one is configured.  A warning is returned for doubtful matches.
+func forwardGeocode(geocoder *geocode.Geocoder, request *repository.Request) (string, error) {
+	var area *geo.Box
+	if v := os.Getenv("SERVICE_AREA"); v != "" {
+		box, err := geo.ParseBox(v)
+		if err != nil {
+			return "", fmt.Errorf("invalid SERVICE_AREA: %s", err)
+		}
+		area = &box
+	}
+
+	match, err := geocoder.Forward(request.Address, area)
+	if err != nil {
+		return "", err
+	}
+	place := match.Place
+
+	location, err := repository.NewLocation(place.Latitude, place.Longitude)
+	if err != nil {
+		return "", fmt.Errorf("place index located '%s' at an invalid location: %s", request.Address, err)
+	}
+	request.Location = location
+	request.AddressID = place.AddressID
+	if request.ZipCode == "" {
+		request.ZipCode = place.ZipCode
+	}
+
+	if match.Relevance < minRelevance {
+		return fmt.Sprintf("address '%s' was matched to '%s'; please check the location", request.Address, place.Address), nil
+	}
+	return "", nil
+}
+
+// outsideJurisdictionErr is returned for requests located outside the city limits of the city they were made to
+type outsideJurisdictionErr struct {
+	message string
+}
+
+func (e *outsideJurisdictionErr) Error() string {
+	return e.message
+}
+
+// cityID returns the city a call is scoped to: the city in the caller's token, else the city_id or jurisdiction_id
+// query parameter, else the JURISDICTION this deployment serves.  It is "" for deployments that serve no city in
+// particular.
+func cityID(req events.APIGatewayProxyRequest) string {
+	if city := auth.StaffCity(req); city != "" {
+		return city
+	}
+	if city := req.QueryStringParameters["city_id"]; city != "" {
+		return city
+	}
+	// GeoReport v2 clients scope calls by jurisdiction_id, which the endpoints directory gives as the city_name
+	if city := req.QueryStringParameters["jurisdiction_id"]; city != "" {
+		return city
+	}
+	return os.Getenv("JURISDICTION")
+}
+
+// servingCity returns the city a request is made to, or an empty City when it is made to no city in particular.
+// If the city is not in the database, a CityNotFoundErr error is set
+func servingCity(cityID string) (repository.City, error) {
+	if cityID == "" {
+		return repository.City{}, nil
+	}
+	return repository.GetCity(cityID)
+}
+
+// deactivationNotice returns the notice residents see when their submission to a paused city is refused
+func deactivationNotice(city repository.City) string {
+	if city.DeactivationNotice != "" {
+		return city.DeactivationNotice
+	}
+
+	notice := fmt.Sprintf("%s is not taking new requests through the app right now. Please try again later", city.CityName)
+	if phone := city.Config.Contact.Phone; phone != "" {
+		notice += ", or call " + phone
+	}
+	return notice
+}
+
+// checkJurisdiction refuses requests located outside the limits of the city this deployment serves, naming the
+// city that does serve the location when one is known.  Nothing is refused until the city has a boundary.
+func checkJurisdiction(city repository.City, request repository.Request) error {
+	lat, lon := request.Coordinates()
+	if len(city.Boundary) == 0 || city.Boundary.Contains(lat, lon) {
+		return nil
+	}
+
+	message := fmt.Sprintf("location is outside the city limits of %s", city.CityName)
+	neighbour, err := repository.FindCity(lat, lon)
+	if err == nil {
+		message += fmt.Sprintf(". Submit it to %s at %s", neighbour.CityName, neighbour.Endpoint)
+	} else if _, ok := err.(*repository.CityNotFoundErr); !ok {
+		return err
+	}
+	return &outsideJurisdictionErr{message}
+}
+
 func serverError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
 	errorLogger.Println(err.Error())
-	return events.APIGatewayProxyResponse{
-		StatusCode: statusCode,
-		Headers:    map[string]string{"content-type": "text/plain"},
-		Body:       http.StatusText(statusCode) + ": " + err.Error(),
-	}, nil
+	return apierror.Response(statusCode, err), nil
 }
 
 func clientError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
 	warningLogger.Println(err.Error())
-	return events.APIGatewayProxyResponse{
-		StatusCode: statusCode,
-		Headers:    map[string]string{"content-type": "text/plain"},
-		Body:       http.StatusText(statusCode) + ": " + err.Error(),
-	}, nil
+	return apierror.Response(statusCode, err), nil
 }
 
 func main() {
-	lambda.Start(router)
+	warmup.Start("request", metrics.Handler("request", apierror.Handler(repository.Audit(router))), repository.Warm)
 }
@@ -1,8 +1,405 @@
 package main
 
 import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/geo"
+	"github.com/social-torch/open311-services/repository"
+	"github.com/social-torch/open311-services/wire"
 )
 
-func TestStub(t *testing.T) {
+func TestZoomLimit(t *testing.T) {
+	tests := map[string]int{"": 1000, "10": 100, "13": 500, "17": 1000, "street": 1000}
+	for zoom, want := range tests {
+		if got := zoomLimit(zoom); got != want {
+			t.Errorf("zoomLimit(%q) = %d, want %d", zoom, got, want)
+		}
+	}
+}
+
+func TestClusterPrecision(t *testing.T) {
+	tests := map[int]int{3: 4, 10: 5, 12: 6, 15: 7, 16: 12, 19: 12}
+	for zoom, want := range tests {
+		if got := clusterPrecision(zoom); got != want {
+			t.Errorf("clusterPrecision(%d) = %d, want %d", zoom, got, want)
+		}
+	}
+}
+
+func TestRequestFeature(t *testing.T) {
+	request := repository.Request{ServiceRequestID: "01ABC", Status: "open",
+		Location: repository.Location{Latitude: 42.5, Longitude: -73.5}}
+	feature := requestFeature(request.Summary())
+	if point, ok := feature.Geometry.(*geo.Point); !ok || point.Coordinates != [2]float64{-73.5, 42.5} {
+		t.Errorf("requestFeature() geometry = %+v, want point at [-73.5, 42.5]", feature.Geometry)
+	}
+	if feature.Properties["service_request_id"] != "01ABC" || feature.Properties["status"] != "open" {
+		t.Errorf("requestFeature() properties = %+v", feature.Properties)
+	}
+
+	if feature := requestFeature(repository.RequestSummary{Address: "1 Monument Sq"}); feature.Geometry != nil {
+		t.Errorf("requestFeature() of a request without coordinates should have no geometry, got %+v", feature.Geometry)
+	}
+
+	sidewalk := &repository.Geometry{Type: repository.LineStringGeometry, Line: [][]float64{{-73.5, 42.5}, {-73.5, 42.501}}}
+	request.Geometry = sidewalk
+	feature = requestFeature(request.Summary())
+	if feature.Geometry != sidewalk || feature.Properties["lat"] != 42.5 {
+		t.Errorf("requestFeature() of a request with a geometry = %+v, want the geometry with lat and lon properties", feature)
+	}
+}
+
+func TestListingBody(t *testing.T) {
+	requests := []repository.Request{{ServiceRequestID: "01ABC"}}
+	for format, want := range map[string]string{"": jsonContentType, "json": jsonContentType, "geojson": geoJSONContentType} {
+		req := events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"format": format}}
+		if _, contentType, err := listingBody(req, requests); err != nil || contentType != want {
+			t.Errorf("listingBody(format=%q) content type = %s, %v, want %s", format, contentType, err, want)
+		}
+	}
+
+	req := events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"format": "kml"}}
+	if _, _, err := listingBody(req, requests); err == nil {
+		t.Error("listingBody(format=kml) should fail")
+	}
+
+	// An explicit format wins over the Accept header
+	headers := map[string]string{"Accept": wire.ContentTypeProtobuf}
+	for format, want := range map[string]string{"": wire.ContentTypeProtobuf, "json": jsonContentType} {
+		req := events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"format": format}, Headers: headers}
+		if _, contentType, err := listingBody(req, requests); err != nil || contentType != want {
+			t.Errorf("listingBody(format=%q, Accept protobuf) content type = %s, %v, want %s", format, contentType, err, want)
+		}
+	}
+}
+
+// The listing is built a request at a time, but reads as if marshalled whole
+func TestListingWriter(t *testing.T) {
+	requests := []repository.Request{{ServiceRequestID: "01ABC"}, {ServiceRequestID: "01ABD", Address: "1 Monument Sq"}}
+
+	want, _ := json.Marshal([]repository.RequestSummary{requests[0].Summary(), requests[1].Summary()})
+	if body, _, _ := listingBody(events.APIGatewayProxyRequest{}, requests); body != string(want) {
+		t.Errorf("listingBody() = %s, want %s", body, want)
+	}
+	want, _ = json.Marshal(geo.NewFeatureCollection([]geo.Feature{requestFeature(requests[0].Summary()), requestFeature(requests[1].Summary())}))
+	req := events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"format": "geojson"}}
+	if body, _, _ := listingBody(req, requests); body != string(want) {
+		t.Errorf("listingBody(format=geojson) = %s, want %s", body, want)
+	}
+	wantProtobuf, _ := wire.Requests(requests)
+	req = events.APIGatewayProxyRequest{Headers: map[string]string{"Accept": wire.ContentTypeProtobuf}}
+	if body, _, _ := listingBody(req, requests); body != wantProtobuf {
+		t.Errorf("listingBody(Accept protobuf) = %s, want %s", body, wantProtobuf)
+	}
+	if body, _, _ := listingBody(events.APIGatewayProxyRequest{}, nil); body != "[]" {
+		t.Errorf("listingBody() of no requests = %s, want []", body)
+	}
+
+	// A listing over its budget refuses further requests, but always takes the first
+	w, _ := newListingWriter(events.APIGatewayProxyRequest{}, 10)
+	if written, err := w.add(requests[0]); !written || err != nil {
+		t.Errorf("add() of first request = %t, %v, want true", written, err)
+	}
+	if written, err := w.add(requests[1]); written || err != nil {
+		t.Errorf("add() over budget = %t, %v, want false", written, err)
+	}
+	first, _ := json.Marshal(requests[0].Summary())
+	if body, _ := w.close(); body != "["+string(first)+"]" {
+		t.Errorf("close() = %s, want only the first request", body)
+	}
+}
+
+// Selected fields are all a listing's entries carry, and unknown fields are refused
+func TestListingFields(t *testing.T) {
+	requests := []repository.Request{{ServiceRequestID: "01ABC", Status: "open", Address: "1 Monument Sq", Location: repository.Location{Latitude: 42.5, Longitude: -73.5}}}
+
+	req := events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"fields": "service_request_id,status,lat,lon"}}
+	want := `[{"lat":42.5,"lon":-73.5,"service_request_id":"01ABC","status":"open"}]`
+	if body, _, err := listingBody(req, requests); err != nil || body != want {
+		t.Errorf("listingBody(fields) = %s, %v, want %s", body, err, want)
+	}
+
+	req.QueryStringParameters["format"] = "geojson"
+	body, _, _ := listingBody(req, requests)
+	collection := geo.FeatureCollection{}
+	if err := json.Unmarshal([]byte(body), &collection); err != nil || len(collection.Features) != 1 {
+		t.Fatalf("listingBody(fields, format=geojson) = %s, %v", body, err)
+	}
+	// A point's lat and lon are its coordinates rather than properties
+	if feature := collection.Features[0]; feature.Geometry == nil || len(feature.Properties) != 2 || feature.Properties["status"] != "open" {
+		t.Errorf("listingBody(fields, format=geojson) feature = %+v, want its point and only the status and ID properties", feature)
+	}
+
+	req = events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"fields": "status,description"}}
+	if _, _, err := listingBody(req, requests); err == nil {
+		t.Error("listingBody(fields=status,description) should fail, as listings don't carry descriptions")
+	}
+	if _, err := listingFields(req); err == nil {
+		t.Error("listingFields(fields=status,description) should fail")
+	}
+}
+
+func TestRequestsLimit(t *testing.T) {
+	admin := map[string]interface{}{"cognito:groups": "[city_admin]", "custom:city": "Troy"}
+	anyCity := map[string]interface{}{"cognito:groups": "[city_admin]"}
+	resident := map[string]interface{}{"cognito:groups": "[residents]", "custom:city": "Troy"}
+	tests := []struct {
+		limit   string
+		claims  map[string]interface{}
+		want    int
+		wantErr bool
+	}{
+		{"", nil, defaultRequestsLimit, false},
+		{"25", nil, 25, false},
+		{"1001", nil, 0, true},
+		{"0", nil, 0, true},
+		{"all", nil, 0, true},
+		{"all", resident, 0, true},
+		{"all", anyCity, 0, true},
+		{"all", admin, 0, false},
+	}
+	for _, tt := range tests {
+		req := events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"city_id": "Albany"}}
+		if tt.limit != "" {
+			req.QueryStringParameters["limit"] = tt.limit
+		}
+		if tt.claims != nil {
+			req.RequestContext.Authorizer = map[string]interface{}{"claims": tt.claims}
+		}
+		got, err := requestsLimit(req)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("requestsLimit(limit=%q, claims %v) = %d, %v, want %d", tt.limit, tt.claims, got, err, tt.want)
+		}
+	}
+}
+
+func TestNextPage(t *testing.T) {
+	req := events.APIGatewayProxyRequest{
+		Path:                  "/requests",
+		Headers:               map[string]string{"Host": "api.example.com"},
+		QueryStringParameters: map[string]string{"city_id": "Troy", "cursor": "old"},
+		RequestContext:        events.APIGatewayProxyRequestContext{Stage: "prod"},
+	}
+	want := "https://api.example.com/prod/requests?city_id=Troy&cursor=bmV3"
+	if got := nextPage(req, "bmV3"); got != want {
+		t.Errorf("nextPage() = %s, want %s", got, want)
+	}
+}
+
+func TestRequestURL(t *testing.T) {
+	req := events.APIGatewayProxyRequest{
+		Path:           "/requests",
+		Headers:        map[string]string{"Host": "api.example.com"},
+		RequestContext: events.APIGatewayProxyRequestContext{Stage: "prod"},
+	}
+	want := "https://api.example.com/prod/request/SR-01ARZ3NDEKTSV4RRFFQ69G5FAV"
+	if got := requestURL(req, "SR-01ARZ3NDEKTSV4RRFFQ69G5FAV"); got != want {
+		t.Errorf("requestURL() = %s, want %s", got, want)
+	}
+	// Federated cities may use IDs that need escaping
+	if got, want := requestURL(req, "queued/42"), "https://api.example.com/prod/request/queued%2F42"; got != want {
+		t.Errorf("requestURL() = %s, want %s", got, want)
+	}
+}
+
+func TestRequestInput(t *testing.T) {
+	request, update, err := requestInput(`{"service_code": "pothole", "status": "closed", "address": "1 Monument Sq"}`)
+	if err != nil || update != nil || request.Status != "" || request.Address != "1 Monument Sq" {
+		t.Errorf("requestInput() of a submission = %+v, %+v, %v, want no status and no update", request, update, err)
+	}
+
+	request, update, err = requestInput(`{"service_request_id": "SR-1", "status": "closed", "account_id": "someone"}`)
+	if err != nil || update == nil || request.ServiceRequestID != "SR-1" || request.Status != "closed" || request.AccountID != "" {
+		t.Errorf("requestInput() of an update = %+v, %+v, %v, want the status updated and no account", request, update, err)
+	}
+
+	if _, _, err := requestInput(`{"service_code": `); err == nil {
+		t.Error("requestInput() of invalid JSON should fail")
+	}
+}
+
+func TestDeactivationNotice(t *testing.T) {
+	city := repository.City{CityName: "Troy", Deactivated: true}
+	if got, want := deactivationNotice(city), "Troy is not taking new requests through the app right now. Please try again later"; got != want {
+		t.Errorf("deactivationNotice() = %q, want %q", got, want)
+	}
+
+	city.Config.Contact.Phone = "518-270-4400"
+	if got := deactivationNotice(city); !strings.HasSuffix(got, "or call 518-270-4400") {
+		t.Errorf("deactivationNotice() = %q, want the city's phone", got)
+	}
+
+	city.DeactivationNotice = "Back on Monday"
+	if got := deactivationNotice(city); got != "Back on Monday" {
+		t.Errorf("deactivationNotice() = %q, want the city's own notice", got)
+	}
+}
+
+func TestStatsPeriod(t *testing.T) {
+	tests := map[string]int{"": 30, "7": 7, "7d": 7, "12w": 84, "366d": 366}
+	for period, want := range tests {
+		if got, err := statsPeriod(period); err != nil || got != want {
+			t.Errorf("statsPeriod(%q) = %d, %v, want %d", period, got, err, want)
+		}
+	}
+	for _, period := range []string{"0d", "-3d", "53w", "367", "month", "d"} {
+		if _, err := statsPeriod(period); err == nil {
+			t.Errorf("statsPeriod(%q) accepted", period)
+		}
+	}
+}
+
+func TestRequestsRange(t *testing.T) {
+	now := time.Date(2019, 6, 30, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	tests := []struct {
+		start, end         string
+		wantStart, wantEnd time.Time
+	}{
+		{"", "", now.Add(-requestsWindow), now},
+		{"2019-06-01T00:00:00Z", "", time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC), now},
+		{"", "2019-06-01T00:00:00Z", time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC).Add(-requestsWindow), time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"2019-01-01T00:00:00Z", "", time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC).Add(90 * day)},
+	}
+	for _, test := range tests {
+		req := events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{}}
+		if test.start != "" {
+			req.QueryStringParameters["start_date"] = test.start
+		}
+		if test.end != "" {
+			req.QueryStringParameters["end_date"] = test.end
+		}
+		start, end, err := requestsRange(req, now)
+		if err != nil || !start.Equal(test.wantStart) || !end.Equal(test.wantEnd) {
+			t.Errorf("requestsRange(%q, %q) = %s, %s, %v, want %s, %s", test.start, test.end, start, end, err, test.wantStart, test.wantEnd)
+		}
+	}
+
+	for _, params := range []map[string]string{
+		{"start_date": "June 1st"},
+		{"start_date": "2019-06-02T00:00:00Z", "end_date": "2019-06-01T00:00:00Z"},
+		{"start_date": "2019-01-01T00:00:00Z", "end_date": "2019-06-01T00:00:00Z"},
+	} {
+		if _, _, err := requestsRange(events.APIGatewayProxyRequest{QueryStringParameters: params}, now); err == nil {
+			t.Errorf("requestsRange(%v) should fail", params)
+		}
+	}
+}
+
+func TestFeedRequests(t *testing.T) {
+	requests := []repository.Request{
+		{ServiceRequestID: "r1", ServiceCode: "pothole", Neighborhood: "north", RequestedDateTime: "2020-03-01T09:00:00Z"},
+		{ServiceRequestID: "r2", ServiceCode: "graffiti", Neighborhood: "north", RequestedDateTime: "2020-03-02T09:00:00Z"},
+		{ServiceRequestID: "r3", ServiceCode: "pothole", Neighborhood: "south", RequestedDateTime: "2020-03-03T09:00:00Z"},
+	}
+
+	ids := func(requests []repository.Request) string {
+		s := []string{}
+		for _, r := range requests {
+			s = append(s, r.ServiceRequestID)
+		}
+		return strings.Join(s, ",")
+	}
+	if got := ids(feedRequests(requests, "", "")); got != "r3,r2,r1" {
+		t.Errorf("feedRequests() = %s, want every request newest first", got)
+	}
+	if got := ids(feedRequests(requests, "pothole", "")); got != "r3,r1" {
+		t.Errorf("feedRequests(pothole) = %s, want r3,r1", got)
+	}
+	if got := ids(feedRequests(requests, "pothole", "north")); got != "r1" {
+		t.Errorf("feedRequests(pothole, north) = %s, want r1", got)
+	}
+}
+
+func TestRequestsFeed(t *testing.T) {
+	city := repository.City{CityName: "troy"}
+	requests := []repository.Request{{
+		ServiceRequestID:  "r1",
+		Status:            repository.RequestOpen,
+		ServiceCode:       "pothole",
+		ServiceName:       "Pothole",
+		Description:       "Call Jane on 555-0100",
+		Address:           "12 Elm St",
+		Location:          repository.Location{Latitude: 42.7284, Longitude: -73.6918},
+		AccountID:         "acct-1",
+		RequestedDateTime: "2020-03-01T09:00:00Z",
+		UpdatedDateTime:   "2020-03-02T09:00:00Z",
+	}}
+
+	now := time.Date(2020, 3, 3, 0, 0, 0, 0, time.UTC)
+	feed := requestsFeed(city, requests, "https://api.example.com/Prod", now)
+	if feed.Updated != "2020-03-02T09:00:00Z" || len(feed.Entries) != 1 {
+		t.Fatalf("requestsFeed() = %+v, want one entry updated with the request", feed)
+	}
+	entry := feed.Entries[0]
+	if entry.Title != "Pothole at 12 Elm St" || entry.Link.Href != "https://api.example.com/Prod/request/r1" || entry.Point != "42.7284 -73.6918" {
+		t.Errorf("entry = %+v", entry)
+	}
+
+	body, err := xml.Marshal(feed)
+	if err != nil {
+		t.Fatalf("xml.Marshal() error = %s", err)
+	}
+	for _, want := range []string{`<feed xmlns="http://www.w3.org/2005/Atom"`, `xmlns:georss="http://www.georss.org/georss"`, `<georss:point>42.7284 -73.6918</georss:point>`} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("feed = %s, want %s", body, want)
+		}
+	}
+	for _, pii := range []string{"Jane", "acct-"} {
+		if strings.Contains(string(body), pii) {
+			t.Errorf("feed published %q", pii)
+		}
+	}
+
+	city.Config.OpenData.RedactFields = []string{"address", "location"}
+	entry = requestsFeed(city, requests, "", now).Entries[0]
+	if entry.Title != "Pothole" || entry.Point != "" {
+		t.Errorf("entry = %+v, want address and location redacted", entry)
+	}
+
+	if feed := requestsFeed(city, nil, "", now); feed.Updated != "2020-03-03T00:00:00Z" {
+		t.Errorf("empty feed updated = %s, want now", feed.Updated)
+	}
+}
+
+func TestSubmitError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{&repository.RequestIdNotFoundErr{}, http.StatusNotFound},
+		{&repository.RequestIdConflictErr{}, http.StatusConflict},
+		{&repository.RequestChangedErr{}, http.StatusConflict},
+		{errors.New("throttled"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if resp, _ := submitError(tt.err, "SR-1"); resp.StatusCode != tt.want {
+			t.Errorf("submitError(%T) = %d, want %d", tt.err, resp.StatusCode, tt.want)
+		}
+	}
+}
+
+// Reviews are refused before anything is read unless a city admin sends a decision
+func TestReviewRequestMedia(t *testing.T) {
+	resident := events.APIGatewayProxyRequest{Body: `{"media_key": "troy/a.jpg", "moderation_status": "approved"}`}
+	if resp, _ := reviewRequestMedia("SR-1", resident); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("reviewRequestMedia() by a resident = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+
+	admin := events.APIGatewayProxyRequest{
+		Body: `{"media_key": "troy/a.jpg", "moderation_status": "flagged"}`,
+		RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{
+			"claims": map[string]interface{}{"cognito:groups": "[city_admin]", "custom:city": "troy"},
+		}},
+	}
+	if resp, _ := reviewRequestMedia("SR-1", admin); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("reviewRequestMedia() of a status other than approved or rejected = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
 }
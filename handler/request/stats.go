@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/repository"
+)
+
+// Periods of request stats: the default, and the longest the daily counters are read for
+const (
+	defaultStatsPeriod = 30
+	maxStatsPeriod     = 366
+)
+
+// getRequestStats counts a city's requests over a period, grouped by status, service_code or agency, from the
+// counters the stream processor keeps.  Unlike GET /requests, no request is read.
+func getRequestStats(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	city := cityID(req)
+	if city == "" {
+		return clientError(http.StatusBadRequest, errors.New("city_id must be specified, since stats are kept per city"))
+	}
+
+	groupBy := req.QueryStringParameters["group_by"]
+	switch groupBy {
+	case repository.StatsByStatus, repository.StatsByService, repository.StatsByAgency:
+	case "":
+		groupBy = repository.StatsByStatus
+	default:
+		return clientError(http.StatusBadRequest, fmt.Errorf("group_by '%s' must be status, service_code or agency", groupBy))
+	}
+
+	n, err := statsPeriod(req.QueryStringParameters["period"])
+	if err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+
+	config, err := repository.GetCityConfig(city)
+	if err != nil {
+		switch err.(type) {
+		case *repository.CityNotFoundErr:
+			errorMessage := fmt.Errorf("%s.  city_id '%s' not in database", err, city)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	daily, err := repository.GetDailyStats(city, periodDays(time.Now().In(config.Location()), n))
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	var current map[string]int64
+	if groupBy == repository.StatsByStatus {
+		current, err = repository.GetStatusCounts(city)
+		if err != nil {
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	stats := repository.GroupStats(daily, groupBy, current)
+	stats.CityID = city
+
+	body, err := json.Marshal(stats)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling GroupStats() struct"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// statsPeriod parses the period of request stats, a number of days or weeks such as "7d" or "12w", into days
+func statsPeriod(period string) (int, error) {
+	if period == "" {
+		return defaultStatsPeriod, nil
+	}
+
+	unit := 1
+	switch {
+	case strings.HasSuffix(period, "d"):
+		period = strings.TrimSuffix(period, "d")
+	case strings.HasSuffix(period, "w"):
+		period = strings.TrimSuffix(period, "w")
+		unit = 7
+	}
+
+	n, err := strconv.Atoi(period)
+	if err != nil || n < 1 || n*unit > maxStatsPeriod {
+		return 0, fmt.Errorf("period must be a number of days or weeks, eg 30d or 12w, of at most %d days", maxStatsPeriod)
+	}
+	return n * unit, nil
+}
+
+// periodDays returns the n days ending today, oldest first, as YYYY-MM-DD
+func periodDays(today time.Time, n int) []string {
+	days := make([]string, n)
+	for i := range days {
+		days[i] = today.AddDate(0, 0, i-n+1).Format("2006-01-02")
+	}
+	return days
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/repository"
+)
+
+// trendingWindows are the windows trends are looked for in, by the window query parameter
+var trendingWindows = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+}
+
+// trendingResponse is the body of GET /analytics/trending
+type trendingResponse struct {
+	CityID       string             `json:"city_id"`
+	Window       string             `json:"window"`
+	Start        string             `json:"start"` // Start of the window, RFC3339
+	End          string             `json:"end"`
+	BaselineDays []string           `json:"baseline_days"` // Days the window is compared with, YYYY-MM-DD in the city's time zone
+	Trends       []repository.Trend `json:"trends"`
+}
+
+// getTrending surfaces the services of a city with unusual volume over the last 24 hours or 7 days, compared with
+// the days before, so cities spot a water main break or storm damage early.  With by=neighborhood, or a
+// neighborhood geo_cell, services are compared neighborhood by neighborhood.
+func getTrending(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	city := cityID(req)
+	if city == "" {
+		return clientError(http.StatusBadRequest, errors.New("city_id must be specified, since trends are found per city"))
+	}
+
+	windowName := req.QueryStringParameters["window"]
+	if windowName == "" {
+		windowName = "24h"
+	}
+	window, ok := trendingWindows[windowName]
+	if !ok {
+		return clientError(http.StatusBadRequest, fmt.Errorf("window '%s' must be 24h or 7d", windowName))
+	}
+
+	neighborhood := req.QueryStringParameters["neighborhood"]
+	if neighborhood != "" && len(neighborhood) != repository.GeoCellPrecision {
+		return clientError(http.StatusBadRequest, fmt.Errorf("neighborhood must be a geo_cell of %d characters", repository.GeoCellPrecision))
+	}
+	byNeighborhood := neighborhood != "" || req.QueryStringParameters["by"] == "neighborhood"
+
+	config, err := repository.GetCityConfig(city)
+	if err != nil {
+		switch err.(type) {
+		case *repository.CityNotFoundErr:
+			errorMessage := fmt.Errorf("%s.  city_id '%s' not in database", err, city)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	end := time.Now()
+	start := end.Add(-window)
+
+	recent, err := repository.GetRecentRequests(city, start)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	days := repository.BaselineDays(start, config.Location())
+	baseline, err := repository.GetDailyStats(city, days)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	trends := []repository.Trend{}
+	for _, trend := range repository.Trends(recent, baseline, window, byNeighborhood) {
+		if neighborhood == "" || trend.Neighborhood == neighborhood {
+			trends = append(trends, trend)
+		}
+	}
+
+	body, err := json.Marshal(trendingResponse{
+		CityID:       city,
+		Window:       windowName,
+		Start:        start.UTC().Format(time.RFC3339),
+		End:          end.UTC().Format(time.RFC3339),
+		BaselineDays: days,
+		Trends:       trends,
+	})
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling Trends() struct"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/social-torch/open311-services/awsclient"
+	"github.com/social-torch/open311-services/repository"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+
+// defaultArchiveDays is used when neither the city nor ARCHIVE_AFTER_DAYS configures an archive period
+const defaultArchiveDays = 90
+
+// handler runs daily, archiving media of requests closed longer than the archive period and deleting media of
+// requests closed longer than their city's retention period.  Media that can't be moved is logged and left for the
+// next run, without holding up the rest.
+func handler(event events.CloudWatchEvent) error {
+	archiveDays := defaultArchiveDays
+	if v, err := strconv.Atoi(os.Getenv("ARCHIVE_AFTER_DAYS")); err == nil && v > 0 {
+		archiveDays = v
+	}
+
+	requests, err := repository.GetRequests("")
+	if err != nil {
+		return err
+	}
+
+	cities := map[string]repository.City{}
+	now := time.Now()
+	failures := 0
+
+	for _, request := range requests {
+		if request.Status != repository.RequestClosed {
+			continue
+		}
+
+		closed, err := closedAt(request)
+		if err != nil {
+			warningLogger.Printf("Request %s has no valid close date", request.ServiceRequestID)
+			continue
+		}
+		closedDays := int(now.Sub(closed).Hours() / 24)
+		if closedDays < archiveDays {
+			continue
+		}
+
+		media, err := repository.GetRequestMedia(request.CityID, request.ServiceRequestID)
+		if err != nil {
+			warningLogger.Printf("Unable to read media of request %s: %s", request.ServiceRequestID, err)
+			failures++
+			continue
+		}
+
+		for _, m := range media {
+			if m.StorageStatus == repository.MediaDeleted {
+				continue
+			}
+
+			city, bucket, err := cityPolicy(cities, m.City)
+			if err != nil {
+				warningLogger.Printf("Unable to read the retention policy of media %s: %s", m.Key, err)
+				failures++
+				continue
+			}
+
+			// A city pinned to a region keeps its bucket there
+			svc := s3.New(awsclient.SessionIn(city.Region))
+			switch storageAction(city, archiveDays, closedDays, m) {
+			case repository.MediaDeleted:
+				err = deleteMedia(svc, bucket, m)
+			case repository.MediaArchived:
+				err = archiveMedia(svc, bucket, m)
+			}
+			if err != nil {
+				warningLogger.Printf("Unable to move media %s of request %s: %s", m.Key, m.ServiceRequestID, err)
+				failures++
+			}
+		}
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("retention: %d media could not be moved; they are retried on the next run", failures)
+	}
+	return nil
+}
+
+// closedAt returns when a request was closed.  Requests closed before closed_datetime was recorded fall back to
+// their last update, which closing them was.
+func closedAt(request repository.Request) (time.Time, error) {
+	if request.ClosedDateTime != "" {
+		return time.Parse(time.RFC3339, request.ClosedDateTime)
+	}
+	return time.Parse(time.RFC3339, request.UpdatedDateTime)
+}
+
+// storageAction returns the storage status media of a request closed closedDays ago should move to under its city's
+// policy, or "" to leave it as it is.  The city's archive period overrides archiveDays.
+func storageAction(city repository.City, archiveDays int, closedDays int, m repository.Media) string {
+	if city.MediaArchiveDays > 0 {
+		archiveDays = city.MediaArchiveDays
+	}
+
+	if city.MediaRetentionDays > 0 && closedDays >= city.MediaRetentionDays {
+		return repository.MediaDeleted
+	}
+	if closedDays >= archiveDays && m.StorageStatus != repository.MediaArchived {
+		return repository.MediaArchived
+	}
+	return ""
+}
+
+// cityPolicy returns the city owning a piece of media and the bucket holding it, caching cities across requests
+func cityPolicy(cities map[string]repository.City, name string) (repository.City, string, error) {
+	bucket := os.Getenv("IMAGE_BUCKET")
+	if name == "" {
+		return repository.City{}, bucket, nil
+	}
+
+	city, ok := cities[name]
+	if !ok {
+		var err error
+		city, err = repository.GetCity(name)
+		if err != nil {
+			return city, bucket, err
+		}
+		cities[name] = city
+	}
+
+	if city.MediaBucket != "" {
+		bucket = city.MediaBucket
+	}
+	return city, bucket, nil
+}
+
+// archiveMedia transitions an upload and its variants to Glacier by copying each object onto itself
+func archiveMedia(svc *s3.S3, bucket string, m repository.Media) error {
+	for _, key := range mediaKeys(m) {
+		_, err := svc.CopyObject(&s3.CopyObjectInput{
+			Bucket:       aws.String(bucket),
+			Key:          aws.String(key),
+			CopySource:   aws.String(bucket + "/" + url.PathEscape(key)),
+			StorageClass: aws.String(s3.StorageClassGlacier),
+		})
+		if err != nil {
+			return fmt.Errorf("unable to archive '%s': %s", key, err)
+		}
+	}
+
+	infoLogger.Printf("Archived media %s of request %s", m.Key, m.ServiceRequestID)
+	return repository.SetMediaStorageStatus(m.Key, repository.MediaArchived)
+}
+
+// deleteMedia removes an upload, its variants and any original preserved by image normalization
+// per the city's retention policy
+func deleteMedia(svc *s3.S3, bucket string, m repository.Media) error {
+	for _, key := range append(mediaKeys(m), "originals/"+m.Key) {
+		_, err := svc.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return fmt.Errorf("unable to delete '%s': %s", key, err)
+		}
+	}
+
+	infoLogger.Printf("Deleted media %s of request %s", m.Key, m.ServiceRequestID)
+	return repository.SetMediaStorageStatus(m.Key, repository.MediaDeleted)
+}
+
+// mediaKeys lists the keys of an upload and all of its variants
+func mediaKeys(m repository.Media) []string {
+	keys := []string{m.Key}
+	for _, v := range m.Variants {
+		keys = append(keys, v.Key)
+	}
+	return keys
+}
+
+func main() {
+	lambda.Start(handler)
+}
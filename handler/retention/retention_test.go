@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestStorageAction(t *testing.T) {
+	active := repository.Media{StorageStatus: repository.MediaActive}
+	archived := repository.Media{StorageStatus: repository.MediaArchived}
+
+	tests := []struct {
+		name       string
+		city       repository.City
+		closedDays int
+		media      repository.Media
+		want       string
+	}{
+		{"recently closed", repository.City{}, 10, active, ""},
+		{"default archive period", repository.City{}, 90, active, repository.MediaArchived},
+		{"already archived", repository.City{}, 200, archived, ""},
+		{"no retention period", repository.City{}, 5000, archived, ""},
+		{"city archive period", repository.City{MediaArchiveDays: 30}, 30, active, repository.MediaArchived},
+		{"before city archive period", repository.City{MediaArchiveDays: 120}, 90, active, ""},
+		{"before retention period", repository.City{MediaRetentionDays: 365}, 364, archived, ""},
+		{"retention period", repository.City{MediaRetentionDays: 365}, 365, archived, repository.MediaDeleted},
+		{"retention before archive", repository.City{MediaRetentionDays: 30}, 30, active, repository.MediaDeleted},
+	}
+
+	for _, test := range tests {
+		if got := storageAction(test.city, 90, test.closedDays, test.media); got != test.want {
+			t.Errorf("storageAction(%s) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestClosedAt(t *testing.T) {
+	request := repository.Request{ClosedDateTime: "2019-01-02T00:00:00Z", UpdatedDateTime: "2019-06-01T00:00:00Z"}
+	if closed, err := closedAt(request); err != nil || closed.Format("2006-01-02") != "2019-01-02" {
+		t.Errorf("closedAt() = %v, %v, want closed_datetime, not the later edit", closed, err)
+	}
+
+	request = repository.Request{UpdatedDateTime: "2019-06-01T00:00:00Z"}
+	if closed, err := closedAt(request); err != nil || closed.Format("2006-01-02") != "2019-06-01" {
+		t.Errorf("closedAt(no closed_datetime) = %v, %v, want the last update", closed, err)
+	}
+}
+
+func TestMediaKeys(t *testing.T) {
+	m := repository.Media{Key: "albany/a.mp4", Variants: []repository.MediaVariant{{Key: "albany/a-720p.mp4"}, {Key: "albany/a.jpg"}}}
+	want := []string{"albany/a.mp4", "albany/a-720p.mp4", "albany/a.jpg"}
+	if got := mediaKeys(m); !reflect.DeepEqual(got, want) {
+		t.Errorf("mediaKeys() = %v, want %v", got, want)
+	}
+
+	if got := mediaKeys(repository.Media{Key: "a.jpg"}); !reflect.DeepEqual(got, []string{"a.jpg"}) {
+		t.Errorf("mediaKeys(no variants) = %v, want [a.jpg]", got)
+	}
+}
@@ -7,33 +7,79 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"regexp"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/social-torch/open311-services/apierror"
+	"github.com/social-torch/open311-services/auth"
+	"github.com/social-torch/open311-services/federation"
+	"github.com/social-torch/open311-services/metrics"
 	"github.com/social-torch/open311-services/repository"
+	"github.com/social-torch/open311-services/snapshot"
+	"github.com/social-torch/open311-services/warmup"
+	"github.com/social-torch/open311-services/wire"
 )
 
 var infoLogger = log.New(os.Stdout, "INFO\t", 0)
 var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
 var errorLogger = log.New(os.Stderr, "ERROR\t", log.Lshortfile)
 
+// serviceCodePattern limits service codes to characters that are safe in URL paths
+var serviceCodePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// Open311 service types
+var serviceTypes = map[string]bool{"realtime": true, "batch": true, "blackbox": true}
+
 // Route requests
 func router(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	switch req.HTTPMethod {
 	case "GET":
+		// Cities with their own Open311 server are answered by it
+		city, err := federatedCity(cityID(req))
+		if err != nil {
+			return serverError(http.StatusInternalServerError, err)
+		}
+		if city.Federated {
+			return proxyServices(city, req.PathParameters["id"])
+		}
+
 		if req.Resource == "/service/{id}" {
 			id := req.PathParameters["id"]
-			return getService(id)
+			return getService(cityID(req), id)
 		}
 
 		if req.Resource == "/services" {
-			return getServices()
+			// Residents opening the app are sent to the static snapshot, sparing the table during spikes
+			if !auth.IsAdminOf(cityID(req), req) {
+				if location := snapshot.Location(req, cityID(req), snapshot.ServicesFile); location != "" {
+					return snapshot.Redirect(location)
+				}
+			}
+			return getServices(cityID(req), wire.Protobuf(wire.Accept(req.Headers)))
+		}
+
+	case "POST":
+		if req.Resource == "/services" {
+			return addService(req)
+		}
+
+	case "PUT":
+		if req.Resource == "/service/{id}" {
+			id := req.PathParameters["id"]
+			return updateService(id, req)
+		}
+
+	case "DELETE":
+		if req.Resource == "/service/{id}" {
+			id := req.PathParameters["id"]
+			return deleteService(id, req)
 		}
 	}
-	return clientError(http.StatusMethodNotAllowed, errors.New("method must be 'GET'"))
+	return clientError(http.StatusMethodNotAllowed, errors.New("method must be 'GET', 'POST', 'PUT' or 'DELETE'"))
 }
 
-func getService(id string) (events.APIGatewayProxyResponse, error) {
+func getService(cityID string, id string) (events.APIGatewayProxyResponse, error) {
 	service, err := repository.GetService(id)
 	if err != nil {
 		switch err.(type) {
@@ -45,6 +91,11 @@ func getService(id string) (events.APIGatewayProxyResponse, error) {
 		}
 	}
 
+	// Services of other cities are hidden from callers scoped to a city
+	if cityID != "" && service.CityID != cityID {
+		return clientError(http.StatusNotFound, fmt.Errorf("service_code '%s' not offered by %s", id, cityID))
+	}
+
 	body, err := json.Marshal(&service)
 	if err != nil {
 		return serverError(http.StatusInternalServerError, errors.New("error marshalling GetService() struct"))
@@ -57,12 +108,26 @@ func getService(id string) (events.APIGatewayProxyResponse, error) {
 	}, nil
 }
 
-func getServices() (events.APIGatewayProxyResponse, error) {
-	services, err := repository.GetServices()
+// getServices lists the services of a city, as a base64 encoded protobuf ListServicesResponse when asProtobuf
+func getServices(cityID string, asProtobuf bool) (events.APIGatewayProxyResponse, error) {
+	services, err := repository.GetServices(cityID)
 	if err != nil {
 		return serverError(http.StatusInternalServerError, err)
 	}
 
+	if asProtobuf {
+		body, err := wire.Services(services)
+		if err != nil {
+			return serverError(http.StatusInternalServerError, errors.New("error marshalling services as protobuf"))
+		}
+		return events.APIGatewayProxyResponse{
+			StatusCode:      http.StatusOK,
+			Headers:         map[string]string{"content-type": wire.ContentTypeProtobuf, "Access-Control-Allow-Origin": "*", "Vary": "Accept"},
+			Body:            body,
+			IsBase64Encoded: true,
+		}, nil
+	}
+
 	body, err := json.Marshal(services)
 	if err != nil {
 		return serverError(http.StatusInternalServerError, errors.New("error marshalling GetServices() struct"))
@@ -70,29 +135,276 @@ func getServices() (events.APIGatewayProxyResponse, error) {
 
 	return events.APIGatewayProxyResponse{
 		StatusCode: http.StatusOK,
-		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*", "Vary": "Accept"},
 		Body:       string(body),
 	}, nil
 }
 
-func serverError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
-	errorLogger.Println(err.Error())
+// federatedCity returns a city when it serves its services from its own GeoReport v2 server, else an empty City
+func federatedCity(cityID string) (repository.City, error) {
+	if cityID == "" {
+		return repository.City{}, nil
+	}
+
+	city, err := repository.GetCity(cityID)
+	if err != nil {
+		if _, ok := err.(*repository.CityNotFoundErr); ok {
+			return repository.City{}, nil
+		}
+		return repository.City{}, err
+	}
+	if !city.Federated {
+		return repository.City{}, nil
+	}
+	return city, nil
+}
+
+// proxyServices lists the services of a federated city from its server, or returns the one with code id when id
+// is not empty.  Errors the server blames on the caller are passed on; other failures are a bad gateway.
+func proxyServices(city repository.City, id string) (events.APIGatewayProxyResponse, error) {
+	services, err := federation.New(city).Services()
+	if err != nil {
+		if remote, ok := err.(*federation.RemoteErr); ok {
+			return clientError(remote.StatusCode, remote)
+		}
+		return serverError(http.StatusBadGateway, err)
+	}
+
+	if id == "" {
+		body, err := json.Marshal(services)
+		if err != nil {
+			return serverError(http.StatusInternalServerError, errors.New("error marshalling federated Services() struct"))
+		}
+
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusOK,
+			Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+			Body:       string(body),
+		}, nil
+	}
+
+	for _, service := range services {
+		if service.ServiceCode == id {
+			return serviceResponse(http.StatusOK, service)
+		}
+	}
+	return clientError(http.StatusNotFound, fmt.Errorf("service_code '%s' not offered by %s", id, city.CityName))
+}
+
+func addService(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var service repository.Service
+	err := json.Unmarshal([]byte(req.Body), &service)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, errors.New("error unmarshalling service JSON. Check syntax"))
+	}
+
+	if service.CityID == "" {
+		service.CityID = cityID(req)
+	}
+	if !auth.IsAdminOf(service.CityID, req) {
+		return clientError(http.StatusForbidden, fmt.Errorf("services of %s may only be managed by its city admins", service.CityID))
+	}
+
+	err = validateService(&service)
+	if err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+	if response, ok := checkGroup(service.Group); !ok {
+		return response, nil
+	}
+
+	err = repository.AddService(service)
+	if err != nil {
+		switch err.(type) {
+		case *repository.ServiceCodeAlreadyExistsErr:
+			errorMessage := fmt.Errorf("%s. service_code '%s' is taken", err, service.ServiceCode)
+			return clientError(http.StatusConflict, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	infoLogger.Printf("Service %s of %s added", service.ServiceCode, service.CityID)
+	return serviceResponse(http.StatusCreated, service)
+}
+
+func updateService(id string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	existing, err := repository.GetService(id)
+	if err != nil {
+		switch err.(type) {
+		case *repository.ServiceCodeNotFoundErr:
+			errorMessage := fmt.Errorf("%s. service_code '%s' not in database", err, id)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+	if !auth.IsAdminOf(existing.CityID, req) {
+		return clientError(http.StatusForbidden, fmt.Errorf("services of %s may only be managed by its city admins", existing.CityID))
+	}
+
+	var service repository.Service
+	err = json.Unmarshal([]byte(req.Body), &service)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, errors.New("error unmarshalling service JSON. Check syntax"))
+	}
+	if service.ServiceCode != "" && service.ServiceCode != id {
+		return clientError(http.StatusBadRequest, errors.New("service_code can't be changed. Add a new service and delete this one"))
+	}
+
+	// A service stays with the city that offers it
+	service.ServiceCode = id
+	service.CityID = existing.CityID
+
+	err = validateService(&service)
+	if err != nil {
+		return clientError(http.StatusBadRequest, err)
+	}
+	if response, ok := checkGroup(service.Group); !ok {
+		return response, nil
+	}
+
+	err = repository.UpdateService(service)
+	if err != nil {
+		switch err.(type) {
+		case *repository.ServiceCodeNotFoundErr:
+			errorMessage := fmt.Errorf("%s. service_code '%s' not in database", err, id)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	infoLogger.Printf("Service %s of %s updated", service.ServiceCode, service.CityID)
+	return serviceResponse(http.StatusOK, service)
+}
+
+func deleteService(id string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	service, err := repository.GetService(id)
+	if err == nil {
+		if !auth.IsAdminOf(service.CityID, req) {
+			return clientError(http.StatusForbidden, fmt.Errorf("services of %s may only be managed by its city admins", service.CityID))
+		}
+		err = repository.DeleteService(id)
+	}
+	if err != nil {
+		switch err.(type) {
+		case *repository.ServiceCodeNotFoundErr:
+			errorMessage := fmt.Errorf("%s. service_code '%s' not in database", err, id)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	infoLogger.Printf("Service %s of %s deleted", id, service.CityID)
+
 	return events.APIGatewayProxyResponse{
-		StatusCode: statusCode,
-		Headers:    map[string]string{"content-type": "text/plain"},
-		Body:       http.StatusText(statusCode) + ": " + err.Error(),
+		StatusCode: http.StatusNoContent,
+		Headers:    map[string]string{"Access-Control-Allow-Origin": "*"},
 	}, nil
 }
 
-func clientError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
-	warningLogger.Println(err.Error())
+// checkGroup checks that the group of a service names an agency, so requests for the service reach someone.  When
+// it doesn't, or the agency can't be looked up, the error response is returned with ok false.
+func checkGroup(group string) (response events.APIGatewayProxyResponse, ok bool) {
+	_, err := repository.GetAgency(group)
+	if err != nil {
+		switch err.(type) {
+		case *repository.AgencyNotFoundErr:
+			errorMessage := fmt.Errorf("%s. group '%s' is not an agency_id in the Agencies table", err, group)
+			response, _ = clientError(http.StatusBadRequest, errorMessage)
+		default:
+			response, _ = serverError(http.StatusInternalServerError, err)
+		}
+		return response, false
+	}
+	return response, true
+}
+
+// validateService checks the fields of a service, trimming its name, group and keywords.  Type defaults to
+// realtime, since requests are stored as soon as they are submitted.
+func validateService(service *repository.Service) error {
+	if !serviceCodePattern.MatchString(service.ServiceCode) {
+		return errors.New("service_code must be 1 to 64 letters, digits, '_', '.' or '-', starting with a letter or digit")
+	}
+
+	service.ServiceName = strings.TrimSpace(service.ServiceName)
+	if service.ServiceName == "" {
+		return errors.New("service_name must be specified")
+	}
+
+	service.Group = strings.TrimSpace(service.Group)
+	if service.Group == "" {
+		return errors.New("group must name the agency responsible for the service")
+	}
+
+	if service.Type == "" {
+		service.Type = "realtime"
+	}
+	if !serviceTypes[service.Type] {
+		return fmt.Errorf("type '%s' must be realtime, batch or blackbox", service.Type)
+	}
+
+	// Open311 clients fetch the definition of services with metadata, and none are served yet
+	if service.Metadata {
+		return errors.New("metadata can't be true until service definitions are supported")
+	}
+
+	if service.SLAHours < 0 {
+		return errors.New("sla_hours can't be negative. Use 0 for no service level agreement")
+	}
+
+	keywords := []string{}
+	for _, k := range service.Keywords {
+		if k = strings.TrimSpace(k); k != "" {
+			keywords = append(keywords, k)
+		}
+	}
+	service.Keywords = keywords
+	return nil
+}
+
+func serviceResponse(statusCode int, service repository.Service) (events.APIGatewayProxyResponse, error) {
+	body, err := json.Marshal(service)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("unable to marshal JSON for response"))
+	}
+
 	return events.APIGatewayProxyResponse{
 		StatusCode: statusCode,
-		Headers:    map[string]string{"content-type": "text/plain"},
-		Body:       http.StatusText(statusCode) + ": " + err.Error(),
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
 	}, nil
 }
 
+// cityID returns the city a call is scoped to: the city in the caller's token, else the city_id or jurisdiction_id
+// query parameter, else the JURISDICTION this deployment serves.  It is "" for deployments that serve no city in
+// particular.
+func cityID(req events.APIGatewayProxyRequest) string {
+	if city := auth.StaffCity(req); city != "" {
+		return city
+	}
+	if city := req.QueryStringParameters["city_id"]; city != "" {
+		return city
+	}
+	// GeoReport v2 clients scope calls by jurisdiction_id, which the endpoints directory gives as the city_name
+	if city := req.QueryStringParameters["jurisdiction_id"]; city != "" {
+		return city
+	}
+	return os.Getenv("JURISDICTION")
+}
+
+func serverError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
+	errorLogger.Println(err.Error())
+	return apierror.Response(statusCode, err), nil
+}
+
+func clientError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
+	warningLogger.Println(err.Error())
+	return apierror.Response(statusCode, err), nil
+}
+
 func main() {
-	lambda.Start(router)
+	warmup.Start("service", metrics.Handler("service", apierror.Handler(repository.Audit(router))), repository.Warm)
 }
@@ -2,8 +2,42 @@ package main
 
 import (
 	"testing"
+
+	"github.com/social-torch/open311-services/repository"
 )
 
-func TestStub(t *testing.T) {
-	// see https://github.com/aws/aws-sdk-go/blob/master/example/service/dynamodb/unitTest/unitTest_test.go
+func TestValidateService(t *testing.T) {
+	valid := func() repository.Service {
+		return repository.Service{ServiceCode: "pothole-01", ServiceName: " Pothole ", Group: "public_works", Keywords: []string{"road", " ", "hole "}}
+	}
+
+	service := valid()
+	if err := validateService(&service); err != nil {
+		t.Fatalf("validateService(%+v) = %s, want nil", service, err)
+	}
+	if service.ServiceName != "Pothole" || service.Type != "realtime" || len(service.Keywords) != 2 || service.Keywords[1] != "hole" {
+		t.Errorf("validateService did not normalize: %+v", service)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*repository.Service)
+	}{
+		{"empty code", func(s *repository.Service) { s.ServiceCode = "" }},
+		{"code with slash", func(s *repository.Service) { s.ServiceCode = "pot/hole" }},
+		{"code starting with dash", func(s *repository.Service) { s.ServiceCode = "-pothole" }},
+		{"blank name", func(s *repository.Service) { s.ServiceName = "  " }},
+		{"no group", func(s *repository.Service) { s.Group = "" }},
+		{"unknown type", func(s *repository.Service) { s.Type = "instant" }},
+		{"metadata", func(s *repository.Service) { s.Metadata = true }},
+		{"negative SLA", func(s *repository.Service) { s.SLAHours = -1 }},
+	}
+
+	for _, tt := range tests {
+		service := valid()
+		tt.modify(&service)
+		if err := validateService(&service); err == nil {
+			t.Errorf("validateService with %s = nil, want error", tt.name)
+		}
+	}
 }
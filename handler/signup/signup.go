@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/social-torch/open311-services/repository"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+
+// handler is the Cognito post confirmation trigger.  It counts each resident who confirms their account towards the
+// signups of their city.
+func handler(event events.CognitoEventUserPoolsPostConfirmation) (events.CognitoEventUserPoolsPostConfirmation, error) {
+	city := signupCity(event)
+	if city == "" {
+		infoLogger.Printf("%s signed up without a city", event.UserName)
+		return event, nil
+	}
+
+	// An error returned here would fail the resident's confirmation, so a missed count is only logged
+	err := repository.RecordSignup(city, time.Now())
+	if err != nil {
+		warningLogger.Printf("Unable to record signup of %s with %s: %s", event.UserName, city, err)
+	}
+	return event, nil
+}
+
+// signupCity returns the city a resident signed up with: their custom:city attribute, else the city_id the app
+// passed as client metadata, else the deployment's jurisdiction
+func signupCity(event events.CognitoEventUserPoolsPostConfirmation) string {
+	if city := event.Request.UserAttributes["custom:city"]; city != "" {
+		return city
+	}
+	if city := event.Request.ClientMetadata["city_id"]; city != "" {
+		return city
+	}
+	return os.Getenv("JURISDICTION")
+}
+
+func main() {
+	lambda.Start(handler)
+}
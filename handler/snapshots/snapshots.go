@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/social-torch/open311-services/awsclient"
+	"github.com/social-torch/open311-services/repository"
+	"github.com/social-torch/open311-services/snapshot"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+
+// handler runs every minute, refreshing the snapshots of the services and recent requests of every city that has
+// switched on static_snapshots.  The requests and services handlers redirect plain listings to them.
+func handler(event events.CloudWatchEvent) error {
+	bucket := os.Getenv("SNAPSHOT_BUCKET")
+
+	cities, err := repository.GetCities()
+	if err != nil {
+		return err
+	}
+
+	svc := s3.New(awsclient.Session())
+	failed := 0
+
+	for _, city := range cities {
+		// Federated cities' listings are answered by their own servers, and cities pinned to another region are
+		// published by the stack there
+		if !city.Config.Enabled(repository.FeatureStaticSnapshots) || city.Federated || city.DataRegion() != repository.DataRegion() {
+			continue
+		}
+
+		if err := publish(svc, bucket, city.CityName, time.Now()); err != nil {
+			// One city's failure should not leave everyone else's snapshot stale
+			warningLogger.Printf("Unable to refresh snapshot of %s: %s", city.CityName, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("snapshots of %d cities not refreshed", failed)
+	}
+	return nil
+}
+
+// publish writes the snapshot of a city's services and of the requests made to it over the snapshot.RequestsWindow
+func publish(svc *s3.S3, bucket string, cityName string, now time.Time) error {
+	services, err := repository.GetServices(cityName)
+	if err != nil {
+		return err
+	}
+	requests, err := repository.GetRequestsBetween(cityName, now.Add(-snapshot.RequestsWindow), now)
+	if err != nil {
+		return err
+	}
+
+	servicesBody, err := json.Marshal(services)
+	if err != nil {
+		return fmt.Errorf("error marshalling services snapshot: %s", err)
+	}
+	requestsBody, err := json.Marshal(snapshotRequests(requests))
+	if err != nil {
+		return fmt.Errorf("error marshalling requests snapshot: %s", err)
+	}
+
+	if err := putObject(svc, bucket, snapshot.Key(cityName, snapshot.ServicesFile), servicesBody); err != nil {
+		return err
+	}
+	if err := putObject(svc, bucket, snapshot.Key(cityName, snapshot.RequestsFile), requestsBody); err != nil {
+		return err
+	}
+
+	infoLogger.Printf("Refreshed snapshot of %s: %d services, %d requests", cityName, len(services), len(requests))
+	return nil
+}
+
+// snapshotRequests returns requests as they are published in a snapshot
+func snapshotRequests(requests []repository.Request) []repository.RequestSummary {
+	published := make([]repository.RequestSummary, len(requests))
+	for i, request := range requests {
+		published[i] = snapshot.Request(request)
+	}
+	return published
+}
+
+func putObject(svc *s3.S3, bucket string, key string, body []byte) error {
+	_, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket:       aws.String(bucket),
+		Key:          aws.String(key),
+		Body:         bytes.NewReader(body),
+		ContentType:  aws.String("application/json"),
+		CacheControl: aws.String("max-age=" + strconv.Itoa(int(snapshot.MaxAge.Seconds()))),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to write '%s': %s", key, err)
+	}
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/social-torch/open311-services/awsclient"
+	"github.com/social-torch/open311-services/features"
+	"github.com/social-torch/open311-services/metrics"
+	"github.com/social-torch/open311-services/repository"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+
+// maxEntries is the most events EventBridge accepts in a single PutEvents call
+const maxEntries = 10
+
+// handler converts Requests table stream records into domain events and publishes them to EventBridge, after
+// updating the map pins of the requests they changed
+func handler(event events.DynamoDBEvent) error {
+	// Pins are updated first: a failure retries the batch before any of its events are published, and writing the
+	// same pins again is harmless
+	err := updatePins(event.Records)
+	if err != nil {
+		return err
+	}
+
+	entries := []*eventbridge.PutEventsRequestEntry{}
+	published := []repository.RequestEvent{}
+	history := []repository.RequestEvent{}
+	for _, record := range event.Records {
+		domainEvents, err := toDomainEvents(record)
+		if err != nil {
+			return err
+		}
+
+		// Requests imported from a city's previous 311 system are history: they are counted on the days they were
+		// made and closed, but nobody is told about them
+		if len(domainEvents) == 1 && domainEvents[0].Type == repository.RequestCreatedEvent && domainEvents[0].Request.ExternalID != "" {
+			history = append(history, historyEvents(domainEvents[0].Request)...)
+			continue
+		}
+		published = append(published, domainEvents...)
+
+		for _, e := range domainEvents {
+			detail, err := json.Marshal(e)
+			if err != nil {
+				return fmt.Errorf("unable to marshal %s event for %s: %s", e.Type, e.ServiceRequestID, err)
+			}
+
+			entries = append(entries, &eventbridge.PutEventsRequestEntry{
+				EventBusName: aws.String(os.Getenv("EVENT_BUS")),
+				Source:       aws.String(repository.EventSource),
+				DetailType:   aws.String(e.Type),
+				Detail:       aws.String(string(detail)),
+			})
+		}
+	}
+
+	svc := eventbridge.New(awsclient.Session())
+	for start := 0; start < len(entries); start += maxEntries {
+		end := start + maxEntries
+		if end > len(entries) {
+			end = len(entries)
+		}
+
+		result, err := svc.PutEvents(&eventbridge.PutEventsInput{Entries: entries[start:end]})
+		if err != nil {
+			return fmt.Errorf("unable to publish domain events: %s", err)
+		}
+
+		// Returning an error makes Lambda retry the batch, so consumers must tolerate duplicates
+		if aws.Int64Value(result.FailedEntryCount) > 0 {
+			return fmt.Errorf("%d domain events failed to publish", aws.Int64Value(result.FailedEntryCount))
+		}
+	}
+
+	infoLogger.Printf("Published %d domain events from %d stream records", len(entries), len(event.Records))
+
+	recordStats(append(published, history...))
+	recordMetrics(published)
+	return nil
+}
+
+// updatePins puts the pins of the requests created or changed by stream records, and deletes those of requests
+// deleted, moved to another geo cell or stripped of their location
+func updatePins(records []events.DynamoDBEventRecord) error {
+	for _, record := range records {
+		put, removed, err := pinChanges(record)
+		if err != nil {
+			return err
+		}
+		if removed != nil {
+			err = repository.DeletePin(*removed)
+			if err != nil {
+				return err
+			}
+		}
+		if put != nil {
+			err = repository.PutPin(*put)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pinChanges returns the pin a stream record puts and the pin it removes, either of which may be nil
+func pinChanges(record events.DynamoDBEventRecord) (*repository.Pin, *repository.Pin, error) {
+	var before, after *repository.Request
+	if record.EventName == "MODIFY" || record.EventName == "REMOVE" {
+		request, err := toRequest(record.Change.OldImage)
+		if err != nil {
+			return nil, nil, err
+		}
+		before = &request
+	}
+	if record.EventName == "INSERT" || record.EventName == "MODIFY" {
+		request, err := toRequest(record.Change.NewImage)
+		if err != nil {
+			return nil, nil, err
+		}
+		after = &request
+	}
+
+	put, removed := pinChange(before, after)
+	return put, removed, nil
+}
+
+// pinChange returns the pin to put and the pin to remove when a request changes from before to after, where nil
+// is a request that doesn't exist.  Changes that leave its pin as it was, eg a comment, change neither.
+func pinChange(before *repository.Request, after *repository.Request) (*repository.Pin, *repository.Pin) {
+	var previous, current repository.Pin
+	var hadPin, hasPin bool
+	if before != nil {
+		previous, hadPin = repository.RequestPin(*before)
+	}
+	if after != nil {
+		current, hasPin = repository.RequestPin(*after)
+	}
+
+	var put, removed *repository.Pin
+	if hasPin && (!hadPin || current != previous) {
+		put = &current
+	}
+	if hadPin && (!hasPin || current.GeoCell != previous.GeoCell) {
+		removed = &previous
+	}
+	return put, removed
+}
+
+// historyEvents returns the events an imported request would have raised in the city's previous system: its
+// creation when it was made, and its closing when it was closed
+func historyEvents(request repository.Request) []repository.RequestEvent {
+	created := request
+	if request.Status == repository.RequestClosed && request.ClosedDateTime != "" {
+		created.Status = repository.RequestOpen
+	}
+	raised := []repository.RequestEvent{
+		{Type: repository.RequestCreatedEvent, ServiceRequestID: request.ServiceRequestID, Request: created, Timestamp: request.RequestedDateTime},
+	}
+	if created.Status != request.Status {
+		raised = append(raised, repository.RequestEvent{
+			Type:             repository.StatusChangedEvent,
+			ServiceRequestID: request.ServiceRequestID,
+			Request:          request,
+			PreviousStatus:   repository.RequestOpen,
+			Timestamp:        request.ClosedDateTime,
+		})
+	}
+	return raised
+}
+
+// recordMetrics counts the requests submitted and closed in the batch, by city and then service
+func recordMetrics(domainEvents []repository.RequestEvent) {
+	for _, e := range domainEvents {
+		name := ""
+		switch {
+		case e.Type == repository.RequestCreatedEvent:
+			name = "RequestsSubmitted"
+		case e.Type == repository.StatusChangedEvent && e.Request.Status == repository.RequestClosed:
+			name = "RequestsClosed"
+		default:
+			continue
+		}
+		metrics.Count(name, metrics.Dimension{Name: "City", Value: e.Request.CityID}, metrics.Dimension{Name: "ServiceCode", Value: e.Request.ServiceCode})
+	}
+}
+
+// statsKey names the daily stats of a city
+type statsKey struct {
+	cityID string
+	day    string
+}
+
+// recordStats adds the batch's events to the pre-aggregated stats of their cities.  Failures are logged rather
+// than retried, since retrying the batch would publish its events again; the stats are a summary, not a ledger.
+func recordStats(domainEvents []repository.RequestEvent) {
+	daily, open, statuses := tally(domainEvents, cityLocation)
+
+	for key, stats := range daily {
+		err := repository.AddDailyStats(key.cityID, *stats)
+		if err != nil {
+			warningLogger.Println(err)
+		}
+	}
+	for cityID, delta := range open {
+		if delta == 0 {
+			continue
+		}
+		err := repository.AddOpenRequests(cityID, delta)
+		if err != nil {
+			warningLogger.Println(err)
+		}
+	}
+	for cityID, deltas := range statuses {
+		err := repository.AddStatusCounts(cityID, deltas)
+		if err != nil {
+			warningLogger.Println(err)
+		}
+	}
+}
+
+// cityLocation returns the time zone a city's days are counted in
+func cityLocation(cityID string) *time.Location {
+	config, err := features.Config(cityID)
+	if err != nil {
+		warningLogger.Printf("Counting stats of %s in UTC: %s", cityID, err)
+	}
+	return config.Location()
+}
+
+// tally totals the changes events make to the daily stats of their cities, to their counts of open requests and
+// to their counts of requests in each status.  Days are counted in each city's time zone.  Requests of no city in
+// particular aren't counted.
+func tally(domainEvents []repository.RequestEvent, location func(cityID string) *time.Location) (map[statsKey]*repository.DailyStats, map[string]int64, map[string]map[string]int64) {
+	daily := map[statsKey]*repository.DailyStats{}
+	open := map[string]int64{}
+	statuses := map[string]map[string]int64{}
+
+	for _, e := range domainEvents {
+		request := e.Request
+		if request.CityID == "" {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, e.Timestamp)
+		if err != nil {
+			warningLogger.Printf("Not counting %s event of %s: %s", e.Type, e.ServiceRequestID, err)
+			continue
+		}
+
+		key := statsKey{request.CityID, at.In(location(request.CityID)).Format("2006-01-02")}
+		change := repository.DailyStats{Statuses: map[string]int64{request.Status: 1}}
+		if statuses[request.CityID] == nil {
+			statuses[request.CityID] = map[string]int64{}
+		}
+
+		switch e.Type {
+		case repository.RequestCreatedEvent:
+			change.Opened = 1
+			change.Services = map[string]int64{request.ServiceCode: 1}
+			change.Agencies = map[string]int64{agencyOf(request): 1}
+			if request.GeoCell != "" {
+				change.Cells = map[string]int64{repository.CellServiceKey(request.GeoCell, request.ServiceCode): 1}
+			}
+			if request.Neighborhood != "" {
+				change.Neighborhoods = map[string]int64{request.Neighborhood: 1}
+			}
+			if request.Status != repository.RequestClosed {
+				open[request.CityID]++
+			}
+
+		case repository.StatusChangedEvent:
+			statuses[request.CityID][e.PreviousStatus]--
+			switch {
+			case request.Status == repository.RequestClosed:
+				change.Closed = 1
+				open[request.CityID]--
+				if request.Neighborhood != "" {
+					change.NeighborhoodsResolved = map[string]int64{request.Neighborhood: 1}
+				}
+				if hours, ok := resolutionHours(request, at); ok {
+					resolved := make([]int64, len(repository.ResolutionBuckets))
+					resolved[repository.ResolutionBucket(hours)] = 1
+					change.Resolved = resolved
+					change.ServiceResolved = map[string][]int64{request.ServiceCode: resolved}
+					change.AgencyResolved = map[string][]int64{agencyOf(request): resolved}
+				}
+			case e.PreviousStatus == repository.RequestClosed:
+				// Reopened
+				open[request.CityID]++
+			}
+
+		default:
+			continue
+		}
+
+		statuses[request.CityID][request.Status]++
+		if daily[key] == nil {
+			daily[key] = &repository.DailyStats{Day: key.day}
+		}
+		daily[key].Add(change)
+	}
+	return daily, open, statuses
+}
+
+// agencyOf returns the agency stats count a request under
+func agencyOf(request repository.Request) string {
+	if request.AgencyResponsible == "" {
+		return repository.UnassignedAgency
+	}
+	return request.AgencyResponsible
+}
+
+// resolutionHours returns how long a request closed at took to close: the time stored when it was closed, else
+// the time since it was made
+func resolutionHours(request repository.Request, at time.Time) (float64, bool) {
+	if request.ResolutionHours > 0 {
+		return request.ResolutionHours, true
+	}
+	requested, err := time.Parse(time.RFC3339, request.RequestedDateTime)
+	if err != nil {
+		return 0, false
+	}
+	return at.Sub(requested).Hours(), true
+}
+
+// toDomainEvents derives the domain events represented by a single stream record
+func toDomainEvents(record events.DynamoDBEventRecord) ([]repository.RequestEvent, error) {
+	timestamp := record.Change.ApproximateCreationDateTime.Format(time.RFC3339)
+
+	switch record.EventName {
+	case "INSERT":
+		request, err := toRequest(record.Change.NewImage)
+		if err != nil {
+			return nil, err
+		}
+		return []repository.RequestEvent{
+			{Type: repository.RequestCreatedEvent, ServiceRequestID: request.ServiceRequestID, Request: request, Timestamp: timestamp},
+		}, nil
+
+	case "MODIFY":
+		request, err := toRequest(record.Change.NewImage)
+		if err != nil {
+			return nil, err
+		}
+		previous, err := toRequest(record.Change.OldImage)
+		if err != nil {
+			return nil, err
+		}
+
+		domainEvents := []repository.RequestEvent{}
+		if request.Status != previous.Status {
+			domainEvents = append(domainEvents, repository.RequestEvent{
+				Type:             repository.StatusChangedEvent,
+				ServiceRequestID: request.ServiceRequestID,
+				Request:          request,
+				PreviousStatus:   previous.Status,
+				Timestamp:        timestamp,
+			})
+		}
+		if request.MediaURL != "" && request.MediaURL != previous.MediaURL {
+			domainEvents = append(domainEvents, repository.RequestEvent{
+				Type:             repository.MediaAddedEvent,
+				ServiceRequestID: request.ServiceRequestID,
+				Request:          request,
+				Timestamp:        timestamp,
+			})
+		}
+		return domainEvents, nil
+	}
+
+	return nil, nil
+}
+
+// toRequest unmarshals a stream image into a Request.  Stream attribute values marshal to the same
+// JSON shape as the SDK's dynamodb.AttributeValue, which lets dynamodbattribute do the work.
+func toRequest(image map[string]events.DynamoDBAttributeValue) (repository.Request, error) {
+	request := repository.Request{}
+
+	data, err := json.Marshal(image)
+	if err != nil {
+		return request, fmt.Errorf("unable to marshal stream image: %s", err)
+	}
+
+	item := map[string]*dynamodb.AttributeValue{}
+	err = json.Unmarshal(data, &item)
+	if err != nil {
+		return request, fmt.Errorf("unable to convert stream image: %s", err)
+	}
+
+	err = dynamodbattribute.UnmarshalMap(item, &request)
+	if err != nil {
+		return request, fmt.Errorf("unable to unmarshal request from stream image: %s", err)
+	}
+	return request, nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
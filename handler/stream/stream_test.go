@@ -0,0 +1,158 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestTally(t *testing.T) {
+	newYork, _ := time.LoadLocation("America/New_York")
+	location := func(cityID string) *time.Location {
+		if cityID == "Troy" {
+			return newYork
+		}
+		return time.UTC
+	}
+
+	troy := repository.Request{CityID: "Troy", ServiceCode: "pothole", Status: repository.RequestOpen, RequestedDateTime: "2019-06-01T12:00:00Z", Neighborhood: "ward-1"}
+	closed := troy
+	closed.Status = repository.RequestClosed
+	events := []repository.RequestEvent{
+		// 01:00 UTC is still the 1st in Troy
+		{Type: repository.RequestCreatedEvent, Request: troy, Timestamp: "2019-06-02T01:00:00Z"},
+		{Type: repository.StatusChangedEvent, Request: closed, PreviousStatus: repository.RequestOpen, Timestamp: "2019-06-03T00:00:00Z"},
+		{Type: repository.StatusChangedEvent, Request: troy, PreviousStatus: repository.RequestClosed, Timestamp: "2019-06-03T14:00:00Z"},
+		{Type: repository.RequestCreatedEvent, Request: repository.Request{CityID: "Albany", ServiceCode: "graffiti", GeoCell: "dreg0"}, Timestamp: "2019-06-02T01:00:00Z"},
+		{Type: repository.RequestCreatedEvent, Request: repository.Request{ServiceCode: "graffiti"}, Timestamp: "2019-06-02T01:00:00Z"},
+		{Type: repository.MediaAddedEvent, Request: troy, Timestamp: "2019-06-02T01:00:00Z"},
+	}
+
+	daily, open, statuses := tally(events, location)
+	if len(daily) != 4 {
+		t.Fatalf("tally() counted %d days, want 4: %v", len(daily), daily)
+	}
+
+	first := daily[statsKey{"Troy", "2019-06-01"}]
+	if first == nil || first.Opened != 1 || first.Services["pothole"] != 1 || first.Agencies[repository.UnassignedAgency] != 1 || first.Statuses[repository.RequestOpen] != 1 ||
+		first.Neighborhoods["ward-1"] != 1 {
+		t.Errorf("stats of Troy on 2019-06-01 = %+v, want 1 unassigned pothole opened in ward-1", first)
+	}
+
+	// Closed 36 hours after it was requested
+	second := daily[statsKey{"Troy", "2019-06-02"}]
+	if second == nil || second.Closed != 1 || second.Resolved[repository.ResolutionBucket(36)] != 1 || second.Statuses[repository.RequestClosed] != 1 ||
+		second.ServiceResolved["pothole"][repository.ResolutionBucket(36)] != 1 || second.AgencyResolved[repository.UnassignedAgency][repository.ResolutionBucket(36)] != 1 ||
+		second.NeighborhoodsResolved["ward-1"] != 1 {
+		t.Errorf("stats of Troy on 2019-06-02 = %+v, want 1 closed within 48 hours", second)
+	}
+
+	// Reopened on the 3rd in Troy
+	if third := daily[statsKey{"Troy", "2019-06-03"}]; third == nil || third.Statuses[repository.RequestOpen] != 1 || third.Opened != 0 {
+		t.Errorf("stats of Troy on 2019-06-03 = %+v, want 1 reopened", third)
+	}
+
+	if albany := daily[statsKey{"Albany", "2019-06-02"}]; albany == nil || albany.Opened != 1 || albany.Cells["dreg0:graffiti"] != 1 {
+		t.Errorf("stats of Albany on 2019-06-02 = %+v, want 1 opened in dreg0", albany)
+	}
+	if first != nil && len(first.Cells) != 0 {
+		t.Errorf("cells of Troy on 2019-06-01 = %v, want none for a request without a location", first.Cells)
+	}
+
+	// Opened, closed and reopened
+	if open["Troy"] != 1 || open["Albany"] != 1 || len(open) != 2 {
+		t.Errorf("open = %v, want 1 each for Troy and Albany", open)
+	}
+	troyStatuses := statuses["Troy"]
+	if troyStatuses[repository.RequestOpen] != 1 || troyStatuses[repository.RequestClosed] != 0 {
+		t.Errorf("statuses of Troy = %v, want 1 open", troyStatuses)
+	}
+}
+
+func TestResolutionHours(t *testing.T) {
+	at := time.Date(2019, 6, 3, 0, 0, 0, 0, time.UTC)
+	request := repository.Request{RequestedDateTime: "2019-06-01T12:00:00Z"}
+	if hours, ok := resolutionHours(request, at); !ok || hours != 36 {
+		t.Errorf("resolutionHours() = %g, %t, want 36 from the time it was made", hours, ok)
+	}
+
+	request.ResolutionHours = 30.25
+	if hours, ok := resolutionHours(request, at); !ok || hours != 30.25 {
+		t.Errorf("resolutionHours() = %g, %t, want the stored 30.25", hours, ok)
+	}
+
+	if _, ok := resolutionHours(repository.Request{}, at); ok {
+		t.Error("resolutionHours() of a request with no times succeeded")
+	}
+}
+
+func TestHistoryEvents(t *testing.T) {
+	request := repository.Request{
+		CityID:            "Troy",
+		ServiceCode:       "pothole",
+		Status:            repository.RequestClosed,
+		RequestedDateTime: "2016-03-01T12:00:00Z",
+		ClosedDateTime:    "2016-03-04T12:00:00Z",
+		ResolutionHours:   72,
+		ExternalID:        "seeclickfix:1234567",
+	}
+
+	daily, open, statuses := tally(historyEvents(request), func(string) *time.Location { return time.UTC })
+	if made := daily[statsKey{"Troy", "2016-03-01"}]; made == nil || made.Opened != 1 || made.Services["pothole"] != 1 {
+		t.Errorf("stats of the day an imported request was made = %+v, want 1 pothole opened", made)
+	}
+	if closed := daily[statsKey{"Troy", "2016-03-04"}]; closed == nil || closed.Closed != 1 || closed.Resolved[repository.ResolutionBucket(72)] != 1 {
+		t.Errorf("stats of the day an imported request was closed = %+v, want 1 closed within 72 hours", closed)
+	}
+	if open["Troy"] != 0 || statuses["Troy"][repository.RequestClosed] != 1 || statuses["Troy"][repository.RequestOpen] != 0 {
+		t.Errorf("imported closed request counted as open %d, statuses %v, want only closed", open["Troy"], statuses["Troy"])
+	}
+
+	request.Status = repository.RequestOpen
+	request.ClosedDateTime = ""
+	if raised := historyEvents(request); len(raised) != 1 || raised[0].Timestamp != request.RequestedDateTime {
+		t.Errorf("historyEvents() of an open request = %+v, want its creation", raised)
+	}
+}
+
+func TestPinChange(t *testing.T) {
+	located := repository.Request{ServiceRequestID: "SR-1", CityID: "Troy", ServiceCode: "pothole", Status: repository.RequestOpen, Location: repository.Location{Latitude: 42.7284, Longitude: -73.6918}}
+	commented := located
+	commented.Description = "Still there"
+	closed := located
+	closed.Status = repository.RequestClosed
+	moved := located
+	moved.Location = repository.Location{Latitude: 40.7128, Longitude: -74.0060}
+	unlocated := located
+	unlocated.Location = repository.Location{}
+
+	tests := []struct {
+		name          string
+		before, after *repository.Request
+		put, removed  bool
+	}{
+		{"created", nil, &located, true, false},
+		{"created without a location", nil, &unlocated, false, false},
+		{"commented", &located, &commented, false, false},
+		{"closed", &located, &closed, true, false},
+		{"moved to another cell", &located, &moved, true, true},
+		{"location removed", &located, &unlocated, false, true},
+		{"deleted", &located, nil, false, true},
+	}
+	for _, test := range tests {
+		put, removed := pinChange(test.before, test.after)
+		if (put != nil) != test.put || (removed != nil) != test.removed {
+			t.Errorf("pinChange() of a request %s = %v, %v, want put %v and removed %v", test.name, put, removed, test.put, test.removed)
+		}
+	}
+
+	put, _ := pinChange(&located, &closed)
+	if put.Status != repository.RequestClosed || put.ID != "SR-1" || put.Service != "pothole" || put.GeoCell == "" {
+		t.Errorf("pin of a closed request = %+v", put)
+	}
+	_, removed := pinChange(&located, &moved)
+	if removed.GeoCell != put.GeoCell {
+		t.Errorf("pin removed from a moved request = %+v, want the one in cell %s", removed, put.GeoCell)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/social-torch/open311-services/repository"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+
+// jobStateChange is the subset of the MediaConvert "Job State Change" event detail used to attach outputs to a request
+type jobStateChange struct {
+	Status             string            `json:"status"`
+	JobID              string            `json:"jobId"`
+	UserMetadata       map[string]string `json:"userMetadata"`
+	OutputGroupDetails []struct {
+		OutputDetails []struct {
+			OutputFilePaths []string `json:"outputFilePaths"`
+		} `json:"outputDetails"`
+	} `json:"outputGroupDetails"`
+}
+
+// handler records the rendition and poster frame of a completed transcode job as variants of the original upload
+func handler(event events.CloudWatchEvent) error {
+	var detail jobStateChange
+	err := json.Unmarshal(event.Detail, &detail)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling MediaConvert event detail: %s", err)
+	}
+
+	if detail.Status != "COMPLETE" {
+		warningLogger.Printf("Transcode job %s finished with status %s", detail.JobID, detail.Status)
+		return nil
+	}
+
+	sourceKey := detail.UserMetadata["key"]
+	if sourceKey == "" {
+		warningLogger.Printf("Transcode job %s has no key in user metadata", detail.JobID)
+		return nil
+	}
+
+	variants := []repository.MediaVariant{}
+	for _, group := range detail.OutputGroupDetails {
+		for _, output := range group.OutputDetails {
+			for _, path := range output.OutputFilePaths {
+				key := objectKey(path)
+				variants = append(variants, repository.MediaVariant{Name: variantName(key), Key: key})
+			}
+		}
+	}
+
+	err = repository.AddMediaVariants(sourceKey, variants)
+	if err != nil {
+		switch err.(type) {
+		case *repository.MediaNotFoundErr:
+			// Nothing to attach to; retrying will not help
+			warningLogger.Printf("%s. media_key '%s' not in database", err, sourceKey)
+			return nil
+		default:
+			return err
+		}
+	}
+
+	infoLogger.Printf("Attached %d transcoded variants to %s", len(variants), sourceKey)
+	return nil
+}
+
+// variantName distinguishes the poster frame (a frame capture image) from the playable rendition
+func variantName(key string) string {
+	if strings.HasSuffix(strings.ToLower(key), ".jpg") {
+		return "poster"
+	}
+	return "rendition"
+}
+
+// objectKey converts an s3://bucket/key path to the key used by the images endpoints
+func objectKey(path string) string {
+	path = strings.TrimPrefix(path, "s3://")
+	if i := strings.Index(path, "/"); i >= 0 {
+		return path[i+1:]
+	}
+	return path
+}
+
+func main() {
+	lambda.Start(handler)
+}
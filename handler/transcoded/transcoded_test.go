@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestObjectKey(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"s3://images/albany/clip-720p.mp4", "albany/clip-720p.mp4"},
+		{"s3://images/clip.0000000.jpg", "clip.0000000.jpg"},
+		{"images/clip.mp4", "clip.mp4"},
+		{"clip.mp4", "clip.mp4"},
+	}
+
+	for _, test := range tests {
+		if got := objectKey(test.path); got != test.want {
+			t.Errorf("objectKey(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestVariantName(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"albany/clip.0000000.jpg", "poster"},
+		{"albany/clip.0000000.JPG", "poster"},
+		{"albany/clip-720p.mp4", "rendition"},
+		{"albany/clip.m3u8", "rendition"},
+	}
+
+	for _, test := range tests {
+		if got := variantName(test.key); got != test.want {
+			t.Errorf("variantName(%q) = %q, want %q", test.key, got, test.want)
+		}
+	}
+}
+
+func TestHandlerSkipsIncompleteJobs(t *testing.T) {
+	details := []string{
+		`{"status":"ERROR","jobId":"1","userMetadata":{"key":"albany/clip.mp4"}}`,
+		`{"status":"COMPLETE","jobId":"2","userMetadata":{}}`,
+	}
+
+	for _, detail := range details {
+		if err := handler(events.CloudWatchEvent{Detail: []byte(detail)}); err != nil {
+			t.Errorf("handler(%s) = %v, want nil", detail, err)
+		}
+	}
+
+	if err := handler(events.CloudWatchEvent{Detail: []byte(`{"status":`)}); err == nil {
+		t.Errorf("handler(invalid) = nil, want error")
+	}
+}
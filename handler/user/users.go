@@ -4,20 +4,30 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/social-torch/open311-services/apierror"
+	"github.com/social-torch/open311-services/auth"
+	"github.com/social-torch/open311-services/awsclient"
+	"github.com/social-torch/open311-services/metrics"
+	"github.com/social-torch/open311-services/notification"
 	"github.com/social-torch/open311-services/repository"
+	"github.com/social-torch/open311-services/warmup"
 )
 
 var infoLogger = log.New(os.Stdout, "INFO\t", 0)
 var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
 var errorLogger = log.New(os.Stderr, "ERROR\t", log.Lshortfile)
 
-/// Route request
+// / Route request
 func router(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	switch req.HTTPMethod {
 	case "GET":
@@ -25,12 +35,47 @@ func router(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse,
 			id := req.PathParameters["id"]
 			return getUser(id)
 		}
+
+		if req.Resource == "/user/{id}/unsubscribe" {
+			id := req.PathParameters["id"]
+			return confirmUnsubscribe(id, req.QueryStringParameters["token"])
+		}
+
+		if req.Resource == "/user/{id}/subscriptions" {
+			id := req.PathParameters["id"]
+			return getSubscriptions(id, req)
+		}
 	case "POST":
 		if req.Resource == "/feedback" {
 			return submitFeedback(req)
 		}
+
+		if req.Resource == "/user/{id}/unsubscribe" {
+			id := req.PathParameters["id"]
+			return unsubscribe(id, req.QueryStringParameters["token"])
+		}
+
+		if req.Resource == "/user/{id}/devices" {
+			id := req.PathParameters["id"]
+			return registerDevice(id, req)
+		}
+
+		if req.Resource == "/user/{id}/subscriptions" {
+			id := req.PathParameters["id"]
+			return subscribe(id, req)
+		}
+	case "PUT":
+		if req.Resource == "/user/{id}/preferences" {
+			id := req.PathParameters["id"]
+			return setPreferences(id, req)
+		}
+	case "DELETE":
+		if req.Resource == "/user/{id}/subscription/{subscription_id}" {
+			id := req.PathParameters["id"]
+			return unsubscribeFrom(id, req.PathParameters["subscription_id"], req)
+		}
 	}
-	return clientError(http.StatusMethodNotAllowed, errors.New("method must be 'GET' or 'POST'"))
+	return clientError(http.StatusMethodNotAllowed, errors.New("method must be 'GET', 'POST', 'PUT' or 'DELETE'"))
 }
 
 func getUser(accountID string) (events.APIGatewayProxyResponse, error) {
@@ -84,24 +129,277 @@ func submitFeedback(req events.APIGatewayProxyRequest) (events.APIGatewayProxyRe
 	}, nil
 }
 
-func serverError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
-	errorLogger.Println(err.Error())
+// device is the body a client posts to receive push notifications
+type device struct {
+	Platform string `json:"platform"` // "ios" or "android"
+	Token    string `json:"token"`    // APNs device token or FCM registration token
+}
+
+// SNS platform applications, by device platform
+var platformApplications = map[string]string{
+	"ios":     "APNS_PLATFORM_APPLICATION_ARN",
+	"android": "GCM_PLATFORM_APPLICATION_ARN",
+}
+
+func registerDevice(accountID string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !isAccount(req, accountID) {
+		return clientError(http.StatusForbidden, errors.New("devices may only be registered by the account they notify"))
+	}
+
+	var d device
+	err := json.Unmarshal([]byte(req.Body), &d)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, errors.New("error unmarshalling device JSON. Check syntax"))
+	}
+
+	application, ok := platformApplications[d.Platform]
+	if !ok || d.Token == "" {
+		return clientError(http.StatusBadRequest, errors.New("platform must be 'ios' or 'android' and token must be specified"))
+	}
+
+	svc := sns.New(awsclient.Session())
+	endpoint, err := svc.CreatePlatformEndpoint(&sns.CreatePlatformEndpointInput{
+		PlatformApplicationArn: aws.String(os.Getenv(application)),
+		Token:                  aws.String(d.Token),
+		CustomUserData:         aws.String(accountID),
+	})
+	if err != nil {
+		return serverError(http.StatusInternalServerError, fmt.Errorf("unable to create push endpoint: %s", err))
+	}
+
+	err = repository.RegisterPushEndpoint(accountID, aws.StringValue(endpoint.EndpointArn))
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	infoLogger.Println("Device registered for " + accountID)
+
 	return events.APIGatewayProxyResponse{
-		StatusCode: statusCode,
-		Headers:    map[string]string{"content-type": "text/plain"},
-		Body:       http.StatusText(statusCode) + ": " + err.Error(),
+		StatusCode: http.StatusCreated,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       "{}",
 	}, nil
 }
 
-func clientError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
-	warningLogger.Println(err.Error())
+func setPreferences(accountID string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !isAccount(req, accountID) {
+		return clientError(http.StatusForbidden, errors.New("notification preferences may only be set by their own account"))
+	}
+
+	var preferences repository.NotificationPreferences
+	err := json.Unmarshal([]byte(req.Body), &preferences)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, errors.New("error unmarshalling notification preferences JSON. Check syntax"))
+	}
+
+	err = repository.SetNotificationPreferences(accountID, preferences)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	body, err := json.Marshal(preferences)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("unable to marshal JSON for response"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// confirmUnsubscribe asks for confirmation before disabling email notifications from the link included in every
+// notification email.  Links are opened by mail scanners and link prefetchers too, so email is only disabled when
+// the form is submitted.
+func confirmUnsubscribe(accountID string, token string) (events.APIGatewayProxyResponse, error) {
+	if !notification.ValidUnsubscribeToken(accountID, token) {
+		return page(http.StatusForbidden, "This unsubscribe link is not valid.")
+	}
+
+	query := url.Values{}
+	query.Set("token", token)
+	return page(http.StatusOK, fmt.Sprintf(`Stop receiving email notifications about your requests?</p>
+<form method="post" action="?%s"><button type="submit">Unsubscribe</button></form><p>`, html.EscapeString(query.Encode())))
+}
+
+// unsubscribe disables email notifications once confirmUnsubscribe's form is submitted
+func unsubscribe(accountID string, token string) (events.APIGatewayProxyResponse, error) {
+	if !notification.ValidUnsubscribeToken(accountID, token) {
+		return page(http.StatusForbidden, "This unsubscribe link is not valid.")
+	}
+
+	err := repository.DisableEmailNotifications(accountID)
+	if err != nil {
+		switch err.(type) {
+		case *repository.AccountIDNotFoundErr:
+			warningLogger.Printf("%s. account_id: '%s' not in database", err, accountID)
+			return page(http.StatusNotFound, "This account no longer exists.")
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	infoLogger.Println("Email notifications disabled for " + accountID)
+
+	return page(http.StatusOK, "You have been unsubscribed from email notifications.")
+}
+
+// Largest radius, in meters, of an area subscription
+const maxSubscriptionRadius = 5000
+
+func getSubscriptions(accountID string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	// What an account watches, areas included, may tell where its user lives
+	if !isAccount(req, accountID) {
+		return clientError(http.StatusForbidden, errors.New("subscriptions may only be listed by their own account"))
+	}
+
+	subscriptions, err := repository.GetUserSubscriptions(accountID)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	body, err := json.Marshal(subscriptions)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling subscriptions"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+func subscribe(accountID string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !isAccount(req, accountID) {
+		return clientError(http.StatusForbidden, errors.New("subscriptions may only be made by their own account"))
+	}
+
+	var subscription repository.Subscription
+	err := json.Unmarshal([]byte(req.Body), &subscription)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, errors.New("error unmarshalling subscription JSON. Check syntax"))
+	}
+
+	switch subscription.Type {
+	case repository.RequestSubscription:
+		_, err = repository.GetRequest(cityID(req), subscription.ServiceRequestID)
+		if err != nil {
+			switch err.(type) {
+			case *repository.RequestIdNotFoundErr:
+				errorMessage := fmt.Errorf("%s. service_request_id '%s' not in database", err, subscription.ServiceRequestID)
+				return clientError(http.StatusBadRequest, errorMessage)
+			default:
+				return serverError(http.StatusInternalServerError, err)
+			}
+		}
+	case repository.ServiceSubscription:
+		if !repository.IsValidServiceCode(cityID(req), subscription.ServiceCode) {
+			return clientError(http.StatusBadRequest, fmt.Errorf("service_code '%s' is not a valid service", subscription.ServiceCode))
+		}
+	case repository.AreaSubscription:
+		if !subscription.HasLocation() {
+			return clientError(http.StatusBadRequest, errors.New("lat and lon must be specified for an area subscription"))
+		}
+		err = subscription.ValidateLocation()
+		if err != nil {
+			return clientError(http.StatusBadRequest, err)
+		}
+		if subscription.Radius <= 0 || subscription.Radius > maxSubscriptionRadius {
+			return clientError(http.StatusBadRequest, fmt.Errorf("radius must be between 0 and %d meters", maxSubscriptionRadius))
+		}
+	default:
+		return clientError(http.StatusBadRequest, errors.New("type must be 'request', 'service' or 'area'"))
+	}
+
+	subscription.AccountID = accountID
+	subscription, err = repository.AddSubscription(subscription)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	body, err := json.Marshal(subscription)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("unable to marshal JSON for response"))
+	}
+
+	if city := cityID(req); city != "" {
+		err = repository.RecordWatch(city, subscription.Type, time.Now())
+		if err != nil {
+			warningLogger.Printf("Unable to record %s watch in %s: %s", subscription.Type, city, err)
+		}
+	}
+
+	infoLogger.Printf("%s subscribed to %s updates", accountID, subscription.Type)
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusCreated,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// unsubscribeFrom removes one subscription, as opposed to unsubscribe which turns off email altogether
+func unsubscribeFrom(accountID string, subscriptionID string, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !isAccount(req, accountID) {
+		return clientError(http.StatusForbidden, errors.New("subscriptions may only be removed by their own account"))
+	}
+
+	err := repository.DeleteSubscription(accountID, subscriptionID)
+	if err != nil {
+		switch err.(type) {
+		case *repository.SubscriptionNotFoundErr:
+			errorMessage := fmt.Errorf("%s. subscription_id: '%s' not in database", err, subscriptionID)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusNoContent,
+		Headers:    map[string]string{"Access-Control-Allow-Origin": "*"},
+	}, nil
+}
+
+// isAccount reports whether the caller is signed in as the account with accountID, so one user can't have another's
+// notifications sent to them
+func isAccount(req events.APIGatewayProxyRequest, accountID string) bool {
+	username := auth.Claim(req, "cognito:username")
+	return username != "" && username == accountID
+}
+
+// cityID returns the city a call is scoped to: the city in the caller's token, else the city_id query parameter,
+// else the JURISDICTION this deployment serves.  It is "" for deployments that serve no city in particular.
+func cityID(req events.APIGatewayProxyRequest) string {
+	if city := auth.StaffCity(req); city != "" {
+		return city
+	}
+	if city := req.QueryStringParameters["city_id"]; city != "" {
+		return city
+	}
+	return os.Getenv("JURISDICTION")
+}
+
+func page(statusCode int, message string) (events.APIGatewayProxyResponse, error) {
 	return events.APIGatewayProxyResponse{
 		StatusCode: statusCode,
-		Headers:    map[string]string{"content-type": "text/plain"},
-		Body:       http.StatusText(statusCode) + ": " + err.Error(),
+		Headers:    map[string]string{"content-type": "text/html; charset=utf-8"},
+		Body:       "<!DOCTYPE html><html><head><meta name=\"viewport\" content=\"width=device-width\"><title>Open311</title></head><body><p>" + message + "</p></body></html>",
 	}, nil
 }
 
+func serverError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
+	errorLogger.Println(err.Error())
+	return apierror.Response(statusCode, err), nil
+}
+
+func clientError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
+	warningLogger.Println(err.Error())
+	return apierror.Response(statusCode, err), nil
+}
+
 func main() {
-	lambda.Start(router)
+	warmup.Start("user", metrics.Handler("user", apierror.Handler(repository.Audit(router))), repository.Warm)
 }
@@ -1,9 +1,80 @@
 package main
 
 import (
+	"net/http"
+	"os"
+	"strings"
 	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/notification"
 )
 
 func TestStub(t *testing.T) {
 	// see https://github.com/aws/aws-sdk-go/blob/master/example/service/dynamodb/unitTest/unitTest_test.go
 }
+
+// signedIn returns a call made with the token of username
+func signedIn(username string) events.APIGatewayProxyRequest {
+	return events.APIGatewayProxyRequest{RequestContext: events.APIGatewayProxyRequestContext{
+		Authorizer: map[string]interface{}{"claims": map[string]interface{}{"cognito:username": username}},
+	}}
+}
+
+func TestIsAccount(t *testing.T) {
+	if !isAccount(signedIn("alice"), "alice") {
+		t.Error("isAccount() of the caller's own account = false, want true")
+	}
+	if isAccount(signedIn("mallory"), "alice") {
+		t.Error("isAccount() of another account = true, want false")
+	}
+	if isAccount(events.APIGatewayProxyRequest{}, "") {
+		t.Error("isAccount() of a call without a token = true, want false")
+	}
+}
+
+// Another account's notifications are refused before anything is read or stored
+func TestNotificationsOfAnotherAccount(t *testing.T) {
+	req := signedIn("mallory")
+	req.Body = `{"email": true}`
+	if resp, _ := setPreferences("alice", req); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("setPreferences() of another account = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+	req.Body = `{"platform": "ios", "token": "device"}`
+	if resp, _ := registerDevice("alice", req); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("registerDevice() of another account = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
+
+// Another account's subscriptions are neither listed, made nor removed
+func TestSubscriptionsOfAnotherAccount(t *testing.T) {
+	req := signedIn("mallory")
+	if resp, _ := getSubscriptions("alice", req); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("getSubscriptions() of another account = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+	if resp, _ := unsubscribeFrom("alice", "sub-1", req); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("unsubscribeFrom() of another account = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+	req.Body = `{"type": "area", "lat": 42.73, "lon": -73.69, "radius": 500}`
+	if resp, _ := subscribe("alice", req); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("subscribe() of another account = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
+
+// Opening an unsubscribe link only asks for confirmation, which is posted back with the token
+func TestConfirmUnsubscribe(t *testing.T) {
+	os.Setenv("UNSUBSCRIBE_SECRET", "secret")
+	defer os.Unsetenv("UNSUBSCRIBE_SECRET")
+
+	token := notification.UnsubscribeToken("alice")
+	resp, _ := confirmUnsubscribe("alice", token)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(resp.Body, `<form method="post" action="?token=`+token+`">`) {
+		t.Errorf("confirmUnsubscribe() = %d %s, want a form posting the token", resp.StatusCode, resp.Body)
+	}
+	if resp, _ := confirmUnsubscribe("mallory", token); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("confirmUnsubscribe() with the token of another account = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+	if resp, _ := unsubscribe("mallory", token); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("unsubscribe() with the token of another account = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+}
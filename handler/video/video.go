@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/mediaconvert"
+	"github.com/social-torch/open311-services/apierror"
+	"github.com/social-torch/open311-services/awsclient"
+	"github.com/social-torch/open311-services/features"
+	"github.com/social-torch/open311-services/metrics"
+	"github.com/social-torch/open311-services/repository"
+	"github.com/social-torch/open311-services/warmup"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+var errorLogger = log.New(os.Stderr, "ERROR\t", log.Lshortfile)
+
+// Video file extensions accepted for transcoding
+var videoExtensions = []string{".mp4", ".mov", ".m4v", ".3gp", ".webm"}
+
+// transcodeRequest is the body a client posts after storing a video via /images/store/{key}
+type transcodeRequest struct {
+	ServiceRequestID string `json:"service_request_id"`
+	Key              string `json:"key"`
+}
+
+// Route requests
+func router(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	switch req.HTTPMethod {
+	case "POST":
+		if req.Resource == "/video/transcode" {
+			return submitTranscode(req)
+		}
+	}
+	return clientError(http.StatusMethodNotAllowed, errors.New("method must be 'POST'"))
+}
+
+// Start a MediaConvert job producing a web-friendly rendition and poster frame for an uploaded video.
+// The job's user metadata carries the original key so the completion handler can record the outputs as its variants.
+func submitTranscode(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var transcode transcodeRequest
+	err := json.Unmarshal([]byte(req.Body), &transcode)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, errors.New("error unmarshalling transcode JSON. Check syntax"))
+	}
+
+	if transcode.ServiceRequestID == "" || transcode.Key == "" {
+		return clientError(http.StatusBadRequest, errors.New("service_request_id and key must be specified"))
+	}
+
+	if !isVideo(transcode.Key) {
+		return clientError(http.StatusUnsupportedMediaType, fmt.Errorf("'%s' is not a supported video type", transcode.Key))
+	}
+
+	// The media record says which city, and so which bucket and tables, hold the upload and its request
+	media, err := repository.GetMedia(transcode.Key)
+	if err != nil {
+		switch err.(type) {
+		case *repository.MediaNotFoundErr:
+			errorMessage := fmt.Errorf("%s. media_key '%s' not in database", err, transcode.Key)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	_, err = repository.GetRequest(media.City, transcode.ServiceRequestID)
+	if err != nil {
+		switch err.(type) {
+		case *repository.RequestIdNotFoundErr:
+			errorMessage := fmt.Errorf("%s. service_request_id '%s' not in database", err, transcode.ServiceRequestID)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	video, err := features.Enabled(media.City, repository.FeatureVideoUpload)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+	if !video {
+		return clientError(http.StatusForbidden, fmt.Errorf("%s does not accept video", media.City))
+	}
+
+	bucket := os.Getenv("IMAGE_BUCKET")
+	if media.City != "" {
+		city, err := repository.GetCity(media.City)
+		if err != nil {
+			return serverError(http.StatusInternalServerError, err)
+		}
+		if city.MediaBucket != "" {
+			bucket = city.MediaBucket
+		}
+	}
+
+	// MediaConvert requires the account specific endpoint rather than the regional default
+	svc := mediaconvert.New(awsclient.Session(), &aws.Config{
+		Endpoint: aws.String(os.Getenv("MEDIACONVERT_ENDPOINT")),
+	})
+
+	input := &mediaconvert.CreateJobInput{
+		JobTemplate: aws.String(os.Getenv("MEDIACONVERT_JOB_TEMPLATE")),
+		Role:        aws.String(os.Getenv("MEDIACONVERT_ROLE")),
+		Settings: &mediaconvert.JobSettings{
+			Inputs: []*mediaconvert.Input{
+				{FileInput: aws.String("s3://" + bucket + "/" + transcode.Key)},
+			},
+		},
+		UserMetadata: map[string]*string{
+			"service_request_id": aws.String(transcode.ServiceRequestID),
+			"key":                aws.String(transcode.Key),
+		},
+	}
+
+	result, err := svc.CreateJob(input)
+	if err != nil {
+		errorLogger.Println(err)
+		return serverError(http.StatusInternalServerError, errors.New("error submitting video transcode job"))
+	}
+
+	infoLogger.Println("Transcode job submitted: " + aws.StringValue(result.Job.Id))
+	body, _ := json.Marshal(&struct {
+		JobID string `json:"job_id"`
+	}{
+		JobID: aws.StringValue(result.Job.Id),
+	})
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusAccepted,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+func isVideo(key string) bool {
+	key = strings.ToLower(key)
+	for _, ext := range videoExtensions {
+		if strings.HasSuffix(key, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func serverError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
+	errorLogger.Println(err.Error())
+	return apierror.Response(statusCode, err), nil
+}
+
+func clientError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
+	warningLogger.Println(err.Error())
+	return apierror.Response(statusCode, err), nil
+}
+
+func main() {
+	warmup.Start("video", metrics.Handler("video", apierror.Handler(repository.Audit(router))), repository.Warm)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestIsVideo(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"albany/clip.mp4", true},
+		{"albany/CLIP.MOV", true},
+		{"clip.m4v", true},
+		{"clip.3gp", true},
+		{"clip.webm", true},
+		{"albany/photo.jpg", false},
+		{"clip.mp4.jpg", false},
+		{"mp4", false},
+	}
+
+	for _, test := range tests {
+		if got := isVideo(test.key); got != test.want {
+			t.Errorf("isVideo(%q) = %v, want %v", test.key, got, test.want)
+		}
+	}
+}
+
+func TestSubmitTranscodeInvalid(t *testing.T) {
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"service_request_id":`, http.StatusUnprocessableEntity},
+		{`{"key":"albany/clip.mp4"}`, http.StatusBadRequest},
+		{`{"service_request_id":"42"}`, http.StatusBadRequest},
+		{`{"service_request_id":"42","key":"albany/photo.jpg"}`, http.StatusUnsupportedMediaType},
+	}
+
+	for _, test := range tests {
+		req := events.APIGatewayProxyRequest{HTTPMethod: "POST", Resource: "/video/transcode", Body: test.body}
+		resp, err := router(req)
+		if err != nil {
+			t.Errorf("router(%q) error = %v", test.body, err)
+			continue
+		}
+		if resp.StatusCode != test.want {
+			t.Errorf("router(%q) status = %d, want %d", test.body, resp.StatusCode, test.want)
+		}
+	}
+
+	resp, _ := router(events.APIGatewayProxyRequest{HTTPMethod: "GET", Resource: "/video/transcode"})
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("router(GET) status = %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/auth"
+	"github.com/social-torch/open311-services/notification"
+	"github.com/social-torch/open311-services/repository"
+)
+
+// sampleWindow is how far back GET /hooks/samples looks for requests, and sampleSize the most it returns
+const (
+	sampleWindow = 30 * 24 * time.Hour
+	sampleSize   = 10
+)
+
+// hookSubscription is the body no-code platforms such as Zapier and Make post to subscribe a REST hook
+type hookSubscription struct {
+	TargetURL   string `json:"target_url"`             // Where the platform receives the hook
+	Event       string `json:"event"`                  // Domain event type, eg RequestCreated
+	ServiceCode string `json:"service_code,omitempty"` // Only deliver events of requests for this service
+}
+
+// subscribeHook registers a REST hook: a webhook delivering one event type, as the flat payload of
+// notification.HookPayload, which the platform removes again with DELETE /hooks/{id} when the automation is
+// turned off
+func subscribeHook(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	var subscription hookSubscription
+	err := json.Unmarshal([]byte(req.Body), &subscription)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, errors.New("error unmarshalling hook subscription JSON. Check syntax"))
+	}
+
+	u, err := url.Parse(subscription.TargetURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return clientError(http.StatusBadRequest, errors.New("target_url must be an absolute https URL"))
+	}
+	if !eventTypes[subscription.Event] {
+		return clientError(http.StatusBadRequest, fmt.Errorf("unknown event '%s'", subscription.Event))
+	}
+
+	secret, err := notification.NewSecret()
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("unable to generate webhook secret"))
+	}
+
+	webhook, err := repository.AddWebhook(repository.Webhook{
+		City:        auth.StaffCity(req),
+		URL:         subscription.TargetURL,
+		EventTypes:  []string{subscription.Event},
+		ServiceCode: subscription.ServiceCode,
+		Format:      repository.WebhookFormatRESTHook,
+		Secret:      secret,
+		AccountID:   req.Headers["from"],
+	})
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	body, err := json.Marshal(map[string]string{"id": webhook.ID})
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("unable to marshal JSON for hook response"))
+	}
+
+	infoLogger.Println("REST hook subscribed: " + webhook.ID)
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusCreated,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// unsubscribeHook removes a REST hook of the caller's city
+func unsubscribeHook(req events.APIGatewayProxyRequest, id string) (events.APIGatewayProxyResponse, error) {
+	webhook, err := repository.GetWebhook(id)
+	if err != nil {
+		switch err.(type) {
+		case *repository.WebhookNotFoundErr:
+			errorMessage := fmt.Errorf("%s. hook '%s' not in database", err, id)
+			return clientError(http.StatusNotFound, errorMessage)
+		default:
+			return serverError(http.StatusInternalServerError, err)
+		}
+	}
+
+	// Another city's hook is as good as missing
+	if webhook.Format != repository.WebhookFormatRESTHook || webhook.City != auth.StaffCity(req) {
+		return clientError(http.StatusNotFound, fmt.Errorf("hook '%s' not in database", id))
+	}
+
+	err = repository.DeleteWebhook(id)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	infoLogger.Println("REST hook unsubscribed: " + id)
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusNoContent,
+		Headers:    map[string]string{"Access-Control-Allow-Origin": "*"},
+	}, nil
+}
+
+// getHookSamples returns payloads of an event for the city's latest requests, newest first, shaped as the hook
+// would deliver them.  Platforms show them while an automation is set up, and poll them where hooks can't be used.
+func getHookSamples(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	event := req.QueryStringParameters["event"]
+	if event == "" {
+		event = repository.RequestCreatedEvent
+	}
+	if !eventTypes[event] {
+		return clientError(http.StatusBadRequest, fmt.Errorf("unknown event '%s'", event))
+	}
+
+	city := auth.StaffCity(req)
+	if city == "" {
+		city = req.QueryStringParameters["city_id"]
+	}
+	if city == "" {
+		return clientError(http.StatusBadRequest, errors.New("city_id must be specified, since samples are of a city's requests"))
+	}
+
+	end := time.Now()
+	requests, err := repository.GetRequestsBetween(city, end.Add(-sampleWindow), end)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	body, err := json.Marshal(hookSamples(requests, event, req.QueryStringParameters["service_code"]))
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling hook samples"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// hookSamples returns the payloads of an event for up to sampleSize of the latest requests, optionally of one
+// service.  Only requests which have changed status make StatusChanged samples.
+func hookSamples(requests []repository.Request, event string, serviceCode string) []map[string]interface{} {
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].RequestedDateTime > requests[j].RequestedDateTime
+	})
+
+	samples := []map[string]interface{}{}
+	for _, request := range requests {
+		if len(samples) == sampleSize {
+			break
+		}
+		if serviceCode != "" && request.ServiceCode != serviceCode {
+			continue
+		}
+
+		e := repository.RequestEvent{
+			Type:             event,
+			ServiceRequestID: request.ServiceRequestID,
+			Request:          request,
+			Timestamp:        request.RequestedDateTime,
+		}
+		if event == repository.StatusChangedEvent {
+			if request.UpdatedDateTime == "" || request.UpdatedDateTime == request.RequestedDateTime {
+				continue
+			}
+			e.Timestamp = request.UpdatedDateTime
+		}
+		samples = append(samples, notification.HookPayload(e))
+	}
+	return samples
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/apierror"
+	"github.com/social-torch/open311-services/auth"
+	"github.com/social-torch/open311-services/metrics"
+	"github.com/social-torch/open311-services/notification"
+	"github.com/social-torch/open311-services/repository"
+	"github.com/social-torch/open311-services/warmup"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+var errorLogger = log.New(os.Stderr, "ERROR\t", log.Lshortfile)
+
+// Domain event types a webhook may subscribe to
+var eventTypes = map[string]bool{
+	repository.RequestCreatedEvent: true,
+	repository.StatusChangedEvent:  true,
+	repository.MediaAddedEvent:     true,
+}
+
+// Route requests
+func router(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if !auth.InGroup(req, auth.CityAdminGroup) {
+		return clientError(http.StatusForbidden, errors.New("webhooks may only be managed by city admins"))
+	}
+	// Webhooks belong to the city the admin works for, which their token must name
+	city := auth.StaffCity(req)
+	if city == "" {
+		return clientError(http.StatusForbidden, errors.New("webhooks may only be managed by city admins whose token names their city"))
+	}
+
+	switch req.HTTPMethod {
+	case "GET":
+		if req.Resource == "/webhooks" {
+			return getWebhooks(city)
+		}
+
+		if req.Resource == "/webhook/{id}/deliveries" {
+			id := req.PathParameters["id"]
+			return getDeliveries(id, city)
+		}
+
+		if req.Resource == "/hooks/samples" {
+			return getHookSamples(req)
+		}
+
+	case "POST":
+		if req.Resource == "/webhooks" {
+			return addWebhook(req, city)
+		}
+
+		if req.Resource == "/hooks" {
+			return subscribeHook(req)
+		}
+
+	case "DELETE":
+		if req.Resource == "/webhook/{id}" {
+			id := req.PathParameters["id"]
+			return deleteWebhook(id, city)
+		}
+
+		if req.Resource == "/hooks/{id}" {
+			id := req.PathParameters["id"]
+			return unsubscribeHook(req, id)
+		}
+	}
+	return clientError(http.StatusMethodNotAllowed, errors.New("method must be 'GET', 'POST' or 'DELETE'"))
+}
+
+// getWebhooks lists the webhooks of a city
+func getWebhooks(city string) (events.APIGatewayProxyResponse, error) {
+	all, err := repository.GetWebhooks()
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+	webhooks := cityWebhooks(all, city)
+
+	// Secrets are only revealed when a webhook is registered
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+
+	body, err := json.Marshal(webhooks)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling GetWebhooks() struct"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// addWebhook registers a webhook for the events of the admin's city, whatever city the body names
+func addWebhook(req events.APIGatewayProxyRequest, city string) (events.APIGatewayProxyResponse, error) {
+	var webhook repository.Webhook
+	err := json.Unmarshal([]byte(req.Body), &webhook)
+	if err != nil {
+		return clientError(http.StatusUnprocessableEntity, errors.New("error unmarshalling webhook JSON. Check syntax"))
+	}
+
+	u, err := url.Parse(webhook.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return clientError(http.StatusBadRequest, errors.New("url must be an absolute https URL"))
+	}
+
+	if len(webhook.EventTypes) == 0 {
+		return clientError(http.StatusBadRequest, errors.New("at least one event type must be specified"))
+	}
+	for _, t := range webhook.EventTypes {
+		if !eventTypes[t] {
+			return clientError(http.StatusBadRequest, fmt.Errorf("unknown event type '%s'", t))
+		}
+	}
+	if webhook.Format != repository.WebhookFormatEvent && webhook.Format != repository.WebhookFormatRESTHook {
+		return clientError(http.StatusBadRequest, fmt.Errorf("unknown format '%s'", webhook.Format))
+	}
+
+	webhook.Secret, err = notification.NewSecret()
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("unable to generate webhook secret"))
+	}
+	webhook.AccountID = req.Headers["from"]
+	webhook.City = city
+
+	webhook, err = repository.AddWebhook(webhook)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	body, err := json.Marshal(webhook)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("unable to marshal JSON for webhook response"))
+	}
+
+	infoLogger.Println("Webhook registered: " + webhook.ID)
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusCreated,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+func deleteWebhook(id string, city string) (events.APIGatewayProxyResponse, error) {
+	found, err := cityWebhook(id, city)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+	if !found {
+		return clientError(http.StatusNotFound, fmt.Errorf("webhook not found. webhook_id '%s' not in database", id))
+	}
+
+	err = repository.DeleteWebhook(id)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	infoLogger.Println("Webhook deleted: " + id)
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusNoContent,
+		Headers:    map[string]string{"Access-Control-Allow-Origin": "*"},
+	}, nil
+}
+
+func getDeliveries(id string, city string) (events.APIGatewayProxyResponse, error) {
+	found, err := cityWebhook(id, city)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+	if !found {
+		return clientError(http.StatusNotFound, fmt.Errorf("webhook not found. webhook_id '%s' not in database", id))
+	}
+
+	deliveries, err := repository.GetWebhookDeliveries(id)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, err)
+	}
+
+	body, err := json.Marshal(deliveries)
+	if err != nil {
+		return serverError(http.StatusInternalServerError, errors.New("error marshalling webhook deliveries"))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"content-type": "application/json", "Access-Control-Allow-Origin": "*"},
+		Body:       string(body),
+	}, nil
+}
+
+// cityWebhook reports whether a webhook is one of a city's.  Another city's webhook is as good as missing.
+func cityWebhook(id string, city string) (bool, error) {
+	webhook, err := repository.GetWebhook(id)
+	if err != nil {
+		if _, ok := err.(*repository.WebhookNotFoundErr); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return webhook.City == city, nil
+}
+
+// cityWebhooks returns the webhooks of a city out of those of every city
+func cityWebhooks(webhooks []repository.Webhook, city string) []repository.Webhook {
+	owned := []repository.Webhook{}
+	for _, webhook := range webhooks {
+		if webhook.City == city {
+			owned = append(owned, webhook)
+		}
+	}
+	return owned
+}
+
+func serverError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
+	errorLogger.Println(err.Error())
+	return apierror.Response(statusCode, err), nil
+}
+
+func clientError(statusCode int, err error) (events.APIGatewayProxyResponse, error) {
+	warningLogger.Println(err.Error())
+	return apierror.Response(statusCode, err), nil
+}
+
+func main() {
+	warmup.Start("webhooks", metrics.Handler("webhooks", apierror.Handler(repository.Audit(router))), repository.Warm)
+}
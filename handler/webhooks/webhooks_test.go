@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestHookSamples(t *testing.T) {
+	requests := []repository.Request{
+		{ServiceRequestID: "a", ServiceCode: "pothole", RequestedDateTime: "2020-06-01T12:00:00Z", UpdatedDateTime: "2020-06-01T12:00:00Z"},
+		{ServiceRequestID: "b", ServiceCode: "graffiti", RequestedDateTime: "2020-06-02T12:00:00Z", UpdatedDateTime: "2020-06-03T12:00:00Z"},
+		{ServiceRequestID: "c", ServiceCode: "pothole", RequestedDateTime: "2020-06-03T12:00:00Z", UpdatedDateTime: "2020-06-04T12:00:00Z"},
+	}
+
+	ids := func(samples []map[string]interface{}) (ids []string) {
+		for _, sample := range samples {
+			ids = append(ids, sample["service_request_id"].(string))
+		}
+		return ids
+	}
+
+	if got := ids(hookSamples(requests, repository.RequestCreatedEvent, "")); len(got) != 3 || got[0] != "c" || got[2] != "a" {
+		t.Errorf("RequestCreated samples = %v, want newest first", got)
+	}
+	if got := ids(hookSamples(requests, repository.RequestCreatedEvent, "pothole")); len(got) != 2 || got[0] != "c" || got[1] != "a" {
+		t.Errorf("pothole samples = %v, want [c a]", got)
+	}
+
+	samples := hookSamples(requests, repository.StatusChangedEvent, "")
+	if got := ids(samples); len(got) != 2 || got[0] != "c" || got[1] != "b" {
+		t.Errorf("StatusChanged samples = %v, want [c b], leaving out the unchanged request", got)
+	}
+	if samples[0]["timestamp"] != "2020-06-04T12:00:00Z" {
+		t.Errorf("StatusChanged sample timestamp = %v, want the time the request was updated", samples[0]["timestamp"])
+	}
+}
+
+func TestCityWebhooks(t *testing.T) {
+	webhooks := []repository.Webhook{{ID: "1", City: "Troy"}, {ID: "2", City: "Albany"}, {ID: "3", City: "Troy"}, {ID: "4"}}
+
+	troy := cityWebhooks(webhooks, "Troy")
+	if len(troy) != 2 || troy[0].ID != "1" || troy[1].ID != "3" {
+		t.Errorf("cityWebhooks(Troy) = %+v, want webhooks 1 and 3", troy)
+	}
+	if got := cityWebhooks(webhooks, "Schenectady"); len(got) != 0 {
+		t.Errorf("cityWebhooks(Schenectady) = %+v, want none", got)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/social-torch/open311-services/repository"
+	"github.com/social-torch/open311-services/workorder"
+)
+
+var infoLogger = log.New(os.Stdout, "INFO\t", 0)
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+
+// secretPrefix begins the names of connector secrets.  A city's secrets are under open311/<city_name>/, so a city
+// admin can't point the connector at another city's credentials and have them sent to their own URL.
+const secretPrefix = "open311/"
+
+// handler syncs requests with the work-order systems of cities that have connected one.  Domain events of requests
+// create and update their work orders as they happen; on schedule, every open request is reconciled with its work
+// order, creating work orders missed and taking up the statuses crews gave them.
+func handler(event events.CloudWatchEvent) error {
+	if event.Source == repository.EventSource {
+		return syncEvent(event.Detail)
+	}
+
+	cities, err := repository.GetCities()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, city := range cities {
+		if !connected(city) {
+			continue
+		}
+		if err := reconcile(city); err != nil {
+			// One city's system being down should not hold back everyone else's sync
+			warningLogger.Printf("Unable to reconcile work orders of %s: %s", city.CityName, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("work orders of %d cities not reconciled", failed)
+	}
+	return nil
+}
+
+// connected reports whether a city's requests are synced with a work-order system by this stack.  Federated cities'
+// requests are held by their own servers, and cities pinned to another region are synced by the stack there.
+func connected(city repository.City) bool {
+	return city.Config.WorkOrders.Connector != "" && !city.Federated && city.DataRegion() == repository.DataRegion()
+}
+
+// syncEvent creates the work order of a new request and pushes status changes to it.  Requests missed here are
+// caught up with by the next reconciliation.
+func syncEvent(detail json.RawMessage) error {
+	var requestEvent repository.RequestEvent
+	err := json.Unmarshal(detail, &requestEvent)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling domain event detail: %s", err)
+	}
+
+	request := requestEvent.Request
+	city, err := repository.GetCity(request.CityID)
+	if err != nil {
+		switch err.(type) {
+		case *repository.CityNotFoundErr:
+			return nil
+		default:
+			return err
+		}
+	}
+
+	settings := city.Config.WorkOrders
+	if !connected(city) || !settings.Handles(request.ServiceCode) {
+		return nil
+	}
+	connector, err := connect(city)
+	if err != nil {
+		return err
+	}
+
+	if request.WorkOrderID == "" {
+		if request.Status == repository.RequestClosed {
+			return nil
+		}
+		return createWorkOrder(connector, request)
+	}
+	if requestEvent.Type != repository.StatusChangedEvent {
+		return nil
+	}
+
+	// Statuses taken up from the work order come back as events too, and are not pushed back
+	status, err := connector.Status(request.WorkOrderID)
+	if err != nil {
+		return err
+	}
+	if mapped, ok := settings.RequestStatus(status); ok && mapped == request.Status {
+		return nil
+	}
+
+	err = connector.Update(request.WorkOrderID, request)
+	if err != nil {
+		return err
+	}
+	infoLogger.Printf("Pushed status %s of request %s to work order %s", request.Status, request.ServiceRequestID, request.WorkOrderID)
+	return nil
+}
+
+// reconcile brings the open requests of a city in line with their work orders.  A request that failed is retried
+// by the next reconciliation rather than holding back the rest.
+func reconcile(city repository.City) error {
+	settings := city.Config.WorkOrders
+	connector, err := connect(city)
+	if err != nil {
+		return err
+	}
+
+	requests, err := repository.GetRequests(city.CityName)
+	if err != nil {
+		return err
+	}
+
+	created, updated, failed := 0, 0, 0
+	for _, request := range requests {
+		if request.Status == repository.RequestClosed || !settings.Handles(request.ServiceCode) {
+			continue
+		}
+
+		if request.WorkOrderID == "" {
+			err = createWorkOrder(connector, request)
+			if err == nil {
+				created++
+			}
+		} else {
+			var changed bool
+			changed, err = syncStatus(connector, settings, request)
+			if changed {
+				updated++
+			}
+		}
+		if err != nil {
+			warningLogger.Printf("Unable to reconcile request %s: %s", request.ServiceRequestID, err)
+			failed++
+		}
+	}
+
+	infoLogger.Printf("Reconciled work orders of %s: %d created, %d statuses updated, %d failed", city.CityName, created, updated, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d requests not reconciled", failed)
+	}
+	return nil
+}
+
+// createWorkOrder makes a work order of a request and records its ID on the request
+func createWorkOrder(connector workorder.Connector, request repository.Request) error {
+	id, err := connector.Create(request)
+	if err != nil {
+		return err
+	}
+
+	err = repository.RecordWorkOrder(request.CityID, request.ServiceRequestID, id)
+	if err != nil {
+		return err
+	}
+	infoLogger.Printf("Created work order %s of request %s", id, request.ServiceRequestID)
+	return nil
+}
+
+// syncStatus takes up the status of a request's work order, reporting whether the request changed.  Work-order
+// statuses the city hasn't mapped leave the request as it is.
+func syncStatus(connector workorder.Connector, settings repository.WorkOrderSettings, request repository.Request) (bool, error) {
+	external, err := connector.Status(request.WorkOrderID)
+	if err != nil {
+		return false, err
+	}
+
+	status, ok := settings.RequestStatus(external)
+	if !ok || status == request.Status {
+		return false, nil
+	}
+
+	actor := settings.Connector + ":" + request.WorkOrderID
+	stored := request
+	request.Status = status
+	repository.AuditActor(actor)
+	defer repository.AuditActor("")
+	_, err = repository.UpdateRequest(request, stored, actor)
+	if err != nil {
+		return false, err
+	}
+	infoLogger.Printf("Request %s %s from work order %s", request.ServiceRequestID, status, request.WorkOrderID)
+	return true, nil
+}
+
+// connect returns the connector of a city's work-order system, with its credentials from Secrets Manager
+func connect(city repository.City) (workorder.Connector, error) {
+	settings := city.Config.WorkOrders
+	if !strings.HasPrefix(settings.SecretID, secretPrefix+city.CityName+"/") {
+		return nil, fmt.Errorf("work order secret of %s must be named %s%s/...", city.CityName, secretPrefix, city.CityName)
+	}
+	credentials, err := workorder.GetCredentials(settings.SecretID)
+	if err != nil {
+		return nil, err
+	}
+	return workorder.New(settings, credentials)
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestConnected(t *testing.T) {
+	city := repository.City{CityName: "troy"}
+	if connected(city) {
+		t.Error("connected() of a city without a connector = true, want false")
+	}
+
+	city.Config.WorkOrders.Connector = repository.ConnectorServiceNow
+	if !connected(city) {
+		t.Error("connected() of a city with a connector = false, want true")
+	}
+
+	city.Federated = true
+	if connected(city) {
+		t.Error("connected() of a federated city = true, want false")
+	}
+}
+
+func TestConnectOtherCitysSecret(t *testing.T) {
+	city := repository.City{CityName: "troy"}
+	city.Config.WorkOrders = repository.WorkOrderSettings{Connector: repository.ConnectorServiceNow, SecretID: "open311/albany/servicenow"}
+	if _, err := connect(city); err == nil {
+		t.Error("connect() with another city's secret should set an error")
+	}
+}
@@ -0,0 +1,251 @@
+package importer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Formats of the exports that can be imported
+const (
+	FormatSeeClickFix   = "seeclickfix"   // CSV of issues exported from the SeeClickFix dashboard
+	FormatConnectedBits = "connectedbits" // JSON array of GeoReport v2 service requests, as Connected Bits exports them
+)
+
+// Record is a request as exported by another 311 system, before its category is mapped to one of our services
+type Record struct {
+	ID           string // ID in the exporting system
+	Category     string // Name of the request type, eg "Pothole"
+	CategoryCode string // Code of the request type, when the export has one
+	Status       string // One of our Request* statuses
+	StatusNotes  string
+	Description  string
+	Address      string
+	Lat          float64
+	Lon          float64
+	Requested    time.Time
+	Updated      time.Time
+	Closed       time.Time // Zero while open
+}
+
+// Parse reads the records of an export.  Times without a zone are in loc, the city's time zone.
+func Parse(format string, export io.Reader, loc *time.Location) ([]Record, error) {
+	switch format {
+	case FormatSeeClickFix:
+		return parseSeeClickFix(export, loc)
+	case FormatConnectedBits:
+		return parseConnectedBits(export, loc)
+	}
+	return nil, fmt.Errorf("importer: unknown format '%s', want %s or %s", format, FormatSeeClickFix, FormatConnectedBits)
+}
+
+// seeClickFixColumns are the names each field has gone by in SeeClickFix exports, normalized by column
+var seeClickFixColumns = map[string][]string{
+	"id":          {"issueid", "id"},
+	"category":    {"requesttype", "category", "summary"},
+	"status":      {"status"},
+	"description": {"description"},
+	"address":     {"address"},
+	"lat":         {"lat", "latitude"},
+	"lon":         {"lng", "lon", "longitude"},
+	"created":     {"createdat", "created"},
+	"updated":     {"updatedat", "updated"},
+	"closed":      {"closedat", "closed"},
+}
+
+// seeClickFixStatuses maps SeeClickFix issue statuses to ours
+var seeClickFixStatuses = map[string]string{
+	"open":         "open",
+	"acknowledged": "accepted",
+	"closed":       "closed",
+	"archived":     "closed",
+}
+
+// parseSeeClickFix reads a CSV export of SeeClickFix issues
+func parseSeeClickFix(export io.Reader, loc *time.Location) ([]Record, error) {
+	rows, err := csv.NewReader(export).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("importer: unable to read SeeClickFix CSV \n %s", err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("importer: SeeClickFix CSV has no header row")
+	}
+
+	columns := map[string]int{}
+	for i, name := range rows[0] {
+		columns[normalize(name)] = i
+	}
+	index := map[string]int{}
+	for field, names := range seeClickFixColumns {
+		index[field] = -1
+		for _, name := range names {
+			if i, ok := columns[name]; ok {
+				index[field] = i
+				break
+			}
+		}
+	}
+	for _, required := range []string{"id", "category", "status", "created"} {
+		if index[required] < 0 {
+			return nil, fmt.Errorf("importer: SeeClickFix CSV has no %s column", required)
+		}
+	}
+
+	records := []Record{}
+	for n, row := range rows[1:] {
+		value := func(field string) string {
+			if i := index[field]; i >= 0 && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		line := n + 2
+
+		status, ok := seeClickFixStatuses[strings.ToLower(value("status"))]
+		if !ok {
+			return nil, fmt.Errorf("importer: line %d: unknown status '%s'", line, value("status"))
+		}
+		r := Record{
+			ID:          value("id"),
+			Category:    value("category"),
+			Status:      status,
+			Description: value("description"),
+			Address:     value("address"),
+		}
+		if r.Lat, err = coordinate(value("lat")); err != nil {
+			return nil, fmt.Errorf("importer: line %d: %s", line, err)
+		}
+		if r.Lon, err = coordinate(value("lon")); err != nil {
+			return nil, fmt.Errorf("importer: line %d: %s", line, err)
+		}
+		if r.Requested, err = parseTime(value("created"), loc); err != nil || r.Requested.IsZero() {
+			return nil, fmt.Errorf("importer: line %d: created at '%s' is not a time", line, value("created"))
+		}
+		if r.Updated, err = parseTime(value("updated"), loc); err != nil {
+			return nil, fmt.Errorf("importer: line %d: %s", line, err)
+		}
+		if r.Closed, err = parseTime(value("closed"), loc); err != nil {
+			return nil, fmt.Errorf("importer: line %d: %s", line, err)
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// georeportRequest is a service request in the GeoReport v2 JSON Connected Bits exports
+type georeportRequest struct {
+	ServiceRequestID  string      `json:"service_request_id"`
+	Status            string      `json:"status"`
+	StatusNotes       string      `json:"status_notes"`
+	ServiceName       string      `json:"service_name"`
+	ServiceCode       string      `json:"service_code"`
+	Description       string      `json:"description"`
+	RequestedDateTime string      `json:"requested_datetime"`
+	UpdatedDateTime   string      `json:"updated_datetime"`
+	Address           string      `json:"address"`
+	Lat               json.Number `json:"lat"`
+	Long              json.Number `json:"long"`
+}
+
+// parseConnectedBits reads a JSON export of GeoReport v2 service requests.  GeoReport only knows open and closed
+// requests, and closed requests were closed when they were last updated.
+func parseConnectedBits(export io.Reader, loc *time.Location) ([]Record, error) {
+	var requests []georeportRequest
+	err := json.NewDecoder(export).Decode(&requests)
+	if err != nil {
+		return nil, fmt.Errorf("importer: unable to read GeoReport JSON \n %s", err)
+	}
+
+	records := []Record{}
+	for n, request := range requests {
+		r := Record{
+			ID:           request.ServiceRequestID,
+			Category:     request.ServiceName,
+			CategoryCode: request.ServiceCode,
+			StatusNotes:  request.StatusNotes,
+			Description:  request.Description,
+			Address:      request.Address,
+		}
+		switch strings.ToLower(request.Status) {
+		case "open":
+			r.Status = "open"
+		case "closed":
+			r.Status = "closed"
+		default:
+			return nil, fmt.Errorf("importer: request %d: unknown status '%s'", n+1, request.Status)
+		}
+		if r.Lat, err = coordinate(request.Lat.String()); err != nil {
+			return nil, fmt.Errorf("importer: request %d: %s", n+1, err)
+		}
+		if r.Lon, err = coordinate(request.Long.String()); err != nil {
+			return nil, fmt.Errorf("importer: request %d: %s", n+1, err)
+		}
+		if r.Requested, err = parseTime(request.RequestedDateTime, loc); err != nil || r.Requested.IsZero() {
+			return nil, fmt.Errorf("importer: request %d: requested_datetime '%s' is not a time", n+1, request.RequestedDateTime)
+		}
+		if r.Updated, err = parseTime(request.UpdatedDateTime, loc); err != nil {
+			return nil, fmt.Errorf("importer: request %d: %s", n+1, err)
+		}
+		if r.Status == "closed" {
+			r.Closed = r.Updated
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// timeLayouts are the layouts times are exported in, those with a zone first
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05 MST",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"01/02/2006 15:04:05",
+	"01/02/2006 15:04",
+	"1/2/2006 3:04 PM",
+}
+
+// parseTime reads an exported time, taking those without a zone to be in loc.  An empty time is zero.
+func parseTime(value string, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range timeLayouts {
+		t, err := time.ParseInLocation(layout, value, loc)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("'%s' is not a time", value)
+}
+
+// coordinate reads an exported latitude or longitude.  An empty coordinate is 0.
+func coordinate(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not a coordinate", value)
+	}
+	return f, nil
+}
+
+// normalize lowercases a column or category name and drops everything but letters and digits, so "Issue ID"
+// matches "issue_id"
+func normalize(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,141 @@
+// Package importer brings the history of a city's previous 311 system into the platform, so cities switching to
+// it keep their requests.  Exports are parsed into records, whose categories are mapped to the city's services,
+// and stored as requests keeping their original IDs, as external_id, and times.
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+// Mapping maps the categories of an export, by name or code, to the service codes of the city
+type Mapping map[string]string
+
+// ReadMapping reads a mapping from JSON, eg {"Pothole": "troy-pothole", "Graffiti Removal": "troy-graffiti"}
+func ReadMapping(r io.Reader) (Mapping, error) {
+	mapping := Mapping{}
+	err := json.NewDecoder(r).Decode(&mapping)
+	if err != nil {
+		return nil, fmt.Errorf("importer: unable to read mapping \n %s", err)
+	}
+	return mapping, nil
+}
+
+// serviceCode returns the service a record's category maps to, matching the category's code before its name, and
+// names regardless of case and punctuation
+func (m Mapping) serviceCode(r Record) (string, bool) {
+	if code, ok := m[r.CategoryCode]; ok && r.CategoryCode != "" {
+		return code, true
+	}
+	if code, ok := m[r.Category]; ok {
+		return code, true
+	}
+	for category, code := range m {
+		if normalize(category) == normalize(r.Category) {
+			return code, true
+		}
+	}
+	return "", false
+}
+
+// Result is the outcome of an import
+type Result struct {
+	Imported int      `json:"imported"` // Requests stored, or for a dry run, that would be
+	Skipped  int      `json:"skipped"`  // Requests stored by an earlier import of the same export
+	Unmapped []string `json:"unmapped"` // Categories with no service in the mapping.  Nothing is imported until every one is mapped
+}
+
+// toRequest converts a record of a system into a request of a city
+func toRequest(system string, cityID string, serviceCode string, r Record) (repository.Request, error) {
+	request := repository.Request{
+		ExternalID:        system + ":" + r.ID,
+		CityID:            cityID,
+		ServiceCode:       serviceCode,
+		Status:            r.Status,
+		StatusNotes:       r.StatusNotes,
+		Description:       r.Description,
+		Address:           r.Address,
+		RequestedDateTime: r.Requested.UTC().Format(time.RFC3339),
+		UpdatedDateTime:   r.Requested.UTC().Format(time.RFC3339),
+	}
+	if !r.Updated.IsZero() {
+		request.UpdatedDateTime = r.Updated.UTC().Format(time.RFC3339)
+	}
+	if r.Status == repository.RequestClosed && !r.Closed.IsZero() {
+		request.ClosedDateTime = r.Closed.UTC().Format(time.RFC3339)
+	}
+
+	if r.Lat != 0 || r.Lon != 0 {
+		location, err := repository.NewLocation(r.Lat, r.Lon)
+		if err != nil {
+			return request, fmt.Errorf("importer: %s: %s", request.ExternalID, err)
+		}
+		request.Location = location
+	}
+	if !request.HasLocation() && request.Address == "" {
+		return request, fmt.Errorf("importer: %s has no location", request.ExternalID)
+	}
+	return request, nil
+}
+
+// Import stores the requests of an export in a city.  Every category must be mapped to one of the city's
+// services before anything is stored; otherwise the unmapped categories are returned.  Records that can't be
+// converted stop the import, and importing again picks up where it stopped.  A dry run checks the export and
+// mapping without storing anything.
+func Import(cityID string, format string, export io.Reader, mapping Mapping, dryRun bool) (Result, error) {
+	city, err := repository.GetCity(cityID)
+	if err != nil {
+		return Result{}, err
+	}
+	records, err := Parse(format, export, city.Config.Location())
+	if err != nil {
+		return Result{}, err
+	}
+
+	services, err := repository.GetServices(cityID)
+	if err != nil {
+		return Result{}, err
+	}
+	offered := map[string]repository.Service{}
+	for _, service := range services {
+		offered[service.ServiceCode] = service
+	}
+
+	result := Result{Unmapped: []string{}}
+	unmapped := map[string]bool{}
+	requests := []repository.Request{}
+	for _, r := range records {
+		code, ok := mapping.serviceCode(r)
+		if !ok {
+			unmapped[r.Category] = true
+			continue
+		}
+		if _, ok := offered[code]; !ok {
+			return result, fmt.Errorf("importer: %s is mapped to %s, which %s doesn't offer", r.Category, code, cityID)
+		}
+		request, err := toRequest(format, cityID, code, r)
+		if err != nil {
+			return result, err
+		}
+		requests = append(requests, request)
+	}
+	for category := range unmapped {
+		result.Unmapped = append(result.Unmapped, category)
+	}
+	sort.Strings(result.Unmapped)
+	if len(result.Unmapped) > 0 {
+		return result, nil
+	}
+	if dryRun {
+		result.Imported = len(requests)
+		return result, nil
+	}
+
+	result.Imported, result.Skipped, err = repository.ImportRequests(requests, offered, city)
+	return result, err
+}
@@ -0,0 +1,103 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestParseSeeClickFix(t *testing.T) {
+	newYork, _ := time.LoadLocation("America/New_York")
+	export := `Issue ID,Summary,Request Type,Description,Status,Created At,Closed At,Address,Lat,Lng
+1234567,Pothole on 4th,Pothole,Deep one,Archived,2016-03-01 08:00:00,2016-03-04 08:00:00,"1 Monument Sq, Troy",42.73,-73.69
+1234568,Tagging,Graffiti Removal,,Acknowledged,2016-03-02T10:00:00Z,,,,
+`
+	records, err := Parse(FormatSeeClickFix, strings.NewReader(export), newYork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Parse() = %d records, want 2", len(records))
+	}
+
+	first := records[0]
+	if first.ID != "1234567" || first.Category != "Pothole" || first.Status != repository.RequestClosed || first.Lat != 42.73 || first.Address != "1 Monument Sq, Troy" {
+		t.Errorf("first record = %+v", first)
+	}
+	// Times without a zone are in the city's
+	if !first.Requested.Equal(time.Date(2016, 3, 1, 13, 0, 0, 0, time.UTC)) || !first.Closed.Equal(time.Date(2016, 3, 4, 13, 0, 0, 0, time.UTC)) {
+		t.Errorf("first record times = %s to %s, want 13:00 UTC", first.Requested, first.Closed)
+	}
+	if second := records[1]; second.Status != repository.RequestAccepted || !second.Closed.IsZero() || second.Lat != 0 {
+		t.Errorf("second record = %+v, want an accepted request without a location", second)
+	}
+
+	if _, err := Parse(FormatSeeClickFix, strings.NewReader("Issue ID,Status,Created At\n1,Open,2016-03-01\n"), time.UTC); err == nil {
+		t.Error("Parse() of an export without categories succeeded")
+	}
+}
+
+func TestParseConnectedBits(t *testing.T) {
+	export := `[
+		{"service_request_id": "638344", "status": "closed", "status_notes": "Filled", "service_name": "Pothole", "service_code": "PTH",
+		 "requested_datetime": "2016-03-01T08:00:00-05:00", "updated_datetime": "2016-03-02T08:00:00-05:00", "lat": 42.73, "long": "-73.69"},
+		{"service_request_id": "638345", "status": "open", "service_name": "Streetlight", "requested_datetime": "2016-03-01T09:00:00-05:00", "address": "2 River St"}
+	]`
+	records, err := Parse(FormatConnectedBits, strings.NewReader(export), time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Parse() = %d records, want 2", len(records))
+	}
+	if r := records[0]; r.CategoryCode != "PTH" || r.Lon != -73.69 || !r.Closed.Equal(r.Updated) || r.StatusNotes != "Filled" {
+		t.Errorf("closed record = %+v, want closed when last updated", r)
+	}
+	if r := records[1]; r.Status != repository.RequestOpen || !r.Closed.IsZero() {
+		t.Errorf("open record = %+v", r)
+	}
+
+	if _, err := Parse("open311", strings.NewReader(export), time.UTC); err == nil {
+		t.Error("Parse() of an unknown format succeeded")
+	}
+}
+
+func TestMapping(t *testing.T) {
+	mapping, err := ReadMapping(strings.NewReader(`{"PTH": "troy-pothole", "Graffiti Removal": "troy-graffiti"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		record Record
+		want   string
+		ok     bool
+	}{
+		{Record{Category: "Pothole", CategoryCode: "PTH"}, "troy-pothole", true},
+		{Record{Category: "graffiti removal"}, "troy-graffiti", true},
+		{Record{Category: "Streetlight"}, "", false},
+	}
+	for _, tt := range tests {
+		if code, ok := mapping.serviceCode(tt.record); code != tt.want || ok != tt.ok {
+			t.Errorf("serviceCode(%+v) = %s, %v, want %s, %v", tt.record, code, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestToRequest(t *testing.T) {
+	requested := time.Date(2016, 3, 1, 13, 0, 0, 0, time.UTC)
+	r := Record{ID: "1234567", Status: repository.RequestClosed, Lat: 42.73, Lon: -73.69, Requested: requested, Closed: requested.Add(72 * time.Hour)}
+	request, err := toRequest(FormatSeeClickFix, "troy", "troy-pothole", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.ExternalID != "seeclickfix:1234567" || request.RequestedDateTime != "2016-03-01T13:00:00Z" || request.ClosedDateTime != "2016-03-04T13:00:00Z" ||
+		request.UpdatedDateTime != request.RequestedDateTime || !request.HasLocation() {
+		t.Errorf("toRequest() = %+v", request)
+	}
+
+	if _, err := toRequest(FormatSeeClickFix, "troy", "troy-pothole", Record{ID: "1", Requested: requested}); err == nil {
+		t.Error("toRequest() of a record without a location or address succeeded")
+	}
+}
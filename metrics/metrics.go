@@ -0,0 +1,111 @@
+// Package metrics emits CloudWatch metrics in the embedded metric format: JSON log lines CloudWatch Logs turns into
+// metrics, so handlers publish them without calling CloudWatch or waiting on it.  Operators build dashboards and
+// alarms on the Open311 namespace rather than scraping logs.
+package metrics
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Namespace is the CloudWatch namespace metrics are published in
+const Namespace = "Open311"
+
+// Units of metric values
+const (
+	UnitCount        = "Count"
+	UnitMilliseconds = "Milliseconds"
+)
+
+// Dimension is a name and value a metric is broken down by, eg City "troy"
+type Dimension struct {
+	Name  string
+	Value string
+}
+
+// Metrics are written to stdout, where Lambda sends them to CloudWatch Logs
+var (
+	mu  sync.Mutex
+	out io.Writer = os.Stdout
+	now           = time.Now
+)
+
+// Count adds one to a metric
+func Count(name string, dimensions ...Dimension) {
+	Emit(name, UnitCount, 1, dimensions...)
+}
+
+// Duration records the time since start as a metric in milliseconds
+func Duration(name string, start time.Time, dimensions ...Dimension) {
+	Emit(name, UnitMilliseconds, float64(now().Sub(start))/float64(time.Millisecond), dimensions...)
+}
+
+// Emit records a value of a metric.  The metric is published for each leading run of its dimensions, so a count
+// by City and ServiceCode can also be read per city.  Values of dimensions that are empty are published as "none",
+// since CloudWatch refuses empty ones.  Metrics that can't be written are dropped rather than failing the caller.
+func Emit(name string, unit string, value float64, dimensions ...Dimension) {
+	line, err := json.Marshal(document(name, unit, value, dimensions, now()))
+	if err != nil {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	out.Write(append(line, '\n'))
+	collect(name, unit, value, dimensions)
+}
+
+// document returns the embedded metric format document of a value
+func document(name string, unit string, value float64, dimensions []Dimension, at time.Time) map[string]interface{} {
+	sets := [][]string{}
+	names := []string{}
+	doc := map[string]interface{}{name: value}
+	for _, d := range dimensions {
+		names = append(names, d.Name)
+		sets = append(sets, append([]string{}, names...))
+
+		if d.Value == "" {
+			doc[d.Name] = "none"
+		} else {
+			doc[d.Name] = d.Value
+		}
+	}
+	if len(sets) == 0 {
+		// A metric without dimensions is published once, in total
+		sets = [][]string{{}}
+	}
+
+	doc["_aws"] = map[string]interface{}{
+		"Timestamp": at.UnixNano() / int64(time.Millisecond),
+		"CloudWatchMetrics": []map[string]interface{}{{
+			"Namespace":  Namespace,
+			"Dimensions": sets,
+			"Metrics":    []map[string]string{{"Name": name, "Unit": unit}},
+		}},
+	}
+	return doc
+}
+
+// APIHandler is a Lambda handler of API Gateway proxy requests
+type APIHandler func(events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
+
+// Handler wraps the router of an API handler, recording the HandlerLatency of every call and counting those
+// answered with a 5xx as HandlerErrors.  Both are broken down by Handler, then by Route, eg "GET /requests".
+func Handler(name string, h APIHandler) APIHandler {
+	return func(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		start := now()
+		resp, err := h(req)
+
+		dimensions := []Dimension{{"Handler", name}, {"Route", req.HTTPMethod + " " + req.Resource}}
+		Duration("HandlerLatency", start, dimensions...)
+		if err != nil || resp.StatusCode >= 500 {
+			Count("HandlerErrors", dimensions...)
+		}
+		return resp, err
+	}
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// capture sends metrics to a buffer at a fixed time, until the returned func is called
+func capture() (*bytes.Buffer, func()) {
+	var buf bytes.Buffer
+	out, now = &buf, func() time.Time { return time.Unix(1600000000, 0) }
+	return &buf, func() { out, now = os.Stdout, time.Now }
+}
+
+func TestEmit(t *testing.T) {
+	buf, restore := capture()
+	defer restore()
+	Count("RequestsSubmitted", Dimension{"City", "troy"}, Dimension{"ServiceCode", ""})
+
+	want := `{"City":"troy","RequestsSubmitted":1,"ServiceCode":"none","_aws":{"CloudWatchMetrics":[{"Dimensions":[["City"],["City","ServiceCode"]],` +
+		`"Metrics":[{"Name":"RequestsSubmitted","Unit":"Count"}],"Namespace":"Open311"}],"Timestamp":1600000000000}}` + "\n"
+	if buf.String() != want {
+		t.Errorf("Count() wrote %s, want %s", buf.String(), want)
+	}
+
+	buf.Reset()
+	Emit("Total", UnitCount, 3)
+	var doc struct {
+		AWS struct {
+			CloudWatchMetrics []struct {
+				Dimensions [][]string
+			}
+		} `json:"_aws"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Emit() wrote %s: %s", buf.String(), err)
+	}
+	if dims := doc.AWS.CloudWatchMetrics[0].Dimensions; len(dims) != 1 || len(dims[0]) != 0 {
+		t.Errorf("dimensions without any = %v, want [[]]", dims)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	buf, restore := capture()
+	defer restore()
+	responses := []struct {
+		resp events.APIGatewayProxyResponse
+		err  error
+	}{
+		{events.APIGatewayProxyResponse{StatusCode: http.StatusOK}, nil},
+		{events.APIGatewayProxyResponse{StatusCode: http.StatusNotFound}, nil},
+		{events.APIGatewayProxyResponse{StatusCode: http.StatusInternalServerError}, nil},
+		{events.APIGatewayProxyResponse{}, errors.New("boom")},
+	}
+
+	for _, r := range responses {
+		buf.Reset()
+		h := Handler("request", func(events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+			return r.resp, r.err
+		})
+		resp, err := h(events.APIGatewayProxyRequest{HTTPMethod: "GET", Resource: "/requests"})
+		if resp.StatusCode != r.resp.StatusCode || err != r.err {
+			t.Errorf("Handler() = %d, %v, want %d, %v", resp.StatusCode, err, r.resp.StatusCode, r.err)
+		}
+
+		if !strings.Contains(buf.String(), `"HandlerLatency":0`) || !strings.Contains(buf.String(), `"Route":"GET /requests"`) {
+			t.Errorf("Handler() latency metric = %s", buf.String())
+		}
+		wantError := r.err != nil || r.resp.StatusCode >= 500
+		if strings.Contains(buf.String(), `"HandlerErrors"`) != wantError {
+			t.Errorf("Handler() with %d, %v wrote %s, want error counted %v", r.resp.StatusCode, r.err, buf.String(), wantError)
+		}
+	}
+}
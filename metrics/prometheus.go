@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Self-hosted deployments running the handlers outside Lambda have no CloudWatch Logs to turn metrics into
+// CloudWatch metrics, so they can also be kept in memory and scraped by Prometheus.  Collection is off until
+// PrometheusHandler is called, so Lambda functions don't accumulate series they never serve.
+var (
+	collecting bool
+	series     = map[string]*sample{}
+)
+
+// sample is the running total of one metric with one set of labels
+type sample struct {
+	name   string
+	unit   string
+	labels string // eg {city="troy"}, or empty
+	sum    float64
+	count  int64
+}
+
+// PrometheusHandler serves the metrics emitted since it was first called in the Prometheus text format, for
+// mounting at /metrics.  Counts are exposed as counters named open311_{name}_total, eg
+// open311_requests_submitted_total{city="troy",service_code="pothole"}, and durations as summaries of their
+// _sum and _count in milliseconds.  Each metric carries all its dimensions as labels; sum them away in queries
+// rather than expecting the per city series CloudWatch publishes.
+func PrometheusHandler() http.Handler {
+	mu.Lock()
+	collecting = true
+	mu.Unlock()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(exposition()))
+	})
+}
+
+// collect adds a value to its series, when collecting
+func collect(name string, unit string, value float64, dimensions []Dimension) {
+	if !collecting {
+		return
+	}
+
+	labels := []string{}
+	for _, d := range dimensions {
+		v := d.Value
+		if v == "" {
+			v = "none"
+		}
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, snakeCase(d.Name), escapeLabel(v)))
+	}
+	set := ""
+	if len(labels) > 0 {
+		set = "{" + strings.Join(labels, ",") + "}"
+	}
+	key := name + set
+
+	s, ok := series[key]
+	if !ok {
+		s = &sample{name: name, unit: unit, labels: set}
+		series[key] = s
+	}
+	s.sum += value
+	s.count++
+}
+
+// exposition returns the collected series in the Prometheus text format, grouped by metric
+func exposition() string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	samples := make([]*sample, 0, len(series))
+	for _, s := range series {
+		samples = append(samples, s)
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].name != samples[j].name {
+			return samples[i].name < samples[j].name
+		}
+		return samples[i].labels < samples[j].labels
+	})
+
+	var b strings.Builder
+	last := ""
+	for _, s := range samples {
+		name := "open311_" + snakeCase(s.name)
+		if s.unit == UnitMilliseconds {
+			name += "_milliseconds"
+		}
+
+		if s.name != last {
+			if s.unit == UnitCount {
+				fmt.Fprintf(&b, "# TYPE %s_total counter\n", name)
+			} else {
+				fmt.Fprintf(&b, "# TYPE %s summary\n", name)
+			}
+			last = s.name
+		}
+
+		if s.unit == UnitCount {
+			fmt.Fprintf(&b, "%s_total%s %g\n", name, s.labels, s.sum)
+		} else {
+			fmt.Fprintf(&b, "%s_sum%s %g\n", name, s.labels, s.sum)
+			fmt.Fprintf(&b, "%s_count%s %d\n", name, s.labels, s.count)
+		}
+	}
+	return b.String()
+}
+
+// snakeCase turns a CloudWatch name into a Prometheus one, eg ServiceCode into service_code
+func snakeCase(name string) string {
+	var b strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// Start a word at an upper case letter, but keep runs of them such as DB together
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+				b.WriteRune('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// escapeLabel escapes a label value for the text format
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPrometheusHandler(t *testing.T) {
+	_, restore := capture()
+	defer restore()
+	handler := PrometheusHandler()
+	defer func() { collecting, series = false, map[string]*sample{} }()
+
+	Count("RequestsSubmitted", Dimension{"City", "troy"}, Dimension{"ServiceCode", "pothole"})
+	Count("RequestsSubmitted", Dimension{"City", "troy"}, Dimension{"ServiceCode", "pothole"})
+	Count("RequestsSubmitted", Dimension{"City", "albany"}, Dimension{"ServiceCode", ""})
+	Emit("HandlerLatency", UnitMilliseconds, 12.5, Dimension{"Route", `GET /say "hi"`})
+	Emit("HandlerLatency", UnitMilliseconds, 7.5, Dimension{"Route", `GET /say "hi"`})
+	Duration("DynamoDBErrors", time.Unix(1600000000, 0))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	want := "# TYPE open311_dynamo_db_errors_milliseconds summary\n" +
+		"open311_dynamo_db_errors_milliseconds_sum 0\n" +
+		"open311_dynamo_db_errors_milliseconds_count 1\n" +
+		"# TYPE open311_handler_latency_milliseconds summary\n" +
+		`open311_handler_latency_milliseconds_sum{route="GET /say \"hi\""} 20` + "\n" +
+		`open311_handler_latency_milliseconds_count{route="GET /say \"hi\""} 2` + "\n" +
+		"# TYPE open311_requests_submitted_total counter\n" +
+		`open311_requests_submitted_total{city="albany",service_code="none"} 1` + "\n" +
+		`open311_requests_submitted_total{city="troy",service_code="pothole"} 2` + "\n"
+	if w.Body.String() != want {
+		t.Errorf("PrometheusHandler() served\n%s\nwant\n%s", w.Body.String(), want)
+	}
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/social-torch/open311-services/awsclient"
+)
+
+// Total is the sum of a metric over a period for one set of values of its dimensions
+type Total struct {
+	Dimensions map[string]string
+	Sum        float64
+}
+
+// maxQueries is how many metrics CloudWatch sums in one GetMetricData call
+const maxQueries = 500
+
+// Totals sums a metric published in this region from start to end, for each set of values of exactly the given
+// dimensions, eg the ConsumedReadCapacity of every Table, or of every Table and Route.  Metrics are only read back
+// for reports; nothing on the request path waits on CloudWatch.
+func Totals(name string, dimensions []string, start time.Time, end time.Time) ([]Total, error) {
+	svc := cloudwatch.New(awsclient.Session())
+
+	// Find the values the dimensions were published with
+	var found []*cloudwatch.Metric
+	err := svc.ListMetricsPages(&cloudwatch.ListMetricsInput{
+		Namespace:  aws.String(Namespace),
+		MetricName: aws.String(name),
+	}, func(page *cloudwatch.ListMetricsOutput, lastPage bool) bool {
+		for _, m := range page.Metrics {
+			if sameDimensions(m.Dimensions, dimensions) {
+				found = append(found, m)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("metrics: unable to list %s \n %s", name, err)
+	}
+
+	// A single period spanning start to end sums each metric in one value.  Periods are whole minutes.
+	period := int64(end.Sub(start).Minutes()+1) * 60
+
+	totals := make([]Total, len(found))
+	for offset := 0; offset < len(found); offset += maxQueries {
+		queries := []*cloudwatch.MetricDataQuery{}
+		for i := offset; i < len(found) && i < offset+maxQueries; i++ {
+			totals[i].Dimensions = map[string]string{}
+			for _, d := range found[i].Dimensions {
+				totals[i].Dimensions[aws.StringValue(d.Name)] = aws.StringValue(d.Value)
+			}
+			queries = append(queries, &cloudwatch.MetricDataQuery{
+				Id: aws.String("m" + strconv.Itoa(i)),
+				MetricStat: &cloudwatch.MetricStat{
+					Metric: found[i],
+					Period: aws.Int64(period),
+					Stat:   aws.String(cloudwatch.StatisticSum),
+				},
+			})
+		}
+
+		err = svc.GetMetricDataPages(&cloudwatch.GetMetricDataInput{
+			MetricDataQueries: queries,
+			StartTime:         aws.Time(start),
+			EndTime:           aws.Time(end),
+		}, func(page *cloudwatch.GetMetricDataOutput, lastPage bool) bool {
+			for _, result := range page.MetricDataResults {
+				i, err := strconv.Atoi(aws.StringValue(result.Id)[1:])
+				if err != nil || i >= len(totals) {
+					continue
+				}
+				for _, v := range result.Values {
+					totals[i].Sum += aws.Float64Value(v)
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("metrics: unable to sum %s \n %s", name, err)
+		}
+	}
+	return totals, nil
+}
+
+// sameDimensions reports whether a metric was published with exactly the named dimensions
+func sameDimensions(published []*cloudwatch.Dimension, names []string) bool {
+	if len(published) != len(names) {
+		return false
+	}
+	want := map[string]bool{}
+	for _, name := range names {
+		want[name] = true
+	}
+	for _, d := range published {
+		if !want[aws.StringValue(d.Name)] {
+			return false
+		}
+	}
+	return true
+}
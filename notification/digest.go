@@ -0,0 +1,77 @@
+package notification
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+// Digest summarizes the requests covered by a user's subscriptions that were created or resolved in a period
+type Digest struct {
+	Created  []repository.Request
+	Resolved []repository.Request
+}
+
+// NewDigest collects the requests matching any of the subscriptions that were created or closed since the
+// given time
+func NewDigest(subscriptions []repository.Subscription, requests []repository.Request, since time.Time) Digest {
+	var digest Digest
+	for _, request := range requests {
+		if !matchesAny(subscriptions, request) {
+			continue
+		}
+
+		if after(request.RequestedDateTime, since) {
+			digest.Created = append(digest.Created, request)
+		}
+
+		// UpdatedDateTime of a closed request is the date it was closed
+		if request.Status == repository.RequestClosed && after(request.UpdatedDateTime, since) {
+			digest.Resolved = append(digest.Resolved, request)
+		}
+	}
+	return digest
+}
+
+// Empty reports whether there is nothing to send
+func (d Digest) Empty() bool {
+	return len(d.Created) == 0 && len(d.Resolved) == 0
+}
+
+// Summary renders the digest as plain text, one request per line
+func (d Digest) Summary() string {
+	var b strings.Builder
+	if len(d.Created) > 0 {
+		b.WriteString("New requests:\n")
+		for _, r := range d.Created {
+			fmt.Fprintf(&b, "- %s at %s (%s)\n", r.ServiceName, r.Address, r.ServiceRequestID)
+		}
+	}
+	if len(d.Resolved) > 0 {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("Resolved requests:\n")
+		for _, r := range d.Resolved {
+			fmt.Fprintf(&b, "- %s at %s (%s)\n", r.ServiceName, r.Address, r.ServiceRequestID)
+		}
+	}
+	return b.String()
+}
+
+func matchesAny(subscriptions []repository.Subscription, request repository.Request) bool {
+	for _, subscription := range subscriptions {
+		if Matches(subscription, request) {
+			return true
+		}
+	}
+	return false
+}
+
+// after reports whether an RFC3339 timestamp is at or after t.  Invalid timestamps are never after
+func after(timestamp string, t time.Time) bool {
+	parsed, err := time.Parse(time.RFC3339, timestamp)
+	return err == nil && !parsed.Before(t)
+}
@@ -0,0 +1,49 @@
+package notification
+
+import (
+	"testing"
+	"time"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestNewDigest(t *testing.T) {
+	since := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+	subscriptions := []repository.Subscription{
+		{Type: repository.ServiceSubscription, ServiceCode: "pothole"},
+	}
+	requests := []repository.Request{
+		{ServiceRequestID: "new", ServiceCode: "pothole", Status: repository.RequestOpen,
+			RequestedDateTime: "2019-06-01T09:00:00Z", UpdatedDateTime: "2019-06-01T09:00:00Z"},
+		{ServiceRequestID: "resolved", ServiceCode: "pothole", Status: repository.RequestClosed,
+			RequestedDateTime: "2019-05-20T09:00:00Z", UpdatedDateTime: "2019-06-01T12:00:00Z"},
+		{ServiceRequestID: "old", ServiceCode: "pothole", Status: repository.RequestClosed,
+			RequestedDateTime: "2019-05-20T09:00:00Z", UpdatedDateTime: "2019-05-25T12:00:00Z"},
+		{ServiceRequestID: "unsubscribed", ServiceCode: "graffiti", Status: repository.RequestOpen,
+			RequestedDateTime: "2019-06-01T09:00:00Z"},
+	}
+
+	digest := NewDigest(subscriptions, requests, since)
+	if len(digest.Created) != 1 || digest.Created[0].ServiceRequestID != "new" {
+		t.Errorf("Created = %+v, want only request 'new'", digest.Created)
+	}
+	if len(digest.Resolved) != 1 || digest.Resolved[0].ServiceRequestID != "resolved" {
+		t.Errorf("Resolved = %+v, want only request 'resolved'", digest.Resolved)
+	}
+
+	if !NewDigest(nil, requests, since).Empty() {
+		t.Error("digest without subscriptions should be empty")
+	}
+}
+
+func TestDigestSummary(t *testing.T) {
+	digest := Digest{
+		Created:  []repository.Request{{ServiceRequestID: "1", ServiceName: "Pothole", Address: "1 Main St"}},
+		Resolved: []repository.Request{{ServiceRequestID: "2", ServiceName: "Graffiti", Address: "2 Elm St"}},
+	}
+
+	want := "New requests:\n- Pothole at 1 Main St (1)\n\nResolved requests:\n- Graffiti at 2 Elm St (2)\n"
+	if got := digest.Summary(); got != want {
+		t.Errorf("Summary() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,75 @@
+package notification
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/social-torch/open311-services/awsclient"
+	"github.com/social-torch/open311-services/repository"
+)
+
+// Names of the SES templates used for email notifications
+const (
+	StatusChangedTemplate          = "RequestStatusChanged"   // a request the user submitted changed status
+	OnboardingConfirmationTemplate = "OnboardingConfirmation" // a city's onboarding request was received
+	OnboardingLeadTemplate         = "OnboardingLead"         // tells the platform team about an onboarding request
+	DigestTemplate                 = "RequestDigest"          // summary of a user's subscriptions over a day or week
+	AgencyNewRequestTemplate       = "AgencyNewRequest"       // a request was assigned to an agency
+	SLABreachTemplate              = "SLABreach"              // a request is past its resolution time
+	VolumeAnomalyTemplate          = "VolumeAnomaly"          // tells the platform team about an abnormal spike or drought of requests
+	EmailRequestReceivedTemplate   = "EmailRequestReceived"   // a request emailed in was made, with its tracking ID
+	EmailRequestRejectedTemplate   = "EmailRequestRejected"   // a request emailed in couldn't be made, and why
+)
+
+// Sender returns the address email about a city is sent from.  Cities with their own verified SES identity
+// set sender_email on their Cities record; everything else is sent from SENDER_EMAIL.
+func Sender(city repository.City) string {
+	if city.SenderEmail != "" {
+		return city.SenderEmail
+	}
+	return os.Getenv("SENDER_EMAIL")
+}
+
+// SendEmail renders an SES template with data and sends it, returning the SES message ID
+func SendEmail(from string, to string, template string, data map[string]string) (string, error) {
+	templateData, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("notification: unable to marshal template data: %s", err)
+	}
+
+	svc := ses.New(awsclient.Session())
+	result, err := svc.SendTemplatedEmail(&ses.SendTemplatedEmailInput{
+		Source:       aws.String(from),
+		Destination:  &ses.Destination{ToAddresses: []*string{aws.String(to)}},
+		Template:     aws.String(template),
+		TemplateData: aws.String(string(templateData)),
+	})
+	if err != nil {
+		return "", fmt.Errorf("notification: unable to send %s email: %s", template, err)
+	}
+
+	return aws.StringValue(result.MessageId), nil
+}
+
+// UnsubscribeToken returns the token authorizing an unsubscribe link for an account.  The link must work
+// without signing in, so the token is an HMAC of the account ID keyed by UNSUBSCRIBE_SECRET.
+func UnsubscribeToken(accountID string) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("UNSUBSCRIBE_SECRET")))
+	mac.Write([]byte(accountID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidUnsubscribeToken reports whether token was issued by UnsubscribeToken for accountID.  Without
+// UNSUBSCRIBE_SECRET every token is refused, since anyone could sign one with the empty key.
+func ValidUnsubscribeToken(accountID string, token string) bool {
+	if os.Getenv("UNSUBSCRIBE_SECRET") == "" {
+		return false
+	}
+	return hmac.Equal([]byte(UnsubscribeToken(accountID)), []byte(token))
+}
@@ -0,0 +1,28 @@
+package notification
+
+import (
+	"os"
+	"testing"
+)
+
+func TestUnsubscribeToken(t *testing.T) {
+	os.Setenv("UNSUBSCRIBE_SECRET", "secret")
+
+	token := UnsubscribeToken("account-1")
+	if !ValidUnsubscribeToken("account-1", token) {
+		t.Errorf("token issued for account-1 was rejected")
+	}
+
+	if ValidUnsubscribeToken("account-2", token) {
+		t.Errorf("token issued for account-1 was accepted for account-2")
+	}
+}
+
+func TestUnsubscribeTokenWithoutSecret(t *testing.T) {
+	os.Setenv("UNSUBSCRIBE_SECRET", "")
+	defer os.Setenv("UNSUBSCRIBE_SECRET", "secret")
+
+	if ValidUnsubscribeToken("account-1", UnsubscribeToken("account-1")) {
+		t.Errorf("token signed with the empty key was accepted")
+	}
+}
@@ -0,0 +1,53 @@
+package notification
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// PostSlack announces a message on a Slack channel through an incoming webhook
+func PostSlack(webhookURL string, text string) error {
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return fmt.Errorf("notification: unable to marshal slack message: %s", err)
+	}
+
+	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("notification: unable to post slack message: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("notification: slack webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// PostJSON posts a JSON body to a webhook, signing it with secret when one is given
+func PostJSON(url string, secret string, body []byte) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("notification: invalid webhook url %s: %s", url, err)
+	}
+	req.Header.Set("content-type", "application/json")
+	if secret != "" {
+		req.Header.Set(SignatureHeader, Sign(secret, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("notification: unable to post to webhook %s: %s", url, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("notification: webhook %s responded %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package notification
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/social-torch/open311-services/awsclient"
+	"github.com/social-torch/open311-services/repository"
+)
+
+// SendSMS sends a transactional text message through SNS, returning the SNS message ID
+func SendSMS(phoneNumber string, message string) (string, error) {
+	svc := sns.New(awsclient.Session())
+	result, err := svc.Publish(&sns.PublishInput{
+		PhoneNumber: aws.String(phoneNumber),
+		Message:     aws.String(message),
+		MessageAttributes: map[string]*sns.MessageAttributeValue{
+			"AWS.SNS.SMS.SMSType": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String("Transactional"),
+			},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("notification: unable to send SMS: %s", err)
+	}
+
+	return aws.StringValue(result.MessageId), nil
+}
+
+// InQuietHours reports whether t falls within the user's quiet hours, evaluated in their time zone.
+// Quiet hours may wrap midnight, eg 22 to 7.  Equal start and end hours mean no quiet hours.
+func InQuietHours(preferences repository.NotificationPreferences, t time.Time) bool {
+	start, end := preferences.QuietHoursStart, preferences.QuietHoursEnd
+	if start == end {
+		return false
+	}
+
+	if loc, err := time.LoadLocation(preferences.TimeZone); err == nil {
+		t = t.In(loc)
+	}
+
+	hour := t.Hour()
+	if start < end {
+		return hour >= start && hour < end
+	}
+	return hour >= start || hour < end
+}
+
+// QuietHoursEnd returns when the user's quiet hours around t end, or t itself when t isn't in them
+func QuietHoursEnd(preferences repository.NotificationPreferences, t time.Time) time.Time {
+	if !InQuietHours(preferences, t) {
+		return t
+	}
+
+	local := t
+	if loc, err := time.LoadLocation(preferences.TimeZone); err == nil {
+		local = t.In(loc)
+	}
+	end := time.Date(local.Year(), local.Month(), local.Day(), preferences.QuietHoursEnd, 0, 0, 0, local.Location())
+	if !end.After(local) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end
+}
+
+// ReserveSMS counts one text message against the city's daily quota and reports whether it may be sent.
+// Cities set sms_daily_quota on their Cities record; otherwise SMS_DAILY_QUOTA applies.  Zero means unlimited.
+func ReserveSMS(city repository.City, t time.Time) (bool, error) {
+	quota := city.SMSDailyQuota
+	if quota == 0 {
+		quota, _ = strconv.Atoi(os.Getenv("SMS_DAILY_QUOTA"))
+	}
+	if quota <= 0 {
+		return true, nil
+	}
+
+	count, err := repository.IncrementCounter("sms:"+city.CityName+":"+t.UTC().Format("2006-01-02"), 1)
+	if err != nil {
+		return false, err
+	}
+	return count <= int64(quota), nil
+}
@@ -0,0 +1,53 @@
+package notification
+
+import (
+	"testing"
+	"time"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestInQuietHours(t *testing.T) {
+	overnight := repository.NotificationPreferences{QuietHoursStart: 22, QuietHoursEnd: 7, TimeZone: "UTC"}
+	daytime := repository.NotificationPreferences{QuietHoursStart: 9, QuietHoursEnd: 17, TimeZone: "UTC"}
+
+	tests := []struct {
+		preferences repository.NotificationPreferences
+		hour        int
+		quiet       bool
+	}{
+		{overnight, 23, true},
+		{overnight, 3, true},
+		{overnight, 7, false},
+		{overnight, 12, false},
+		{daytime, 9, true},
+		{daytime, 17, false},
+		{repository.NotificationPreferences{}, 3, false},
+	}
+
+	for _, tt := range tests {
+		at := time.Date(2019, 6, 1, tt.hour, 30, 0, 0, time.UTC)
+		if got := InQuietHours(tt.preferences, at); got != tt.quiet {
+			t.Errorf("InQuietHours(%d-%d) at %d:30 = %v, want %v", tt.preferences.QuietHoursStart, tt.preferences.QuietHoursEnd, tt.hour, got, tt.quiet)
+		}
+	}
+}
+
+func TestQuietHoursEnd(t *testing.T) {
+	overnight := repository.NotificationPreferences{QuietHoursStart: 22, QuietHoursEnd: 7, TimeZone: "America/New_York"}
+	ny, _ := time.LoadLocation("America/New_York")
+
+	tests := []struct {
+		at   time.Time
+		want time.Time
+	}{
+		{time.Date(2019, 6, 1, 23, 30, 0, 0, ny), time.Date(2019, 6, 2, 7, 0, 0, 0, ny)},
+		{time.Date(2019, 6, 2, 3, 30, 0, 0, ny), time.Date(2019, 6, 2, 7, 0, 0, 0, ny)},
+		{time.Date(2019, 6, 2, 12, 0, 0, 0, ny), time.Date(2019, 6, 2, 12, 0, 0, 0, ny)},
+	}
+	for _, tt := range tests {
+		if got := QuietHoursEnd(overnight, tt.at); !got.Equal(tt.want) {
+			t.Errorf("QuietHoursEnd() at %s = %s, want %s", tt.at, got, tt.want)
+		}
+	}
+}
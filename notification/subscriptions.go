@@ -0,0 +1,24 @@
+package notification
+
+import (
+	"github.com/social-torch/open311-services/geo"
+	"github.com/social-torch/open311-services/repository"
+)
+
+// Matches reports whether a request falls under a subscription
+func Matches(subscription repository.Subscription, request repository.Request) bool {
+	switch subscription.Type {
+	case repository.RequestSubscription:
+		return subscription.ServiceRequestID == request.ServiceRequestID
+	case repository.ServiceSubscription:
+		return subscription.ServiceCode == request.ServiceCode
+	case repository.AreaSubscription:
+		if !request.HasLocation() {
+			return false
+		}
+		lat, lon := subscription.Coordinates()
+		requestLat, requestLon := request.Coordinates()
+		return geo.Distance(lat, lon, requestLat, requestLon) <= subscription.Radius
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package notification
+
+import (
+	"testing"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestMatches(t *testing.T) {
+	request := repository.Request{
+		ServiceRequestID: "1234",
+		ServiceCode:      "pothole",
+		Location:         repository.Location{Latitude: 42.7284, Longitude: -73.6918},
+	}
+
+	tests := []struct {
+		subscription repository.Subscription
+		match        bool
+	}{
+		{repository.Subscription{Type: repository.RequestSubscription, ServiceRequestID: "1234"}, true},
+		{repository.Subscription{Type: repository.RequestSubscription, ServiceRequestID: "5678"}, false},
+		{repository.Subscription{Type: repository.ServiceSubscription, ServiceCode: "pothole"}, true},
+		{repository.Subscription{Type: repository.ServiceSubscription, ServiceCode: "graffiti"}, false},
+		{repository.Subscription{Type: repository.AreaSubscription, Location: repository.Location{Latitude: 42.7290, Longitude: -73.6920}, Radius: 100}, true},
+		{repository.Subscription{Type: repository.AreaSubscription, Location: repository.Location{Latitude: 42.6526, Longitude: -73.7562}, Radius: 5000}, false},
+		{repository.Subscription{Type: "street"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := Matches(tt.subscription, request); got != tt.match {
+			t.Errorf("Matches(%+v) = %v, want %v", tt.subscription, got, tt.match)
+		}
+	}
+
+	// Requests located only by address must not be placed at 0,0
+	nearNullIsland := repository.Subscription{Type: repository.AreaSubscription, Location: repository.Location{Latitude: 0.0001}, Radius: 100}
+	if Matches(nearNullIsland, repository.Request{ServiceRequestID: "5678"}) {
+		t.Error("Matches() should not match requests without a location to area subscriptions")
+	}
+}
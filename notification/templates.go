@@ -0,0 +1,126 @@
+package notification
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/social-torch/open311-services/awsclient"
+	"github.com/social-torch/open311-services/repository"
+)
+
+// DefaultLanguage is used for users who have not chosen a language, and when a city has no copy in theirs
+const DefaultLanguage = "en"
+
+// placeholder matches the {{name}} placeholders of notification copy, as used by the platform SES templates
+var placeholder = regexp.MustCompile(`{{\s*(\w+)\s*}}`)
+
+// Message is notification copy rendered for one recipient
+type Message struct {
+	Subject string
+	Text    string
+	HTML    string
+	Short   string
+}
+
+// Render fills the placeholders of a template with data.  Values are escaped in the HTML body, and
+// placeholders without data are left empty.
+func Render(template repository.NotificationTemplate, data map[string]string) Message {
+	fill := func(copy string, escape bool) string {
+		return placeholder.ReplaceAllStringFunc(copy, func(p string) string {
+			value := data[placeholder.FindStringSubmatch(p)[1]]
+			if escape {
+				return html.EscapeString(value)
+			}
+			return value
+		})
+	}
+
+	return Message{
+		Subject: fill(template.Subject, false),
+		Text:    fill(template.Text, false),
+		HTML:    fill(template.HTML, true),
+		Short:   fill(template.Short, false),
+	}
+}
+
+// CityTemplate returns a city's copy of a notification in a language, falling back to DefaultLanguage.  ok is
+// false when the city has no copy of its own and the platform copy should be used.
+func CityTemplate(city repository.City, name string, language string) (template repository.NotificationTemplate, ok bool, err error) {
+	if city.CityName == "" {
+		return template, false, nil
+	}
+
+	languages := []string{DefaultLanguage}
+	if language != "" && language != DefaultLanguage {
+		languages = []string{language, DefaultLanguage}
+	}
+
+	for _, l := range languages {
+		template, err = repository.GetNotificationTemplate(city.CityName, name, l)
+		if err == nil {
+			return template, true, nil
+		}
+		if _, notFound := err.(*repository.NotificationTemplateNotFoundErr); !notFound {
+			return template, false, err
+		}
+	}
+	return template, false, nil
+}
+
+// SendCityEmail sends a notification email in the city's own copy and branding, or through the platform SES
+// template when the city has no copy of its own.  It returns the SES message ID.
+func SendCityEmail(city repository.City, language string, to string, name string, data map[string]string) (string, error) {
+	branded := map[string]string{
+		"city_name":   city.CityName,
+		"logo_url":    city.LogoURL,
+		"brand_color": city.BrandColor,
+	}
+	for k, v := range data {
+		branded[k] = v
+	}
+
+	template, ok, err := CityTemplate(city, name, language)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return SendEmail(Sender(city), to, name, branded)
+	}
+
+	message := Render(template, branded)
+	body := &ses.Body{Text: &ses.Content{Charset: aws.String("UTF-8"), Data: aws.String(message.Text)}}
+	if message.HTML != "" {
+		body.Html = &ses.Content{Charset: aws.String("UTF-8"), Data: aws.String(message.HTML)}
+	}
+
+	svc := ses.New(awsclient.Session())
+	result, err := svc.SendEmail(&ses.SendEmailInput{
+		Source:      aws.String(Sender(city)),
+		Destination: &ses.Destination{ToAddresses: []*string{aws.String(to)}},
+		Message: &ses.Message{
+			Subject: &ses.Content{Charset: aws.String("UTF-8"), Data: aws.String(message.Subject)},
+			Body:    body,
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("notification: unable to send %s email of %s: %s", name, city.CityName, err)
+	}
+
+	return aws.StringValue(result.MessageId), nil
+}
+
+// ShortMessage renders the city's push and text message copy of a notification, or returns fallback when the
+// city has none
+func ShortMessage(city repository.City, language string, name string, data map[string]string, fallback string) (string, error) {
+	template, ok, err := CityTemplate(city, name, language)
+	if err != nil {
+		return "", err
+	}
+	if !ok || template.Short == "" {
+		return fallback, nil
+	}
+	return Render(template, data).Short, nil
+}
@@ -0,0 +1,35 @@
+package notification
+
+import (
+	"testing"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestRender(t *testing.T) {
+	template := repository.NotificationTemplate{
+		Subject: "Su solicitud de {{service_name}} está {{ status }}",
+		Text:    "{{status_notes}}{{missing}}",
+		HTML:    "<p>{{status_notes}}</p>",
+		Short:   "{{service_name}}: {{status}}",
+	}
+	data := map[string]string{
+		"service_name": "Bache",
+		"status":       "closed",
+		"status_notes": "Fixed <today>",
+	}
+
+	message := Render(template, data)
+	if message.Subject != "Su solicitud de Bache está closed" {
+		t.Errorf("Subject = %q", message.Subject)
+	}
+	if message.Text != "Fixed <today>" {
+		t.Errorf("Text = %q", message.Text)
+	}
+	if message.HTML != "<p>Fixed &lt;today&gt;</p>" {
+		t.Errorf("HTML = %q", message.HTML)
+	}
+	if message.Short != "Bache: closed" {
+		t.Errorf("Short = %q", message.Short)
+	}
+}
@@ -0,0 +1,65 @@
+package notification
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+// SignatureHeader carries the HMAC-SHA256 signature of a webhook body, formatted "sha256=<hex>"
+const SignatureHeader = "X-Open311-Signature"
+
+// Sign returns the SignatureHeader value for a webhook body.  Receivers recompute it with their secret to
+// verify the payload came from us and was not altered.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret returns a random secret for signing webhook deliveries
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HookPayload returns a domain event as REST hooks deliver it: one flat object, which no-code platforms map onto
+// the fields of their actions without parsing nested JSON.  id identifies the event, so platforms polling for
+// samples can tell events apart.
+func HookPayload(e repository.RequestEvent) map[string]interface{} {
+	request := e.Request
+	payload := map[string]interface{}{
+		"id":                 request.ServiceRequestID + "/" + e.Type + "/" + e.Timestamp,
+		"event":              e.Type,
+		"timestamp":          e.Timestamp,
+		"service_request_id": request.ServiceRequestID,
+		"city_id":            request.CityID,
+		"status":             request.Status,
+		"previous_status":    e.PreviousStatus,
+		"status_notes":       request.StatusNotes,
+		"service_code":       request.ServiceCode,
+		"service_name":       request.ServiceName,
+		"description":        request.Description,
+		"agency_responsible": request.AgencyResponsible,
+		"address":            request.Address,
+		"zipcode":            request.ZipCode,
+		"media_url":          request.MediaURL,
+		"requested_datetime": request.RequestedDateTime,
+		"update_datetime":    request.UpdatedDateTime,
+		"expected_datetime":  request.ExpectedDateTime,
+		"closed_datetime":    request.ClosedDateTime,
+		"lat":                nil,
+		"lon":                nil,
+	}
+	if request.HasLocation() {
+		payload["lat"], payload["lon"] = request.Coordinates()
+	}
+	return payload
+}
@@ -0,0 +1,58 @@
+package notification
+
+import (
+	"testing"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestSign(t *testing.T) {
+	// Expected value computed with: printf '{"a":1}' | openssl dgst -sha256 -hmac secret
+	got := Sign("secret", []byte(`{"a":1}`))
+	want := "sha256=aa9e2e3575f5d7098b6caccd790888c36d5fdb63342a73bada2d6a51747a8494"
+	if got != want {
+		t.Errorf("Sign() = %s, want %s", got, want)
+	}
+
+	if Sign("secret", []byte("a")) == Sign("other", []byte("a")) {
+		t.Errorf("Sign() should depend on the secret")
+	}
+}
+
+func TestHookPayload(t *testing.T) {
+	e := repository.RequestEvent{
+		Type:             repository.StatusChangedEvent,
+		ServiceRequestID: "abc",
+		PreviousStatus:   "open",
+		Timestamp:        "2020-06-01T12:00:00Z",
+		Request: repository.Request{
+			ServiceRequestID: "abc",
+			CityID:           "troy",
+			Status:           "closed",
+			ServiceCode:      "pothole",
+			Location:         repository.Location{Latitude: 42.7284, Longitude: -73.6918},
+		},
+	}
+
+	payload := HookPayload(e)
+	want := map[string]interface{}{
+		"id":              "abc/StatusChanged/2020-06-01T12:00:00Z",
+		"event":           "StatusChanged",
+		"status":          "closed",
+		"previous_status": "open",
+		"service_code":    "pothole",
+		"city_id":         "troy",
+		"lat":             42.7284,
+		"lon":             -73.6918,
+	}
+	for key, value := range want {
+		if payload[key] != value {
+			t.Errorf("HookPayload()[%s] = %v, want %v", key, payload[key], value)
+		}
+	}
+
+	e.Request.Location = repository.Location{}
+	if payload := HookPayload(e); payload["lat"] != nil || payload["lon"] != nil {
+		t.Errorf("HookPayload() of a request without a location = %v, %v, want nil", payload["lat"], payload["lon"])
+	}
+}
@@ -0,0 +1,170 @@
+// Package portal publishes requests to the Socrata and CKAN datasets of cities' open-data portals.  Rows are
+// upserted by their service_request_id, so a request published again replaces its earlier row.
+package portal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+var client = &http.Client{Timeout: 60 * time.Second}
+
+// batchSize is the most rows sent in one call.  Both portals accept more, but large upserts are slow to be
+// acknowledged and time out
+const batchSize = 1000
+
+// maxResponseSize bounds how much of a portal's response is read
+const maxResponseSize = 1 << 20
+
+// Row is a request as published, keyed by the dataset's column names
+type Row map[string]interface{}
+
+// Client publishes to the dataset of one city's portal
+type Client struct {
+	settings repository.OpenDataPortal
+	apiKey   string
+}
+
+// New returns a client of a city's portal dataset, authenticating with the city's portal API key
+func New(city repository.City) *Client {
+	return &Client{settings: city.Config.OpenData.Portal, apiKey: city.PortalAPIKey}
+}
+
+// Upsert adds rows to the dataset, replacing rows of the same requests
+func (c *Client) Upsert(rows []Row) error {
+	for start := 0; start < len(rows); start += batchSize {
+		end := start + batchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		var err error
+		switch c.settings.Platform {
+		case repository.PortalSocrata:
+			err = c.upsertSocrata(rows[start:end])
+		case repository.PortalCKAN:
+			err = c.upsertCKAN(rows[start:end])
+		default:
+			err = fmt.Errorf("portal: unknown platform '%s'", c.settings.Platform)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upsertSocrata upserts rows with the SODA API.  The dataset's row identifier must be the column of
+// service_request_id.  The API key is a key ID and secret, sent as basic auth.
+func (c *Client) upsertSocrata(rows []Row) error {
+	body, err := json.Marshal(rows)
+	if err != nil {
+		return fmt.Errorf("portal: error marshalling rows: %s", err)
+	}
+
+	req, err := http.NewRequest("POST", c.base()+"/resource/"+c.settings.Dataset+".json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("portal: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	keyID, keySecret := splitKey(c.apiKey)
+	req.SetBasicAuth(keyID, keySecret)
+
+	respBody, err := c.do(req)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Errors int `json:"Errors"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return fmt.Errorf("portal: unreadable response of %s: %s", c.settings.URL, err)
+	}
+	if result.Errors > 0 {
+		return fmt.Errorf("portal: %s refused %d of %d rows of %s", c.settings.URL, result.Errors, len(rows), c.settings.Dataset)
+	}
+	return nil
+}
+
+// upsertCKAN upserts rows with the DataStore API.  The resource's primary key must be the column of
+// service_request_id.  The API key is an API token.
+func (c *Client) upsertCKAN(rows []Row) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"resource_id": c.settings.Dataset,
+		"records":     rows,
+		"method":      "upsert",
+		"force":       true, // DataStore-only resources are read-only unless forced
+	})
+	if err != nil {
+		return fmt.Errorf("portal: error marshalling rows: %s", err)
+	}
+
+	req, err := http.NewRequest("POST", c.base()+"/api/3/action/datastore_upsert", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("portal: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", c.apiKey)
+
+	respBody, err := c.do(req)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Success bool            `json:"success"`
+		Error   json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return fmt.Errorf("portal: unreadable response of %s: %s", c.settings.URL, err)
+	}
+	if !result.Success {
+		return fmt.Errorf("portal: %s refused rows of %s: %s", c.settings.URL, c.settings.Dataset, result.Error)
+	}
+	return nil
+}
+
+// do sends a call to the portal, returning the body of a successful response.  CKAN describes refusals in the
+// body of 4xx responses, so their bodies are returned too.
+func (c *Client) do(req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("portal: unable to reach %s: %s", c.settings.URL, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("portal: unable to read response of %s: %s", c.settings.URL, err)
+	}
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return body, nil
+	case c.settings.Platform == repository.PortalCKAN && resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusForbidden:
+		return body, nil
+	}
+	return nil, fmt.Errorf("portal: %s responded %s: %s", c.settings.URL, resp.Status, bytes.TrimSpace(body))
+}
+
+// base returns the portal's URL without a trailing slash
+func (c *Client) base() string {
+	return strings.TrimSuffix(c.settings.URL, "/")
+}
+
+// splitKey splits a Socrata API key into its ID and secret
+func splitKey(apiKey string) (string, string) {
+	parts := strings.SplitN(apiKey, ":", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
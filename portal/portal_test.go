@@ -0,0 +1,94 @@
+package portal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func portalCity(platform string, url string, apiKey string) repository.City {
+	city := repository.City{CityName: "troy", PortalAPIKey: apiKey}
+	city.Config.OpenData.Portal = repository.OpenDataPortal{Platform: platform, URL: url + "/", Dataset: "abcd-1234"}
+	return city
+}
+
+func TestUpsertSocrata(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path != "/resource/abcd-1234.json" {
+			t.Errorf("path = %s, want /resource/abcd-1234.json", r.URL.Path)
+		}
+		if id, secret, ok := r.BasicAuth(); !ok || id != "key" || secret != "secret" {
+			t.Errorf("basic auth = %s, %s, want key, secret", id, secret)
+		}
+		var rows []Row
+		json.NewDecoder(r.Body).Decode(&rows)
+		if rows[0]["id"] == "bad" {
+			w.Write([]byte(`{"Rows Created": 0, "Errors": 1}`))
+			return
+		}
+		w.Write([]byte(`{"Rows Created": 1, "Errors": 0}`))
+	}))
+	defer server.Close()
+
+	c := New(portalCity(repository.PortalSocrata, server.URL, "key:secret"))
+	rows := make([]Row, batchSize+1)
+	for i := range rows {
+		rows[i] = Row{"id": "r"}
+	}
+	if err := c.Upsert(rows); err != nil {
+		t.Errorf("Upsert() = %s, want nil", err)
+	}
+	if calls != 2 {
+		t.Errorf("Upsert() of %d rows made %d calls, want 2", len(rows), calls)
+	}
+
+	if err := c.Upsert([]Row{{"id": "bad"}}); err == nil {
+		t.Error("Upsert() of refused rows should set an error")
+	}
+}
+
+func TestUpsertCKAN(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/3/action/datastore_upsert" || r.Header.Get("Authorization") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(string(body), `"bad"`) {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"success": false, "error": {"message": "primary key missing"}}`))
+			return
+		}
+		var call struct {
+			ResourceID string `json:"resource_id"`
+			Method     string `json:"method"`
+			Records    []Row  `json:"records"`
+		}
+		json.Unmarshal(body, &call)
+		if call.ResourceID != "abcd-1234" || call.Method != "upsert" || len(call.Records) != 1 {
+			t.Errorf("datastore_upsert call = %s", body)
+		}
+		w.Write([]byte(`{"success": true}`))
+	}))
+	defer server.Close()
+
+	if err := New(portalCity(repository.PortalCKAN, server.URL, "token")).Upsert([]Row{{"id": "r1"}}); err != nil {
+		t.Errorf("Upsert() = %s, want nil", err)
+	}
+
+	err := New(portalCity(repository.PortalCKAN, server.URL, "token")).Upsert([]Row{{"id": "bad"}})
+	if err == nil || !strings.Contains(err.Error(), "primary key missing") {
+		t.Errorf("Upsert() of refused rows = %v, want CKAN's error", err)
+	}
+
+	if err := New(portalCity(repository.PortalCKAN, server.URL, "wrong")).Upsert([]Row{{"id": "r1"}}); err == nil {
+		t.Error("Upsert() with a wrong token should set an error")
+	}
+}
@@ -0,0 +1,218 @@
+package repository
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// AgenciesTable holds the notification channels of the agencies services are assigned to
+const AgenciesTable = "Agencies"
+
+// Agency is a city department, or a division of one, responsible for resolving requests, eg "Public Works" or its
+// "Streets Division".  Services name the agency handling them by its ID in their group, unless the city's routing
+// rules send them elsewhere, and SubmitRequest records it in Request.AgencyResponsible.
+type Agency struct {
+	ID                string   `json:"agency_id"`
+	CityID            string   `json:"city_id,omitempty"` // City the agency belongs to. Omitted rather than empty, which the city_id-index rejects
+	ParentID          string   `json:"parent_id"`         // Department the agency is part of, eg "public-works" for "streets". Empty for top level departments
+	Name              string   `json:"name"`
+	Emails            []string `json:"emails"`             // Addresses emailed about each new request
+	WebhookURL        string   `json:"webhook_url"`        // Endpoint new requests are posted to as JSON
+	WebhookSecret     string   `json:"webhook_secret"`     // Key webhook bodies are signed with. Empty to leave them unsigned
+	SlackWebhookURL   string   `json:"slack_webhook_url"`  // Slack incoming webhook new and updated requests are posted to as cards
+	TeamsWebhookURL   string   `json:"teams_webhook_url"`  // Microsoft Teams incoming webhook new and updated requests are posted to as cards
+	SupervisorEmails  []string `json:"supervisor_emails"`  // Addresses requests breaching their SLA are escalated to
+	NotifySubagencies bool     `json:"notify_subagencies"` // Also told about new requests assigned to the agencies under it
+}
+
+// RoutingRule assigns the requests for some of a city's services to one of its agencies, in place of the agency
+// named by the services' group.  A rule applies to a single service_code or to every service of a group.
+type RoutingRule struct {
+	ServiceCode string `json:"service_code,omitempty"`
+	Group       string `json:"group,omitempty"`
+	AgencyID    string `json:"agency_id"`
+}
+
+type AgencyOfAnotherCityErr struct {
+	message string
+}
+
+func (e *AgencyOfAnotherCityErr) Error() string {
+	return e.message
+}
+
+type AgencyNotFoundErr struct {
+	message string
+}
+
+func (e *AgencyNotFoundErr) Error() string {
+	return e.message
+}
+
+// GetAgency returns a single agency.  If the ID is not in the database, an AgencyNotFoundErr error is set
+func GetAgency(id string) (Agency, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return Agency{}, err
+	}
+
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(AgenciesTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"agency_id": {
+				S: aws.String(id),
+			},
+		},
+	}
+
+	result, err := svc.GetItem(input)
+	if err != nil {
+		return Agency{}, fmt.Errorf("repository: unable to get specified agency from database. \n %s", err)
+	}
+
+	agency := Agency{}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &agency)
+	if err != nil {
+		return agency, fmt.Errorf("repository: Failed to unmarshal agency record from database. \n %s", err)
+	}
+
+	if agency.ID == "" {
+		return agency, &AgencyNotFoundErr{"agency not found"}
+	}
+
+	return agency, nil
+}
+
+// maxAgencyDepth bounds how far up a hierarchy is followed, so a misconfigured cycle can't loop forever
+const maxAgencyDepth = 8
+
+// GetAgencyChain returns an agency followed by the departments above it, nearest first.  Parents missing from the
+// database end the chain.  If the agency itself is not in the database, an AgencyNotFoundErr error is set
+func GetAgencyChain(id string) ([]Agency, error) {
+	agency, err := GetAgency(id)
+	if err != nil {
+		return nil, err
+	}
+
+	chain := []Agency{agency}
+	for agency.ParentID != "" && len(chain) < maxAgencyDepth {
+		agency, err = GetAgency(agency.ParentID)
+		if _, ok := err.(*AgencyNotFoundErr); ok {
+			break
+		}
+		if err != nil {
+			return chain, err
+		}
+		chain = append(chain, agency)
+	}
+	return chain, nil
+}
+
+// GetCityAgencies returns the agencies of a city
+func GetCityAgencies(cityID string) ([]Agency, error) {
+	agencies := []Agency{}
+	err := queryCity(AgenciesTable, cityID, &agencies)
+	return agencies, err
+}
+
+// PutAgency adds or replaces an agency of a city.  Agencies stored before cities were partitioned, which have no
+// city, are taken over by the first city to put them.  If the ID belongs to another city's agency, an
+// AgencyOfAnotherCityErr error is set
+func PutAgency(agency Agency) error {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return err
+	}
+
+	av, err := dynamodbattribute.MarshalMap(agency)
+	if err != nil {
+		return fmt.Errorf("repository: Failed to marshal agency:\n %+v. \n  %s", agency, err)
+	}
+
+	_, err = svc.PutItem(&dynamodb.PutItemInput{
+		Item:                av,
+		TableName:           aws.String(AgenciesTable),
+		ConditionExpression: aws.String("attribute_not_exists(agency_id) OR attribute_not_exists(city_id) OR city_id = :c"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":c": {
+				S: aws.String(agency.CityID),
+			},
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return &AgencyOfAnotherCityErr{"agency belongs to another city"}
+		}
+		return fmt.Errorf("repository: failed to put agency %s in database. \n %s", agency.ID, err)
+	}
+
+	return nil
+}
+
+// SetCityRouting replaces the routing rules of a city.  If the city is not in the database, a CityNotFoundErr
+// error is set
+func SetCityRouting(cityName string, rules []RoutingRule) error {
+	svc, err := createDirectoryClient()
+	if err != nil {
+		return err
+	}
+
+	av, err := dynamodbattribute.Marshal(rules)
+	if err != nil {
+		return fmt.Errorf("repository: Failed to marshal routing of %s. \n  %s", cityName, err)
+	}
+
+	_, err = svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(CitiesTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"city_name": {
+				S: aws.String(cityName),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(city_name)"),
+		UpdateExpression:    aws.String("SET routing = :r"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":r": av,
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return &CityNotFoundErr{"city not found"}
+		}
+		return fmt.Errorf("repository: failed to set routing of %s. \n  %s", cityName, err)
+	}
+
+	return nil
+}
+
+// Route returns the agency responsible for requests for a service: the agency of the rule for its service_code,
+// else of the first rule for its group, else the agency its group names
+func Route(rules []RoutingRule, service Service) string {
+	byGroup := ""
+	for _, rule := range rules {
+		if rule.ServiceCode != "" && rule.ServiceCode == service.ServiceCode {
+			return rule.AgencyID
+		}
+		if byGroup == "" && rule.ServiceCode == "" && rule.Group != "" && rule.Group == service.Group {
+			byGroup = rule.AgencyID
+		}
+	}
+	if byGroup != "" {
+		return byGroup
+	}
+	return service.Group
+}
+
+// AgencyPath returns the IDs of an agency chain from the top level department down, eg
+// ["public-works", "streets"]
+func AgencyPath(chain []Agency) []string {
+	path := make([]string, len(chain))
+	for i, agency := range chain {
+		path[len(chain)-1-i] = agency.ID
+	}
+	return path
+}
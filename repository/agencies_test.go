@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRoute(t *testing.T) {
+	rules := []RoutingRule{
+		{Group: "public-works", AgencyID: "streets"},
+		{Group: "public-works", AgencyID: "water"},
+		{ServiceCode: "troy-hydrant", AgencyID: "fire"},
+	}
+
+	tests := []struct {
+		service Service
+		want    string
+	}{
+		{Service{ServiceCode: "troy-pothole", Group: "public-works"}, "streets"},
+		{Service{ServiceCode: "troy-hydrant", Group: "public-works"}, "fire"},
+		{Service{ServiceCode: "troy-graffiti", Group: "parks"}, "parks"},
+		{Service{ServiceCode: "troy-other"}, ""},
+	}
+	for _, tt := range tests {
+		if got := Route(rules, tt.service); got != tt.want {
+			t.Errorf("Route(%s) = %q, want %q", tt.service.ServiceCode, got, tt.want)
+		}
+	}
+
+	if got := Route(nil, Service{Group: "parks"}); got != "parks" {
+		t.Errorf("Route() without rules = %q, want the group", got)
+	}
+}
+
+func TestAgencyPath(t *testing.T) {
+	chain := []Agency{{ID: "potholes"}, {ID: "streets"}, {ID: "public-works"}}
+	if got, want := AgencyPath(chain), []string{"public-works", "streets", "potholes"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("AgencyPath() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Kinds of volume anomaly
+const (
+	AnomalySpike   = "spike"   // Far more requests than usual, eg an incident or a client stuck resubmitting
+	AnomalyDrought = "drought" // Far fewer, eg a broken app or an outage of the API
+)
+
+// A spike needs at least anomalySpikeMinCount requests, anomalySpikeScore standard deviations above the baseline.  A
+// drought needs anomalyDroughtScore standard deviations below a baseline of at least anomalyDroughtMinExpected, so
+// that quiet services never alert.  Both are stricter than trends, since they page operators.
+const (
+	anomalySpikeMinCount      = 10
+	anomalySpikeScore         = 5.0
+	anomalyDroughtMinExpected = 9.0
+	anomalyDroughtScore       = -3.0
+)
+
+// Anomaly is a service of a city, or the city as a whole, receiving far more or far fewer requests than usual
+type Anomaly struct {
+	ServiceCode string  `json:"service_code,omitempty"` // Empty for all of the city's requests
+	Kind        string  `json:"kind"`                   // AnomalySpike or AnomalyDrought
+	Count       int64   `json:"count"`                  // Requests made during the window
+	Expected    float64 `json:"expected"`               // Requests expected during a window of the same length, from the baseline days
+	Score       float64 `json:"score"`                  // Standard deviations of Count from Expected
+}
+
+// Anomalies compares the requests made during a window with the daily stats of the baseline days before it,
+// returning the services, and the city as a whole, whose volume is abnormal, most abnormal first.  Cities without
+// requests in the baseline have nothing to compare with, and have none.
+func Anomalies(recent []Request, baseline []DailyStats, window time.Duration) []Anomaly {
+	base := map[string]int64{}
+	var total int64
+	for _, day := range baseline {
+		total += day.Opened
+		for code, n := range day.Services {
+			base[code] += n
+		}
+	}
+	anomalies := []Anomaly{}
+	if total == 0 {
+		return anomalies
+	}
+	base[""] = total
+
+	counts := map[string]int64{"": int64(len(recent))}
+	for _, request := range recent {
+		counts[request.ServiceCode]++
+	}
+	for code := range base {
+		if _, ok := counts[code]; !ok {
+			counts[code] = 0
+		}
+	}
+
+	scale := window.Hours() / (float64(len(baseline)) * 24)
+	for code, n := range counts {
+		expected := float64(base[code]) * scale
+		score := poissonScore(n, expected)
+
+		anomaly := Anomaly{ServiceCode: code, Count: n, Expected: math.Round(expected*100) / 100, Score: math.Round(score*100) / 100}
+		switch {
+		case n >= anomalySpikeMinCount && score >= anomalySpikeScore:
+			anomaly.Kind = AnomalySpike
+		case expected >= anomalyDroughtMinExpected && score <= anomalyDroughtScore:
+			anomaly.Kind = AnomalyDrought
+		default:
+			continue
+		}
+		anomalies = append(anomalies, anomaly)
+	}
+
+	sort.Slice(anomalies, func(i, j int) bool {
+		a, b := anomalies[i], anomalies[j]
+		if math.Abs(a.Score) != math.Abs(b.Score) {
+			return math.Abs(a.Score) > math.Abs(b.Score)
+		}
+		return a.ServiceCode < b.ServiceCode
+	})
+	return anomalies
+}
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAnomalies(t *testing.T) {
+	// 28 days of 40 potholes and 20 streetlights out a day
+	baseline := []DailyStats{}
+	for i := 0; i < TrendingBaselineDays; i++ {
+		baseline = append(baseline, DailyStats{Opened: 60, Services: map[string]int64{"pothole": 40, "streetlight": 20}})
+	}
+
+	recent := []Request{}
+	for i := 0; i < 100; i++ {
+		recent = append(recent, Request{ServiceCode: "pothole"})
+	}
+	recent = append(recent, Request{ServiceCode: "graffiti"}, Request{ServiceCode: "graffiti"})
+
+	anomalies := Anomalies(recent, baseline, 24*time.Hour)
+	want := []Anomaly{
+		{ServiceCode: "pothole", Kind: AnomalySpike, Count: 100, Expected: 40, Score: 9.49},
+		{ServiceCode: "", Kind: AnomalySpike, Count: 102, Expected: 60, Score: 5.42},
+		{ServiceCode: "streetlight", Kind: AnomalyDrought, Count: 0, Expected: 20, Score: -4.47},
+	}
+	if len(anomalies) != len(want) {
+		t.Fatalf("Anomalies() = %+v, want %+v", anomalies, want)
+	}
+	for i := range want {
+		if anomalies[i] != want[i] {
+			t.Errorf("Anomalies()[%d] = %+v, want %+v", i, anomalies[i], want[i])
+		}
+	}
+
+	// An hour without streetlights is ordinary
+	if anomalies := Anomalies(nil, baseline, time.Hour); len(anomalies) != 0 {
+		t.Errorf("Anomalies() of a quiet hour = %+v, want none", anomalies)
+	}
+
+	if anomalies := Anomalies(recent, nil, 24*time.Hour); anomalies == nil || len(anomalies) != 0 {
+		t.Errorf("Anomalies() without a baseline = %#v, want empty", anomalies)
+	}
+}
@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/social-torch/open311-services/geo"
+)
+
+// AssetsTable holds each city's registry of street furniture requests can be attached to, keyed by city_name
+// and asset_id.  Like RequestsTable, it has a geo_cell-index for location queries.
+const AssetsTable = "Assets"
+
+// Asset is a piece of city infrastructure, eg a streetlight, hydrant or signal cabinet
+type Asset struct {
+	City    string `json:"city_name"`
+	ID      string `json:"asset_id"` // The city's own ID for the asset, eg the number painted on a pole
+	Type    string `json:"type"`     // Kind of asset, eg "streetlight"
+	Label   string `json:"label"`    // How crews refer to the asset, eg "Streetlight #4471"
+	Address string `json:"address"`
+	Location
+	Geohash string `json:"geohash,omitempty"`
+	GeoCell string `json:"geo_cell,omitempty"`
+}
+
+type AssetNotFoundErr struct {
+	message string
+}
+
+func (e *AssetNotFoundErr) Error() string {
+	return e.message
+}
+
+// PutAsset creates or replaces an asset in its city's registry
+func PutAsset(asset Asset) error {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return err
+	}
+
+	lat, lon := asset.Coordinates()
+	asset.Geohash = geo.Encode(lat, lon, GeohashPrecision)
+	asset.GeoCell = asset.Geohash[:GeoCellPrecision]
+
+	av, err := dynamodbattribute.MarshalMap(asset)
+	if err != nil {
+		return fmt.Errorf("repository: Failed to marshal asset:\n %+v. \n  %s", asset, err)
+	}
+
+	_, err = svc.PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(AssetsTable),
+	})
+	if err != nil {
+		return fmt.Errorf("repository: failed to put asset in database. \n %s", err)
+	}
+
+	return nil
+}
+
+// GetAsset returns an asset of a city.  If the city has no such asset, an AssetNotFoundErr error is set
+func GetAsset(cityName string, id string) (Asset, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return Asset{}, err
+	}
+
+	result, err := svc.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(AssetsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"city_name": {
+				S: aws.String(cityName),
+			},
+			"asset_id": {
+				S: aws.String(id),
+			},
+		},
+	})
+	if err != nil {
+		return Asset{}, fmt.Errorf("repository: unable to get asset %s of %s. \n %s", id, cityName, err)
+	}
+
+	asset := Asset{}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &asset)
+	if err != nil {
+		return asset, fmt.Errorf("repository: Failed to unmarshal asset record from database: \n  %+v. \n   %s", result.Item, err)
+	}
+
+	if asset.ID == "" {
+		return asset, &AssetNotFoundErr{"asset not found"}
+	}
+
+	return asset, nil
+}
+
+// GetNearbyAssets returns the assets within radius meters of a point, nearest first.  When assetType is not
+// empty only assets of that type are returned.
+func GetNearbyAssets(lat float64, lon float64, radius float64, assetType string) ([]Asset, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return nil, err
+	}
+
+	assets := []Asset{}
+	for _, cell := range geo.Cover(geo.CircleBox(lat, lon, radius), GeoCellPrecision) {
+		input := &dynamodb.QueryInput{
+			TableName:              aws.String(AssetsTable),
+			IndexName:              aws.String(GeoCellIndex),
+			KeyConditionExpression: aws.String("geo_cell = :c"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":c": {
+					S: aws.String(cell),
+				},
+			},
+		}
+
+		err = svc.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+			items := []Asset{}
+			err = dynamodbattribute.UnmarshalListOfMaps(page.Items, &items)
+			if err != nil {
+				return false
+			}
+			assets = append(assets, items...)
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("repository: unable to get assets in geo cell %s. \n %s", cell, err)
+		}
+	}
+
+	nearby := []Asset{}
+	distances := map[string]float64{}
+	for _, asset := range assets {
+		if assetType != "" && asset.Type != assetType {
+			continue
+		}
+		assetLat, assetLon := asset.Coordinates()
+		d := geo.Distance(lat, lon, assetLat, assetLon)
+		if d <= radius {
+			nearby = append(nearby, asset)
+			distances[asset.City+"#"+asset.ID] = d
+		}
+	}
+
+	sort.Slice(nearby, func(i, j int) bool {
+		return distances[nearby[i].City+"#"+nearby[i].ID] < distances[nearby[j].City+"#"+nearby[j].ID]
+	})
+	return nearby, nil
+}
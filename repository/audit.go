@@ -0,0 +1,461 @@
+package repository
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/oklog/ulid"
+	"github.com/social-torch/open311-services/metrics"
+)
+
+// AuditTable is the append-only log of the writes made to the platform's tables, keyed by city_id and audit_id
+const AuditTable = "AuditLog"
+
+// AuditPlatform is the city_id the writes to items of no city in particular are logged under
+const AuditPlatform = "platform"
+
+// redacted replaces the values of secret attributes in audit entries, which show only that they changed
+const redacted = "[redacted]"
+
+// unaudited are the tables whose writes aren't logged: the audit log itself, counters, pins, which copy the audited
+// requests, and the logs and connections that record what the platform did rather than changes anyone made
+var unaudited = map[string]bool{
+	AuditTable: true, CountersTable: true, ConnectionsTable: true, NotificationDeliveriesTable: true, WebhookDeliveriesTable: true,
+	PinsTable: true,
+}
+
+// tableKeys are the key attributes of the audited tables, which name the item a put writes
+var tableKeys = map[string][]string{
+	RequestsTable:              {"service_request_id"},
+	ServicesTable:              {"service_code"},
+	CitiesTable:                {"city_name"},
+	UsersTable:                 {"account_id"},
+	FeedbackTable:              {"id"},
+	OnboardingTable:            {"id"},
+	MediaTable:                 {"media_key"},
+	SubscriptionsTable:         {"subscription_id"},
+	AgenciesTable:              {"agency_id"},
+	AssetsTable:                {"city_name", "asset_id"},
+	NotificationTemplatesTable: {"city_name", "template_key"},
+	WebhooksTable:              {"webhook_id"},
+}
+
+// AuditRecord records one write: who made it, through which call, and what it changed
+type AuditRecord struct {
+	CityID    string        `json:"city_id"`
+	AuditID   string        `json:"audit_id"` // ULID, so entries sort by time
+	Timestamp string        `json:"timestamp"`
+	Actor     string        `json:"actor"`               // cognito:username of the caller, "anonymous", or "system:<function>" for writes outside the API
+	SourceIP  string        `json:"source_ip,omitempty"` // IP address the API call came from
+	Route     string        `json:"route,omitempty"`     // API route of the call, eg "PUT /request/{id}"
+	Table     string        `json:"table"`
+	Operation string        `json:"operation"` // PutItem, UpdateItem or DeleteItem
+	Item      string        `json:"item"`      // Key of the item written, eg "service_request_id=SR-01ABC"
+	Changes   []AuditChange `json:"changes"`
+	Partial   bool          `json:"partial,omitempty"` // The item before the write is unknown, so every attribute written is listed
+}
+
+// AuditChange is an attribute a write changed.  Before is omitted for attributes it added, and After for those it
+// removed.
+type AuditChange struct {
+	Attribute string      `json:"attribute"`
+	Before    interface{} `json:"before,omitempty"`
+	After     interface{} `json:"after,omitempty"`
+}
+
+// AuditSource is who made the writes being logged, and through which API call
+type AuditSource struct {
+	Actor    string
+	SourceIP string
+	Route    string
+}
+
+// The source of the writes a Lambda is making.  A Lambda serves one call at a time, so it is set per call.
+var (
+	auditMu     sync.Mutex
+	auditSource AuditSource
+)
+
+// Audit wraps the router of an API handler so the writes made while answering a call are logged with the caller,
+// their IP address and the route
+func Audit(h metrics.APIHandler) metrics.APIHandler {
+	return func(req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		setAuditSource(apiSource(req))
+		defer setAuditSource(AuditSource{})
+		return h(req)
+	}
+}
+
+// AuditActor names the caller of an API call made on behalf of someone not signed in to the platform, eg
+// "slack:U024BE7LH" for a Slack user pressing a button.  It only lasts until the call is answered.
+func AuditActor(actor string) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	auditSource.Actor = actor
+}
+
+func setAuditSource(source AuditSource) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	auditSource = source
+}
+
+// apiSource returns the source of the writes made answering an API call.  Callers are named by their Cognito user
+// name, and calls without a token are anonymous.
+func apiSource(req events.APIGatewayProxyRequest) AuditSource {
+	actor := "anonymous"
+	if claims, ok := req.RequestContext.Authorizer["claims"].(map[string]interface{}); ok {
+		if name, _ := claims["cognito:username"].(string); name != "" {
+			actor = name
+		}
+	}
+	return AuditSource{Actor: actor, SourceIP: req.RequestContext.Identity.SourceIP, Route: req.HTTPMethod + " " + req.Resource}
+}
+
+// currentSource returns the source of the writes being made.  Writes outside an API call are made by the system,
+// named by its Lambda function, or by the command run.
+func currentSource() AuditSource {
+	auditMu.Lock()
+	source := auditSource
+	auditMu.Unlock()
+
+	if source.Actor == "" {
+		name := os.Getenv("AWS_LAMBDA_FUNCTION_NAME")
+		if name == "" {
+			name = filepath.Base(os.Args[0])
+		}
+		source.Actor = "system:" + name
+	}
+	return source
+}
+
+// audited reports whether the writes to a table are logged
+func audited(table *string) bool {
+	return !unaudited[aws.StringValue(table)]
+}
+
+// keepOldImage asks DynamoDB for the item a write replaces, so its audit entry can show what changed.  Writes that
+// ask for their own return values keep them.
+func keepOldImage(r *request.Request) {
+	oldImage := func(table *string, returnValues **string) {
+		if audited(table) && (*returnValues == nil || aws.StringValue(*returnValues) == dynamodb.ReturnValueNone) {
+			*returnValues = aws.String(dynamodb.ReturnValueAllOld)
+		}
+	}
+
+	switch input := r.Params.(type) {
+	case *dynamodb.PutItemInput:
+		oldImage(input.TableName, &input.ReturnValues)
+	case *dynamodb.UpdateItemInput:
+		oldImage(input.TableName, &input.ReturnValues)
+	case *dynamodb.DeleteItemInput:
+		oldImage(input.TableName, &input.ReturnValues)
+	}
+}
+
+// auditWrites returns the interceptor logging the successful writes made through a session to its AuditTable.  A
+// write that can't be logged has already been made, so the failure is counted as AuditErrors rather than returned.
+func auditWrites(sess *session.Session) func(r *request.Request) {
+	return func(r *request.Request) {
+		if r.Error != nil {
+			return
+		}
+
+		entry, ok, err := auditEntry(dynamodb.New(sess), r)
+		if err == nil && ok {
+			err = putAuditEntry(dynamodb.New(sess), entry)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING\tUnable to audit %s: %s\n", r.Operation.Name, err)
+			metrics.Count("AuditErrors", metrics.Dimension{Name: "Operation", Value: r.Operation.Name})
+		}
+	}
+}
+
+// auditEntry describes a write, and false when it isn't audited
+func auditEntry(svc *dynamodb.DynamoDB, r *request.Request) (AuditRecord, bool, error) {
+	var table string
+	var key, before, after map[string]*dynamodb.AttributeValue
+	partial := false
+
+	switch input := r.Params.(type) {
+	case *dynamodb.PutItemInput:
+		table = aws.StringValue(input.TableName)
+		key = keyOf(table, input.Item)
+		before = r.Data.(*dynamodb.PutItemOutput).Attributes
+		after = input.Item
+
+	case *dynamodb.UpdateItemInput:
+		table = aws.StringValue(input.TableName)
+		key = input.Key
+		attributes := r.Data.(*dynamodb.UpdateItemOutput).Attributes
+		if aws.StringValue(input.ReturnValues) != dynamodb.ReturnValueAllOld {
+			// The caller asked for the item after the write instead
+			after, partial = attributes, true
+			break
+		}
+
+		before = attributes
+		result, err := svc.GetItem(&dynamodb.GetItemInput{TableName: input.TableName, Key: input.Key, ConsistentRead: aws.Bool(true)})
+		if err != nil {
+			return AuditRecord{}, false, fmt.Errorf("repository: unable to read %s item after update. \n %s", table, err)
+		}
+		after = result.Item
+
+	case *dynamodb.DeleteItemInput:
+		table = aws.StringValue(input.TableName)
+		key = input.Key
+		before = r.Data.(*dynamodb.DeleteItemOutput).Attributes
+
+	default:
+		return AuditRecord{}, false, nil
+	}
+	if !audited(&table) {
+		return AuditRecord{}, false, nil
+	}
+
+	changes, err := auditChanges(before, after)
+	if err != nil {
+		return AuditRecord{}, false, err
+	}
+	if len(changes) == 0 {
+		// Nothing changed, eg a retried write
+		return AuditRecord{}, false, nil
+	}
+
+	id, err := genID()
+	if err != nil {
+		return AuditRecord{}, false, fmt.Errorf("repository: failed to generate audit id. \n  %s", err)
+	}
+	source := currentSource()
+	return AuditRecord{
+		CityID:    auditCity(table, before, after),
+		AuditID:   id,
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Actor:     source.Actor,
+		SourceIP:  source.SourceIP,
+		Route:     source.Route,
+		Table:     table,
+		Operation: r.Operation.Name,
+		Item:      itemName(key),
+		Changes:   changes,
+		Partial:   partial,
+	}, true, nil
+}
+
+// putAuditEntry appends an entry to the AuditTable.  Entries are never overwritten.
+func putAuditEntry(svc *dynamodb.DynamoDB, entry AuditRecord) error {
+	av, err := dynamodbattribute.MarshalMap(entry)
+	if err != nil {
+		return fmt.Errorf("repository: Failed to marshal audit entry:\n %+v. \n  %s", entry, err)
+	}
+
+	_, err = svc.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(AuditTable),
+		Item:                av,
+		ConditionExpression: aws.String("attribute_not_exists(audit_id)"),
+	})
+	if err != nil {
+		return fmt.Errorf("repository: failed to put audit entry of %s %s. \n  %s", entry.Table, entry.Item, err)
+	}
+	return nil
+}
+
+// keyOf returns the key attributes of an item of a table
+func keyOf(table string, item map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	key := map[string]*dynamodb.AttributeValue{}
+	for _, name := range tableKeys[table] {
+		if v, ok := item[name]; ok {
+			key[name] = v
+		}
+	}
+	return key
+}
+
+// itemName writes a key as name=value pairs, eg "city_name=troy,asset_id=light-4471"
+func itemName(key map[string]*dynamodb.AttributeValue) string {
+	names := make([]string, 0, len(key))
+	for name := range key {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		var value interface{}
+		dynamodbattribute.Unmarshal(key[name], &value)
+		pairs[i] = fmt.Sprintf("%s=%v", name, value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+// auditCity returns the city an item written belongs to: the city of a Cities record, else the item's city_id or
+// city_name, else AuditPlatform
+func auditCity(table string, before, after map[string]*dynamodb.AttributeValue) string {
+	names := []string{"city_id", "city_name"}
+	if table == CitiesTable {
+		names = []string{"city_name"}
+	}
+	for _, item := range []map[string]*dynamodb.AttributeValue{after, before} {
+		for _, name := range names {
+			if v, ok := item[name]; ok && aws.StringValue(v.S) != "" {
+				return aws.StringValue(v.S)
+			}
+		}
+	}
+	return AuditPlatform
+}
+
+// auditChanges returns the attributes that differ between an item before and after a write, by name.  The values
+// of secret attributes are redacted.
+func auditChanges(before, after map[string]*dynamodb.AttributeValue) ([]AuditChange, error) {
+	var old, updated map[string]interface{}
+	err := dynamodbattribute.UnmarshalMap(before, &old)
+	if err == nil {
+		err = dynamodbattribute.UnmarshalMap(after, &updated)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("repository: Failed to unmarshal audited item. \n  %s", err)
+	}
+
+	names := map[string]bool{}
+	for name := range old {
+		names[name] = true
+	}
+	for name := range updated {
+		names[name] = true
+	}
+
+	changes := []AuditChange{}
+	for name := range names {
+		b, hadBefore := old[name]
+		a, hasAfter := updated[name]
+		if hadBefore && hasAfter && reflect.DeepEqual(b, a) {
+			continue
+		}
+
+		change := AuditChange{Attribute: name, Before: b, After: a}
+		if secret(name) {
+			if hadBefore {
+				change.Before = redacted
+			}
+			if hasAfter {
+				change.After = redacted
+			}
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Attribute < changes[j].Attribute })
+	return changes, nil
+}
+
+// secret reports whether an attribute holds a credential, whose value is kept out of the audit log
+func secret(name string) bool {
+	for _, word := range []string{"secret", "api_key", "token", "password"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAuditLog returns the audit entries of a city from start to end, newest first, and at most limit of them.  A
+// table or actor, when given, limits the entries to the writes to it or by them.  It also returns whether more
+// entries were in the range.
+func GetAuditLog(cityID string, start time.Time, end time.Time, table string, actor string, limit int) ([]AuditRecord, bool, error) {
+	svc, err := createCityClient(cityID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	from, err := auditBound(start)
+	if err != nil {
+		return nil, false, fmt.Errorf("repository: unable to bound audit log of %s. \n %s", cityID, err)
+	}
+	to, err := auditBound(end.Add(time.Millisecond))
+	if err != nil {
+		return nil, false, fmt.Errorf("repository: unable to bound audit log of %s. \n %s", cityID, err)
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(AuditTable),
+		KeyConditionExpression: aws.String("city_id = :c AND audit_id BETWEEN :s AND :e"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":c": {S: aws.String(cityID)},
+			":s": {S: aws.String(from)},
+			":e": {S: aws.String(to)},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}
+	filterAudit(input, table, actor)
+	return queryAudit(svc, input, cityID, limit)
+}
+
+// filterAudit limits a query of the AuditTable to the writes to a table and by an actor, when given
+func filterAudit(input *dynamodb.QueryInput, table string, actor string) {
+	filters := []string{}
+	names := map[string]*string{}
+	if table != "" {
+		filters = append(filters, "#t = :t")
+		names["#t"] = aws.String("table")
+		input.ExpressionAttributeValues[":t"] = &dynamodb.AttributeValue{S: aws.String(table)}
+	}
+	if actor != "" {
+		filters = append(filters, "actor = :a")
+		input.ExpressionAttributeValues[":a"] = &dynamodb.AttributeValue{S: aws.String(actor)}
+	}
+	if len(filters) > 0 {
+		input.FilterExpression = aws.String(strings.Join(filters, " AND "))
+	}
+	if len(names) > 0 {
+		input.ExpressionAttributeNames = names
+	}
+}
+
+// queryAudit reads up to limit entries of a query of the AuditTable
+func queryAudit(svc *dynamodb.DynamoDB, input *dynamodb.QueryInput, cityID string, limit int) ([]AuditRecord, bool, error) {
+	all := []map[string]*dynamodb.AttributeValue{}
+	truncated := false
+	err := svc.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			if len(all) == limit {
+				truncated = true
+				return false
+			}
+			all = append(all, item)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("repository: unable to get audit log of %s. \n %s", cityID, err)
+	}
+
+	entries := []AuditRecord{}
+	err = dynamodbattribute.UnmarshalListOfMaps(all, &entries)
+	if err != nil {
+		return nil, false, fmt.Errorf("repository: Failed to unmarshal audit log of %s. \n %s", cityID, err)
+	}
+	return entries, truncated, nil
+}
+
+// auditBound returns the lowest audit_id of a time, to range over the ULIDs of the AuditTable
+func auditBound(t time.Time) (string, error) {
+	id, err := ulid.New(ulid.Timestamp(t), bytes.NewReader(make([]byte, 10)))
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"os"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestAuditChanges(t *testing.T) {
+	before := map[string]*dynamodb.AttributeValue{
+		"service_request_id": {S: aws.String("SR-01ABC")},
+		"status":             {S: aws.String("open")},
+		"status_notes":       {S: aws.String("reported")},
+		"webhook_secret":     {S: aws.String("old")},
+	}
+	after := map[string]*dynamodb.AttributeValue{
+		"service_request_id": {S: aws.String("SR-01ABC")},
+		"status":             {S: aws.String("closed")},
+		"assigned_to":        {S: aws.String("crew-1")},
+		"webhook_secret":     {S: aws.String("new")},
+	}
+
+	changes, err := auditChanges(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AuditChange{
+		{Attribute: "assigned_to", After: "crew-1"},
+		{Attribute: "status", Before: "open", After: "closed"},
+		{Attribute: "status_notes", Before: "reported"},
+		{Attribute: "webhook_secret", Before: redacted, After: redacted},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("auditChanges() = %+v, want %+v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("auditChanges()[%d] = %+v, want %+v", i, changes[i], want[i])
+		}
+	}
+
+	if changes, _ := auditChanges(after, after); len(changes) != 0 {
+		t.Errorf("auditChanges() of an unchanged item = %+v, want none", changes)
+	}
+}
+
+func TestAuditItem(t *testing.T) {
+	item := map[string]*dynamodb.AttributeValue{
+		"city_name": {S: aws.String("troy")},
+		"asset_id":  {S: aws.String("light-4471")},
+		"label":     {S: aws.String("Streetlight #4471")},
+	}
+	if name := itemName(keyOf(AssetsTable, item)); name != "asset_id=light-4471,city_name=troy" {
+		t.Errorf("itemName() = %q, want asset_id=light-4471,city_name=troy", name)
+	}
+
+	if city := auditCity(AssetsTable, nil, item); city != "troy" {
+		t.Errorf("auditCity() of an asset = %q, want troy", city)
+	}
+	request := map[string]*dynamodb.AttributeValue{"city_id": {S: aws.String("albany")}}
+	if city := auditCity(RequestsTable, request, nil); city != "albany" {
+		t.Errorf("auditCity() of a deleted request = %q, want albany", city)
+	}
+	if city := auditCity(FeedbackTable, nil, map[string]*dynamodb.AttributeValue{}); city != AuditPlatform {
+		t.Errorf("auditCity() of feedback = %q, want %s", city, AuditPlatform)
+	}
+}
+
+func TestAuditSource(t *testing.T) {
+	req := events.APIGatewayProxyRequest{HTTPMethod: "PUT", Resource: "/request/{id}"}
+	req.RequestContext.Identity.SourceIP = "203.0.113.7"
+	req.RequestContext.Authorizer = map[string]interface{}{"claims": map[string]interface{}{"cognito:username": "clerk"}}
+
+	var during AuditSource
+	Audit(func(events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		during = currentSource()
+		return events.APIGatewayProxyResponse{}, nil
+	})(req)
+	if during != (AuditSource{Actor: "clerk", SourceIP: "203.0.113.7", Route: "PUT /request/{id}"}) {
+		t.Errorf("source during call = %+v", during)
+	}
+
+	if source := apiSource(events.APIGatewayProxyRequest{HTTPMethod: "POST", Resource: "/requests"}); source.Actor != "anonymous" {
+		t.Errorf("source of a call without a token = %+v, want anonymous", source)
+	}
+
+	os.Setenv("AWS_LAMBDA_FUNCTION_NAME", "open311-Escalation")
+	defer os.Unsetenv("AWS_LAMBDA_FUNCTION_NAME")
+	if after := currentSource(); after != (AuditSource{Actor: "system:open311-Escalation"}) {
+		t.Errorf("source outside a call = %+v, want the function", after)
+	}
+}
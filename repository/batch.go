@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Bulk writes, such as imports and the services of a new city, go to DynamoDB in batches rather than an item at a
+// time.  Items DynamoDB leaves unprocessed, when the table is throttled, are sent again after a backoff.
+const (
+	BatchWriteSize   = 25  // Most items a BatchWriteItem call puts
+	batchGetSize     = 100 // Most keys a BatchGetItem call reads
+	maxBatchAttempts = 8   // Calls made for a batch before giving up on its unprocessed items
+)
+
+// batchBackoff is the wait before a batch's unprocessed items are first sent again, doubling with each attempt
+var batchBackoff = 50 * time.Millisecond
+
+type batchWriteFunc func(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error)
+
+type batchGetFunc func(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error)
+
+// batchWrite puts items in a table BatchWriteSize at a time, sending the items each batch leaves unprocessed again
+// until they are written.  It returns how many items were written, which on error are those of the batches before.
+func batchWrite(write batchWriteFunc, table string, items []map[string]*dynamodb.AttributeValue) (int, error) {
+	written := 0
+	for start := 0; start < len(items); start += BatchWriteSize {
+		end := start + BatchWriteSize
+		if end > len(items) {
+			end = len(items)
+		}
+
+		puts := []*dynamodb.WriteRequest{}
+		for _, item := range items[start:end] {
+			puts = append(puts, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}})
+		}
+		pending := map[string][]*dynamodb.WriteRequest{table: puts}
+
+		for attempt := 0; len(pending[table]) > 0; attempt++ {
+			if attempt == maxBatchAttempts {
+				return written, fmt.Errorf("repository: %d items left unprocessed writing to %s after %d attempts", len(pending[table]), table, attempt)
+			}
+			if attempt > 0 {
+				time.Sleep(batchBackoff << uint(attempt-1))
+			}
+
+			result, err := write(&dynamodb.BatchWriteItemInput{RequestItems: pending})
+			if err != nil {
+				return written, fmt.Errorf("repository: failed to write a batch of %d items to %s. \n %s", len(pending[table]), table, err)
+			}
+			pending = result.UnprocessedItems
+		}
+		written += end - start
+	}
+	return written, nil
+}
+
+// storedKeys returns which of the values of a table's string partition key are stored, reading batchGetSize at a
+// time and sending the keys each batch leaves unprocessed again
+func storedKeys(get batchGetFunc, table string, key string, values []string) (map[string]bool, error) {
+	items, err := batchGet(get, table, key, values, []string{key}, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := map[string]bool{}
+	for _, item := range items {
+		stored[aws.StringValue(item[key].S)] = true
+	}
+	return stored, nil
+}
+
+// batchGet reads the items of a table whose string partition key has one of values, batchGetSize at a time with up
+// to workers batches read at once, sending the keys each batch leaves unprocessed again.  Only the named attributes
+// are read when there are any.  Values must not repeat, as BatchGetItem refuses a batch naming an item twice.  Items
+// are returned in no particular order, and values not stored are left out.
+func batchGet(get batchGetFunc, table string, key string, values []string, attributes []string, workers int) ([]map[string]*dynamodb.AttributeValue, error) {
+	batches := make(chan []string)
+	go func() {
+		defer close(batches)
+		for start := 0; start < len(values); start += batchGetSize {
+			end := start + batchGetSize
+			if end > len(values) {
+				end = len(values)
+			}
+			batches <- values[start:end]
+		}
+	}()
+
+	var mu sync.Mutex
+	var firstErr error
+	items := []map[string]*dynamodb.AttributeValue{}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				read, err := getBatch(get, table, key, batch, attributes)
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				items = append(items, read...)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return items, nil
+}
+
+// getBatch reads a single batch of batchGet, until none of its keys are left unprocessed
+func getBatch(get batchGetFunc, table string, key string, values []string, attributes []string) ([]map[string]*dynamodb.AttributeValue, error) {
+	keys := []map[string]*dynamodb.AttributeValue{}
+	for _, value := range values {
+		keys = append(keys, map[string]*dynamodb.AttributeValue{key: {S: aws.String(value)}})
+	}
+	request := &dynamodb.KeysAndAttributes{Keys: keys}
+	if len(attributes) > 0 {
+		request.ExpressionAttributeNames = map[string]*string{}
+		request.ProjectionExpression = aws.String(projection(attributes, request.ExpressionAttributeNames))
+	}
+	pending := map[string]*dynamodb.KeysAndAttributes{table: request}
+
+	items := []map[string]*dynamodb.AttributeValue{}
+	for attempt := 0; pending[table] != nil && len(pending[table].Keys) > 0; attempt++ {
+		if attempt == maxBatchAttempts {
+			return nil, fmt.Errorf("repository: %d keys left unprocessed reading %s after %d attempts", len(pending[table].Keys), table, attempt)
+		}
+		if attempt > 0 {
+			time.Sleep(batchBackoff << uint(attempt-1))
+		}
+
+		result, err := get(&dynamodb.BatchGetItemInput{RequestItems: pending})
+		if err != nil {
+			return nil, fmt.Errorf("repository: failed to read a batch of %d keys of %s. \n %s", len(pending[table].Keys), table, err)
+		}
+		items = append(items, result.Responses[table]...)
+		pending = result.UnprocessedKeys
+	}
+	return items, nil
+}
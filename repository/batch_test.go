@@ -0,0 +1,171 @@
+package repository
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func items(n int) []map[string]*dynamodb.AttributeValue {
+	all := []map[string]*dynamodb.AttributeValue{}
+	for i := 0; i < n; i++ {
+		all = append(all, map[string]*dynamodb.AttributeValue{"service_code": {S: aws.String(strconv.Itoa(i))}})
+	}
+	return all
+}
+
+func TestBatchWrite(t *testing.T) {
+	defer func(backoff time.Duration) { batchBackoff = backoff }(batchBackoff)
+	batchBackoff = 0
+
+	// A throttled table leaves the last item of every call unprocessed, never writing it
+	written, err := batchWrite(func(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+		requests := input.RequestItems[ServicesTable]
+		if len(requests) > BatchWriteSize {
+			t.Fatalf("batchWrite() sent %d items at once, want at most %d", len(requests), BatchWriteSize)
+		}
+		return &dynamodb.BatchWriteItemOutput{
+			UnprocessedItems: map[string][]*dynamodb.WriteRequest{ServicesTable: requests[len(requests)-1:]},
+		}, nil
+	}, ServicesTable, items(60))
+	if err == nil || written != 0 {
+		t.Errorf("batchWrite() of items never processed = %d, %v, want an error", written, err)
+	}
+
+	// Another leaves one item of every other call unprocessed
+	calls := 0
+	put := map[string]bool{}
+	unprocessed := 1
+	written, err = batchWrite(func(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+		calls++
+		requests := input.RequestItems[ServicesTable]
+		left := requests[len(requests)-unprocessed:]
+		for _, request := range requests[:len(requests)-unprocessed] {
+			put[aws.StringValue(request.PutRequest.Item["service_code"].S)] = true
+		}
+		unprocessed = 1 - unprocessed
+		return &dynamodb.BatchWriteItemOutput{UnprocessedItems: map[string][]*dynamodb.WriteRequest{ServicesTable: left}}, nil
+	}, ServicesTable, items(60))
+	if err != nil || written != 60 || len(put) != 60 {
+		t.Errorf("batchWrite() = %d, %v with %d items put, want all 60", written, err, len(put))
+	}
+	if calls != 6 {
+		t.Errorf("batchWrite() made %d calls, want 3 batches each sent again once", calls)
+	}
+
+	written, err = batchWrite(func(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+		return nil, errors.New("ValidationException")
+	}, ServicesTable, items(3))
+	if err == nil || written != 0 {
+		t.Errorf("batchWrite() of a failed call = %d, %v, want an error", written, err)
+	}
+}
+
+func TestStoredKeys(t *testing.T) {
+	defer func(backoff time.Duration) { batchBackoff = backoff }(batchBackoff)
+	batchBackoff = 0
+
+	calls := 0
+	get := func(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+		calls++
+		keys := input.RequestItems[ServicesTable].Keys
+		if len(keys) > batchGetSize {
+			t.Fatalf("storedKeys() read %d keys at once, want at most %d", len(keys), batchGetSize)
+		}
+		// Even codes are stored, and the first key of a batch is left unprocessed once
+		result := &dynamodb.BatchGetItemOutput{Responses: map[string][]map[string]*dynamodb.AttributeValue{}}
+		if calls%2 == 1 {
+			result.UnprocessedKeys = map[string]*dynamodb.KeysAndAttributes{ServicesTable: {Keys: keys[:1]}}
+			keys = keys[1:]
+		}
+		for _, key := range keys {
+			if n, _ := strconv.Atoi(aws.StringValue(key["service_code"].S)); n%2 == 0 {
+				result.Responses[ServicesTable] = append(result.Responses[ServicesTable], key)
+			}
+		}
+		return result, nil
+	}
+
+	codes := []string{}
+	for i := 0; i < 150; i++ {
+		codes = append(codes, strconv.Itoa(i))
+	}
+	stored, err := storedKeys(get, ServicesTable, "service_code", codes)
+	if err != nil || len(stored) != 75 || !stored["0"] || stored["1"] {
+		t.Errorf("storedKeys() = %d keys, %v, want the 75 even codes", len(stored), err)
+	}
+	if calls != 4 {
+		t.Errorf("storedKeys() made %d calls, want 2 batches each sent again once", calls)
+	}
+}
+
+func TestBatchGet(t *testing.T) {
+	defer func(backoff time.Duration) { batchBackoff = backoff }(batchBackoff)
+	batchBackoff = 0
+
+	// Batches are read at once, each leaving its last key unprocessed once
+	var mu sync.Mutex
+	reading, most := 0, 0
+	retried := map[string]bool{}
+	get := func(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+		mu.Lock()
+		reading++
+		if reading > most {
+			most = reading
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+
+		request := input.RequestItems[RequestsTable]
+		if aws.StringValue(request.ProjectionExpression) != "" {
+			t.Errorf("batchGet() of whole items projected %s", aws.StringValue(request.ProjectionExpression))
+		}
+		keys := request.Keys
+		result := &dynamodb.BatchGetItemOutput{Responses: map[string][]map[string]*dynamodb.AttributeValue{}}
+
+		mu.Lock()
+		last := aws.StringValue(keys[len(keys)-1]["service_request_id"].S)
+		if !retried[last] {
+			retried[last] = true
+			result.UnprocessedKeys = map[string]*dynamodb.KeysAndAttributes{RequestsTable: {Keys: keys[len(keys)-1:]}}
+			keys = keys[:len(keys)-1]
+		}
+		reading--
+		mu.Unlock()
+
+		for _, key := range keys {
+			result.Responses[RequestsTable] = append(result.Responses[RequestsTable], map[string]*dynamodb.AttributeValue{
+				"service_request_id": key["service_request_id"],
+				"status":             {S: aws.String(RequestOpen)},
+			})
+		}
+		return result, nil
+	}
+
+	ids := []string{}
+	for i := 0; i < 350; i++ {
+		ids = append(ids, "SR-"+strconv.Itoa(i))
+	}
+	read, err := batchGet(get, RequestsTable, "service_request_id", ids, nil, 2)
+	if err != nil || len(read) != 350 {
+		t.Fatalf("batchGet() = %d items, %v, want 350", len(read), err)
+	}
+	if most > 2 {
+		t.Errorf("batchGet() read %d batches at once, want at most 2", most)
+	}
+	if len(retried) != 4 {
+		t.Errorf("batchGet() sent %d batches, want 4", len(retried))
+	}
+
+	_, err = batchGet(func(input *dynamodb.BatchGetItemInput) (*dynamodb.BatchGetItemOutput, error) {
+		return nil, errors.New("ValidationException")
+	}, RequestsTable, "service_request_id", ids, nil, 2)
+	if err == nil {
+		t.Errorf("batchGet() of failed calls succeeded")
+	}
+}
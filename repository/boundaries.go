@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/social-torch/open311-services/geo"
+)
+
+// SetCityBoundary replaces the city limits of a city.  If the city is not in the database, a CityNotFoundErr
+// error is set
+func SetCityBoundary(cityName string, boundary geo.MultiPolygon) error {
+	svc, err := createDirectoryClient()
+	if err != nil {
+		return err
+	}
+
+	av, err := dynamodbattribute.Marshal(boundary)
+	if err != nil {
+		return fmt.Errorf("repository: Failed to marshal boundary of %s. \n  %s", cityName, err)
+	}
+
+	_, err = svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(CitiesTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"city_name": {
+				S: aws.String(cityName),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(city_name)"),
+		UpdateExpression:    aws.String("SET boundary = :b"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":b": av,
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return &CityNotFoundErr{"city not found"}
+		}
+		return fmt.Errorf("repository: failed to set boundary of %s. \n  %s", cityName, err)
+	}
+
+	return nil
+}
+
+// FindCity returns the city that serves a point: the city whose limits contain it, else the city with the
+// smallest bounding box containing it.  If no city contains it, a CityNotFoundErr error is set
+func FindCity(lat, lon float64) (City, error) {
+	cities, err := allCities()
+	if err != nil {
+		return City{}, err
+	}
+
+	city, ok := locateCity(cities, lat, lon)
+	if !ok {
+		return City{}, &CityNotFoundErr{"no city serves this location"}
+	}
+	return city, nil
+}
+
+// Box returns the bounding box of a city, and false when it has none or it is malformed
+func (c City) Box() (geo.Box, bool) {
+	if len(c.BoundingBox) != 4 {
+		return geo.Box{}, false
+	}
+	box := geo.Box{MinLon: c.BoundingBox[0], MinLat: c.BoundingBox[1], MaxLon: c.BoundingBox[2], MaxLat: c.BoundingBox[3]}
+	return box, box.MinLat <= box.MaxLat && box.MinLon <= box.MaxLon
+}
+
+// locateCity finds the city serving a point among cities.  Boundaries are exact, so they are preferred; bounding
+// boxes of neighbouring cities overlap, so the smallest box containing the point is taken to be the closest fit.
+func locateCity(cities []City, lat, lon float64) (City, bool) {
+	var located City
+	smallest := math.Inf(1)
+	for _, city := range cities {
+		if city.Boundary.Contains(lat, lon) {
+			return city, true
+		}
+		box, ok := city.Box()
+		if !ok || !box.Contains(lat, lon) {
+			continue
+		}
+		if area := (box.MaxLat - box.MinLat) * (box.MaxLon - box.MinLon); area < smallest {
+			located, smallest = city, area
+		}
+	}
+	return located, !math.IsInf(smallest, 1)
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/social-torch/open311-services/geo"
+)
+
+func TestLocateCity(t *testing.T) {
+	square := geo.Polygon{{{-73.70, 42.70}, {-73.65, 42.70}, {-73.65, 42.75}, {-73.70, 42.75}, {-73.70, 42.70}}}
+	cities := []City{
+		{CityName: "County", BoundingBox: []float64{-74.0, 42.5, -73.5, 43.0}},
+		{CityName: "Cohoes", BoundingBox: []float64{-73.72, 42.76, -73.68, 42.79}},
+		{CityName: "Troy", Boundary: geo.MultiPolygon{square}},
+		{CityName: "Backwards", BoundingBox: []float64{-73.5, 43.0, -74.0, 42.5}},
+	}
+
+	tests := []struct {
+		name     string
+		lat, lon float64
+		want     string
+	}{
+		{"inside boundary and boxes", 42.72, -73.68, "Troy"},
+		{"inside nested boxes", 42.77, -73.70, "Cohoes"},
+		{"inside one box", 42.9, -73.9, "County"},
+		{"outside every city", 40.71, -74.0, ""},
+	}
+	for _, tt := range tests {
+		city, ok := locateCity(cities, tt.lat, tt.lon)
+		if city.CityName != tt.want || ok != (tt.want != "") {
+			t.Errorf("%s: locateCity() = %s, %t, want %s", tt.name, city.CityName, ok, tt.want)
+		}
+	}
+}
+
+func TestCityBox(t *testing.T) {
+	box, ok := City{BoundingBox: []float64{-73.72, 42.76, -73.68, 42.79}}.Box()
+	if !ok || box.MinLon != -73.72 || box.MinLat != 42.76 || box.MaxLon != -73.68 || box.MaxLat != 42.79 {
+		t.Errorf("Box() = %+v, %t", box, ok)
+	}
+	if _, ok := (City{}).Box(); ok {
+		t.Error("Box() of city without bbox should not be ok")
+	}
+	if _, ok := (City{BoundingBox: []float64{1, 2, 3}}).Box(); ok {
+		t.Error("Box() of short bbox should not be ok")
+	}
+}
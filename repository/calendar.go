@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BusinessCalendar is when a city works on requests.  SLAs of a city with a calendar are counted in business hours,
+// so an 8 hour SLA on a request made at 4pm on a Friday falls due at 3pm the next Monday for a city working 8 to 5
+// on weekdays.
+type BusinessCalendar struct {
+	Hours    map[string]BusinessHours `json:"hours"`    // Working hours by lower case weekday, eg "monday". Days left out are not worked. Empty to work around the clock
+	Holidays []string                 `json:"holidays"` // YYYY-MM-DD dates that are not worked
+}
+
+// BusinessHours are the working hours of a day, as HH:MM in the city's time zone
+type BusinessHours struct {
+	Open  string `json:"open"`  // eg "08:00"
+	Close string `json:"close"` // eg "17:00". "24:00" works until midnight
+}
+
+// maxCalendarDays bounds the days searched for business hours, so a calendar that is never open can't loop forever
+const maxCalendarDays = 3660
+
+// Validate checks the hours and holidays of a calendar
+func (c BusinessCalendar) Validate() error {
+	for day, hours := range c.Hours {
+		if _, ok := weekday(day); !ok {
+			return fmt.Errorf("calendar day '%s' must be a lower case weekday, eg monday", day)
+		}
+		opens, errOpen := clock(hours.Open)
+		closes, errClose := clock(hours.Close)
+		if errOpen != nil || errClose != nil {
+			return fmt.Errorf("calendar hours of %s must be HH:MM", day)
+		}
+		if opens >= closes {
+			return fmt.Errorf("calendar hours of %s must open before they close", day)
+		}
+	}
+	for _, holiday := range c.Holidays {
+		if _, err := time.Parse("2006-01-02", holiday); err != nil {
+			return fmt.Errorf("holiday '%s' must be a YYYY-MM-DD date", holiday)
+		}
+	}
+	return nil
+}
+
+// Add returns the time d of business hours after start, counted in loc.  Without hours or holidays it is simply
+// start plus d.
+func (c BusinessCalendar) Add(start time.Time, d time.Duration, loc *time.Location) time.Time {
+	if len(c.Hours) == 0 && len(c.Holidays) == 0 {
+		return start.Add(d)
+	}
+
+	t := start.In(loc)
+	remaining := d
+	for i := 0; i < maxCalendarDays && remaining > 0; i++ {
+		midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		if opens, closes, ok := c.window(midnight); ok {
+			if t.Before(opens) {
+				t = opens
+			}
+			if t.Before(closes) {
+				available := closes.Sub(t)
+				if remaining <= available {
+					return t.Add(remaining)
+				}
+				remaining -= available
+			}
+		}
+		t = midnight.AddDate(0, 0, 1)
+	}
+	return t.Add(remaining)
+}
+
+// window returns the working hours of the day starting at midnight, and false when the day isn't worked
+func (c BusinessCalendar) window(midnight time.Time) (time.Time, time.Time, bool) {
+	date := midnight.Format("2006-01-02")
+	for _, holiday := range c.Holidays {
+		if holiday == date {
+			return time.Time{}, time.Time{}, false
+		}
+	}
+
+	if len(c.Hours) == 0 {
+		return midnight, midnight.AddDate(0, 0, 1), true
+	}
+	hours, ok := c.Hours[strings.ToLower(midnight.Weekday().String())]
+	if !ok {
+		return time.Time{}, time.Time{}, false
+	}
+
+	opens, errOpen := clock(hours.Open)
+	closes, errClose := clock(hours.Close)
+	if errOpen != nil || errClose != nil || opens >= closes {
+		return time.Time{}, time.Time{}, false
+	}
+	at := func(minutes int) time.Time {
+		return time.Date(midnight.Year(), midnight.Month(), midnight.Day(), minutes/60, minutes%60, 0, 0, midnight.Location())
+	}
+	return at(opens), at(closes), true
+}
+
+// clock parses HH:MM into minutes after midnight, allowing 24:00
+func clock(hhmm string) (int, error) {
+	var h, m int
+	_, err := fmt.Sscanf(hhmm, "%d:%d", &h, &m)
+	if err != nil || len(hhmm) != 5 || h < 0 || m < 0 || m > 59 || h > 24 || h == 24 && m != 0 {
+		return 0, fmt.Errorf("'%s' is not HH:MM", hhmm)
+	}
+	return h*60 + m, nil
+}
+
+// weekday returns the weekday of a lower case name, eg "monday"
+func weekday(name string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.ToLower(d.String()) == name {
+			return d, true
+		}
+	}
+	return 0, false
+}
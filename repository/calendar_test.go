@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBusinessCalendarAdd(t *testing.T) {
+	newYork, _ := time.LoadLocation("America/New_York")
+	weekdays := BusinessHours{Open: "08:00", Close: "17:00"}
+	calendar := BusinessCalendar{
+		Hours: map[string]BusinessHours{
+			"monday": weekdays, "tuesday": weekdays, "wednesday": weekdays, "thursday": weekdays, "friday": weekdays,
+		},
+		Holidays: []string{"2019-07-04"},
+	}
+
+	tests := []struct {
+		name  string
+		start time.Time
+		hours int
+		want  time.Time
+	}{
+		{"within the day", time.Date(2019, 6, 3, 9, 0, 0, 0, newYork), 4, time.Date(2019, 6, 3, 13, 0, 0, 0, newYork)},
+		{"friday afternoon", time.Date(2019, 6, 7, 16, 0, 0, 0, newYork), 8, time.Date(2019, 6, 10, 15, 0, 0, 0, newYork)},
+		{"3 business days from friday", time.Date(2019, 6, 7, 15, 0, 0, 0, newYork), 27, time.Date(2019, 6, 12, 15, 0, 0, 0, newYork)},
+		{"before opening", time.Date(2019, 6, 3, 6, 0, 0, 0, newYork), 1, time.Date(2019, 6, 3, 9, 0, 0, 0, newYork)},
+		{"on the weekend", time.Date(2019, 6, 8, 12, 0, 0, 0, newYork), 1, time.Date(2019, 6, 10, 9, 0, 0, 0, newYork)},
+		{"over a holiday", time.Date(2019, 7, 3, 16, 0, 0, 0, newYork), 2, time.Date(2019, 7, 5, 9, 0, 0, 0, newYork)},
+		{"ending at closing", time.Date(2019, 6, 3, 16, 0, 0, 0, newYork), 1, time.Date(2019, 6, 3, 17, 0, 0, 0, newYork)},
+	}
+	for _, tt := range tests {
+		got := calendar.Add(tt.start, time.Duration(tt.hours)*time.Hour, newYork)
+		if !got.Equal(tt.want) {
+			t.Errorf("%s: Add() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	start := time.Date(2019, 6, 8, 12, 0, 0, 0, time.UTC)
+	if got := (BusinessCalendar{}).Add(start, 8*time.Hour, newYork); !got.Equal(start.Add(8 * time.Hour)) {
+		t.Errorf("Add() without a calendar = %s, want around the clock", got)
+	}
+	holidays := BusinessCalendar{Holidays: []string{"2019-06-09"}}
+	if got, want := holidays.Add(start, 24*time.Hour, time.UTC), time.Date(2019, 6, 10, 12, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Add() with only holidays = %s, want %s", got, want)
+	}
+}
+
+func TestBusinessCalendarValidate(t *testing.T) {
+	valid := BusinessCalendar{
+		Hours:    map[string]BusinessHours{"monday": {Open: "08:00", Close: "17:00"}, "saturday": {Open: "00:00", Close: "24:00"}},
+		Holidays: []string{"2019-07-04"},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate() = %s, want nil", err)
+	}
+
+	invalid := []BusinessCalendar{
+		{Hours: map[string]BusinessHours{"Monday": {Open: "08:00", Close: "17:00"}}},
+		{Hours: map[string]BusinessHours{"monday": {Open: "8am", Close: "17:00"}}},
+		{Hours: map[string]BusinessHours{"monday": {Open: "17:00", Close: "08:00"}}},
+		{Hours: map[string]BusinessHours{"monday": {Open: "08:00", Close: "24:30"}}},
+		{Holidays: []string{"July 4"}},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Validate(%+v) should set an error", c)
+		}
+	}
+}
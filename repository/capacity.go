@@ -0,0 +1,159 @@
+package repository
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/social-torch/open311-services/metrics"
+)
+
+// throttleCodes are the error codes of DynamoDB calls refused for exceeding a table's capacity or the account's
+// request rate
+var throttleCodes = map[string]bool{
+	dynamodb.ErrCodeProvisionedThroughputExceededException: true,
+	dynamodb.ErrCodeRequestLimitExceeded:                   true,
+	"ThrottlingException":                                  true,
+}
+
+// askCapacity asks DynamoDB for the capacity every call consumes, so it can be counted.  Calls that ask for it
+// themselves keep their own setting.
+func askCapacity(r *request.Request) {
+	total := func(returnConsumedCapacity **string) {
+		if *returnConsumedCapacity == nil {
+			*returnConsumedCapacity = aws.String(dynamodb.ReturnConsumedCapacityTotal)
+		}
+	}
+
+	switch input := r.Params.(type) {
+	case *dynamodb.GetItemInput:
+		total(&input.ReturnConsumedCapacity)
+	case *dynamodb.PutItemInput:
+		total(&input.ReturnConsumedCapacity)
+	case *dynamodb.UpdateItemInput:
+		total(&input.ReturnConsumedCapacity)
+	case *dynamodb.DeleteItemInput:
+		total(&input.ReturnConsumedCapacity)
+	case *dynamodb.QueryInput:
+		total(&input.ReturnConsumedCapacity)
+	case *dynamodb.ScanInput:
+		total(&input.ReturnConsumedCapacity)
+	case *dynamodb.BatchGetItemInput:
+		total(&input.ReturnConsumedCapacity)
+	case *dynamodb.BatchWriteItemInput:
+		total(&input.ReturnConsumedCapacity)
+	case *dynamodb.TransactGetItemsInput:
+		total(&input.ReturnConsumedCapacity)
+	case *dynamodb.TransactWriteItemsInput:
+		total(&input.ReturnConsumedCapacity)
+	}
+}
+
+// consumed returns the capacity a successful call reports consuming, one entry per table
+func consumed(output interface{}) []*dynamodb.ConsumedCapacity {
+	switch output := output.(type) {
+	case *dynamodb.GetItemOutput:
+		return []*dynamodb.ConsumedCapacity{output.ConsumedCapacity}
+	case *dynamodb.PutItemOutput:
+		return []*dynamodb.ConsumedCapacity{output.ConsumedCapacity}
+	case *dynamodb.UpdateItemOutput:
+		return []*dynamodb.ConsumedCapacity{output.ConsumedCapacity}
+	case *dynamodb.DeleteItemOutput:
+		return []*dynamodb.ConsumedCapacity{output.ConsumedCapacity}
+	case *dynamodb.QueryOutput:
+		return []*dynamodb.ConsumedCapacity{output.ConsumedCapacity}
+	case *dynamodb.ScanOutput:
+		return []*dynamodb.ConsumedCapacity{output.ConsumedCapacity}
+	case *dynamodb.BatchGetItemOutput:
+		return output.ConsumedCapacity
+	case *dynamodb.BatchWriteItemOutput:
+		return output.ConsumedCapacity
+	case *dynamodb.TransactGetItemsOutput:
+		return output.ConsumedCapacity
+	case *dynamodb.TransactWriteItemsOutput:
+		return output.ConsumedCapacity
+	}
+	return nil
+}
+
+// capacitySource names what a call was made for: the API route being answered, eg "GET /requests", or the
+// function making it outside the API, eg "system:open311-Digest"
+func capacitySource() metrics.Dimension {
+	source := currentSource()
+	if source.Route != "" {
+		return metrics.Dimension{Name: "Route", Value: source.Route}
+	}
+	return metrics.Dimension{Name: "Route", Value: source.Actor}
+}
+
+// countCapacity counts the capacity units consumed by the DynamoDB calls that succeed as ConsumedReadCapacity and
+// ConsumedWriteCapacity, by table and then route, so the routes whose queries and scans cost the most stand out.
+// Transactions and batches are counted against each of their tables.
+func countCapacity(r *request.Request) {
+	if r.Error != nil {
+		return
+	}
+
+	route := capacitySource()
+	for _, c := range consumed(r.Data) {
+		if c == nil {
+			continue
+		}
+		table := metrics.Dimension{Name: "Table", Value: aws.StringValue(c.TableName)}
+		if c.ReadCapacityUnits != nil || c.WriteCapacityUnits != nil {
+			if units := aws.Float64Value(c.ReadCapacityUnits); units > 0 {
+				metrics.Emit("ConsumedReadCapacity", metrics.UnitCount, units, table, route)
+			}
+			if units := aws.Float64Value(c.WriteCapacityUnits); units > 0 {
+				metrics.Emit("ConsumedWriteCapacity", metrics.UnitCount, units, table, route)
+			}
+			continue
+		}
+
+		// TOTAL capacity only says how much was consumed, not whether it was read or written
+		if isWrite(r.Operation.Name) {
+			metrics.Emit("ConsumedWriteCapacity", metrics.UnitCount, aws.Float64Value(c.CapacityUnits), table, route)
+		} else {
+			metrics.Emit("ConsumedReadCapacity", metrics.UnitCount, aws.Float64Value(c.CapacityUnits), table, route)
+		}
+	}
+}
+
+// isWrite reports whether a DynamoDB operation writes
+func isWrite(operation string) bool {
+	switch operation {
+	case "PutItem", "UpdateItem", "DeleteItem", "BatchWriteItem", "TransactWriteItems":
+		return true
+	}
+	return false
+}
+
+// countThrottles counts every attempt of a DynamoDB call refused for exceeding capacity as DynamoDBThrottles, by
+// table and then route.  The SDK retries throttled calls, so they are counted as they happen rather than once the
+// call completes.
+func countThrottles(r *request.Request) {
+	aerr, ok := r.Error.(awserr.Error)
+	if !ok || !throttleCodes[aerr.Code()] {
+		return
+	}
+	metrics.Count("DynamoDBThrottles", metrics.Dimension{Name: "Table", Value: tableOf(r.Params)}, capacitySource())
+}
+
+// tableOf returns the table a call is made to, or "multiple" for batches and transactions
+func tableOf(params interface{}) string {
+	switch input := params.(type) {
+	case *dynamodb.GetItemInput:
+		return aws.StringValue(input.TableName)
+	case *dynamodb.PutItemInput:
+		return aws.StringValue(input.TableName)
+	case *dynamodb.UpdateItemInput:
+		return aws.StringValue(input.TableName)
+	case *dynamodb.DeleteItemInput:
+		return aws.StringValue(input.TableName)
+	case *dynamodb.QueryInput:
+		return aws.StringValue(input.TableName)
+	case *dynamodb.ScanInput:
+		return aws.StringValue(input.TableName)
+	}
+	return "multiple"
+}
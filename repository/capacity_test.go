@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestAskCapacity(t *testing.T) {
+	query := &dynamodb.QueryInput{TableName: aws.String(RequestsTable)}
+	askCapacity(&request.Request{Params: query})
+	if aws.StringValue(query.ReturnConsumedCapacity) != dynamodb.ReturnConsumedCapacityTotal {
+		t.Errorf("ReturnConsumedCapacity of a query = %v, want TOTAL", query.ReturnConsumedCapacity)
+	}
+
+	put := &dynamodb.PutItemInput{TableName: aws.String(RequestsTable), ReturnConsumedCapacity: aws.String(dynamodb.ReturnConsumedCapacityIndexes)}
+	askCapacity(&request.Request{Params: put})
+	if aws.StringValue(put.ReturnConsumedCapacity) != dynamodb.ReturnConsumedCapacityIndexes {
+		t.Errorf("ReturnConsumedCapacity of a put asking for indexes = %v, want INDEXES kept", put.ReturnConsumedCapacity)
+	}
+
+	if table := tableOf(query); table != RequestsTable {
+		t.Errorf("tableOf() a query = %s, want %s", table, RequestsTable)
+	}
+	if table := tableOf(&dynamodb.BatchGetItemInput{}); table != "multiple" {
+		t.Errorf("tableOf() a batch = %s, want multiple", table)
+	}
+
+	batch := &dynamodb.BatchWriteItemOutput{ConsumedCapacity: []*dynamodb.ConsumedCapacity{
+		{TableName: aws.String(RequestsTable), CapacityUnits: aws.Float64(2)},
+		{TableName: aws.String(CountersTable), CapacityUnits: aws.Float64(1)},
+	}}
+	if c := consumed(batch); len(c) != 2 || aws.StringValue(c[1].TableName) != CountersTable {
+		t.Errorf("consumed() of a batch = %v, want one per table", c)
+	}
+	if !isWrite("BatchWriteItem") || isWrite("Scan") {
+		t.Error("isWrite() should tell writes from reads")
+	}
+}
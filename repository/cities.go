@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// cityFields are the attributes of a Cities record replaced by UpdateCity.  The boundary, neighborhoods and config
+// have their own setters, the federation API key is never sent through the API, and deactivation has its own
+// endpoints.  The region is fixed when the city is added, as its data doesn't move with it.
+var cityFields = []string{
+	"endpoint", "media_bucket", "sender_email", "logo_url", "brand_color", "place_index", "sms_daily_quota",
+	"media_archive_days", "media_retention_days", "bbox", "federated", "federation_jurisdiction_id",
+}
+
+// UpdateCity replaces the settings of a city's record, keeping its boundary, neighborhoods, config, federation API
+// key, deactivation and region.  If the city is not in the database, a CityNotFoundErr error is set
+func UpdateCity(city City) error {
+	svc, err := createDirectoryClient()
+	if err != nil {
+		return err
+	}
+
+	av, err := dynamodbattribute.MarshalMap(city)
+	if err != nil {
+		return fmt.Errorf("repository: Failed to marshal city:\n %+v. \n  %s", city, err)
+	}
+
+	// Settings left out of the city, eg an omitted bbox, are removed
+	var set, remove []string
+	names := map[string]*string{}
+	values := map[string]*dynamodb.AttributeValue{}
+	for i, field := range cityFields {
+		name := fmt.Sprintf("#f%d", i)
+		names[name] = aws.String(field)
+		if v, ok := av[field]; ok {
+			set = append(set, fmt.Sprintf("%s = :v%d", name, i))
+			values[fmt.Sprintf(":v%d", i)] = v
+		} else {
+			remove = append(remove, name)
+		}
+	}
+	update := "SET " + strings.Join(set, ", ")
+	if len(remove) > 0 {
+		update += " REMOVE " + strings.Join(remove, ", ")
+	}
+
+	_, err = svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(CitiesTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"city_name": {
+				S: aws.String(city.CityName),
+			},
+		},
+		ConditionExpression:       aws.String("attribute_exists(city_name)"),
+		UpdateExpression:          aws.String(update),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return &CityNotFoundErr{"city not found"}
+		}
+		return fmt.Errorf("repository: failed to update city %s. \n %s", city.CityName, err)
+	}
+
+	return nil
+}
+
+// SetCityDeactivated pauses or resumes a city.  The notice is shown to residents whose submissions are refused
+// while the city is paused, and is cleared when it resumes.  If the city is not in the database, a
+// CityNotFoundErr error is set
+func SetCityDeactivated(cityName string, deactivated bool, notice string) error {
+	svc, err := createDirectoryClient()
+	if err != nil {
+		return err
+	}
+
+	if !deactivated {
+		notice = ""
+	}
+
+	_, err = svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(CitiesTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"city_name": {
+				S: aws.String(cityName),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(city_name)"),
+		UpdateExpression:    aws.String("SET deactivated = :d, deactivation_notice = :n"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":d": {BOOL: aws.Bool(deactivated)},
+			":n": {S: aws.String(notice)},
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return &CityNotFoundErr{"city not found"}
+		}
+		return fmt.Errorf("repository: failed to set deactivation of %s. \n %s", cityName, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// Comment is a note left on a request by a resident or a member of staff
+type Comment struct {
+	AccountID string `json:"account_id"` // Unique ID of the user account that left the comment
+	Text      string `json:"text"`
+	Timestamp string `json:"timestamp"` // RFC3339 formatted timestamp
+}
+
+// AddComment appends a comment to a request of a city, leaving the rest of the request as it is.  If the request is
+// not in the database, a RequestIdNotFoundErr error is set
+func AddComment(cityID string, requestID string, accountID string, text string) (Comment, error) {
+	svc, err := createCityClient(cityID)
+	if err != nil {
+		return Comment{}, err
+	}
+
+	comment := Comment{AccountID: accountID, Text: text, Timestamp: time.Now().UTC().Format(time.RFC3339)}
+	av, err := dynamodbattribute.MarshalMap(comment)
+	if err != nil {
+		return Comment{}, fmt.Errorf("repository: failed to marshal comment: %+v \n %s", comment, err)
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(RequestsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"service_request_id": {S: aws.String(requestID)},
+		},
+		ConditionExpression: aws.String("attribute_exists(service_request_id)"),
+		UpdateExpression:    aws.String("SET comments = list_append(if_not_exists(comments, :empty_list), :c) " + versionUpdate),
+		ExpressionAttributeNames: map[string]*string{
+			"#V": aws.String("version"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":c":          {L: []*dynamodb.AttributeValue{{M: av}}},
+			":empty_list": {L: []*dynamodb.AttributeValue{}},
+			":one":        {N: aws.String("1")},
+		},
+	}
+
+	_, err = svc.UpdateItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return Comment{}, &RequestIdNotFoundErr{"request not found"}
+	}
+	if err != nil {
+		return Comment{}, fmt.Errorf("repository: failed to add comment to request %s \n %s", requestID, err)
+	}
+	return comment, nil
+}
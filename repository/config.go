@@ -0,0 +1,357 @@
+package repository
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// CityConfig holds the settings a city tunes for itself.  It is kept as config on the city's Cities record; the
+// zero CityConfig leaves every setting at the platform default.
+type CityConfig struct {
+	TimeZone        string            `json:"timezone"`          // IANA time zone, eg "America/New_York". Empty for UTC
+	Locale          string            `json:"locale"`            // BCP 47 language tag, eg "en-US". Empty for English
+	Contact         CityContact       `json:"contact"`           // How residents reach the city outside the app
+	DefaultSLAHours int               `json:"default_sla_hours"` // SLA of services that set none. 0 for no service level agreement
+	Notifications   CityNotifications `json:"notifications"`
+	Features        map[string]bool   `json:"features"` // Optional features the city has switched on or off
+	Calendar        BusinessCalendar  `json:"calendar"` // When the city works on requests. SLAs are counted in its business hours
+	OpenData        OpenDataSettings  `json:"open_data"`
+	WorkOrders      WorkOrderSettings `json:"work_orders"`   // The work-order system requests are synced with
+	InboundEmail    map[string]string `json:"inbound_email"` // service_code of each address residents email requests to, eg {"potholes@schenectady.example": "schenectady-pothole"}
+}
+
+// CityContact is a city's public contact information
+type CityContact struct {
+	Email      string `json:"email"`
+	Phone      string `json:"phone"`
+	WebsiteURL string `json:"website_url"`
+}
+
+// CityNotifications are a city's notification settings
+type CityNotifications struct {
+	DisabledChannels []string `json:"disabled_channels"` // Channels the city doesn't notify residents through, eg "sms"
+}
+
+// OpenDataSettings are a city's open-data settings.  Cities opt in to a nightly snapshot of their requests being
+// published, less the fields they redact.
+type OpenDataSettings struct {
+	Enabled      bool           `json:"enabled"`       // Publish the nightly snapshot
+	RedactFields []string       `json:"redact_fields"` // OpenDataFields left out of the snapshot and portal, eg "address"
+	Portal       OpenDataPortal `json:"portal"`
+}
+
+// Open-data portals a city's requests can be published to
+const (
+	PortalSocrata = "socrata"
+	PortalCKAN    = "ckan"
+)
+
+// OpenDataPortal is the dataset of a city's open-data portal its requests are published to, for cities required to
+// publish their 311 data there.  The portal's API key is kept as portal_api_key on the city's Cities record.
+type OpenDataPortal struct {
+	Platform string            `json:"platform"` // PortalSocrata or PortalCKAN. Empty publishes to no portal
+	URL      string            `json:"url"`      // Base URL of the portal, eg "https://data.troyny.gov"
+	Dataset  string            `json:"dataset"`  // Socrata dataset ID, eg "abcd-1234", or CKAN resource ID
+	Columns  map[string]string `json:"columns"`  // Dataset column of each OpenDataColumn whose column is named otherwise
+	Live     bool              `json:"live"`     // Publish requests as they are made and change, as well as nightly
+}
+
+// Validate checks the settings of a portal
+func (p OpenDataPortal) Validate() error {
+	switch p.Platform {
+	case "":
+		return nil
+	case PortalSocrata, PortalCKAN:
+	default:
+		return fmt.Errorf("open data portal platform '%s' must be %s or %s", p.Platform, PortalSocrata, PortalCKAN)
+	}
+	if !strings.HasPrefix(p.URL, "https://") {
+		return fmt.Errorf("open data portal url must be an https URL")
+	}
+	if p.Dataset == "" {
+		return fmt.Errorf("open data portal dataset is required")
+	}
+	for column, name := range p.Columns {
+		if !isOpenDataColumn(column) {
+			return fmt.Errorf("open data portal can't map '%s'. Map one of %s", column, strings.Join(OpenDataColumns(), ", "))
+		}
+		if name == "" {
+			return fmt.Errorf("open data portal column of '%s' is empty", column)
+		}
+	}
+	return nil
+}
+
+// Column returns the dataset column an OpenDataColumn is published to
+func (p OpenDataPortal) Column(column string) string {
+	if name, ok := p.Columns[column]; ok {
+		return name
+	}
+	return column
+}
+
+// OpenDataFields are the fields of a request published in open-data snapshots, in the order of their CSV columns.
+// Descriptions, notes, media and anything tying a request to the resident who made it are never published;
+// "location" stands for the lat and lon columns.
+var OpenDataFields = []string{
+	"service_request_id",
+	"status",
+	"service_code",
+	"service_name",
+	"agency_responsible",
+	"requested_datetime",
+	"update_datetime",
+	"expected_datetime",
+	"closed_datetime",
+	"resolution_hours",
+	"address",
+	"zipcode",
+	"location",
+}
+
+type InvalidCityConfigErr struct {
+	message string
+}
+
+func (e *InvalidCityConfigErr) Error() string {
+	return e.message
+}
+
+// localePattern matches the BCP 47 tags cities use in practice: a language with an optional script and region
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z][a-z]{3})?(-([A-Z]{2}|[0-9]{3}))?$`)
+
+// featurePattern keeps feature flag names to lower case words joined by underscores, eg "photo_required"
+var featurePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// Validate checks the settings of a config.  If one is unusable, an InvalidCityConfigErr error is set
+func (c CityConfig) Validate() error {
+	if _, err := time.LoadLocation(c.TimeZone); err != nil {
+		return &InvalidCityConfigErr{fmt.Sprintf("timezone '%s' is not an IANA time zone, eg America/New_York", c.TimeZone)}
+	}
+	if c.Locale != "" && !localePattern.MatchString(c.Locale) {
+		return &InvalidCityConfigErr{fmt.Sprintf("locale '%s' is not a language tag, eg en-US", c.Locale)}
+	}
+	if c.Contact.Email != "" && !strings.Contains(c.Contact.Email, "@") {
+		return &InvalidCityConfigErr{fmt.Sprintf("contact email '%s' is not an email address", c.Contact.Email)}
+	}
+	if c.Contact.WebsiteURL != "" && !strings.HasPrefix(c.Contact.WebsiteURL, "https://") {
+		return &InvalidCityConfigErr{"contact website_url must be an https URL"}
+	}
+	if c.DefaultSLAHours < 0 {
+		return &InvalidCityConfigErr{"default_sla_hours can't be negative. Use 0 for no service level agreement"}
+	}
+	for _, channel := range c.Notifications.DisabledChannels {
+		switch channel {
+		case ChannelPush, ChannelEmail, ChannelSMS:
+		default:
+			return &InvalidCityConfigErr{fmt.Sprintf("disabled channel '%s' must be push, email or sms", channel)}
+		}
+	}
+	if err := c.Calendar.Validate(); err != nil {
+		return &InvalidCityConfigErr{err.Error()}
+	}
+	for _, field := range c.OpenData.RedactFields {
+		if field == "service_request_id" || !isOpenDataField(field) {
+			return &InvalidCityConfigErr{fmt.Sprintf("open data can't redact '%s'. Redact one of %s", field, strings.Join(OpenDataFields[1:], ", "))}
+		}
+	}
+	if err := c.OpenData.Portal.Validate(); err != nil {
+		return &InvalidCityConfigErr{err.Error()}
+	}
+	if err := c.WorkOrders.Validate(); err != nil {
+		return &InvalidCityConfigErr{err.Error()}
+	}
+	for address, code := range c.InboundEmail {
+		if !strings.Contains(address, "@") {
+			return &InvalidCityConfigErr{fmt.Sprintf("inbound email '%s' is not an email address", address)}
+		}
+		if code == "" {
+			return &InvalidCityConfigErr{fmt.Sprintf("inbound email '%s' needs the service_code of the requests emailed to it", address)}
+		}
+	}
+	for feature := range c.Features {
+		if !featurePattern.MatchString(feature) {
+			return &InvalidCityConfigErr{fmt.Sprintf("feature '%s' must be lower case letters, digits and underscores", feature)}
+		}
+	}
+	return nil
+}
+
+// InboundService returns the service_code of requests emailed to an address, and whether the city takes requests
+// at it.  Addresses are compared without regard to case.
+func (c CityConfig) InboundService(address string) (string, bool) {
+	for a, code := range c.InboundEmail {
+		if strings.EqualFold(a, address) {
+			return code, true
+		}
+	}
+	return "", false
+}
+
+// OpenDataColumns returns the columns requests are published in: the OpenDataFields with location as lat and lon
+func OpenDataColumns() []string {
+	columns := []string{}
+	for _, field := range OpenDataFields {
+		if field == "location" {
+			columns = append(columns, "lat", "lon")
+		} else {
+			columns = append(columns, field)
+		}
+	}
+	return columns
+}
+
+// isOpenDataColumn reports whether column is one of the OpenDataColumns
+func isOpenDataColumn(column string) bool {
+	for _, c := range OpenDataColumns() {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// isOpenDataField reports whether field is one of the OpenDataFields
+func isOpenDataField(field string) bool {
+	for _, f := range OpenDataFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Location returns the time zone of a city, or UTC when it has none or it can't be loaded
+func (c CityConfig) Location() *time.Location {
+	loc, err := time.LoadLocation(c.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// SLAHours returns the hours within which requests for a service should be resolved in a city: the service's
+// own SLA, else the city's default.  0 means no service level agreement.
+func (c CityConfig) SLAHours(service Service) int {
+	if service.SLAHours > 0 {
+		return service.SLAHours
+	}
+	return c.DefaultSLAHours
+}
+
+// Due returns when a request made at requested falls due under an SLA of slaHours, counted in the city's business
+// hours
+func (c CityConfig) Due(requested time.Time, slaHours int) time.Time {
+	return c.Calendar.Add(requested, time.Duration(slaHours)*time.Hour, c.Location()).UTC()
+}
+
+// ChannelEnabled reports whether a city notifies residents through a channel
+func (c CityConfig) ChannelEnabled(channel string) bool {
+	for _, disabled := range c.Notifications.DisabledChannels {
+		if disabled == channel {
+			return false
+		}
+	}
+	return true
+}
+
+// Feature flags the platform evaluates
+const (
+	FeatureUpvoting           = "upvoting"            // Residents can upvote requests others have made
+	FeatureAnonymousReporting = "anonymous_reporting" // Requests can be submitted without an account
+	FeatureVideoUpload        = "video_upload"        // Video can be attached to requests
+	FeatureStaticSnapshots    = "static_snapshots"    // Plain listings of services and requests are redirected to static snapshots
+)
+
+// featureDefaults are the flags of cities that haven't set them.  Capabilities every city had before flags existed
+// stay on until a city switches them off.
+var featureDefaults = map[string]bool{
+	FeatureAnonymousReporting: true,
+	FeatureVideoUpload:        true,
+}
+
+// Enabled reports whether a city has switched a feature on.  Features the city hasn't set take the platform
+// default, which is off for all but the capabilities that predate flags.
+func (c CityConfig) Enabled(feature string) bool {
+	if on, ok := c.Features[feature]; ok {
+		return on
+	}
+	return featureDefaults[feature]
+}
+
+// GetCityConfig returns the config of a city, reading only its config.  If the city is not in the database, a
+// CityNotFoundErr error is set
+func GetCityConfig(cityName string) (CityConfig, error) {
+	svc, err := createDirectoryClient()
+	if err != nil {
+		return CityConfig{}, err
+	}
+
+	result, err := svc.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(CitiesTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"city_name": {
+				S: aws.String(cityName),
+			},
+		},
+		ProjectionExpression:     aws.String("city_name, #C"),
+		ExpressionAttributeNames: map[string]*string{"#C": aws.String("config")},
+	})
+	if err != nil {
+		return CityConfig{}, fmt.Errorf("repository: unable to get config of %s. \n %s", cityName, err)
+	}
+	if result.Item == nil {
+		return CityConfig{}, &CityNotFoundErr{"city not found"}
+	}
+
+	city := City{}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &city)
+	if err != nil {
+		return CityConfig{}, fmt.Errorf("repository: Failed to unmarshal config of %s. \n  %s", cityName, err)
+	}
+	return city.Config, nil
+}
+
+// SetCityConfig replaces the config of a city.  If the city is not in the database, a CityNotFoundErr error is
+// set
+func SetCityConfig(cityName string, config CityConfig) error {
+	svc, err := createDirectoryClient()
+	if err != nil {
+		return err
+	}
+
+	av, err := dynamodbattribute.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("repository: Failed to marshal config of %s. \n  %s", cityName, err)
+	}
+
+	_, err = svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(CitiesTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"city_name": {
+				S: aws.String(cityName),
+			},
+		},
+		ConditionExpression:      aws.String("attribute_exists(city_name)"),
+		UpdateExpression:         aws.String("SET #C = :c"),
+		ExpressionAttributeNames: map[string]*string{"#C": aws.String("config")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":c": av,
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return &CityNotFoundErr{"city not found"}
+		}
+		return fmt.Errorf("repository: failed to set config of %s. \n  %s", cityName, err)
+	}
+
+	return nil
+}
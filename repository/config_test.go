@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCityConfigValidate(t *testing.T) {
+	valid := CityConfig{
+		TimeZone:        "America/New_York",
+		Locale:          "en-US",
+		Contact:         CityContact{Email: "311@troyny.gov", WebsiteURL: "https://troyny.gov"},
+		DefaultSLAHours: 72,
+		Notifications:   CityNotifications{DisabledChannels: []string{ChannelSMS}},
+		Features:        map[string]bool{"photo_required": true},
+		OpenData: OpenDataSettings{Enabled: true, RedactFields: []string{"address", "location"}, Portal: OpenDataPortal{
+			Platform: PortalSocrata, URL: "https://data.troyny.gov", Dataset: "abcd-1234", Columns: map[string]string{"service_request_id": "id", "lat": "latitude"},
+		}},
+		WorkOrders: WorkOrderSettings{
+			Connector: ConnectorCityworks, URL: "https://cityworks.troyny.gov", SecretID: "open311/troy/cityworks",
+			Services: []string{"pothole"}, Types: map[string]string{"pothole": "42"}, Statuses: map[string]string{"OPEN": RequestOpen, "CLOSED": RequestClosed},
+		},
+		InboundEmail: map[string]string{"potholes@troyny.gov": "troy-pothole"},
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate() = %s, want nil", err)
+	}
+	if err := (CityConfig{}).Validate(); err != nil {
+		t.Errorf("Validate() of zero config = %s, want nil", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*CityConfig)
+	}{
+		{"unknown time zone", func(c *CityConfig) { c.TimeZone = "Eastern" }},
+		{"malformed locale", func(c *CityConfig) { c.Locale = "english" }},
+		{"malformed email", func(c *CityConfig) { c.Contact.Email = "troyny.gov" }},
+		{"http website", func(c *CityConfig) { c.Contact.WebsiteURL = "http://troyny.gov" }},
+		{"negative SLA", func(c *CityConfig) { c.DefaultSLAHours = -1 }},
+		{"unknown channel", func(c *CityConfig) { c.Notifications.DisabledChannels = []string{"fax"} }},
+		{"malformed feature", func(c *CityConfig) { c.Features = map[string]bool{"Photo Required": true} }},
+		{"unknown open data field", func(c *CityConfig) { c.OpenData.RedactFields = []string{"account_id"} }},
+		{"redacted request id", func(c *CityConfig) { c.OpenData.RedactFields = []string{"service_request_id"} }},
+		{"unknown portal", func(c *CityConfig) { c.OpenData.Portal.Platform = "arcgis" }},
+		{"http portal", func(c *CityConfig) { c.OpenData.Portal.URL = "http://data.troyny.gov" }},
+		{"portal without dataset", func(c *CityConfig) { c.OpenData.Portal.Dataset = "" }},
+		{"unknown portal column", func(c *CityConfig) { c.OpenData.Portal.Columns = map[string]string{"location": "point"} }},
+		{"empty portal column", func(c *CityConfig) { c.OpenData.Portal.Columns = map[string]string{"status": ""} }},
+		{"unknown work order connector", func(c *CityConfig) { c.WorkOrders.Connector = "maximo" }},
+		{"http work order system", func(c *CityConfig) { c.WorkOrders.URL = "http://cityworks.troyny.gov" }},
+		{"work orders without secret", func(c *CityConfig) { c.WorkOrders.SecretID = "" }},
+		{"work orders without statuses", func(c *CityConfig) { c.WorkOrders.Statuses = nil }},
+		{"unknown work order status", func(c *CityConfig) { c.WorkOrders.Statuses = map[string]string{"DONE": "done"} }},
+		{"cityworks service without template", func(c *CityConfig) { c.WorkOrders.Services = []string{"pothole", "graffiti"} }},
+		{"malformed inbound email", func(c *CityConfig) { c.InboundEmail = map[string]string{"potholes": "troy-pothole"} }},
+		{"inbound email without service", func(c *CityConfig) { c.InboundEmail = map[string]string{"potholes@troyny.gov": ""} }},
+	}
+
+	for _, tt := range tests {
+		config := valid
+		tt.modify(&config)
+		if _, ok := config.Validate().(*InvalidCityConfigErr); !ok {
+			t.Errorf("Validate() with %s should set an InvalidCityConfigErr", tt.name)
+		}
+	}
+}
+
+func TestCityConfigAccessors(t *testing.T) {
+	config := CityConfig{
+		TimeZone:        "America/New_York",
+		DefaultSLAHours: 72,
+		Notifications:   CityNotifications{DisabledChannels: []string{ChannelSMS}},
+		Features:        map[string]bool{"photo_required": true, "anonymous": false},
+	}
+
+	if got := config.SLAHours(Service{SLAHours: 4}); got != 4 {
+		t.Errorf("SLAHours of service with SLA = %d, want 4", got)
+	}
+	if got := config.SLAHours(Service{}); got != 72 {
+		t.Errorf("SLAHours of service without SLA = %d, want 72", got)
+	}
+
+	if config.ChannelEnabled(ChannelSMS) || !config.ChannelEnabled(ChannelEmail) {
+		t.Error("ChannelEnabled should be false for sms only")
+	}
+
+	if !config.Enabled("photo_required") || config.Enabled("anonymous") || config.Enabled("unknown") {
+		t.Error("Enabled should be true for photo_required only")
+	}
+	if !config.Enabled(FeatureAnonymousReporting) || config.Enabled(FeatureUpvoting) {
+		t.Error("Enabled of unset features should be the platform default")
+	}
+	config.Features[FeatureVideoUpload] = false
+	if config.Enabled(FeatureVideoUpload) {
+		t.Error("Enabled should be false for a default feature the city switched off")
+	}
+
+	config.InboundEmail = map[string]string{"potholes@troyny.gov": "troy-pothole"}
+	if code, ok := config.InboundService("Potholes@TroyNY.gov"); !ok || code != "troy-pothole" {
+		t.Errorf("InboundService() = %s, %v, want troy-pothole", code, ok)
+	}
+	if _, ok := config.InboundService("graffiti@troyny.gov"); ok {
+		t.Error("InboundService() of an address the city doesn't take requests at should not be ok")
+	}
+
+	if got := config.Location().String(); got != "America/New_York" {
+		t.Errorf("Location() = %s, want America/New_York", got)
+	}
+	if got := (CityConfig{}).Location(); got != time.UTC {
+		t.Errorf("Location() of zero config = %s, want UTC", got)
+	}
+}
+
+func TestOpenDataPortalColumn(t *testing.T) {
+	portal := OpenDataPortal{Columns: map[string]string{"service_request_id": "id"}}
+	if got := portal.Column("service_request_id"); got != "id" {
+		t.Errorf("Column(service_request_id) = %s, want id", got)
+	}
+	if got := portal.Column("lat"); got != "lat" {
+		t.Errorf("Column(lat) = %s, want lat", got)
+	}
+}
@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// ConnectionsTable holds the clients connected to the WebSocket API
+const ConnectionsTable = "Connections"
+
+// connectionTTL is how long a connection record outlives its last update.  API Gateway closes WebSocket
+// connections after two hours, so older records are left over from missed disconnects.
+const connectionTTL = 3 * time.Hour
+
+// Connection is a dashboard or app receiving live updates over the WebSocket API
+type Connection struct {
+	ID        string         `json:"connection_id"` // API Gateway connection ID
+	AccountID string         `json:"account_id"`
+	Filters   []Subscription `json:"filters"`    // Requests the client wants updates on. Empty for every request
+	Timestamp string         `json:"timestamp"`  // RFC3339 formatted time the connection was opened
+	ExpiresAt int64          `json:"expires_at"` // Unix time after which DynamoDB may delete the record
+}
+
+// AddConnection stores a newly opened WebSocket connection
+func AddConnection(connection Connection) error {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	connection.Timestamp = now.Format(time.RFC3339)
+	connection.ExpiresAt = now.Add(connectionTTL).Unix()
+
+	av, err := dynamodbattribute.MarshalMap(connection)
+	if err != nil {
+		return fmt.Errorf("repository: Failed to marshal connection:\n %+v. \n  %s", connection, err)
+	}
+
+	_, err = svc.PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(ConnectionsTable),
+	})
+	if err != nil {
+		return fmt.Errorf("repository: failed to put connection in database. \n %s", err)
+	}
+
+	return nil
+}
+
+// SetConnectionFilters replaces the filters scoping the updates a connection receives
+func SetConnectionFilters(connectionID string, filters []Subscription) error {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return err
+	}
+
+	av, err := dynamodbattribute.Marshal(filters)
+	if err != nil {
+		return fmt.Errorf("repository: Failed to marshal connection filters:\n %+v. \n  %s", filters, err)
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#F": aws.String("filters"),
+			"#X": aws.String("expires_at"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":f": av,
+			":x": {N: aws.String(fmt.Sprint(time.Now().Add(connectionTTL).Unix()))},
+		},
+		Key: map[string]*dynamodb.AttributeValue{
+			"connection_id": {
+				S: aws.String(connectionID),
+			},
+		},
+		TableName:        aws.String(ConnectionsTable),
+		UpdateExpression: aws.String("SET #F = :f, #X = :x"),
+	}
+
+	_, err = svc.UpdateItem(input)
+	if err != nil {
+		return fmt.Errorf("repository: failed to set filters of connection %s. \n  %s", connectionID, err)
+	}
+
+	return nil
+}
+
+// GetConnections returns every open WebSocket connection
+func GetConnections() ([]Connection, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return []Connection{}, err
+	}
+
+	params := &dynamodb.ScanInput{
+		TableName: aws.String(ConnectionsTable),
+	}
+
+	items := []map[string]*dynamodb.AttributeValue{}
+	err = svc.ScanPages(params, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		items = append(items, page.Items...)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("repository: unable to get all connections from database. \n %s", err)
+	}
+
+	connections := []Connection{}
+	err = dynamodbattribute.UnmarshalListOfMaps(items, &connections)
+	if err != nil {
+		return connections, fmt.Errorf("repository: Failed to unmarshal connection records. \n %s", err)
+	}
+
+	return connections, nil
+}
+
+// DeleteConnection forgets a closed WebSocket connection
+func DeleteConnection(connectionID string) error {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(ConnectionsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"connection_id": {
+				S: aws.String(connectionID),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("repository: failed to delete connection %s. \n %s", connectionID, err)
+	}
+
+	return nil
+}
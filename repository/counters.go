@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// CountersTable holds atomic counters keyed by counter_id, eg per-city daily SMS quotas
+const CountersTable = "Counters"
+
+// IncrementCounter atomically adds delta to a counter, creating it at zero if needed, and returns the new value
+func IncrementCounter(counterID string, delta int64) (int64, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return 0, err
+	}
+	return incrementCounter(svc, counterID, delta)
+}
+
+// incrementCounter adds delta to a counter of the Counters table svc reaches
+func incrementCounter(svc *dynamodb.DynamoDB, counterID string, delta int64) (int64, error) {
+	input := &dynamodb.UpdateItemInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#C": aws.String("count"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":d": {N: aws.String(fmt.Sprint(delta))},
+		},
+		Key: map[string]*dynamodb.AttributeValue{
+			"counter_id": {
+				S: aws.String(counterID),
+			},
+		},
+		ReturnValues:     aws.String("UPDATED_NEW"),
+		TableName:        aws.String(CountersTable),
+		UpdateExpression: aws.String("ADD #C :d"),
+	}
+
+	result, err := svc.UpdateItem(input)
+	if err != nil {
+		return 0, fmt.Errorf("repository: failed to increment counter %s. \n  %s", counterID, err)
+	}
+
+	var count int64
+	if v, ok := result.Attributes["count"]; ok && v.N != nil {
+		fmt.Sscan(aws.StringValue(v.N), &count)
+	}
+	return count, nil
+}
+
+// GetCounter returns the value of a counter, or 0 if it has never been incremented
+func GetCounter(counterID string) (int64, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return 0, err
+	}
+	return getCounter(svc, counterID)
+}
+
+// getCounter returns a counter of the Counters table svc reaches
+func getCounter(svc *dynamodb.DynamoDB, counterID string) (int64, error) {
+	result, err := svc.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(CountersTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"counter_id": {
+				S: aws.String(counterID),
+			},
+		},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("repository: failed to get counter %s. \n  %s", counterID, err)
+	}
+
+	var count int64
+	if v, ok := result.Item["count"]; ok && v.N != nil {
+		fmt.Sscan(aws.StringValue(v.N), &count)
+	}
+	return count, nil
+}
@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// NotificationDeliveriesTable logs every notification sent about a request, keyed by service_request_id and
+// delivery_id
+const NotificationDeliveriesTable = "NotificationDeliveries"
+
+// Channels notifications are delivered through
+const (
+	ChannelPush  = "push"
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+	ChannelSlack = "slack"
+	ChannelTeams = "teams"
+)
+
+// Outcomes of a notification delivery attempt
+const (
+	DeliverySent     = "sent"     // accepted by the provider
+	DeliveryFailed   = "failed"   // rejected or unreachable; retried through the retry queue where possible
+	DeliverySkipped  = "skipped"  // deliberately not sent, eg over quota
+	DeliveryDeferred = "deferred" // held back until later, eg the end of quiet hours, through the retry queue
+)
+
+// NotificationDelivery is one attempt to notify someone about a request
+type NotificationDelivery struct {
+	ServiceRequestID string `json:"service_request_id"`
+	DeliveryID       string `json:"delivery_id"` // ULID, so attempts sort by time
+	AccountID        string `json:"account_id"`  // User notified. Empty for agency and staff notifications
+	Channel          string `json:"channel"`     // One of push, email, sms or slack
+	Recipient        string `json:"recipient"`   // Address, phone number or endpoint notified
+	Status           string `json:"status"`      // One of sent, failed or skipped
+	MessageID        string `json:"message_id"`  // ID the provider (SES or SNS) assigned the message
+	Error            string `json:"error"`       // Why the attempt failed or was skipped
+	Attempt          int    `json:"attempt"`     // 1 for the first attempt, higher for retries
+	Timestamp        string `json:"timestamp"`
+}
+
+// RecordNotificationDelivery appends an attempt to the delivery log of a request
+func RecordNotificationDelivery(delivery NotificationDelivery) error {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return err
+	}
+
+	id, err := genID()
+	if err != nil {
+		return fmt.Errorf("repository: failed to generate unique id for notification delivery. \n  %s", err)
+	}
+	delivery.DeliveryID = id
+	delivery.Timestamp = time.Now().Format(time.RFC3339)
+
+	av, err := dynamodbattribute.MarshalMap(delivery)
+	if err != nil {
+		return fmt.Errorf("repository: Failed to marshal notification delivery:\n %+v. \n  %s", delivery, err)
+	}
+
+	_, err = svc.PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(NotificationDeliveriesTable),
+	})
+	if err != nil {
+		return fmt.Errorf("repository: failed to put notification delivery in database. \n %s", err)
+	}
+
+	return nil
+}
+
+// GetNotificationDeliveries returns every notification attempt about a request, oldest first
+func GetNotificationDeliveries(requestID string) ([]NotificationDelivery, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return []NotificationDelivery{}, err
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(NotificationDeliveriesTable),
+		KeyConditionExpression: aws.String("service_request_id = :r"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":r": {
+				S: aws.String(requestID),
+			},
+		},
+	}
+
+	result, err := svc.Query(input)
+	if err != nil {
+		return nil, fmt.Errorf("repository: unable to get notification deliveries of request %s. \n %s", requestID, err)
+	}
+
+	deliveries := []NotificationDelivery{}
+	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &deliveries)
+	if err != nil {
+		return deliveries, fmt.Errorf("repository: Failed to unmarshal notification delivery records. \n %s", err)
+	}
+
+	return deliveries, nil
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+)
+
+// Engagement measures how residents of a city take part over a period, for adoption reports to councils
+type Engagement struct {
+	CityID            string           `json:"city_id"`
+	Start             string           `json:"start"` // First day of the period, YYYY-MM-DD in the city's time zone
+	End               string           `json:"end"`   // Last day of the period
+	Signups           int64            `json:"signups"`
+	Requests          int64            `json:"requests"`           // Requests submitted
+	AnonymousRequests int64            `json:"anonymous_requests"` // Requests submitted without an account
+	ActiveReporters   int64            `json:"active_reporters"`   // Accounts that submitted a request
+	RepeatReporters   int64            `json:"repeat_reporters"`   // Accounts that submitted more than one request
+	Watches           int64            `json:"watches"`            // Subscriptions added to requests, services and areas
+	WatchesByType     map[string]int64 `json:"watches_by_type"`
+}
+
+// RecordSignup counts a resident signing up with a city at a time
+func RecordSignup(cityID string, at time.Time) error {
+	return recordEngagement(cityID, at, DailyStats{Signups: 1})
+}
+
+// RecordWatch counts a resident of a city subscribing to updates of a type at a time
+func RecordWatch(cityID string, subscriptionType string, at time.Time) error {
+	return recordEngagement(cityID, at, DailyStats{Watches: map[string]int64{subscriptionType: 1}})
+}
+
+// recordEngagement adds engagement counters to a city's stats for the day of at, in the city's time zone.  Cities
+// not in the database count in UTC.
+func recordEngagement(cityID string, at time.Time, stats DailyStats) error {
+	config, err := GetCityConfig(cityID)
+	if _, ok := err.(*CityNotFoundErr); err != nil && !ok {
+		return fmt.Errorf("repository: unable to record engagement of %s. \n  %s", cityID, err)
+	}
+
+	stats.Day = at.In(config.Location()).Format("2006-01-02")
+	return AddDailyStats(cityID, stats)
+}
+
+// NewEngagement measures engagement from a city's daily stats over a period and the requests submitted during
+// it.  Reporters are counted by account_id.
+func NewEngagement(days []DailyStats, requests []Request) Engagement {
+	engagement := Engagement{WatchesByType: map[string]int64{}}
+	if len(days) > 0 {
+		engagement.Start = days[0].Day
+		engagement.End = days[len(days)-1].Day
+	}
+
+	for _, day := range days {
+		engagement.Signups += day.Signups
+		for kind, n := range day.Watches {
+			engagement.Watches += n
+			engagement.WatchesByType[kind] += n
+		}
+	}
+
+	reporters := map[string]int{}
+	for _, request := range requests {
+		engagement.Requests++
+		if request.AccountID == "" {
+			engagement.AnonymousRequests++
+			continue
+		}
+		reporters[request.AccountID]++
+	}
+	for _, n := range reporters {
+		engagement.ActiveReporters++
+		if n > 1 {
+			engagement.RepeatReporters++
+		}
+	}
+	return engagement
+}
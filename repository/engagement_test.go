@@ -0,0 +1,28 @@
+package repository
+
+import "testing"
+
+func TestNewEngagement(t *testing.T) {
+	days := []DailyStats{
+		{Day: "2019-06-01", Signups: 3, Watches: map[string]int64{"request": 2}},
+		{Day: "2019-06-02"},
+		{Day: "2019-06-03", Signups: 1, Watches: map[string]int64{"request": 1, "area": 1}},
+	}
+	requests := []Request{{AccountID: "a"}, {AccountID: "b"}, {AccountID: "a"}, {AccountID: "a"}, {}}
+
+	e := NewEngagement(days, requests)
+	if e.Start != "2019-06-01" || e.End != "2019-06-03" {
+		t.Errorf("period = %s to %s, want 2019-06-01 to 2019-06-03", e.Start, e.End)
+	}
+	if e.Signups != 4 || e.Watches != 4 || e.WatchesByType["request"] != 3 || e.WatchesByType["area"] != 1 {
+		t.Errorf("signups, watches = %d, %d %v, want 4, 4", e.Signups, e.Watches, e.WatchesByType)
+	}
+	if e.Requests != 5 || e.AnonymousRequests != 1 || e.ActiveReporters != 2 || e.RepeatReporters != 1 {
+		t.Errorf("requests, anonymous, active, repeat = %d, %d, %d, %d, want 5, 1, 2, 1",
+			e.Requests, e.AnonymousRequests, e.ActiveReporters, e.RepeatReporters)
+	}
+
+	if e := NewEngagement(nil, nil); e.WatchesByType == nil || e.Requests != 0 {
+		t.Errorf("NewEngagement() of nothing = %+v", e)
+	}
+}
@@ -0,0 +1,20 @@
+package repository
+
+// EventSource is the EventBridge source of domain events derived from the Requests table stream
+const EventSource = "open311.requests"
+
+// Domain event types published by the stream processor
+const (
+	RequestCreatedEvent = "RequestCreated" // a new request was submitted
+	StatusChangedEvent  = "StatusChanged"  // an existing request moved to a new status
+	MediaAddedEvent     = "MediaAdded"     // media was attached to an existing request
+)
+
+// RequestEvent is the detail of a domain event published to EventBridge
+type RequestEvent struct {
+	Type             string  `json:"type"`                      // One of the *Event constants
+	ServiceRequestID string  `json:"service_request_id"`        // The request the event is about
+	Request          Request `json:"request"`                   // The request as it is after the change
+	PreviousStatus   string  `json:"previous_status,omitempty"` // Status before the change, for StatusChanged events
+	Timestamp        string  `json:"timestamp"`                 // RFC3339 formatted time the change was recorded
+}
@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// RequestAttributes are the attributes of the Requests table a caller may select from a request, named as in its
+// JSON
+var RequestAttributes = []string{
+	"service_request_id", "city_id", "status", "status_notes", "service_name", "service_code", "description",
+	"agency_responsible", "agency_path", "service_notice", "requested_datetime", "update_datetime", "expected_datetime",
+	"scheduled_datetime", "address", "address_id", "zipcode", "lat", "lon", "geometry", "geohash", "geo_cell",
+	"neighborhood", "asset_id", "asset_label", "media_url", "account_id", "external_id", "work_order_id", "audit_log",
+	"comments", "escalation_level", "escalated_datetime", "closed_datetime", "resolution_hours", "assigned_to", "values",
+}
+
+// listingKeys are the attributes listings read whatever fields are selected, to page, place, order and filter
+// the requests they list
+var listingKeys = []string{"service_request_id", "requested_datetime", "status", "lat", "lon"}
+
+// FieldsErr is returned for a selection of fields naming a field that isn't returned
+type FieldsErr struct {
+	message string
+}
+
+func (e *FieldsErr) Error() string {
+	return e.message
+}
+
+// ParseFields returns the fields a comma separated selection names, eg "service_request_id,status,lat,lon", each
+// of which must be one of allowed.  An empty selection returns no fields, selecting them all.  If a field isn't
+// allowed, a FieldsErr error is set
+func ParseFields(selection string, allowed []string) ([]string, error) {
+	known := map[string]bool{}
+	for _, field := range allowed {
+		known[field] = true
+	}
+
+	fields := []string{}
+	seen := map[string]bool{}
+	for _, field := range strings.Split(selection, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" || seen[field] {
+			continue
+		}
+		if !known[field] {
+			return nil, &FieldsErr{fmt.Sprintf("unknown field '%s'. fields may select %s", field, strings.Join(allowed, ", "))}
+		}
+		seen[field] = true
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// ListingAttributes returns the attributes a listing of requests reads to return the fields selected: those fields,
+// and the few every listing needs.  With no fields selected it is the SummaryAttributes.
+func ListingAttributes(fields []string) []string {
+	if len(fields) == 0 {
+		return SummaryAttributes
+	}
+
+	attributes := append([]string{}, listingKeys...)
+	for _, field := range fields {
+		if !contains(attributes, field) {
+			attributes = append(attributes, field)
+		}
+	}
+	return attributes
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRequestFields looks up a request as GetRequest does, reading only the fields selected, and its
+// service_request_id.  With no fields selected the whole request is read.  If the service_request_id is not in the
+// database, a RequestIdNotFoundErr error is set
+func GetRequestFields(cityID string, id string, fields []string) (Request, error) {
+	if len(fields) == 0 {
+		return GetRequest(cityID, id)
+	}
+
+	svc, err := createCityClient(cityID)
+	if err != nil {
+		return Request{}, err
+	}
+
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(RequestsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"service_request_id": {
+				S: aws.String(id),
+			},
+		},
+		ExpressionAttributeNames: map[string]*string{},
+	}
+	attributes := fields
+	if !contains(attributes, "service_request_id") {
+		attributes = append([]string{"service_request_id"}, attributes...)
+	}
+	input.ProjectionExpression = aws.String(projection(attributes, input.ExpressionAttributeNames))
+
+	result, err := svc.GetItem(input)
+	if err != nil {
+		return Request{}, fmt.Errorf("repository: unable to get fields of request %s. \n %s", id, err)
+	}
+
+	request := Request{}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &request)
+	if err != nil {
+		return request, fmt.Errorf("repository: Failed to unmarshal request record from database: %+v. \n %s", result.Item, err)
+	}
+	if request.ServiceRequestID == "" {
+		return Request{}, &RequestIdNotFoundErr{"request not found"}
+	}
+	return request, nil
+}
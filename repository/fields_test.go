@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFields(t *testing.T) {
+	fields, err := ParseFields(" status,lat, lon,status,,", SummaryAttributes)
+	if want := []string{"status", "lat", "lon"}; err != nil || !reflect.DeepEqual(fields, want) {
+		t.Errorf("ParseFields() = %v, %v, want %v", fields, err, want)
+	}
+	if fields, err := ParseFields("", SummaryAttributes); err != nil || len(fields) != 0 {
+		t.Errorf("ParseFields() of no selection = %v, %v, want no fields", fields, err)
+	}
+	if _, err := ParseFields("status,description", SummaryAttributes); err == nil {
+		t.Error("ParseFields() of a field not allowed should fail")
+	} else if _, ok := err.(*FieldsErr); !ok {
+		t.Errorf("ParseFields() error = %T, want *FieldsErr", err)
+	}
+	if _, err := ParseFields("status,description", RequestAttributes); err != nil {
+		t.Errorf("ParseFields() of request fields = %v", err)
+	}
+}
+
+func TestListingAttributes(t *testing.T) {
+	if got := ListingAttributes(nil); !reflect.DeepEqual(got, SummaryAttributes) {
+		t.Errorf("ListingAttributes() of no fields = %v, want the SummaryAttributes", got)
+	}
+
+	// Listings always read what they page, order and filter by
+	got := ListingAttributes([]string{"status", "service_name"})
+	want := []string{"service_request_id", "requested_datetime", "status", "lat", "lon", "service_name"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListingAttributes() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,373 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/social-torch/open311-services/geo"
+)
+
+// GeoCellIndex is the global secondary index of RequestsTable on geo_cell, sorted by geohash
+const GeoCellIndex = "geo_cell-index"
+
+// Geohash precisions.  Cells of GeoCellPrecision are about 4.9km by 4.9km, so a neighbourhood search touches a
+// handful of index partitions; GeohashPrecision locates a request to within a few meters.
+const (
+	GeoCellPrecision = 5
+	GeohashPrecision = 9
+)
+
+// setGeohash derives the geo attributes of a request from its location
+func setGeohash(request *Request) {
+	if !request.HasLocation() {
+		request.Geohash = ""
+		request.GeoCell = ""
+		return
+	}
+	lat, lon := request.Coordinates()
+	request.Geohash = geo.Encode(lat, lon, GeohashPrecision)
+	request.GeoCell = request.Geohash[:GeoCellPrecision]
+}
+
+// BackfillGeohashes sets the geo attributes of stored requests that lack them or whose location has changed,
+// returning how many requests were (or, for a dry run, would be) updated.  Only the geo attributes are written,
+// so requests updated concurrently are not clobbered.
+func BackfillGeohashes(dryRun bool) (int, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return 0, err
+	}
+
+	var updated int64
+	err = scanEach(svc, &dynamodb.ScanInput{TableName: aws.String(RequestsTable)}, func(item map[string]*dynamodb.AttributeValue) error {
+		request := Request{}
+		err := dynamodbattribute.UnmarshalMap(item, &request)
+		if err != nil {
+			return err
+		}
+
+		stored := request
+		setGeohash(&request)
+		if request.Geohash == stored.Geohash && request.GeoCell == stored.GeoCell {
+			return nil
+		}
+
+		atomic.AddInt64(&updated, 1)
+		if dryRun {
+			return nil
+		}
+		return updateGeohash(svc, request)
+	})
+	if err != nil {
+		return int(updated), fmt.Errorf("repository: unable to backfill geohashes. \n %s", err)
+	}
+	return int(updated), nil
+}
+
+// updateGeohash writes only the geo attributes of a request, removing them from requests without a location
+func updateGeohash(svc *dynamodb.DynamoDB, request Request) error {
+	input := &dynamodb.UpdateItemInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#G": aws.String("geohash"),
+			"#C": aws.String("geo_cell"),
+		},
+		Key: map[string]*dynamodb.AttributeValue{
+			"service_request_id": {
+				S: aws.String(request.ServiceRequestID),
+			},
+		},
+		TableName:        aws.String(RequestsTable),
+		UpdateExpression: aws.String("REMOVE #G, #C"),
+	}
+	if request.Geohash != "" {
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":g": {S: aws.String(request.Geohash)},
+			":c": {S: aws.String(request.GeoCell)},
+		}
+		input.UpdateExpression = aws.String("SET #G = :g, #C = :c")
+	}
+
+	_, err := svc.UpdateItem(input)
+	if err != nil {
+		return fmt.Errorf("repository: failed to set geohash of request %s. \n  %s", request.ServiceRequestID, err)
+	}
+	return nil
+}
+
+// GetNearbyRequests returns the requests of a city that are not closed within radius meters of a point, nearest
+// first, with only the attributes given read, eg those ListingAttributes returns.  An empty cityID returns the requests
+// of every city.
+func GetNearbyRequests(cityID string, lat float64, lon float64, radius float64, attributes []string) ([]Request, error) {
+	cells := geo.Cover(geo.CircleBox(lat, lon, radius), GeoCellPrecision)
+
+	requests, err := requestsInCells(cityID, cells, attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	nearby := []Request{}
+	distances := map[string]float64{}
+	for _, request := range requests {
+		if request.Status == RequestClosed {
+			continue
+		}
+		requestLat, requestLon := request.Coordinates()
+		d := geo.Distance(lat, lon, requestLat, requestLon)
+		if d <= radius {
+			nearby = append(nearby, request)
+			distances[request.ServiceRequestID] = d
+		}
+	}
+
+	sort.Slice(nearby, func(i, j int) bool {
+		return distances[nearby[i].ServiceRequestID] < distances[nearby[j].ServiceRequestID]
+	})
+	return nearby, nil
+}
+
+// MaxBoxCells is the most geo cells a bounding box query may cover, about 40km by 40km at mid latitudes
+const MaxBoxCells = 64
+
+type BoxTooLargeErr struct {
+	message string
+}
+
+func (e *BoxTooLargeErr) Error() string {
+	return e.message
+}
+
+// GetRequestsInBox returns the most recently submitted requests of a city inside a bounding box, at most limit
+// of them, with only the attributes given read, eg those ListingAttributes returns.  truncated is true when there were
+// more.  If the box covers more than MaxBoxCells cells, a BoxTooLargeErr error is set
+func GetRequestsInBox(cityID string, box geo.Box, limit int, attributes []string) (requests []Request, truncated bool, err error) {
+	cells := geo.Cover(box, GeoCellPrecision)
+	if len(cells) > MaxBoxCells {
+		return nil, false, &BoxTooLargeErr{"bounding box too large"}
+	}
+
+	inCells, err := requestsInCells(cityID, cells, attributes)
+	if err != nil {
+		return nil, false, err
+	}
+
+	requests = []Request{}
+	for _, request := range inCells {
+		if box.Contains(request.Coordinates()) {
+			requests = append(requests, request)
+		}
+	}
+
+	// RFC3339 timestamps in the same zone sort chronologically as strings
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].RequestedDateTime > requests[j].RequestedDateTime
+	})
+	if len(requests) > limit {
+		return requests[:limit], true, nil
+	}
+	return requests, false, nil
+}
+
+// MaxClusterCells is the most geo cells a cluster query may cover, about 80km by 80km at mid latitudes.  Cluster
+// queries read only the location of each request, so they can afford more cells than GetRequestsInBox.
+const MaxClusterCells = 256
+
+// GetRequestClusters groups the requests of a city inside a bounding box into the geohash cells of a precision,
+// returning the centroid and count of each cell.  If the box covers more than MaxClusterCells cells, a
+// BoxTooLargeErr error is set
+func GetRequestClusters(cityID string, box geo.Box, precision int) ([]geo.Cluster, error) {
+	cells := geo.Cover(box, GeoCellPrecision)
+	if len(cells) > MaxClusterCells {
+		return nil, &BoxTooLargeErr{"bounding box too large"}
+	}
+
+	requests, err := requestsInCells(cityID, cells, []string{"service_request_id", "lat", "lon"})
+	if err != nil {
+		return nil, err
+	}
+
+	clusterer := geo.NewClusterer(precision)
+	for _, request := range requests {
+		lat, lon := request.Coordinates()
+		if box.Contains(lat, lon) {
+			clusterer.Add(request.ServiceRequestID, lat, lon)
+		}
+	}
+	return clusterer.Clusters(), nil
+}
+
+// requestsInCells queries the geo_cell-index for every request of a city in the given cells, reading only the
+// named attributes when there are any.  An empty cityID reads the requests of every city.
+func requestsInCells(cityID string, cells []string, attributes []string) ([]Request, error) {
+	svc, err := createCityClient(cityID)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := []Request{}
+	for _, cell := range cells {
+		input := &dynamodb.QueryInput{
+			TableName:              aws.String(RequestsTable),
+			IndexName:              aws.String(GeoCellIndex),
+			KeyConditionExpression: aws.String("geo_cell = :c"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":c": {
+					S: aws.String(cell),
+				},
+			},
+		}
+		if len(attributes) > 0 {
+			input.ExpressionAttributeNames = map[string]*string{}
+			input.ProjectionExpression = aws.String(projection(attributes, input.ExpressionAttributeNames))
+		}
+		filterCity(input, cityID)
+
+		err = svc.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+			items := []Request{}
+			err = dynamodbattribute.UnmarshalListOfMaps(page.Items, &items)
+			if err != nil {
+				return false
+			}
+			requests = append(requests, items...)
+			return true
+		})
+		if err != nil {
+			return nil, fmt.Errorf("repository: unable to get requests in geo cell %s. \n %s", cell, err)
+		}
+	}
+
+	return requests, nil
+}
+
+// ZipCodeIndex is the global secondary index of RequestsTable on zip_area, the five digits of the zipcode, sorted by
+// requested_datetime
+const ZipCodeIndex = "zipcode-index"
+
+// GetRequestsByZipCode returns the requests of a city in the five digit ZIP code of zipCode, newest first, ZIP+4
+// or not, with only the attributes given read, eg those ListingAttributes returns.  An empty cityID returns the
+// requests of every city.
+func GetRequestsByZipCode(cityID string, zipCode ZipCode, attributes []string) ([]Request, error) {
+	svc, err := createCityClient(cityID)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(RequestsTable),
+		IndexName:              aws.String(ZipCodeIndex),
+		KeyConditionExpression: aws.String("zip_area = :z"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":z": {
+				S: aws.String(zipCode.Area()),
+			},
+		},
+		ExpressionAttributeNames: map[string]*string{},
+		ScanIndexForward:         aws.Bool(false),
+	}
+	input.ProjectionExpression = aws.String(projection(attributes, input.ExpressionAttributeNames))
+	filterCity(input, cityID)
+
+	requests := []Request{}
+	err = svc.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		items := []Request{}
+		err = dynamodbattribute.UnmarshalListOfMaps(page.Items, &items)
+		if err != nil {
+			return false
+		}
+		requests = append(requests, items...)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("repository: unable to get requests in ZIP code %s. \n %s", zipCode, err)
+	}
+
+	return requests, nil
+}
+
+// BackfillZipCodes sets the ZIP code of stored requests that have a location but no ZIP code, using derive to
+// look it up, and returns how many requests were (or, for a dry run, would be) updated.  Requests whose ZIP
+// code can't be derived are left alone.  ZIP codes stored as numbers, before they were strings, are rewritten as
+// strings, and given the zip_area listings by ZIP code read.  Only zipcode and zip_area are written, so requests updated
+// concurrently are not clobbered.  Segments of the table are read in parallel, so derive is called from several
+// goroutines at once.
+func BackfillZipCodes(derive func(Request) (ZipCode, error), dryRun bool) (int, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return 0, err
+	}
+
+	var updated int64
+	err = scanEach(svc, &dynamodb.ScanInput{TableName: aws.String(RequestsTable)}, func(item map[string]*dynamodb.AttributeValue) error {
+		request := Request{}
+		err := dynamodbattribute.UnmarshalMap(item, &request)
+		if err != nil {
+			return err
+		}
+
+		if request.ZipCode != "" {
+			if stored := item["zipcode"]; stored.N == nil && request.ZipArea == request.ZipCode.Area() {
+				return nil
+			}
+			atomic.AddInt64(&updated, 1)
+			if dryRun {
+				return nil
+			}
+			return setZipCode(svc, request.ServiceRequestID, request.ZipCode)
+		}
+		if !request.HasLocation() {
+			return nil
+		}
+
+		if dryRun {
+			atomic.AddInt64(&updated, 1)
+			return nil
+		}
+
+		zipCode, err := derive(request)
+		if err != nil || zipCode == "" {
+			return nil
+		}
+
+		err = setZipCode(svc, request.ServiceRequestID, zipCode)
+		if err != nil {
+			return err
+		}
+		atomic.AddInt64(&updated, 1)
+		return nil
+	})
+	if err != nil {
+		return int(updated), fmt.Errorf("repository: unable to backfill ZIP codes. \n %s", err)
+	}
+	return int(updated), nil
+}
+
+// setZipCode writes only the ZIP code of a request, as a string, and the zip_area it is listed under
+func setZipCode(svc *dynamodb.DynamoDB, requestID string, zipCode ZipCode) error {
+	names := map[string]*string{"#Z": aws.String("zipcode")}
+	values := map[string]*dynamodb.AttributeValue{":z": {S: aws.String(string(zipCode))}}
+	update := "SET #Z = :z"
+	if area := zipCode.Area(); area != "" {
+		names["#A"] = aws.String("zip_area")
+		values[":a"] = &dynamodb.AttributeValue{S: aws.String(area)}
+		update += ", #A = :a"
+	}
+
+	_, err := svc.UpdateItem(&dynamodb.UpdateItemInput{
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		Key: map[string]*dynamodb.AttributeValue{
+			"service_request_id": {
+				S: aws.String(requestID),
+			},
+		},
+		TableName:        aws.String(RequestsTable),
+		UpdateExpression: aws.String(update),
+	})
+	if err != nil {
+		return fmt.Errorf("repository: failed to set ZIP code of request %s. \n  %s", requestID, err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// HealthTables are the tables the health check verifies.  The audit log and delivery logs are included, as writes
+// and notifications fail without them.
+var HealthTables = []string{
+	CitiesTable, ServicesTable, RequestsTable, UsersTable, FeedbackTable, OnboardingTable, MediaTable, CountersTable,
+	SubscriptionsTable, AgenciesTable, AssetsTable, ConnectionsTable, NotificationTemplatesTable,
+	NotificationDeliveriesTable, WebhooksTable, WebhookDeliveriesTable, AuditTable, PinsTable,
+}
+
+// CheckTable verifies a table exists, is serving, and can be reached with the deployment's credentials.  It reads
+// the table's description rather than any items, so it consumes no capacity.  Errors of DynamoDB are returned as
+// they are, so callers can tell a missing table from denied access by their code.
+func CheckTable(table string) error {
+	createClient := createDynamoClient
+	if table == CitiesTable {
+		createClient = createDirectoryClient
+	}
+	svc, err := createClient()
+	if err != nil {
+		return err
+	}
+
+	result, err := svc.DescribeTable(&dynamodb.DescribeTableInput{TableName: aws.String(table)})
+	if err != nil {
+		return err
+	}
+	// Tables being updated, eg adding an index, still serve reads and writes
+	status := aws.StringValue(result.Table.TableStatus)
+	if status != dynamodb.TableStatusActive && status != dynamodb.TableStatusUpdating {
+		return fmt.Errorf("repository: table %s is %s", table, status)
+	}
+	return nil
+}
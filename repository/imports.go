@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/oklog/ulid"
+)
+
+// ImportRequests stores requests imported from the 311 system a city used before, keeping their status and times,
+// BatchWriteSize at a time.  Their service names and agencies are set as for new requests, from services by
+// service_code.  A request's ID is derived from its ExternalID, so importing the same export again leaves the
+// requests already stored alone.  It returns how many requests were stored and how many were already.  On error,
+// the requests stored are those of the batches before, and importing again picks up where it stopped.
+func ImportRequests(requests []Request, services map[string]Service, city City) (int, int, error) {
+	svc, err := createCityClient(city.CityName)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	prepared := []Request{}
+	ids := []string{}
+	seen := map[string]bool{}
+	for _, request := range requests {
+		request, err := prepareImport(request, services[request.ServiceCode], city)
+		if err != nil {
+			return 0, 0, err
+		}
+		// A batch may not put the same item twice
+		if seen[request.ServiceRequestID] {
+			continue
+		}
+		seen[request.ServiceRequestID] = true
+		prepared = append(prepared, request)
+		ids = append(ids, request.ServiceRequestID)
+	}
+
+	stored, err := storedKeys(svc.BatchGetItem, RequestsTable, "service_request_id", ids)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	items := []map[string]*dynamodb.AttributeValue{}
+	for _, request := range prepared {
+		if stored[request.ServiceRequestID] {
+			continue
+		}
+		av, err := dynamodbattribute.MarshalMap(request)
+		if err != nil {
+			return 0, 0, fmt.Errorf("repository: Failed to marshal request:\n %+v. \n  %s", request, err)
+		}
+		items = append(items, av)
+	}
+
+	written, err := batchWrite(svc.BatchWriteItem, RequestsTable, items)
+	skipped := len(requests) - len(items)
+	if err != nil {
+		return written, skipped, fmt.Errorf("repository: failed to put imported requests in database \n %s", err)
+	}
+	return written, skipped, nil
+}
+
+// prepareImport returns an imported request as it is stored, with its ID, service name, agency and the attributes
+// derived at write time set
+func prepareImport(request Request, service Service, city City) (Request, error) {
+	if request.ExternalID == "" {
+		return request, fmt.Errorf("repository: imported requests need an external_id")
+	}
+	requested, err := time.Parse(time.RFC3339, request.RequestedDateTime)
+	if err != nil {
+		return request, fmt.Errorf("repository: imported request %s has no requested_datetime \n %s", request.ExternalID, err)
+	}
+
+	request.ServiceRequestID, err = importedRequestID(request.ExternalID, requested)
+	if err != nil {
+		return request, err
+	}
+	request.ServiceName = service.ServiceName
+	setGeohash(&request)
+	err = assignAgency(&request, service, city.Routing)
+	if err != nil {
+		return request, err
+	}
+	setQueue(&request)
+	setZipArea(&request)
+	request.SchemaVersion = requestSchemaVersion
+
+	if request.Status == RequestClosed && request.ClosedDateTime != "" {
+		closed, err := time.Parse(time.RFC3339, request.ClosedDateTime)
+		if err == nil {
+			request.ResolutionHours = math.Round(closed.Sub(requested).Hours()*100) / 100
+		}
+	} else {
+		request.ClosedDateTime = ""
+	}
+	return request, nil
+}
+
+// importedRequestID returns the ID of an imported request: a ULID of the time it was made, so it sorts among the
+// city's other requests, whose entropy is the hash of its ExternalID
+func importedRequestID(externalID string, requested time.Time) (string, error) {
+	hash := sha256.Sum256([]byte(externalID))
+	id, err := ulid.New(ulid.Timestamp(requested), bytes.NewReader(hash[:]))
+	if err != nil {
+		return "", fmt.Errorf("\n repository: Unable to generate request id:\n  %s", err)
+	}
+	return "SR-" + id.String(), nil
+}
@@ -0,0 +1,24 @@
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestImportedRequestID(t *testing.T) {
+	june := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	first, err := importedRequestID("seeclickfix:1234567", june)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := importedRequestID("seeclickfix:1234567", june)
+	if first != again {
+		t.Errorf("importedRequestID() of the same request = %s then %s, want the same ID", first, again)
+	}
+	if other, _ := importedRequestID("seeclickfix:1234568", june); other == first {
+		t.Errorf("importedRequestID() of different requests = %s for both", first)
+	}
+	if later, _ := importedRequestID("seeclickfix:1", june.Add(time.Hour)); later <= first {
+		t.Errorf("importedRequestID() of a later request = %s, want it after %s", later, first)
+	}
+}
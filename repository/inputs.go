@@ -0,0 +1,132 @@
+package repository
+
+import "encoding/json"
+
+// SubmitRequestInput is what a client may send to submit a request.  Its ID, status, times, agency and the rest are
+// assigned by SubmitRequest, so a client can't, for instance, submit a request already closed.
+type SubmitRequestInput struct {
+	CityID      string           `json:"city_id,omitempty"`
+	ServiceCode string           `json:"service_code"`
+	Description string           `json:"description"`
+	Address     string           `json:"address"`
+	AddressID   string           `json:"address_id"`
+	ZipCode     ZipCode          `json:"zipcode"`
+	Location                     // lat and lon using the (WGS84) projection.
+	Geometry    *Geometry        `json:"geometry,omitempty"`
+	AssetID     string           `json:"asset_id"`
+	MediaURL    string           `json:"media_url"`
+	Values      []AttributeValue `json:"values"`
+}
+
+// Request returns the request submitted, with only the fields a client may set
+func (in SubmitRequestInput) Request() Request {
+	return Request{
+		CityID:      in.CityID,
+		ServiceCode: in.ServiceCode,
+		Description: in.Description,
+		Address:     in.Address,
+		AddressID:   in.AddressID,
+		ZipCode:     in.ZipCode,
+		Location:    in.Location,
+		Geometry:    in.Geometry,
+		AssetID:     in.AssetID,
+		MediaURL:    in.MediaURL,
+		Values:      in.Values,
+	}
+}
+
+// UpdateRequestInput is what a client may send to update the request with its service_request_id.  Updates change
+// the fields sent and keep the others, so a client that leaves out the description or media_url doesn't erase them.
+// Nil fields, left out or null, are kept, and an empty string clears a field; a geometry, which has no empty value,
+// is cleared by sending it as null.  Fields a client may not set, such as
+// when the request was made, its city, service and agency, its audit log and comments, are always kept, as is the
+// expected_datetime derived from the service's SLA.
+type UpdateRequestInput struct {
+	ServiceRequestID string `json:"service_request_id"`
+	StaffUpdateInput
+	Description *string           `json:"description"`
+	Address     *string           `json:"address"`
+	AddressID   *string           `json:"address_id"`
+	ZipCode     *ZipCode          `json:"zipcode"`
+	Latitude    *Coordinate       `json:"lat"`
+	Longitude   *Coordinate       `json:"lon"`
+	Geometry    GeometryUpdate    `json:"geometry"`
+	AssetID     *string           `json:"asset_id"`
+	MediaURL    *string           `json:"media_url"`
+	Values      *[]AttributeValue `json:"values"`
+}
+
+// StaffUpdateInput is the part of an update only the admins of the request's city may send: how the request is
+// being handled, and by whom
+type StaffUpdateInput struct {
+	Status            *string `json:"status"`
+	StatusNotes       *string `json:"status_notes"`
+	ServiceNotice     *string `json:"service_notice"`
+	ScheduledDateTime *string `json:"scheduled_datetime,omitempty"`
+	AssignedTo        *string `json:"assigned_to,omitempty"`
+}
+
+// Sent reports whether an update sets any of the fields only staff may set
+func (in StaffUpdateInput) Sent() bool {
+	return in != StaffUpdateInput{}
+}
+
+// Apply returns a stored request updated with the fields a client sent
+func (in UpdateRequestInput) Apply(stored Request) Request {
+	stored.ServiceRequestID = in.ServiceRequestID
+	setString(&stored.Status, in.Status)
+	setString(&stored.StatusNotes, in.StatusNotes)
+	setString(&stored.ServiceNotice, in.ServiceNotice)
+	setString(&stored.Description, in.Description)
+	setString(&stored.Address, in.Address)
+	setString(&stored.AddressID, in.AddressID)
+	if in.ZipCode != nil {
+		stored.ZipCode = *in.ZipCode
+	}
+	if in.Latitude != nil {
+		stored.Latitude = *in.Latitude
+	}
+	if in.Longitude != nil {
+		stored.Longitude = *in.Longitude
+	}
+	if in.Geometry.Sent {
+		stored.Geometry = in.Geometry.Geometry
+	}
+	// The label of another asset doesn't follow the request to the asset it is now about
+	if in.AssetID != nil && *in.AssetID != stored.AssetID {
+		stored.AssetID = *in.AssetID
+		stored.AssetLabel = ""
+	}
+	setString(&stored.MediaURL, in.MediaURL)
+	setString(&stored.ScheduledDateTime, in.ScheduledDateTime)
+	setString(&stored.AssignedTo, in.AssignedTo)
+	if in.Values != nil {
+		stored.Values = *in.Values
+	}
+	return stored
+}
+
+// GeometryUpdate is the geometry field of an update, which tells a geometry left out, and kept, from one sent as
+// null, which clears it
+type GeometryUpdate struct {
+	Sent     bool      // Whether the update has a geometry field, null or not
+	Geometry *Geometry // The geometry sent, nil for null
+}
+
+// UnmarshalJSON reads the geometry of an update, which is only called when the update has one
+func (g *GeometryUpdate) UnmarshalJSON(b []byte) error {
+	g.Sent = true
+	g.Geometry = nil
+	if string(b) == "null" {
+		return nil
+	}
+	g.Geometry = &Geometry{}
+	return json.Unmarshal(b, g.Geometry)
+}
+
+// setString sets a field to the value a client sent, keeping it when none was
+func setString(field *string, value *string) {
+	if value != nil {
+		*field = *value
+	}
+}
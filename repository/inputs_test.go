@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Fields the platform manages are dropped from what clients send
+func TestSubmitRequestInput(t *testing.T) {
+	body := `{"service_code": "pothole", "description": "Deep pothole", "lat": 42.7, "lon": -73.7, "status": "closed",
+		"requested_datetime": "2001-01-01T00:00:00Z", "agency_responsible": "parks", "service_request_id": "SR-1"}`
+	input := SubmitRequestInput{}
+	if err := json.Unmarshal([]byte(body), &input); err != nil {
+		t.Fatal(err)
+	}
+
+	request := input.Request()
+	if request.ServiceCode != "pothole" || request.Description != "Deep pothole" || request.Latitude != 42.7 {
+		t.Errorf("Request() = %+v, want the fields submitted", request)
+	}
+	if request.Status != "" || request.RequestedDateTime != "" || request.AgencyResponsible != "" || request.ServiceRequestID != "" {
+		t.Errorf("Request() = %+v, want no status, time, agency or ID", request)
+	}
+}
+
+func TestUpdateRequestInput(t *testing.T) {
+	stored := Request{
+		ServiceRequestID:  "SR-1",
+		CityID:            "Troy",
+		Status:            RequestOpen,
+		ServiceCode:       "pothole",
+		Description:       "Deep pothole",
+		MediaURL:          "https://example.com/pothole.jpg",
+		Location:          Location{Latitude: 42.7, Longitude: -73.7},
+		Values:            []AttributeValue{{Key: "depth", Name: "Deep"}},
+		RequestedDateTime: "2019-06-01T08:00:00Z",
+		ExpectedDateTime:  "2019-06-03T08:00:00Z",
+		AgencyResponsible: "streets",
+		AssetID:           "light-4471",
+		AssetLabel:        "Streetlight #4471",
+		AuditLog:          []AuditEntry{{ChangeNote: "Created"}},
+	}
+	body := `{"service_request_id": "SR-1", "status": "closed", "status_notes": "Filled", "city_id": "Albany",
+		"requested_datetime": "2001-01-01T00:00:00Z", "expected_datetime": "2001-01-02T00:00:00Z", "agency_responsible": "parks", "audit_log": []}`
+	input := UpdateRequestInput{}
+	if err := json.Unmarshal([]byte(body), &input); err != nil {
+		t.Fatal(err)
+	}
+
+	request := input.Apply(stored)
+	if request.Status != RequestClosed || request.StatusNotes != "Filled" {
+		t.Errorf("Apply() = %+v, want the status updated", request)
+	}
+	if request.CityID != "Troy" || request.RequestedDateTime != stored.RequestedDateTime || request.ExpectedDateTime != stored.ExpectedDateTime ||
+		request.AgencyResponsible != "streets" || len(request.AuditLog) != 1 {
+		t.Errorf("Apply() = %+v, want the city, times, agency and audit log kept", request)
+	}
+	if !input.StaffUpdateInput.Sent() {
+		t.Error("StaffUpdateInput.Sent() of a status update = false, want true")
+	}
+
+	// Fields left out or null are kept rather than erased
+	if request.Description != stored.Description || request.MediaURL != stored.MediaURL || request.Location != stored.Location ||
+		len(request.Values) != 1 || request.AssetLabel != stored.AssetLabel {
+		t.Errorf("Apply() = %+v, want the fields left out kept", request)
+	}
+
+	// Fields sent empty are cleared, and a new asset drops the label of the old one
+	body = `{"service_request_id": "SR-1", "media_url": "", "description": null, "values": [], "asset_id": "light-4472"}`
+	input = UpdateRequestInput{}
+	if err := json.Unmarshal([]byte(body), &input); err != nil {
+		t.Fatal(err)
+	}
+	if input.StaffUpdateInput.Sent() {
+		t.Error("StaffUpdateInput.Sent() of a resident's correction = true, want false")
+	}
+	request = input.Apply(stored)
+	if request.MediaURL != "" || len(request.Values) != 0 || request.Description != stored.Description {
+		t.Errorf("Apply() = %+v, want media and values cleared and the description kept", request)
+	}
+	if request.AssetID != "light-4472" || request.AssetLabel != "" {
+		t.Errorf("Apply() = %+v, want the new asset without the old label", request)
+	}
+
+	// A geometry left out is kept, one sent replaces it, and null clears it
+	stored.Geometry = &Geometry{Type: LineStringGeometry, Line: [][]float64{{-73.7, 42.7}, {-73.71, 42.71}}}
+	tests := map[string]*Geometry{
+		`{"service_request_id": "SR-1"}`: stored.Geometry,
+		`{"service_request_id": "SR-1", "geometry": {"type": "LineString", "coordinates": [[-73.7, 42.7], [-73.8, 42.8]]}}`: {Type: LineStringGeometry, Line: [][]float64{{-73.7, 42.7}, {-73.8, 42.8}}},
+		`{"service_request_id": "SR-1", "geometry": null}`:                                                                  nil,
+	}
+	for body, want := range tests {
+		input = UpdateRequestInput{}
+		if err := json.Unmarshal([]byte(body), &input); err != nil {
+			t.Fatal(err)
+		}
+		got := input.Apply(stored).Geometry
+		if (got == nil) != (want == nil) || got != nil && (len(got.Line) != 2 || got.Line[1][0] != want.Line[1][0]) {
+			t.Errorf("Apply() of %s = geometry %+v, want %+v", body, got, want)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Coordinate is a latitude or longitude in decimal degrees.  Some clients send coordinates as strings, as
+// GeoReport v2 form posts do, and requests stored by early versions may hold them as strings; both are accepted.
+type Coordinate float64
+
+// UnmarshalJSON accepts a coordinate as a JSON number or string.  null and "" are 0.
+func (c *Coordinate) UnmarshalJSON(b []byte) error {
+	s := strings.TrimSpace(string(b))
+	if s == "null" {
+		*c = 0
+		return nil
+	}
+
+	if strings.HasPrefix(s, `"`) {
+		err := json.Unmarshal(b, &s)
+		if err != nil {
+			return err
+		}
+	}
+	return c.parse(s)
+}
+
+// UnmarshalDynamoDBAttributeValue accepts a coordinate stored as a number or a string
+func (c *Coordinate) UnmarshalDynamoDBAttributeValue(av *dynamodb.AttributeValue) error {
+	switch {
+	case av.N != nil:
+		return c.parse(*av.N)
+	case av.S != nil:
+		return c.parse(*av.S)
+	}
+	*c = 0
+	return nil
+}
+
+func (c *Coordinate) parse(s string) error {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		*c = 0
+		return nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Errorf("repository: invalid coordinate '%s'", s)
+	}
+	*c = Coordinate(f)
+	return nil
+}
+
+// Location is a point in WGS84.  It is embedded in the types it locates, so its lat and lon sit at the top level
+// of their JSON and items as GeoReport v2 expects.  The zero Location means no location was given.
+type Location struct {
+	Latitude  Coordinate `json:"lat"` // latitude using the (WGS84) projection.
+	Longitude Coordinate `json:"lon"` // longitude using the (WGS84) projection.
+}
+
+type InvalidLocationErr struct {
+	message string
+}
+
+func (e *InvalidLocationErr) Error() string {
+	return e.message
+}
+
+// NewLocation returns the Location of a point.  If the point is out of range or is 0,0, an InvalidLocationErr
+// error is set
+func NewLocation(lat float64, lon float64) (Location, error) {
+	l := Location{Latitude: Coordinate(lat), Longitude: Coordinate(lon)}
+	if !l.HasLocation() {
+		return Location{}, &InvalidLocationErr{"location 0,0 is not a real location"}
+	}
+	return l, l.ValidateLocation()
+}
+
+// HasLocation reports whether a location was given.  0,0 is in the sea off Africa, and is what clients send when
+// they have no fix.
+func (l Location) HasLocation() bool {
+	return l.Latitude != 0 || l.Longitude != 0
+}
+
+// ValidateLocation checks that the coordinates of a location are in range.  If they are not, an
+// InvalidLocationErr error is set
+func (l Location) ValidateLocation() error {
+	if l.Latitude < -90 || l.Latitude > 90 {
+		return &InvalidLocationErr{fmt.Sprintf("lat %f must be between -90 and 90", l.Latitude)}
+	}
+	if l.Longitude < -180 || l.Longitude > 180 {
+		return &InvalidLocationErr{fmt.Sprintf("lon %f must be between -180 and 180", l.Longitude)}
+	}
+	return nil
+}
+
+// Coordinates returns the latitude and longitude of a location
+func (l Location) Coordinates() (float64, float64) {
+	return float64(l.Latitude), float64(l.Longitude)
+}
+
+// Geometry is the extent of an issue larger than a point, eg a stretch of cracked sidewalk as a GeoJSON
+// LineString or a flooded block as a GeoJSON Polygon.  It reads and writes GeoJSON, and is stored as whichever
+// of line or polygon its type uses.
+type Geometry struct {
+	Type    string        `json:"type"`
+	Line    [][]float64   `json:"-" dynamodbav:"line,omitempty"`    // LineString positions, [lon, lat]
+	Polygon [][][]float64 `json:"-" dynamodbav:"polygon,omitempty"` // Polygon rings of [lon, lat] positions
+}
+
+// GeoJSON geometry types a Geometry may have
+const (
+	LineStringGeometry = "LineString"
+	PolygonGeometry    = "Polygon"
+)
+
+// maxGeometryPositions bounds the size of a geometry, keeping requests well within DynamoDB's item size
+const maxGeometryPositions = 1000
+
+// geoJSONGeometry is the GeoJSON form of a Geometry
+type geoJSONGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// MarshalJSON writes a geometry as a GeoJSON geometry object
+func (g Geometry) MarshalJSON() ([]byte, error) {
+	var coordinates interface{} = g.Line
+	if g.Type == PolygonGeometry {
+		coordinates = g.Polygon
+	}
+
+	c, err := json.Marshal(coordinates)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(geoJSONGeometry{Type: g.Type, Coordinates: c})
+}
+
+// UnmarshalJSON reads a GeoJSON LineString or Polygon geometry object
+func (g *Geometry) UnmarshalJSON(b []byte) error {
+	var raw geoJSONGeometry
+	err := json.Unmarshal(b, &raw)
+	if err != nil {
+		return err
+	}
+
+	*g = Geometry{Type: raw.Type}
+	switch raw.Type {
+	case LineStringGeometry:
+		return json.Unmarshal(raw.Coordinates, &g.Line)
+	case PolygonGeometry:
+		return json.Unmarshal(raw.Coordinates, &g.Polygon)
+	}
+	return fmt.Errorf("repository: geometry type must be %s or %s", LineStringGeometry, PolygonGeometry)
+}
+
+// Validate checks that a geometry is a usable LineString or Polygon.  If it is not, an InvalidLocationErr error
+// is set
+func (g Geometry) Validate() error {
+	rings := g.Polygon
+	if g.Type == LineStringGeometry {
+		if len(g.Line) < 2 {
+			return &InvalidLocationErr{"a LineString needs at least 2 positions"}
+		}
+		rings = [][][]float64{g.Line}
+	} else if g.Type != PolygonGeometry {
+		return &InvalidLocationErr{fmt.Sprintf("geometry type must be %s or %s", LineStringGeometry, PolygonGeometry)}
+	}
+
+	positions := 0
+	for _, ring := range rings {
+		if g.Type == PolygonGeometry {
+			if len(ring) < 4 {
+				return &InvalidLocationErr{"each Polygon ring needs at least 4 positions"}
+			}
+			first, last := ring[0], ring[len(ring)-1]
+			if len(first) < 2 || len(last) < 2 || first[0] != last[0] || first[1] != last[1] {
+				return &InvalidLocationErr{"each Polygon ring must end where it starts"}
+			}
+		}
+
+		for _, position := range ring {
+			if len(position) < 2 {
+				return &InvalidLocationErr{"positions must be [lon, lat]"}
+			}
+			l := Location{Latitude: Coordinate(position[1]), Longitude: Coordinate(position[0])}
+			if err := l.ValidateLocation(); err != nil {
+				return err
+			}
+		}
+		positions += len(ring)
+	}
+
+	if len(rings) == 0 {
+		return &InvalidLocationErr{"a Polygon needs an outer ring"}
+	}
+	if positions > maxGeometryPositions {
+		return &InvalidLocationErr{fmt.Sprintf("geometry may have at most %d positions", maxGeometryPositions)}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestCoordinateUnmarshalJSON(t *testing.T) {
+	var request Request
+	err := json.Unmarshal([]byte(`{"service_request_id": "1", "lat": 42.728412345678, "lon": "-73.6918"}`), &request)
+	if err != nil || request.Latitude != 42.728412345678 || request.Longitude != -73.6918 {
+		t.Errorf("Unmarshal() = %+v, %v, want lat 42.728412345678 and lon -73.6918", request.Location, err)
+	}
+
+	for _, body := range []string{`{"lat": null, "lon": ""}`, `{}`} {
+		request = Request{}
+		if err := json.Unmarshal([]byte(body), &request); err != nil || request.HasLocation() {
+			t.Errorf("Unmarshal(%s) = %+v, %v, want no location", body, request.Location, err)
+		}
+	}
+
+	for _, body := range []string{`{"lat": "north"}`, `{"lat": "NaN"}`, `{"lat": true}`} {
+		if err := json.Unmarshal([]byte(body), &request); err == nil {
+			t.Errorf("Unmarshal(%s) should fail", body)
+		}
+	}
+}
+
+func TestCoordinateUnmarshalDynamoDB(t *testing.T) {
+	tests := []struct {
+		av   *dynamodb.AttributeValue
+		want Coordinate
+	}{
+		{&dynamodb.AttributeValue{N: aws.String("42.7284")}, 42.7284},
+		{&dynamodb.AttributeValue{S: aws.String("-73.6918")}, -73.6918},
+		{&dynamodb.AttributeValue{NULL: aws.Bool(true)}, 0},
+	}
+
+	for _, tt := range tests {
+		var c Coordinate
+		if err := c.UnmarshalDynamoDBAttributeValue(tt.av); err != nil || c != tt.want {
+			t.Errorf("UnmarshalDynamoDBAttributeValue(%v) = %v, %v, want %v", tt.av, c, err, tt.want)
+		}
+	}
+}
+
+func TestNewLocation(t *testing.T) {
+	if _, err := NewLocation(42.7284, -73.6918); err != nil {
+		t.Errorf("NewLocation(42.7284, -73.6918) = %v", err)
+	}
+
+	for _, point := range [][2]float64{{0, 0}, {91, 0}, {0, -181}} {
+		if _, err := NewLocation(point[0], point[1]); err == nil {
+			t.Errorf("NewLocation(%f, %f) should fail", point[0], point[1])
+		}
+	}
+}
+
+func TestGeometryJSON(t *testing.T) {
+	body := `{"type":"Polygon","coordinates":[[[-73.69,42.72],[-73.68,42.72],[-73.68,42.73],[-73.69,42.72]]]}`
+	var g Geometry
+	err := json.Unmarshal([]byte(body), &g)
+	if err != nil || g.Type != PolygonGeometry || len(g.Polygon) != 1 || len(g.Polygon[0]) != 4 || g.Line != nil {
+		t.Fatalf("Unmarshal(%s) = %+v, %v", body, g, err)
+	}
+	if err := g.Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+
+	out, err := json.Marshal(g)
+	if err != nil || string(out) != body {
+		t.Errorf("Marshal() = %s, %v, want %s", out, err, body)
+	}
+
+	if err := json.Unmarshal([]byte(`{"type":"Point","coordinates":[-73.69,42.72]}`), &g); err == nil {
+		t.Error("Unmarshal() of a Point should fail")
+	}
+}
+
+func TestGeometryValidate(t *testing.T) {
+	invalid := []Geometry{
+		{Type: LineStringGeometry, Line: [][]float64{{-73.69, 42.72}}},
+		{Type: LineStringGeometry, Line: [][]float64{{-73.69, 42.72}, {-73.68, 95}}},
+		{Type: PolygonGeometry},
+		{Type: PolygonGeometry, Polygon: [][][]float64{{{-73.69, 42.72}, {-73.68, 42.72}, {-73.68, 42.73}, {-73.69, 42.73}}}},
+		{Type: "Point"},
+	}
+
+	for _, g := range invalid {
+		if err := g.Validate(); err == nil {
+			t.Errorf("Validate(%+v) should fail", g)
+		}
+	}
+
+	line := Geometry{Type: LineStringGeometry, Line: [][]float64{{-73.69, 42.72}, {-73.68, 42.72}}}
+	if err := line.Validate(); err != nil {
+		t.Errorf("Validate(%+v) = %v", line, err)
+	}
+}
@@ -0,0 +1,332 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// Media describes a file (image or video) attached to a request.  Records are registered when an upload URL is
+// issued and completed by the media pipeline once the object lands in S3.
+type Media struct {
+	Key              string         `json:"media_key"`             // S3 object key of the original upload
+	City             string         `json:"city"`                  // City whose prefix (and possibly bucket) holds the media
+	ServiceRequestID string         `json:"service_request_id"`    // The request the media is attached to
+	AccountID        string         `json:"account_id"`            // Unique ID for the user account of the uploader
+	ContentType      string         `json:"content_type"`          // MIME type reported by S3
+	Size             int64          `json:"size"`                  // Size of the original upload in bytes
+	Width            int            `json:"width"`                 // Width in pixels, when known
+	Height           int            `json:"height"`                // Height in pixels, when known
+	ModerationStatus string         `json:"moderation_status"`     // One of the Moderation* constants
+	ReviewedBy       string         `json:"reviewed_by,omitempty"` // Account of the city admin who set the moderation status, "" until reviewed
+	StorageStatus    string         `json:"storage_status"`        // One of the Media* storage constants
+	Variants         []MediaVariant `json:"variants"`              // Derived renditions, eg a transcoded video and its poster frame
+	Timestamp        string         `json:"timestamp"`             // RFC3339 formatted time the media was registered
+}
+
+// constants to define where a media object is in its retention lifecycle
+const (
+	MediaActive   = "active"   // stored in standard storage and can be fetched
+	MediaArchived = "archived" // transitioned to Glacier and must be restored before it can be fetched
+	MediaDeleted  = "deleted"  // removed according to the city's retention policy
+)
+
+// ModerationGracePeriod is how long media pending moderation is shown to residents, so their own upload appears
+// while the pipeline is still at it.  Media still pending afterwards, eg video that isn't analyzed, waits for a city
+// admin to review it with ReviewMedia.
+const ModerationGracePeriod = time.Hour
+
+// Viewable reports whether media may be served, to city staff or to anyone else.  Rejected media is never served;
+// media flagged for review, and media pending past the ModerationGracePeriod of its registration, only to staff.
+func (m Media) Viewable(staff bool, now time.Time) bool {
+	switch m.ModerationStatus {
+	case ModerationRejected:
+		return false
+	case ModerationApproved:
+		return true
+	case ModerationPending:
+		registered, err := time.Parse(time.RFC3339, m.Timestamp)
+		return staff || err == nil && now.Sub(registered) < ModerationGracePeriod
+	}
+	return staff
+}
+
+// MediaRequestIndex is the global secondary index of the Media table keyed by service_request_id
+const MediaRequestIndex = "service_request_id-index"
+
+// MediaVariant is a rendition derived from an original upload
+type MediaVariant struct {
+	Name string `json:"name"` // eg "rendition", "poster", "thumbnail"
+	Key  string `json:"key"`  // S3 object key of the variant
+}
+
+type MediaNotFoundErr struct {
+	message string
+}
+
+func (e *MediaNotFoundErr) Error() string {
+	return e.message
+}
+
+type MediaConflictErr struct {
+	message string
+}
+
+func (e *MediaConflictErr) Error() string {
+	return e.message
+}
+
+// mediaClient returns a DynamoDB client of the tables holding the media record of key.  Keys are namespaced by the
+// city whose media they are, so the city is read from the key's prefix; keys stored before namespacing have none and
+// are in the deployment's tables.
+func mediaClient(key string) (*dynamodb.DynamoDB, error) {
+	return createCityClient(mediaCity(key))
+}
+
+// mediaCity returns the city namespacing a media key, or "" for un-namespaced keys
+func mediaCity(key string) string {
+	if i := strings.Index(key, "/"); i > 0 {
+		return key[:i]
+	}
+	return ""
+}
+
+// RegisterMedia records a pending upload before the client stores the object in S3.  A key is registered once, so
+// an upload can't reset the moderation of media already stored, nor move it to another request or account; if
+// the key is registered, a MediaConflictErr error is set.  If the request the media is for isn't stored, a
+// RequestIdNotFoundErr error is set.
+func RegisterMedia(media Media) error {
+	svc, err := mediaClient(media.Key)
+	if err != nil {
+		return err
+	}
+
+	if media.ServiceRequestID != "" {
+		_, err = getRequest(svc, media.ServiceRequestID)
+		if err != nil {
+			return err
+		}
+	}
+
+	media.ModerationStatus = ModerationPending
+	media.StorageStatus = MediaActive
+	media.Timestamp = time.Now().Format(time.RFC3339)
+
+	av, err := dynamodbattribute.MarshalMap(media)
+	if err != nil {
+		return fmt.Errorf("repository: Failed to marshal media:\n %+v. \n  %s", media, err)
+	}
+
+	input := &dynamodb.PutItemInput{
+		Item:                av,
+		TableName:           aws.String(MediaTable),
+		ConditionExpression: aws.String("attribute_not_exists(media_key)"),
+	}
+
+	_, err = svc.PutItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return &MediaConflictErr{fmt.Sprintf("media %s is already registered", media.Key)}
+	}
+	if err != nil {
+		return fmt.Errorf("repository: failed to put new media in database: \n input: %+v. \n %s", input, err)
+	}
+
+	return nil
+}
+
+// GetMedia takes an S3 object key and returns the corresponding Media record.
+// If the key has not been registered, a MediaNotFoundErr error is set
+func GetMedia(key string) (Media, error) {
+	svc, err := mediaClient(key)
+	if err != nil {
+		return Media{}, err
+	}
+
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(MediaTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"media_key": {
+				S: aws.String(key),
+			},
+		},
+	}
+
+	result, err := svc.GetItem(input)
+	if err != nil {
+		return Media{}, fmt.Errorf("\n repository: unable to get specified media from database with the following input: \n  %+v. \n   %s", input, err)
+	}
+
+	media := Media{}
+
+	err = dynamodbattribute.UnmarshalMap(result.Item, &media)
+	if err != nil {
+		return media, fmt.Errorf("\n repository: Failed to unmarshal media record from database: \n  %+v. \n   %s", result.Item, err)
+	}
+
+	if media.Key == "" {
+		return media, &MediaNotFoundErr{"media not found"}
+	}
+
+	return media, err
+}
+
+// GetRequestMedia returns all media attached to a request of a city
+func GetRequestMedia(cityID string, requestID string) ([]Media, error) {
+	svc, err := createCityClient(cityID)
+	if err != nil {
+		return []Media{}, err
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(MediaTable),
+		IndexName:              aws.String(MediaRequestIndex),
+		KeyConditionExpression: aws.String("service_request_id = :r"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":r": {
+				S: aws.String(requestID),
+			},
+		},
+	}
+
+	items := []map[string]*dynamodb.AttributeValue{}
+	err = svc.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		items = append(items, page.Items...)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("repository: unable to get media for request from database with the following input: %+v. \n %s", input, err)
+	}
+
+	media := []Media{}
+	err = dynamodbattribute.UnmarshalListOfMaps(items, &media)
+	if err != nil {
+		return media, fmt.Errorf("repository: Failed to unmarshal media records: %+v. \n %s", items, err)
+	}
+
+	return media, nil
+}
+
+// SetMediaStorageStatus records a retention lifecycle transition of a media record
+func SetMediaStorageStatus(key string, status string) error {
+	return updateMedia(key,
+		"SET #SS = :ss",
+		map[string]*string{
+			"#SS": aws.String("storage_status"),
+		},
+		map[string]*dynamodb.AttributeValue{
+			":ss": {S: aws.String(status)},
+		})
+}
+
+// RecordMediaUpload fills in what the media pipeline learned about a stored object.  The moderation status is only
+// set on media no city admin has reviewed, so rewriting an object doesn't undo their decision.
+// Objects that were never registered (eg transcoder output) are reported with a MediaNotFoundErr error
+func RecordMediaUpload(key string, contentType string, size int64, width int, height int, moderationStatus string) error {
+	// size is a DynamoDB reserved word, so every attribute is referenced by placeholder name
+	err := updateMedia(key,
+		"SET #CT = :ct, #S = :s, #W = :w, #H = :h",
+		map[string]*string{
+			"#CT": aws.String("content_type"),
+			"#S":  aws.String("size"),
+			"#W":  aws.String("width"),
+			"#H":  aws.String("height"),
+		},
+		map[string]*dynamodb.AttributeValue{
+			":ct": {S: aws.String(contentType)},
+			":s":  {N: aws.String(fmt.Sprint(size))},
+			":w":  {N: aws.String(fmt.Sprint(width))},
+			":h":  {N: aws.String(fmt.Sprint(height))},
+		})
+	if err != nil {
+		return err
+	}
+
+	err = updateMediaWhere(key, "attribute_exists(media_key) AND attribute_not_exists(reviewed_by)",
+		"SET #MS = :ms",
+		map[string]*string{
+			"#MS": aws.String("moderation_status"),
+		},
+		map[string]*dynamodb.AttributeValue{
+			":ms": {S: aws.String(moderationStatus)},
+		})
+	if _, ok := err.(*MediaNotFoundErr); ok {
+		return nil
+	}
+	return err
+}
+
+// ReviewMedia records a city admin's moderation decision on media, which the media pipeline then keeps.
+// If the media isn't registered, a MediaNotFoundErr error is set
+func ReviewMedia(key string, moderationStatus string, reviewer string) error {
+	return updateMedia(key,
+		"SET #MS = :ms, #RB = :rb",
+		map[string]*string{
+			"#MS": aws.String("moderation_status"),
+			"#RB": aws.String("reviewed_by"),
+		},
+		map[string]*dynamodb.AttributeValue{
+			":ms": {S: aws.String(moderationStatus)},
+			":rb": {S: aws.String(reviewer)},
+		})
+}
+
+// AddMediaVariants appends derived renditions to a registered media record
+func AddMediaVariants(key string, variants []MediaVariant) error {
+	av, err := dynamodbattribute.MarshalList(variants)
+	if err != nil {
+		return fmt.Errorf("repository: Failed to marshal media variants:\n %+v. \n  %s", variants, err)
+	}
+
+	return updateMedia(key,
+		"SET #V = list_append(if_not_exists(#V, :empty_list), :v)",
+		map[string]*string{
+			"#V": aws.String("variants"),
+		},
+		map[string]*dynamodb.AttributeValue{
+			":v":          {L: av},
+			":empty_list": {L: []*dynamodb.AttributeValue{}},
+		})
+}
+
+// updateMedia applies an update expression to an existing media record.  Unlike trackUserRequest,
+// UpdateItem is not allowed to create the item.
+func updateMedia(key string, expression string, names map[string]*string, values map[string]*dynamodb.AttributeValue) error {
+	return updateMediaWhere(key, "attribute_exists(media_key)", expression, names, values)
+}
+
+// updateMediaWhere applies an update expression to a media record meeting condition.  A record that doesn't is
+// reported with a MediaNotFoundErr error.
+func updateMediaWhere(key string, condition string, expression string, names map[string]*string, values map[string]*dynamodb.AttributeValue) error {
+	svc, err := mediaClient(key)
+	if err != nil {
+		return err
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		Key: map[string]*dynamodb.AttributeValue{
+			"media_key": {
+				S: aws.String(key),
+			},
+		},
+		ConditionExpression: aws.String(condition),
+		TableName:           aws.String(MediaTable),
+		UpdateExpression:    aws.String(expression),
+	}
+
+	_, err = svc.UpdateItem(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return &MediaNotFoundErr{"media not found"}
+		}
+		return fmt.Errorf("repository: failed to update media %s. \n  %s", key, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMediaViewable(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	recent := now.Add(-ModerationGracePeriod / 2).Format(time.RFC3339)
+	old := now.Add(-2 * ModerationGracePeriod).Format(time.RFC3339)
+
+	tests := []struct {
+		status    string
+		timestamp string
+		staff     bool
+		want      bool
+	}{
+		{ModerationApproved, old, false, true},
+		{ModerationApproved, old, true, true},
+		{ModerationRejected, recent, false, false},
+		{ModerationRejected, recent, true, false},
+		{ModerationFlagged, recent, false, false},
+		{ModerationFlagged, recent, true, true},
+		{ModerationPending, recent, false, true},
+		{ModerationPending, old, false, false},
+		{ModerationPending, old, true, true},
+		{ModerationPending, "", false, false},
+		{"", recent, false, false},
+		{"", recent, true, true},
+	}
+
+	for _, test := range tests {
+		m := Media{ModerationStatus: test.status, Timestamp: test.timestamp}
+		if got := m.Viewable(test.staff, now); got != test.want {
+			t.Errorf("Media{%q, %q}.Viewable(%v) = %v, want %v", test.status, test.timestamp, test.staff, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/social-torch/open311-services/geo"
+)
+
+// Neighborhood is an area of a city, such as a neighborhood or ward, that requests located in it are tagged with
+type Neighborhood struct {
+	ID       string           `json:"id"`
+	Name     string           `json:"name"`
+	Boundary geo.MultiPolygon `json:"boundary"`
+}
+
+// What a neighborhood leaderboard ranks by
+const (
+	LeaderboardBySubmitted = "submitted"
+	LeaderboardByResolved  = "resolved"
+)
+
+// NeighborhoodRank is the standing of a neighborhood on its city's leaderboard
+type NeighborhoodRank struct {
+	Rank         int    `json:"rank"` // 1 for the first. Neighborhoods that tie share a rank
+	Neighborhood string `json:"neighborhood"`
+	Name         string `json:"name"`
+	Submitted    int64  `json:"submitted"` // Requests submitted during the period
+	Resolved     int64  `json:"resolved"`  // Requests closed during the period
+}
+
+// SetCityNeighborhoods replaces the neighborhoods of a city.  If the city is not in the database, a
+// CityNotFoundErr error is set
+func SetCityNeighborhoods(cityName string, neighborhoods []Neighborhood) error {
+	svc, err := createDirectoryClient()
+	if err != nil {
+		return err
+	}
+
+	av, err := dynamodbattribute.Marshal(neighborhoods)
+	if err != nil {
+		return fmt.Errorf("repository: Failed to marshal neighborhoods of %s. \n  %s", cityName, err)
+	}
+
+	_, err = svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(CitiesTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"city_name": {
+				S: aws.String(cityName),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(city_name)"),
+		UpdateExpression:    aws.String("SET neighborhoods = :n"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":n": av,
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return &CityNotFoundErr{"city not found"}
+		}
+		return fmt.Errorf("repository: failed to set neighborhoods of %s. \n  %s", cityName, err)
+	}
+
+	return nil
+}
+
+// NeighborhoodOf returns the id of the neighborhood of a city containing a point, or "" when none does.  Where
+// neighborhoods overlap, the first listed is taken.
+func (c City) NeighborhoodOf(lat, lon float64) string {
+	for _, n := range c.Neighborhoods {
+		if n.Boundary.Contains(lat, lon) {
+			return n.ID
+		}
+	}
+	return ""
+}
+
+// Leaderboard ranks the neighborhoods of a city by the requests submitted or resolved in them over the daily stats
+// of a period, most first.  Ties are broken by the other count, though they share a rank only when both are equal.
+func Leaderboard(neighborhoods []Neighborhood, days []DailyStats, rankBy string) []NeighborhoodRank {
+	ranks := make([]NeighborhoodRank, len(neighborhoods))
+	for i, n := range neighborhoods {
+		ranks[i] = NeighborhoodRank{Neighborhood: n.ID, Name: n.Name}
+		for _, day := range days {
+			ranks[i].Submitted += day.Neighborhoods[n.ID]
+			ranks[i].Resolved += day.NeighborhoodsResolved[n.ID]
+		}
+	}
+
+	counts := func(r NeighborhoodRank) (int64, int64) {
+		if rankBy == LeaderboardByResolved {
+			return r.Resolved, r.Submitted
+		}
+		return r.Submitted, r.Resolved
+	}
+	sort.SliceStable(ranks, func(i, j int) bool {
+		first, second := counts(ranks[i])
+		otherFirst, otherSecond := counts(ranks[j])
+		if first != otherFirst {
+			return first > otherFirst
+		}
+		if second != otherSecond {
+			return second > otherSecond
+		}
+		return ranks[i].Name < ranks[j].Name
+	})
+
+	for i := range ranks {
+		ranks[i].Rank = i + 1
+		if i > 0 && ranks[i].Submitted == ranks[i-1].Submitted && ranks[i].Resolved == ranks[i-1].Resolved {
+			ranks[i].Rank = ranks[i-1].Rank
+		}
+	}
+	return ranks
+}
@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/social-torch/open311-services/geo"
+)
+
+func TestNeighborhoodOf(t *testing.T) {
+	square := func(minLon, minLat float64) geo.MultiPolygon {
+		return geo.MultiPolygon{{{{minLon, minLat}, {minLon + 1, minLat}, {minLon + 1, minLat + 1}, {minLon, minLat + 1}, {minLon, minLat}}}}
+	}
+	city := City{Neighborhoods: []Neighborhood{
+		{ID: "ward-1", Name: "Ward 1", Boundary: square(-74, 42)},
+		{ID: "ward-2", Name: "Ward 2", Boundary: square(-73, 42)},
+	}}
+
+	if got := city.NeighborhoodOf(42.5, -72.5); got != "ward-2" {
+		t.Errorf("NeighborhoodOf() = %q, want ward-2", got)
+	}
+	if got := city.NeighborhoodOf(40, -72.5); got != "" {
+		t.Errorf("NeighborhoodOf() outside every neighborhood = %q, want none", got)
+	}
+}
+
+func TestLeaderboard(t *testing.T) {
+	neighborhoods := []Neighborhood{{ID: "ward-1", Name: "Ward 1"}, {ID: "ward-2", Name: "Ward 2"}, {ID: "ward-3", Name: "Ward 3"}, {ID: "ward-4", Name: "Ward 4"}}
+	days := []DailyStats{
+		{Neighborhoods: map[string]int64{"ward-1": 2, "ward-2": 4, "ward-3": 1}, NeighborhoodsResolved: map[string]int64{"ward-1": 3}},
+		{Neighborhoods: map[string]int64{"ward-1": 2, "ward-3": 3}, NeighborhoodsResolved: map[string]int64{"ward-2": 1, "ward-3": 1}},
+	}
+
+	// Wards 1 and 3 submitted as many, but 1 resolved more; ward 4 had nothing
+	board := Leaderboard(neighborhoods, days, LeaderboardBySubmitted)
+	want := []NeighborhoodRank{
+		{Rank: 1, Neighborhood: "ward-1", Name: "Ward 1", Submitted: 4, Resolved: 3},
+		{Rank: 2, Neighborhood: "ward-2", Name: "Ward 2", Submitted: 4, Resolved: 1},
+		{Rank: 2, Neighborhood: "ward-3", Name: "Ward 3", Submitted: 4, Resolved: 1},
+		{Rank: 4, Neighborhood: "ward-4", Name: "Ward 4"},
+	}
+	if len(board) != len(want) {
+		t.Fatalf("Leaderboard() = %+v, want %+v", board, want)
+	}
+	for i := range want {
+		if board[i] != want[i] {
+			t.Errorf("Leaderboard()[%d] = %+v, want %+v", i, board[i], want[i])
+		}
+	}
+
+	if board := Leaderboard(neighborhoods, days, LeaderboardByResolved); board[0].Neighborhood != "ward-1" || board[3].Neighborhood != "ward-4" {
+		t.Errorf("Leaderboard() by resolved = %+v, want ward-1 first and ward-4 last", board)
+	}
+	if board := Leaderboard(nil, days, LeaderboardBySubmitted); board == nil || len(board) != 0 {
+		t.Errorf("Leaderboard() of no neighborhoods = %#v, want empty", board)
+	}
+}
@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// Digest frequencies.  Users with a digest get updates on their subscriptions in one summary email rather than
+// an email per update
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// NotificationPreferences records which channels a user wants to be notified through
+type NotificationPreferences struct {
+	Push         bool   `json:"push"`          // Push notifications to registered devices. Enabled when a device is registered
+	Email        bool   `json:"email"`         // Email notifications to EmailAddress
+	EmailAddress string `json:"email_address"` // Address email notifications are sent to
+	SMS          bool   `json:"sms"`           // Text messages for critical updates to PhoneNumber
+	PhoneNumber  string `json:"phone_number"`  // E.164 formatted number text messages are sent to
+
+	QuietHoursStart int    `json:"quiet_hours_start"` // Hour (0-23) from which non-emergency text messages are held back
+	QuietHoursEnd   int    `json:"quiet_hours_end"`   // Hour (0-23) at which quiet hours end. Equal to start for no quiet hours
+	TimeZone        string `json:"time_zone"`         // IANA time zone quiet hours are evaluated in, eg "America/New_York"
+
+	Digest   string `json:"digest"`   // DigestDaily or DigestWeekly to summarize subscription updates. Empty to email each update
+	Language string `json:"language"` // BCP 47 language tag notifications are written in. Empty for English
+}
+
+// RegisterPushEndpoint appends an SNS platform endpoint to a user's devices and enables push notifications
+func RegisterPushEndpoint(accountID string, endpointArn string) error {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return err
+	}
+
+	// As in trackUserRequest, UpdateItem creates the user if they do not exist yet
+	input := &dynamodb.UpdateItemInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#PE": aws.String("push_endpoints"),
+			"#NP": aws.String("notification_preferences"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":e": {
+				L: []*dynamodb.AttributeValue{
+					{S: aws.String(endpointArn)},
+				},
+			},
+			":empty_list": {
+				L: []*dynamodb.AttributeValue{},
+			},
+			":p": {
+				M: map[string]*dynamodb.AttributeValue{
+					"push": {BOOL: aws.Bool(true)},
+				},
+			},
+		},
+		Key: map[string]*dynamodb.AttributeValue{
+			"account_id": {
+				S: aws.String(accountID),
+			},
+		},
+		TableName:        aws.String(UsersTable),
+		UpdateExpression: aws.String("SET #PE = list_append(if_not_exists(#PE, :empty_list), :e), #NP = if_not_exists(#NP, :p)"),
+	}
+
+	_, err = svc.UpdateItem(input)
+	if err != nil {
+		return fmt.Errorf("repository: failed to register push endpoint for account %s. \n  %s", accountID, err)
+	}
+
+	return nil
+}
+
+// SetNotificationPreferences replaces a user's notification preferences
+func SetNotificationPreferences(accountID string, preferences NotificationPreferences) error {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return err
+	}
+
+	av, err := dynamodbattribute.Marshal(preferences)
+	if err != nil {
+		return fmt.Errorf("repository: Failed to marshal notification preferences:\n %+v. \n  %s", preferences, err)
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#NP": aws.String("notification_preferences"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":p": av,
+		},
+		Key: map[string]*dynamodb.AttributeValue{
+			"account_id": {
+				S: aws.String(accountID),
+			},
+		},
+		TableName:        aws.String(UsersTable),
+		UpdateExpression: aws.String("SET #NP = :p"),
+	}
+
+	_, err = svc.UpdateItem(input)
+	if err != nil {
+		return fmt.Errorf("repository: failed to update notification preferences for account %s. \n  %s", accountID, err)
+	}
+
+	return nil
+}
+
+// DisableEmailNotifications turns off email notifications for a user, eg when they follow an unsubscribe link.
+// If the AccountID is not in the database, an AccountIDNotFoundErr error is set
+func DisableEmailNotifications(accountID string) error {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return err
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#NP": aws.String("notification_preferences"),
+			"#E":  aws.String("email"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":f": {BOOL: aws.Bool(false)},
+		},
+		Key: map[string]*dynamodb.AttributeValue{
+			"account_id": {
+				S: aws.String(accountID),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(#NP)"),
+		TableName:           aws.String(UsersTable),
+		UpdateExpression:    aws.String("SET #NP.#E = :f"),
+	}
+
+	_, err = svc.UpdateItem(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return &AccountIDNotFoundErr{"user not found"}
+		}
+		return fmt.Errorf("repository: failed to disable email notifications for account %s. \n  %s", accountID, err)
+	}
+
+	return nil
+}
+
+// GetDigestUsers returns the users who receive a digest email at the given frequency
+func GetDigestUsers(frequency string) ([]User, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return []User{}, err
+	}
+
+	params := &dynamodb.ScanInput{
+		TableName:        aws.String(UsersTable),
+		FilterExpression: aws.String("#NP.#D = :f AND #NP.#E = :t"),
+		ExpressionAttributeNames: map[string]*string{
+			"#NP": aws.String("notification_preferences"),
+			"#D":  aws.String("digest"),
+			"#E":  aws.String("email"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":f": {S: aws.String(frequency)},
+			":t": {BOOL: aws.Bool(true)},
+		},
+	}
+
+	items := []map[string]*dynamodb.AttributeValue{}
+	err = svc.ScanPages(params, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		items = append(items, page.Items...)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("repository: unable to get %s digest users from database. \n %s", frequency, err)
+	}
+
+	users := []User{}
+	err = dynamodbattribute.UnmarshalListOfMaps(items, &users)
+	if err != nil {
+		return users, fmt.Errorf("repository: Failed to unmarshal user records. \n %s", err)
+	}
+
+	return users, nil
+}
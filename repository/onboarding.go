@@ -0,0 +1,238 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// constants to define onboarding request status strings
+const (
+	OnboardingReceived  = "received"  // request awaits review by the platform team
+	OnboardingContacted = "contacted" // the platform team is talking with the city
+	OnboardingApproved  = "approved"  // request was approved and its city is being set up
+	OnboardingRejected  = "rejected"  // request was turned down
+	OnboardingLive      = "live"      // the city is set up and its admin invited
+)
+
+// legacyOnboardingStatuses maps the statuses of requests made before the platform team tracked them
+var legacyOnboardingStatuses = map[string]string{
+	"":             OnboardingReceived,
+	"pending":      OnboardingReceived,
+	"provisioning": OnboardingApproved,
+	"provisioned":  OnboardingLive,
+}
+
+// normalizeOnboarding gives a request read from the database a current status
+func normalizeOnboarding(request *OnboardingRequest) {
+	if status, ok := legacyOnboardingStatuses[request.Status]; ok {
+		request.Status = status
+	}
+}
+
+// OnboardingUpdate holds the fields of an onboarding request the platform team changes.  Nil fields are kept.
+type OnboardingUpdate struct {
+	Status   *string `json:"status"`
+	Assignee *string `json:"assignee"`
+	Notes    *string `json:"notes"`
+}
+
+type OnboardingRequestNotFoundErr struct {
+	message string
+}
+
+func (e *OnboardingRequestNotFoundErr) Error() string {
+	return e.message
+}
+
+type CityAlreadyExistsErr struct {
+	message string
+}
+
+func (e *CityAlreadyExistsErr) Error() string {
+	return e.message
+}
+
+// GetOnboardingRequests returns every onboarding request
+func GetOnboardingRequests() ([]OnboardingRequest, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return nil, err
+	}
+
+	requests := []OnboardingRequest{}
+	err = svc.ScanPages(&dynamodb.ScanInput{TableName: aws.String(OnboardingTable)}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		items := []OnboardingRequest{}
+		err = dynamodbattribute.UnmarshalListOfMaps(page.Items, &items)
+		if err != nil {
+			return false
+		}
+		requests = append(requests, items...)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("repository: unable to get onboarding requests. \n %s", err)
+	}
+
+	for i := range requests {
+		normalizeOnboarding(&requests[i])
+	}
+
+	return requests, nil
+}
+
+// GetOnboardingRequest returns a single onboarding request.  If the ID is not in the database, an
+// OnboardingRequestNotFoundErr error is set
+func GetOnboardingRequest(id string) (OnboardingRequest, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return OnboardingRequest{}, err
+	}
+
+	result, err := svc.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(OnboardingTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(id),
+			},
+		},
+	})
+	if err != nil {
+		return OnboardingRequest{}, fmt.Errorf("repository: unable to get onboarding request %s. \n %s", id, err)
+	}
+
+	request := OnboardingRequest{}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &request)
+	if err != nil {
+		return request, fmt.Errorf("repository: Failed to unmarshal onboarding request record from database. \n %s", err)
+	}
+
+	if request.ID == "" {
+		return request, &OnboardingRequestNotFoundErr{"onboarding request not found"}
+	}
+
+	normalizeOnboarding(&request)
+	return request, nil
+}
+
+// SetOnboardingStatus moves an onboarding request along the approval workflow, recording the city provisioned for
+// it.  If the ID is not in the database, an OnboardingRequestNotFoundErr error is set
+func SetOnboardingStatus(id string, status string, cityName string) error {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(OnboardingTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		ConditionExpression:      aws.String("attribute_exists(id)"),
+		UpdateExpression:         aws.String("SET #S = :s, city_name = :c, updated_datetime = :t"),
+		ExpressionAttributeNames: map[string]*string{"#S": aws.String("status")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":s": {S: aws.String(status)},
+			":c": {S: aws.String(cityName)},
+			":t": {S: aws.String(time.Now().UTC().Format(time.RFC3339))},
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return &OnboardingRequestNotFoundErr{"onboarding request not found"}
+		}
+		return fmt.Errorf("repository: failed to set status of onboarding request %s. \n  %s", id, err)
+	}
+
+	return nil
+}
+
+// UpdateOnboardingRequest changes the status, assignee or notes of an onboarding request.  If the ID is not in the
+// database, an OnboardingRequestNotFoundErr error is set
+func UpdateOnboardingRequest(id string, update OnboardingUpdate) error {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return err
+	}
+
+	set := []string{"updated_datetime = :t"}
+	names := map[string]*string{}
+	values := map[string]*dynamodb.AttributeValue{
+		":t": {S: aws.String(time.Now().UTC().Format(time.RFC3339))},
+	}
+	fields := []struct {
+		name  string
+		value *string
+	}{
+		{"status", update.Status},
+		{"assignee", update.Assignee},
+		{"notes", update.Notes},
+	}
+	for i, field := range fields {
+		if field.value == nil {
+			continue
+		}
+		names[fmt.Sprintf("#f%d", i)] = aws.String(field.name)
+		values[fmt.Sprintf(":v%d", i)] = &dynamodb.AttributeValue{S: aws.String(*field.value)}
+		set = append(set, fmt.Sprintf("#f%d = :v%d", i, i))
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(OnboardingTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		ConditionExpression:       aws.String("attribute_exists(id)"),
+		UpdateExpression:          aws.String("SET " + strings.Join(set, ", ")),
+		ExpressionAttributeValues: values,
+	}
+	if len(names) > 0 {
+		input.ExpressionAttributeNames = names
+	}
+
+	_, err = svc.UpdateItem(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return &OnboardingRequestNotFoundErr{"onboarding request not found"}
+		}
+		return fmt.Errorf("repository: failed to update onboarding request %s. \n  %s", id, err)
+	}
+
+	return nil
+}
+
+// AddCity adds a city.  If a city of the same name exists, a CityAlreadyExistsErr error is set
+func AddCity(city City) error {
+	svc, err := createDirectoryClient()
+	if err != nil {
+		return err
+	}
+
+	av, err := dynamodbattribute.MarshalMap(city)
+	if err != nil {
+		return fmt.Errorf("repository: Failed to marshal city:\n %+v. \n  %s", city, err)
+	}
+
+	_, err = svc.PutItem(&dynamodb.PutItemInput{
+		Item:                av,
+		TableName:           aws.String(CitiesTable),
+		ConditionExpression: aws.String("attribute_not_exists(city_name)"),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return &CityAlreadyExistsErr{"city already exists"}
+		}
+		return fmt.Errorf("repository: failed to put city %s in database. \n %s", city.CityName, err)
+	}
+
+	return nil
+}
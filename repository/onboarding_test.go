@@ -0,0 +1,21 @@
+package repository
+
+import "testing"
+
+func TestNormalizeOnboarding(t *testing.T) {
+	tests := map[string]string{
+		"":                  OnboardingReceived,
+		"pending":           OnboardingReceived,
+		"provisioning":      OnboardingApproved,
+		"provisioned":       OnboardingLive,
+		OnboardingContacted: OnboardingContacted,
+		OnboardingRejected:  OnboardingRejected,
+	}
+	for status, want := range tests {
+		request := OnboardingRequest{ID: "01ARZ3NDEKTSV4RRFFQ69G5FAV", Status: status}
+		normalizeOnboarding(&request)
+		if request.Status != want {
+			t.Errorf("normalizeOnboarding() of %q = %q, want %q", status, request.Status, want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// RequestsPageSize is the most requests GetRequestsPage reads from the table at once
+const RequestsPageSize = 500
+
+// CursorErr is returned for a cursor that doesn't continue a listing of requests
+type CursorErr struct {
+	message string
+}
+
+func (e *CursorErr) Error() string {
+	return e.message
+}
+
+// GetRequestsPage returns a page of at most limit requests of a city made from start to end, oldest first, after the
+// request cursor names, or from the first for an empty cursor, with only the attributes given read, eg those
+// ListingAttributes returns.  It also returns
+// the cursor continuing the listing after the page, which is empty once there are no more.  An empty cityID pages
+// through the requests of every city made in the range, in no particular order.  If cursor doesn't continue the
+// listing, a CursorErr error is set.
+func GetRequestsPage(cityID string, start time.Time, end time.Time, cursor string, limit int, attributes []string) ([]Request, string, error) {
+	if limit <= 0 || limit > RequestsPageSize {
+		limit = RequestsPageSize
+	}
+	names := map[string]*string{}
+	expression := projection(attributes, names)
+	values := map[string]*dynamodb.AttributeValue{
+		":s": {S: aws.String(start.UTC().Format(time.RFC3339))},
+		":e": {S: aws.String(end.UTC().Format(time.RFC3339))},
+	}
+
+	var after map[string]*dynamodb.AttributeValue
+	if cursor != "" {
+		id, requested, err := parseCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		after = map[string]*dynamodb.AttributeValue{"service_request_id": {S: aws.String(id)}}
+		if cityID != "" {
+			// The CityIndex continues from its own key as well as the table's
+			if requested < *values[":s"].S || requested > *values[":e"].S {
+				return nil, "", &CursorErr{"cursor is outside start_date and end_date"}
+			}
+			after["city_id"] = &dynamodb.AttributeValue{S: aws.String(cityID)}
+			after["requested_datetime"] = &dynamodb.AttributeValue{S: aws.String(requested)}
+		}
+	}
+
+	var items []map[string]*dynamodb.AttributeValue
+	var last map[string]*dynamodb.AttributeValue
+	if cityID == "" {
+		svc, err := createDynamoClient()
+		if err != nil {
+			return nil, "", err
+		}
+		result, err := svc.Scan(&dynamodb.ScanInput{
+			TableName:                 aws.String(RequestsTable),
+			FilterExpression:          aws.String("requested_datetime BETWEEN :s AND :e"),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+			ProjectionExpression:      aws.String(expression),
+			ExclusiveStartKey:         after,
+			Limit:                     aws.Int64(int64(limit)),
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("repository: unable to get a page of requests made since %s. \n %s", start, err)
+		}
+		items, last = result.Items, result.LastEvaluatedKey
+	} else {
+		svc, err := createCityClient(cityID)
+		if err != nil {
+			return nil, "", err
+		}
+		values[":c"] = &dynamodb.AttributeValue{S: aws.String(cityID)}
+		result, err := svc.Query(&dynamodb.QueryInput{
+			TableName:                 aws.String(RequestsTable),
+			IndexName:                 aws.String(CityIndex),
+			KeyConditionExpression:    aws.String("city_id = :c AND requested_datetime BETWEEN :s AND :e"),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+			ProjectionExpression:      aws.String(expression),
+			ExclusiveStartKey:         after,
+			Limit:                     aws.Int64(int64(limit)),
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("repository: unable to get a page of requests of %s made since %s. \n %s", cityID, start, err)
+		}
+		items, last = result.Items, result.LastEvaluatedKey
+	}
+
+	requests := []Request{}
+	err := dynamodbattribute.UnmarshalListOfMaps(items, &requests)
+	if err != nil {
+		return nil, "", fmt.Errorf("repository: Failed to unmarshal a page of requests. \n %s", err)
+	}
+	return requests, keyCursor(last), nil
+}
+
+// RequestCursor returns the cursor continuing a listing of requests after request, for listings cut short of a page
+func RequestCursor(request Request) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(request.RequestedDateTime + " " + request.ServiceRequestID))
+}
+
+// keyCursor returns the cursor continuing a listing after the last key a Query or Scan evaluated, or an empty one
+// when it evaluated every item.  A Scan's key has no requested_datetime, which the cursor leaves empty.
+func keyCursor(key map[string]*dynamodb.AttributeValue) string {
+	if len(key) == 0 {
+		return ""
+	}
+	request := Request{ServiceRequestID: aws.StringValue(key["service_request_id"].S)}
+	if requested, ok := key["requested_datetime"]; ok {
+		request.RequestedDateTime = aws.StringValue(requested.S)
+	}
+	return RequestCursor(request)
+}
+
+// parseCursor returns the service_request_id and requested_datetime of the request a cursor names
+func parseCursor(cursor string) (string, string, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", "", &CursorErr{"cursor is not one returned by a previous listing"}
+	}
+	parts := strings.SplitN(string(decoded), " ", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", &CursorErr{"cursor is not one returned by a previous listing"}
+	}
+	return parts[1], parts[0], nil
+}
@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestRequestCursor(t *testing.T) {
+	request := Request{ServiceRequestID: "01ABC", RequestedDateTime: "2019-06-01T12:00:00Z"}
+	id, requested, err := parseCursor(RequestCursor(request))
+	if err != nil || id != request.ServiceRequestID || requested != request.RequestedDateTime {
+		t.Errorf("parseCursor(RequestCursor()) = %s, %s, %v, want %s, %s", id, requested, err, request.ServiceRequestID, request.RequestedDateTime)
+	}
+
+	// The cursor after a Scan's page names no requested_datetime
+	key := map[string]*dynamodb.AttributeValue{"service_request_id": {S: aws.String("01ABC")}}
+	if id, requested, err := parseCursor(keyCursor(key)); err != nil || id != "01ABC" || requested != "" {
+		t.Errorf("parseCursor(keyCursor(scan key)) = %s, %s, %v, want 01ABC", id, requested, err)
+	}
+	if got := keyCursor(nil); got != "" {
+		t.Errorf("keyCursor() of the last page = %s, want none", got)
+	}
+
+	for _, cursor := range []string{"not base64!", "MjAxOQ"} {
+		if _, _, err := parseCursor(cursor); err == nil {
+			t.Errorf("parseCursor(%q) should fail", cursor)
+		} else if _, ok := err.(*CursorErr); !ok {
+			t.Errorf("parseCursor(%q) error = %T, want *CursorErr", cursor, err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// CityIndex is the global secondary index on city_id of RequestsTable, ServicesTable and UsersTable.  Requests
+// are sorted by requested_datetime, services by service_code.
+const CityIndex = "city_id-index"
+
+// inCity reports whether an item of a city is visible to a call scoped to scope.  Unscoped calls, from
+// deployments serving a single city, see every item.
+func inCity(scope string, cityID string) bool {
+	return scope == "" || scope == cityID
+}
+
+// filterCity limits a query of an index other than CityIndex to the items of a city.  An empty cityID leaves the
+// query unscoped.
+func filterCity(input *dynamodb.QueryInput, cityID string) {
+	if cityID == "" {
+		return
+	}
+	if input.ExpressionAttributeNames == nil {
+		input.ExpressionAttributeNames = map[string]*string{}
+	}
+	input.ExpressionAttributeNames["#city"] = aws.String("city_id")
+	input.ExpressionAttributeValues[":city"] = &dynamodb.AttributeValue{S: aws.String(cityID)}
+	input.FilterExpression = aws.String("#city = :city")
+}
+
+// queryCity reads every item of a city from the CityIndex of a table into items, a pointer to a slice
+func queryCity(table string, cityID string, items interface{}) error {
+	svc, err := createCityClient(cityID)
+	if err != nil {
+		return err
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(table),
+		IndexName:              aws.String(CityIndex),
+		KeyConditionExpression: aws.String("city_id = :c"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":c": {
+				S: aws.String(cityID),
+			},
+		},
+	}
+
+	all := []map[string]*dynamodb.AttributeValue{}
+	err = svc.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		all = append(all, page.Items...)
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("repository: unable to get %s of %s. \n %s", table, cityID, err)
+	}
+
+	err = dynamodbattribute.UnmarshalListOfMaps(all, items)
+	if err != nil {
+		return fmt.Errorf("repository: Failed to unmarshal %s records. \n %s", table, err)
+	}
+	return nil
+}
+
+// BackfillCityID sets the city_id of the items of a table that have none, for deployments that served a single
+// city before data was partitioned by city.  key is the table's partition key.  It returns how many items were
+// (or, for a dry run, would be) updated.
+func BackfillCityID(table string, key string, cityID string, dryRun bool) (int, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return 0, err
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName:                aws.String(table),
+		FilterExpression:         aws.String("attribute_not_exists(city_id)"),
+		ProjectionExpression:     aws.String("#K"),
+		ExpressionAttributeNames: map[string]*string{"#K": aws.String(key)},
+	}
+
+	var updated int64
+	err = scanEach(svc, input, func(item map[string]*dynamodb.AttributeValue) error {
+		atomic.AddInt64(&updated, 1)
+		if dryRun {
+			return nil
+		}
+
+		_, err := svc.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName:           aws.String(table),
+			Key:                 map[string]*dynamodb.AttributeValue{key: item[key]},
+			ConditionExpression: aws.String("attribute_not_exists(city_id)"),
+			UpdateExpression:    aws.String("SET city_id = :c"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":c": {S: aws.String(cityID)},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("repository: failed to set city_id of %s item %v. \n  %s", table, item[key], err)
+		}
+		return nil
+	})
+	if err != nil {
+		return int(updated), fmt.Errorf("repository: unable to backfill city_id of %s. \n %s", table, err)
+	}
+	return int(updated), nil
+}
@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/social-torch/open311-services/geo"
+)
+
+// PinsTable holds a pin for each request with a location, keyed by geo_cell and id, so maps read a viewport's
+// markers without reading the requests themselves.  The Stream function keeps it up to date.
+const PinsTable = "Pins"
+
+// MaxPinCells is the most geo cells a pins query may cover, about 80km by 80km at mid latitudes, as pins are
+// small enough to read as many cells as clusters
+const MaxPinCells = 256
+
+// Pin is the marker of a request on a map: where it is, and enough to draw and filter it.  The geo cell and city
+// are kept only to find pins.
+type Pin struct {
+	ID string `json:"id"` // service_request_id of the request
+	Location
+	Status  string `json:"status"`
+	Service string `json:"service"` // service_code of the request
+	Geohash string `json:"geohash"`
+	GeoCell string `json:"-" dynamodbav:"geo_cell"`
+	CityID  string `json:"-" dynamodbav:"city_id,omitempty"`
+}
+
+// RequestPin returns the pin of a request, and false for requests without a location, which have none
+func RequestPin(request Request) (Pin, bool) {
+	setGeohash(&request)
+	if request.Geohash == "" {
+		return Pin{}, false
+	}
+	return Pin{
+		ID:       request.ServiceRequestID,
+		Location: request.Location,
+		Status:   request.Status,
+		Service:  request.ServiceCode,
+		Geohash:  request.Geohash,
+		GeoCell:  request.GeoCell,
+		CityID:   request.CityID,
+	}, true
+}
+
+// PutPin adds or replaces a pin
+func PutPin(pin Pin) error {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return err
+	}
+	return putPin(svc, pin)
+}
+
+func putPin(svc *dynamodb.DynamoDB, pin Pin) error {
+	item, err := dynamodbattribute.MarshalMap(pin)
+	if err != nil {
+		return fmt.Errorf("repository: failed to marshal pin of request %s. \n %s", pin.ID, err)
+	}
+
+	_, err = svc.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(PinsTable),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("repository: failed to put pin of request %s. \n %s", pin.ID, err)
+	}
+	return nil
+}
+
+// DeletePin removes a pin, eg of a request that was deleted or moved to another geo cell.  Removing a pin that
+// doesn't exist is not an error.
+func DeletePin(pin Pin) error {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(PinsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"geo_cell": {S: aws.String(pin.GeoCell)},
+			"id":       {S: aws.String(pin.ID)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("repository: failed to delete pin of request %s. \n %s", pin.ID, err)
+	}
+	return nil
+}
+
+// GetPinsInBox returns the pins of a city's requests inside a bounding box, at most limit of them, ordered by
+// request ID, which puts requests submitted to the platform newest first.  truncated is true when there were
+// more.  An empty cityID returns the pins of every city.  If the box covers more than MaxPinCells cells, a
+// BoxTooLargeErr error is set
+func GetPinsInBox(cityID string, box geo.Box, limit int) (pins []Pin, truncated bool, err error) {
+	cells := geo.Cover(box, GeoCellPrecision)
+	if len(cells) > MaxPinCells {
+		return nil, false, &BoxTooLargeErr{"bounding box too large"}
+	}
+
+	svc, err := createCityClient(cityID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	pins = []Pin{}
+	for _, cell := range cells {
+		input := &dynamodb.QueryInput{
+			TableName:              aws.String(PinsTable),
+			KeyConditionExpression: aws.String("geo_cell = :c"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":c": {
+					S: aws.String(cell),
+				},
+			},
+		}
+		filterCity(input, cityID)
+
+		err = svc.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+			items := []Pin{}
+			err = dynamodbattribute.UnmarshalListOfMaps(page.Items, &items)
+			if err != nil {
+				return false
+			}
+			for _, pin := range items {
+				if box.Contains(pin.Coordinates()) {
+					pins = append(pins, pin)
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, false, fmt.Errorf("repository: unable to get pins in geo cell %s. \n %s", cell, err)
+		}
+	}
+
+	// Request IDs are ULIDs, which sort by the time they were made
+	sort.Slice(pins, func(i, j int) bool {
+		return pins[i].ID > pins[j].ID
+	})
+	if len(pins) > limit {
+		return pins[:limit], true, nil
+	}
+	return pins, false, nil
+}
+
+// BackfillPins puts the pins of stored requests with a location, returning how many pins were (or, for a dry run,
+// would be) put.  Pins already stored are replaced with the same pin, so it is safe to run again.
+func BackfillPins(dryRun bool) (int, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return 0, err
+	}
+
+	var put int64
+	err = scanEach(svc, &dynamodb.ScanInput{TableName: aws.String(RequestsTable)}, func(item map[string]*dynamodb.AttributeValue) error {
+		request := Request{}
+		err := dynamodbattribute.UnmarshalMap(item, &request)
+		if err != nil {
+			return err
+		}
+
+		pin, ok := RequestPin(request)
+		if !ok {
+			return nil
+		}
+
+		atomic.AddInt64(&put, 1)
+		if dryRun {
+			return nil
+		}
+		return putPin(svc, pin)
+	})
+	if err != nil {
+		return int(put), fmt.Errorf("repository: unable to backfill pins. \n %s", err)
+	}
+	return int(put), nil
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+func TestRequestPin(t *testing.T) {
+	_, ok := RequestPin(Request{ServiceRequestID: "SR-1"})
+	if ok {
+		t.Errorf("RequestPin() of a request without a location is ok")
+	}
+
+	request := Request{
+		ServiceRequestID: "SR-1", CityID: "Troy", ServiceCode: "pothole", Status: RequestOpen, Description: "Deep",
+		Location: Location{Latitude: 42.7284, Longitude: -73.6918},
+	}
+	pin, ok := RequestPin(request)
+	if !ok || pin.ID != "SR-1" || pin.Service != "pothole" || pin.Status != RequestOpen || pin.CityID != "Troy" {
+		t.Fatalf("RequestPin() = %+v, %v", pin, ok)
+	}
+	if len(pin.Geohash) != GeohashPrecision || pin.GeoCell != pin.Geohash[:GeoCellPrecision] {
+		t.Errorf("RequestPin() geohash %s and cell %s", pin.Geohash, pin.GeoCell)
+	}
+
+	// Clients get the pin alone, the table its keys and city too
+	body, err := json.Marshal(pin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]interface{}{}
+	_ = json.Unmarshal(body, &fields)
+	for _, field := range []string{"id", "lat", "lon", "status", "service", "geohash"} {
+		if _, ok := fields[field]; !ok {
+			t.Errorf("pin JSON %s has no %s", body, field)
+		}
+	}
+	if len(fields) != 6 {
+		t.Errorf("pin JSON %s has %d fields, want 6", body, len(fields))
+	}
+
+	item, err := dynamodbattribute.MarshalMap(pin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, attribute := range []string{"id", "geo_cell", "city_id", "lat", "lon"} {
+		if _, ok := item[attribute]; !ok {
+			t.Errorf("pin item has no %s", attribute)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/social-torch/open311-services/metrics"
+)
+
+// Cities may pin their data to an AWS region, eg to keep residents' requests in the country they were made in.  A
+// pinned city's tables and media bucket live in its region, where a stack deployed with DATA_REGION set to the
+// region serves it.  The Cities table is the directory every stack shares, so it stays in AwsRegion.
+
+// DataRegion returns the region of the tables of this deployment: DATA_REGION, else AwsRegion
+func DataRegion() string {
+	if region := os.Getenv("DATA_REGION"); region != "" {
+		return region
+	}
+	return AwsRegion
+}
+
+// ValidRegion reports whether region is an AWS Standard region, eg "eu-west-1"
+func ValidRegion(region string) bool {
+	_, ok := endpoints.AwsPartition().Regions()[region]
+	return ok
+}
+
+// DataRegion returns the region holding a city's data, AwsRegion for cities that aren't pinned
+func (c City) DataRegion() string {
+	if c.Region == "" {
+		return AwsRegion
+	}
+	return c.Region
+}
+
+// clients are reused across invocations of a warm Lambda, one per region.  Creating a session loads configuration
+// and credentials, which added tens of milliseconds to every call when each call made its own.
+var (
+	clientsMu sync.Mutex
+	clients   = map[string]*dynamodb.DynamoDB{}
+)
+
+// createDynamoClient is a convenience function to establish a session with AWS and
+// returns a new instance of the DynamoDB client of the deployment's tables
+func createDynamoClient() (*dynamodb.DynamoDB, error) {
+	return createDynamoClientIn(DataRegion())
+}
+
+// createDirectoryClient returns a DynamoDB client of the Cities table, which every deployment shares
+func createDirectoryClient() (*dynamodb.DynamoDB, error) {
+	return createDynamoClientIn(AwsRegion)
+}
+
+// createCityClient returns a DynamoDB client of the tables holding a city's data.  An empty cityID gives the
+// deployment's tables.
+func createCityClient(cityID string) (*dynamodb.DynamoDB, error) {
+	region, err := cityRegion(cityID)
+	if err != nil {
+		return nil, err
+	}
+	return createDynamoClientIn(region)
+}
+
+// createDynamoClientIn returns the DynamoDB client of the tables in a region, creating it on first use
+func createDynamoClientIn(region string) (*dynamodb.DynamoDB, error) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	if svc, ok := clients[region]; ok {
+		return svc, nil
+	}
+
+	// Initial credentials loaded from SDK's default credential chain. Such as
+	// the environment, shared credentials (~/.aws/credentials), or EC2 Instance
+	// Role.
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(region)},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("\n repository: unable to establish session with AWS in %s \n  %s", region, err)
+	}
+	sess.Handlers.Build.PushBack(keepOldImage)
+	sess.Handlers.Build.PushBack(askCapacity)
+	sess.Handlers.Retry.PushBack(countThrottles)
+	sess.Handlers.Complete.PushBack(countErrors)
+	sess.Handlers.Complete.PushBack(countCapacity)
+	sess.Handlers.Complete.PushBack(auditWrites(sess))
+
+	svc := dynamodb.New(sess)
+	clients[region] = svc
+	return svc, nil
+}
+
+// countErrors counts the DynamoDB calls that fail as DynamoDBErrors, by operation and then error code.  Failed
+// conditions are how the repository detects missing and duplicate items, so they aren't counted.
+func countErrors(r *request.Request) {
+	if r.Error == nil {
+		return
+	}
+
+	code := "unknown"
+	if aerr, ok := r.Error.(awserr.Error); ok {
+		code = aerr.Code()
+	}
+	if code == dynamodb.ErrCodeConditionalCheckFailedException {
+		return
+	}
+	metrics.Count("DynamoDBErrors", metrics.Dimension{Name: "Operation", Value: r.Operation.Name}, metrics.Dimension{Name: "ErrorCode", Value: code})
+}
+
+// Warm creates the DynamoDB clients of the deployment's tables and of the Cities table, and looks up the region of
+// the JURISDICTION the deployment serves, so the first call a function instance answers doesn't wait on them.
+// Functions call it when a warmer calls them.
+func Warm() error {
+	if _, err := createDynamoClient(); err != nil {
+		return err
+	}
+	if _, err := createDirectoryClient(); err != nil {
+		return err
+	}
+	_, err := createCityClient(os.Getenv("JURISDICTION"))
+	return err
+}
+
+// regionTTL is how long the region of a city is cached.  Cities rarely move, and their data has to be migrated
+// when they do.
+const regionTTL = 5 * time.Minute
+
+type cachedRegion struct {
+	region  string
+	expires time.Time
+}
+
+var (
+	regionsMu sync.Mutex
+	regions   = map[string]cachedRegion{}
+)
+
+// cityRegion returns the region of a city's data.  Cities not in the directory, and unscoped calls, use the
+// deployment's tables.
+func cityRegion(cityID string) (string, error) {
+	if cityID == "" {
+		return DataRegion(), nil
+	}
+
+	regionsMu.Lock()
+	cached, ok := regions[cityID]
+	regionsMu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.region, nil
+	}
+
+	region := DataRegion()
+	city, err := GetCity(cityID)
+	switch err.(type) {
+	case nil:
+		region = city.DataRegion()
+	case *CityNotFoundErr:
+	default:
+		return "", err
+	}
+
+	regionsMu.Lock()
+	regions[cityID] = cachedRegion{region, time.Now().Add(regionTTL)}
+	regionsMu.Unlock()
+	return region, nil
+}
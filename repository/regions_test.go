@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDataRegion(t *testing.T) {
+	defer os.Setenv("DATA_REGION", os.Getenv("DATA_REGION"))
+
+	os.Setenv("DATA_REGION", "")
+	if got := DataRegion(); got != AwsRegion {
+		t.Errorf("DataRegion() without DATA_REGION = %s, want %s", got, AwsRegion)
+	}
+	os.Setenv("DATA_REGION", "eu-west-1")
+	if got := DataRegion(); got != "eu-west-1" {
+		t.Errorf("DataRegion() = %s, want eu-west-1", got)
+	}
+
+	if got := (City{CityName: "Troy"}).DataRegion(); got != AwsRegion {
+		t.Errorf("DataRegion() of unpinned city = %s, want %s", got, AwsRegion)
+	}
+	if got := (City{CityName: "Galway", Region: "eu-west-1"}).DataRegion(); got != "eu-west-1" {
+		t.Errorf("DataRegion() of pinned city = %s, want eu-west-1", got)
+	}
+	if ValidRegion("europe") || !ValidRegion("eu-west-1") {
+		t.Error("ValidRegion() accepted an unknown region or refused eu-west-1")
+	}
+}
+
+// BenchmarkCreateDynamoClient is what a repository call of a warm Lambda pays for its client
+func BenchmarkCreateDynamoClient(b *testing.B) {
+	if _, err := createDynamoClientIn(AwsRegion); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		createDynamoClientIn(AwsRegion)
+	}
+}
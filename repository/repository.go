@@ -2,15 +2,18 @@ package repository
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
+	"sort"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/oklog/ulid"
+	"github.com/social-torch/open311-services/geo"
 )
 
 // Names of Open311 tables in dynamoDB
@@ -21,9 +24,11 @@ const (
 	UsersTable      = "Users"
 	FeedbackTable   = "Feedback"
 	OnboardingTable = "OnboardingRequests"
+	MediaTable      = "Media"
 )
 
-// AwsRegion is the AWS Standard region in which the dynamo tables are created
+// AwsRegion is the AWS Standard region in which the dynamo tables are created, unless a deployment or city is
+// pinned to another region
 const AwsRegion = endpoints.UsEast1RegionID // "us-east-1" -  US East (N. Virginia).
 
 // constants to define Open311 Request status strings
@@ -34,6 +39,14 @@ const (
 	RequestClosed     = "closed"     // request has been resolved
 )
 
+// constants to define moderation status strings for uploaded media
+const (
+	ModerationPending  = "pending"  // media has not been reviewed yet
+	ModerationApproved = "approved" // automated moderation found nothing objectionable
+	ModerationFlagged  = "flagged"  // media may contain personal information and needs review before public display
+	ModerationRejected = "rejected" // media contains nudity or graphic violence and must not be served
+)
+
 // Service is an Open311 struct representing a service offered by a city
 type Service struct {
 	ServiceCode string   `json:"service_code"`
@@ -43,6 +56,9 @@ type Service struct {
 	Type        string   `json:"type"`
 	Keywords    []string `json:"keywords"`
 	Group       string   `json:"group"`
+	Emergency   bool     `json:"emergency"`         // Updates on requests for emergency services are sent even during quiet hours
+	SLAHours    int      `json:"sla_hours"`         // Hours within which requests should be resolved. 0 for no service level agreement
+	CityID      string   `json:"city_id,omitempty"` // City offering the service. Omitted rather than empty, which the city_id-index rejects
 }
 
 // ServiceDefinition defines attributes associated with a service code. These attributes can be unique to the city/jurisdiction.
@@ -72,31 +88,48 @@ type AttributeValue struct {
 
 // Issues that have been reported as service requests.  Location is submitted via lat/long or address
 type Request struct {
-	ServiceRequestID  string           `json:"service_request_id"` // The unique ID of the service request created.
-	Status            string           `json:"status"`             // The current status of the service request.
-	StatusNotes       string           `json:"status_notes"`       // Explanation of why status was changed to current state or more details on current status than conveyed with status alone.
-	ServiceName       string           `json:"service_name"`       // The human readable name of the service request type
-	ServiceCode       string           `json:"service_code"`       // The unique identifier for the service request type
-	Description       string           `json:"description"`        // A full description of the request or report submitted.
-	AgencyResponsible string           `json:"agency_responsible"` // The agency responsible for fulfilling or otherwise addressing the service request.
-	ServiceNotice     string           `json:"service_notice"`     // Information about the action expected to fulfill the request or otherwise address the information reported.
-	RequestedDateTime string           `json:"requested_datetime"` // The date and time (RFC3339) when the service request was made.
-	UpdatedDateTime   string           `json:"update_datetime"`    // The date and time (RFC3339) when the service request was last modified. For requests with status=closed, this will be the date the request was closed.
-	ExpectedDateTime  string           `json:"expected_datetime"`  // The date and time (RFC3339) when the service request can be expected to be fulfilled. This may be based on a service-specific service level agreement.
-	Address           string           `json:"address"`            // Human readable address or description of location.
-	AddressID         string           `json:"address_id"`         // The internal address ID used by a jurisdictions master address repository or other addressing system.
-	ZipCode           int32            `json:"zipcode"`            // The postal code for the location of the service request.
-	Latitude          float32          `json:"lat"`                // latitude using the (WGS84) projection.
-	Longitude         float32          `json:"lon"`                // longitude using the (WGS84) projection.
-	MediaURL          string           `json:"media_url"`         // Media URL
-	AuditLog          []AuditEntry     `json:"audit_log"`          // Slice of AuditEntry items - Log to keep track of all changes to a Request over time
-	Values            []AttributeValue `json:"values"`             // Enables future expansion
-}
-
-type Media struct {
-	MediaURL  string `json:"media_url"` // A URL to media associated with the request, eg an image.
-	Timestamp string `json:"timestamp"` // RFC3339 formatted timestamp
+	ServiceRequestID  string           `json:"service_request_id"`                     // The unique ID of the service request created.
+	CityID            string           `json:"city_id,omitempty"`                      // City the request was made to. Omitted rather than empty, which the city_id-index rejects
+	Status            string           `json:"status"`                                 // The current status of the service request.
+	StatusNotes       string           `json:"status_notes"`                           // Explanation of why status was changed to current state or more details on current status than conveyed with status alone.
+	ServiceName       string           `json:"service_name"`                           // The human readable name of the service request type
+	ServiceCode       string           `json:"service_code"`                           // The unique identifier for the service request type
+	Description       string           `json:"description"`                            // A full description of the request or report submitted.
+	AgencyResponsible string           `json:"agency_responsible"`                     // The agency responsible for fulfilling or otherwise addressing the service request.
+	AgencyPath        []string         `json:"agency_path,omitempty"`                  // agency_id of the departments from the top level down to the agency responsible, eg ["public-works", "streets"]
+	ServiceNotice     string           `json:"service_notice"`                         // Information about the action expected to fulfill the request or otherwise address the information reported.
+	RequestedDateTime string           `json:"requested_datetime"`                     // The date and time (RFC3339) when the service request was made.
+	UpdatedDateTime   string           `json:"update_datetime"`                        // The date and time (RFC3339) when the service request was last modified. For requests with status=closed, this will be the date the request was closed.
+	ExpectedDateTime  string           `json:"expected_datetime"`                      // The date and time (RFC3339) when the service request can be expected to be fulfilled. This may be based on a service-specific service level agreement.
+	ScheduledDateTime string           `json:"scheduled_datetime,omitempty"`           // The date and time (RFC3339) staff have scheduled work on the request for. Empty until scheduled
+	Address           string           `json:"address"`                                // Human readable address or description of location.
+	AddressID         string           `json:"address_id"`                             // The internal address ID used by a jurisdictions master address repository or other addressing system.
+	ZipCode           ZipCode          `json:"zipcode" dynamodbav:"zipcode,omitempty"` // The ZIP code, or ZIP+4, for the location of the service request. Not stored when unknown
+	Location                           // lat and lon using the (WGS84) projection.
+	Geometry          *Geometry        `json:"geometry,omitempty"`                      // Extent of an issue larger than a point, as a GeoJSON LineString or Polygon
+	Geohash           string           `json:"geohash,omitempty"`                       // Geohash of lat/lon, set when the request is stored
+	GeoCell           string           `json:"geo_cell,omitempty"`                      // Prefix of Geohash partitioning the geo_cell-index. Omitted rather than empty, which the index rejects
+	Neighborhood      string           `json:"neighborhood,omitempty"`                  // id of the city neighborhood the request is located in, set when it is submitted
+	AssetID           string           `json:"asset_id"`                                // The city asset the request is about, eg a streetlight, from the Assets registry
+	AssetLabel        string           `json:"asset_label"`                             // How crews refer to the asset, eg "Streetlight #4471"
+	MediaURL          string           `json:"media_url"`                               // Media URL
+	AccountID         string           `json:"account_id"`                              // Unique ID for the user account of the person who submitted the request
+	ExternalID        string           `json:"external_id,omitempty"`                   // ID of the request in the 311 system it was imported from, eg "seeclickfix:1234567"
+	WorkOrderID       string           `json:"work_order_id,omitempty"`                 // ID of the work order the request became in its city's work-order system
+	AuditLog          []AuditEntry     `json:"audit_log"`                               // Slice of AuditEntry items - Log to keep track of all changes to a Request over time
+	Comments          []Comment        `json:"comments,omitempty"`                      // Notes left on the request by residents and staff, oldest first
+	EscalationLevel   int              `json:"escalation_level"`                        // Times the request has been escalated for breaching its SLA
+	EscalatedDateTime string           `json:"escalated_datetime"`                      // The date and time (RFC3339) of the latest escalation
+	ClosedDateTime    string           `json:"closed_datetime,omitempty"`               // The date and time (RFC3339) the request was closed. Empty while it is open
+	ResolutionHours   float64          `json:"resolution_hours,omitempty"`              // Hours from the request being made to it being closed
+	AssignedTo        string           `json:"assigned_to,omitempty"`                   // Worker or crew of the agency responsible the request is assigned to
+	QueueAgency       string           `json:"-" dynamodbav:"queue_agency,omitempty"`   // AgencyResponsible while the request isn't closed, partitioning the queue_agency-index
+	ZipArea           string           `json:"-" dynamodbav:"zip_area,omitempty"`       // Five digits of ZipCode, partitioning the zipcode-index. Not stored when unknown, keeping it out of the index
+	SchemaVersion     int              `json:"-" dynamodbav:"schema_version,omitempty"` // Version of the form the request is stored in, upgraded on read by requestMigrations
+	Version           int              `json:"-" dynamodbav:"version,omitempty"`        // Times the request was written since it was made, which guards updates against concurrent changes
+	Values            []AttributeValue `json:"values"`                                  // Enables future expansion
 }
+
 type AuditEntry struct {
 	ChangeNote string `json:"change_note"` // Text describing the change that was made to the Request
 	AccountID  string `json:"account_id"`  // Unique ID for the user account of the person updating the request
@@ -104,9 +137,10 @@ type AuditEntry struct {
 }
 
 type RequestResponse struct {
-	ServiceRequestID string `json:"service_request_id"` // The unique ID of the service request created.
-	ServiceNotice    string `json:"service_notice"`     // Information about the action expected to fulfill the request or otherwise address the information reported
-	AccountID        string `json:"account_id"`         // Unique ID for the user account of the person submitting the request
+	ServiceRequestID string   `json:"service_request_id"` // The unique ID of the service request created.
+	ServiceNotice    string   `json:"service_notice"`     // Information about the action expected to fulfill the request or otherwise address the information reported
+	AccountID        string   `json:"account_id"`         // Unique ID for the user account of the person submitting the request
+	Warnings         []string `json:"warnings,omitempty"` // Problems with the request that did not prevent it being accepted
 }
 
 type UserResponse struct {
@@ -114,10 +148,13 @@ type UserResponse struct {
 }
 
 type User struct {
-	AccountID         string   `json:"account_id"`            // Unique ID of Open311 User
-	Groups            []string `json:"group_ids"`             // Slice of agencies or groups to which a user belongs
-	SubmittedRequests []string `json:"submitted_request_ids"` // Slice of requests user has made
-	WatchedRequests   []string `json:"watched_request_ids"`   // Slice of request user is watching
+	AccountID         string                  `json:"account_id"`               // Unique ID of Open311 User
+	CityID            string                  `json:"city_id,omitempty"`        // City the user first made a request to
+	Groups            []string                `json:"group_ids"`                // Slice of agencies or groups to which a user belongs
+	SubmittedRequests []string                `json:"submitted_request_ids"`    // Slice of requests user has made
+	WatchedRequests   []string                `json:"watched_request_ids"`      // Slice of request user is watching
+	PushEndpoints     []string                `json:"push_endpoints"`           // SNS platform endpoint ARNs of the user's devices
+	Preferences       NotificationPreferences `json:"notification_preferences"` // Channels through which the user wants to be notified
 }
 
 type Feedback struct {
@@ -133,8 +170,38 @@ type FeedbackResponse struct {
 }
 
 type City struct {
-	CityName string `json:"city_name"`
-	Endpoint string `json:"endpoint"`
+	CityName    string `json:"city_name"`
+	Endpoint    string `json:"endpoint"`
+	MediaBucket string `json:"media_bucket"` // Bucket holding the city's media. Empty when the shared images bucket is used
+	SenderEmail string `json:"sender_email"` // Verified SES identity the city's email is sent from. Empty when the platform sender is used
+	LogoURL     string `json:"logo_url"`     // Logo shown in the city's email notifications
+	BrandColor  string `json:"brand_color"`  // Hex color, eg "#1d4f91", available to the city's notification templates
+	PlaceIndex  string `json:"place_index"`  // Amazon Location Service place index addresses in the city are looked up in. Empty uses the deployment's
+
+	SMSDailyQuota int `json:"sms_daily_quota"` // Text messages the city may send per day. 0 uses the deployment default
+
+	MediaArchiveDays   int `json:"media_archive_days"`   // Days after a request closes before its media moves to Glacier. 0 uses the deployment default
+	MediaRetentionDays int `json:"media_retention_days"` // Days after a request closes before its media is deleted. 0 keeps media forever
+
+	Boundary    geo.MultiPolygon `json:"boundary,omitempty"` // City limits. Requests located outside them are refused when set
+	BoundingBox []float64        `json:"bbox,omitempty"`     // minLon, minLat, maxLon, maxLat of the area the city serves. Locates cities without a boundary
+
+	Config CityConfig `json:"config"` // Settings the city tunes for itself
+
+	Federated                bool   `json:"federated"`                         // Requests and services of the city are served by the GeoReport v2 server at Endpoint
+	FederationJurisdictionID string `json:"federation_jurisdiction_id"`        // jurisdiction_id the city's server expects, if any
+	FederationAPIKey         string `json:"-" dynamodbav:"federation_api_key"` // API key requests are submitted to the city's server with. Never returned by the API
+
+	PortalAPIKey string `json:"-" dynamodbav:"portal_api_key"` // Credentials of the city's open-data portal, "keyID:keySecret" for Socrata. Never returned by the API
+
+	Deactivated        bool   `json:"deactivated"`         // Paused, eg during a contract lapse or maintenance. Reads are served but submissions are refused
+	DeactivationNotice string `json:"deactivation_notice"` // Shown to residents whose submissions are refused while deactivated
+
+	Routing []RoutingRule `json:"routing,omitempty"` // Rules assigning requests to the city's agencies in place of their services' group
+
+	Neighborhoods []Neighborhood `json:"neighborhoods,omitempty"` // Neighborhoods or wards requests are tagged with and ranked by
+
+	Region string `json:"region,omitempty"` // AWS region holding the city's tables and media bucket. Empty for AwsRegion
 }
 
 type OnboardingRequest struct {
@@ -145,6 +212,12 @@ type OnboardingRequest struct {
 	LastName  string `json:"last_name"`
 	Email     string `json:"email"`
 	Feedback  string `json:"feedback"`
+	Status    string `json:"status"`    // Where the request is in the onboarding lifecycle, eg "received"
+	CityName  string `json:"city_name"` // city_name of the City provisioned for the request once approved
+
+	Assignee        string `json:"assignee"`         // Member of the platform team handling the request
+	Notes           string `json:"notes"`            // The platform team's notes on the request
+	UpdatedDateTime string `json:"updated_datetime"` // When the platform team last changed the request
 }
 
 type OnboardingResponse struct {
@@ -167,6 +240,24 @@ func (e *RequestIdNotFoundErr) Error() string {
 	return e.message
 }
 
+// RequestIdConflictErr is returned when a request is made with the service_request_id of a request already stored
+type RequestIdConflictErr struct {
+	message string
+}
+
+func (e *RequestIdConflictErr) Error() string {
+	return e.message
+}
+
+// RequestChangedErr is returned when a request is updated from a copy read before it last changed
+type RequestChangedErr struct {
+	message string
+}
+
+func (e *RequestChangedErr) Error() string {
+	return e.message
+}
+
 type CityNotFoundErr struct {
 	message string
 }
@@ -192,9 +283,15 @@ func (e *UserIDAlreadyExistsErr) Error() string {
 }
 
 // GetServices provides a list of acceptable 311 service request types and their associated service codes.
-// These request types can be unique to the city/jurisdiction.
-func GetServices() ([]Service, error) {
-	return allServices()
+// These request types can be unique to the city/jurisdiction.  An empty cityID returns the services of every city.
+func GetServices(cityID string) ([]Service, error) {
+	if cityID == "" {
+		return allServices()
+	}
+
+	services := []Service{}
+	err := queryCity(ServicesTable, cityID, &services)
+	return services, err
 }
 
 func allServices() ([]Service, error) {
@@ -238,7 +335,11 @@ func GetService(code string) (Service, error) {
 	if err != nil {
 		return Service{}, err
 	}
+	return getService(svc, code)
+}
 
+// getService looks up a service in the Services table svc reaches
+func getService(svc *dynamodb.DynamoDB, code string) (Service, error) {
 	input := &dynamodb.GetItemInput{
 		TableName: aws.String(ServicesTable),
 		Key: map[string]*dynamodb.AttributeValue{
@@ -267,25 +368,30 @@ func GetService(code string) (Service, error) {
 	return service, err
 }
 
-// GetRequests returns slice of all Open311 Requests in DynamoBD Requests Table
-func GetRequests() ([]Request, error) {
-	return allRequests()
+// GetRequests returns slice of the Open311 Requests of a city in DynamoBD Requests Table.  An empty cityID returns
+// the requests of every city.
+func GetRequests(cityID string) ([]Request, error) {
+	if cityID == "" {
+		return allRequests()
+	}
+
+	requests := []Request{}
+	err := queryCity(RequestsTable, cityID, &requests)
+	return requests, err
 }
 
+// allRequests scans for the requests of every city, in parallel segments
 func allRequests() ([]Request, error) {
 	svc, err := createDynamoClient()
 	if err != nil {
 		return []Request{}, err
 	}
 
-	// Build the query input parameters
 	params := &dynamodb.ScanInput{
 		TableName: aws.String(RequestsTable),
 	}
 
-	// Make the DynamoDB Query API call
-	// TODO handle pagination
-	result, err := svc.Scan(params)
+	all, err := scanAll(svc, params)
 	if err != nil {
 		return nil, fmt.Errorf("repository: unable to get all requests from database with the following parameters: %+v. \n %s", params, err)
 	}
@@ -293,7 +399,7 @@ func allRequests() ([]Request, error) {
 	requests := []Request{}
 
 	// for each request, unmarshal and add to slice of all requests
-	for _, i := range result.Items {
+	for _, i := range all {
 		request := Request{}
 		err = dynamodbattribute.UnmarshalMap(i, &request)
 		if err != nil {
@@ -305,14 +411,101 @@ func allRequests() ([]Request, error) {
 	return requests, err
 }
 
-// GetRequest takes a service_request_id, looks up that request in DynamoDB and returns the corresponding
-// Open311 Request struct.  If the service_request_id is not in the database, a RequestIdNotFoundErr error is set
-func GetRequest(id string) (Request, error) {
+// GetRequestsBetween returns the Open311 Requests of a city made from start to end, inclusive, oldest first.  They are
+// read from the CityIndex, which is sorted by requested_datetime, rather than filtered out of every request.  An
+// empty cityID returns the requests of every city made in the range.
+func GetRequestsBetween(cityID string, start time.Time, end time.Time) ([]Request, error) {
+	if cityID == "" {
+		return allRequestsBetween(start, end)
+	}
+	return queryRequestsBetween(cityID, start, end, "")
+}
+
+// queryRequestsBetween reads the requests of a city made from start to end from the CityIndex, with only the
+// attributes of projection, or all of them when it is empty.  A zero end leaves the range open.
+func queryRequestsBetween(cityID string, start time.Time, end time.Time, projection string) ([]Request, error) {
+	svc, err := createCityClient(cityID)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(RequestsTable),
+		IndexName:              aws.String(CityIndex),
+		KeyConditionExpression: aws.String("city_id = :c AND requested_datetime >= :s"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":c": {S: aws.String(cityID)},
+			":s": {S: aws.String(start.UTC().Format(time.RFC3339))},
+		},
+	}
+	if !end.IsZero() {
+		input.KeyConditionExpression = aws.String("city_id = :c AND requested_datetime BETWEEN :s AND :e")
+		input.ExpressionAttributeValues[":e"] = &dynamodb.AttributeValue{S: aws.String(end.UTC().Format(time.RFC3339))}
+	}
+	if projection != "" {
+		input.ProjectionExpression = aws.String(projection)
+	}
+
+	all := []map[string]*dynamodb.AttributeValue{}
+	err = svc.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		all = append(all, page.Items...)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("repository: unable to get requests of %s made since %s. \n %s", cityID, start, err)
+	}
+
+	requests := []Request{}
+	err = dynamodbattribute.UnmarshalListOfMaps(all, &requests)
+	if err != nil {
+		return nil, fmt.Errorf("repository: Failed to unmarshal requests of %s. \n %s", cityID, err)
+	}
+	return requests, nil
+}
+
+// allRequestsBetween scans for the requests of every city made from start to end, for deployments without a city
+func allRequestsBetween(start time.Time, end time.Time) ([]Request, error) {
 	svc, err := createDynamoClient()
+	if err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName:        aws.String(RequestsTable),
+		FilterExpression: aws.String("requested_datetime BETWEEN :s AND :e"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":s": {S: aws.String(start.UTC().Format(time.RFC3339))},
+			":e": {S: aws.String(end.UTC().Format(time.RFC3339))},
+		},
+	}
+
+	all, err := scanAll(svc, input)
+	if err != nil {
+		return nil, fmt.Errorf("repository: unable to get requests made since %s. \n %s", start, err)
+	}
+
+	requests := []Request{}
+	err = dynamodbattribute.UnmarshalListOfMaps(all, &requests)
+	if err != nil {
+		return nil, fmt.Errorf("repository: Failed to unmarshal requests. \n %s", err)
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].RequestedDateTime < requests[j].RequestedDateTime })
+	return requests, nil
+}
+
+// GetRequest takes a service_request_id, looks up that request in the tables of the city it was made in and returns
+// the corresponding Open311 Request struct.  An empty cityID reads the deployment's tables.  If the
+// service_request_id is not in the database, a RequestIdNotFoundErr error is set
+func GetRequest(cityID string, id string) (Request, error) {
+	svc, err := createCityClient(cityID)
 	if err != nil {
 		return Request{}, err
 	}
+	return getRequest(svc, id)
+}
 
+// getRequest looks up a request in the Requests table svc reaches
+func getRequest(svc *dynamodb.DynamoDB, id string) (Request, error) {
 	input := &dynamodb.GetItemInput{
 		TableName: aws.String(RequestsTable),
 		Key: map[string]*dynamodb.AttributeValue{
@@ -341,10 +534,55 @@ func GetRequest(id string) (Request, error) {
 	return request, err
 }
 
+// requestsByIDWorkers is how many batches of requests GetRequestsByID reads at once
+const requestsByIDWorkers = 4
+
+// GetRequestsByID returns the requests with the given service_request_ids in the order given, eg those a user
+// submitted or watches, leaving out requests since deleted and IDs repeated.  Requests are read batchGetSize at a
+// time, several batches at once, rather than a call per request.
+func GetRequestsByID(ids []string) ([]Request, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return nil, err
+	}
+
+	unique := []string{}
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	items, err := batchGet(svc.BatchGetItem, RequestsTable, "service_request_id", unique, nil, requestsByIDWorkers)
+	if err != nil {
+		return nil, err
+	}
+	found := []Request{}
+	err = dynamodbattribute.UnmarshalListOfMaps(items, &found)
+	if err != nil {
+		return nil, fmt.Errorf("repository: Failed to unmarshal requests. \n %s", err)
+	}
+
+	byID := map[string]Request{}
+	for _, request := range found {
+		byID[request.ServiceRequestID] = request
+	}
+	requests := []Request{}
+	for _, id := range unique {
+		if request, ok := byID[id]; ok {
+			requests = append(requests, request)
+		}
+	}
+	return requests, nil
+}
+
 // SubmitRequest initializes a new Open311 request. This function generates a requestID, assigns the request creation time,
 // initializes the request to 'open' sets the service name and group responsible to resolve and stores in DynamoDB requests table.
+// If a request with the ID generated is already stored, a RequestIdConflictErr error is set
 func SubmitRequest(request Request, accountID string) (RequestResponse, error) {
-	svc, err := createDynamoClient()
+	svc, err := createCityClient(request.CityID)
 	if err != nil {
 		return RequestResponse{}, err
 	}
@@ -363,22 +601,57 @@ func SubmitRequest(request Request, accountID string) (RequestResponse, error) {
 	//Initialize new request as "open"
 	request.Status = RequestOpen
 
+	// Remember the submitter so they can be notified of changes
+	request.AccountID = accountID
+
+	// Agencies assign requests to their workers once they have them
+	request.AssignedTo = ""
+
+	setGeohash(&request)
+
 	// Initialize service name and group responsible to resolve
-	service, _ := GetService(request.ServiceCode)
+	service, err := LookupService(request.CityID, request.ServiceCode)
+	if err != nil {
+		return RequestResponse{}, err
+	}
 	request.ServiceName = service.ServiceName
-	request.AgencyResponsible = service.Group
+
+	city := City{}
+	if request.CityID != "" {
+		city, err = GetCity(request.CityID)
+		if _, ok := err.(*CityNotFoundErr); err != nil && !ok {
+			return RequestResponse{}, err
+		}
+	}
+	err = assignAgency(&request, service, city.Routing)
+	if err != nil {
+		return RequestResponse{}, err
+	}
+	setQueue(&request)
+	setZipArea(&request)
+	request.SchemaVersion = requestSchemaVersion
+
+	// Requests under a service level agreement are expected to be resolved within it, in the city's business hours
+	if slaHours := city.Config.SLAHours(service); slaHours > 0 && request.ExpectedDateTime == "" {
+		request.ExpectedDateTime = city.Config.Due(t, slaHours).Format(time.RFC3339)
+	}
 
 	av, err := dynamodbattribute.MarshalMap(request)
 	if err != nil {
 		return RequestResponse{}, fmt.Errorf("repository: Failed to marshal request:\n %+v. \n  %s", request, err)
 	}
 
+	// A new request never replaces one already stored
 	input := &dynamodb.PutItemInput{
-		Item:      av,
-		TableName: aws.String(RequestsTable),
+		Item:                av,
+		TableName:           aws.String(RequestsTable),
+		ConditionExpression: aws.String("attribute_not_exists(service_request_id)"),
 	}
 
 	_, err = svc.PutItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return RequestResponse{}, &RequestIdConflictErr{fmt.Sprintf("request %s already exists", requestID)}
+	}
 	if err != nil {
 		return RequestResponse{}, fmt.Errorf("repository: failed to put new request in database: \n input: %+v. \n %s", input, err)
 	}
@@ -388,7 +661,7 @@ func SubmitRequest(request Request, accountID string) (RequestResponse, error) {
 	response.ServiceRequestID = requestID
 
 	// Add new request to list of requests created by this user
-	_, err = trackUserRequest(requestID, accountID)
+	_, err = trackUserRequest(requestID, accountID, request.CityID)
 	if err != nil {
 		return response, fmt.Errorf("repository: failed to append new request (%s) to list of requests for account: %s\n  %s", requestID, accountID, err)
 	}
@@ -396,8 +669,28 @@ func SubmitRequest(request Request, accountID string) (RequestResponse, error) {
 	return response, err
 }
 
-// trackUserRequest updates the Users table to append a request to the list of requsts a user has created
-func trackUserRequest(requestID string, userID string) (*dynamodb.UpdateItemOutput, error) {
+// assignAgency sets the agency responsible for a new request, following its city's routing rules, and the
+// departments it sits under
+func assignAgency(request *Request, service Service, rules []RoutingRule) error {
+	request.AgencyResponsible = Route(rules, service)
+	if request.AgencyResponsible == "" {
+		return nil
+	}
+
+	chain, err := GetAgencyChain(request.AgencyResponsible)
+	if _, ok := err.(*AgencyNotFoundErr); ok {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	request.AgencyPath = AgencyPath(chain)
+	return nil
+}
+
+// trackUserRequest updates the Users table to append a request to the list of requsts a user has created.  Users
+// without a city are given the city of the request.
+func trackUserRequest(requestID string, userID string, cityID string) (*dynamodb.UpdateItemOutput, error) {
 	svc, err := createDynamoClient()
 	if err != nil {
 		return nil, err
@@ -437,6 +730,10 @@ func trackUserRequest(requestID string, userID string) (*dynamodb.UpdateItemOutp
 		TableName:        aws.String(UsersTable),
 		UpdateExpression: aws.String("SET #SR = list_append(if_not_exists(#SR, :empty_list), :r)"),
 	}
+	if cityID != "" {
+		input.ExpressionAttributeValues[":c"] = &dynamodb.AttributeValue{S: aws.String(cityID)}
+		input.UpdateExpression = aws.String("SET #SR = list_append(if_not_exists(#SR, :empty_list), :r), city_id = if_not_exists(city_id, :c)")
+	}
 
 	result, err := svc.UpdateItem(input)
 	if err != nil {
@@ -447,9 +744,13 @@ func trackUserRequest(requestID string, userID string) (*dynamodb.UpdateItemOutp
 
 }
 
-// UpdateRequest takes an existing request and updates the DynamoDB with the new values after setting the 'UpdatedDateTime'
-func UpdateRequest(request Request, accountID string) (RequestResponse, error) {
-	svc, err := createDynamoClient()
+// UpdateRequest takes an existing request and updates the DynamoDB with the new values after setting the 'UpdatedDateTime'.
+// stored is the request as the caller read it, before changing it; the update is only written if the request hasn't
+// changed since, by its version, so concurrent updates and comments can't silently undo each other.  The request is written to the tables of its
+// city.  If the request isn't stored, a RequestIdNotFoundErr error is set, and if it changed since it was read, a
+// RequestChangedErr error.
+func UpdateRequest(request Request, stored Request, accountID string) (RequestResponse, error) {
+	svc, err := createCityClient(request.CityID)
 	if err != nil {
 		return RequestResponse{}, err
 	}
@@ -457,18 +758,38 @@ func UpdateRequest(request Request, accountID string) (RequestResponse, error) {
 	// Set last updated time
 	t := time.Now()
 	request.UpdatedDateTime = t.Format(time.RFC3339)
+	setGeohash(&request)
+
+	// Updates replace the whole request, so when it was closed is taken from the stored request rather than trusted
+	setResolution(&request, stored, t)
+	setQueue(&request)
+	setZipArea(&request)
+	request.SchemaVersion = requestSchemaVersion
+	request.Version = stored.Version + 1
+	// Clients don't know the work order a request became, so it is kept rather than cleared
+	if request.WorkOrderID == "" {
+		request.WorkOrderID = stored.WorkOrderID
+	}
 
 	av, err := dynamodbattribute.MarshalMap(request)
 	if err != nil {
 		return RequestResponse{}, fmt.Errorf("repository: Failed to marshal request:\n %+v. \n  %s", request, err)
 	}
 
+	// A request deleted since it was read isn't brought back, nor is one changed since overwritten
 	input := &dynamodb.PutItemInput{
 		Item:      av,
 		TableName: aws.String(RequestsTable),
 	}
+	setVersionCondition(input, stored)
 
 	_, err = svc.PutItem(input)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		if _, err := getRequest(svc, request.ServiceRequestID); err != nil {
+			return RequestResponse{}, err
+		}
+		return RequestResponse{}, &RequestChangedErr{fmt.Sprintf("request %s was changed since it was read. Read it again before updating it", request.ServiceRequestID)}
+	}
 	if err != nil {
 		return RequestResponse{}, fmt.Errorf("repository: failed to put new request in database: \n input: %+v. \n %s", input, err)
 	}
@@ -480,6 +801,81 @@ func UpdateRequest(request Request, accountID string) (RequestResponse, error) {
 	return response, err
 }
 
+// versionUpdate is the update expression clause every write to a stored request adds, so that updates from copies
+// read before the write are refused.  It uses the #V name placeholder for the version attribute.
+const versionUpdate = "ADD #V :one"
+
+// setVersionCondition conditions the write of an update on the request being stored at the version it was read.
+// Requests not written since they were made, or since versions were kept, have none.
+func setVersionCondition(input *dynamodb.PutItemInput, stored Request) {
+	input.ExpressionAttributeNames = map[string]*string{"#V": aws.String("version")}
+	if stored.Version == 0 {
+		input.ConditionExpression = aws.String("attribute_exists(service_request_id) AND attribute_not_exists(#V)")
+		return
+	}
+	input.ConditionExpression = aws.String("attribute_exists(service_request_id) AND #V = :v")
+	input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+		":v": {N: aws.String(fmt.Sprint(stored.Version))},
+	}
+}
+
+// setResolution records when a request being updated at t was closed, and how long it took.  A request stays closed
+// at the time it was first closed however often it is edited afterwards, and reopening it clears both.
+func setResolution(request *Request, stored Request, t time.Time) {
+	if request.Status != RequestClosed {
+		request.ClosedDateTime = ""
+		request.ResolutionHours = 0
+		return
+	}
+	if stored.Status == RequestClosed && stored.ClosedDateTime != "" {
+		request.ClosedDateTime = stored.ClosedDateTime
+		request.ResolutionHours = stored.ResolutionHours
+		return
+	}
+
+	request.ClosedDateTime = t.UTC().Format(time.RFC3339)
+	request.ResolutionHours = 0
+	requested, err := time.Parse(time.RFC3339, stored.RequestedDateTime)
+	if err == nil {
+		request.ResolutionHours = math.Round(t.Sub(requested).Hours()*100) / 100
+	}
+}
+
+// RecordEscalation notes that a request of a city has been escalated to supervisors for breaching its SLA
+func RecordEscalation(cityID string, requestID string, level int) error {
+	svc, err := createCityClient(cityID)
+	if err != nil {
+		return err
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#L": aws.String("escalation_level"),
+			"#T": aws.String("escalated_datetime"),
+			"#V": aws.String("version"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":l":   {N: aws.String(fmt.Sprint(level))},
+			":t":   {S: aws.String(time.Now().Format(time.RFC3339))},
+			":one": {N: aws.String("1")},
+		},
+		Key: map[string]*dynamodb.AttributeValue{
+			"service_request_id": {
+				S: aws.String(requestID),
+			},
+		},
+		TableName:        aws.String(RequestsTable),
+		UpdateExpression: aws.String("SET #L = :l, #T = :t " + versionUpdate),
+	}
+
+	_, err = svc.UpdateItem(input)
+	if err != nil {
+		return fmt.Errorf("repository: failed to record escalation of request %s. \n  %s", requestID, err)
+	}
+
+	return nil
+}
+
 // GetUser takes a user's AccountID, looks up that user in DynamoDB and returns the corresponding
 // User struct.  If the requested AccountID is not in the database, an AccountIDNotFoundErr error is set
 func GetUser(accountID string) (User, error) {
@@ -516,57 +912,16 @@ func GetUser(accountID string) (User, error) {
 	return user, err
 }
 
-// createDynamoClient is a convenience function to establish a session with AWS and
-// returns a new instance of the DynamoDB client
-func createDynamoClient() (*dynamodb.DynamoDB, error) {
-
-	// Initial credentials loaded from SDK's default credential chain. Such as
-	// the environment, shared credentials (~/.aws/credentials), or EC2 Instance
-	// Role.
-
-	// Create the session that the DynamoDB service will use.
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(AwsRegion)},
-	)
-	if err != nil {
-		return nil, fmt.Errorf("\n repository: unable to establish session with AWS \n  %s", err)
-	}
-
-	// Create DynamoDB client
-	svc := dynamodb.New(sess)
-
-	return svc, nil
-}
-
-func IsValidServiceCode(ServiceCode string) bool {
-	svc, err := createDynamoClient()
-	if err != nil {
-		// TODO send this to os.Stderr so the AWS cloudwatch logs pick it up
-		fmt.Printf("\nERROR: repository/IsValidServiceCode: unable to establish session with AWS \n  %s", err)
-		return false
-	}
-
-	input := &dynamodb.GetItemInput{
-		TableName: aws.String(ServicesTable),
-		Key: map[string]*dynamodb.AttributeValue{
-			"service_code": {
-				S: aws.String(ServiceCode),
-			},
-		},
-	}
-	response, err := svc.GetItem(input)
-	if err != nil {
+// IsValidServiceCode reports whether a service code exists.  When cityID is not empty, the service must also be
+// offered by that city.
+func IsValidServiceCode(cityID string, ServiceCode string) bool {
+	_, err := LookupService(cityID, ServiceCode)
+	if _, ok := err.(*ServiceCodeNotFoundErr); err != nil && !ok {
 		// TODO send this to os.Stderr so the AWS cloudwatch logs pick it up
 		fmt.Printf("\nERROR: repository: "+
 			"Query API call failed while checking if Service Code was valid. \n   %s", err)
 	}
-
-	// If there is no matching item, GetItem does not return any data and there will be no Item element in the response.
-	if response.Item == nil {
-		return false
-	}
-
-	return true
+	return err == nil
 }
 
 func genRequestID() (string, error) {
@@ -580,12 +935,23 @@ func genRequestID() (string, error) {
 	return reqID, nil
 }
 
+// genID returns a new ULID string, for records that are not Open311 requests
+func genID() (string, error) {
+	t := time.Now().UTC()
+	entropy := rand.New(rand.NewSource(t.UnixNano()))
+	id, err := ulid.New(ulid.Timestamp(t), entropy)
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
 func GetCities() ([]City, error) {
 	return allCities()
 }
 
 func allCities() ([]City, error) {
-	svc, err := createDynamoClient()
+	svc, err := createDirectoryClient()
 	if err != nil {
 		return []City{}, err
 	}
@@ -618,7 +984,7 @@ func allCities() ([]City, error) {
 }
 
 func GetCity(id string) (City, error) {
-	svc, err := createDynamoClient()
+	svc, err := createDirectoryClient()
 	if err != nil {
 		return City{}, err
 	}
@@ -665,6 +1031,9 @@ func AddOnboardingRequest(request OnboardingRequest, accountID string) (Onboardi
 		return OnboardingResponse{}, fmt.Errorf("repository: failed to generate unique id for  request. \n  %s", err)
 	}
 	request.ID = id.String()
+	request.Status = OnboardingReceived
+	request.Assignee = ""
+	request.Notes = ""
 
 	av, err := dynamodbattribute.MarshalMap(request)
 	if err != nil {
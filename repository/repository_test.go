@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+func TestSetResolution(t *testing.T) {
+	at := time.Date(2019, 6, 3, 0, 30, 0, 0, time.UTC)
+	open := Request{Status: RequestOpen, RequestedDateTime: "2019-06-01T12:00:00Z"}
+
+	closing := Request{Status: RequestClosed, RequestedDateTime: "2019-06-02T12:00:00Z"}
+	setResolution(&closing, open, at)
+	if closing.ClosedDateTime != "2019-06-03T00:30:00Z" || closing.ResolutionHours != 36.5 {
+		t.Errorf("setResolution() of closing request = %s, %g, want closed after 36.5 hours from the stored request", closing.ClosedDateTime, closing.ResolutionHours)
+	}
+
+	// Edited after it was closed
+	edited := Request{Status: RequestClosed}
+	setResolution(&edited, closing, at.Add(48*time.Hour))
+	if edited.ClosedDateTime != closing.ClosedDateTime || edited.ResolutionHours != 36.5 {
+		t.Errorf("setResolution() of closed request = %s, %g, want it kept", edited.ClosedDateTime, edited.ResolutionHours)
+	}
+
+	reopened := Request{Status: RequestOpen, ClosedDateTime: closing.ClosedDateTime, ResolutionHours: 36.5}
+	setResolution(&reopened, closing, at)
+	if reopened.ClosedDateTime != "" || reopened.ResolutionHours != 0 {
+		t.Errorf("setResolution() of reopened request = %s, %g, want both cleared", reopened.ClosedDateTime, reopened.ResolutionHours)
+	}
+}
+
+// Updates are written only over the version of the request they were made from
+func TestSetVersionCondition(t *testing.T) {
+	input := &dynamodb.PutItemInput{}
+	setVersionCondition(input, Request{})
+	if aws.StringValue(input.ConditionExpression) != "attribute_exists(service_request_id) AND attribute_not_exists(#V)" || input.ExpressionAttributeValues != nil {
+		t.Errorf("setVersionCondition() of a request never written = %s, %v", aws.StringValue(input.ConditionExpression), input.ExpressionAttributeValues)
+	}
+
+	input = &dynamodb.PutItemInput{}
+	setVersionCondition(input, Request{Version: 3})
+	if aws.StringValue(input.ConditionExpression) != "attribute_exists(service_request_id) AND #V = :v" || aws.StringValue(input.ExpressionAttributeValues[":v"].N) != "3" {
+		t.Errorf("setVersionCondition() of version 3 = %s, %v", aws.StringValue(input.ConditionExpression), input.ExpressionAttributeValues)
+	}
+	if aws.StringValue(input.ExpressionAttributeNames["#V"]) != "version" {
+		t.Errorf("setVersionCondition() names = %v, want #V for version", input.ExpressionAttributeNames)
+	}
+
+	// The version is stored, and never sent to clients
+	av, _ := dynamodbattribute.MarshalMap(Request{Version: 3})
+	body, _ := json.Marshal(Request{Version: 3})
+	if aws.StringValue(av["version"].N) != "3" || strings.Contains(string(body), "version") {
+		t.Errorf("Request{Version: 3} = %v in DynamoDB and %s in JSON", av["version"], body)
+	}
+}
+
+// Updates never create requests: an update of an unknown ID is refused as not found, and one of a request changed
+// since it was read as changed
+func TestUpdateRequestConditions(t *testing.T) {
+	update := Request{ServiceRequestID: "SR-404", Status: RequestClosed}
+
+	defer fakeDynamo(t, map[string]string{"PutItem": conditionFailed, "GetItem": "200 {}"})()
+	if _, err := UpdateRequest(update, Request{}, "guest"); err == nil {
+		t.Error("UpdateRequest() of an unknown ID should set an error")
+	} else if _, ok := err.(*RequestIdNotFoundErr); !ok {
+		t.Errorf("UpdateRequest() of an unknown ID = %T %s, want a RequestIdNotFoundErr", err, err)
+	}
+
+	defer fakeDynamo(t, map[string]string{"PutItem": conditionFailed, "GetItem": `200 {"Item": {"service_request_id": {"S": "SR-404"}, "version": {"N": "2"}}}`})()
+	if _, err := UpdateRequest(update, Request{Version: 1}, "guest"); err == nil {
+		t.Error("UpdateRequest() of a changed request should set an error")
+	} else if _, ok := err.(*RequestChangedErr); !ok {
+		t.Errorf("UpdateRequest() of a changed request = %T %s, want a RequestChangedErr", err, err)
+	}
+
+	defer fakeDynamo(t, map[string]string{"PutItem": "200 {}"})()
+	if response, err := UpdateRequest(update, Request{}, "guest"); err != nil || response.ServiceRequestID != "SR-404" {
+		t.Errorf("UpdateRequest() = %+v, %v, want the request updated", response, err)
+	}
+}
+
+func TestLookupService(t *testing.T) {
+	defer forgetService("troy-pothole")
+
+	// A cached service is served without reading the table
+	cachedServices["troy-pothole"] = cachedService{Service{ServiceCode: "troy-pothole", ServiceName: "Pothole", CityID: "Troy"}, time.Now().Add(serviceTTL)}
+	if service, err := LookupService("Troy", "troy-pothole"); err != nil || service.ServiceName != "Pothole" {
+		t.Errorf("LookupService() = %+v, %v, want the cached Pothole service", service, err)
+	}
+	if service, err := LookupService("", "troy-pothole"); err != nil || service.ServiceName != "Pothole" {
+		t.Errorf("LookupService() unscoped = %+v, %v, want the cached Pothole service", service, err)
+	}
+	if _, err := LookupService("Albany", "troy-pothole"); err == nil {
+		t.Error("LookupService() of another city's service should fail")
+	} else if _, ok := err.(*ServiceCodeNotFoundErr); !ok {
+		t.Errorf("LookupService() of another city's service error = %T, want *ServiceCodeNotFoundErr", err)
+	}
+
+	forgetService("troy-pothole")
+	if _, ok := cachedServices["troy-pothole"]; ok {
+		t.Error("forgetService() left the service cached")
+	}
+}
+
+// fakeDynamo serves the DynamoDB client of the deployment's tables from a fake DynamoDB, answering each operation,
+// eg "PutItem", with the status and body of responses.  The function returned restores the client.
+func fakeDynamo(t *testing.T, responses map[string]string) func() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+		response, ok := responses[operation]
+		if !ok {
+			t.Errorf("unexpected DynamoDB call %s", operation)
+			response = "500 {}"
+		}
+		status, body := response[:3], response[4:]
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		if status == "400" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		w.Write([]byte(body))
+	}))
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String(DataRegion()),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	}))
+	clientsMu.Lock()
+	previous, cached := clients[DataRegion()]
+	clients[DataRegion()] = dynamodb.New(sess)
+	clientsMu.Unlock()
+	return func() {
+		server.Close()
+		clientsMu.Lock()
+		defer clientsMu.Unlock()
+		delete(clients, DataRegion())
+		if cached {
+			clients[DataRegion()] = previous
+		}
+	}
+}
+
+const conditionFailed = `400 {"__type": "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException", "message": "The conditional request failed"}`
@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Full-table reads, such as exports of every city's requests and backfills, scan a table in segments read in
+// parallel rather than page after page, which took minutes on large tables.
+const (
+	DefaultScanSegments = 8  // Segments of a full-table read when SCAN_SEGMENTS is not set
+	MaxScanSegments     = 64 // Most segments a full-table read is split into
+	DefaultScanWorkers  = 8  // Segments read at once when SCAN_WORKERS is not set
+)
+
+// ScanSegments returns how many segments full-table reads are split into: SCAN_SEGMENTS, else DefaultScanSegments,
+// up to MaxScanSegments
+func ScanSegments() int {
+	return scanSetting("SCAN_SEGMENTS", DefaultScanSegments, MaxScanSegments)
+}
+
+// ScanWorkers returns how many segments of a full-table read are read at once: SCAN_WORKERS, else
+// DefaultScanWorkers, up to the segments there are.  Fewer workers spare the table's read capacity.
+func ScanWorkers(segments int) int {
+	return scanSetting("SCAN_WORKERS", DefaultScanWorkers, segments)
+}
+
+// scanSetting reads a positive setting from the environment, capped at max
+func scanSetting(name string, value int, max int) int {
+	if n, err := strconv.Atoi(os.Getenv(name)); err == nil && n > 0 {
+		value = n
+	}
+	if value > max {
+		value = max
+	}
+	return value
+}
+
+// scanFunc reads every page of a scan, as (*dynamodb.DynamoDB).ScanPages does
+type scanFunc func(input *dynamodb.ScanInput, fn func(page *dynamodb.ScanOutput, lastPage bool) bool) error
+
+// parallelScan reads every page of a scan in segments, with a pool of workers each reading a segment at a time.
+// visit is called with the items of each page from several workers at once, so it must be safe for concurrent
+// use.  The first error, of visit or of reading a segment, stops every worker at its next page and is returned.
+func parallelScan(scan scanFunc, input *dynamodb.ScanInput, segments int, workers int, visit func(items []map[string]*dynamodb.AttributeValue) error) error {
+	var (
+		mu       sync.Mutex
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+
+	queue := make(chan int, segments)
+	for segment := 0; segment < segments; segment++ {
+		queue <- segment
+	}
+	close(queue)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for segment := range queue {
+				if failed() {
+					return
+				}
+
+				segmentInput := *input
+				segmentInput.Segment = aws.Int64(int64(segment))
+				segmentInput.TotalSegments = aws.Int64(int64(segments))
+				err := scan(&segmentInput, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+					if failed() {
+						return false
+					}
+					if err := visit(page.Items); err != nil {
+						fail(err)
+						return false
+					}
+					return true
+				})
+				if err != nil {
+					fail(fmt.Errorf("repository: unable to scan segment %d of %s. \n %s", segment, aws.StringValue(input.TableName), err))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// scanAll reads every item of a scan, in ScanSegments segments read in parallel.  Items come in no particular order.
+func scanAll(svc *dynamodb.DynamoDB, input *dynamodb.ScanInput) ([]map[string]*dynamodb.AttributeValue, error) {
+	var mu sync.Mutex
+	all := []map[string]*dynamodb.AttributeValue{}
+
+	segments := ScanSegments()
+	err := parallelScan(svc.ScanPages, input, segments, ScanWorkers(segments), func(items []map[string]*dynamodb.AttributeValue) error {
+		mu.Lock()
+		defer mu.Unlock()
+		all = append(all, items...)
+		return nil
+	})
+	return all, err
+}
+
+// scanEach calls visit with every item of a scan, from ScanSegments segments read in parallel, so visit must be safe
+// for concurrent use.  The first error visit returns stops the scan and is returned.
+func scanEach(svc *dynamodb.DynamoDB, input *dynamodb.ScanInput, visit func(item map[string]*dynamodb.AttributeValue) error) error {
+	segments := ScanSegments()
+	return parallelScan(svc.ScanPages, input, segments, ScanWorkers(segments), func(items []map[string]*dynamodb.AttributeValue) error {
+		for _, item := range items {
+			if err := visit(item); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// fakeScan serves pages pages of one item per segment, naming each item by its segment and page
+func fakeScan(pages int, fail int) scanFunc {
+	return func(input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool) error {
+		segment := aws.Int64Value(input.Segment)
+		if int(segment) == fail {
+			return errors.New("throttled")
+		}
+		for p := 0; p < pages; p++ {
+			item := map[string]*dynamodb.AttributeValue{
+				"id": {S: aws.String(strconv.FormatInt(segment, 10) + "/" + strconv.Itoa(p))},
+			}
+			if !fn(&dynamodb.ScanOutput{Items: []map[string]*dynamodb.AttributeValue{item}}, p == pages-1) {
+				return nil
+			}
+		}
+		return nil
+	}
+}
+
+func TestParallelScan(t *testing.T) {
+	var mu sync.Mutex
+	seen := map[string]bool{}
+	input := &dynamodb.ScanInput{TableName: aws.String(RequestsTable)}
+	err := parallelScan(fakeScan(3, -1), input, 8, 4, func(items []map[string]*dynamodb.AttributeValue) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, item := range items {
+			seen[aws.StringValue(item["id"].S)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("parallelScan() error = %s", err)
+	}
+	if len(seen) != 24 {
+		t.Errorf("parallelScan() visited %d items, want 24", len(seen))
+	}
+	if input.Segment != nil || input.TotalSegments != nil {
+		t.Error("parallelScan() changed the input it was given")
+	}
+}
+
+func TestParallelScanStops(t *testing.T) {
+	input := &dynamodb.ScanInput{TableName: aws.String(RequestsTable)}
+	err := parallelScan(fakeScan(3, 5), input, 8, 2, func(items []map[string]*dynamodb.AttributeValue) error { return nil })
+	if err == nil {
+		t.Error("parallelScan() ignored a failed segment")
+	}
+
+	visitErr := errors.New("unmarshal")
+	var mu sync.Mutex
+	visits := 0
+	err = parallelScan(fakeScan(100, -1), input, 4, 4, func(items []map[string]*dynamodb.AttributeValue) error {
+		mu.Lock()
+		defer mu.Unlock()
+		visits++
+		return visitErr
+	})
+	if err != visitErr {
+		t.Errorf("parallelScan() error = %v, want %v", err, visitErr)
+	}
+	if visits > 4 {
+		t.Errorf("parallelScan() visited %d pages after an error, want each worker to stop", visits)
+	}
+}
+
+func TestScanSettings(t *testing.T) {
+	defer os.Setenv("SCAN_SEGMENTS", os.Getenv("SCAN_SEGMENTS"))
+	defer os.Setenv("SCAN_WORKERS", os.Getenv("SCAN_WORKERS"))
+
+	tests := []struct {
+		segments string
+		workers  string
+		want     int
+		wantWork int
+	}{
+		{"", "", DefaultScanSegments, DefaultScanWorkers},
+		{"16", "2", 16, 2},
+		{"1000", "", MaxScanSegments, DefaultScanWorkers},
+		{"2", "", 2, 2},
+		{"-3", "zero", DefaultScanSegments, DefaultScanWorkers},
+	}
+	for _, tt := range tests {
+		os.Setenv("SCAN_SEGMENTS", tt.segments)
+		os.Setenv("SCAN_WORKERS", tt.workers)
+		segments := ScanSegments()
+		if segments != tt.want {
+			t.Errorf("ScanSegments() with %q = %d, want %d", tt.segments, segments, tt.want)
+		}
+		if got := ScanWorkers(segments); got != tt.wantWork {
+			t.Errorf("ScanWorkers(%d) with %q = %d, want %d", segments, tt.workers, got, tt.wantWork)
+		}
+	}
+}
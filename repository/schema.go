@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// A migration upgrades a stored item, in place, from the schema version it is registered for to the next.  Listings
+// read items projected without their schema_version, which are taken to be of version 0, so a migration must leave
+// the attributes already in the newer form alone.
+type migration func(item map[string]*dynamodb.AttributeValue) error
+
+// requestMigrations upgrades request items: requestMigrations[v] takes an item of version v to v+1.  Items written
+// before schema_version was stored are of version 0.  A change to how requests are stored adds a migration here and
+// bumps requestSchemaVersion, rather than rewriting the table.
+var requestMigrations = []migration{
+	// 1: coordinates are numbers, and ZIP codes strings listed under their zip_area
+	func(item map[string]*dynamodb.AttributeValue) error {
+		for _, name := range []string{"lat", "lon"} {
+			if av := item[name]; av != nil && av.S != nil {
+				var c Coordinate
+				if err := c.parse(*av.S); err != nil {
+					return err
+				}
+				item[name] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatFloat(float64(c), 'f', -1, 64))}
+			}
+		}
+
+		av := item["zipcode"]
+		if av == nil || av.N == nil {
+			return nil
+		}
+		zipCode, ok := numberZipCode(*av.N)
+		if !ok {
+			warningLogger.Printf("Stored ZIP code %s is not five digits; reading it as unknown", *av.N)
+		}
+		delete(item, "zipcode")
+		if zipCode != "" {
+			item["zipcode"] = &dynamodb.AttributeValue{S: aws.String(string(zipCode))}
+			item["zip_area"] = &dynamodb.AttributeValue{S: aws.String(zipCode.Area())}
+		}
+		return nil
+	},
+}
+
+// requestSchemaVersion is the version of the requests written, that of the last of requestMigrations
+const requestSchemaVersion = 1
+
+// migrate returns an item upgraded to the current version by the migrations from the version it was written in.
+// The item is copied before it is upgraded, leaving what was read as it is.  Items of a version newer than the
+// migrations, written by a newer deployment, are returned as they are.
+func migrate(item map[string]*dynamodb.AttributeValue, migrations []migration) (map[string]*dynamodb.AttributeValue, error) {
+	version := 0
+	if av := item["schema_version"]; av != nil && av.N != nil {
+		v, err := strconv.Atoi(*av.N)
+		if err != nil {
+			return nil, fmt.Errorf("repository: invalid schema_version '%s'", *av.N)
+		}
+		version = v
+	}
+	if version >= len(migrations) {
+		return item, nil
+	}
+
+	upgraded := make(map[string]*dynamodb.AttributeValue, len(item)+1)
+	for name, av := range item {
+		upgraded[name] = av
+	}
+	for v := version; v < len(migrations); v++ {
+		if err := migrations[v](upgraded); err != nil {
+			return nil, fmt.Errorf("repository: failed to upgrade item from schema version %d \n %s", v, err)
+		}
+	}
+	upgraded["schema_version"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(len(migrations)))}
+	return upgraded, nil
+}
+
+// storedRequest is a Request without its methods, for decoding one without calling UnmarshalDynamoDBAttributeValue
+// again
+type storedRequest Request
+
+// UnmarshalDynamoDBAttributeValue reads a stored request, upgrading items written in the form of an earlier schema
+// version.  The upgrade is lazy: the item stays as it is until the request is next written, in the current form.
+func (r *Request) UnmarshalDynamoDBAttributeValue(av *dynamodb.AttributeValue) error {
+	if av.M == nil {
+		return dynamodbattribute.Unmarshal(av, (*storedRequest)(r))
+	}
+
+	item, err := migrate(av.M, requestMigrations)
+	if err != nil {
+		return err
+	}
+	return dynamodbattribute.UnmarshalMap(item, (*storedRequest)(r))
+}
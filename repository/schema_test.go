@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+func TestRequestSchemaVersion(t *testing.T) {
+	if len(requestMigrations) != requestSchemaVersion {
+		t.Errorf("requestSchemaVersion = %d, want %d, the version of the last migration", requestSchemaVersion, len(requestMigrations))
+	}
+}
+
+// Requests stored before schema_version, with string coordinates and numeric ZIP codes, are read in the current form
+func TestMigrateRequest(t *testing.T) {
+	item := map[string]*dynamodb.AttributeValue{
+		"service_request_id": {S: aws.String("SR-1")},
+		"lat":                {S: aws.String("42.7")},
+		"lon":                {N: aws.String("-73.7")},
+		"zipcode":            {N: aws.String("1040")},
+	}
+
+	request := Request{}
+	if err := dynamodbattribute.UnmarshalMap(item, &request); err != nil {
+		t.Fatal(err)
+	}
+	if request.Latitude != 42.7 || request.Longitude != -73.7 || request.ZipCode != "01040" || request.ZipArea != "01040" {
+		t.Errorf("UnmarshalMap() = %+v, want the coordinates, ZIP code and its area", request)
+	}
+	if request.SchemaVersion != requestSchemaVersion {
+		t.Errorf("UnmarshalMap() schema version = %d, want %d", request.SchemaVersion, requestSchemaVersion)
+	}
+	if item["lat"].S == nil || item["zipcode"].N == nil || item["zip_area"] != nil || item["schema_version"] != nil {
+		t.Errorf("UnmarshalMap() changed the item read to %v", item)
+	}
+
+	// Written back, the request is stored in the current form
+	av, err := dynamodbattribute.MarshalMap(request)
+	if err != nil || av["lat"].N == nil || av["zipcode"].S == nil || aws.StringValue(av["schema_version"].N) != "1" {
+		t.Errorf("MarshalMap() = %v, %v, want numeric coordinates, a string ZIP code and the schema version", av, err)
+	}
+
+	// Listings decode every item, with the migrations, and those already in the current form are left alone
+	items := []map[string]*dynamodb.AttributeValue{item, av, {"zipcode": {S: aws.String("12180-4321")}}}
+	requests := []Request{}
+	if err := dynamodbattribute.UnmarshalListOfMaps(items, &requests); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 3 || requests[0].ZipCode != "01040" || requests[1].ZipCode != "01040" || requests[2].ZipCode != "12180-4321" {
+		t.Errorf("UnmarshalListOfMaps() = %+v", requests)
+	}
+
+	item["lat"] = &dynamodb.AttributeValue{S: aws.String("north")}
+	if err := dynamodbattribute.UnmarshalMap(item, &Request{}); err == nil {
+		t.Error("UnmarshalMap() of an invalid coordinate should fail")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	calls := 0
+	migrations := []migration{
+		func(item map[string]*dynamodb.AttributeValue) error { calls++; return nil },
+		func(item map[string]*dynamodb.AttributeValue) error { calls += 10; return nil },
+	}
+
+	tests := map[string]int{"": 11, "1": 10, "2": 0, "3": 0}
+	for version, want := range tests {
+		calls = 0
+		item := map[string]*dynamodb.AttributeValue{}
+		if version != "" {
+			item["schema_version"] = &dynamodb.AttributeValue{N: aws.String(version)}
+		}
+		if _, err := migrate(item, migrations); err != nil || calls != want {
+			t.Errorf("migrate() of version %q = %v, ran %d, want %d", version, err, calls, want)
+		}
+	}
+
+	// Items of a version newer than the migrations know are read as they are
+	newer := map[string]*dynamodb.AttributeValue{"schema_version": {N: aws.String("3")}}
+	if got, _ := migrate(newer, migrations); aws.StringValue(got["schema_version"].N) != "3" {
+		t.Errorf("migrate() of a newer item = %v", got)
+	}
+}
@@ -0,0 +1,180 @@
+package repository
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+type ServiceCodeAlreadyExistsErr struct {
+	message string
+}
+
+func (e *ServiceCodeAlreadyExistsErr) Error() string {
+	return e.message
+}
+
+// serviceTTL is how long a service looked up to submit requests is cached in the container.  Changes made through
+// other containers, eg a renamed service, reach the requests submitted through this one within it.
+const serviceTTL = time.Minute
+
+type cachedService struct {
+	service Service
+	expires time.Time
+}
+
+var (
+	servicesMu     sync.Mutex
+	cachedServices = map[string]cachedService{}
+)
+
+// LookupService returns a service, read at most once per serviceTTL in the container.  When cityID is not empty,
+// the service must also be offered by that city.  If it is not, or the service code is not in the database, a
+// ServiceCodeNotFoundErr error is set
+func LookupService(cityID string, code string) (Service, error) {
+	servicesMu.Lock()
+	cached, ok := cachedServices[code]
+	servicesMu.Unlock()
+
+	if !ok || time.Now().After(cached.expires) {
+		svc, err := createCityClient(cityID)
+		if err != nil {
+			return Service{}, err
+		}
+		// Unknown codes aren't cached, so a service is offered as soon as it is added
+		service, err := getService(svc, code)
+		if err != nil {
+			return Service{}, err
+		}
+
+		cached = cachedService{service, time.Now().Add(serviceTTL)}
+		servicesMu.Lock()
+		cachedServices[code] = cached
+		servicesMu.Unlock()
+	}
+
+	if !inCity(cityID, cached.service.CityID) {
+		return Service{}, &ServiceCodeNotFoundErr{"service not found"}
+	}
+	return cached.service, nil
+}
+
+// forgetService drops a changed service from the container's cache
+func forgetService(code string) {
+	servicesMu.Lock()
+	delete(cachedServices, code)
+	servicesMu.Unlock()
+}
+
+// AddService adds a service to the catalog.  Service codes are unique across cities; if the code is taken, a
+// ServiceCodeAlreadyExistsErr error is set
+func AddService(service Service) error {
+	return putService(service, "attribute_not_exists(service_code)", &ServiceCodeAlreadyExistsErr{"service code already exists"})
+}
+
+// AddServices adds the services of a city to the catalog BatchWriteSize at a time, setting their city_id.  Services
+// whose code is taken are left alone, so a catalog can be applied again.  It returns how many services were added.
+func AddServices(cityID string, services []Service) (int, error) {
+	svc, err := createCityClient(cityID)
+	if err != nil {
+		return 0, err
+	}
+
+	codes := []string{}
+	for _, service := range services {
+		codes = append(codes, service.ServiceCode)
+	}
+	taken, err := storedKeys(svc.BatchGetItem, ServicesTable, "service_code", codes)
+	if err != nil {
+		return 0, err
+	}
+
+	items := []map[string]*dynamodb.AttributeValue{}
+	for _, service := range services {
+		if taken[service.ServiceCode] {
+			continue
+		}
+		// A batch may not put the same item twice
+		taken[service.ServiceCode] = true
+
+		service.CityID = cityID
+		av, err := dynamodbattribute.MarshalMap(service)
+		if err != nil {
+			return 0, fmt.Errorf("repository: Failed to marshal service:\n %+v. \n  %s", service, err)
+		}
+		items = append(items, av)
+	}
+
+	added, err := batchWrite(svc.BatchWriteItem, ServicesTable, items)
+	if err != nil {
+		return added, fmt.Errorf("repository: failed to put services of %s in database. \n %s", cityID, err)
+	}
+	return added, nil
+}
+
+// UpdateService replaces a service in the catalog.  If the service code is not in the database, a
+// ServiceCodeNotFoundErr error is set
+func UpdateService(service Service) error {
+	return putService(service, "attribute_exists(service_code)", &ServiceCodeNotFoundErr{"service not found"})
+}
+
+// putService writes a service on condition, returning conditionErr when the condition fails
+func putService(service Service, condition string, conditionErr error) error {
+	svc, err := createCityClient(service.CityID)
+	if err != nil {
+		return err
+	}
+
+	av, err := dynamodbattribute.MarshalMap(service)
+	if err != nil {
+		return fmt.Errorf("repository: Failed to marshal service:\n %+v. \n  %s", service, err)
+	}
+
+	_, err = svc.PutItem(&dynamodb.PutItemInput{
+		Item:                av,
+		TableName:           aws.String(ServicesTable),
+		ConditionExpression: aws.String(condition),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return conditionErr
+		}
+		return fmt.Errorf("repository: failed to put service %s in database. \n %s", service.ServiceCode, err)
+	}
+
+	forgetService(service.ServiceCode)
+	return nil
+}
+
+// DeleteService removes a service from the catalog.  Requests already made for it keep its name and group.  If
+// the service code is not in the database, a ServiceCodeNotFoundErr error is set
+func DeleteService(code string) error {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(ServicesTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"service_code": {
+				S: aws.String(code),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(service_code)"),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return &ServiceCodeNotFoundErr{"service not found"}
+		}
+		return fmt.Errorf("repository: failed to delete service %s. \n %s", code, err)
+	}
+
+	forgetService(code)
+	return nil
+}
@@ -0,0 +1,600 @@
+package repository
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// ResolutionBuckets are the upper bounds, in hours, of the histogram of how long requests took to close.  Medians
+// are estimated from the histogram, so the stats never need to read the requests themselves.
+var ResolutionBuckets = []float64{1, 4, 8, 24, 48, 72, 168, 336, 720, math.Inf(1)}
+
+// DailyStats are the pre-aggregated counters of a city's requests on one day, kept in the Counters table by the
+// stream processor
+type DailyStats struct {
+	Day      string           // YYYY-MM-DD in the city's time zone
+	Opened   int64            // Requests submitted
+	Closed   int64            // Requests closed
+	Services map[string]int64 // Requests submitted, by service code
+	Agencies map[string]int64 // Requests submitted, by the agency they were assigned to. UnassignedAgency for none
+	Statuses map[string]int64 // Requests entering each status, including the status they were submitted in
+	Resolved []int64          // Requests closed, by the ResolutionBuckets their time to close fell in
+	Cells    map[string]int64 // Requests submitted with a location, by CellServiceKey of their geo_cell and service code
+	Signups  int64            // Residents who signed up
+	Watches  map[string]int64 // Subscriptions residents added, by type
+
+	Neighborhoods         map[string]int64 // Requests submitted, by the id of the city neighborhood they were located in
+	NeighborhoodsResolved map[string]int64 // Requests closed, by the id of their neighborhood
+
+	ServiceResolved map[string][]int64 // Resolved, by service code
+	AgencyResolved  map[string][]int64 // Resolved, by the agency responsible when the request closed
+}
+
+// UnassignedAgency is the agency stats count requests assigned to no agency under
+const UnassignedAgency = "unassigned"
+
+// CityStats summarizes a city's requests over a period
+type CityStats struct {
+	CityID                string         `json:"city_id"`
+	Start                 string         `json:"start"`                   // First day of the period, YYYY-MM-DD in the city's time zone
+	End                   string         `json:"end"`                     // Last day of the period
+	Opened                int64          `json:"opened"`                  // Requests submitted during the period
+	Closed                int64          `json:"closed"`                  // Requests closed during the period
+	Open                  int64          `json:"open"`                    // Requests open now
+	MedianResolutionHours float64        `json:"median_resolution_hours"` // Estimated median time to close requests closed during the period. 0 when none were
+	P90ResolutionHours    float64        `json:"p90_resolution_hours"`    // Estimated time within which 90% of them were closed
+	TopServices           []ServiceCount `json:"top_services"`            // Services most requested during the period, most first
+}
+
+// ServiceCount is the number of requests for a service
+type ServiceCount struct {
+	ServiceCode string `json:"service_code"`
+	Count       int64  `json:"count"`
+}
+
+// Counter attribute names of a DailyStats item
+const (
+	statOpened         = "opened"
+	statClosed         = "closed"
+	statServicePrefix  = "service:"
+	statAgencyPrefix   = "agency:"
+	statStatusPrefix   = "status:"
+	statResolvedPrefix = "resolved:"
+
+	statServiceResolvedPrefix = "service_resolved:" // service_resolved:<service code>:<bucket bound>
+	statAgencyResolvedPrefix  = "agency_resolved:"  // agency_resolved:<agency_id>:<bucket bound>
+	statCellPrefix            = "cell:"             // cell:<geo_cell>:<service code>
+	statSignups               = "signups"
+	statWatchPrefix           = "watch:" // watch:<subscription type>
+
+	statNeighborhoodPrefix         = "neighborhood:"          // neighborhood:<neighborhood id>
+	statNeighborhoodResolvedPrefix = "neighborhood_resolved:" // neighborhood_resolved:<neighborhood id>
+)
+
+// CellServiceKey is the key of DailyStats.Cells counting requests for a service in a geo cell
+func CellServiceKey(cell string, serviceCode string) string {
+	return cell + ":" + serviceCode
+}
+
+// ResolutionBucket returns the index in ResolutionBuckets of a time to close
+func ResolutionBucket(hours float64) int {
+	return sort.SearchFloat64s(ResolutionBuckets, hours)
+}
+
+// Add adds the counters of other to s
+func (s *DailyStats) Add(other DailyStats) {
+	s.Opened += other.Opened
+	s.Closed += other.Closed
+	s.Services = addCounts(s.Services, other.Services)
+	s.Agencies = addCounts(s.Agencies, other.Agencies)
+	s.Statuses = addCounts(s.Statuses, other.Statuses)
+	s.Cells = addCounts(s.Cells, other.Cells)
+	s.Signups += other.Signups
+	s.Watches = addCounts(s.Watches, other.Watches)
+	s.Neighborhoods = addCounts(s.Neighborhoods, other.Neighborhoods)
+	s.NeighborhoodsResolved = addCounts(s.NeighborhoodsResolved, other.NeighborhoodsResolved)
+	s.Resolved = addHistogram(s.Resolved, other.Resolved)
+	s.ServiceResolved = addHistograms(s.ServiceResolved, other.ServiceResolved)
+	s.AgencyResolved = addHistograms(s.AgencyResolved, other.AgencyResolved)
+}
+
+// addHistogram adds the buckets of other to resolved, returning resolved
+func addHistogram(resolved []int64, other []int64) []int64 {
+	for i, n := range other {
+		for len(resolved) <= i {
+			resolved = append(resolved, 0)
+		}
+		resolved[i] += n
+	}
+	return resolved
+}
+
+// addHistograms adds the histograms of other to histograms by key, returning histograms
+func addHistograms(histograms map[string][]int64, other map[string][]int64) map[string][]int64 {
+	for key, resolved := range other {
+		if histograms == nil {
+			histograms = map[string][]int64{}
+		}
+		histograms[key] = addHistogram(histograms[key], resolved)
+	}
+	return histograms
+}
+
+// addCounts adds the counts of other to counts, returning counts
+func addCounts(counts map[string]int64, other map[string]int64) map[string]int64 {
+	for key, n := range other {
+		if counts == nil {
+			counts = map[string]int64{}
+		}
+		counts[key] += n
+	}
+	return counts
+}
+
+// counters returns the non-zero counters of s by attribute name
+func (s DailyStats) counters() map[string]int64 {
+	counters := map[string]int64{}
+	if s.Opened != 0 {
+		counters[statOpened] = s.Opened
+	}
+	if s.Closed != 0 {
+		counters[statClosed] = s.Closed
+	}
+	if s.Signups != 0 {
+		counters[statSignups] = s.Signups
+	}
+	prefixed := map[string]map[string]int64{statServicePrefix: s.Services, statAgencyPrefix: s.Agencies, statStatusPrefix: s.Statuses, statCellPrefix: s.Cells, statWatchPrefix: s.Watches,
+		statNeighborhoodPrefix: s.Neighborhoods, statNeighborhoodResolvedPrefix: s.NeighborhoodsResolved}
+	for prefix, counts := range prefixed {
+		for key, n := range counts {
+			if n != 0 {
+				counters[prefix+key] = n
+			}
+		}
+	}
+	histograms := map[string][]int64{statResolvedPrefix: s.Resolved}
+	for code, resolved := range s.ServiceResolved {
+		histograms[statServiceResolvedPrefix+code+":"] = resolved
+	}
+	for agency, resolved := range s.AgencyResolved {
+		histograms[statAgencyResolvedPrefix+agency+":"] = resolved
+	}
+	for prefix, resolved := range histograms {
+		for i, n := range resolved {
+			if n != 0 && i < len(ResolutionBuckets) {
+				counters[prefix+fmt.Sprint(ResolutionBuckets[i])] = n
+			}
+		}
+	}
+	return counters
+}
+
+// resolutionCounter splits the name of a per-service or per-agency resolution counter, eg
+// "service_resolved:pothole:24", into the service or agency and the index of its bucket
+func resolutionCounter(name string, prefix string) (string, int, bool) {
+	rest := strings.TrimPrefix(name, prefix)
+	sep := strings.LastIndex(rest, ":")
+	if sep < 1 {
+		return "", 0, false
+	}
+	i, ok := resolutionBucketOf(rest[sep+1:])
+	return rest[:sep], i, ok
+}
+
+// resolutionBucketOf returns the index in ResolutionBuckets of a bucket's bound as counter names write it
+func resolutionBucketOf(bound string) (int, bool) {
+	b, err := strconv.ParseFloat(bound, 64)
+	if err != nil {
+		return 0, false
+	}
+	i := ResolutionBucket(b)
+	return i, i < len(ResolutionBuckets) && ResolutionBuckets[i] == b
+}
+
+// dailyStats reads the counters of a DailyStats item
+func dailyStats(day string, item map[string]*dynamodb.AttributeValue) DailyStats {
+	s := DailyStats{
+		Day:      day,
+		Services: map[string]int64{},
+		Agencies: map[string]int64{},
+		Statuses: map[string]int64{},
+		Cells:    map[string]int64{},
+		Watches:  map[string]int64{},
+		Resolved: make([]int64, len(ResolutionBuckets)),
+
+		Neighborhoods:         map[string]int64{},
+		NeighborhoodsResolved: map[string]int64{},
+
+		ServiceResolved: map[string][]int64{},
+		AgencyResolved:  map[string][]int64{},
+	}
+	for name, v := range item {
+		if v.N == nil {
+			continue
+		}
+		n, err := strconv.ParseInt(aws.StringValue(v.N), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case name == statOpened:
+			s.Opened = n
+		case name == statClosed:
+			s.Closed = n
+		case name == statSignups:
+			s.Signups = n
+		case strings.HasPrefix(name, statServicePrefix):
+			s.Services[strings.TrimPrefix(name, statServicePrefix)] = n
+		case strings.HasPrefix(name, statAgencyPrefix):
+			s.Agencies[strings.TrimPrefix(name, statAgencyPrefix)] = n
+		case strings.HasPrefix(name, statStatusPrefix):
+			s.Statuses[strings.TrimPrefix(name, statStatusPrefix)] = n
+		case strings.HasPrefix(name, statCellPrefix):
+			s.Cells[strings.TrimPrefix(name, statCellPrefix)] = n
+		case strings.HasPrefix(name, statWatchPrefix):
+			s.Watches[strings.TrimPrefix(name, statWatchPrefix)] = n
+		case strings.HasPrefix(name, statNeighborhoodPrefix):
+			s.Neighborhoods[strings.TrimPrefix(name, statNeighborhoodPrefix)] = n
+		case strings.HasPrefix(name, statNeighborhoodResolvedPrefix):
+			s.NeighborhoodsResolved[strings.TrimPrefix(name, statNeighborhoodResolvedPrefix)] = n
+		case strings.HasPrefix(name, statResolvedPrefix):
+			if i, ok := resolutionBucketOf(strings.TrimPrefix(name, statResolvedPrefix)); ok {
+				s.Resolved[i] = n
+			}
+		case strings.HasPrefix(name, statServiceResolvedPrefix):
+			if code, i, ok := resolutionCounter(name, statServiceResolvedPrefix); ok {
+				s.ServiceResolved[code] = addHistogram(s.ServiceResolved[code], bucket(i, n))
+			}
+		case strings.HasPrefix(name, statAgencyResolvedPrefix):
+			if agency, i, ok := resolutionCounter(name, statAgencyResolvedPrefix); ok {
+				s.AgencyResolved[agency] = addHistogram(s.AgencyResolved[agency], bucket(i, n))
+			}
+		}
+	}
+	return s
+}
+
+// bucket returns a resolution histogram counting n in bucket i
+func bucket(i int, n int64) []int64 {
+	resolved := make([]int64, len(ResolutionBuckets))
+	resolved[i] = n
+	return resolved
+}
+
+// statsCounterID is the counter_id of a city's DailyStats for a day
+func statsCounterID(cityID string, day string) string {
+	return "stats#" + cityID + "#" + day
+}
+
+// openCounterID is the counter_id of the count of a city's open requests
+func openCounterID(cityID string) string {
+	return "stats#" + cityID + "#open"
+}
+
+// statusCounterID is the counter_id of the counts of a city's requests in each status
+func statusCounterID(cityID string) string {
+	return "stats#" + cityID + "#status"
+}
+
+// AddDailyStats atomically adds counters to a city's stats for a day
+func AddDailyStats(cityID string, stats DailyStats) error {
+	err := addCounters(cityID, statsCounterID(cityID, stats.Day), stats.counters())
+	if err != nil {
+		return fmt.Errorf("repository: failed to add stats of %s on %s. \n  %s", cityID, stats.Day, err)
+	}
+	return nil
+}
+
+// AddStatusCounts atomically adds deltas, by status, to the counts of a city's requests in each status
+func AddStatusCounts(cityID string, deltas map[string]int64) error {
+	counters := map[string]int64{}
+	for status, n := range deltas {
+		if n != 0 {
+			counters[statStatusPrefix+status] = n
+		}
+	}
+
+	err := addCounters(cityID, statusCounterID(cityID), counters)
+	if err != nil {
+		return fmt.Errorf("repository: failed to add status counts of %s. \n  %s", cityID, err)
+	}
+	return nil
+}
+
+// GetStatusCounts returns how many of a city's requests are in each status now
+func GetStatusCounts(cityID string) (map[string]int64, error) {
+	svc, err := createCityClient(cityID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := svc.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(CountersTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"counter_id": {
+				S: aws.String(statusCounterID(cityID)),
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("repository: unable to get status counts of %s. \n  %s", cityID, err)
+	}
+	return dailyStats("", result.Item).Statuses, nil
+}
+
+// addCounters atomically adds counters, by attribute name, to a counter item of a city's Counters table
+func addCounters(cityID string, counterID string, counters map[string]int64) error {
+	if len(counters) == 0 {
+		return nil
+	}
+
+	svc, err := createCityClient(cityID)
+	if err != nil {
+		return err
+	}
+
+	var adds []string
+	names := map[string]*string{}
+	values := map[string]*dynamodb.AttributeValue{}
+	i := 0
+	for name, n := range counters {
+		adds = append(adds, fmt.Sprintf("#c%d :c%d", i, i))
+		names[fmt.Sprintf("#c%d", i)] = aws.String(name)
+		values[fmt.Sprintf(":c%d", i)] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(n, 10))}
+		i++
+	}
+
+	_, err = svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(CountersTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"counter_id": {
+				S: aws.String(counterID),
+			},
+		},
+		UpdateExpression:          aws.String("ADD " + strings.Join(adds, ", ")),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	return err
+}
+
+// AddOpenRequests adds delta to the count of a city's open requests
+func AddOpenRequests(cityID string, delta int64) error {
+	svc, err := createCityClient(cityID)
+	if err != nil {
+		return err
+	}
+	_, err = incrementCounter(svc, openCounterID(cityID), delta)
+	return err
+}
+
+// GetOpenRequests returns the count of a city's open requests
+func GetOpenRequests(cityID string) (int64, error) {
+	svc, err := createCityClient(cityID)
+	if err != nil {
+		return 0, err
+	}
+	return getCounter(svc, openCounterID(cityID))
+}
+
+// GetDailyStats returns a city's stats for each of days.  Days without requests have zero counters.
+func GetDailyStats(cityID string, days []string) ([]DailyStats, error) {
+	svc, err := createCityClient(cityID)
+	if err != nil {
+		return nil, err
+	}
+
+	items := map[string]map[string]*dynamodb.AttributeValue{}
+
+	// BatchGetItem reads at most 100 keys per call, and may leave some unprocessed when throttled
+	const maxKeys = 100
+	for start := 0; start < len(days); start += maxKeys {
+		end := start + maxKeys
+		if end > len(days) {
+			end = len(days)
+		}
+
+		keys := []map[string]*dynamodb.AttributeValue{}
+		for _, day := range days[start:end] {
+			keys = append(keys, map[string]*dynamodb.AttributeValue{
+				"counter_id": {S: aws.String(statsCounterID(cityID, day))},
+			})
+		}
+
+		request := map[string]*dynamodb.KeysAndAttributes{CountersTable: {Keys: keys}}
+		for len(request) > 0 {
+			result, err := svc.BatchGetItem(&dynamodb.BatchGetItemInput{RequestItems: request})
+			if err != nil {
+				return nil, fmt.Errorf("repository: unable to get stats of %s. \n  %s", cityID, err)
+			}
+			for _, item := range result.Responses[CountersTable] {
+				items[aws.StringValue(item["counter_id"].S)] = item
+			}
+			request = result.UnprocessedKeys
+		}
+	}
+
+	stats := []DailyStats{}
+	for _, day := range days {
+		stats = append(stats, dailyStats(day, items[statsCounterID(cityID, day)]))
+	}
+	return stats, nil
+}
+
+// SummarizeStats totals a city's daily stats over a period, listing at most top services
+func SummarizeStats(days []DailyStats, top int) CityStats {
+	total := DailyStats{Resolved: make([]int64, len(ResolutionBuckets))}
+	for _, day := range days {
+		total.Add(day)
+	}
+
+	summary := CityStats{
+		Opened:                total.Opened,
+		Closed:                total.Closed,
+		MedianResolutionHours: percentileHours(total.Resolved, 0.5),
+		P90ResolutionHours:    percentileHours(total.Resolved, 0.9),
+		TopServices:           []ServiceCount{},
+	}
+	if len(days) > 0 {
+		summary.Start = days[0].Day
+		summary.End = days[len(days)-1].Day
+	}
+
+	for code, n := range total.Services {
+		summary.TopServices = append(summary.TopServices, ServiceCount{code, n})
+	}
+	sort.Slice(summary.TopServices, func(i, j int) bool {
+		a, b := summary.TopServices[i], summary.TopServices[j]
+		return a.Count > b.Count || a.Count == b.Count && a.ServiceCode < b.ServiceCode
+	})
+	if len(summary.TopServices) > top {
+		summary.TopServices = summary.TopServices[:top]
+	}
+	return summary
+}
+
+// Dimensions request stats are grouped by
+const (
+	StatsByStatus  = "status"
+	StatsByService = "service_code"
+	StatsByAgency  = "agency"
+)
+
+// RequestStats are the counts of a city's requests over a period, grouped by a dimension
+type RequestStats struct {
+	CityID  string       `json:"city_id"`
+	GroupBy string       `json:"group_by"`
+	Start   string       `json:"start"` // First day of the period, YYYY-MM-DD in the city's time zone
+	End     string       `json:"end"`   // Last day of the period
+	Total   int64        `json:"total"` // Sum of the counts of every group
+	Groups  []StatsGroup `json:"groups"`
+}
+
+// StatsGroup counts the requests of one group.  Groups by service_code and agency count the requests submitted
+// during the period; groups by status count the requests entering the status during it, and how many are in it now.
+type StatsGroup struct {
+	Key     string  `json:"key"`
+	Count   int64   `json:"count"`
+	Share   float64 `json:"share"`             // Fraction of the total
+	Current *int64  `json:"current,omitempty"` // Requests in the status now, for groups by status
+
+	Resolution *Resolution `json:"resolution,omitempty"` // Time to close the group's requests closed during the period, for groups by service_code and agency
+}
+
+// Resolution summarizes how long requests took to close
+type Resolution struct {
+	Closed      int64   `json:"closed"`       // Requests closed
+	MedianHours float64 `json:"median_hours"` // Estimated median time to close. 0 when none were closed
+	P90Hours    float64 `json:"p90_hours"`    // Estimated time within which 90% were closed
+}
+
+// resolution summarizes a resolution time histogram
+func resolution(resolved []int64) *Resolution {
+	r := &Resolution{MedianHours: percentileHours(resolved, 0.5), P90Hours: percentileHours(resolved, 0.9)}
+	for _, n := range resolved {
+		r.Closed += n
+	}
+	return r
+}
+
+// GroupStats totals a city's daily stats over a period by a dimension, largest group first.  current holds the
+// counts of requests in each status now, added to groups by status; statuses with requests in them are listed even
+// when none entered them during the period.
+func GroupStats(days []DailyStats, groupBy string, current map[string]int64) RequestStats {
+	total := DailyStats{}
+	for _, day := range days {
+		total.Add(day)
+	}
+
+	stats := RequestStats{GroupBy: groupBy, Groups: []StatsGroup{}}
+	if len(days) > 0 {
+		stats.Start = days[0].Day
+		stats.End = days[len(days)-1].Day
+	}
+
+	counts := map[string]int64{}
+	var resolved map[string][]int64
+	switch groupBy {
+	case StatsByStatus:
+		counts = addCounts(counts, total.Statuses)
+		for status, n := range current {
+			if _, ok := counts[status]; !ok && n > 0 {
+				counts[status] = 0
+			}
+		}
+	case StatsByService:
+		counts = addCounts(counts, total.Services)
+		resolved = total.ServiceResolved
+	case StatsByAgency:
+		counts = addCounts(counts, total.Agencies)
+		resolved = total.AgencyResolved
+	}
+	// Groups whose requests were closed, but none submitted, during the period
+	for key := range resolved {
+		if _, ok := counts[key]; !ok {
+			counts[key] = 0
+		}
+	}
+
+	for key, n := range counts {
+		stats.Total += n
+		group := StatsGroup{Key: key, Count: n}
+		switch groupBy {
+		case StatsByStatus:
+			now := current[key]
+			group.Current = &now
+		case StatsByService, StatsByAgency:
+			group.Resolution = resolution(resolved[key])
+		}
+		stats.Groups = append(stats.Groups, group)
+	}
+	for i := range stats.Groups {
+		if stats.Total > 0 {
+			stats.Groups[i].Share = float64(stats.Groups[i].Count) / float64(stats.Total)
+		}
+	}
+	sort.Slice(stats.Groups, func(i, j int) bool {
+		a, b := stats.Groups[i], stats.Groups[j]
+		return a.Count > b.Count || a.Count == b.Count && a.Key < b.Key
+	})
+	return stats
+}
+
+// percentileHours estimates the time within which a fraction q of requests closed from a resolution time
+// histogram, interpolating within the bucket holding it.  Percentiles in the open ended last bucket are reported as
+// its lower bound.
+func percentileHours(resolved []int64, q float64) float64 {
+	var count int64
+	for _, n := range resolved {
+		count += n
+	}
+	if count == 0 {
+		return 0
+	}
+
+	target := float64(count) * q
+	var below int64
+	for i, n := range resolved {
+		if n == 0 || float64(below+n) < target {
+			below += n
+			continue
+		}
+
+		lower := 0.0
+		if i > 0 {
+			lower = ResolutionBuckets[i-1]
+		}
+		if i >= len(ResolutionBuckets) || math.IsInf(ResolutionBuckets[i], 1) {
+			return lower
+		}
+		return lower + (ResolutionBuckets[i]-lower)*(target-float64(below))/float64(n)
+	}
+	return 0
+}
@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestResolutionBucket(t *testing.T) {
+	tests := map[float64]int{0.5: 0, 1: 0, 2: 1, 24: 3, 100: 6, 720: 8, 5000: 9}
+	for hours, want := range tests {
+		if got := ResolutionBucket(hours); got != want {
+			t.Errorf("ResolutionBucket(%g) = %d, want %d", hours, got, want)
+		}
+	}
+}
+
+func TestDailyStatsCounters(t *testing.T) {
+	resolved := make([]int64, len(ResolutionBuckets))
+	resolved[3], resolved[9] = 2, 1
+	stats := DailyStats{Day: "2019-06-02", Opened: 5, Closed: 3, Services: map[string]int64{"pothole": 4, "graffiti": 1}, Resolved: resolved,
+		Cells: map[string]int64{CellServiceKey("dreg0", "pothole"): 2}, Signups: 2, Watches: map[string]int64{"area": 1}}
+
+	counters := stats.counters()
+	if len(counters) != 9 || counters["cell:dreg0:pothole"] != 2 || counters["signups"] != 2 || counters["watch:area"] != 1 || counters["service:pothole"] != 4 || counters["resolved:24"] != 2 || counters["resolved:+Inf"] != 1 {
+		t.Errorf("counters() = %v", counters)
+	}
+
+	// Read back as DynamoDB returns them
+	item := map[string]*dynamodb.AttributeValue{"counter_id": {S: aws.String("stats#Troy#2019-06-02")}}
+	for name, n := range counters {
+		item[name] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(n, 10))}
+	}
+	got := dailyStats("2019-06-02", item)
+	if got.Opened != 5 || got.Closed != 3 || got.Services["graffiti"] != 1 || got.Resolved[3] != 2 || got.Resolved[9] != 1 || got.Cells["dreg0:pothole"] != 2 ||
+		got.Signups != 2 || got.Watches["area"] != 1 {
+		t.Errorf("dailyStats() = %+v, want %+v", got, stats)
+	}
+}
+
+func TestResolutionCounters(t *testing.T) {
+	stats := DailyStats{
+		ServiceResolved: map[string][]int64{"pothole": {0, 0, 0, 2}},
+		AgencyResolved:  map[string][]int64{"streets": {0, 0, 0, 1, 0, 0, 0, 0, 0, 1}},
+	}
+
+	counters := stats.counters()
+	if len(counters) != 3 || counters["service_resolved:pothole:24"] != 2 || counters["agency_resolved:streets:+Inf"] != 1 {
+		t.Errorf("counters() = %v", counters)
+	}
+
+	item := map[string]*dynamodb.AttributeValue{}
+	for name, n := range counters {
+		item[name] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(n, 10))}
+	}
+	item["service_resolved:pothole:25"] = &dynamodb.AttributeValue{N: aws.String("9")}
+	got := dailyStats("2019-06-02", item)
+	if got.ServiceResolved["pothole"][3] != 2 || got.AgencyResolved["streets"][3] != 1 || got.AgencyResolved["streets"][9] != 1 {
+		t.Errorf("dailyStats() = %+v, want %+v", got, stats)
+	}
+	if total := got.ServiceResolved["pothole"]; total[3]+total[4] != 2 {
+		t.Errorf("dailyStats() read a bucket that isn't one: %v", total)
+	}
+}
+
+func TestSummarizeStats(t *testing.T) {
+	resolved := func(bucket int, n int64) []int64 {
+		r := make([]int64, len(ResolutionBuckets))
+		r[bucket] = n
+		return r
+	}
+	days := []DailyStats{
+		{Day: "2019-06-01", Opened: 3, Closed: 1, Services: map[string]int64{"pothole": 2, "graffiti": 1}, Resolved: resolved(3, 1)},
+		{Day: "2019-06-02"},
+		{Day: "2019-06-03", Opened: 2, Closed: 3, Services: map[string]int64{"graffiti": 1, "streetlight": 1}, Resolved: resolved(4, 3)},
+	}
+
+	summary := SummarizeStats(days, 2)
+	if summary.Start != "2019-06-01" || summary.End != "2019-06-03" || summary.Opened != 5 || summary.Closed != 4 {
+		t.Errorf("SummarizeStats() = %+v", summary)
+	}
+	if len(summary.TopServices) != 2 || summary.TopServices[0] != (ServiceCount{"graffiti", 2}) || summary.TopServices[1] != (ServiceCount{"pothole", 2}) {
+		t.Errorf("TopServices = %+v, want graffiti then pothole", summary.TopServices)
+	}
+	// Half of the 4 closed requests is reached a third of the way through the 24-48 hour bucket
+	if summary.MedianResolutionHours != 32 {
+		t.Errorf("MedianResolutionHours = %g, want 32", summary.MedianResolutionHours)
+	}
+
+	if empty := SummarizeStats(nil, 5); empty.MedianResolutionHours != 0 || len(empty.TopServices) != 0 {
+		t.Errorf("SummarizeStats() of no days = %+v", empty)
+	}
+}
+
+func TestPercentileHours(t *testing.T) {
+	r := make([]int64, len(ResolutionBuckets))
+	r[9] = 3
+	if got := percentileHours(r, 0.5); got != 720 {
+		t.Errorf("percentileHours() in the last bucket = %g, want its lower bound 720", got)
+	}
+	r[0] = 7
+	if got := percentileHours(r, 0.5); math.Abs(got-5.0/7) > 1e-9 {
+		t.Errorf("percentileHours(0.5) = %g, want 5/7", got)
+	}
+	if got := percentileHours(r, 0.9); got != 720 {
+		t.Errorf("percentileHours(0.9) = %g, want 720", got)
+	}
+	if got := percentileHours(make([]int64, len(ResolutionBuckets)), 0.9); got != 0 {
+		t.Errorf("percentileHours() of no requests = %g, want 0", got)
+	}
+}
+
+func TestGroupStats(t *testing.T) {
+	days := []DailyStats{
+		{Day: "2019-06-01", Statuses: map[string]int64{RequestOpen: 3, RequestClosed: 1}, Agencies: map[string]int64{"streets": 2, UnassignedAgency: 1}},
+		{Day: "2019-06-02", Statuses: map[string]int64{RequestOpen: 1, RequestClosed: 2}, Agencies: map[string]int64{"parks": 1}},
+	}
+	current := map[string]int64{RequestOpen: 5, RequestInProgress: 2, RequestClosed: 40}
+
+	stats := GroupStats(days, StatsByStatus, current)
+	if stats.Start != "2019-06-01" || stats.End != "2019-06-02" || stats.Total != 7 || len(stats.Groups) != 3 {
+		t.Fatalf("GroupStats(status) = %+v", stats)
+	}
+	if g := stats.Groups[0]; g.Key != RequestOpen || g.Count != 4 || g.Share != 4.0/7 || g.Current == nil || *g.Current != 5 {
+		t.Errorf("first group = %+v, want 4 opened of which 5 are open now", g)
+	}
+	// In progress now, though nothing entered it during the period
+	if g := stats.Groups[2]; g.Key != RequestInProgress || g.Count != 0 || *g.Current != 2 {
+		t.Errorf("last group = %+v, want 2 in progress now", g)
+	}
+
+	stats = GroupStats(days, StatsByAgency, nil)
+	if stats.Total != 4 || len(stats.Groups) != 3 || stats.Groups[0].Key != "streets" || stats.Groups[0].Current != nil {
+		t.Errorf("GroupStats(agency) = %+v, want streets first", stats)
+	}
+
+	// Closed during the period, though submitted before it
+	days[1].ServiceResolved = map[string][]int64{"graffiti": {0, 0, 0, 0, 4}}
+	stats = GroupStats(days, StatsByService, nil)
+	if len(stats.Groups) != 1 || stats.Groups[0].Key != "graffiti" || stats.Groups[0].Count != 0 || stats.Groups[0].Resolution.Closed != 4 || stats.Groups[0].Resolution.MedianHours != 36 {
+		t.Errorf("GroupStats(service_code) = %+v, want graffiti closed 4 times", stats)
+	}
+
+	if stats = GroupStats(nil, StatsByService, nil); stats.Total != 0 || len(stats.Groups) != 0 {
+		t.Errorf("GroupStats() of no days = %+v", stats)
+	}
+}
@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// SubscriptionsTable holds what users are watching, beyond the requests they submitted
+const SubscriptionsTable = "Subscriptions"
+
+// SubscriptionAccountIndex is the global secondary index of SubscriptionsTable on account_id
+const SubscriptionAccountIndex = "account_id-index"
+
+// Kinds of subscription
+const (
+	RequestSubscription = "request" // Updates on a single request
+	ServiceSubscription = "service" // Every request of a service type
+	AreaSubscription    = "area"    // Every request within a radius of a point
+)
+
+// Subscription asks for notifications about requests other than a user's own
+type Subscription struct {
+	ID               string  `json:"subscription_id"`
+	AccountID        string  `json:"account_id"`
+	Type             string  `json:"type"`               // One of request, service or area
+	ServiceRequestID string  `json:"service_request_id"` // Request watched by a request subscription
+	ServiceCode      string  `json:"service_code"`       // Service watched by a service subscription
+	Location                 // Centre of an area subscription
+	Radius           float64 `json:"radius"` // Radius in meters of an area subscription
+	Timestamp        string  `json:"timestamp"`
+}
+
+type SubscriptionNotFoundErr struct {
+	message string
+}
+
+func (e *SubscriptionNotFoundErr) Error() string {
+	return e.message
+}
+
+// AddSubscription stores a new subscription, assigning its ID
+func AddSubscription(subscription Subscription) (Subscription, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	id, err := genID()
+	if err != nil {
+		return Subscription{}, fmt.Errorf("repository: failed to generate unique id for subscription. \n  %s", err)
+	}
+	subscription.ID = id
+	subscription.Timestamp = time.Now().Format(time.RFC3339)
+
+	av, err := dynamodbattribute.MarshalMap(subscription)
+	if err != nil {
+		return Subscription{}, fmt.Errorf("repository: Failed to marshal subscription:\n %+v. \n  %s", subscription, err)
+	}
+
+	_, err = svc.PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(SubscriptionsTable),
+	})
+	if err != nil {
+		return Subscription{}, fmt.Errorf("repository: failed to put new subscription in database. \n %s", err)
+	}
+
+	return subscription, nil
+}
+
+// GetSubscriptions returns every subscription, for matching against domain events
+func GetSubscriptions() ([]Subscription, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return []Subscription{}, err
+	}
+
+	params := &dynamodb.ScanInput{
+		TableName: aws.String(SubscriptionsTable),
+	}
+
+	items := []map[string]*dynamodb.AttributeValue{}
+	err = svc.ScanPages(params, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		items = append(items, page.Items...)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("repository: unable to get all subscriptions from database. \n %s", err)
+	}
+
+	subscriptions := []Subscription{}
+	err = dynamodbattribute.UnmarshalListOfMaps(items, &subscriptions)
+	if err != nil {
+		return subscriptions, fmt.Errorf("repository: Failed to unmarshal subscription records. \n %s", err)
+	}
+
+	return subscriptions, nil
+}
+
+// GetUserSubscriptions returns the subscriptions of a single user
+func GetUserSubscriptions(accountID string) ([]Subscription, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return []Subscription{}, err
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(SubscriptionsTable),
+		IndexName:              aws.String(SubscriptionAccountIndex),
+		KeyConditionExpression: aws.String("account_id = :a"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":a": {
+				S: aws.String(accountID),
+			},
+		},
+	}
+
+	result, err := svc.Query(input)
+	if err != nil {
+		return nil, fmt.Errorf("repository: unable to get subscriptions of %s. \n %s", accountID, err)
+	}
+
+	subscriptions := []Subscription{}
+	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &subscriptions)
+	if err != nil {
+		return subscriptions, fmt.Errorf("repository: Failed to unmarshal subscription records. \n %s", err)
+	}
+
+	return subscriptions, nil
+}
+
+// DeleteSubscription removes one of a user's subscriptions.  If the subscription does not exist or belongs to
+// another user, a SubscriptionNotFoundErr error is set
+func DeleteSubscription(accountID string, id string) error {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(SubscriptionsTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"subscription_id": {
+				S: aws.String(id),
+			},
+		},
+		ConditionExpression: aws.String("account_id = :a"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":a": {
+				S: aws.String(accountID),
+			},
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return &SubscriptionNotFoundErr{"subscription not found"}
+		}
+		return fmt.Errorf("repository: failed to delete subscription %s. \n %s", id, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// RequestSummary is what listings of requests show of each: enough to place it on a map and pick it out of a list.
+// The description, comments, audit log and values of a request are returned only by GET /request/{id}.
+type RequestSummary struct {
+	ServiceRequestID  string    `json:"service_request_id"`
+	Status            string    `json:"status"`
+	ServiceName       string    `json:"service_name"`
+	ServiceCode       string    `json:"service_code"`
+	Address           string    `json:"address"`
+	Location                    // lat and lon using the (WGS84) projection.
+	Geometry          *Geometry `json:"geometry,omitempty"`
+	RequestedDateTime string    `json:"requested_datetime"`
+	UpdatedDateTime   string    `json:"update_datetime"`
+	MediaURL          string    `json:"media_url"` // Media of the request, shown as its thumbnail
+}
+
+// SummaryAttributes are the attributes of the Requests table read for listings, those of a RequestSummary
+var SummaryAttributes = []string{
+	"service_request_id", "status", "service_name", "service_code", "address", "lat", "lon", "geometry",
+	"requested_datetime", "update_datetime", "media_url",
+}
+
+// Summary returns the summary of a request shown in listings
+func (r Request) Summary() RequestSummary {
+	return RequestSummary{
+		ServiceRequestID:  r.ServiceRequestID,
+		Status:            r.Status,
+		ServiceName:       r.ServiceName,
+		ServiceCode:       r.ServiceCode,
+		Address:           r.Address,
+		Location:          r.Location,
+		Geometry:          r.Geometry,
+		RequestedDateTime: r.RequestedDateTime,
+		UpdatedDateTime:   r.UpdatedDateTime,
+		MediaURL:          r.MediaURL,
+	}
+}
+
+// projection returns a projection expression reading attributes, adding the names it uses to names.  Attributes
+// are named rather than written out, as several, eg status, are reserved words.
+func projection(attributes []string, names map[string]*string) string {
+	expression := []string{}
+	for i, attribute := range attributes {
+		name := fmt.Sprintf("#a%d", i)
+		names[name] = aws.String(attribute)
+		expression = append(expression, name)
+	}
+	return strings.Join(expression, ", ")
+}
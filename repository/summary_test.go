@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestSummary(t *testing.T) {
+	request := Request{ServiceRequestID: "01ABC", Status: RequestOpen, Description: "Pothole", AccountID: "resident",
+		Location: Location{Latitude: 42.5, Longitude: -73.5}}
+	summary := request.Summary()
+	if summary.ServiceRequestID != "01ABC" || summary.Status != RequestOpen || summary.Location != request.Location {
+		t.Errorf("Summary() = %+v, want the request's ID, status and location", summary)
+	}
+
+	names := map[string]*string{"#city": aws.String("city_id")}
+	expression := projection(SummaryAttributes, names)
+	if strings.Count(expression, "#a") != len(SummaryAttributes) || len(names) != len(SummaryAttributes)+1 {
+		t.Errorf("projection() = %s, %v, want every summary attribute named alongside #city", expression, names)
+	}
+	if aws.StringValue(names["#a1"]) != "status" {
+		t.Errorf("projection() named #a1 %s, want status", aws.StringValue(names["#a1"]))
+	}
+}
@@ -0,0 +1,212 @@
+package repository
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// keyAttribute is an attribute of a table or index key, with its DynamoDB type, eg "S" for a string
+type keyAttribute struct {
+	name string
+	kind string
+}
+
+func stringKey(name string) keyAttribute {
+	return keyAttribute{name, dynamodb.ScalarAttributeTypeS}
+}
+
+// indexSchema is a global secondary index of a table.  Indexes project every attribute.
+type indexSchema struct {
+	name string
+	key  keyAttribute
+	sort *keyAttribute
+}
+
+// tableSchema is what CreateTables needs to create a table: its keys and indexes, the attribute DynamoDB expires
+// items by, if any, and whether it keeps a stream or point-in-time recovery
+type tableSchema struct {
+	name     string
+	key      keyAttribute
+	sort     *keyAttribute
+	indexes  []indexSchema
+	expires  string
+	stream   bool
+	recovery bool
+}
+
+func sortKey(attribute keyAttribute) *keyAttribute {
+	return &attribute
+}
+
+// tableSchemas are the tables of the platform, as the repository reads and writes them
+var tableSchemas = []tableSchema{
+	{name: CitiesTable, key: stringKey("city_name")},
+	{name: ServicesTable, key: stringKey("service_code"), indexes: []indexSchema{
+		{name: CityIndex, key: stringKey("city_id"), sort: sortKey(stringKey("service_code"))},
+	}},
+	{name: RequestsTable, key: stringKey("service_request_id"), stream: true, indexes: []indexSchema{
+		{name: CityIndex, key: stringKey("city_id"), sort: sortKey(stringKey("requested_datetime"))},
+		{name: GeoCellIndex, key: stringKey("geo_cell"), sort: sortKey(stringKey("geohash"))},
+		{name: ZipCodeIndex, key: stringKey("zip_area"), sort: sortKey(stringKey("requested_datetime"))},
+		{name: AgencyQueueIndex, key: stringKey("queue_agency"), sort: sortKey(stringKey("requested_datetime"))},
+	}},
+	{name: UsersTable, key: stringKey("account_id"), indexes: []indexSchema{
+		{name: CityIndex, key: stringKey("city_id")},
+	}},
+	{name: FeedbackTable, key: stringKey("id")},
+	{name: OnboardingTable, key: stringKey("id")},
+	{name: MediaTable, key: stringKey("media_key"), indexes: []indexSchema{
+		{name: MediaRequestIndex, key: stringKey("service_request_id")},
+	}},
+	{name: CountersTable, key: stringKey("counter_id")},
+	{name: SubscriptionsTable, key: stringKey("subscription_id"), indexes: []indexSchema{
+		{name: SubscriptionAccountIndex, key: stringKey("account_id")},
+	}},
+	{name: AgenciesTable, key: stringKey("agency_id"), indexes: []indexSchema{
+		{name: CityIndex, key: stringKey("city_id")},
+	}},
+	{name: AssetsTable, key: stringKey("city_name"), sort: sortKey(stringKey("asset_id")), indexes: []indexSchema{
+		{name: GeoCellIndex, key: stringKey("geo_cell"), sort: sortKey(stringKey("geohash"))},
+	}},
+	{name: ConnectionsTable, key: stringKey("connection_id"), expires: "expires_at"},
+	{name: NotificationTemplatesTable, key: stringKey("city_name"), sort: sortKey(stringKey("template_key"))},
+	{name: NotificationDeliveriesTable, key: stringKey("service_request_id"), sort: sortKey(stringKey("delivery_id"))},
+	{name: WebhooksTable, key: stringKey("webhook_id")},
+	{name: WebhookDeliveriesTable, key: stringKey("webhook_id"), sort: sortKey(stringKey("delivery_id"))},
+	{name: AuditTable, key: stringKey("city_id"), sort: sortKey(stringKey("audit_id")), recovery: true},
+	{name: PinsTable, key: stringKey("geo_cell"), sort: sortKey(stringKey("id"))},
+}
+
+// input returns the call creating the table, billed per request so it needs no capacity planned
+func (t tableSchema) input() *dynamodb.CreateTableInput {
+	definitions := []*dynamodb.AttributeDefinition{}
+	defined := map[string]bool{}
+	define := func(attribute keyAttribute) {
+		if !defined[attribute.name] {
+			defined[attribute.name] = true
+			definitions = append(definitions, &dynamodb.AttributeDefinition{
+				AttributeName: aws.String(attribute.name),
+				AttributeType: aws.String(attribute.kind),
+			})
+		}
+	}
+	keySchema := func(key keyAttribute, sort *keyAttribute) []*dynamodb.KeySchemaElement {
+		define(key)
+		schema := []*dynamodb.KeySchemaElement{
+			{AttributeName: aws.String(key.name), KeyType: aws.String(dynamodb.KeyTypeHash)},
+		}
+		if sort != nil {
+			define(*sort)
+			schema = append(schema, &dynamodb.KeySchemaElement{
+				AttributeName: aws.String(sort.name), KeyType: aws.String(dynamodb.KeyTypeRange),
+			})
+		}
+		return schema
+	}
+
+	input := &dynamodb.CreateTableInput{
+		TableName:   aws.String(t.name),
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+		KeySchema:   keySchema(t.key, t.sort),
+	}
+	for _, index := range t.indexes {
+		input.GlobalSecondaryIndexes = append(input.GlobalSecondaryIndexes, &dynamodb.GlobalSecondaryIndex{
+			IndexName:  aws.String(index.name),
+			KeySchema:  keySchema(index.key, index.sort),
+			Projection: &dynamodb.Projection{ProjectionType: aws.String(dynamodb.ProjectionTypeAll)},
+		})
+	}
+	if t.stream {
+		input.StreamSpecification = &dynamodb.StreamSpecification{
+			StreamEnabled:  aws.Bool(true),
+			StreamViewType: aws.String(dynamodb.StreamViewTypeNewAndOldImages),
+		}
+	}
+	input.AttributeDefinitions = definitions
+	return input
+}
+
+// tableClient is the part of the DynamoDB client creating tables
+type tableClient interface {
+	DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error)
+	CreateTable(input *dynamodb.CreateTableInput) (*dynamodb.CreateTableOutput, error)
+	WaitUntilTableExists(input *dynamodb.DescribeTableInput) error
+	UpdateTimeToLive(input *dynamodb.UpdateTimeToLiveInput) (*dynamodb.UpdateTimeToLiveOutput, error)
+	UpdateContinuousBackups(input *dynamodb.UpdateContinuousBackupsInput) (*dynamodb.UpdateContinuousBackupsOutput, error)
+}
+
+// CreateTables creates the platform's tables that don't exist yet, in the stack's DATA_REGION, and the Cities table
+// in the directory's region, with their keys, indexes, TTL, stream and point-in-time recovery.  Tables are billed per
+// request.  Existing tables are left as they are, even when they are missing an index, so it is safe to run again.
+// It returns the names of the tables it created.
+func CreateTables() ([]string, error) {
+	created := []string{}
+	for _, schema := range tableSchemas {
+		createClient := createDynamoClient
+		if schema.name == CitiesTable {
+			createClient = createDirectoryClient
+		}
+		svc, err := createClient()
+		if err != nil {
+			return created, err
+		}
+
+		made, err := createTable(svc, schema)
+		if err != nil {
+			return created, err
+		}
+		if made {
+			created = append(created, schema.name)
+		}
+	}
+	return created, nil
+}
+
+// createTable creates a table unless it exists, waiting for it to be active, and reports whether it created it
+func createTable(svc tableClient, schema tableSchema) (bool, error) {
+	describe := &dynamodb.DescribeTableInput{TableName: aws.String(schema.name)}
+	_, err := svc.DescribeTable(describe)
+	if err == nil {
+		return false, nil
+	}
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != dynamodb.ErrCodeResourceNotFoundException {
+		return false, fmt.Errorf("repository: unable to describe table %s. \n %s", schema.name, err)
+	}
+
+	_, err = svc.CreateTable(schema.input())
+	if err != nil {
+		return false, fmt.Errorf("repository: unable to create table %s. \n %s", schema.name, err)
+	}
+	err = svc.WaitUntilTableExists(describe)
+	if err != nil {
+		return true, fmt.Errorf("repository: table %s was created but is not active. \n %s", schema.name, err)
+	}
+
+	if schema.expires != "" {
+		_, err = svc.UpdateTimeToLive(&dynamodb.UpdateTimeToLiveInput{
+			TableName: aws.String(schema.name),
+			TimeToLiveSpecification: &dynamodb.TimeToLiveSpecification{
+				AttributeName: aws.String(schema.expires),
+				Enabled:       aws.Bool(true),
+			},
+		})
+		if err != nil {
+			return true, fmt.Errorf("repository: unable to enable TTL on table %s. \n %s", schema.name, err)
+		}
+	}
+	if schema.recovery {
+		_, err = svc.UpdateContinuousBackups(&dynamodb.UpdateContinuousBackupsInput{
+			TableName: aws.String(schema.name),
+			PointInTimeRecoverySpecification: &dynamodb.PointInTimeRecoverySpecification{
+				PointInTimeRecoveryEnabled: aws.Bool(true),
+			},
+		})
+		if err != nil {
+			return true, fmt.Errorf("repository: unable to enable point-in-time recovery on table %s. \n %s", schema.name, err)
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+type fakeTables struct {
+	existing map[string]bool
+	created  []*dynamodb.CreateTableInput
+	ttl      []string
+	recovery []string
+}
+
+func (f *fakeTables) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	if !f.existing[aws.StringValue(input.TableName)] {
+		return nil, awserr.New(dynamodb.ErrCodeResourceNotFoundException, "not found", nil)
+	}
+	return &dynamodb.DescribeTableOutput{}, nil
+}
+
+func (f *fakeTables) CreateTable(input *dynamodb.CreateTableInput) (*dynamodb.CreateTableOutput, error) {
+	f.created = append(f.created, input)
+	f.existing[aws.StringValue(input.TableName)] = true
+	return &dynamodb.CreateTableOutput{}, nil
+}
+
+func (f *fakeTables) WaitUntilTableExists(input *dynamodb.DescribeTableInput) error {
+	return nil
+}
+
+func (f *fakeTables) UpdateTimeToLive(input *dynamodb.UpdateTimeToLiveInput) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	f.ttl = append(f.ttl, aws.StringValue(input.TimeToLiveSpecification.AttributeName))
+	return &dynamodb.UpdateTimeToLiveOutput{}, nil
+}
+
+func (f *fakeTables) UpdateContinuousBackups(input *dynamodb.UpdateContinuousBackupsInput) (*dynamodb.UpdateContinuousBackupsOutput, error) {
+	f.recovery = append(f.recovery, aws.StringValue(input.TableName))
+	return &dynamodb.UpdateContinuousBackupsOutput{}, nil
+}
+
+func TestTableSchemas(t *testing.T) {
+	schemas := map[string]tableSchema{}
+	for _, schema := range tableSchemas {
+		schemas[schema.name] = schema
+	}
+	for _, table := range HealthTables {
+		if _, ok := schemas[table]; !ok {
+			t.Errorf("table %s has no schema", table)
+		}
+	}
+
+	for _, schema := range tableSchemas {
+		input := schema.input()
+		if aws.StringValue(input.BillingMode) != dynamodb.BillingModePayPerRequest {
+			t.Errorf("%s is billed %s, want %s", schema.name, aws.StringValue(input.BillingMode), dynamodb.BillingModePayPerRequest)
+		}
+
+		// Every key attribute is defined once, and no other attribute is
+		defined := map[string]int{}
+		for _, definition := range input.AttributeDefinitions {
+			defined[aws.StringValue(definition.AttributeName)]++
+		}
+		keys := map[string]bool{}
+		elements := input.KeySchema
+		for _, index := range input.GlobalSecondaryIndexes {
+			elements = append(elements, index.KeySchema...)
+		}
+		for _, element := range elements {
+			keys[aws.StringValue(element.AttributeName)] = true
+		}
+		for name, count := range defined {
+			if count != 1 || !keys[name] {
+				t.Errorf("%s defines %s %d times, used as a key %v", schema.name, name, count, keys[name])
+			}
+		}
+		if len(defined) != len(keys) {
+			t.Errorf("%s defines %d attributes for %d keys", schema.name, len(defined), len(keys))
+		}
+	}
+
+	// ZIP codes are strings, listed by the five digits of their zip_area
+	requests := schemas[RequestsTable].input()
+	for _, definition := range requests.AttributeDefinitions {
+		if aws.StringValue(definition.AttributeName) == "zip_area" && aws.StringValue(definition.AttributeType) != dynamodb.ScalarAttributeTypeS {
+			t.Errorf("zip_area is defined as %s, want a string", aws.StringValue(definition.AttributeType))
+		}
+	}
+	if requests.StreamSpecification == nil || !aws.BoolValue(requests.StreamSpecification.StreamEnabled) {
+		t.Errorf("%s has no stream", RequestsTable)
+	}
+}
+
+func TestCreateTable(t *testing.T) {
+	svc := &fakeTables{existing: map[string]bool{ConnectionsTable: true}}
+	for _, schema := range tableSchemas {
+		if schema.name == ConnectionsTable || schema.name == AuditTable {
+			made, err := createTable(svc, schema)
+			if err != nil {
+				t.Fatalf("createTable(%s) error = %s", schema.name, err)
+			}
+			if made != (schema.name == AuditTable) {
+				t.Errorf("createTable(%s) = %v", schema.name, made)
+			}
+		}
+	}
+	if len(svc.created) != 1 || aws.StringValue(svc.created[0].TableName) != AuditTable {
+		t.Errorf("created %v, want only %s", svc.created, AuditTable)
+	}
+	if len(svc.ttl) != 0 || len(svc.recovery) != 1 {
+		t.Errorf("enabled TTL %v and recovery %v, want recovery of %s", svc.ttl, svc.recovery, AuditTable)
+	}
+
+	// A table created again is left alone
+	made, err := createTable(svc, tableSchemas[0])
+	if err == nil {
+		made, err = createTable(svc, tableSchemas[0])
+	}
+	if err != nil || made {
+		t.Errorf("createTable() of an existing table = %v, %v", made, err)
+	}
+}
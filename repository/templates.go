@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// NotificationTemplatesTable holds each city's own copy of notifications, keyed by city_name and template_key
+const NotificationTemplatesTable = "NotificationTemplates"
+
+// NotificationTemplate is a city's copy of a notification in one language.  Copy may contain {{name}}
+// placeholders, which are filled with the notification's data when it is sent.
+type NotificationTemplate struct {
+	City        string `json:"city_name"`
+	TemplateKey string `json:"template_key"` // Name and language, eg "RequestStatusChanged#es". See TemplateKey
+	Name        string `json:"name"`         // Name of the notification, eg RequestStatusChanged
+	Language    string `json:"language"`     // BCP 47 language tag, eg "en" or "es"
+	Subject     string `json:"subject"`      // Email subject
+	Text        string `json:"text"`         // Plain text email body
+	HTML        string `json:"html"`         // HTML email body
+	Short       string `json:"short"`        // Push and text message copy
+}
+
+type NotificationTemplateNotFoundErr struct {
+	message string
+}
+
+func (e *NotificationTemplateNotFoundErr) Error() string {
+	return e.message
+}
+
+// TemplateKey returns the sort key of a city's copy of a notification in a language
+func TemplateKey(name string, language string) string {
+	return name + "#" + language
+}
+
+// GetNotificationTemplate returns a city's copy of a notification in a language.  If the city has none, a
+// NotificationTemplateNotFoundErr error is set
+func GetNotificationTemplate(city string, name string, language string) (NotificationTemplate, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return NotificationTemplate{}, err
+	}
+
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(NotificationTemplatesTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"city_name": {
+				S: aws.String(city),
+			},
+			"template_key": {
+				S: aws.String(TemplateKey(name, language)),
+			},
+		},
+	}
+
+	result, err := svc.GetItem(input)
+	if err != nil {
+		return NotificationTemplate{}, fmt.Errorf("repository: unable to get %s template of %s from database. \n %s", name, city, err)
+	}
+
+	template := NotificationTemplate{}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &template)
+	if err != nil {
+		return template, fmt.Errorf("repository: Failed to unmarshal notification template record. \n %s", err)
+	}
+
+	if template.TemplateKey == "" {
+		return template, &NotificationTemplateNotFoundErr{"notification template not found"}
+	}
+
+	return template, nil
+}
+
+// GetNotificationTemplates returns every notification template of a city
+func GetNotificationTemplates(city string) ([]NotificationTemplate, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return []NotificationTemplate{}, err
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(NotificationTemplatesTable),
+		KeyConditionExpression: aws.String("city_name = :c"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":c": {
+				S: aws.String(city),
+			},
+		},
+	}
+
+	result, err := svc.Query(input)
+	if err != nil {
+		return nil, fmt.Errorf("repository: unable to get notification templates of %s. \n %s", city, err)
+	}
+
+	templates := []NotificationTemplate{}
+	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &templates)
+	if err != nil {
+		return templates, fmt.Errorf("repository: Failed to unmarshal notification template records. \n %s", err)
+	}
+
+	return templates, nil
+}
+
+// PutNotificationTemplate creates or replaces a city's copy of a notification in a language
+func PutNotificationTemplate(template NotificationTemplate) error {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return err
+	}
+
+	template.TemplateKey = TemplateKey(template.Name, template.Language)
+
+	av, err := dynamodbattribute.MarshalMap(template)
+	if err != nil {
+		return fmt.Errorf("repository: Failed to marshal notification template:\n %+v. \n  %s", template, err)
+	}
+
+	_, err = svc.PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(NotificationTemplatesTable),
+	})
+	if err != nil {
+		return fmt.Errorf("repository: failed to put notification template in database. \n %s", err)
+	}
+
+	return nil
+}
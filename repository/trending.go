@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TrendingBaselineDays is how many days before a trending window its volume is compared with
+const TrendingBaselineDays = 28
+
+// A service is trending when it had at least trendingMinCount requests in the window, and that many more than its
+// baseline that they are unlikely to be chance: trendingMinScore standard deviations, taking requests to arrive
+// as a Poisson process.
+const (
+	trendingMinCount = 3
+	trendingMinScore = 3.0
+)
+
+// Trend is a service with unusual volume, in a whole city or in one of its neighborhoods
+type Trend struct {
+	ServiceCode  string  `json:"service_code"`
+	Neighborhood string  `json:"neighborhood,omitempty"` // geo_cell of the neighborhood, about 5km by 5km
+	Count        int64   `json:"count"`                  // Requests made during the window
+	Baseline     float64 `json:"baseline"`               // Requests expected during a window of the same length, from the baseline days
+	Ratio        float64 `json:"ratio"`                  // Count over Baseline. 0 for services without requests in the baseline
+	Score        float64 `json:"score"`                  // Standard deviations of Count above Baseline
+}
+
+// trendKey names the requests a trend counts
+type trendKey struct {
+	service string
+	cell    string
+}
+
+// GetRecentRequests returns the requests of a city made since a time, with only the attributes trends and
+// engagement are counted from.  They are read from the CityIndex, which is sorted by requested_datetime.
+func GetRecentRequests(cityID string, since time.Time) ([]Request, error) {
+	return queryRequestsBetween(cityID, since, time.Time{}, "service_request_id, service_code, geo_cell, account_id, requested_datetime")
+}
+
+// BaselineDays returns the TrendingBaselineDays days before the day a window starts on, oldest first, as
+// YYYY-MM-DD in loc
+func BaselineDays(start time.Time, loc *time.Location) []string {
+	first := start.In(loc)
+	days := make([]string, TrendingBaselineDays)
+	for i := range days {
+		days[i] = first.AddDate(0, 0, i-TrendingBaselineDays).Format("2006-01-02")
+	}
+	return days
+}
+
+// Trends compares the requests made during a window with the daily stats of the baseline days before it, returning
+// the services whose volume stands out, most unusual first.  By neighborhood, services are compared in each geo
+// cell, and requests without a location are left out.
+func Trends(recent []Request, baseline []DailyStats, window time.Duration, byNeighborhood bool) []Trend {
+	counts := map[trendKey]int64{}
+	for _, request := range recent {
+		key := trendKey{service: request.ServiceCode}
+		if byNeighborhood {
+			if request.GeoCell == "" {
+				continue
+			}
+			key.cell = request.GeoCell
+		}
+		counts[key]++
+	}
+
+	base := map[trendKey]int64{}
+	for _, day := range baseline {
+		if !byNeighborhood {
+			for code, n := range day.Services {
+				base[trendKey{service: code}] += n
+			}
+			continue
+		}
+		for cellService, n := range day.Cells {
+			sep := strings.Index(cellService, ":")
+			if sep < 0 {
+				continue
+			}
+			base[trendKey{service: cellService[sep+1:], cell: cellService[:sep]}] += n
+		}
+	}
+
+	// The baseline is scaled to the length of the window
+	scale := 0.0
+	if len(baseline) > 0 {
+		scale = window.Hours() / (float64(len(baseline)) * 24)
+	}
+
+	trends := []Trend{}
+	for key, n := range counts {
+		expected := float64(base[key]) * scale
+		score := poissonScore(n, expected)
+		if n < trendingMinCount || score < trendingMinScore {
+			continue
+		}
+
+		trend := Trend{
+			ServiceCode:  key.service,
+			Neighborhood: key.cell,
+			Count:        n,
+			Baseline:     math.Round(expected*100) / 100,
+			Score:        math.Round(score*100) / 100,
+		}
+		if expected > 0 {
+			trend.Ratio = math.Round(float64(n)/expected*100) / 100
+		}
+		trends = append(trends, trend)
+	}
+
+	sort.Slice(trends, func(i, j int) bool {
+		a, b := trends[i], trends[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.ServiceCode != b.ServiceCode {
+			return a.ServiceCode < b.ServiceCode
+		}
+		return a.Neighborhood < b.Neighborhood
+	})
+	return trends
+}
+
+// poissonScore returns how many standard deviations a count is above, or below, the count expected, taking requests
+// to arrive as a Poisson process.  Expected counts under 1 are taken as 1, so a handful of requests to a service
+// that rarely gets any isn't unusual.
+func poissonScore(n int64, expected float64) float64 {
+	return (float64(n) - expected) / math.Sqrt(math.Max(expected, 1))
+}
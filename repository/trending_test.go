@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBaselineDays(t *testing.T) {
+	newYork, _ := time.LoadLocation("America/New_York")
+
+	// 02:00 UTC on the 2nd is still the 1st in New York
+	days := BaselineDays(time.Date(2019, 6, 2, 2, 0, 0, 0, time.UTC), newYork)
+	if len(days) != TrendingBaselineDays || days[0] != "2019-05-04" || days[len(days)-1] != "2019-05-31" {
+		t.Errorf("BaselineDays() = %v, want 2019-05-04 to 2019-05-31", days)
+	}
+}
+
+func TestTrends(t *testing.T) {
+	requests := func(service string, cell string, n int) []Request {
+		r := []Request{}
+		for i := 0; i < n; i++ {
+			r = append(r, Request{ServiceCode: service, GeoCell: cell})
+		}
+		return r
+	}
+
+	// 28 days of baseline: 2 water main breaks and 56 potholes a day, split between two neighborhoods
+	baseline := []DailyStats{}
+	for i := 0; i < TrendingBaselineDays; i++ {
+		baseline = append(baseline, DailyStats{
+			Services: map[string]int64{"water-main": 2, "pothole": 56},
+			Cells: map[string]int64{
+				CellServiceKey("dreg0", "water-main"): 1, CellServiceKey("dreg1", "water-main"): 1,
+				CellServiceKey("dreg0", "pothole"): 28, CellServiceKey("dreg1", "pothole"): 28,
+			},
+		})
+	}
+
+	recent := requests("water-main", "dreg0", 12)
+	recent = append(recent, requests("pothole", "dreg0", 30)...)
+	recent = append(recent, requests("pothole", "dreg1", 30)...)
+	recent = append(recent, requests("graffiti", "", 4)...)
+	recent = append(recent, requests("tree-down", "dreg1", 2)...)
+
+	trends := Trends(recent, baseline, 24*time.Hour, false)
+	if len(trends) != 2 {
+		t.Fatalf("Trends() = %+v, want water-main then graffiti", trends)
+	}
+	if w := trends[0]; w.ServiceCode != "water-main" || w.Count != 12 || w.Baseline != 2 || w.Ratio != 6 || w.Score != 7.07 {
+		t.Errorf("water-main trend = %+v, want 12 against 2, 6x, 7.07 deviations", w)
+	}
+	if g := trends[1]; g.ServiceCode != "graffiti" || g.Baseline != 0 || g.Ratio != 0 || g.Score != 4 {
+		t.Errorf("graffiti trend = %+v, want 4 without a baseline", g)
+	}
+
+	// Spread over a week, the water main breaks are ordinary, but graffiti is still new
+	if trends := Trends(recent, baseline, 7*24*time.Hour, false); len(trends) != 1 || trends[0].ServiceCode != "graffiti" {
+		t.Errorf("Trends() over 7d = %+v, want graffiti alone", trends)
+	}
+
+	trends = Trends(recent, baseline, 24*time.Hour, true)
+	if len(trends) != 1 || trends[0].ServiceCode != "water-main" || trends[0].Neighborhood != "dreg0" || trends[0].Baseline != 1 {
+		t.Errorf("Trends() by neighborhood = %+v, want water-main in dreg0 alone", trends)
+	}
+
+	if trends := Trends(nil, nil, 24*time.Hour, false); trends == nil || len(trends) != 0 {
+		t.Errorf("Trends() of nothing = %#v, want empty", trends)
+	}
+}
@@ -0,0 +1,235 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// Names of the tables holding webhook registrations and their delivery log
+const (
+	WebhooksTable          = "Webhooks"
+	WebhookDeliveriesTable = "WebhookDeliveries"
+)
+
+// Formats webhooks are delivered in
+const (
+	WebhookFormatEvent    = ""         // The domain event
+	WebhookFormatRESTHook = "resthook" // A flat object of the request, for no-code platforms subscribing with REST hooks
+)
+
+// Webhook is a city system's registration to receive domain events as signed JSON
+type Webhook struct {
+	ID          string   `json:"webhook_id"`
+	City        string   `json:"city"`                   // City whose admins registered the webhook. Events of other cities aren't delivered
+	URL         string   `json:"url"`                    // HTTPS endpoint events are posted to
+	EventTypes  []string `json:"event_types"`            // Domain event types delivered, eg RequestCreated
+	ServiceCode string   `json:"service_code,omitempty"` // Service whose events are delivered. Empty for every service
+	Format      string   `json:"format,omitempty"`       // WebhookFormatEvent or WebhookFormatRESTHook
+	Secret      string   `json:"secret"`                 // Key of the HMAC-SHA256 signature sent with every delivery
+	AccountID   string   `json:"account_id"`             // Admin who registered the webhook
+	Timestamp   string   `json:"timestamp"`              // RFC3339 formatted time the webhook was registered
+}
+
+// WebhookDelivery is an entry in the delivery log of a webhook
+type WebhookDelivery struct {
+	WebhookID        string `json:"webhook_id"`
+	DeliveryID       string `json:"delivery_id"` // ULID, so deliveries sort by time
+	EventType        string `json:"event_type"`
+	ServiceRequestID string `json:"service_request_id"`
+	StatusCode       int    `json:"status_code"` // HTTP status of the last attempt. 0 if the endpoint was unreachable
+	Attempts         int    `json:"attempts"`
+	Error            string `json:"error"`
+	Timestamp        string `json:"timestamp"`
+}
+
+type WebhookNotFoundErr struct {
+	message string
+}
+
+func (e *WebhookNotFoundErr) Error() string {
+	return e.message
+}
+
+// AddWebhook stores a new webhook registration, assigning its ID
+func AddWebhook(webhook Webhook) (Webhook, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	id, err := genID()
+	if err != nil {
+		return Webhook{}, fmt.Errorf("repository: failed to generate unique id for webhook. \n  %s", err)
+	}
+	webhook.ID = id
+	webhook.Timestamp = time.Now().Format(time.RFC3339)
+
+	av, err := dynamodbattribute.MarshalMap(webhook)
+	if err != nil {
+		return Webhook{}, fmt.Errorf("repository: Failed to marshal webhook:\n %+v. \n  %s", webhook, err)
+	}
+
+	input := &dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(WebhooksTable),
+	}
+
+	_, err = svc.PutItem(input)
+	if err != nil {
+		return Webhook{}, fmt.Errorf("repository: failed to put new webhook in database. \n %s", err)
+	}
+
+	return webhook, nil
+}
+
+// GetWebhooks returns every registered webhook
+func GetWebhooks() ([]Webhook, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return []Webhook{}, err
+	}
+
+	params := &dynamodb.ScanInput{
+		TableName: aws.String(WebhooksTable),
+	}
+
+	items := []map[string]*dynamodb.AttributeValue{}
+	err = svc.ScanPages(params, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		items = append(items, page.Items...)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("repository: unable to get all webhooks from database. \n %s", err)
+	}
+
+	webhooks := []Webhook{}
+	err = dynamodbattribute.UnmarshalListOfMaps(items, &webhooks)
+	if err != nil {
+		return webhooks, fmt.Errorf("repository: Failed to unmarshal webhook records. \n %s", err)
+	}
+
+	return webhooks, nil
+}
+
+// GetWebhook returns a single webhook.  If the ID is not in the database, a WebhookNotFoundErr error is set
+func GetWebhook(id string) (Webhook, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(WebhooksTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"webhook_id": {
+				S: aws.String(id),
+			},
+		},
+	}
+
+	result, err := svc.GetItem(input)
+	if err != nil {
+		return Webhook{}, fmt.Errorf("repository: unable to get specified webhook from database. \n %s", err)
+	}
+
+	webhook := Webhook{}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &webhook)
+	if err != nil {
+		return webhook, fmt.Errorf("repository: Failed to unmarshal webhook record from database. \n %s", err)
+	}
+
+	if webhook.ID == "" {
+		return webhook, &WebhookNotFoundErr{"webhook not found"}
+	}
+
+	return webhook, nil
+}
+
+// DeleteWebhook removes a webhook registration.  Its delivery log is kept
+func DeleteWebhook(id string) error {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(WebhooksTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"webhook_id": {
+				S: aws.String(id),
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("repository: failed to delete webhook %s. \n %s", id, err)
+	}
+
+	return nil
+}
+
+// RecordWebhookDelivery appends an entry to a webhook's delivery log
+func RecordWebhookDelivery(delivery WebhookDelivery) error {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return err
+	}
+
+	id, err := genID()
+	if err != nil {
+		return fmt.Errorf("repository: failed to generate unique id for webhook delivery. \n  %s", err)
+	}
+	delivery.DeliveryID = id
+	delivery.Timestamp = time.Now().Format(time.RFC3339)
+
+	av, err := dynamodbattribute.MarshalMap(delivery)
+	if err != nil {
+		return fmt.Errorf("repository: Failed to marshal webhook delivery:\n %+v. \n  %s", delivery, err)
+	}
+
+	_, err = svc.PutItem(&dynamodb.PutItemInput{
+		Item:      av,
+		TableName: aws.String(WebhookDeliveriesTable),
+	})
+	if err != nil {
+		return fmt.Errorf("repository: failed to put webhook delivery in database. \n %s", err)
+	}
+
+	return nil
+}
+
+// GetWebhookDeliveries returns a webhook's delivery log, most recent first
+func GetWebhookDeliveries(webhookID string) ([]WebhookDelivery, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return []WebhookDelivery{}, err
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(WebhookDeliveriesTable),
+		KeyConditionExpression: aws.String("webhook_id = :w"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":w": {
+				S: aws.String(webhookID),
+			},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int64(100),
+	}
+
+	result, err := svc.Query(input)
+	if err != nil {
+		return nil, fmt.Errorf("repository: unable to get deliveries of webhook %s. \n %s", webhookID, err)
+	}
+
+	deliveries := []WebhookDelivery{}
+	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &deliveries)
+	if err != nil {
+		return deliveries, fmt.Errorf("repository: Failed to unmarshal webhook delivery records. \n %s", err)
+	}
+
+	return deliveries, nil
+}
@@ -0,0 +1,268 @@
+package repository
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// AgencyQueueIndex is the global secondary index of RequestsTable on queue_agency, sorted by requested_datetime.
+// Only requests that aren't closed have a queue_agency, so the index holds each agency's open queue and nothing
+// else.
+const AgencyQueueIndex = "queue_agency-index"
+
+// queueAttributes are the attributes of requests read for workloads
+var queueAttributes = []string{"service_request_id", "city_id", "status", "service_code", "service_name", "address",
+	"requested_datetime", "expected_datetime", "scheduled_datetime", "assigned_to", "escalation_level"}
+
+// setQueue puts a request in the queue of the agency responsible for it until it is closed
+func setQueue(request *Request) {
+	if request.Status == RequestClosed {
+		request.QueueAgency = ""
+		return
+	}
+	request.QueueAgency = request.AgencyResponsible
+}
+
+// GetAgencyQueue returns the requests of an agency that are not closed, oldest first, with only the attributes
+// workloads are computed from
+func GetAgencyQueue(cityID string, agencyID string) ([]Request, error) {
+	svc, err := createCityClient(cityID)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(RequestsTable),
+		IndexName:              aws.String(AgencyQueueIndex),
+		KeyConditionExpression: aws.String("queue_agency = :a"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":a": {
+				S: aws.String(agencyID),
+			},
+		},
+		ExpressionAttributeNames: map[string]*string{},
+	}
+	projection := ""
+	for i, attribute := range queueAttributes {
+		name := fmt.Sprintf("#p%d", i)
+		input.ExpressionAttributeNames[name] = aws.String(attribute)
+		if projection != "" {
+			projection += ", "
+		}
+		projection += name
+	}
+	input.ProjectionExpression = aws.String(projection)
+
+	all := []map[string]*dynamodb.AttributeValue{}
+	err = svc.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		all = append(all, page.Items...)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("repository: unable to get queue of agency %s. \n %s", agencyID, err)
+	}
+
+	requests := []Request{}
+	err = dynamodbattribute.UnmarshalListOfMaps(all, &requests)
+	if err != nil {
+		return nil, fmt.Errorf("repository: Failed to unmarshal queue of agency %s. \n %s", agencyID, err)
+	}
+	return requests, nil
+}
+
+// AgingBounds are the upper bounds, in days, of the buckets requests in a queue are aged in
+var AgingBounds = []int{1, 3, 7, 14, 30}
+
+// Workload summarizes the queue of an agency
+type Workload struct {
+	AgencyID     string         `json:"agency_id"`
+	Name         string         `json:"name"`
+	Open         int            `json:"open"`       // Requests that aren't closed
+	Overdue      int            `json:"overdue"`    // Open requests past their expected_datetime
+	Unassigned   int            `json:"unassigned"` // Open requests not assigned to a worker
+	Oldest       string         `json:"oldest_requested_datetime,omitempty"`
+	Aging        []AgingBucket  `json:"aging"`
+	Workers      []WorkerLoad   `json:"workers"`                 // Assignment load, heaviest first
+	OverdueItems []OverdueEntry `json:"overdue_items,omitempty"` // Overdue requests, most overdue first. Only listed for a single agency
+}
+
+// AgingBucket counts the open requests of a queue made between MinDays and MaxDays ago.  MaxDays is 0 for the
+// last, open ended, bucket.
+type AgingBucket struct {
+	MinDays int `json:"min_days"`
+	MaxDays int `json:"max_days,omitempty"`
+	Count   int `json:"count"`
+}
+
+// WorkerLoad is the share of a queue assigned to one worker
+type WorkerLoad struct {
+	AssignedTo string `json:"assigned_to"`
+	Open       int    `json:"open"`
+	Overdue    int    `json:"overdue"`
+}
+
+// OverdueEntry is an overdue request of a queue
+type OverdueEntry struct {
+	ServiceRequestID string  `json:"service_request_id"`
+	ServiceCode      string  `json:"service_code"`
+	ServiceName      string  `json:"service_name"`
+	Status           string  `json:"status"`
+	Address          string  `json:"address"`
+	AssignedTo       string  `json:"assigned_to"`
+	ExpectedDateTime string  `json:"expected_datetime"`
+	HoursOverdue     float64 `json:"hours_overdue"`
+	EscalationLevel  int     `json:"escalation_level"`
+}
+
+// NewWorkload summarizes the queue of an agency at now.  Requests without a valid requested_datetime are counted
+// as open but not aged.
+func NewWorkload(agency Agency, queue []Request, now time.Time) Workload {
+	workload := Workload{AgencyID: agency.ID, Name: agency.Name, Workers: []WorkerLoad{}}
+	for i, bound := range AgingBounds {
+		bucket := AgingBucket{MaxDays: bound}
+		if i > 0 {
+			bucket.MinDays = AgingBounds[i-1]
+		}
+		workload.Aging = append(workload.Aging, bucket)
+	}
+	workload.Aging = append(workload.Aging, AgingBucket{MinDays: AgingBounds[len(AgingBounds)-1]})
+
+	workers := map[string]*WorkerLoad{}
+	for _, request := range queue {
+		workload.Open++
+
+		if requested, err := time.Parse(time.RFC3339, request.RequestedDateTime); err == nil {
+			workload.Aging[agingBucket(now.Sub(requested))].Count++
+			if workload.Oldest == "" || request.RequestedDateTime < workload.Oldest {
+				workload.Oldest = request.RequestedDateTime
+			}
+		}
+
+		overdue := false
+		if expected, err := time.Parse(time.RFC3339, request.ExpectedDateTime); err == nil && now.After(expected) {
+			overdue = true
+			workload.Overdue++
+			workload.OverdueItems = append(workload.OverdueItems, OverdueEntry{
+				ServiceRequestID: request.ServiceRequestID,
+				ServiceCode:      request.ServiceCode,
+				ServiceName:      request.ServiceName,
+				Status:           request.Status,
+				Address:          request.Address,
+				AssignedTo:       request.AssignedTo,
+				ExpectedDateTime: request.ExpectedDateTime,
+				HoursOverdue:     math.Round(now.Sub(expected).Hours()*10) / 10,
+				EscalationLevel:  request.EscalationLevel,
+			})
+		}
+
+		if request.AssignedTo == "" {
+			workload.Unassigned++
+			continue
+		}
+		worker, ok := workers[request.AssignedTo]
+		if !ok {
+			worker = &WorkerLoad{AssignedTo: request.AssignedTo}
+			workers[request.AssignedTo] = worker
+		}
+		worker.Open++
+		if overdue {
+			worker.Overdue++
+		}
+	}
+
+	for _, worker := range workers {
+		workload.Workers = append(workload.Workers, *worker)
+	}
+	sort.Slice(workload.Workers, func(i, j int) bool {
+		a, b := workload.Workers[i], workload.Workers[j]
+		if a.Open != b.Open {
+			return a.Open > b.Open
+		}
+		return a.AssignedTo < b.AssignedTo
+	})
+	sort.SliceStable(workload.OverdueItems, func(i, j int) bool {
+		return workload.OverdueItems[i].HoursOverdue > workload.OverdueItems[j].HoursOverdue
+	})
+	return workload
+}
+
+// agingBucket returns the index of the AgingBounds bucket a request of an age falls in
+func agingBucket(age time.Duration) int {
+	days := age.Hours() / 24
+	for i, bound := range AgingBounds {
+		if days < float64(bound) {
+			return i
+		}
+	}
+	return len(AgingBounds)
+}
+
+// BackfillQueues sets the queue_agency of stored requests that lack it or whose queue has changed, returning how
+// many requests were (or, for a dry run, would be) updated.  Only queue_agency is written, so requests updated
+// concurrently are not clobbered.
+func BackfillQueues(dryRun bool) (int, error) {
+	svc, err := createDynamoClient()
+	if err != nil {
+		return 0, err
+	}
+
+	var updated int64
+	err = scanEach(svc, &dynamodb.ScanInput{TableName: aws.String(RequestsTable)}, func(item map[string]*dynamodb.AttributeValue) error {
+		request := Request{}
+		err := dynamodbattribute.UnmarshalMap(item, &request)
+		if err != nil {
+			return err
+		}
+
+		stored := request.QueueAgency
+		setQueue(&request)
+		if request.QueueAgency == stored {
+			return nil
+		}
+
+		atomic.AddInt64(&updated, 1)
+		if dryRun {
+			return nil
+		}
+		return updateQueue(svc, request)
+	})
+	if err != nil {
+		return int(updated), fmt.Errorf("repository: unable to backfill queues. \n %s", err)
+	}
+	return int(updated), nil
+}
+
+// updateQueue writes only the queue_agency of a request, removing it from requests in no queue
+func updateQueue(svc *dynamodb.DynamoDB, request Request) error {
+	input := &dynamodb.UpdateItemInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#Q": aws.String("queue_agency"),
+		},
+		Key: map[string]*dynamodb.AttributeValue{
+			"service_request_id": {
+				S: aws.String(request.ServiceRequestID),
+			},
+		},
+		TableName:        aws.String(RequestsTable),
+		UpdateExpression: aws.String("REMOVE #Q"),
+	}
+	if request.QueueAgency != "" {
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":q": {S: aws.String(request.QueueAgency)},
+		}
+		input.UpdateExpression = aws.String("SET #Q = :q")
+	}
+
+	_, err := svc.UpdateItem(input)
+	if err != nil {
+		return fmt.Errorf("repository: failed to set queue of request %s. \n  %s", request.ServiceRequestID, err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetQueue(t *testing.T) {
+	request := Request{Status: RequestOpen, AgencyResponsible: "streets"}
+	setQueue(&request)
+	if request.QueueAgency != "streets" {
+		t.Errorf("queue of open request = %q, want streets", request.QueueAgency)
+	}
+
+	request.Status = RequestClosed
+	setQueue(&request)
+	if request.QueueAgency != "" {
+		t.Errorf("queue of closed request = %q, want none", request.QueueAgency)
+	}
+
+	request = Request{Status: RequestInProgress}
+	setQueue(&request)
+	if request.QueueAgency != "" {
+		t.Errorf("queue of request of no agency = %q, want none", request.QueueAgency)
+	}
+}
+
+func TestNewWorkload(t *testing.T) {
+	now := time.Date(2020, 3, 31, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) string { return now.Add(-d).Format(time.RFC3339) }
+	day := 24 * time.Hour
+
+	queue := []Request{
+		{ServiceRequestID: "a", RequestedDateTime: ago(2 * time.Hour), ExpectedDateTime: ago(-70 * time.Hour), AssignedTo: "crew-1"},
+		{ServiceRequestID: "b", RequestedDateTime: ago(2 * day), ExpectedDateTime: ago(3 * time.Hour), AssignedTo: "crew-1"},
+		{ServiceRequestID: "c", RequestedDateTime: ago(10 * day), ExpectedDateTime: ago(5 * day), AssignedTo: "crew-2"},
+		{ServiceRequestID: "d", RequestedDateTime: ago(45 * day)},
+		{ServiceRequestID: "e"},
+	}
+
+	w := NewWorkload(Agency{ID: "streets", Name: "Streets Division"}, queue, now)
+	if w.AgencyID != "streets" || w.Name != "Streets Division" {
+		t.Errorf("agency = %s %s", w.AgencyID, w.Name)
+	}
+	if w.Open != 5 || w.Overdue != 2 || w.Unassigned != 2 {
+		t.Errorf("open, overdue, unassigned = %d, %d, %d, want 5, 2, 2", w.Open, w.Overdue, w.Unassigned)
+	}
+	if w.Oldest != ago(45*day) {
+		t.Errorf("oldest = %s, want %s", w.Oldest, ago(45*day))
+	}
+
+	wantAging := []AgingBucket{{0, 1, 1}, {1, 3, 1}, {3, 7, 0}, {7, 14, 1}, {14, 30, 0}, {30, 0, 1}}
+	if len(w.Aging) != len(wantAging) {
+		t.Fatalf("aging = %+v, want %+v", w.Aging, wantAging)
+	}
+	for i := range wantAging {
+		if w.Aging[i] != wantAging[i] {
+			t.Errorf("aging[%d] = %+v, want %+v", i, w.Aging[i], wantAging[i])
+		}
+	}
+
+	wantWorkers := []WorkerLoad{{"crew-1", 2, 1}, {"crew-2", 1, 1}}
+	if len(w.Workers) != len(wantWorkers) || w.Workers[0] != wantWorkers[0] || w.Workers[1] != wantWorkers[1] {
+		t.Errorf("workers = %+v, want %+v", w.Workers, wantWorkers)
+	}
+
+	if len(w.OverdueItems) != 2 || w.OverdueItems[0].ServiceRequestID != "c" || w.OverdueItems[1].ServiceRequestID != "b" {
+		t.Fatalf("overdue items = %+v, want c then b", w.OverdueItems)
+	}
+	if w.OverdueItems[0].HoursOverdue != 120 || w.OverdueItems[0].AssignedTo != "crew-2" {
+		t.Errorf("overdue item = %+v, want 120 hours overdue, assigned to crew-2", w.OverdueItems[0])
+	}
+}
+
+func TestNewWorkloadEmpty(t *testing.T) {
+	w := NewWorkload(Agency{ID: "parks"}, nil, time.Now())
+	if w.Open != 0 || w.Workers == nil || len(w.Aging) != len(AgingBounds)+1 || w.OverdueItems != nil {
+		t.Errorf("NewWorkload() of empty queue = %+v", w)
+	}
+}
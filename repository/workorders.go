@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Work-order systems a city's requests can be synced with
+const (
+	ConnectorServiceNow = "servicenow"
+	ConnectorSalesforce = "salesforce"
+	ConnectorCityworks  = "cityworks"
+)
+
+// WorkOrderSettings connect a city to the work-order system its crews work from.  Requests become work orders there
+// as they are made, changes to requests are pushed to their work orders, and the statuses crews give work orders
+// are synced back.  The connector's credentials are kept in Secrets Manager, never on the Cities record.
+type WorkOrderSettings struct {
+	Connector string            `json:"connector"` // ConnectorServiceNow, ConnectorSalesforce or ConnectorCityworks. Empty syncs with no system
+	URL       string            `json:"url"`       // Base URL of the system, eg "https://troy.service-now.com"
+	SecretID  string            `json:"secret_id"` // Name of the Secrets Manager secret holding the connector's credentials as JSON, under open311/<city_name>/
+	Object    string            `json:"object"`    // ServiceNow table or Salesforce object work orders are records of. Empty for incident and Case
+	Services  []string          `json:"services"`  // service_codes whose requests become work orders. Empty for every service
+	Types     map[string]string `json:"types"`     // Work-order type of each service_code, eg a Cityworks template ID or a Salesforce case Type
+	Statuses  map[string]string `json:"statuses"`  // Request status of each work-order status, eg {"Closed": "closed"}
+}
+
+// Validate checks the settings of a work-order connector
+func (w WorkOrderSettings) Validate() error {
+	switch w.Connector {
+	case "":
+		return nil
+	case ConnectorServiceNow, ConnectorSalesforce, ConnectorCityworks:
+	default:
+		return fmt.Errorf("work order connector '%s' must be %s, %s or %s", w.Connector, ConnectorServiceNow, ConnectorSalesforce, ConnectorCityworks)
+	}
+	if !strings.HasPrefix(w.URL, "https://") {
+		return fmt.Errorf("work order url must be an https URL")
+	}
+	if w.SecretID == "" {
+		return fmt.Errorf("work order secret_id is required")
+	}
+	if len(w.Statuses) == 0 {
+		return fmt.Errorf("work order statuses are required, so work-order statuses can be synced back")
+	}
+	for external, status := range w.Statuses {
+		switch status {
+		case RequestOpen, RequestAccepted, RequestInProgress, RequestClosed:
+		default:
+			return fmt.Errorf("work order status '%s' maps to '%s', which is not a request status", external, status)
+		}
+	}
+	if w.Connector == ConnectorCityworks {
+		for _, code := range w.Services {
+			if w.Types[code] == "" {
+				return fmt.Errorf("work order type of service '%s' is required, since Cityworks creates work orders from templates", code)
+			}
+		}
+	}
+	return nil
+}
+
+// Handles reports whether requests for a service become work orders
+func (w WorkOrderSettings) Handles(serviceCode string) bool {
+	if w.Connector == "" {
+		return false
+	}
+	if len(w.Services) == 0 {
+		return true
+	}
+	for _, code := range w.Services {
+		if code == serviceCode {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestStatus returns the request status of a work-order status, and whether it is mapped
+func (w WorkOrderSettings) RequestStatus(external string) (string, bool) {
+	status, ok := w.Statuses[external]
+	return status, ok
+}
+
+// ExternalStatus returns the work-order status a request status is pushed as, and whether there is one.  When
+// several work-order statuses map to the request status, the first in alphabetical order is used.
+func (w WorkOrderSettings) ExternalStatus(status string) (string, bool) {
+	var externals []string
+	for external, s := range w.Statuses {
+		if s == status {
+			externals = append(externals, external)
+		}
+	}
+	if len(externals) == 0 {
+		return "", false
+	}
+	sort.Strings(externals)
+	return externals[0], true
+}
+
+// RecordWorkOrder sets the ID of the work order a request of a city became in the city's work-order system
+func RecordWorkOrder(cityID string, requestID string, workOrderID string) error {
+	svc, err := createCityClient(cityID)
+	if err != nil {
+		return err
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		ConditionExpression: aws.String("attribute_exists(service_request_id)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#W": aws.String("work_order_id"),
+			"#V": aws.String("version"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":w":   {S: aws.String(workOrderID)},
+			":one": {N: aws.String("1")},
+		},
+		Key: map[string]*dynamodb.AttributeValue{
+			"service_request_id": {
+				S: aws.String(requestID),
+			},
+		},
+		TableName:        aws.String(RequestsTable),
+		UpdateExpression: aws.String("SET #W = :w " + versionUpdate),
+	}
+
+	_, err = svc.UpdateItem(input)
+	if err != nil {
+		return fmt.Errorf("repository: failed to record work order %s of request %s. \n  %s", workOrderID, requestID, err)
+	}
+
+	return nil
+}
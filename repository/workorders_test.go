@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"testing"
+)
+
+func TestWorkOrderSettingsHandles(t *testing.T) {
+	settings := WorkOrderSettings{Connector: ConnectorServiceNow}
+	if !settings.Handles("pothole") {
+		t.Error("Handles() without services should handle every service")
+	}
+
+	settings.Services = []string{"pothole"}
+	if !settings.Handles("pothole") || settings.Handles("graffiti") {
+		t.Error("Handles() should handle only the services listed")
+	}
+
+	if (WorkOrderSettings{}).Handles("pothole") {
+		t.Error("Handles() without a connector should handle no service")
+	}
+}
+
+func TestWorkOrderSettingsStatuses(t *testing.T) {
+	settings := WorkOrderSettings{Statuses: map[string]string{
+		"New":      RequestOpen,
+		"Assigned": RequestOpen,
+		"Resolved": RequestClosed,
+	}}
+
+	if status, ok := settings.RequestStatus("Resolved"); !ok || status != RequestClosed {
+		t.Errorf("RequestStatus(Resolved) = %s, %v, want closed", status, ok)
+	}
+	if _, ok := settings.RequestStatus("On Hold"); ok {
+		t.Error("RequestStatus() of an unmapped status should not be mapped")
+	}
+
+	if external, ok := settings.ExternalStatus(RequestOpen); !ok || external != "Assigned" {
+		t.Errorf("ExternalStatus(open) = %s, %v, want Assigned, the first in alphabetical order", external, ok)
+	}
+	if _, ok := settings.ExternalStatus(RequestInProgress); ok {
+		t.Error("ExternalStatus() of an unmapped status should not be mapped")
+	}
+}
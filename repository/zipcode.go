@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// ZipCode is a US ZIP code, of five digits or ZIP+4, eg "01040" or "12180-4321".  It is kept as a string so leading
+// zeros survive.  ZIP codes stored or sent as numbers, as they were before, are read as five digits.
+type ZipCode string
+
+var warningLogger = log.New(os.Stderr, "WARNING\t", log.Lshortfile)
+
+var zipCodePattern = regexp.MustCompile(`^[0-9]{5}(-[0-9]{4})?$`)
+
+// ParseZipCode returns the ZIP code of a US postal code, eg "12180-4321" for "12180-4321" or "121804321", or an
+// empty ZipCode if it isn't one
+func ParseZipCode(postalCode string) ZipCode {
+	zip := strings.TrimSpace(postalCode)
+	if len(zip) == 9 && !strings.Contains(zip, "-") {
+		zip = zip[:5] + "-" + zip[5:]
+	}
+	if !zipCodePattern.MatchString(zip) {
+		return ""
+	}
+	return ZipCode(zip)
+}
+
+// Valid reports whether a ZIP code is five digits or ZIP+4.  An empty ZIP code, for one that isn't known, is not.
+func (z ZipCode) Valid() bool {
+	return zipCodePattern.MatchString(string(z))
+}
+
+// Area returns the five digits of a ZIP code, without any +4, or an empty string if it isn't valid
+func (z ZipCode) Area() string {
+	if !z.Valid() {
+		return ""
+	}
+	return string(z)[:5]
+}
+
+// numberZipCode returns the ZIP code stored or sent as a number, which lost its leading zeros.  0 is unknown.  ok is
+// false for a number that can't have been five digits.
+func numberZipCode(number string) (zip ZipCode, ok bool) {
+	v, err := strconv.ParseInt(number, 10, 32)
+	if err != nil || v < 0 || v > 99999 {
+		return "", false
+	}
+	if v == 0 {
+		return "", true
+	}
+	return ZipCode(fmt.Sprintf("%05d", v)), true
+}
+
+// UnmarshalJSON reads a ZIP code sent as a string, or as a number by clients written before it was a string.  A
+// number that isn't a ZIP code is kept as it was sent, for validation to refuse.
+func (z *ZipCode) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*z = ZipCode(strings.TrimSpace(text))
+		return nil
+	}
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return fmt.Errorf("repository: ZIP code %s is neither a string nor a number", data)
+	}
+	zip, ok := numberZipCode(number.String())
+	if !ok {
+		zip = ZipCode(number.String())
+	}
+	*z = zip
+	return nil
+}
+
+// UnmarshalDynamoDBAttributeValue reads a ZIP code stored as a string, or as a number by requests stored before it
+// was a string.  A stored number that isn't a ZIP code is read as unknown, so one bad item doesn't fail a listing.
+func (z *ZipCode) UnmarshalDynamoDBAttributeValue(av *dynamodb.AttributeValue) error {
+	switch {
+	case av.S != nil:
+		*z = ZipCode(*av.S)
+	case av.N != nil:
+		zip, ok := numberZipCode(*av.N)
+		if !ok {
+			warningLogger.Printf("Stored ZIP code %s is not five digits; reading it as unknown", *av.N)
+		}
+		*z = zip
+	default:
+		*z = ""
+	}
+	return nil
+}
+
+// setZipArea sets the zip_area a request is listed by ZIP code under, the five digits of its ZIP code
+func setZipArea(request *Request) {
+	request.ZipArea = request.ZipCode.Area()
+}
@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+func TestParseZipCode(t *testing.T) {
+	tests := map[string]ZipCode{
+		"12180":      "12180",
+		"12180-4321": "12180-4321",
+		"121804321":  "12180-4321",
+		" 02134 ":    "02134",
+		"":           "",
+		"K1A 0B1":    "",
+		"1218":       "",
+	}
+
+	for postalCode, want := range tests {
+		if got := ParseZipCode(postalCode); got != want {
+			t.Errorf("ParseZipCode(%q) = %q, want %q", postalCode, got, want)
+		}
+	}
+}
+
+func TestZipCodeArea(t *testing.T) {
+	tests := map[ZipCode]string{"01040": "01040", "12180-4321": "12180", "": "", "1040": ""}
+	for zipCode, want := range tests {
+		if got := zipCode.Area(); got != want {
+			t.Errorf("ZipCode(%q).Area() = %q, want %q", zipCode, got, want)
+		}
+	}
+}
+
+// ZIP codes sent or stored as numbers, before they were strings, keep reading as five digits
+func TestZipCodeNumbers(t *testing.T) {
+	request := Request{}
+	if err := json.Unmarshal([]byte(`{"zipcode": 1040}`), &request); err != nil || request.ZipCode != "01040" {
+		t.Errorf("json.Unmarshal() of a number = %q, %v, want 01040", request.ZipCode, err)
+	}
+	if err := json.Unmarshal([]byte(`{"zipcode": "12180-4321"}`), &request); err != nil || request.ZipCode != "12180-4321" {
+		t.Errorf("json.Unmarshal() of a string = %q, %v, want 12180-4321", request.ZipCode, err)
+	}
+	if err := json.Unmarshal([]byte(`{"zipcode": 123456}`), &request); err != nil || request.ZipCode.Valid() {
+		t.Errorf("json.Unmarshal() of a six digit number = %q, %v, want it kept for validation to refuse", request.ZipCode, err)
+	}
+
+	stored := map[string]*dynamodb.AttributeValue{"zipcode": {N: aws.String("1040")}}
+	request = Request{}
+	if err := dynamodbattribute.UnmarshalMap(stored, &request); err != nil || request.ZipCode != "01040" {
+		t.Errorf("UnmarshalMap() of a number = %q, %v, want 01040", request.ZipCode, err)
+	}
+	stored = map[string]*dynamodb.AttributeValue{"zipcode": {N: aws.String("-5")}, "service_request_id": {S: aws.String("42")}}
+	request = Request{}
+	if err := dynamodbattribute.UnmarshalMap(stored, &request); err != nil || request.ZipCode != "" || request.ServiceRequestID != "42" {
+		t.Errorf("UnmarshalMap() of a negative number = %q, %v, want the request read with no ZIP code", request.ZipCode, err)
+	}
+
+	// ZIP codes are written as strings, with the zip_area they are listed under
+	request = Request{ZipCode: "01040-1234"}
+	setZipArea(&request)
+	item, err := dynamodbattribute.MarshalMap(request)
+	if err != nil || aws.StringValue(item["zipcode"].S) != "01040-1234" || aws.StringValue(item["zip_area"].S) != "01040" {
+		t.Errorf("MarshalMap() = %v, %v, want the ZIP code and its area as strings", item, err)
+	}
+	if item, _ := dynamodbattribute.MarshalMap(Request{}); item["zipcode"] != nil || item["zip_area"] != nil {
+		t.Errorf("MarshalMap() of no ZIP code = %v, want neither stored", item)
+	}
+}
@@ -0,0 +1,154 @@
+// Package rpc serves the platform's cities, services and requests over gRPC, for internal consumers such as batch
+// jobs and partner services that would rather not pay for JSON.  The service is defined in
+// proto/open311/v1/open311.proto.  It can be run behind a load balancer by cmd/grpcserver, or registered on a
+// partner service's own grpc.Server in process.
+package rpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"time"
+
+	"github.com/social-torch/open311-services/proto/open311pb"
+	"github.com/social-torch/open311-services/repository"
+	"github.com/social-torch/open311-services/wire"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements the Open311 gRPC service over the repository
+type Server struct {
+	open311pb.UnimplementedOpen311Server
+}
+
+// NewServer returns a gRPC server serving the Open311 service.  When token isn't empty, calls must carry it in an
+// "authorization: Bearer <token>" header.
+func NewServer(token string) *grpc.Server {
+	var options []grpc.ServerOption
+	if token != "" {
+		options = append(options, grpc.UnaryInterceptor(unaryAuth(token)), grpc.StreamInterceptor(streamAuth(token)))
+	}
+	s := grpc.NewServer(options...)
+	open311pb.RegisterOpen311Server(s, &Server{})
+	return s
+}
+
+func (s *Server) GetCity(ctx context.Context, req *open311pb.GetCityRequest) (*open311pb.City, error) {
+	city, err := repository.GetCity(req.CityName)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return wire.ToCity(city), nil
+}
+
+func (s *Server) ListCities(ctx context.Context, req *open311pb.ListCitiesRequest) (*open311pb.ListCitiesResponse, error) {
+	cities, err := repository.GetCities()
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	response := &open311pb.ListCitiesResponse{}
+	for _, city := range cities {
+		response.Cities = append(response.Cities, wire.ToCity(city))
+	}
+	return response, nil
+}
+
+func (s *Server) GetService(ctx context.Context, req *open311pb.GetServiceRequest) (*open311pb.Service, error) {
+	service, err := repository.GetService(req.ServiceCode)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return wire.ToService(service), nil
+}
+
+func (s *Server) ListServices(ctx context.Context, req *open311pb.ListServicesRequest) (*open311pb.ListServicesResponse, error) {
+	services, err := repository.GetServices(req.CityId)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	response := &open311pb.ListServicesResponse{}
+	for _, service := range services {
+		response.Services = append(response.Services, wire.ToService(service))
+	}
+	return response, nil
+}
+
+func (s *Server) GetRequest(ctx context.Context, req *open311pb.GetRequestRequest) (*open311pb.Request, error) {
+	request, err := repository.GetRequest(req.CityId, req.ServiceRequestId)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return wire.ToRequest(request), nil
+}
+
+// ListRequests streams the requests made in a range, oldest first
+func (s *Server) ListRequests(req *open311pb.ListRequestsRequest, stream open311pb.Open311_ListRequestsServer) error {
+	if req.Start == nil {
+		return status.Error(codes.InvalidArgument, "start is required")
+	}
+	start := req.Start.AsTime()
+	end := time.Now()
+	if req.End != nil {
+		end = req.End.AsTime()
+	}
+	if end.Before(start) {
+		return status.Error(codes.InvalidArgument, "end must be after start")
+	}
+
+	requests, err := repository.GetRequestsBetween(req.CityId, start, end)
+	if err != nil {
+		return grpcError(err)
+	}
+	for _, request := range requests {
+		if req.Status != "" && request.Status != req.Status {
+			continue
+		}
+		if err := stream.Send(wire.ToRequest(request)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// grpcError converts an error of the repository into a gRPC status, NotFound for records that aren't there
+func grpcError(err error) error {
+	switch err.(type) {
+	case *repository.CityNotFoundErr, *repository.ServiceCodeNotFoundErr, *repository.RequestIdNotFoundErr:
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// authorized reports whether a call carries the token
+func authorized(ctx context.Context, token string) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false
+	}
+	for _, value := range md.Get("authorization") {
+		if subtle.ConstantTimeCompare([]byte(value), []byte("Bearer "+token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func unaryAuth(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !authorized(ctx, token) {
+			return nil, status.Error(codes.Unauthenticated, "a valid bearer token is required")
+		}
+		return handler(ctx, req)
+	}
+}
+
+func streamAuth(token string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !authorized(ss.Context(), token) {
+			return status.Error(codes.Unauthenticated, "a valid bearer token is required")
+		}
+		return handler(srv, ss)
+	}
+}
@@ -0,0 +1,87 @@
+// Package snapshot publishes static copies of the hottest reads, a city's services and its recent requests, to a
+// bucket served through CloudFront.  When everyone opens the map during a storm, readers are redirected to the
+// copies rather than each reading DynamoDB.  Cities opt in with the static_snapshots feature flag.
+package snapshot
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/features"
+	"github.com/social-torch/open311-services/repository"
+	"github.com/social-torch/open311-services/wire"
+)
+
+// Files of a city's snapshot
+const (
+	ServicesFile = "services.json" // As GET /services lists them
+	RequestsFile = "requests.json" // As GET /requests lists them by default, less what identifies residents and staff
+)
+
+// RequestsWindow is the span of requests in a snapshot, that of GET /requests without a start_date or end_date
+const RequestsWindow = 90 * 24 * time.Hour
+
+// MaxAge is how long readers and CloudFront may cache a snapshot.  Snapshots are refreshed as often.
+const MaxAge = time.Minute
+
+// Key returns the key of a city's snapshot file in the snapshot bucket
+func Key(cityName string, file string) string {
+	return "snapshots/" + cityName + "/" + file
+}
+
+// Location returns the URL a call is redirected to, or "" when it should be answered from the tables.  Only plain
+// listings are redirected: calls asking for no more than the city, as JSON, to cities that have switched on
+// static_snapshots, when SNAPSHOT_URL is configured.  Snapshots are JSON, so apps negotiating protobuf are answered
+// from the tables too.  Callers should answer staff, who need the live and full
+// listing, themselves.
+func Location(req events.APIGatewayProxyRequest, cityName string, file string) string {
+	base := os.Getenv("SNAPSHOT_URL")
+	if base == "" || cityName == "" || !plain(req) {
+		return ""
+	}
+
+	on, err := features.Enabled(cityName, repository.FeatureStaticSnapshots)
+	if err != nil || !on {
+		return ""
+	}
+	return strings.TrimSuffix(base, "/") + "/" + Key(cityName, file)
+}
+
+// plain reports whether a call asks for no more than a city's listing as JSON
+func plain(req events.APIGatewayProxyRequest) bool {
+	if wire.Protobuf(wire.Accept(req.Headers)) {
+		return false
+	}
+	for name, value := range req.QueryStringParameters {
+		switch {
+		case name == "city_id" || name == "jurisdiction_id":
+		case name == "format" && (value == "" || value == "json"):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Redirect answers a call with a redirect to its snapshot
+func Redirect(location string) (events.APIGatewayProxyResponse, error) {
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusFound,
+		Headers: map[string]string{
+			"Location":                    location,
+			"Access-Control-Allow-Origin": "*",
+			"Cache-Control":               "max-age=" + strconv.Itoa(int(MaxAge.Seconds())),
+		},
+	}, nil
+}
+
+// Request returns a request as it is published in a snapshot: its summary, as GET /requests lists it.  Snapshots are
+// readable by anyone with the URL, and a summary carries none of the accounts of the resident who made it or of
+// commenters, who it is assigned to, its audit log or its work order.
+func Request(request repository.Request) repository.RequestSummary {
+	return request.Summary()
+}
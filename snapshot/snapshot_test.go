@@ -0,0 +1,79 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestPlain(t *testing.T) {
+	tests := []struct {
+		query map[string]string
+		plain bool
+	}{
+		{nil, true},
+		{map[string]string{"city_id": "troy"}, true},
+		{map[string]string{"jurisdiction_id": "troy", "format": "json"}, true},
+		{map[string]string{"city_id": "troy", "format": "geojson"}, false},
+		{map[string]string{"city_id": "troy", "start_date": "2019-06-01T00:00:00Z"}, false},
+	}
+
+	for _, tt := range tests {
+		req := events.APIGatewayProxyRequest{QueryStringParameters: tt.query}
+		if got := plain(req); got != tt.plain {
+			t.Errorf("plain(%v) = %v, want %v", tt.query, got, tt.plain)
+		}
+	}
+
+	req := events.APIGatewayProxyRequest{
+		QueryStringParameters: map[string]string{"city_id": "troy"},
+		Headers:               map[string]string{"Accept": "application/x-protobuf"},
+	}
+	if plain(req) {
+		t.Error("plain() of a call accepting protobuf = true, want false")
+	}
+}
+
+func TestLocationUnconfigured(t *testing.T) {
+	req := events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"city_id": "troy"}}
+	if got := Location(req, "troy", RequestsFile); got != "" {
+		t.Errorf("Location() without SNAPSHOT_URL = %s, want \"\"", got)
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	resp, _ := Redirect("https://d111111abcdef8.cloudfront.net/" + Key("troy", ServicesFile))
+	if resp.StatusCode != http.StatusFound || resp.Headers["Location"] != "https://d111111abcdef8.cloudfront.net/snapshots/troy/services.json" {
+		t.Errorf("Redirect() = %d to %s", resp.StatusCode, resp.Headers["Location"])
+	}
+	if resp.Headers["Cache-Control"] != "max-age=60" {
+		t.Errorf("Redirect() Cache-Control = %s, want max-age=60", resp.Headers["Cache-Control"])
+	}
+}
+
+func TestRequest(t *testing.T) {
+	request := repository.Request{
+		ServiceRequestID: "abc",
+		Status:           repository.RequestOpen,
+		AccountID:        "resident",
+		AssignedTo:       "crew-4",
+		WorkOrderID:      "INC0010001",
+		AuditLog:         []repository.AuditEntry{{ChangeNote: "created"}},
+		Comments:         []repository.Comment{{AccountID: "neighbor", Text: "Still there"}},
+	}
+
+	published := Request(request)
+	if published.ServiceRequestID != "abc" || published.Status != repository.RequestOpen {
+		t.Errorf("Request() = %+v, want the request's ID and status kept", published)
+	}
+	body, _ := json.Marshal(published)
+	for _, private := range []string{"resident", "crew-4", "INC0010001", "created", "neighbor"} {
+		if strings.Contains(string(body), private) {
+			t.Errorf("Request() = %s, want accounts, assignee, work order, audit log and comments left out", body)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Package validate checks the fields of requests residents submit before anything is stored, reporting every field
+// that is wrong at once so clients can point at each of them.
+package validate
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/social-torch/open311-services/apierror"
+	"github.com/social-torch/open311-services/repository"
+)
+
+// MaxDescriptionLength is the most characters a request's description may have, several screens of text on a phone
+const MaxDescriptionLength = 4000
+
+// FieldErr is what is wrong with a field of a request, named as in its JSON, with a code apps show a localized
+// message for, eg "out_of_range"
+type FieldErr struct {
+	Field   string
+	Code    string
+	Message string
+}
+
+// InvalidRequestErr is returned for a request with fields that are wrong, each of which it lists
+type InvalidRequestErr struct {
+	Fields []FieldErr
+}
+
+func (e *InvalidRequestErr) Error() string {
+	messages := []string{}
+	for _, field := range e.Fields {
+		messages = append(messages, field.Message)
+	}
+	return "invalid request: " + strings.Join(messages, "; ")
+}
+
+// ErrorCode returns the code of the error in the API's error bodies
+func (e *InvalidRequestErr) ErrorCode() string {
+	return "invalid_request"
+}
+
+// ErrorDetails returns each field that is wrong, for the API's error bodies
+func (e *InvalidRequestErr) ErrorDetails() []apierror.Detail {
+	details := []apierror.Detail{}
+	for _, field := range e.Fields {
+		details = append(details, apierror.Detail{Code: field.Code, Message: field.Message, Field: field.Field})
+	}
+	return details
+}
+
+// Request checks the fields a resident submits with a request: that lat and lon are in range, the description isn't
+// too long, times are RFC3339, the media_url is a web URL and the zipcode is a ZIP code.  Fields left out aren't
+// checked.  If any field is wrong, an InvalidRequestErr error listing them is set
+func Request(request repository.Request) error {
+	fields := []FieldErr{}
+	invalid := func(field string, code string, format string, args ...interface{}) {
+		fields = append(fields, FieldErr{field, code, fmt.Sprintf(format, args...)})
+	}
+
+	lat, lon := request.Coordinates()
+	if lat < -90 || lat > 90 {
+		invalid("lat", "out_of_range", "lat %f must be between -90 and 90", lat)
+	}
+	if lon < -180 || lon > 180 {
+		invalid("lon", "out_of_range", "lon %f must be between -180 and 180", lon)
+	}
+
+	if n := utf8.RuneCountInString(request.Description); n > MaxDescriptionLength {
+		invalid("description", "too_long", "description is %d characters, and may be at most %d", n, MaxDescriptionLength)
+	}
+
+	times := []struct {
+		field string
+		value string
+	}{
+		{"requested_datetime", request.RequestedDateTime},
+		{"update_datetime", request.UpdatedDateTime},
+		{"expected_datetime", request.ExpectedDateTime},
+		{"scheduled_datetime", request.ScheduledDateTime},
+	}
+	for _, t := range times {
+		if t.value == "" {
+			continue
+		}
+		if _, err := time.Parse(time.RFC3339, t.value); err != nil {
+			invalid(t.field, "invalid_datetime", "%s must be a w3 datetime, eg 2019-06-02T08:00:00-04:00", t.field)
+		}
+	}
+
+	if request.MediaURL != "" && !webURL(request.MediaURL) {
+		invalid("media_url", "invalid_url", "media_url must be an http or https URL")
+	}
+
+	if request.ZipCode != "" && !request.ZipCode.Valid() {
+		invalid("zipcode", "invalid_zipcode", "zipcode '%s' must be a five digit ZIP code or ZIP+4, eg 12180-4321", request.ZipCode)
+	}
+
+	if len(fields) > 0 {
+		return &InvalidRequestErr{fields}
+	}
+	return nil
+}
+
+// webURL reports whether a URL is an absolute http or https URL with a host
+func webURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
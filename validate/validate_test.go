@@ -0,0 +1,65 @@
+package validate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestRequest(t *testing.T) {
+	valid := repository.Request{
+		ServiceCode:       "pothole",
+		Description:       "Deep pothole in the right lane",
+		Location:          repository.Location{Latitude: 42.65, Longitude: -73.75},
+		RequestedDateTime: "2019-06-02T08:00:00-04:00",
+		MediaURL:          "https://example.com/pothole.jpg",
+		ZipCode:           "12207",
+	}
+	if err := Request(valid); err != nil {
+		t.Errorf("Request() of a valid request = %v", err)
+	}
+	if err := Request(repository.Request{ServiceCode: "pothole", Address: "1 Monument Sq"}); err != nil {
+		t.Errorf("Request() leaving fields out = %v", err)
+	}
+
+	tests := map[string]func(r *repository.Request){
+		"lat":                func(r *repository.Request) { r.Latitude = 91 },
+		"lon":                func(r *repository.Request) { r.Longitude = -181 },
+		"description":        func(r *repository.Request) { r.Description = strings.Repeat("é", MaxDescriptionLength+1) },
+		"requested_datetime": func(r *repository.Request) { r.RequestedDateTime = "June 2nd" },
+		"scheduled_datetime": func(r *repository.Request) { r.ScheduledDateTime = "2019-06-02 08:00" },
+		"media_url":          func(r *repository.Request) { r.MediaURL = "javascript:alert(1)" },
+		"zipcode":            func(r *repository.Request) { r.ZipCode = "123456" },
+	}
+	for field, change := range tests {
+		request := valid
+		change(&request)
+		err := Request(request)
+		invalid, ok := err.(*InvalidRequestErr)
+		if !ok || len(invalid.Fields) != 1 || invalid.Fields[0].Field != field {
+			t.Errorf("Request() with a bad %s = %v, want an InvalidRequestErr naming only it", field, err)
+		}
+	}
+
+	// A description at the limit is fine, however many bytes its characters take
+	request := valid
+	request.Description = strings.Repeat("é", MaxDescriptionLength)
+	if err := Request(request); err != nil {
+		t.Errorf("Request() with a description at the limit = %v", err)
+	}
+
+	// Every field that is wrong is reported
+	request = valid
+	request.Latitude, request.MediaURL, request.ZipCode = -100, "/relative.jpg", "1234"
+	if invalid, ok := Request(request).(*InvalidRequestErr); !ok || len(invalid.Fields) != 3 {
+		t.Errorf("Request() with three bad fields = %v, want all three", invalid)
+	}
+
+	// Each field is detailed in the API's error body, with its own code
+	invalid, _ := Request(request).(*InvalidRequestErr)
+	details := invalid.ErrorDetails()
+	if len(details) != 3 || details[0].Field != "lat" || details[0].Code != "out_of_range" || invalid.ErrorCode() != "invalid_request" {
+		t.Errorf("ErrorDetails() = %+v", details)
+	}
+}
@@ -0,0 +1,78 @@
+// Package warmup lets functions answer the calls of scheduled warmers without running their handlers, and reports
+// their cold starts.  Operators size provisioned concurrency, or the rate of a warmer, from the ColdStarts and
+// InitDuration metrics of each handler.
+package warmup
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/social-torch/open311-services/metrics"
+)
+
+// Event is the payload of a warm-up call, {"warmup": true}, eg the constant input of a scheduled rule
+type Event struct {
+	Warmup bool `json:"warmup"`
+}
+
+// started is when the function instance began initializing, as near as a package can tell
+var started = time.Now()
+
+var errorLogger = log.New(os.Stderr, "ERROR\t", log.Lshortfile)
+
+// Start runs a handler as lambda.Start does, but answers warm-up calls itself: prepare, when not nil, creates the
+// clients and fills the caches the handler's calls use, and the call returns without reaching the handler.  The
+// first call an instance answers, warm-up or not, counts a ColdStart and records the InitDuration since the instance
+// started, both broken down by Handler and InitializationType (on-demand or provisioned-concurrency).
+func Start(name string, handler interface{}, prepare func() error) {
+	lambda.StartHandler(newHandler(name, lambda.NewHandler(handler), prepare))
+}
+
+// warmable is a Lambda handler answering warm-up calls before its own
+type warmable struct {
+	name    string
+	handler lambda.Handler
+	prepare func() error
+	cold    sync.Once
+}
+
+func newHandler(name string, handler lambda.Handler, prepare func() error) *warmable {
+	return &warmable{name: name, handler: handler, prepare: prepare}
+}
+
+// Invoke answers a call, a warm-up call without calling the handler
+func (w *warmable) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+	w.cold.Do(func() {
+		dimensions := []metrics.Dimension{
+			{Name: "Handler", Value: w.name},
+			{Name: "InitializationType", Value: os.Getenv("AWS_LAMBDA_INITIALIZATION_TYPE")},
+		}
+		metrics.Count("ColdStarts", dimensions...)
+		metrics.Duration("InitDuration", started, dimensions...)
+	})
+
+	if !isWarmup(payload) {
+		return w.handler.Invoke(ctx, payload)
+	}
+
+	metrics.Count("WarmUps", metrics.Dimension{Name: "Handler", Value: w.name})
+	if w.prepare != nil {
+		if err := w.prepare(); err != nil {
+			errorLogger.Printf("Warm-up of %s failed: %s", w.name, err)
+			return nil, err
+		}
+	}
+	return []byte(`{"warm":true}`), nil
+}
+
+// isWarmup reports whether the payload of a call is a warm-up Event.  The events of API Gateway, streams and
+// schedules have no warmup field, so they are never taken for one.
+func isWarmup(payload []byte) bool {
+	event := Event{}
+	return json.Unmarshal(payload, &event) == nil && event.Warmup
+}
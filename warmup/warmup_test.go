@@ -0,0 +1,48 @@
+package warmup
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// handlerFunc is a lambda.Handler recording whether it was called
+type handlerFunc func(payload []byte) ([]byte, error)
+
+func (f handlerFunc) Invoke(ctx context.Context, payload []byte) ([]byte, error) {
+	return f(payload)
+}
+
+func TestInvoke(t *testing.T) {
+	called := 0
+	handler := handlerFunc(func(payload []byte) ([]byte, error) {
+		called++
+		return []byte(`{"statusCode":200}`), nil
+	})
+	prepared := 0
+	w := newHandler("request", handler, func() error {
+		prepared++
+		return nil
+	})
+
+	if reply, err := w.Invoke(context.Background(), []byte(`{"warmup": true}`)); err != nil || string(reply) != `{"warm":true}` {
+		t.Errorf("Invoke() of a warm-up = %s, %v", reply, err)
+	}
+	if called != 0 || prepared != 1 {
+		t.Errorf("Invoke() of a warm-up called the handler %d times and prepare %d, want 0 and 1", called, prepared)
+	}
+
+	for _, payload := range []string{`{"httpMethod": "GET", "resource": "/requests"}`, `{"warmup": false}`, `[]`} {
+		if reply, err := w.Invoke(context.Background(), []byte(payload)); err != nil || string(reply) != `{"statusCode":200}` {
+			t.Errorf("Invoke(%s) = %s, %v, want the handler's reply", payload, reply, err)
+		}
+	}
+	if called != 3 || prepared != 1 {
+		t.Errorf("Invoke() of other calls called the handler %d times and prepare %d, want 3 and 1", called, prepared)
+	}
+
+	failing := newHandler("request", handler, func() error { return errors.New("throttled") })
+	if _, err := failing.Invoke(context.Background(), []byte(`{"warmup": true}`)); err == nil {
+		t.Error("Invoke() of a warm-up that failed to prepare should fail")
+	}
+}
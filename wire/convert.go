@@ -0,0 +1,88 @@
+package wire
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/social-torch/open311-services/proto/open311pb"
+	"github.com/social-torch/open311-services/repository"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// timestamp converts an RFC3339 time of a record, leaving it unset when it is empty or not a time
+func timestamp(value string) *timestamppb.Timestamp {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// numberZipCode returns the five digits of a ZIP code as the number clients read before ZIP codes were strings, 0
+// when it is unknown
+func numberZipCode(zipCode repository.ZipCode) int32 {
+	v, _ := strconv.Atoi(zipCode.Area())
+	return int32(v)
+}
+
+// ToCity converts a city.  Federation credentials stay out of it, as they stay out of the REST API.
+func ToCity(c repository.City) *open311pb.City {
+	return &open311pb.City{
+		CityName:    c.CityName,
+		Endpoint:    c.Endpoint,
+		Federated:   c.Federated,
+		Deactivated: c.Deactivated,
+		Region:      c.DataRegion(),
+		Bbox:        c.BoundingBox,
+		TimeZone:    c.Config.TimeZone,
+	}
+}
+
+func ToService(s repository.Service) *open311pb.Service {
+	return &open311pb.Service{
+		ServiceCode: s.ServiceCode,
+		ServiceName: s.ServiceName,
+		Description: s.Description,
+		Group:       s.Group,
+		Keywords:    s.Keywords,
+		Emergency:   s.Emergency,
+		SlaHours:    int32(s.SLAHours),
+		CityId:      s.CityID,
+	}
+}
+
+func ToRequest(r repository.Request) *open311pb.Request {
+	request := &open311pb.Request{
+		ServiceRequestId:  r.ServiceRequestID,
+		CityId:            r.CityID,
+		Status:            r.Status,
+		StatusNotes:       r.StatusNotes,
+		ServiceCode:       r.ServiceCode,
+		ServiceName:       r.ServiceName,
+		Description:       r.Description,
+		AgencyResponsible: r.AgencyResponsible,
+		AgencyPath:        r.AgencyPath,
+		Address:           r.Address,
+		Zipcode:           numberZipCode(r.ZipCode),
+		ZipCode:           string(r.ZipCode),
+		Neighborhood:      r.Neighborhood,
+		AssetId:           r.AssetID,
+		MediaUrl:          r.MediaURL,
+		AccountId:         r.AccountID,
+		AssignedTo:        r.AssignedTo,
+		RequestedDatetime: timestamp(r.RequestedDateTime),
+		UpdatedDatetime:   timestamp(r.UpdatedDateTime),
+		ExpectedDatetime:  timestamp(r.ExpectedDateTime),
+		ClosedDatetime:    timestamp(r.ClosedDateTime),
+		ResolutionHours:   r.ResolutionHours,
+		EscalationLevel:   int32(r.EscalationLevel),
+	}
+	if r.HasLocation() {
+		lat, lon := r.Coordinates()
+		request.Location = &open311pb.Location{Lat: lat, Lon: lon}
+	}
+	for _, c := range r.Comments {
+		request.Comments = append(request.Comments, &open311pb.Comment{AccountId: c.AccountID, Text: c.Text, Timestamp: timestamp(c.Timestamp)})
+	}
+	return request
+}
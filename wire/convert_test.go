@@ -0,0 +1,51 @@
+package wire
+
+import (
+	"testing"
+	"time"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func TestToRequest(t *testing.T) {
+	location, _ := repository.NewLocation(42.73, -73.69)
+	r := ToRequest(repository.Request{
+		ServiceRequestID:  "SR-01ABC",
+		CityID:            "troy",
+		Status:            repository.RequestClosed,
+		ServiceCode:       "troy-pothole",
+		Location:          location,
+		RequestedDateTime: "2019-06-01T12:00:00Z",
+		ClosedDateTime:    "2019-06-02T18:30:00Z",
+		ExpectedDateTime:  "",
+		ResolutionHours:   30.5,
+		Comments:          []repository.Comment{{AccountID: "clerk", Text: "Crew sent", Timestamp: "2019-06-02T09:00:00Z"}},
+	})
+
+	if r.ServiceRequestId != "SR-01ABC" || r.CityId != "troy" || r.ResolutionHours != 30.5 {
+		t.Errorf("ToRequest() = %+v", r)
+	}
+	if r.Location == nil || r.Location.Lat != 42.73 || r.Location.Lon != -73.69 {
+		t.Errorf("ToRequest() location = %+v, want 42.73,-73.69", r.Location)
+	}
+	if !r.RequestedDatetime.AsTime().Equal(time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)) || r.ExpectedDatetime != nil {
+		t.Errorf("ToRequest() times = %v, %v, want 2019-06-01T12:00:00Z and unset", r.RequestedDatetime, r.ExpectedDatetime)
+	}
+	if len(r.Comments) != 1 || r.Comments[0].Text != "Crew sent" {
+		t.Errorf("ToRequest() comments = %v", r.Comments)
+	}
+
+	if r := ToRequest(repository.Request{Address: "1 Monument Sq"}); r.Location != nil {
+		t.Errorf("ToRequest() of a request located by address has location %v", r.Location)
+	}
+}
+
+func TestToCity(t *testing.T) {
+	c := ToCity(repository.City{CityName: "troy", FederationAPIKey: "secret", Region: "eu-west-1"})
+	if c.CityName != "troy" || c.Region != "eu-west-1" {
+		t.Errorf("ToCity() = %+v", c)
+	}
+	if c2 := ToCity(repository.City{CityName: "albany"}); c2.Region != repository.AwsRegion {
+		t.Errorf("ToCity() region of an unpinned city = %s, want %s", c2.Region, repository.AwsRegion)
+	}
+}
@@ -0,0 +1,97 @@
+// Package wire converts the platform's records to the protobuf messages of proto/open311/v1/open311.proto, for the
+// gRPC service and for REST listings negotiated as protobuf.  The mobile app asks for listings with
+// Accept: application/x-protobuf, as large request lists are far smaller and quicker to parse that way on cellular
+// connections than as JSON.
+package wire
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/social-torch/open311-services/proto/open311pb"
+	"github.com/social-torch/open311-services/repository"
+	"google.golang.org/protobuf/proto"
+)
+
+// ContentTypeProtobuf is the content type of listings serialized as protobuf
+const ContentTypeProtobuf = "application/x-protobuf"
+
+// Accept returns the Accept header of a call, whatever the case its client sent it in
+func Accept(headers map[string]string) string {
+	for name, value := range headers {
+		if strings.EqualFold(name, "Accept") {
+			return value
+		}
+	}
+	return ""
+}
+
+// Protobuf reports whether an Accept header prefers protobuf to JSON.  Protobuf must be named, and with at least the
+// quality of the most preferred range JSON matches, so browsers and clients sending */* keep getting JSON.
+func Protobuf(accept string) bool {
+	protobuf, json := 0.0, 0.0
+	for _, mediaRange := range strings.Split(accept, ",") {
+		params := strings.Split(mediaRange, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+
+		quality := 1.0
+		for _, param := range params[1:] {
+			pair := strings.SplitN(param, "=", 2)
+			if len(pair) != 2 || strings.ToLower(strings.TrimSpace(pair[0])) != "q" {
+				continue
+			}
+			if q, err := strconv.ParseFloat(strings.TrimSpace(pair[1]), 64); err == nil {
+				quality = q
+			}
+		}
+
+		switch mediaType {
+		case ContentTypeProtobuf:
+			protobuf = quality
+		case "application/json", "application/*", "*/*":
+			if quality > json {
+				json = quality
+			}
+		}
+	}
+	return protobuf > 0 && protobuf >= json
+}
+
+// Requests serializes a listing of requests as a RequestList, base64 encoded for an API Gateway proxy response
+func Requests(requests []repository.Request) (string, error) {
+	list := &open311pb.RequestList{}
+	for _, request := range requests {
+		list.Requests = append(list.Requests, ToRequest(request))
+	}
+	return marshal(list)
+}
+
+// RequestEntry serializes a request as a RequestList of one, not encoded.  RequestLists concatenate into the list
+// of all their requests, so a listing can be built a request at a time and base64 encoded once it is complete.
+func RequestEntry(request repository.Request) ([]byte, error) {
+	body, err := proto.Marshal(&open311pb.RequestList{Requests: []*open311pb.Request{ToRequest(request)}})
+	if err != nil {
+		return nil, fmt.Errorf("wire: error marshalling request %s: %s", request.ServiceRequestID, err)
+	}
+	return body, nil
+}
+
+// Services serializes a listing of services as a ListServicesResponse, base64 encoded for an API Gateway proxy
+// response
+func Services(services []repository.Service) (string, error) {
+	list := &open311pb.ListServicesResponse{}
+	for _, service := range services {
+		list.Services = append(list.Services, ToService(service))
+	}
+	return marshal(list)
+}
+
+func marshal(message proto.Message) (string, error) {
+	body, err := proto.Marshal(message)
+	if err != nil {
+		return "", fmt.Errorf("wire: error marshalling %T: %s", message, err)
+	}
+	return base64.StdEncoding.EncodeToString(body), nil
+}
@@ -0,0 +1,32 @@
+package wire
+
+import "testing"
+
+func TestProtobuf(t *testing.T) {
+	tests := map[string]bool{
+		"":                                  false,
+		"*/*":                               false,
+		"application/json":                  false,
+		"application/x-protobuf":            true,
+		"Application/X-Protobuf":            true,
+		"application/x-protobuf, */*":       true,
+		"application/x-protobuf;q=0.5, */*": false,
+		"application/json;q=0.5, application/x-protobuf": true,
+		"application/x-protobuf;q=0":                     false,
+		"text/html, application/xhtml+xml, */*;q=0.8":    false,
+	}
+	for accept, want := range tests {
+		if got := Protobuf(accept); got != want {
+			t.Errorf("Protobuf(%q) = %v, want %v", accept, got, want)
+		}
+	}
+}
+
+func TestAccept(t *testing.T) {
+	if got := Accept(map[string]string{"accept": "application/x-protobuf"}); got != "application/x-protobuf" {
+		t.Errorf("Accept() = %q, want the lower-case header", got)
+	}
+	if got := Accept(map[string]string{"Host": "api.example.org"}); got != "" {
+		t.Errorf("Accept() = %q, want empty", got)
+	}
+}
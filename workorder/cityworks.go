@@ -0,0 +1,131 @@
+package workorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+// cityworks connects to Cityworks' AMS API.  Calls post their parameters as a data form field of JSON, with the
+// token got by signing in, and answer with a Status of 0 on success.  Work orders are made from the template
+// given as the type of the request's service.
+type cityworks struct {
+	settings    repository.WorkOrderSettings
+	credentials Credentials
+	token       string // Token of the session, once signed in
+}
+
+// cityworksWorkOrder is the part of a Cityworks work order read back
+type cityworksWorkOrder struct {
+	WorkOrderID string `json:"WorkOrderId"`
+	Status      string `json:"Status"`
+}
+
+func (c *cityworks) Create(request repository.Request) (string, error) {
+	template := c.settings.Types[request.ServiceCode]
+	if template == "" {
+		return "", fmt.Errorf("workorder: no Cityworks template for service %s", request.ServiceCode)
+	}
+
+	data := map[string]interface{}{
+		"WOTemplateId": template,
+		"Address":      request.Address,
+		"Instructions": details(request),
+		"Text1":        request.ServiceRequestID,
+	}
+	var workOrders []cityworksWorkOrder
+	if err := c.call("Ams/WorkOrder/Create", data, &workOrders); err != nil {
+		return "", err
+	}
+	if len(workOrders) == 0 || workOrders[0].WorkOrderID == "" {
+		return "", fmt.Errorf("workorder: %s created no work order for request %s", c.settings.URL, request.ServiceRequestID)
+	}
+	return workOrders[0].WorkOrderID, nil
+}
+
+func (c *cityworks) Update(id string, request repository.Request) error {
+	data := map[string]interface{}{
+		"WorkOrderId":  id,
+		"Address":      request.Address,
+		"Instructions": details(request),
+	}
+	if status, ok := c.settings.ExternalStatus(request.Status); ok {
+		data["Status"] = status
+	}
+	return c.call("Ams/WorkOrder/Update", data, nil)
+}
+
+func (c *cityworks) Status(id string) (string, error) {
+	var workOrder cityworksWorkOrder
+	if err := c.call("Ams/WorkOrder/ById", map[string]interface{}{"WorkOrderId": id}, &workOrder); err != nil {
+		return "", err
+	}
+	return workOrder.Status, nil
+}
+
+// signIn starts a session with the connector's username and password
+func (c *cityworks) signIn() error {
+	var result struct {
+		Token string `json:"Token"`
+	}
+	data := map[string]interface{}{"LoginName": c.credentials.Username, "Password": c.credentials.Password}
+	if err := c.post("General/Authentication/Authenticate", data, &result); err != nil {
+		return err
+	}
+	if result.Token == "" {
+		return fmt.Errorf("workorder: %s returned no token", c.settings.URL)
+	}
+	c.token = result.Token
+	return nil
+}
+
+// call sends a call of a service, signing in first if need be
+func (c *cityworks) call(service string, data map[string]interface{}, result interface{}) error {
+	if c.token == "" {
+		if err := c.signIn(); err != nil {
+			return err
+		}
+	}
+	return c.post(service, data, result)
+}
+
+// post sends a call of a service, decoding the Value of its response into result, if any
+func (c *cityworks) post(service string, data map[string]interface{}, result interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("workorder: error marshalling work order: %s", err)
+	}
+	form := url.Values{"data": {string(b)}}
+	if c.token != "" {
+		form.Set("token", c.token)
+	}
+
+	req, err := http.NewRequest("POST", base(c.settings)+"/Services/"+service, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("workorder: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var response struct {
+		Status  int             `json:"Status"`
+		Message string          `json:"Message"`
+		Value   json.RawMessage `json:"Value"`
+	}
+	if err := do(req, &response); err != nil {
+		return err
+	}
+	if response.Status != 0 {
+		return fmt.Errorf("workorder: %s refused %s: %s", c.settings.URL, service, response.Message)
+	}
+	if result == nil || len(response.Value) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(response.Value, result); err != nil {
+		return fmt.Errorf("workorder: unreadable %s response of %s: %s", service, c.settings.URL, err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package workorder
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+// salesforceObject is the object work orders are records of unless the city names another, eg WorkOrder
+const salesforceObject = "Case"
+
+// salesforceVersion is the REST API version called
+const salesforceVersion = "v58.0"
+
+// salesforce connects to Salesforce's REST API, signing in with the OAuth client credentials flow of a connected
+// app.  Records are made with Origin "Open311" and the work-order type as their Type.
+type salesforce struct {
+	settings    repository.WorkOrderSettings
+	credentials Credentials
+	token       string // Access token, once signed in
+	instance    string // URL of the org's instance API calls are made to, once signed in
+}
+
+func (s *salesforce) Create(request repository.Request) (string, error) {
+	record := map[string]string{
+		"Subject":     title(request),
+		"Description": details(request),
+		"Origin":      "Open311",
+	}
+	if t := s.settings.Types[request.ServiceCode]; t != "" {
+		record["Type"] = t
+	}
+	if status, ok := s.settings.ExternalStatus(request.Status); ok {
+		record["Status"] = status
+	}
+
+	var result struct {
+		ID      string `json:"id"`
+		Success bool   `json:"success"`
+	}
+	if err := s.call("POST", "", record, &result); err != nil {
+		return "", err
+	}
+	if !result.Success || result.ID == "" {
+		return "", fmt.Errorf("workorder: %s did not create a %s for request %s", s.settings.URL, s.object(), request.ServiceRequestID)
+	}
+	return result.ID, nil
+}
+
+func (s *salesforce) Update(id string, request repository.Request) error {
+	record := map[string]string{
+		"Subject":     title(request),
+		"Description": details(request),
+	}
+	if status, ok := s.settings.ExternalStatus(request.Status); ok {
+		record["Status"] = status
+	}
+	return s.call("PATCH", "/"+url.PathEscape(id), record, nil)
+}
+
+func (s *salesforce) Status(id string) (string, error) {
+	var result struct {
+		Status string `json:"Status"`
+	}
+	if err := s.call("GET", "/"+url.PathEscape(id)+"?fields=Status", nil, &result); err != nil {
+		return "", err
+	}
+	return result.Status, nil
+}
+
+// signIn gets an access token with the client credentials flow
+func (s *salesforce) signIn() error {
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {s.credentials.ClientID},
+		"client_secret": {s.credentials.ClientSecret},
+	}
+	req, err := http.NewRequest("POST", base(s.settings)+"/services/oauth2/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("workorder: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		InstanceURL string `json:"instance_url"`
+	}
+	if err := do(req, &result); err != nil {
+		return err
+	}
+	if result.AccessToken == "" {
+		return fmt.Errorf("workorder: %s returned no access token", s.settings.URL)
+	}
+
+	s.token = result.AccessToken
+	s.instance = strings.TrimSuffix(result.InstanceURL, "/")
+	if s.instance == "" {
+		s.instance = base(s.settings)
+	}
+	return nil
+}
+
+// object returns the object work orders are records of
+func (s *salesforce) object() string {
+	if s.settings.Object != "" {
+		return s.settings.Object
+	}
+	return salesforceObject
+}
+
+// call sends a call about a record to the sobjects API, signing in first if need be
+func (s *salesforce) call(method string, path string, body interface{}, result interface{}) error {
+	if s.token == "" {
+		if err := s.signIn(); err != nil {
+			return err
+		}
+	}
+
+	req, err := jsonRequest(method, s.instance+"/services/data/"+salesforceVersion+"/sobjects/"+url.PathEscape(s.object())+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	return do(req, result)
+}
@@ -0,0 +1,86 @@
+package workorder
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+// serviceNowTable is the table work orders are records of unless the city names another, eg wm_order
+const serviceNowTable = "incident"
+
+// serviceNow connects to ServiceNow's Table API with basic auth.  The request ID is kept as each record's
+// correlation_id, and the work-order type as its category.
+type serviceNow struct {
+	settings    repository.WorkOrderSettings
+	credentials Credentials
+}
+
+type serviceNowResult struct {
+	Result struct {
+		SysID string `json:"sys_id"`
+		State string `json:"state"`
+	} `json:"result"`
+}
+
+func (s *serviceNow) Create(request repository.Request) (string, error) {
+	record := map[string]string{
+		"short_description":   title(request),
+		"description":         details(request),
+		"correlation_id":      request.ServiceRequestID,
+		"correlation_display": "Open311",
+	}
+	if category := s.settings.Types[request.ServiceCode]; category != "" {
+		record["category"] = category
+	}
+	if state, ok := s.settings.ExternalStatus(request.Status); ok {
+		record["state"] = state
+	}
+
+	var result serviceNowResult
+	if err := s.call("POST", s.table(), record, &result); err != nil {
+		return "", err
+	}
+	if result.Result.SysID == "" {
+		return "", fmt.Errorf("workorder: %s returned no sys_id for request %s", s.settings.URL, request.ServiceRequestID)
+	}
+	return result.Result.SysID, nil
+}
+
+func (s *serviceNow) Update(id string, request repository.Request) error {
+	record := map[string]string{
+		"short_description": title(request),
+		"description":       details(request),
+	}
+	if state, ok := s.settings.ExternalStatus(request.Status); ok {
+		record["state"] = state
+	}
+	return s.call("PATCH", s.table()+"/"+url.PathEscape(id), record, nil)
+}
+
+func (s *serviceNow) Status(id string) (string, error) {
+	var result serviceNowResult
+	if err := s.call("GET", s.table()+"/"+url.PathEscape(id)+"?sysparm_fields=sys_id,state", nil, &result); err != nil {
+		return "", err
+	}
+	return result.Result.State, nil
+}
+
+// table returns the URL of the table work orders are records of
+func (s *serviceNow) table() string {
+	table := s.settings.Object
+	if table == "" {
+		table = serviceNowTable
+	}
+	return base(s.settings) + "/api/now/table/" + url.PathEscape(table)
+}
+
+func (s *serviceNow) call(method string, u string, body interface{}, result interface{}) error {
+	req, err := jsonRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.credentials.Username, s.credentials.Password)
+	return do(req, result)
+}
@@ -0,0 +1,151 @@
+// Package workorder syncs requests with the work-order systems cities' crews work from: ServiceNow, Salesforce and
+// Cityworks.  Each system is reached through a Connector, which creates a work order from a request, pushes changes
+// of the request to it, and reads back the status crews give it.
+package workorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/social-torch/open311-services/awsclient"
+	"github.com/social-torch/open311-services/repository"
+)
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// maxResponseSize bounds how much of a system's response is read
+const maxResponseSize = 1 << 20
+
+// Connector is a city's work-order system
+type Connector interface {
+	// Create makes a work order of a request, returning its ID in the system
+	Create(request repository.Request) (string, error)
+	// Update pushes the status and details of a request to its work order
+	Update(id string, request repository.Request) error
+	// Status returns the status of a work order, as the system names it
+	Status(id string) (string, error)
+}
+
+// Credentials authenticate a connector.  They are kept in Secrets Manager as JSON, eg
+// {"username": "open311", "password": "..."}.  ServiceNow and Cityworks sign in with a username and password,
+// and Salesforce with the client ID and secret of a connected app using the client credentials flow.
+type Credentials struct {
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+// GetCredentials reads a connector's credentials from Secrets Manager
+func GetCredentials(secretID string) (Credentials, error) {
+	svc := secretsmanager.New(awsclient.Session())
+	output, err := svc.GetSecretValue(&secretsmanager.GetSecretValueInput{SecretId: aws.String(secretID)})
+	if err != nil {
+		return Credentials{}, fmt.Errorf("workorder: unable to read secret %s: %s", secretID, err)
+	}
+
+	var credentials Credentials
+	if err := json.Unmarshal([]byte(aws.StringValue(output.SecretString)), &credentials); err != nil {
+		return Credentials{}, fmt.Errorf("workorder: secret %s is not credentials JSON: %s", secretID, err)
+	}
+	return credentials, nil
+}
+
+// New returns the connector of a city's work-order settings
+func New(settings repository.WorkOrderSettings, credentials Credentials) (Connector, error) {
+	switch settings.Connector {
+	case repository.ConnectorServiceNow:
+		return &serviceNow{settings: settings, credentials: credentials}, nil
+	case repository.ConnectorSalesforce:
+		return &salesforce{settings: settings, credentials: credentials}, nil
+	case repository.ConnectorCityworks:
+		return &cityworks{settings: settings, credentials: credentials}, nil
+	}
+	return nil, fmt.Errorf("workorder: unknown connector '%s'", settings.Connector)
+}
+
+// title names a request's work order, eg "Pothole at 123 Main St"
+func title(request repository.Request) string {
+	name := request.ServiceName
+	if name == "" {
+		name = request.ServiceCode
+	}
+	if request.Address != "" {
+		return name + " at " + request.Address
+	}
+	return name
+}
+
+// details describes a request for the crews working its work order
+func details(request repository.Request) string {
+	var b strings.Builder
+	b.WriteString(request.Description)
+	if request.Address != "" {
+		fmt.Fprintf(&b, "\n\nAddress: %s", request.Address)
+	}
+	if request.HasLocation() {
+		lat, lon := request.Coordinates()
+		fmt.Fprintf(&b, "\nLocation: %f, %f", lat, lon)
+	}
+	fmt.Fprintf(&b, "\nOpen311 request: %s", request.ServiceRequestID)
+	return strings.TrimSpace(b.String())
+}
+
+// base returns a system's URL without a trailing slash
+func base(settings repository.WorkOrderSettings) string {
+	return strings.TrimSuffix(settings.URL, "/")
+}
+
+// do sends a call to a work-order system, decoding the JSON body of a successful response into result, if any
+func do(req *http.Request, result interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("workorder: unable to reach %s: %s", req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return fmt.Errorf("workorder: unable to read response of %s: %s", req.URL.Host, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("workorder: %s responded %s: %s", req.URL.Host, resp.Status, bytes.TrimSpace(body))
+	}
+	if result == nil || len(body) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("workorder: unreadable response of %s: %s", req.URL.Host, err)
+	}
+	return nil
+}
+
+// jsonRequest returns a call with a JSON body
+func jsonRequest(method string, url string, body interface{}) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("workorder: error marshalling work order: %s", err)
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, fmt.Errorf("workorder: %s", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
@@ -0,0 +1,189 @@
+package workorder
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/social-torch/open311-services/repository"
+)
+
+func settings(connector string, url string) repository.WorkOrderSettings {
+	return repository.WorkOrderSettings{
+		Connector: connector,
+		URL:       url + "/",
+		Types:     map[string]string{"pothole": "42"},
+		Statuses:  map[string]string{"1": repository.RequestOpen, "2": repository.RequestInProgress, "7": repository.RequestClosed},
+	}
+}
+
+var request = repository.Request{
+	ServiceRequestID: "abc",
+	ServiceCode:      "pothole",
+	ServiceName:      "Pothole",
+	Description:      "Deep one",
+	Address:          "123 Main St",
+	Status:           repository.RequestOpen,
+}
+
+func TestServiceNow(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "open311" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/api/now/table/incident":
+			var record map[string]string
+			json.NewDecoder(r.Body).Decode(&record)
+			if record["correlation_id"] != "abc" || record["category"] != "42" || record["state"] != "1" || record["short_description"] != "Pothole at 123 Main St" {
+				t.Errorf("created record = %v", record)
+			}
+			w.Write([]byte(`{"result": {"sys_id": "sys1", "state": "1"}}`))
+		case r.Method == "PATCH" && r.URL.Path == "/api/now/table/incident/sys1":
+			var record map[string]string
+			json.NewDecoder(r.Body).Decode(&record)
+			if record["state"] != "7" {
+				t.Errorf("updated state = %s, want 7", record["state"])
+			}
+			w.Write([]byte(`{"result": {"sys_id": "sys1", "state": "7"}}`))
+		case r.Method == "GET" && r.URL.Path == "/api/now/table/incident/sys1":
+			w.Write([]byte(`{"result": {"sys_id": "sys1", "state": "2"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c, err := New(settings(repository.ConnectorServiceNow, server.URL), Credentials{Username: "open311", Password: "secret"})
+	if err != nil {
+		t.Fatalf("New() = %s", err)
+	}
+	testConnector(t, c, "sys1", "2")
+
+	c, _ = New(settings(repository.ConnectorServiceNow, server.URL), Credentials{Username: "open311", Password: "wrong"})
+	if _, err := c.Create(request); err == nil {
+		t.Error("Create() with wrong credentials should set an error")
+	}
+}
+
+func TestSalesforce(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/services/oauth2/token" {
+			if r.FormValue("grant_type") != "client_credentials" || r.FormValue("client_id") != "id" || r.FormValue("client_secret") != "secret" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"access_token": "token", "instance_url": "` + server.URL + `"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/services/data/v58.0/sobjects/Case":
+			var record map[string]string
+			json.NewDecoder(r.Body).Decode(&record)
+			if record["Origin"] != "Open311" || record["Type"] != "42" || record["Status"] != "1" {
+				t.Errorf("created record = %v", record)
+			}
+			w.Write([]byte(`{"id": "500x", "success": true, "errors": []}`))
+		case r.Method == "PATCH" && r.URL.Path == "/services/data/v58.0/sobjects/Case/500x":
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == "GET" && r.URL.Path == "/services/data/v58.0/sobjects/Case/500x":
+			w.Write([]byte(`{"Id": "500x", "Status": "2"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c, err := New(settings(repository.ConnectorSalesforce, server.URL), Credentials{ClientID: "id", ClientSecret: "secret"})
+	if err != nil {
+		t.Fatalf("New() = %s", err)
+	}
+	testConnector(t, c, "500x", "2")
+}
+
+func TestCityworks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data map[string]interface{}
+		json.Unmarshal([]byte(r.FormValue("data")), &data)
+		if r.URL.Path == "/Services/General/Authentication/Authenticate" {
+			if data["LoginName"] != "open311" || data["Password"] != "secret" {
+				w.Write([]byte(`{"Status": 1, "Message": "Invalid login"}`))
+				return
+			}
+			w.Write([]byte(`{"Status": 0, "Value": {"Token": "token"}}`))
+			return
+		}
+		if r.FormValue("token") != "token" {
+			w.Write([]byte(`{"Status": 2, "Message": "Unauthorized"}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/Services/Ams/WorkOrder/Create":
+			if data["WOTemplateId"] != "42" || data["Text1"] != "abc" {
+				t.Errorf("created work order = %v", data)
+			}
+			w.Write([]byte(`{"Status": 0, "Value": [{"WorkOrderId": "1001", "Status": "1"}]}`))
+		case "/Services/Ams/WorkOrder/Update":
+			if data["WorkOrderId"] != "1001" || data["Status"] != "7" {
+				t.Errorf("updated work order = %v", data)
+			}
+			w.Write([]byte(`{"Status": 0, "Value": {}}`))
+		case "/Services/Ams/WorkOrder/ById":
+			w.Write([]byte(`{"Status": 0, "Value": {"WorkOrderId": "1001", "Status": "2"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c, err := New(settings(repository.ConnectorCityworks, server.URL), Credentials{Username: "open311", Password: "secret"})
+	if err != nil {
+		t.Fatalf("New() = %s", err)
+	}
+	testConnector(t, c, "1001", "2")
+
+	other := request
+	other.ServiceCode = "graffiti"
+	if _, err := c.Create(other); err == nil {
+		t.Error("Create() of a service without a template should set an error")
+	}
+
+	c, _ = New(settings(repository.ConnectorCityworks, server.URL), Credentials{Username: "open311", Password: "wrong"})
+	if _, err := c.Status("1001"); err == nil {
+		t.Error("Status() with wrong credentials should set an error")
+	}
+}
+
+// testConnector creates, closes and reads the status of a work order of request
+func testConnector(t *testing.T, c Connector, wantID string, wantStatus string) {
+	t.Helper()
+
+	id, err := c.Create(request)
+	if err != nil || id != wantID {
+		t.Errorf("Create() = %s, %v, want %s", id, err, wantID)
+	}
+
+	closed := request
+	closed.Status = repository.RequestClosed
+	if err := c.Update(id, closed); err != nil {
+		t.Errorf("Update() = %s, want nil", err)
+	}
+
+	status, err := c.Status(id)
+	if err != nil || status != wantStatus {
+		t.Errorf("Status() = %s, %v, want %s", status, err, wantStatus)
+	}
+}
+
+func TestNewUnknownConnector(t *testing.T) {
+	if _, err := New(repository.WorkOrderSettings{Connector: "maximo"}, Credentials{}); err == nil {
+		t.Error("New() of an unknown connector should set an error")
+	}
+}